@@ -19,13 +19,73 @@ SHELL INTEGRATION:
 COMMANDS:
 
 	enva                Launch interactive TUI (default)
+	enva tui --path DIR Launch the TUI for another directory
 	enva hook <shell>   Print shell hook code (bash, zsh, fish)
+	enva hook --check   Check the hook is installed and active (--install to fix)
 	enva export         Print export/unset lines for current directory
+	                    (--prefix TF_VAR_ and friends rename keys for a tool)
 	enva set KEY=VALUE  Set a variable at current directory scope
+	enva set KEY=CMD --eval  Export CMD's output instead of a fixed value
+	enva set KEY --generate secret:32  Store a random secret, UUID or timestamp
+	enva set --global KEY=VALUE  Set a default that applies everywhere
+	enva set --host NAME KEY=VALUE  Set a var that only applies on one machine
+	enva refresh        Rerun --eval commands now (--watch to keep them fresh)
+	enva watch          Notify when watched keys change (see "notify" in config)
+	enva capture KEY... Save variables from your shell's environment here
+	enva lint           Check *_URL, *_HOST and *_PORT values make sense
+	enva graph          Draw the project's scopes and overrides (DOT or Mermaid)
+	enva check          Report missing required keys and .env drift here
+	enva check-profiles Report keys set in default but missing from other profiles
+	enva env-file watch Keep a .env file and this scope in sync both ways
 	enva unset KEY      Remove a variable from current directory scope
-	enva ls             List effective environment variables (sorted)
+	enva batch -f FILE  Apply set/unset lines from FILE (- for stdin) atomically
+	enva sql            Query the database read-only (prompt, or --query SQL)
+	enva trash          List, restore or purge deleted variables
+	enva archive DIR    Save a retired project's scopes to a file and remove
+	                    them from the database (enva unarchive FILE restores)
+	enva mv KEY         Move or copy a variable to another scope or profile
+	enva ls             List effective environment variables (sorted by key,
+	                    or --sort updated|created to show stale ones first)
+	enva search QUERY   Search effective variables by key and value
+	enva cat KEY        Write a variable's raw value to stdout (alias get;
+	                    --jq PATH for a field of a JSON value)
 	enva edit           Open $EDITOR to edit local vars for current directory
+	                    (--scope-root: every level from the root, as TOML)
+	enva import [FILE]  Set vars from a .env file here (--strip-prefix, --prefix
+	                    and --map OLD=NEW rename keys on the way in), or
+	                    from a pass/gopass folder with --pass PREFIX
 	enva run -- CMD     Run command with effective env merged into current env
+	                    (--prompt-missing asks for required keys first,
+	                    --output-ref also writes them to $ENVA_ENV_FILE)
+	enva run NAME       Run a task defined with enva task (--list to list them)
+	enva task N=CMD     Define a named command for the current directory
+	enva shell          Start $SHELL with the effective env and a prompt marker
+	enva apply -f FILE  Apply a JSON change document transactionally
+	                    (--patch FILE for a patch from enva diff --json)
+	enva diff [REMOTE]  List what differs from a sync remote (--json: a patch)
+	enva policy         Show or set key naming policy for current directory
+	enva which          Show active root, profile, database and hook state
+	enva clear KEY      Force-unset KEY when entering current directory
+	enva alias N=CMD    Define a shell alias for the current directory
+	enva trust [DIR]    Let DIR's scopes export LD_PRELOAD, PATH and the like
+	                    and run --eval commands
+	enva protect PROF   Require a passphrase to open or export a profile
+	enva unlock [PROF]  Enter a protected profile's passphrase for a while
+	enva lock [PROF]    Lock a protected profile again
+	enva tag KEY TAG    Tag a variable; filter with ls/export --tag
+	enva report         Summarize the opt-in local usage log
+	enva sync push/pull Sync the database with a file, WebDAV, S3 or git remote
+	enva gui-env apply  Set effective vars for macOS GUI apps via launchctl
+	enva direnv stdlib  Print a direnv library defining "use enva"
+	enva template save NAME   Save this scope as a template for new projects
+	enva template apply NAME  Fill in a template's placeholders here
+
+EXIT CODES:
+
+	0 success, 1 other errors, 2 not found, 3 invalid input or policy
+	violation, 4 database locked, 5 keys held back from an untrusted scope,
+	6 export --diff or check found differences, 7 lint found problems,
+	8 a protected profile is locked
 
 ROOT BOUNDARY DISCOVERY:
  1. Walk up from cwd looking for .enva marker file (closest wins)
@@ -34,36 +94,175 @@ ROOT BOUNDARY DISCOVERY:
 
 PROFILE SUPPORT:
 
-	Set ENVA_PROFILE environment variable to use a different profile.
+	Set ENVA_PROFILE environment variable, or pass --profile to any
+	command, to use a different profile.
 	Default profile is "default".
 
 DATABASE LOCATION:
 
-	~/.local/share/enva/enva.db
+	$ENVA_DATA_DIR/enva.db if set, else $XDG_DATA_HOME/enva/enva.db,
+	else the platform default:
+	  Linux:   ~/.local/share/enva/enva.db
+	  macOS:   ~/Library/Application Support/enva/enva.db
+	  Windows: %LocalAppData%\enva\enva.db
+
+	A database at the old ~/.local/share location is moved automatically.
+
+	Override with the --db flag, the ENVA_DB environment variable or
+	"db" in the config file (in that order), e.g. to keep separate work
+	and personal databases.
+
+	Besides a SQLite file, the location can select another backend:
+	  json:PATH   a single JSON file, rewritten on every change
+	  memory:     in memory only, gone when enva exits (for tests)
 */
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/nick-skriabin/enva/internal/apply"
+	"github.com/nick-skriabin/enva/internal/archive"
+	"github.com/nick-skriabin/enva/internal/cache"
+	"github.com/nick-skriabin/enva/internal/config"
 	"github.com/nick-skriabin/enva/internal/db"
+	"github.com/nick-skriabin/enva/internal/dbsync"
+	"github.com/nick-skriabin/enva/internal/dynamic"
 	"github.com/nick-skriabin/enva/internal/env"
+	"github.com/nick-skriabin/enva/internal/generate"
+	"github.com/nick-skriabin/enva/internal/graph"
+	"github.com/nick-skriabin/enva/internal/lint"
+	"github.com/nick-skriabin/enva/internal/manifest"
+	"github.com/nick-skriabin/enva/internal/merge"
+	"github.com/nick-skriabin/enva/internal/notify"
+	"github.com/nick-skriabin/enva/internal/pass"
 	envpath "github.com/nick-skriabin/enva/internal/path"
+	"github.com/nick-skriabin/enva/internal/policy"
+	"github.com/nick-skriabin/enva/internal/profilecheck"
+	"github.com/nick-skriabin/enva/internal/progress"
+	"github.com/nick-skriabin/enva/internal/protect"
+	"github.com/nick-skriabin/enva/internal/provider"
+	"github.com/nick-skriabin/enva/internal/redact"
+	"github.com/nick-skriabin/enva/internal/scopedoc"
+	"github.com/nick-skriabin/enva/internal/search"
+	"github.com/nick-skriabin/enva/internal/secrets"
 	"github.com/nick-skriabin/enva/internal/shell"
+	"github.com/nick-skriabin/enva/internal/templates"
+	"github.com/nick-skriabin/enva/internal/trust"
 	"github.com/nick-skriabin/enva/internal/tui"
+	"github.com/nick-skriabin/enva/internal/usage"
+	"github.com/nick-skriabin/enva/internal/valuetype"
 )
 
 func main() {
+	envpath.OnMissing = func(abs string) {
+		fmt.Fprintf(os.Stderr, "enva: warning: %s no longer exists; using the path as-is\n", abs)
+	}
+	if cfg, err := config.Load(); err == nil {
+		configureRoot(cfg)
+	}
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+	if exitStatus != 0 {
+		os.Exit(exitStatus)
+	}
+}
+
+// Exit codes, so scripts and hooks can branch on why a command failed
+// instead of parsing its message.
+const (
+	exitFailure   = 1 // Any other error
+	exitNotFound  = 2 // A variable, directory or file that doesn't exist
+	exitInvalid   = 3 // Bad arguments or flags, an invalid key or a policy violation
+	exitLocked    = 4 // The database is locked by another process
+	exitUntrusted = 5 // Keys from an untrusted scope were held back (see enva trust)
+	exitDrift     = 6 // export --diff, check or check-profiles found differences
+	exitLint      = 7 // lint found problems
+	exitProtected = 8 // A protected profile is locked (see enva unlock)
+)
+
+// exitStatus is the exit code for a command that succeeded with a caveat,
+// such as export holding keys back.
+var exitStatus int
+
+// codedError carries the exit code for an error.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// invalidf formats an error for bad input, exiting with exitInvalid.
+func invalidf(format string, args ...any) error {
+	return &codedError{exitInvalid, fmt.Errorf(format, args...)}
+}
+
+// notFoundf formats an error for something missing, exiting with exitNotFound.
+func notFoundf(format string, args ...any) error {
+	return &codedError{exitNotFound, fmt.Errorf(format, args...)}
+}
+
+// exitCode picks the exit code for an error a command returned.
+func exitCode(err error) int {
+	var coded *codedError
+	var violation *policy.Violation
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case db.IsLocked(err):
+		return exitLocked
+	case errors.Is(err, db.ErrVarNotFound), errors.Is(err, fs.ErrNotExist):
+		return exitNotFound
+	case errors.As(err, &violation):
+		return exitInvalid
+	}
+	return exitFailure
+}
+
+// invalidArgs wraps every command's argument check, and flag parsing, so
+// usage mistakes exit with exitInvalid.
+func invalidArgs(cmd *cobra.Command) {
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &codedError{exitInvalid, err}
+	})
+	if check := cmd.Args; check != nil {
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			if err := check(cmd, args); err != nil {
+				return &codedError{exitInvalid, err}
+			}
+			return nil
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		invalidArgs(sub)
 	}
 }
 
@@ -87,41 +286,430 @@ variables when changing directories. Use 'enva hook <shell>' to set up.`,
 			return fmt.Errorf("failed to get cwd: %w", err)
 		}
 
-		return tui.Run(database, resolver, cwd)
+		// Stderr warnings would corrupt the TUI screen
+		envpath.OnMissing = nil
+
+		return tui.Run(database, resolver, cwd, tuiOptions())
 	},
 }
 
 func init() {
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		usageStart = time.Now()
+	}
+	rootCmd.PersistentPostRun = func(cmd *cobra.Command, args []string) {
+		logUsage(cmd)
+	}
+
+	rootCmd.PersistentFlags().StringVar(&dbFlag, "db", "", "Database path or URL: PATH, json:PATH or memory: (overrides ENVA_DB and the config)")
+	rootCmd.PersistentFlags().StringVarP(&profileFlag, "profile", "p", "", "Profile to use (overrides ENVA_PROFILE)")
+
 	rootCmd.AddCommand(hookCmd)
 	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(setCmd)
 	rootCmd.AddCommand(unsetCmd)
+	rootCmd.AddCommand(batchCmd)
+	rootCmd.AddCommand(sqlCmd)
 	rootCmd.AddCommand(lsCmd)
 	rootCmd.AddCommand(editCmd)
+	rootCmd.AddCommand(importCmd)
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(tuiCmd)
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(policyCmd)
+	rootCmd.AddCommand(whichCmd)
+	rootCmd.AddCommand(clearCmd)
+	rootCmd.AddCommand(aliasCmd)
+	rootCmd.AddCommand(taskCmd)
+	rootCmd.AddCommand(reportCmd)
+	rootCmd.AddCommand(catCmd)
+	rootCmd.AddCommand(tagCmd)
+	rootCmd.AddCommand(mvCmd)
+	rootCmd.AddCommand(syncCmd)
+	syncCmd.AddCommand(syncPushCmd)
+	syncCmd.AddCommand(syncPullCmd)
+	rootCmd.AddCommand(guiEnvCmd)
+	guiEnvCmd.AddCommand(guiEnvApplyCmd)
+	rootCmd.AddCommand(direnvCmd)
+	rootCmd.AddCommand(trustCmd)
+	rootCmd.AddCommand(protectCmd)
+	rootCmd.AddCommand(unlockCmd)
+	rootCmd.AddCommand(lockCmd)
+	rootCmd.AddCommand(refreshCmd)
+	rootCmd.AddCommand(templateCmd)
+	rootCmd.AddCommand(shellCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(trashCmd)
+	rootCmd.AddCommand(archiveCmd)
+	rootCmd.AddCommand(unarchiveCmd)
+	rootCmd.AddCommand(captureCmd)
+	rootCmd.AddCommand(lintCmd)
+	rootCmd.AddCommand(graphCmd)
+	rootCmd.AddCommand(searchCmd)
+	rootCmd.AddCommand(checkCmd)
+	rootCmd.AddCommand(checkProfilesCmd)
+	rootCmd.AddCommand(envFileCmd)
+	envFileCmd.AddCommand(envFileWatchCmd)
+	trashCmd.AddCommand(trashListCmd)
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashCmd.AddCommand(trashPurgeCmd)
+	templateCmd.AddCommand(templateSaveCmd)
+	templateCmd.AddCommand(templateApplyCmd)
+	templateCmd.AddCommand(templateListCmd)
+	templateCmd.AddCommand(templateRmCmd)
+	direnvCmd.AddCommand(direnvStdlibCmd)
+	policyCmd.AddCommand(policySetCmd)
+	policyCmd.AddCommand(policyClearCmd)
 
 	exportCmd.Flags().BoolVar(&exportInternal, "internal", false, "Include internal tracking variables (for shell hooks)")
+	exportCmd.Flags().BoolVar(&exportDotenv, "dotenv", false, "Print KEY=value lines for a .env file")
+	exportCmd.Flags().BoolVar(&exportJSON, "json", false, "Print the vars as a JSON array, with where and when each was set")
+	exportCmd.Flags().StringVar(&exportFormat, "format", "", "Output format: shell, dotenv, launchctl or json")
+	exportCmd.Flags().StringVar(&exportComments, "comments", "", "Write descriptions as comments: trailing, preceding or none")
+	exportCmd.Flags().Lookup("comments").NoOptDefVal = "trailing"
+	exportCmd.Flags().StringSliceVar(&exportTags, "tag", nil, "Only export vars with any of these tags (repeatable)")
+	exportCmd.Flags().StringVar(&exportSince, "since", "", "Only export vars changed since this age (7d, 36h) or date (2026-01-31)")
+	exportCmd.Flags().StringVar(&exportUntil, "until", "", "Only export vars last changed before this age or date")
+	exportCmd.Flags().StringVar(&exportPrefix, "prefix", "", "Export every key with this prefix, e.g. TF_VAR_ or VITE_")
+	exportCmd.Flags().StringVar(&exportStripPrefix, "strip-prefix", "", "Export keys that have this prefix without it")
+	exportCmd.Flags().BoolVar(&exportLower, "lower", false, "Export keys in lowercase, before --prefix (Terraform variables)")
+	exportCmd.Flags().StringArrayVar(&exportMap, "map", nil, "Export key OLD as NEW, instead of prefixing it (repeatable)")
+	exportCmd.Flags().StringVar(&exportDiff, "diff", "", "List differences from a reference .env file instead of exporting")
+	exportCmd.Flags().StringVar(&exportOutputRef, "output-ref", "", "Write the vars to a private .env file and print only a line setting this var to its path")
+	exportCmd.Flags().Lookup("output-ref").NoOptDefVal = defaultEnvFileVar
+	exportCmd.Flags().BoolVar(&exportAsync, "async", false, "Answer from the cache and refresh it in the background")
+	exportCmd.Flags().BoolVar(&exportRefreshCache, "refresh-cache", false, "Resolve and rewrite the cache entry for the current directory")
+	exportCmd.Flags().MarkHidden("refresh-cache")
+	exportCmd.Flags().IntVar(&exportShellPID, "shell-pid", 0, "PID of the calling shell, to spot state inherited from a parent")
+	exportCmd.Flags().MarkHidden("shell-pid")
+	exportCmd.Flags().StringVar(&exportShell, "shell", "", "Shell the output is for, where its syntax differs (fish)")
+	exportCmd.Flags().MarkHidden("shell")
+
+	hookCmd.Flags().BoolVar(&hookCheck, "check", false, "Check that the hook is installed and active")
+	hookCmd.Flags().BoolVar(&hookOnCd, "on-cd", false, "Only run export when the directory changes (bash, zsh)")
+	hookCmd.Flags().BoolVar(&hookInstall, "install", false, "With --check, add or repair the hook in the shell config")
+
+	tuiCmd.Flags().StringVar(&tuiPath, "path", "", "Directory to open instead of the current one")
+	tuiCmd.Flags().BoolVar(&tuiRenderOnce, "render-once", false, "Print a single frame to stdout and exit")
+	tuiCmd.Flags().BoolVar(&tuiKeys, "keys", false, "List the keybindings, with \"keys\" from the config applied, and check them")
+	tuiCmd.Flags().IntVar(&tuiWidth, "width", 100, "Frame width for --render-once")
+	tuiCmd.Flags().IntVar(&tuiHeight, "height", 30, "Frame height for --render-once")
+
+	setCmd.Flags().StringVar(&setFromFile, "from-file", "", "Read the value verbatim from a file (- for stdin)")
+	setCmd.Flags().StringVar(&setGenerate, "generate", "", "Generate the value: secret[:BYTES], hex[:BYTES], base64[:BYTES], uuid or timestamp[:unix|unixms]")
+	setCmd.Flags().BoolVar(&setIfUnset, "if-unset", false, "Only apply when the key isn't already set in the environment")
+	setCmd.Flags().BoolVar(&setEval, "eval", false, "Treat the value as a shell command whose output is exported")
+	setCmd.Flags().StringVar(&setPass, "pass", "", "Resolve the value from this pass/gopass entry whenever it's exported")
+	setCmd.Flags().StringVar(&setType, "type", "", "Declare the value's type, checked on every set and edit: string, json, int or bool")
+	setCmd.Flags().StringVar(&setFallback, "fallback", "", "What an --eval var exports when its command fails: fail, cache[:MAXAGE] or default:VALUE")
+	setCmd.Flags().StringVar(&setRef, "ref", "", "Resolve the value from a secret manager reference (aws-sm://, aws-ssm://, gcp-sm://, vault://, pass://) whenever it's exported")
+	setCmd.Flags().BoolVar(&setNoExport, "no-export", false, "Keep the var out of export, run and the shell hook; enva cat still reads it")
+	setCmd.Flags().DurationVar(&setRefresh, "refresh", 0, "How long an --eval output is reused, e.g. 15m (0 for the default)")
+	setCmd.Flags().BoolVar(&setGlobal, "global", false, "Set at the global scope, which applies everywhere below every project")
+	setCmd.Flags().StringVar(&setHost, "host", "", "Only apply on this host (see enva which), for databases synced between machines")
+	unsetCmd.Flags().BoolVar(&unsetGlobal, "global", false, "Remove from the global scope")
+	unsetCmd.Flags().StringVar(&unsetHost, "host", "", "Remove the var limited to this host")
+	batchCmd.Flags().StringVarP(&batchFile, "file", "f", "-", "Read commands from this file (- for stdin)")
+	sqlCmd.Flags().StringVarP(&sqlQuery, "query", "q", "", "Run this statement and exit instead of prompting")
+	sqlCmd.Flags().BoolVar(&sqlJSON, "json", false, "Print rows as a JSON array of objects")
+
+	mvCmd.Flags().StringVar(&mvToPath, "to-path", "", "Destination directory (default: the scope that defines KEY)")
+	mvCmd.Flags().StringVar(&mvToProfile, "to-profile", "", "Destination profile (default: the active profile)")
+	mvCmd.Flags().BoolVar(&mvCopy, "copy", false, "Copy instead of move")
+	mvCmd.Flags().BoolVar(&mvForce, "force", false, "Replace KEY if it exists at the destination")
+
+	tagCmd.Flags().BoolVar(&tagRemove, "remove", false, "Remove the given tags")
+
+	clearCmd.Flags().BoolVar(&clearRemove, "remove", false, "Stop force-unsetting the given keys")
+	aliasCmd.Flags().BoolVar(&aliasRemove, "remove", false, "Remove the given aliases")
+	taskCmd.Flags().BoolVar(&taskRemove, "remove", false, "Remove the given tasks")
+	trustCmd.Flags().BoolVar(&trustRemove, "remove", false, "Stop trusting the directory")
+	trustCmd.Flags().BoolVar(&trustList, "list", false, "List trusted directories")
+	protectCmd.Flags().BoolVar(&protectRemove, "remove", false, "Stop protecting the profile")
+	protectCmd.Flags().BoolVar(&protectList, "list", false, "List protected profiles")
+	lockCmd.Flags().BoolVar(&lockAll, "all", false, "Lock every protected profile")
+
+	editCmd.Flags().BoolVar(&editScopeRoot, "scope-root", false, "Edit every directory from the project root to here as one TOML document")
+	catCmd.Flags().BoolVar(&catNewline, "newline", false, "Append a trailing newline")
+	catCmd.Flags().StringVar(&catJQ, "jq", "", "Print the field at this jq-style path of a JSON value")
+
+	refreshCmd.Flags().BoolVar(&refreshWatch, "watch", false, "Keep running and renew each output before it expires")
+
+	captureCmd.Flags().StringSliceVar(&captureFilters, "filter", nil, "Capture every variable matching GLOB, e.g. 'AWS_*' (repeatable)")
+	captureCmd.Flags().BoolVar(&captureDryRun, "dry-run", false, "Show what would be captured without saving it")
+	lintCmd.Flags().BoolVar(&lintAllScopes, "all-scopes", false, "Check every scope in the profile, not just those in effect here")
+	searchCmd.Flags().BoolVar(&searchExact, "exact", false, "Match QUERY as a substring instead of fuzzily")
+	searchCmd.Flags().BoolVar(&searchCaseSensitive, "case-sensitive", false, "Match letters in QUERY's case only")
+	searchCmd.Flags().BoolVar(&searchKeysOnly, "keys-only", false, "Match keys only, not values")
+	searchCmd.Flags().IntVar(&searchMinScore, "min-score", 0, "Drop fuzzy matches scoring below N (0 keeps all)")
+	checkProfilesCmd.Flags().StringVar(&checkProfilesBase, "base", env.DefaultProfile, "Profile the others must match")
+	graphCmd.Flags().StringVar(&graphFormat, "format", "dot", "Output format: dot or mermaid")
+	envFileWatchCmd.Flags().DurationVar(&envFileInterval, "interval", 2*time.Second, "How often to check the file and the database")
+	envFileWatchCmd.Flags().StringVar(&envFilePrefer, "prefer", "enva", "Which side wins when both changed a key: enva or file")
+	envFileWatchCmd.Flags().BoolVar(&envFileOnce, "once", false, "Sync once and exit")
+
+	trashRestoreCmd.Flags().BoolVar(&trashForce, "force", false, "Replace a var that has been set again since")
+	trashPurgeCmd.Flags().DurationVar(&trashOlderThan, "older-than", 0, "Only purge vars deleted longer ago than this")
+
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", 10*time.Second, "How often to check for changes")
+
+	templateSaveCmd.Flags().StringSliceVar(&templatePlaceholders, "placeholder", nil, "Save KEY's value as a {{KEY}} placeholder (repeatable)")
+	templateSaveCmd.Flags().BoolVar(&templateForce, "force", false, "Replace an existing template")
+	importCmd.Flags().StringVar(&importPrefix, "prefix", "", "Add this prefix to every imported key")
+	importCmd.Flags().StringVar(&importStripPrefix, "strip-prefix", "", "Remove this prefix from keys that have it")
+	importCmd.Flags().StringArrayVar(&importMap, "map", nil, "Import key OLD as NEW, instead of prefixing it (repeatable)")
+	importCmd.Flags().StringVar(&importPass, "pass", "", "Import the entries below this pass/gopass folder instead of a file")
+	importCmd.Flags().BoolVar(&importRef, "ref", false, "With --pass, store references resolved at export time instead of the values")
+	templateApplyCmd.Flags().StringArrayVar(&templateValues, "set", nil, "Fill placeholder NAME=VALUE instead of prompting (repeatable)")
+	templateApplyCmd.Flags().BoolVar(&templateForce, "force", false, "Replace vars and aliases that already exist here")
+
+	syncPushCmd.Flags().BoolVar(&syncForce, "force", false, "Overwrite the remote even if it has changes you haven't pulled")
+	syncPullCmd.Flags().StringVar(&syncPrefer, "prefer", "", "Resolve conflicts: mine, theirs or newer")
+	syncPullCmd.Flags().BoolVar(&syncDryRun, "dry-run", false, "Show what would change without changing anything")
+
+	guiEnvApplyCmd.Flags().BoolVar(&guiEnvDryRun, "dry-run", false, "Print the launchctl commands instead of running them")
+
+	reportCmd.Flags().IntVar(&reportTop, "top", 10, "Number of slowest commands and biggest scopes to show")
+
+	lsCmd.Flags().StringSliceVar(&lsTags, "tag", nil, "Only list vars with any of these tags (repeatable)")
+	lsCmd.Flags().StringVar(&lsSince, "since", "", "Only list vars changed since this age (7d, 36h) or date (2026-01-31)")
+	lsCmd.Flags().StringVar(&lsUntil, "until", "", "Only list vars last changed before this age or date")
+	lsCmd.Flags().BoolVar(&lsAllScopes, "all-scopes", false, "List variables from every scope in the database")
+	lsCmd.Flags().BoolVarP(&lsLong, "long", "l", false, "Show who last set each var, when, and when it was created")
+	lsCmd.Flags().StringVar(&lsSort, "sort", "key", "Order: key, or oldest first by updated or created")
+
+	applyCmd.Flags().StringVarP(&applyFile, "file", "f", "", "Change document to apply (- for stdin)")
+	applyCmd.Flags().StringVar(&applyPatch, "patch", "", "Patch to apply, as enva diff --json writes it (- for stdin)")
+	applyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "Show the changes without applying them")
+	applyCmd.Flags().BoolVar(&applyForce, "force", false, "Apply a patch even where the database no longer matches its old values")
+
+	diffCmd.Flags().BoolVar(&diffJSON, "json", false, "Print the differences as a patch for enva apply --patch")
+	diffCmd.Flags().BoolVar(&diffReverse, "reverse", false, "Show the changes that turn the remote into the database instead")
+
+	archiveCmd.Flags().StringVarP(&archiveOutput, "output", "o", "", "Write the archive here instead of the data directory's archives folder")
+	archiveCmd.Flags().BoolVar(&archiveDryRun, "dry-run", false, "Show what would be archived without writing or removing anything")
+	unarchiveCmd.Flags().BoolVar(&unarchiveForce, "force", false, "Replace vars that have been set again since they were archived")
+
+	policySetCmd.Flags().StringVar(&policyPrefix, "prefix", "", "Require keys to start with this prefix")
+	policySetCmd.Flags().BoolVar(&policySnake, "screaming-snake", false, "Require SCREAMING_SNAKE_CASE keys")
+	policySetCmd.Flags().StringSliceVar(&policyBanned, "ban", nil, "Keys that may never be set (repeatable)")
+	policySetCmd.Flags().StringVar(&policySecrets, "secrets", "", "Secret scanning mode: warn, block or off")
+
+	invalidArgs(rootCmd)
+}
+
+// Global overrides: --db and --profile
+var (
+	dbFlag      string
+	profileFlag string
+)
+
+// activeProfile returns the profile to use: --profile, else ENVA_PROFILE,
+// else the default profile.
+func activeProfile() string {
+	if profileFlag != "" {
+		return profileFlag
+	}
+	return env.GetProfileFromEnv()
+}
+
+// atScope formats a scope path with its profile for confirmation messages.
+func atScope(path, profile string) string {
+	path, host := env.SplitHost(path)
+	if path == env.GlobalScope {
+		path = "the global scope"
+	}
+	if host != "" {
+		return fmt.Sprintf("%s on host %s (profile %s)", path, host, profile)
+	}
+	return fmt.Sprintf("%s (profile %s)", path, profile)
+}
+
+// resolveDBPath returns the database location: --db, then ENVA_DB, then
+// the config file's "db", then the default.
+func resolveDBPath() (string, error) {
+	override := dbFlag
+	if override == "" && os.Getenv("ENVA_DB") == "" {
+		cfg, err := config.Load()
+		if err != nil {
+			return "", fmt.Errorf("failed to load config: %w", err)
+		}
+		override = cfg.DB
+	}
+	return db.ResolveDBPath(override)
+}
+
+// resolveAuthor returns who to record on writes: ENVA_AUTHOR, else config
+// author, else user@host. "none" records nothing.
+func resolveAuthor() string {
+	author := os.Getenv("ENVA_AUTHOR")
+	if author == "" {
+		if cfg, err := config.Load(); err == nil {
+			author = cfg.Author
+		}
+	}
+	switch author {
+	case "":
+		return db.DefaultAuthor()
+	case "none":
+		return ""
+	}
+	return author
+}
+
+// openStore opens the store at dbPath with the author set for writes.
+func openStore(dbPath string) (db.Store, error) {
+	database, err := db.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	database.SetAuthor(resolveAuthor())
+	return database, nil
 }
 
 // Helper to get database and resolver
-func getDBAndResolver() (*db.DB, *env.Resolver, error) {
-	dbPath, err := db.DefaultDBPath()
+// configureRoot applies the config's limits on project root discovery.
+func configureRoot(cfg *config.Config) {
+	envpath.RefuseFSRoot = cfg.RefuseFSRoot
+	if !cfg.RootStopAtHome {
+		return
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if canonical, err := envpath.Canonicalize(home); err == nil {
+			envpath.Home = canonical
+		}
+	}
+}
+
+func getDBAndResolver() (db.Store, *env.Resolver, error) {
+	dbPath, err := resolveDBPath()
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to get database path: %w", err)
 	}
 
-	database, err := db.Open(dbPath)
+	database, err := openStore(dbPath)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	profile := env.GetProfileFromEnv()
+	profile := activeProfile()
+	if err := requireUnlocked(profile); err != nil {
+		database.Close()
+		return nil, nil, err
+	}
+
 	resolver := env.NewResolver(database, profile)
+	if cfg, err := config.Load(); err == nil {
+		resolver.SetChainOptions(env.ChainOptions{MaxDepth: cfg.MaxChainDepth, ScopedOnly: cfg.ScopedChain})
+		if cfg.ReadEnvFiles {
+			resolver.SetEnvFiles(shell.ReadEnvFiles)
+		}
+	}
 
 	return database, resolver, nil
 }
 
+// printSecretWarnings prints secret scanning warnings to stderr.
+func printSecretWarnings(warnings []string) {
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "enva: warning: %s\n", w)
+	}
+	if len(warnings) > 0 {
+		fmt.Fprintln(os.Stderr, "enva: consider keeping credentials in a secret manager and referencing them instead")
+	}
+}
+
+// Opt-in usage log state: when the command started and what it resolved.
+var (
+	usageStart time.Time
+	usageCtx   *env.ResolveContext
+)
+
+// usageLogPath returns the location of the local usage log.
+func usageLogPath() (string, error) {
+	dir, err := db.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "usage.log"), nil
+}
+
+// logUsage appends the finished command to the usage log if it's enabled in
+// the config. Interactive sessions aren't logged (their duration is the
+// user's, not enva's), nor is report itself. Failures are ignored so logging
+// never breaks a command.
+func logUsage(cmd *cobra.Command) {
+	if cmd == rootCmd || cmd == tuiCmd || cmd == reportCmd || usageStart.IsZero() {
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil || !cfg.UsageLog {
+		return
+	}
+	path, err := usageLogPath()
+	if err != nil {
+		return
+	}
+
+	entry := usage.Entry{
+		Time:     usageStart.UTC(),
+		Command:  strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" "),
+		Duration: time.Since(usageStart),
+	}
+	if usageCtx != nil {
+		entry.Dir = usageCtx.CwdReal
+		entry.Scopes = len(usageCtx.Chain)
+		entry.Vars = len(usageCtx.Resolved)
+	}
+	usage.Append(path, entry)
+}
+
+// errCwdUnavailable reports a working directory that was deleted or can't be accessed.
+var errCwdUnavailable = errors.New("current directory is unavailable (deleted or permission denied)")
+
+// getCwd returns the working directory, or errCwdUnavailable if it was
+// removed from under the shell or can't be read.
+func getCwd() (string, error) {
+	cwd, err := os.Getwd()
+	if err == nil {
+		_, err = os.Stat(cwd)
+	}
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return "", errCwdUnavailable
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get cwd: %w", err)
+	}
+	return cwd, nil
+}
+
+// loadedKeys returns the keys the shell hook loaded into the current
+// environment that still hold the values it exported.
+func loadedKeys() map[string]bool {
+	return shell.LoadState(os.LookupEnv).Owned(os.LookupEnv)
+}
+
+// printStateUpdate prints the lines that move the shell's tracking state from
+// prev to next, dropping any legacy tracking variables along the way.
+func printStateUpdate(prev, next shell.State) {
+	if _, ok := os.LookupEnv(shell.LegacyKeysVar); ok {
+		fmt.Printf("unset %s\n", shell.LegacyKeysVar)
+		fmt.Printf("unset %s\n", shell.LegacyPathVar)
+	}
+	if !next.Empty() {
+		fmt.Printf("export %s='%s'\n", shell.StateVar, next.Encode())
+	} else if _, ok := os.LookupEnv(shell.StateVar); ok {
+		fmt.Printf("unset %s\n", shell.StateVar)
+	}
+}
+
+var (
+	hookCheck   bool
+	hookInstall bool
+	hookOnCd    bool
+)
+
 // hookCmd prints shell hook code
 var hookCmd = &cobra.Command{
 	Use:   "hook [bash|zsh|fish]",
@@ -132,38 +720,290 @@ of environment variables when changing directories.
 Add to your shell config:
   # bash: eval "$(enva hook bash)"
   # zsh:  eval "$(enva hook zsh)"
-  # fish: enva hook fish | source`,
-	Args: cobra.ExactArgs(1),
+  # fish: enva hook fish | source
+
+With --on-cd, bash and zsh only run export when the directory changes
+instead of at every prompt. Changes made in the current directory then
+load on the next cd. Fish always works this way.
+
+With --check, verify the hook is in the shell's config file and still
+registered after startup (frameworks sometimes overwrite PROMPT_COMMAND
+or precmd). The shell defaults to $SHELL. Add --install to fix it.`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		shellName := strings.ToLower(args[0])
+		if hookInstall && !hookCheck {
+			return invalidf("--install requires --check")
+		}
+
+		shellName := ""
+		if len(args) == 1 {
+			shellName = strings.ToLower(args[0])
+		} else if hookCheck {
+			shellName = shell.DetectShell()
+			if shellName == "" {
+				return invalidf("could not detect shell from $SHELL, pass one of: bash, zsh, fish")
+			}
+		} else {
+			return invalidf("specify a shell: bash, zsh or fish")
+		}
+
+		if hookCheck {
+			return checkHook(shellName)
+		}
 
 		switch shellName {
 		case "bash":
-			fmt.Print(bashHook)
+			if hookOnCd {
+				fmt.Print(bashCdHook)
+			} else {
+				fmt.Print(bashHook)
+			}
 		case "zsh":
-			fmt.Print(zshHook)
+			if hookOnCd {
+				fmt.Print(zshCdHook)
+			} else {
+				fmt.Print(zshHook)
+			}
 		case "fish":
 			fmt.Print(fishHook)
 		default:
-			return fmt.Errorf("unsupported shell: %s (supported: bash, zsh, fish)", shellName)
+			return invalidf("unsupported shell: %s (supported: bash, zsh, fish)", shellName)
 		}
 		return nil
 	},
 }
 
-const bashHook = `_enva_hook() { local s=$?; eval "$(enva export --internal)"; return $s; }
+// checkHook reports whether the hook is configured and active for a shell,
+// repairing the config file when --install is set.
+func checkHook(shellName string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	rc := shell.RCFile(shellName, home)
+	if rc == "" {
+		return invalidf("unsupported shell: %s (supported: bash, zsh, fish)", shellName)
+	}
+
+	fmt.Printf("Shell:    %s\n", shellName)
+
+	if !shell.IsHookInstalled(shellName, home) {
+		fmt.Printf("Config:   hook not found in %s\n", rc)
+		if !hookInstall {
+			fmt.Println("Fix:      run 'enva hook --check --install' or add: " + shell.HookLine(shellName))
+			return nil
+		}
+		if _, _, err := shell.InstallHook(shellName, home); err != nil {
+			return fmt.Errorf("failed to install hook: %w", err)
+		}
+		fmt.Printf("Fixed:    added hook to %s, restart your shell\n", rc)
+		return nil
+	}
+	fmt.Printf("Config:   hook found in %s\n", rc)
+
+	active, err := shell.ProbeHook(shellName)
+	if err != nil {
+		fmt.Printf("Active:   unknown (%v)\n", err)
+		return nil
+	}
+	if active {
+		fmt.Println("Active:   yes, _enva_hook is registered after startup")
+		return nil
+	}
+
+	fmt.Println("Active:   no, something later in your config overwrote the hook")
+	if !hookInstall {
+		fmt.Println("Fix:      run 'enva hook --check --install' to re-add the hook at the end of " + rc)
+		return nil
+	}
+	if err := shell.AppendHook(shellName, home); err != nil {
+		return fmt.Errorf("failed to install hook: %w", err)
+	}
+	fmt.Printf("Fixed:    re-added hook at the end of %s, restart your shell\n", rc)
+	return nil
+}
+
+const bashHook = `_enva_hook() { local s=$?; eval "$(enva export --internal --shell-pid $$)"; return $s; }
+if ! [[ "${PROMPT_COMMAND:-}" =~ _enva_hook ]]; then PROMPT_COMMAND="_enva_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}"; fi
+`
+
+const zshHook = `_enva_hook() { eval "$(enva export --internal --shell-pid $$)"; }; autoload -Uz add-zsh-hook; add-zsh-hook precmd _enva_hook`
+
+// bashCdHook skips export unless $PWD changed since the last prompt; bash has
+// no chpwd hook of its own.
+const bashCdHook = `_enva_hook() { local s=$?; if [[ "$PWD" != "${_enva_pwd:-}" ]]; then _enva_pwd=$PWD; eval "$(enva export --internal --shell-pid $$)"; fi; return $s; }
 if ! [[ "${PROMPT_COMMAND:-}" =~ _enva_hook ]]; then PROMPT_COMMAND="_enva_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}"; fi
 `
 
-const zshHook = `_enva_hook() { eval "$(enva export --internal)"; }; autoload -Uz add-zsh-hook; add-zsh-hook precmd _enva_hook`
+// zshCdHook runs export on chpwd only, plus once for the starting directory.
+const zshCdHook = `_enva_hook() { eval "$(enva export --internal --shell-pid $$)"; }; autoload -Uz add-zsh-hook; add-zsh-hook chpwd _enva_hook; _enva_hook
+`
 
 const fishHook = `function _enva_hook --on-variable PWD
-    enva export --internal | source
+    enva export --internal --shell fish --shell-pid $fish_pid | source
 end
-enva export --internal | source
+enva export --internal --shell fish --shell-pid $fish_pid | source
 `
 
-var exportInternal bool
+// exportUnavailable unloads everything the hook loaded when the working
+// directory is gone or the profile is locked, so the prompt stays quiet
+// instead of erroring.
+func exportUnavailable(reason error) {
+	prev := shell.LoadState(os.LookupEnv)
+	var unloaded int
+	for _, key := range prev.Keys() {
+		if prev.Owns(key, os.LookupEnv) {
+			fmt.Printf("unset %s\n", key)
+			unloaded++
+		}
+	}
+	if !prev.Inherited(exportShellPID) {
+		for _, name := range prev.AliasNames() {
+			fmt.Println(shell.FormatUnalias(exportShell, name))
+		}
+	}
+
+	if exportInternal {
+		printStateUpdate(prev, shell.State{})
+	}
+
+	// From the hook, only speak up when something was actually unloaded
+	if unloaded > 0 {
+		fmt.Fprintf(os.Stderr, "enva: %v; unloaded %d var(s)\n", reason, unloaded)
+	} else if !exportInternal {
+		fmt.Fprintf(os.Stderr, "enva: %v\n", reason)
+	}
+}
+
+var (
+	exportInternal     bool
+	exportAsync        bool
+	exportDotenv       bool
+	exportFormat       string
+	exportJSON         bool
+	exportPrefix       string
+	exportStripPrefix  string
+	exportLower        bool
+	exportMap          []string
+	exportComments     string
+	exportTags         []string
+	exportDiff         string
+	exportOutputRef    string
+	exportSince        string
+	exportUntil        string
+	exportRefreshCache bool
+	exportShellPID     int
+	exportShell        string
+)
+
+// resolveNow resolves the environment for dir straight from the database.
+func resolveNow(dir string) (*env.ResolveContext, error) {
+	database, resolver, err := getDBAndResolver()
+	if err != nil {
+		return nil, err
+	}
+	defer database.Close()
+
+	ctx, err := resolver.Resolve(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve environment: %w", err)
+	}
+	return ctx, nil
+}
+
+// exportCachePath returns the cache file for dir under the active database
+// and profile, and the database file it's resolved from, without opening
+// the database.
+func exportCachePath(dir string) (path, dbFile string, err error) {
+	dbPath, err := resolveDBPath()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get database path: %w", err)
+	}
+	file := db.FilePath(dbPath)
+	if file == "" {
+		return "", "", invalidf("--async needs a file-backed database, not %s", dbPath)
+	}
+	return cache.Path(file, activeProfile(), dir), file, nil
+}
+
+// resolveCached answers from the export cache and, when the database
+// changed since the entry was resolved, starts a background refresh for
+// the next prompt. On a cache miss it resolves synchronously and seeds the
+// cache.
+func resolveCached(dir string) (*env.ResolveContext, error) {
+	cachePath, dbFile, err := exportCachePath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := cache.Load(cachePath)
+	if err == nil {
+		if cache.Stale(cachePath, dbFile) {
+			startCacheRefresh(dir, cachePath)
+		}
+		return ctx, nil
+	}
+
+	source := cache.SourceTime(dbFile)
+	ctx, err = resolveNow(dir)
+	if err != nil {
+		return nil, err
+	}
+	// A failed cache write only costs speed on the next prompt
+	_ = cache.Store(cachePath, ctx, source)
+	return ctx, nil
+}
+
+// startCacheRefresh spawns a detached "enva export --refresh-cache" for dir,
+// unless a refresh of its cache entry at cachePath is already running.
+func startCacheRefresh(dir, cachePath string) {
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	if !cache.Claim(cachePath) {
+		return
+	}
+
+	args := []string{"export", "--refresh-cache"}
+	if dbFlag != "" {
+		args = append(args, "--db", dbFlag)
+	}
+	if profileFlag != "" {
+		args = append(args, "--profile", profileFlag)
+	}
+
+	c := exec.Command(exe, args...)
+	c.Dir = dir
+	detach(c)
+	if err := c.Start(); err != nil {
+		cache.Release(cachePath)
+		return
+	}
+	// Don't wait: the refresh outlives this process
+	_ = c.Process.Release()
+}
+
+// refreshExportCache resolves dir, rewrites its cache entry and drops the
+// refresh lock startCacheRefresh took.
+func refreshExportCache(dir string) error {
+	cachePath, dbFile, err := exportCachePath(dir)
+	if err != nil {
+		return err
+	}
+	defer cache.Release(cachePath)
+
+	source := cache.SourceTime(dbFile)
+	ctx, err := resolveNow(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := cache.Store(cachePath, ctx, source); err != nil {
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	return nil
+}
 
 // exportCmd prints shell export/unset lines
 var exportCmd = &cobra.Command{
@@ -173,379 +1013,6205 @@ var exportCmd = &cobra.Command{
 current directory. Tracks previously loaded variables and unsets them
 when they're no longer needed.
 
-Use --internal flag for shell hook integration (includes tracking variables).`,
+Use --internal flag for shell hook integration (includes tracking variables).
+
+Use --tag to export only a group of tagged vars, e.g. --tag aws, and
+--since/--until to export only vars changed in a time window, e.g.
+--since 7d for the last week's changes or --since 2026-01-01.
+
+Use --dotenv (or --format dotenv) to print KEY=value lines suitable for a
+.env file, and --comments=trailing|preceding|none to choose how stored
+descriptions are written (shell output defaults to trailing, dotenv to none).
+
+Use --format launchctl on macOS to print launchctl setenv lines that make
+the vars visible to GUI apps; pipe them to sh, or see enva gui-env apply.
+
+Use --prefix, --strip-prefix, --lower and --map OLD=NEW to export keys
+under the names a tool expects, e.g. --prefix TF_VAR_ --lower for
+Terraform or --prefix VITE_ for Vite; the stored keys don't change.
+
+Use --json (or --format json) to print the vars as a JSON array with each
+one's scope, tags, author and created_at/updated_at times, for scripts.
+
+Use --async (or set ENVA_ASYNC=1) on slow or network filesystems: export
+answers from the cache immediately and, when the database changed since
+the cache was written, refreshes it in the background, so changes show
+up on the next prompt.
+
+Use --diff FILE in CI to check the stored environment against a reference
+.env file. It lists the keys that are missing, extra or different (never
+the values) and exits with status 6 if there are any.
+
+Use --output-ref for tools that read a .env file rather than their
+environment: the vars are written to a file only you can read, kept per
+directory and profile under enva's data directory, and the output only
+sets ENVA_ENV_FILE (or --output-ref=VAR) to its path:
+
+  eval "$(enva export --output-ref)"
+  docker compose --env-file "$ENVA_ENV_FILE" up`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		database, resolver, err := getDBAndResolver()
+		cwd, err := getCwd()
+		if errors.Is(err, errCwdUnavailable) {
+			exportUnavailable(err)
+			return nil
+		}
 		if err != nil {
 			return err
 		}
-		defer database.Close()
 
-		cwd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("failed to get cwd: %w", err)
+		if exportRefreshCache {
+			return refreshExportCache(cwd)
+		}
+		if err := requireUnlocked(activeProfile()); err != nil {
+			var coded *codedError
+			if exportInternal && errors.As(err, &coded) && coded.code == exitProtected {
+				exportUnavailable(err)
+				return nil
+			}
+			return err
 		}
 
-		ctx, err := resolver.Resolve(cwd)
+		format, err := exportOutputFormat()
 		if err != nil {
-			return fmt.Errorf("failed to resolve environment: %w", err)
+			return err
 		}
-
-		// Get current vars
-		newVars := ctx.GetSortedVars()
-		newKeys := make(map[string]bool)
-		newVals := make(map[string]string)
-		for _, v := range newVars {
-			newKeys[v.Key] = true
-			newVals[v.Key] = v.Value
-		}
-
-		// Get previously loaded keys and path from env
-		prevKeysStr := os.Getenv("__ENVA_LOADED_KEYS")
-		prevPath := os.Getenv("__ENVA_LOADED_PATH")
-		var prevKeys []string
-		prevKeysSet := make(map[string]bool)
-		if prevKeysStr != "" {
-			prevKeys = strings.Split(prevKeysStr, ":")
-			for _, k := range prevKeys {
-				if k != "" {
-					prevKeysSet[k] = true
-				}
-			}
+		window, err := parseTimeWindow(exportSince, exportUntil)
+		if err != nil {
+			return err
 		}
-
-		// Count changes
-		var unsetCount, loadCount int
-
-		// Unset keys that are no longer in the environment
-		for _, key := range prevKeys {
-			if key != "" && !newKeys[key] {
-				fmt.Printf("unset %s\n", key)
-				unsetCount++
-			}
+		km, err := parseKeyMap(exportMap)
+		if err != nil {
+			return err
 		}
-
-		// Export new values (with description as comment if present)
-		for _, v := range newVars {
-			fmt.Println(shell.FormatExportWithDesc(v.Key, v.Value, v.Description))
-			if !prevKeysSet[v.Key] {
-				loadCount++
-			}
+		km.StripPrefix, km.Lower, km.Prefix = exportStripPrefix, exportLower, exportPrefix
+		if (format != "shell" || len(exportTags) > 0 || window.set() || !km.IsZero()) && exportInternal {
+			return invalidf("--format, --dotenv, --tag, --since, --until and key renaming can't be combined with --internal")
 		}
-
-		// Update the tracking variables
-		var keysList []string
+		if exportDiff != "" && (format != "shell" || exportInternal || exportComments != "") {
+			return invalidf("--diff can't be combined with --format, --dotenv, --comments or --internal")
+		}
+		if exportOutputRef != "" && (exportInternal || exportDiff != "" || format == "json") {
+			return invalidf("--output-ref can't be combined with --internal, --diff or --json")
+		}
+		if exportOutputRef != "" && !shell.IsValidKey(exportOutputRef) {
+			return invalidf("invalid variable name for --output-ref: %q", exportOutputRef)
+		}
+		tags, err := normalizeTags(exportTags)
+		if err != nil {
+			return err
+		}
+		style := shell.CommentsTrailing
+		if format == "dotenv" {
+			style = shell.CommentsNone
+		}
+		if exportComments != "" {
+			if style, err = shell.ParseCommentStyle(exportComments); err != nil {
+				return err
+			}
+		}
+
+		var ctx *env.ResolveContext
+		if exportAsync || os.Getenv("ENVA_ASYNC") == "1" {
+			ctx, err = resolveCached(cwd)
+		} else {
+			ctx, err = resolveNow(cwd)
+		}
+		if errors.Is(err, envpath.ErrFSRoot) {
+			exportUnavailable(err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		usageCtx = ctx
+
+		// The hook only repeats the warning when entering a directory, below
+		ctx.StripNoExport()
+		warnInvalidKeys(shell.StripInvalidKeys(ctx))
+		blocked := stripUntrusted(ctx)
+		if !exportInternal && len(blocked) > 0 {
+			warnBlocked(blocked)
+			exitStatus = exitUntrusted
+		}
+		failed := evalRunnerFor(ctx).Apply(ctx)
+		if !exportInternal {
+			warnEvalFailed(failed)
+		}
+
+		selected := filterByTime(filterByTags(ctx.GetSortedVars(), tags), window)
+		if selected, err = renameVars(selected, km); err != nil {
+			return err
+		}
+		if exportDiff != "" {
+			return printDrift(selected, exportDiff)
+		}
+		if exportOutputRef != "" {
+			return printEnvFileRef(ctx, selected, format)
+		}
+
+		if format == "json" {
+			return printExportJSON(selected)
+		}
+
+		// A .env file, launchd, selection or renaming gets every value,
+		// defaults included, and no unsets beyond the scope's clears for launchd
+		if format != "shell" || len(tags) > 0 || window.set() || !km.IsZero() {
+			if format == "launchctl" && len(tags) == 0 && !window.set() && km.IsZero() {
+				for _, key := range ctx.Cleared {
+					fmt.Println(shell.FormatLaunchctlUnset(key))
+				}
+			}
+			for _, v := range selected {
+				var line string
+				switch format {
+				case "dotenv":
+					line = shell.FormatDotenv(v.Key, v.Value)
+				case "launchctl":
+					line = shell.FormatLaunchctl(v.Key, v.Value)
+				default:
+					line = shell.FormatExport(v.Key, v.Value)
+				}
+				fmt.Println(shell.FormatWithComment(line, v.Description, style))
+			}
+			return nil
+		}
+
+		// Keys the hook loaded before, minus any the user has since changed
+		prev := shell.LoadState(os.LookupEnv)
+		if prev.Inherited(exportShellPID) {
+			// A parent shell wrote this state; trust only what this shell holds
+			prev = prev.Rederive(os.LookupEnv)
+		}
+		prevKeysSet := prev.Owned(os.LookupEnv)
+
+		// Get current vars (if-unset vars yield to values the user set themselves)
+		newVars := ctx.ApplicableVars(os.LookupEnv, prevKeysSet)
+		newKeys := make(map[string]bool)
+		for _, v := range newVars {
+			newKeys[v.Key] = true
+		}
+
+		// Count changes
+		var unsetCount, loadCount int
+
+		// Unset keys that are no longer in the environment. A tracked key
+		// whose value changed since it was loaded is left alone.
+		unsetDone := make(map[string]bool)
+		for _, key := range prev.Keys() {
+			if !newKeys[key] && prevKeysSet[key] {
+				fmt.Printf("unset %s\n", key)
+				unsetDone[key] = true
+				unsetCount++
+			}
+		}
+
+		// Force-unset keys declared for this scope
+		for _, key := range ctx.Cleared {
+			if unsetDone[key] {
+				continue
+			}
+			if _, present := os.LookupEnv(key); present || !exportInternal {
+				fmt.Printf("unset %s\n", key)
+			}
+		}
+
+		// Export new values (with description as comment if present)
+		sums := make(map[string]string, len(newVars))
 		for _, v := range newVars {
-			keysList = append(keysList, v.Key)
+			fmt.Println(shell.FormatWithComment(shell.FormatExport(v.Key, v.Value), v.Description, style))
+			sums[v.Key] = shell.Fingerprint(v.Value)
+			if !prevKeysSet[v.Key] {
+				loadCount++
+			}
+		}
+
+		// Update the tracking state (only with --internal flag for shell hooks)
+		cwdReal := ctx.CwdReal
+		if exportInternal {
+			aliases := exportAliases(prev, ctx)
+			next := prev.Next(cwdReal, ctx.Profile, exportShellPID, sums, aliases)
+			printStateUpdate(prev, next)
+
+			// Print status message to stderr (only for shell hooks)
+			moved := prev.Path != cwdReal || (prev.Profile != "" && prev.Profile != ctx.Profile)
+			if moved {
+				warnBlocked(blocked)
+				warnEvalFailed(failed)
+				warnScopeDrift(ctx)
+			}
+			if unsetCount > 0 && len(newVars) == 0 {
+				fmt.Fprintf(os.Stderr, "enva: unloaded %d var(s)\n", unsetCount)
+			} else if (loadCount > 0 || unsetCount > 0) && moved {
+				fmt.Fprintf(os.Stderr, "enva: loaded %d var(s)\n", len(newVars))
+			}
+		}
+
+		return nil
+	},
+}
+
+// trustListPath returns the location of the local list of trusted scopes.
+func trustListPath() (string, error) {
+	dir, err := db.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trusted.json"), nil
+}
+
+// protectListPath returns the location of the local list of protected
+// profiles.
+func protectListPath() (string, error) {
+	dir, err := db.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "protected.json"), nil
+}
+
+// unlockSession returns where unlocked profiles are recorded: the login
+// session's runtime directory if there is one, so logging out locks them,
+// else the data directory.
+func unlockSession() (*protect.Session, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return protect.NewSession(filepath.Join(dir, "enva", "unlocked")), nil
+	}
+	dir, err := db.DataDir()
+	if err != nil {
+		return nil, err
+	}
+	return protect.NewSession(filepath.Join(dir, "unlocked")), nil
+}
+
+// unlockTimeout returns how long an unlock lasts: the config's
+// unlock_minutes, else protect.DefaultTimeout.
+func unlockTimeout() time.Duration {
+	if cfg, err := config.Load(); err == nil && cfg.UnlockMinutes > 0 {
+		return time.Duration(cfg.UnlockMinutes) * time.Minute
+	}
+	return protect.DefaultTimeout
+}
+
+// profileLocker checks and unlocks protected profiles for this session. It
+// is the TUI's tui.Locker.
+type profileLocker struct {
+	list    *protect.List
+	session *protect.Session
+}
+
+func (l profileLocker) Locked(profile string) bool {
+	return !l.session.Unlocked(l.list, profile)
+}
+
+func (l profileLocker) Unlock(profile, passphrase string) error {
+	return l.session.Unlock(l.list, profile, passphrase, unlockTimeout())
+}
+
+// loadLocker reads the protected profiles and this session's unlocks.
+func loadLocker() (profileLocker, error) {
+	path, err := protectListPath()
+	if err != nil {
+		return profileLocker{}, err
+	}
+	list, err := protect.Load(path)
+	if err != nil {
+		return profileLocker{}, err
+	}
+	session, err := unlockSession()
+	if err != nil {
+		return profileLocker{}, err
+	}
+	return profileLocker{list: list, session: session}, nil
+}
+
+// requireUnlocked makes sure profile may be used: it isn't protected, it's
+// unlocked, or its passphrase is entered now. From the shell hook, or with
+// no terminal to ask on, it fails with exitProtected instead.
+func requireUnlocked(profile string) error {
+	locker, err := loadLocker()
+	if err != nil {
+		return fmt.Errorf("failed to read protected profiles: %w", err)
+	}
+	if !locker.Locked(profile) {
+		return nil
+	}
+	if exportInternal || !protect.IsTerminal(os.Stdin) {
+		return &codedError{exitProtected, fmt.Errorf("profile %s is protected; run enva unlock %s", profile, profile)}
+	}
+	return promptUnlock(locker, profile)
+}
+
+// promptUnlock asks for profile's passphrase on the terminal, allowing a
+// few tries, and unlocks it.
+func promptUnlock(locker profileLocker, profile string) error {
+	for range 3 {
+		passphrase, err := readPassphrase(fmt.Sprintf("Passphrase for profile %s: ", profile))
+		if err != nil {
+			return err
+		}
+		err = locker.Unlock(profile, passphrase)
+		if !errors.Is(err, protect.ErrWrongPassphrase) {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "enva: wrong passphrase")
+	}
+	return &codedError{exitProtected, fmt.Errorf("profile %s is still locked", profile)}
+}
+
+// readPassphrase asks for a passphrase on the terminal without echoing it.
+func readPassphrase(prompt string) (string, error) {
+	if !protect.IsTerminal(os.Stdin) {
+		return "", invalidf("a passphrase can only be entered on a terminal")
+	}
+	return protect.ReadPassphrase(os.Stdin, os.Stderr, prompt)
+}
+
+// stripUntrusted removes denylisted vars (LD_PRELOAD, PATH, ...) defined at
+// untrusted scopes from ctx and returns them. If the trust list can't be
+// read, nothing is trusted.
+func stripUntrusted(ctx *env.ResolveContext) []*env.ResolvedVar {
+	var extra []string
+	if cfg, err := config.Load(); err == nil {
+		extra = cfg.DangerousKeys
+	}
+	list := &trust.List{}
+	if path, err := trustListPath(); err == nil {
+		if l, err := trust.Load(path); err == nil {
+			list = l
+		}
+	}
+	return list.Strip(ctx, extra)
+}
+
+// drift is a key that differs between enva and a .env file: mark is "+"
+// for keys only enva has, "-" for keys only the file has and "~" for
+// different values.
+type drift struct{ mark, key, what string }
+
+// dotenvDrift compares vars with the .env file at path, sorted by key.
+func dotenvDrift(vars []*env.ResolvedVar, path string) ([]drift, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	ref, invalid := shell.ParseDotenv(string(data))
+	if len(invalid) > 0 {
+		return nil, invalidf("%s: %d line(s) aren't KEY=value", path, len(invalid))
+	}
+
+	var diffs []drift
+	for _, v := range vars {
+		want, ok := ref[v.Key]
+		switch {
+		case !ok:
+			diffs = append(diffs, drift{"+", v.Key, "only in enva"})
+		case want != v.Value:
+			diffs = append(diffs, drift{"~", v.Key, "values differ"})
+		}
+		delete(ref, v.Key)
+	}
+	for key := range ref {
+		diffs = append(diffs, drift{"-", key, "only in " + path})
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].key < diffs[j].key })
+	return diffs, nil
+}
+
+// printDriftLines prints diffs with their keys aligned.
+func printDriftLines(diffs []drift) {
+	width := 0
+	for _, d := range diffs {
+		width = max(width, len(d.key))
+	}
+	for _, d := range diffs {
+		fmt.Printf("%s %-*s  %s\n", d.mark, width, d.key, d.what)
+	}
+}
+
+// printDrift lists how vars differ from the .env file at path, by key only
+// so CI logs don't leak values, and sets exitDrift if they differ at all.
+func printDrift(vars []*env.ResolvedVar, path string) error {
+	diffs, err := dotenvDrift(vars, path)
+	if err != nil {
+		return err
+	}
+	printDriftLines(diffs)
+	if len(diffs) == 0 {
+		fmt.Fprintf(os.Stderr, "enva: no differences from %s\n", path)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "enva: %d difference(s) from %s\n", len(diffs), path)
+	exitStatus = exitDrift
+	return nil
+}
+
+// trustedDir reports whether dir's scope may export denylisted keys and run
+// eval commands.
+func trustedDir(dir string) bool {
+	canonical, err := envpath.Canonicalize(dir)
+	if err != nil {
+		return false
+	}
+	path, err := trustListPath()
+	if err != nil {
+		return false
+	}
+	list, err := trust.Load(path)
+	return err == nil && list.Trusts(canonical)
+}
+
+// evalRunner returns a runner for --eval values configured from the config
+// file, caching outputs under the data directory.
+func evalRunner() *dynamic.Runner {
+	r := &dynamic.Runner{}
+	if cfg, err := config.Load(); err == nil {
+		r.Timeout = time.Duration(cfg.EvalTimeout) * time.Second
+		r.TTL = time.Duration(cfg.EvalCacheTTL) * time.Second
+	}
+	if dir, err := db.DataDir(); err == nil {
+		r.CacheDir = filepath.Join(dir, "eval-cache")
+	}
+	// Offline, commands aren't run: eval vars use cached or fallback values
+	r.Offline = os.Getenv("ENVA_OFFLINE") == "1"
+	r.Command = refCommand
+	return r
+}
+
+// refCommand returns the command an eval value runs: the CLI call reading
+// a secret manager reference, with today's defaults, or the value itself.
+func refCommand(value string) (string, error) {
+	if !provider.IsRef(value) {
+		return value, nil
+	}
+	opts, err := providerOptions()
+	if err != nil {
+		return "", err
+	}
+	return provider.Command(value, opts)
+}
+
+// evalRunnerFor returns evalRunner with the Vault token ctx's vault://
+// vars need, if it comes from an AppRole session. Only the eval commands
+// see it, not programs run with the vars.
+func evalRunnerFor(ctx *env.ResolveContext) *dynamic.Runner {
+	r := evalRunner()
+	if r.Offline {
+		return r
+	}
+	if token := vaultToken(ctx); token != "" {
+		r.Env = []string{"VAULT_TOKEN=" + token}
+	}
+	return r
+}
+
+// vaultSessionPath returns the file caching the AppRole Vault session.
+func vaultSessionPath() (string, error) {
+	dir, err := db.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "vault-session.json"), nil
+}
+
+// vaultSession returns the AppRole session for vault:// references when
+// the environment has AppRole credentials (VAULT_ROLE_ID and
+// VAULT_SECRET_ID) but no token, reusing the one cached in the data
+// directory until it expires, or logging in again if force is set. It
+// returns nil when the vault CLI's own VAULT_TOKEN or ~/.vault-token
+// applies instead.
+func vaultSession(force bool) (*provider.VaultSession, error) {
+	if os.Getenv("VAULT_ROLE_ID") == "" || (os.Getenv("VAULT_TOKEN") != "" && !force) {
+		return nil, nil
+	}
+	path, err := vaultSessionPath()
+	if err != nil {
+		return nil, err
+	}
+	var session provider.VaultSession
+	if data, err := os.ReadFile(path); err == nil && !force && json.Unmarshal(data, &session) == nil && session.Valid(time.Now()) {
+		return &session, nil
+	}
+
+	fresh, ok, err := provider.VaultLogin()
+	if err != nil || !ok {
+		return nil, err
+	}
+	if err := saveVaultSession(path, fresh); err != nil {
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// vaultToken returns the token for ctx's vault:// vars from the AppRole
+// session, logging in if needed, or "" when the vault CLI's own applies.
+// Without vault:// vars it does nothing, so nobody logs in needlessly.
+func vaultToken(ctx *env.ResolveContext) string {
+	if len(vaultKeys(ctx)) == 0 {
+		return ""
+	}
+	session, err := vaultSession(false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "enva: %v\n", err)
+	}
+	if session == nil {
+		return ""
+	}
+	return session.Token
+}
+
+// saveVaultSession writes session to path, readable only by the user.
+func saveVaultSession(path string, session *provider.VaultSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// vaultKeys returns the eval vars in ctx that read from Vault, sorted.
+func vaultKeys(ctx *env.ResolveContext) []string {
+	var keys []string
+	for _, v := range ctx.GetSortedVars() {
+		if v.Eval && provider.UsesVault(v.Value) {
+			keys = append(keys, v.Key)
+		}
+	}
+	return keys
+}
+
+// renewVault renews token, the Vault token vault:// references use ("" for
+// the vault CLI's own), and returns the token to use from now on and when
+// to renew it next: halfway to its expiry, or in an hour for tokens that
+// don't expire. A token that can't be renewed is replaced by logging in
+// again when AppRole credentials are available.
+func renewVault(token string) (string, time.Duration) {
+	session, err := provider.VaultRenew(token)
+	if err != nil {
+		if os.Getenv("VAULT_ROLE_ID") == "" {
+			fmt.Fprintf(os.Stderr, "enva: %v\n", err)
+			return token, time.Minute
+		}
+		fresh, err := vaultSession(true)
+		if err != nil || fresh == nil {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "enva: %v\n", err)
+			}
+			return token, time.Minute
+		}
+		return fresh.Token, renewAfter(fresh)
+	}
+	if token != "" && os.Getenv("VAULT_ROLE_ID") != "" {
+		if path, err := vaultSessionPath(); err == nil {
+			saveVaultSession(path, session) // Best effort: the next run logs in again
+		}
+	}
+	return token, renewAfter(session)
+}
+
+// renewAfter returns when to renew session: halfway to its expiry, or in
+// an hour if it doesn't expire.
+func renewAfter(session *provider.VaultSession) time.Duration {
+	if session.Expires.IsZero() {
+		return time.Hour
+	}
+	return max(time.Until(session.Expires)/2, time.Minute)
+}
+
+// warnEvalFailed tells the user which eval vars weren't exported, or were
+// exported with their fallback value.
+func warnEvalFailed(failed []dynamic.Failure) {
+	for _, f := range failed {
+		reason := fmt.Sprintf("command failed: %v", f.Err)
+		if errors.Is(f.Err, dynamic.ErrOffline) {
+			reason = "offline"
+		}
+		if f.Fallback != "" {
+			fmt.Fprintf(os.Stderr, "enva: exporting %s with its %s: %s\n", f.Var.Key, f.Fallback, reason)
+		} else {
+			fmt.Fprintf(os.Stderr, "enva: not exporting %s: %s\n", f.Var.Key, reason)
+		}
+	}
+}
+
+// warnBlocked tells the user which vars stripUntrusted held back.
+func warnBlocked(blocked []*env.ResolvedVar) {
+	for _, v := range blocked {
+		fmt.Fprintf(os.Stderr, "enva: not exporting %s from untrusted scope %s (enva trust %s to allow)\n", v.Key, v.DefinedAtPath, v.DefinedAtPath)
+	}
+}
+
+// warnInvalidKeys tells the user which keys shell.StripInvalidKeys held back.
+func warnInvalidKeys(keys []string) {
+	for _, key := range keys {
+		fmt.Fprintf(os.Stderr, "enva: not exporting invalid key %q\n", key)
+	}
+}
+
+// printEnvFileRef writes vars to the env file for ctx's directory and
+// profile, then prints a line setting --output-ref's var to its path.
+func printEnvFileRef(ctx *env.ResolveContext, vars []*env.ResolvedVar, format string) error {
+	path, err := exportEnvFilePath(ctx)
+	if err != nil {
+		return err
+	}
+	if err := writeEnvFile(path, vars, nil); err != nil {
+		return err
+	}
+	switch format {
+	case "dotenv":
+		fmt.Println(shell.FormatDotenv(exportOutputRef, path))
+	case "launchctl":
+		fmt.Println(shell.FormatLaunchctl(exportOutputRef, path))
+	default:
+		fmt.Println(shell.FormatExport(exportOutputRef, path))
+	}
+	return nil
+}
+
+// exportEnvFilePath returns the env file export --output-ref writes for
+// ctx. Each directory and profile has its own, rewritten on every export,
+// in a directory under the data directory only the user can open.
+func exportEnvFilePath(ctx *env.ResolveContext) (string, error) {
+	dir, err := db.DataDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get data directory: %w", err)
+	}
+	dir = filepath.Join(dir, "env-files")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	sum := sha256.Sum256([]byte(ctx.Profile + "\x00" + ctx.CwdReal))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".env"), nil
+}
+
+// parseKeyMap parses --map OLD=NEW flags into a key map.
+func parseKeyMap(maps []string) (shell.KeyMap, error) {
+	km := shell.KeyMap{Rename: make(map[string]string)}
+	for _, m := range maps {
+		from, to, ok := strings.Cut(m, "=")
+		if !ok || !shell.IsValidKey(from) || !shell.IsValidKey(to) {
+			return km, invalidf("invalid --map %q (use OLD=NEW)", m)
+		}
+		km.Rename[from] = to
+	}
+	return km, nil
+}
+
+// renameVars returns copies of vars under the names km gives them, sorted
+// by their new keys.
+func renameVars(vars []*env.ResolvedVar, km shell.KeyMap) ([]*env.ResolvedVar, error) {
+	if km.IsZero() {
+		return vars, nil
+	}
+	keys := make([]string, len(vars))
+	for i, v := range vars {
+		keys[i] = v.Key
+	}
+	if err := km.Check(keys); err != nil {
+		return nil, invalidf("%v", err)
+	}
+	renamed := make([]*env.ResolvedVar, len(vars))
+	for i, v := range vars {
+		c := *v
+		c.Key = km.Key(v.Key)
+		renamed[i] = &c
+	}
+	sort.Slice(renamed, func(i, j int) bool { return renamed[i].Key < renamed[j].Key })
+	return renamed, nil
+}
+
+// exportJSONVar is one var in export --json output.
+type exportJSONVar struct {
+	Key         string    `json:"key"`
+	Value       string    `json:"value"`
+	Description string    `json:"description,omitempty"`
+	DefinedAt   string    `json:"defined_at"`
+	Tags        []string  `json:"tags,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// printExportJSON prints vars as an indented JSON array.
+func printExportJSON(vars []*env.ResolvedVar) error {
+	out := make([]exportJSONVar, 0, len(vars))
+	for _, v := range vars {
+		out = append(out, exportJSONVar{
+			Key:         v.Key,
+			Value:       v.Value,
+			Description: v.Description,
+			DefinedAt:   v.DefinedAtPath,
+			Tags:        v.Tags,
+			Author:      v.Author,
+			CreatedAt:   v.CreatedAt.UTC(),
+			UpdatedAt:   v.UpdatedAt.UTC(),
+		})
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// exportOutputFormat returns the export --format, with --dotenv and --json
+// as shorthands for dotenv and json.
+func exportOutputFormat() (string, error) {
+	format := exportFormat
+	if exportDotenv && exportJSON {
+		return "", invalidf("--dotenv can't be combined with --json")
+	}
+	for flag, set := range map[string]bool{"dotenv": exportDotenv, "json": exportJSON} {
+		if !set {
+			continue
+		}
+		if format != "" && format != flag {
+			return "", invalidf("--%s can't be combined with --format %s", flag, format)
+		}
+		format = flag
+	}
+	switch format {
+	case "":
+		return "shell", nil
+	case "shell", "dotenv", "launchctl", "json":
+		return format, nil
+	}
+	return "", invalidf("invalid format %q (use shell, dotenv, launchctl or json)", format)
+}
+
+// exportAliases prints the alias changes from what the hook last defined to
+// the aliases in effect for ctx, returning their fingerprints for the state.
+// Unchanged aliases aren't redefined.
+func exportAliases(prev shell.State, ctx *env.ResolveContext) map[string]string {
+	for _, name := range prev.AliasNames() {
+		if _, ok := ctx.Aliases[name]; !ok {
+			fmt.Println(shell.FormatUnalias(exportShell, name))
+		}
+	}
+
+	var sums map[string]string
+	for _, a := range ctx.GetSortedAliases() {
+		sum := shell.Fingerprint(a.Command)
+		if prev.Aliases[a.Name] != sum {
+			fmt.Println(shell.FormatAlias(a.Name, a.Command))
+		}
+		if sums == nil {
+			sums = make(map[string]string)
+		}
+		sums[a.Name] = sum
+	}
+	return sums
+}
+
+// defaultEnvFileVar is the variable --output-ref sets to the env file's
+// path when no name is given.
+const defaultEnvFileVar = "ENVA_ENV_FILE"
+
+// writeEnvFile writes vars, then extra KEY=VALUE pairs, to path as a .env
+// file only the user can read. The file is replaced atomically, so a tool
+// reading it never sees it half-written.
+func writeEnvFile(path string, vars []*env.ResolvedVar, extra []string) error {
+	var b strings.Builder
+	for _, v := range vars {
+		b.WriteString(shell.FormatDotenv(v.Key, v.Value) + "\n")
+	}
+	for _, kv := range extra {
+		key, value, _ := strings.Cut(kv, "=")
+		b.WriteString(shell.FormatDotenv(key, value) + "\n")
+	}
+
+	// CreateTemp makes the file 0600
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".env-*")
+	if err != nil {
+		return fmt.Errorf("failed to create env file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write env file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write env file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write env file: %w", err)
+	}
+	return nil
+}
+
+// effectiveEnviron returns the current process environment with ctx's
+// force-unsets removed and its vars applied, sorted.
+func effectiveEnviron(ctx *env.ResolveContext) []string {
+	// Build environment: current env + enva vars
+	envMap := make(map[string]string)
+	for _, e := range os.Environ() {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 {
+			envMap[parts[0]] = parts[1]
+		}
+	}
+
+	// Strip force-unset keys, then override with enva vars
+	for _, key := range ctx.Cleared {
+		delete(envMap, key)
+	}
+	for _, v := range ctx.ApplicableVars(os.LookupEnv, loadedKeys()) {
+		envMap[v.Key] = v.Value
+	}
+
+	// Convert back to slice
+	var environ []string
+	for k, v := range envMap {
+		environ = append(environ, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(environ)
+	return environ
+}
+
+var (
+	setIfUnset  bool
+	setEval     bool
+	setPass     string
+	setRef      string
+	setFallback string
+	setType     string
+	setNoExport bool
+	setRefresh  time.Duration
+	setFromFile string
+	setGenerate string
+	setGlobal   bool
+	setHost     string
+)
+
+// setCmd sets a variable at current directory scope
+var setCmd = &cobra.Command{
+	Use:   "set KEY=VALUE | KEY --from-file PATH | KEY --generate SPEC",
+	Short: "Set an environment variable at current directory",
+	Long: `Set an environment variable at the current directory scope.
+
+With --from-file the value is read verbatim from PATH (- for stdin),
+newlines and all, which suits PEM keys and kubeconfigs. It round-trips
+with enva cat:
+
+  enva set TLS_KEY --from-file key.pem
+  enva cat TLS_KEY > key.pem
+
+With --generate the value is made by enva, so a new secret never passes
+through your shell or its history. The value isn't printed; use enva cat
+to see it.
+
+  secret[:BYTES]    random bytes as URL-safe base64 (default 32 bytes)
+  hex[:BYTES]       random bytes as hex
+  base64[:BYTES]    random bytes as standard base64
+  uuid              a random UUID (version 4)
+  timestamp[:FMT]   the current UTC time: rfc3339 (default), unix or unixms
+
+With --if-unset the value is a default: it only applies when KEY isn't
+already set in your environment (useful for EDITOR or PAGER). Use
+--if-unset=false to turn an existing default back into an override.
+
+With --no-export the var stays in enva: cat, ls and the TUI show it, but
+export, run, the shell hook and gui-env leave it out, so a value only
+scripts read with enva cat doesn't leak into every child process. Use
+--no-export=false to export it again.
+
+With --eval the value is a shell command, run with sh in the scope's
+directory whenever the var is exported (export, the shell hook, run and
+gui-env); its output, minus trailing newlines, is the value:
+
+  enva set GIT_SHA='$(git rev-parse --short HEAD)' --eval
+
+The $( ) around the command is optional. Commands time out after 5s and
+their output is reused for 30s (eval_timeout and eval_cache_ttl in the
+config file). Only trusted scopes run commands; see enva trust. Use
+--eval=false to make the value literal again.
+
+--refresh sets how long this var's output is reused instead, e.g. 50m for
+credentials that expire hourly; enva refresh --watch renews it ahead of
+time.
+
+--type declares what the value holds: json, int or bool (string, the
+default, takes anything). The value is checked now and on every later set
+or edit, JSON is stored pretty-printed, and enva cat --jq reads fields out
+of it. Use --type string to drop the declaration.
+
+  enva set --type json CONFIG='{"db":{"host":"localhost","port":5432}}'
+  enva get CONFIG --jq .db.port
+
+--fallback sets what the var exports when its command fails, say because
+a secret manager is unreachable: fail (the default) leaves it out,
+cache[:MAXAGE] uses the last output if it's no older than MAXAGE, and
+default:VALUE uses VALUE. Export reports on stderr whenever a fallback is
+used. With ENVA_OFFLINE=1 no commands run at all: cached outputs serve
+while fresh, and every other var falls back.
+
+  enva set DB_PASSWORD --ref aws-sm://prod/db --fallback cache:24h
+
+With --pass the value is an entry in your pass password store (gopass with
+pass_command in the config): an --eval var that runs pass show and exports
+the entry's first line, so the secret never lands in enva's database:
+
+  enva set API_KEY --pass project/API_KEY
+
+--ref does the same for a secret manager, through the aws, gcloud or
+vault CLI and its credentials:
+
+  aws-sm://NAME[?region=R]                AWS Secrets Manager
+  aws-ssm://NAME[?region=R]               AWS SSM Parameter Store
+  gcp-sm://NAME[?project=P&version=V]     GCP Secret Manager (version latest)
+  vault://MOUNT/PATH[#FIELD][?version=N]  Vault KV v2 (field "value")
+  pass://ENTRY                            same as --pass
+
+Vault uses VAULT_ADDR and VAULT_TOKEN (or vault login). With VAULT_ROLE_ID
+and VAULT_SECRET_ID set instead, enva logs in with AppRole and reuses the
+token until it expires; enva refresh --watch renews it, and enva check
+reports an expired session.
+
+aws_region and gcp_project in the config fill in what a reference leaves
+out. Combine with --refresh to reuse values for longer than 30s:
+
+  enva set DB_PASSWORD --ref aws-sm://prod/db-password --refresh 15m
+
+With --global the var is set at the global scope, which applies in every
+directory below all project roots, so any scope can override it. It suits
+machine-wide defaults like EDITOR or GOPATH. Global vars can't use --eval
+or be denylisted keys such as PATH. Remove them with enva unset --global.
+
+With --host the var only applies on the named machine, for a database
+synced between machines (see enva sync). On that host it takes precedence
+over the same key anywhere in the chain, global scope included, so it can
+point a shared project at a local path:
+
+  enva set --host my-laptop DATA_DIR=/Volumes/fast/data
+
+The host is ENVA_HOST if set, else the machine's host name up to the first
+dot; enva which shows it. Combine with --global for a machine-wide default.`,
+	Args: cobra.ExactArgs(1),
+	Example: `  enva set API_URL=http://localhost:8080
+  enva set TLS_KEY --from-file key.pem
+  enva set JWT_SECRET --generate secret:32
+  enva set API_KEY --pass project/API_KEY
+  enva set DB_PASSWORD --ref aws-sm://prod/db-password
+  enva set --global EDITOR=nvim`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var key, value string
+		if setPass != "" || setRef != "" {
+			if setGenerate != "" || setFromFile != "" || (setPass != "" && setRef != "") {
+				return invalidf("--pass and --ref can't be combined with each other, --generate or --from-file")
+			}
+			key = args[0]
+			if strings.Contains(key, "=") {
+				return invalidf("--pass and --ref read the value from a store: give just KEY, not KEY=VALUE")
+			}
+			ref := setRef
+			if setPass != "" {
+				ref = provider.Pass + "://" + setPass
+			}
+			// Stored as written and resolved at export, so later config
+			// changes apply; check it now all the same
+			if _, err := provider.Command(ref, provider.Options{}); err != nil {
+				return invalidf("--ref: %v", err)
+			}
+			value = ref
+			setEval = true
+		} else if setGenerate != "" {
+			if setFromFile != "" || setEval {
+				return invalidf("--generate can't be combined with --from-file or --eval")
+			}
+			key = args[0]
+			if strings.Contains(key, "=") {
+				return invalidf("--generate makes the value: give just KEY, not KEY=VALUE")
+			}
+			generated, err := generate.Value(setGenerate)
+			if err != nil {
+				return invalidf("--generate: %v", err)
+			}
+			value = generated
+		} else if setFromFile != "" {
+			key = args[0]
+			data, err := readValueFile(setFromFile)
+			if err != nil {
+				return err
+			}
+			value = data
+		} else {
+			var ok bool
+			key, value, ok = shell.ParseKeyValue(args[0])
+			if !ok {
+				return invalidf("invalid format: expected KEY=VALUE")
+			}
+		}
+
+		if !shell.IsValidKey(key) {
+			return invalidf("invalid key: must match [A-Za-z_][A-Za-z0-9_]*")
+		}
+		if setRefresh < 0 || (setRefresh > 0 && setRefresh < time.Second) {
+			return invalidf("--refresh must be at least 1s, or 0 for the default")
+		}
+		if _, err := dynamic.ParseFallback(setFallback); err != nil {
+			return invalidf("--fallback: %v", err)
+		}
+		if setFallback == "fail" {
+			setFallback = ""
+		}
+		if setGlobal && setEval {
+			return invalidf("--eval can't be used with --global: global vars have no directory to run in")
+		}
+		if setEval {
+			if value = evalCommand(value); value == "" {
+				return invalidf("--eval needs a command, e.g. %s='$(git rev-parse HEAD)'", key)
+			}
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+		if setHost != "" && !env.ValidHost(setHost) {
+			return invalidf("invalid host %q: use letters, digits, -, _ or .", setHost)
+		}
+		scope := cwd
+		if setGlobal {
+			// Only trusted directories may export denylisted keys
+			var extra []string
+			if cfg, err := config.Load(); err == nil {
+				extra = cfg.DangerousKeys
+			}
+			if trust.Denied(key, extra) {
+				return invalidf("%s can't be set globally; set it at a trusted directory instead (see enva trust)", key)
+			}
+			scope = env.GlobalScope
+		}
+		if setHost != "" {
+			scope = env.HostScope(scope, setHost)
+		}
+
+		if err := resolver.CheckKeys(scope, key); err != nil {
+			return err
+		}
+
+		// Values are checked against the type given here or declared before
+		valueType := ""
+		if cmd.Flags().Changed("type") {
+			if valueType, err = valuetype.Parse(setType); err != nil {
+				return invalidf("--type: %v", err)
+			}
+		} else if existing, err := resolver.GetVar(scope, key); err == nil && existing != nil {
+			valueType = existing.Type
+		}
+		if !setEval {
+			if value, err = valuetype.Normalize(valueType, value); err != nil {
+				return invalidf("%s is declared %s: %v", key, valueType, err)
+			}
+		}
+
+		warnings, err := resolver.CheckSecrets(scope, map[string]string{key: value})
+		if err != nil {
+			return err
+		}
+		printSecretWarnings(warnings)
+		if !setEval {
+			warnLint(resolver, cwd, map[string]string{key: value})
+		}
+
+		if err := resolver.SetVar(scope, key, value, ""); err != nil {
+			return fmt.Errorf("failed to set variable: %w", err)
+		}
+
+		if cmd.Flags().Changed("if-unset") {
+			if err := resolver.SetIfUnset(scope, key, setIfUnset); err != nil {
+				return fmt.Errorf("failed to set variable: %w", err)
+			}
+		}
+		if cmd.Flags().Changed("eval") || setPass != "" || setRef != "" {
+			if err := resolver.SetEval(scope, key, setEval); err != nil {
+				return fmt.Errorf("failed to set variable: %w", err)
+			}
+		}
+		if cmd.Flags().Changed("no-export") {
+			if err := resolver.SetNoExport(scope, key, setNoExport); err != nil {
+				return fmt.Errorf("failed to set variable: %w", err)
+			}
+		}
+		if cmd.Flags().Changed("refresh") {
+			if err := resolver.SetRefresh(scope, key, int(setRefresh/time.Second)); err != nil {
+				return fmt.Errorf("failed to set variable: %w", err)
+			}
+		}
+		if cmd.Flags().Changed("fallback") {
+			if err := resolver.SetFallback(scope, key, setFallback); err != nil {
+				return fmt.Errorf("failed to set variable: %w", err)
+			}
+		}
+		if cmd.Flags().Changed("type") {
+			if err := resolver.SetType(scope, key, valueType); err != nil {
+				return fmt.Errorf("failed to set variable: %w", err)
+			}
+		}
+
+		fmt.Printf("Set %s at %s\n", key, atScope(scope, resolver.GetProfile()))
+		if !setEval && provider.IsRef(value) {
+			fmt.Fprintf(os.Stderr, "enva: %s is stored as written; use --ref to read it from the secret manager\n", key)
+		}
+		if setEval && !trustedDir(cwd) {
+			fmt.Fprintf(os.Stderr, "enva: %s won't be evaluated until this directory is trusted (enva trust)\n", key)
+		}
+		return nil
+	},
+}
+
+// passTool returns the password store command set by pass_command.
+func passTool() (string, error) {
+	tool := pass.Pass
+	if cfg, err := config.Load(); err == nil && cfg.PassCommand != "" {
+		tool = cfg.PassCommand
+	}
+	if !pass.ValidTool(tool) {
+		return "", invalidf("pass_command must be %s or %s, not %q", pass.Pass, pass.Gopass, tool)
+	}
+	return tool, nil
+}
+
+// providerOptions returns the secret manager defaults from the config.
+func providerOptions() (provider.Options, error) {
+	tool, err := passTool()
+	if err != nil {
+		return provider.Options{}, err
+	}
+	opts := provider.Options{PassTool: tool}
+	if cfg, err := config.Load(); err == nil {
+		opts.AWSRegion, opts.GCPProject = cfg.AWSRegion, cfg.GCPProject
+	}
+	return opts, nil
+}
+
+// evalCommand returns the command in an --eval value, unwrapping $( ).
+func evalCommand(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "$(") && strings.HasSuffix(value, ")") {
+		value = strings.TrimSpace(value[2 : len(value)-1])
+	}
+	return value
+}
+
+// readValueFile reads a value verbatim from path, or stdin for "-".
+func readValueFile(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read value: %w", err)
+	}
+
+	// The environment can't carry NUL bytes; refuse rather than truncate
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", invalidf("value contains NUL bytes, which environment variables can't hold")
+	}
+	return string(data), nil
+}
+
+var (
+	unsetGlobal bool
+	unsetHost   string
+)
+
+// unsetCmd deletes a variable from current directory scope
+var unsetCmd = &cobra.Command{
+	Use:   "unset KEY",
+	Short: "Remove an environment variable from current directory",
+	Long: `Remove an environment variable from the current directory scope, or with
+--global from the global scope; --host removes the var limited to that
+host instead. Removed vars can be restored by ID with enva trash restore.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := args[0]
+
+		if !shell.IsValidKey(key) {
+			return invalidf("invalid key: must match [A-Za-z_][A-Za-z0-9_]*")
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		scope := cwd
+		if unsetGlobal {
+			scope = env.GlobalScope
+		}
+		if unsetHost != "" {
+			if !env.ValidHost(unsetHost) {
+				return invalidf("invalid host %q: use letters, digits, -, _ or .", unsetHost)
+			}
+			scope = env.HostScope(scope, unsetHost)
+		}
+		if err := resolver.DeleteVar(scope, key); err != nil {
+			return fmt.Errorf("failed to unset variable: %w", err)
+		}
+		purgeExpiredTrash(database)
+
+		fmt.Printf("Unset %s at %s\n", key, atScope(scope, resolver.GetProfile()))
+		return nil
+	},
+}
+
+var batchFile string
+
+// batchOp is one set or unset line of a batch.
+type batchOp struct {
+	unset bool
+	scope string
+	key   string
+	data  db.VarData
+}
+
+// batchCmd applies several sets and unsets as one transaction
+var batchCmd = &cobra.Command{
+	Use:   "batch [-f FILE]",
+	Short: "Apply several set and unset commands in one transaction",
+	Long: `Read set and unset commands, one per line, and apply them all in a
+single database transaction: either every change lands or, if a line is
+invalid or a policy rejects a key, none does. Shells and other enva
+processes never see a half-applied batch.
+
+Lines look like the commands they stand for, minus the "enva", and act on
+the current directory unless they say --global or --host HOST. A set
+line's value is quoted as in a .env file, and a trailing # comment becomes
+the description. Blank lines and lines starting with # are skipped:
+
+  enva batch <<'EOF'
+  set DB_HOST=db.internal
+  set DB_PORT=5432 # Primary's port
+  set --global EDITOR=nvim
+  unset DB_URL
+  EOF
+
+Later lines win over earlier ones for the same key and scope. Removed vars
+go to the trash as with enva unset.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var content []byte
+		var err error
+		if batchFile == "-" {
+			content, err = io.ReadAll(os.Stdin)
+		} else {
+			content, err = os.ReadFile(batchFile)
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			return notFoundf("no such file: %s", batchFile)
+		}
+		if err != nil {
+			return err
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+		var extra []string
+		if cfg, err := config.Load(); err == nil {
+			extra = cfg.DangerousKeys
+		}
+		var ops []batchOp
+		for i, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			op, err := parseBatchLine(line, cwd)
+			if err != nil {
+				return invalidf("line %d: %v", i+1, err)
+			}
+			if dir, _ := env.SplitHost(op.scope); dir == env.GlobalScope && !op.unset && trust.Denied(op.key, extra) {
+				return invalidf("line %d: %s can't be set globally; set it at a trusted directory instead (see enva trust)", i+1, op.key)
+			}
+			ops = append(ops, op)
+		}
+		if len(ops) == 0 {
+			return invalidf("no set or unset lines to apply")
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		// Group by scope, the last line for a key winning
+		byScope := make(map[string]*db.ScopeChange)
+		var scopes []string
+		for _, op := range ops {
+			c, ok := byScope[op.scope]
+			if !ok {
+				c = &db.ScopeChange{Path: op.scope, Set: make(map[string]db.VarData)}
+				byScope[op.scope] = c
+				scopes = append(scopes, op.scope)
+			}
+			c.Delete = slices.DeleteFunc(c.Delete, func(k string) bool { return k == op.key })
+			delete(c.Set, op.key)
+			if op.unset {
+				c.Delete = append(c.Delete, op.key)
+			} else {
+				c.Set[op.key] = op.data
+			}
+		}
+
+		changes := make([]db.ScopeChange, 0, len(scopes))
+		for _, scope := range scopes {
+			c := byScope[scope]
+			if len(c.Set) > 0 {
+				keys := slices.Sorted(maps.Keys(c.Set))
+				if err := resolver.CheckKeys(scope, keys...); err != nil {
+					return err
+				}
+				values := make(map[string]string, len(c.Set))
+				for k, v := range c.Set {
+					values[k] = v.Value
+				}
+				warnings, err := resolver.CheckSecrets(scope, values)
+				if err != nil {
+					return err
+				}
+				printSecretWarnings(warnings)
+			}
+			changes = append(changes, *c)
+		}
+
+		if err := resolver.ApplyChanges(changes); err != nil {
+			return fmt.Errorf("failed to apply batch: %w", err)
+		}
+		purgeExpiredTrash(database)
+
+		for _, c := range changes {
+			for _, key := range slices.Sorted(maps.Keys(c.Set)) {
+				fmt.Printf("Set %s at %s\n", key, atScope(c.Path, resolver.GetProfile()))
+			}
+			for _, key := range c.Delete {
+				fmt.Printf("Unset %s at %s\n", key, atScope(c.Path, resolver.GetProfile()))
+			}
+		}
+		return nil
+	},
+}
+
+// parseBatchLine parses a batch line: set or unset, then --global and
+// --host HOST in any order, then KEY=VALUE or KEY. Scopes default to cwd.
+func parseBatchLine(line, cwd string) (batchOp, error) {
+	verb, rest, _ := strings.Cut(line, " ")
+	op := batchOp{unset: verb == "unset", scope: cwd}
+	if verb != "set" && verb != "unset" {
+		return op, fmt.Errorf("unknown command %q (use set or unset)", verb)
+	}
+
+	var host string
+	for {
+		rest = strings.TrimSpace(rest)
+		switch {
+		case rest == "--global" || strings.HasPrefix(rest, "--global "):
+			op.scope = env.GlobalScope
+			rest = strings.TrimPrefix(rest, "--global")
+			continue
+		case strings.HasPrefix(rest, "--host "), strings.HasPrefix(rest, "--host="):
+			rest = rest[len("--host "):]
+			host, rest, _ = strings.Cut(strings.TrimSpace(rest), " ")
+			if !env.ValidHost(host) {
+				return op, fmt.Errorf("invalid host %q: use letters, digits, -, _ or .", host)
+			}
+			continue
+		}
+		break
+	}
+	if host != "" {
+		op.scope = env.HostScope(op.scope, host)
+	}
+
+	if op.unset {
+		op.key = rest
+		if !shell.IsValidKey(op.key) {
+			return op, fmt.Errorf("invalid key %q: must match [A-Za-z_][A-Za-z0-9_]*", op.key)
+		}
+		return op, nil
+	}
+	key, parsed, ok := shell.ParseKeyValueWithDesc(rest)
+	if !ok {
+		return op, fmt.Errorf("expected set KEY=VALUE, got %q", redact.Line(line))
+	}
+	op.key = key
+	op.data = db.VarData{Value: parsed.Value, Description: parsed.Description}
+	return op, nil
+}
+
+var (
+	sqlQuery string
+	sqlJSON  bool
+)
+
+// sqlCmd runs ad-hoc read-only queries against the database
+var sqlCmd = &cobra.Command{
+	Use:   "sql",
+	Short: "Query the database read-only",
+	Long: `Run SQL against the enva database over a read-only connection, so
+statements that would change it fail. With --query, run one statement and
+exit; otherwise read statements ending in ";" from a prompt (or stdin).
+
+At the prompt, .tables lists tables, .schema [TABLE] prints their
+definitions and .quit exits. The schema is internal and may change between
+versions. Only SQLite databases can be queried.
+
+Examples:
+  enva sql -q "SELECT key, path FROM env_vars WHERE profile = 'default'"
+  enva sql -q "SELECT profile, count(*) AS vars FROM env_vars GROUP BY profile" --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		location, err := resolveDBPath()
+		if err != nil {
+			return fmt.Errorf("failed to get database path: %w", err)
+		}
+		dbPath := db.SQLitePath(location)
+		if dbPath == "" {
+			return invalidf("enva sql needs a SQLite database, not %s", location)
+		}
+		if _, err := os.Stat(dbPath); err != nil {
+			return notFoundf("no database at %s", dbPath)
+		}
+
+		if sqlQuery != "" {
+			return runSQL(dbPath, sqlQuery)
+		}
+
+		interactive := false
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			interactive = true
+			fmt.Printf("Connected read-only to %s. End statements with \";\", .quit to exit.\n", dbPath)
+		}
+		in := bufio.NewScanner(os.Stdin)
+		var stmt strings.Builder
+		for {
+			if interactive {
+				if stmt.Len() == 0 {
+					fmt.Print("enva> ")
+				} else {
+					fmt.Print("  ... ")
+				}
+			}
+			if !in.Scan() {
+				break
+			}
+			line := strings.TrimSpace(in.Text())
+			if stmt.Len() == 0 && strings.HasPrefix(line, ".") {
+				name, arg, _ := strings.Cut(line, " ")
+				var err error
+				switch name {
+				case ".quit", ".exit":
+					return nil
+				case ".tables":
+					err = runSQL(dbPath, "SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+				case ".schema":
+					query := "SELECT sql FROM sqlite_master WHERE sql IS NOT NULL"
+					if arg = strings.TrimSpace(arg); arg != "" {
+						query += " AND tbl_name = '" + strings.ReplaceAll(arg, "'", "''") + "'"
+					}
+					err = runSQL(dbPath, query+" ORDER BY tbl_name, type DESC")
+				default:
+					err = fmt.Errorf("unknown command %s (use .tables, .schema or .quit)", name)
+				}
+				if err != nil {
+					if !interactive {
+						return err
+					}
+					fmt.Fprintf(os.Stderr, "enva: %v\n", err)
+				}
+				continue
+			}
+
+			stmt.WriteString(line)
+			stmt.WriteString("\n")
+			if !strings.HasSuffix(line, ";") {
+				continue
+			}
+			query := stmt.String()
+			stmt.Reset()
+			if err := runSQL(dbPath, query); err != nil {
+				if !interactive {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "enva: %v\n", err)
+			}
+		}
+		if interactive {
+			fmt.Println()
+		}
+		if strings.TrimSpace(stmt.String()) != "" {
+			return runSQL(dbPath, stmt.String())
+		}
+		return in.Err()
+	},
+}
+
+// runSQL runs query read-only and prints its rows as a table or JSON.
+func runSQL(dbPath, query string) error {
+	res, err := db.Query(dbPath, query)
+	if err != nil {
+		return invalidf("%v", err)
+	}
+
+	if sqlJSON {
+		out := make([]map[string]any, 0, len(res.Rows))
+		for _, row := range res.Rows {
+			obj := make(map[string]any, len(row))
+			for i, v := range row {
+				obj[res.Columns[i]] = v
+			}
+			out = append(out, obj)
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	cells := make([][]string, len(res.Rows))
+	widths := make([]int, len(res.Columns))
+	for i, col := range res.Columns {
+		widths[i] = len(col)
+	}
+	for r, row := range res.Rows {
+		cells[r] = make([]string, len(row))
+		for i, v := range row {
+			cell := "NULL"
+			if v != nil {
+				cell = strings.NewReplacer("\n", `\n`, "\t", `\t`).Replace(fmt.Sprint(v))
+			}
+			cells[r][i] = cell
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	printRow := func(row []string) {
+		var b strings.Builder
+		for i, cell := range row {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			fmt.Fprintf(&b, "%-*s", widths[i], cell)
+		}
+		fmt.Println(strings.TrimRight(b.String(), " "))
+	}
+	printRow(res.Columns)
+	rule := make([]string, len(widths))
+	for i, w := range widths {
+		rule[i] = strings.Repeat("-", w)
+	}
+	printRow(rule)
+	for _, row := range cells {
+		printRow(row)
+	}
+	fmt.Printf("(%d row(s))\n", len(res.Rows))
+	return nil
+}
+
+var (
+	lsAllScopes bool
+	lsTags      []string
+	lsLong      bool
+	lsSince     string
+	lsUntil     string
+	lsSort      string
+)
+
+// lsCmd lists effective variables
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List effective environment variables",
+	Long: `List the environment variables in effect here, sorted by key.
+
+Use -l to see who last set each var and when, and --sort updated (or
+created) to list the oldest first, so stale values are easy to spot:
+
+  enva ls -l --sort updated
+  enva ls --all-scopes --sort created`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch lsSort {
+		case "key", "updated", "created":
+		default:
+			return invalidf("invalid --sort %q (use key, updated or created)", lsSort)
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		tags, err := normalizeTags(lsTags)
+		if err != nil {
+			return err
+		}
+		window, err := parseTimeWindow(lsSince, lsUntil)
+		if err != nil {
+			return err
+		}
+
+		if lsAllScopes {
+			if len(tags) > 0 {
+				return invalidf("--tag can't be combined with --all-scopes")
+			}
+			vars, err := database.GetAllVars(resolver.GetProfile())
+			if err != nil {
+				return fmt.Errorf("failed to list variables: %w", err)
+			}
+
+			headers := make(map[string]string)
+			if lsLong {
+				scopes, err := database.GetScopesWithVars(resolver.GetProfile())
+				if err != nil {
+					return fmt.Errorf("failed to list scopes: %w", err)
+				}
+				for _, sc := range scopes {
+					headers[sc.Path] = scopeSummaryLine(sc)
+				}
+			}
+
+			// Vars come sorted by path and key; keep them grouped by scope
+			sort.SliceStable(vars, func(i, j int) bool {
+				a, b := vars[i], vars[j]
+				return a.Path == b.Path && lsTime(a.UpdatedAt, a.CreatedAt).Before(lsTime(b.UpdatedAt, b.CreatedAt))
+			})
+
+			fmt.Printf("# database: %s\n", database.Path())
+			fmt.Printf("# profile: %s\n", resolver.GetProfile())
+			lastPath := ""
+			for _, v := range vars {
+				if !window.contains(v.UpdatedAt) {
+					continue
+				}
+				if v.Path != lastPath {
+					if header := headers[v.Path]; header != "" {
+						fmt.Printf("\n[%s]  # %s\n", v.Path, header)
+					} else {
+						fmt.Printf("\n[%s]\n", v.Path)
+					}
+					lastPath = v.Path
+				}
+				fmt.Println(lsLine(v.Key, shownValue(v.Value, v.Eval), v.Author, v.UpdatedAt, v.CreatedAt))
+			}
+			return nil
+		}
+
+		cwd, err := getCwd()
+		if errors.Is(err, errCwdUnavailable) {
+			fmt.Fprintf(os.Stderr, "enva: %v\n", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		ctx, err := resolver.Resolve(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to resolve environment: %w", err)
+		}
+		usageCtx = ctx
+
+		vars := filterByTime(filterByTags(ctx.GetSortedVars(), tags), window)
+		sort.SliceStable(vars, func(i, j int) bool {
+			return lsTime(vars[i].UpdatedAt, vars[i].CreatedAt).Before(lsTime(vars[j].UpdatedAt, vars[j].CreatedAt))
+		})
+		for _, v := range vars {
+			fmt.Println(lsLine(v.Key, shownValue(v.Value, v.Eval), v.Author, v.UpdatedAt, v.CreatedAt))
+		}
+		return nil
+	},
+}
+
+var (
+	searchExact         bool
+	searchCaseSensitive bool
+	searchKeysOnly      bool
+	searchMinScore      int
+)
+
+// searchCmd searches the effective vars like the TUI's /
+var searchCmd = &cobra.Command{
+	Use:   "search QUERY",
+	Short: "Search effective variables by key and value",
+	Long: `Search the variables in effect here, best match first, the way / does in
+the TUI. Matching is fuzzy and ignores case over keys and values; values
+that look like secrets only match by key. Terms like tag:NAME keep only
+vars with that tag.
+
+The search_exact, search_case_sensitive, search_keys_only and
+search_min_score config settings change the defaults for both; flags
+given here override them.`,
+	Example: `  enva search db
+  enva search --exact --keys-only _URL
+  enva search 'tag:aws region'`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := getCwd()
+		if err != nil {
+			return err
+		}
+		ctx, err := resolver.Resolve(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to resolve environment: %w", err)
+		}
+		usageCtx = ctx
+
+		opts := searchOptions()
+		opts.Local = ctx.CwdReal
+		flags := cmd.Flags()
+		if flags.Changed("exact") {
+			opts.Exact = searchExact
+		}
+		if flags.Changed("case-sensitive") {
+			opts.CaseSensitive = searchCaseSensitive
+		}
+		if flags.Changed("keys-only") {
+			opts.KeysOnly = searchKeysOnly
+		}
+		if flags.Changed("min-score") {
+			opts.MinScore = searchMinScore
+		}
+
+		results := search.SearchWith(ctx.GetSortedVars(), args[0], opts)
+		if len(results) == 0 {
+			return notFoundf("no variables match %q", args[0])
+		}
+		for _, r := range results {
+			fmt.Println(lsLine(r.Var.Key, shownValue(r.Var.Value, r.Var.Eval), r.Var.Author, r.Var.UpdatedAt, r.Var.CreatedAt))
+		}
+		return nil
+	},
+}
+
+// tuiOptions returns the configured TUI settings.
+func tuiOptions() tui.Options {
+	opts := tui.Options{Search: searchOptions()}
+	if locker, err := loadLocker(); err == nil {
+		opts.Locker = locker
+	}
+	if cfg, err := config.Load(); err == nil {
+		opts.Keys = cfg.Keys
+	}
+	return opts
+}
+
+// searchOptions returns the configured search defaults.
+func searchOptions() search.Options {
+	cfg, err := config.Load()
+	if err != nil {
+		return search.Options{}
+	}
+	return search.Options{
+		Exact:         cfg.SearchExact,
+		CaseSensitive: cfg.SearchCaseSensitive,
+		KeysOnly:      cfg.SearchKeysOnly,
+		MinScore:      cfg.SearchMinScore,
+	}
+}
+
+// shownValue displays an eval var's command the way it was declared.
+func shownValue(value string, eval bool) string {
+	if eval {
+		return "$(" + value + ")"
+	}
+	return value
+}
+
+// lsLine formats a var for ls, with its author and date under --long.
+func lsLine(key, value, author string, updated, created time.Time) string {
+	line := key + "=" + value
+	if !lsLong {
+		return line
+	}
+	if author == "" {
+		author = "unknown"
+	}
+	line = fmt.Sprintf("%s  # by %s, %s", line, author, updated.Local().Format("2006-01-02 15:04"))
+	if c := created.Local().Format("2006-01-02 15:04"); !created.IsZero() && c != updated.Local().Format("2006-01-02 15:04") {
+		line += ", created " + c
+	}
+	return line
+}
+
+// lsTime returns the time ls --sort orders by, or the zero time for key.
+func lsTime(updated, created time.Time) time.Time {
+	switch lsSort {
+	case "updated":
+		return updated
+	case "created":
+		return created
+	}
+	return time.Time{}
+}
+
+// scopeSummaryLine describes a scope for ls --all-scopes --long headers.
+func scopeSummaryLine(sc db.ScopeSummary) string {
+	noun := "vars"
+	if sc.Vars == 1 {
+		noun = "var"
+	}
+	line := fmt.Sprintf("%d %s, updated %s", sc.Vars, noun, sc.UpdatedAt.Local().Format("2006-01-02 15:04"))
+	if sc.Owner != "" {
+		line += ", owner: " + sc.Owner
+	}
+	return line
+}
+
+// timeWindow selects vars by when they were last changed. A zero bound is
+// open.
+type timeWindow struct {
+	since, until time.Time
+}
+
+// parseTimeWindow parses --since and --until values.
+func parseTimeWindow(since, until string) (timeWindow, error) {
+	var w timeWindow
+	var err error
+	if w.since, err = parseTimeBound(since, time.Now()); err != nil {
+		return w, invalidf("invalid --since: %v", err)
+	}
+	if w.until, err = parseTimeBound(until, time.Now()); err != nil {
+		return w, invalidf("invalid --until: %v", err)
+	}
+	if !w.since.IsZero() && !w.until.IsZero() && !w.since.Before(w.until) {
+		return w, invalidf("--since must be before --until")
+	}
+	return w, nil
+}
+
+// parseTimeBound reads a point in time: an age before now such as 7d, 2w
+// or 36h, or a local date or date and time such as 2026-01-31 or
+// 2026-01-31 09:00. Empty is the zero time.
+func parseTimeBound(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			if count, err := strconv.Atoi(n); err == nil && count >= 0 {
+				return now.Add(-time.Duration(count) * unit), nil
+			}
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.DateOnly, "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither an age like 7d or 36h nor a date like 2026-01-31", s)
+}
+
+// set reports whether the window has any bound.
+func (w timeWindow) set() bool {
+	return !w.since.IsZero() || !w.until.IsZero()
+}
+
+// contains reports whether t is at or after since and before until.
+func (w timeWindow) contains(t time.Time) bool {
+	return (w.since.IsZero() || !t.Before(w.since)) && (w.until.IsZero() || t.Before(w.until))
+}
+
+// filterByTime keeps the vars last changed within w.
+func filterByTime(vars []*env.ResolvedVar, w timeWindow) []*env.ResolvedVar {
+	if !w.set() {
+		return vars
+	}
+	var out []*env.ResolvedVar
+	for _, v := range vars {
+		if w.contains(v.UpdatedAt) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// normalizeTags validates and lowercases tags given on the command line.
+func normalizeTags(tags []string) ([]string, error) {
+	var out []string
+	for _, tag := range tags {
+		t, err := env.NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// filterByTags keeps vars carrying any of tags. No tags keeps everything.
+func filterByTags(vars []*env.ResolvedVar, tags []string) []*env.ResolvedVar {
+	if len(tags) == 0 {
+		return vars
+	}
+	var out []*env.ResolvedVar
+	for _, v := range vars {
+		for _, tag := range tags {
+			if v.HasTag(tag) {
+				out = append(out, v)
+				break
+			}
+		}
+	}
+	return out
+}
+
+var (
+	importPrefix      string
+	importStripPrefix string
+	importMap         []string
+	importPass        string
+	importRef         bool
+)
+
+// importChunk is how many vars import writes per transaction.
+const importChunk = 500
+
+// importCmd sets vars at the current directory from a .env file
+var importCmd = &cobra.Command{
+	Use:   "import [FILE | --pass PREFIX] [--strip-prefix P] [--prefix P] [--map OLD=NEW...]",
+	Short: "Import variables from a .env file into the current directory scope",
+	Long: `Set the KEY=value lines of FILE (default: .env) at the current
+directory, with trailing comments as descriptions. Vars already set here
+that the file doesn't mention are left alone.
+
+Keys can be renamed on the way in, to fit a vendor's .env to the project's
+naming: --strip-prefix removes a prefix from the keys that have it, then
+--prefix adds one to every key. --map OLD=NEW imports OLD as exactly NEW,
+skipping both:
+
+  enva import vendor.env --strip-prefix REACT_APP_ --prefix WEB_
+  enva import vendor.env --map REACT_APP_SENTRY=SENTRY_DSN
+
+With --pass, every entry below PREFIX in your pass store (gopass with
+pass_command in the config) is imported instead, its path below PREFIX as
+the key: project/db/password becomes DB_PASSWORD. Values are each entry's
+first line. --ref stores references instead, like enva set --pass, so the
+store is read whenever the vars are exported:
+
+  enva import --pass project --ref
+
+Large imports show their progress on a terminal and are written a chunk
+at a time. Ctrl-C stops after the current chunk, keeping what was written;
+run the same import again to finish, as vars that already match are
+skipped.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := ".env"
+		if len(args) == 1 {
+			file = args[0]
+		}
+		if importPass != "" && len(args) == 1 {
+			return invalidf("give a FILE or --pass, not both")
+		}
+		if importRef && importPass == "" {
+			return invalidf("--ref only applies to --pass")
+		}
+		km, err := parseKeyMap(importMap)
+		if err != nil {
+			return err
+		}
+		km.StripPrefix, km.Prefix = importStripPrefix, importPrefix
+
+		var parsed map[string]shell.ParsedVar
+		if importPass != "" {
+			file = "pass store " + strings.Trim(importPass, "/")
+			if parsed, err = readPassEntries(importPass, importRef); err != nil {
+				return err
+			}
+		} else {
+			content, err := os.ReadFile(file)
+			if errors.Is(err, os.ErrNotExist) {
+				return notFoundf("no such file: %s", file)
+			}
+			if err != nil {
+				return err
+			}
+			var invalid []string
+			parsed, invalid = shell.ParseEnvFileWithDesc(string(content))
+			if len(invalid) > 0 {
+				return invalidf("invalid lines in %s: %v", file, redact.Lines(invalid))
+			}
+			if len(parsed) == 0 {
+				return invalidf("no KEY=value lines in %s", file)
+			}
+		}
+		for from := range km.Rename {
+			if _, ok := parsed[from]; !ok {
+				return notFoundf("--map %s: no such key in %s", from, file)
+			}
+		}
+		renamed, err := km.Apply(parsed)
+		if err != nil {
+			return invalidf("%v", err)
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+		cwdCanon, err := envpath.Canonicalize(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to canonicalize cwd: %w", err)
+		}
+
+		values := make(map[string]string, len(renamed))
+		keys := make([]string, 0, len(renamed))
+		for k, v := range renamed {
+			values[k] = v.Value
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if err := resolver.CheckKeys(cwdCanon, keys...); err != nil {
+			return err
+		}
+		if !importRef {
+			warnings, err := resolver.CheckSecrets(cwdCanon, values)
+			if err != nil {
+				return err
+			}
+			printSecretWarnings(warnings)
+		}
+
+		// Vars that already match are skipped, so a rerun resumes an
+		// interrupted import
+		existing, err := resolver.GetLocalVarsFromDB(cwdCanon)
+		if err != nil {
+			return fmt.Errorf("failed to get local vars: %w", err)
+		}
+		current := make(map[string]db.EnvVar, len(existing))
+		for _, v := range existing {
+			current[v.Key] = v
+		}
+		pending := keys[:0:0]
+		for _, k := range keys {
+			v, ok := current[k]
+			if !ok || v.Value != renamed[k].Value || v.Description != renamed[k].Description || importRef && !v.Eval {
+				pending = append(pending, k)
+			}
+		}
+
+		sig, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		bar := progress.New(progress.Terminal(os.Stderr), "Importing", len(pending))
+		for start := 0; start < len(pending); start += importChunk {
+			if sig.Err() != nil {
+				break
+			}
+			chunk := pending[start:min(start+importChunk, len(pending))]
+			vars := make(map[string]db.VarData, len(chunk))
+			for _, k := range chunk {
+				vars[k] = db.VarData{Value: renamed[k].Value, Description: renamed[k].Description}
+			}
+			if err := resolver.SetVarsBatch(cwdCanon, vars); err != nil {
+				bar.Finish()
+				return fmt.Errorf("failed to import variables after %d of %d: %w", bar.Done(), len(pending), err)
+			}
+			if importRef {
+				for _, k := range chunk {
+					if err := resolver.SetEval(cwdCanon, k, true); err != nil {
+						bar.Finish()
+						return fmt.Errorf("failed to import variables after %d of %d: %w", bar.Done(), len(pending), err)
+					}
+				}
+			}
+			bar.Add(len(chunk))
+		}
+		bar.Finish()
+		if bar.Done() < len(pending) {
+			return fmt.Errorf("interrupted: imported %d of %d variable(s) at %s; run the same import again to finish",
+				bar.Done(), len(pending), atScope(cwdCanon, resolver.GetProfile()))
+		}
+
+		fmt.Printf("Imported %d variable(s) at %s", len(pending), atScope(cwdCanon, resolver.GetProfile()))
+		if unchanged := len(keys) - len(pending); unchanged > 0 {
+			fmt.Printf(", %d already up to date", unchanged)
+		}
+		fmt.Println()
+		from := make([]string, 0, len(parsed))
+		for k := range parsed {
+			from = append(from, k)
+		}
+		sort.Strings(from)
+		for _, k := range from {
+			if to := km.Key(k); to != k {
+				fmt.Printf("  %s as %s\n", k, to)
+			}
+		}
+		return nil
+	},
+}
+
+// readPassEntries reads the entries below prefix in the pass store as vars
+// keyed by pass.Key. With ref, values are commands that read the entry
+// rather than the entry itself.
+func readPassEntries(prefix string, ref bool) (map[string]shell.ParsedVar, error) {
+	tool, err := passTool()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := pass.List(tool, prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, notFoundf("no such folder in the password store: %s", prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, notFoundf("no entries below %s in the password store", prefix)
+	}
+
+	// Reading each entry runs the store, which adds up for big folders
+	var out io.Writer
+	if !ref {
+		out = progress.Terminal(os.Stderr)
+	}
+	bar := progress.New(out, "Reading "+tool, len(entries))
+	defer bar.Finish()
+
+	vars := make(map[string]shell.ParsedVar, len(entries))
+	for _, entry := range entries {
+		key := pass.Key(entry, prefix)
+		if !shell.IsValidKey(key) {
+			return nil, invalidf("%s can't be a key (%s): rename the entry or import it with enva set --pass", entry, key)
+		}
+		if prev, ok := vars[key]; ok {
+			return nil, invalidf("%s and %s both import as %s", prev.Description, entry, key)
+		}
+		value := provider.Pass + "://" + entry
+		if !ref {
+			if value, err = pass.Show(tool, entry); err != nil {
+				return nil, err
+			}
+		}
+		vars[key] = shell.ParsedVar{Value: value, Description: entry}
+		bar.Add(1)
+	}
+	return vars, nil
+}
+
+var editScopeRoot bool
+
+// editCmd opens $EDITOR for editing local vars
+var editCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edit local environment variables in $EDITOR",
+	Long: `Opens $EDITOR with KEY=VALUE lines for local variables at the current
+directory. After saving, parses the file and applies changes (upserts/deletes).
+
+With --scope-root, opens one TOML document with a section for every
+directory from the project root down to the current one instead:
+
+  ["/home/me/app"]
+  DB_HOST = "localhost"  # Primary database
+
+  ["/home/me/app/api"]
+  PORT = "8080"
+
+Move a line to another section to move the var there, with its flags and
+tags; delete a line to unset it. All changes are applied in one
+transaction, or none are if a scope changed while the editor was open.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		editor := os.Getenv("EDITOR")
+		if editor == "" {
+			editor = "vi"
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		if editScopeRoot {
+			return editChain(database, resolver, editor, cwd)
+		}
+
+		cwdCanon, err := envpath.Canonicalize(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to canonicalize cwd: %w", err)
+		}
+
+		// Get current local vars
+		localVars, err := resolver.GetLocalVarsFromDB(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to get local vars: %w", err)
+		}
+		base := varDataMap(localVars)
+
+		// Build content (descriptions round-trip as trailing comments)
+		var lines []string
+		sort.Slice(localVars, func(i, j int) bool {
+			return localVars[i].Key < localVars[j].Key
+		})
+		for _, v := range localVars {
+			value := v.Value
+			if !v.Eval {
+				value = valuetype.Inline(v.Type, value)
+			}
+			lines = append(lines, shell.FormatWithComment(shell.FormatKeyValue(v.Key, value), v.Description, shell.CommentsTrailing))
+		}
+		content := strings.Join(lines, "\n")
+		if content != "" {
+			content += "\n"
+		}
+
+		// Create temp file
+		tmpFile, err := os.CreateTemp("", "enva-edit-*.env")
+		if err != nil {
+			return fmt.Errorf("failed to create temp file: %w", err)
+		}
+		tmpPath := tmpFile.Name()
+		defer os.Remove(tmpPath)
+
+		if _, err := tmpFile.WriteString(content); err != nil {
+			tmpFile.Close()
+			return fmt.Errorf("failed to write temp file: %w", err)
+		}
+		tmpFile.Close()
+
+		// Open editor
+		editorCmd := exec.Command(editor, tmpPath)
+		editorCmd.Stdin = os.Stdin
+		editorCmd.Stdout = os.Stdout
+		editorCmd.Stderr = os.Stderr
+
+		if err := editorCmd.Run(); err != nil {
+			return fmt.Errorf("editor failed: %w", err)
+		}
+
+		// Read updated content
+		newContent, err := os.ReadFile(tmpPath)
+		if err != nil {
+			return fmt.Errorf("failed to read temp file: %w", err)
+		}
+
+		// Parse new content with descriptions
+		parsed, invalid := shell.ParseEnvFileWithDesc(string(newContent))
+		if len(invalid) > 0 {
+			return invalidf("invalid lines in file: %v", redact.Lines(invalid))
+		}
+
+		// Convert to db.VarData, holding typed keys to their declared type
+		declared := make(map[string]string)
+		for _, v := range localVars {
+			if v.Type != "" && !v.Eval {
+				declared[v.Key] = v.Type
+			}
+		}
+		newVars := make(map[string]db.VarData)
+		var keys []string
+		for k, v := range parsed {
+			value := v.Value
+			if t, ok := declared[k]; ok {
+				if value, err = valuetype.Normalize(t, value); err != nil {
+					return invalidf("%s is declared %s: %v", k, t, err)
+				}
+			}
+			newVars[k] = db.VarData{Value: value, Description: v.Description}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		if err := resolver.CheckKeys(cwdCanon, keys...); err != nil {
+			return err
+		}
+
+		values := make(map[string]string)
+		for k, v := range newVars {
+			values[k] = v.Value
+		}
+		warnings, err := resolver.CheckSecrets(cwdCanon, values)
+		if err != nil {
+			return err
+		}
+		printSecretWarnings(warnings)
+
+		// Someone else may have changed this scope while the editor was open
+		currentVars, err := resolver.GetLocalVarsFromDB(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to get local vars: %w", err)
+		}
+		theirs := varDataMap(currentVars)
+		if !maps.Equal(base, theirs) {
+			res := merge.ThreeWay(base, newVars, theirs)
+			if len(res.Theirs) > 0 {
+				fmt.Fprintf(os.Stderr, "enva: kept concurrent changes to %s\n", strings.Join(res.Theirs, ", "))
+			}
+			if len(res.Conflicts) > 0 {
+				if err := resolveConflicts(res); err != nil {
+					return err
+				}
+			}
+			newVars = res.Merged
+		}
+
+		// Sync vars
+		if err := resolver.SyncLocalVars(cwdCanon, newVars); err != nil {
+			return fmt.Errorf("failed to sync vars: %w", err)
+		}
+
+		fmt.Printf("Updated local vars at %s\n", atScope(cwdCanon, resolver.GetProfile()))
+		return nil
+	},
+}
+
+// editChainHeader opens the document enva edit --scope-root writes.
+const editChainHeader = `# Vars of each directory from the project root down to here, profile %s.
+# Move a line to another section to move the var there, with its flags and
+# tags; delete a line to unset it. A trailing # comment is the description.
+# A section whose header is removed is left as it is. Changes are applied
+# together when you save and quit.
+`
+
+// editChain edits the vars of every directory from the project root to cwd
+// as one TOML document and applies the result in a single transaction.
+func editChain(database db.Store, resolver *env.Resolver, editor, cwd string) error {
+	ctx, err := resolver.Resolve(cwd)
+	if err != nil {
+		return fmt.Errorf("failed to resolve environment: %w", err)
+	}
+	chain := envpath.BuildChainCanonical(ctx.RootDir, ctx.CwdReal)
+
+	loadChain := func() (map[string]map[string]db.EnvVar, error) {
+		scopes := make(map[string]map[string]db.EnvVar, len(chain))
+		for _, dir := range chain {
+			vars, err := resolver.GetLocalVarsFromDB(dir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get local vars: %w", err)
+			}
+			scopes[dir] = make(map[string]db.EnvVar, len(vars))
+			for _, v := range vars {
+				scopes[dir][v.Key] = v
+			}
+		}
+		return scopes, nil
+	}
+	before, err := loadChain()
+	if err != nil {
+		return err
+	}
+
+	sections := make([]scopedoc.Section, 0, len(chain))
+	for _, dir := range chain {
+		s := scopedoc.Section{Path: dir}
+		for _, key := range slices.Sorted(maps.Keys(before[dir])) {
+			v := before[dir][key]
+			value := v.Value
+			if !v.Eval {
+				value = valuetype.Inline(v.Type, value)
+			}
+			s.Vars = append(s.Vars, scopedoc.Var{Key: key, Value: value, Description: v.Description})
+		}
+		sections = append(sections, s)
+	}
+	content := scopedoc.Format(fmt.Sprintf(editChainHeader, resolver.GetProfile()), sections)
+
+	tmpFile, err := os.CreateTemp("", "enva-edit-*.toml")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	keep := false
+	defer func() {
+		if !keep {
+			os.Remove(tmpPath)
+		}
+	}()
+	if _, err := tmpFile.WriteString(content); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	tmpFile.Close()
+
+	editorCmd := exec.Command(editor, tmpPath)
+	editorCmd.Stdin = os.Stdin
+	editorCmd.Stdout = os.Stdout
+	editorCmd.Stderr = os.Stderr
+	if err := editorCmd.Run(); err != nil {
+		return fmt.Errorf("editor failed: %w", err)
+	}
+	newContent, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to read temp file: %w", err)
+	}
+	parsed, err := scopedoc.Parse(string(newContent))
+	if err != nil {
+		return invalidf("%v", err)
+	}
+
+	// Where each key lives before and after the edit, in chain order. A
+	// section left out of the document is left alone rather than emptied,
+	// so a stray deleted header can't unset a whole level.
+	after := make(map[string]map[string]scopedoc.Var, len(chain))
+	var invalid []string
+	for _, s := range parsed {
+		if !slices.Contains(chain, s.Path) {
+			return invalidf("[%q] isn't a directory between the project root and here", s.Path)
+		}
+		after[s.Path] = make(map[string]scopedoc.Var, len(s.Vars))
+		for _, v := range s.Vars {
+			if !shell.IsValidKey(v.Key) {
+				invalid = append(invalid, fmt.Sprintf("%q in [%q]", v.Key, s.Path))
+			}
+			after[s.Path][v.Key] = v
+		}
+	}
+	if len(invalid) > 0 {
+		return invalidf("invalid keys, must match [A-Za-z_][A-Za-z0-9_]*: %s; no changes saved", strings.Join(invalid, ", "))
+	}
+	untouched := make(map[string]bool)
+	for _, dir := range chain {
+		if _, ok := after[dir]; !ok {
+			untouched[dir] = true
+			if len(before[dir]) > 0 {
+				fmt.Fprintf(os.Stderr, "enva: no section for %s; leaving its vars as they are\n", atScope(dir, resolver.GetProfile()))
+			}
+		}
+	}
+	keys := make(map[string]bool)
+	for _, dir := range chain {
+		for key := range before[dir] {
+			keys[key] = true
+		}
+		for key := range after[dir] {
+			keys[key] = true
+		}
+	}
+
+	changes := make(map[string]*db.ScopeChange, len(chain))
+	change := func(dir string) *db.ScopeChange {
+		if changes[dir] == nil {
+			changes[dir] = &db.ScopeChange{Path: dir, Set: make(map[string]db.VarData), Move: make(map[string]string)}
+		}
+		return changes[dir]
+	}
+	type moved struct{ key, from, to string }
+	var moves []moved
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		// Pair each level the key left with one it appeared at as a move
+		var gone, added []string
+		for _, dir := range chain {
+			if untouched[dir] {
+				continue
+			}
+			_, was := before[dir][key]
+			_, is := after[dir][key]
+			if was && !is {
+				gone = append(gone, dir)
+			} else if is && !was {
+				added = append(added, dir)
+			}
+		}
+		source := make(map[string]db.EnvVar)
+		for i, dir := range gone {
+			if i < len(added) {
+				change(dir).Move[key] = added[i]
+				source[added[i]] = before[dir][key]
+				moves = append(moves, moved{key, dir, added[i]})
+			} else {
+				change(dir).Delete = append(change(dir).Delete, key)
+			}
+		}
+
+		for _, dir := range chain {
+			v, ok := after[dir][key]
+			if !ok {
+				continue
+			}
+			old, had := before[dir][key]
+			if src, ok := source[dir]; ok {
+				old, had = src, true
+			}
+			value := v.Value
+			if had && old.Type != "" && !old.Eval {
+				if value, err = valuetype.Normalize(old.Type, value); err != nil {
+					return invalidf("%s is declared %s: %v", key, old.Type, err)
+				}
+			}
+			if !had || value != old.Value || v.Description != old.Description {
+				change(dir).Set[key] = db.VarData{Value: value, Description: v.Description}
+			}
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+		return nil
+	}
+
+	var ordered []db.ScopeChange
+	for _, dir := range chain {
+		c := changes[dir]
+		if c == nil {
+			continue
+		}
+		incoming := slices.Sorted(maps.Keys(c.Set))
+		for _, m := range moves {
+			if m.to == dir && !slices.Contains(incoming, m.key) {
+				incoming = append(incoming, m.key)
+			}
+		}
+		if len(incoming) > 0 {
+			if err := resolver.CheckKeys(dir, incoming...); err != nil {
+				return err
+			}
+		}
+		if len(c.Set) > 0 {
+			values := make(map[string]string, len(c.Set))
+			for k, v := range c.Set {
+				values[k] = v.Value
+			}
+			warnings, err := resolver.CheckSecrets(dir, values)
+			if err != nil {
+				return err
+			}
+			printSecretWarnings(warnings)
+		}
+		ordered = append(ordered, *c)
+	}
+
+	// Moves make a three-way merge ambiguous, so a concurrent change to
+	// any level stops the edit; the document is kept to redo it from
+	current, err := loadChain()
+	if err != nil {
+		return err
+	}
+	for _, dir := range chain {
+		if !maps.EqualFunc(before[dir], current[dir], func(a, b db.EnvVar) bool {
+			return a.Value == b.Value && a.Description == b.Description && a.Eval == b.Eval && a.Type == b.Type
+		}) {
+			keep = true
+			return fmt.Errorf("%s changed while editing; no changes saved (your edit is in %s)", atScope(dir, resolver.GetProfile()), tmpPath)
+		}
+	}
+
+	if err := resolver.ApplyChanges(ordered); err != nil {
+		return fmt.Errorf("failed to apply changes: %w", err)
+	}
+	purgeExpiredTrash(database)
+
+	for _, m := range moves {
+		fmt.Printf("Moved %s from %s to %s\n", m.key, atScope(m.from, resolver.GetProfile()), atScope(m.to, resolver.GetProfile()))
+	}
+	for _, c := range ordered {
+		for _, key := range slices.Sorted(maps.Keys(c.Set)) {
+			fmt.Printf("Set %s at %s\n", key, atScope(c.Path, resolver.GetProfile()))
+		}
+		for _, key := range c.Delete {
+			fmt.Printf("Unset %s at %s\n", key, atScope(c.Path, resolver.GetProfile()))
+		}
+	}
+	return nil
+}
+
+// varDataMap indexes vars by key for merging.
+func varDataMap(vars []db.EnvVar) map[string]db.VarData {
+	m := make(map[string]db.VarData, len(vars))
+	for _, v := range vars {
+		m[v.Key] = db.VarData{Value: v.Value, Description: v.Description}
+	}
+	return m
+}
+
+// formatSide describes one side of an edit conflict.
+func formatSide(v *db.VarData) string {
+	if v == nil {
+		return "(deleted)"
+	}
+	return shell.FormatWithComment(strconv.Quote(v.Value), v.Description, shell.CommentsTrailing)
+}
+
+// resolveConflicts asks the user to pick a side for each conflicting key
+// and records the choices in res.Merged. Without a terminal to ask on, it
+// fails rather than overwrite either side.
+func resolveConflicts(res merge.Result) error {
+	var keys []string
+	for _, c := range res.Conflicts {
+		keys = append(keys, c.Key)
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("scope changed while editing; conflicting keys: %s", strings.Join(keys, ", "))
+	}
+
+	fmt.Printf("This scope changed while you were editing. %d conflicting key(s):\n", len(res.Conflicts))
+	in := bufio.NewReader(os.Stdin)
+	all := ""
+	for _, c := range res.Conflicts {
+		fmt.Printf("\n%s\n", c.Key)
+		fmt.Printf("  was:    %s\n", formatSide(c.Base))
+		fmt.Printf("  mine:   %s\n", formatSide(c.Mine))
+		fmt.Printf("  theirs: %s\n", formatSide(c.Theirs))
+
+		choice := all
+		for choice == "" {
+			fmt.Print("Keep [m]ine, [t]heirs, [M]ine for all, [T]heirs for all, or [q]uit? ")
+			line, err := in.ReadString('\n')
+			if err != nil {
+				return fmt.Errorf("aborted: no changes saved")
+			}
+			switch strings.TrimSpace(line) {
+			case "m":
+				choice = "m"
+			case "t":
+				choice = "t"
+			case "M":
+				choice, all = "m", "m"
+			case "T":
+				choice, all = "t", "t"
+			case "q":
+				return fmt.Errorf("aborted: no changes saved")
+			}
+		}
+		merge.Resolve(res.Merged, c, choice == "m")
+	}
+	fmt.Println()
+	return nil
+}
+
+// promptMissingKeys asks on the terminal for each key the project's .enva
+// file requires that environ lacks, offers to save the answers at the
+// current directory, and returns them as KEY=value entries. Without a
+// terminal it fails, naming the missing keys.
+func promptMissingKeys(resolver *env.Resolver, ctx *env.ResolveContext, environ []string) ([]string, error) {
+	keys, err := manifest.Load(ctx.RootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read required keys: %w", err)
+	}
+	missing := manifest.Missing(keys, environ)
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	names := make([]string, len(missing))
+	for i, k := range missing {
+		names[i] = k.Name
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil, notFoundf("missing required key(s): %s", strings.Join(names, ", "))
+	}
+
+	fmt.Fprintf(os.Stderr, "%d key(s) required by %s aren't set:\n", len(missing), filepath.Join(ctx.RootDir, manifest.FileName))
+	in := bufio.NewReader(os.Stdin)
+	vars := make(map[string]db.VarData, len(missing))
+	values := make(map[string]string, len(missing))
+	for _, k := range missing {
+		prompt := k.Name
+		if k.Description != "" {
+			prompt += " (" + k.Description + ")"
+		}
+		fmt.Fprintf(os.Stderr, "%s: ", prompt)
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("aborted: command not run")
+		}
+		values[k.Name] = strings.TrimRight(line, "\r\n")
+		vars[k.Name] = db.VarData{Value: values[k.Name], Description: k.Description}
+	}
+
+	fmt.Fprintf(os.Stderr, "Save them at %s for next time? [y/N] ", atScope(ctx.CwdReal, resolver.GetProfile()))
+	if line, _ := in.ReadString('\n'); strings.EqualFold(strings.TrimSpace(line), "y") {
+		if err := resolver.CheckKeys(ctx.CwdReal, names...); err != nil {
+			return nil, err
+		}
+		warnings, err := resolver.CheckSecrets(ctx.CwdReal, values)
+		if err != nil {
+			return nil, err
+		}
+		printSecretWarnings(warnings)
+		if err := resolver.SetVarsBatch(ctx.CwdReal, vars); err != nil {
+			return nil, fmt.Errorf("failed to save variables: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Saved %d variable(s)\n", len(vars))
+	}
+
+	answers := make([]string, 0, len(missing))
+	for _, name := range names {
+		answers = append(answers, name+"="+values[name])
+	}
+	return answers, nil
+}
+
+// runCmd executes a command with the effective environment
+var runCmd = &cobra.Command{
+	Use:   "run [--prompt-missing] [--output-ref[=VAR]] [-- COMMAND | NAME] [ARGS...]",
+	Short: "Run a command or task with effective environment",
+	Long: `Executes the given command with the effective environment variables
+merged into the current process environment.
+
+Without --, a NAME defined with 'enva task' runs that task's command
+with sh, with any ARGS appended; --list shows the tasks in effect here.
+After --, the command always runs as given, even if a task has its name:
+
+  enva run build
+  enva run test:unit -run TestParse
+  enva run --list
+
+With --prompt-missing, keys the project requires (listed in its .enva
+file, one per line) that still aren't set are asked for first, and can be
+saved at the current directory for next time:
+
+  enva run --prompt-missing -- npm run dev
+
+With --output-ref, the vars are also written to a .env file only you can
+read, and its path is set in ENVA_ENV_FILE (or --output-ref=VAR) for tools
+that want a file rather than their environment. The file is removed when
+the command exits:
+
+  enva run --output-ref -- sh -c 'docker compose --env-file "$ENVA_ENV_FILE" up'`,
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Find -- separator; options before it are run's own
+		cmdArgs, opts := args, []string(nil)
+		for i, arg := range args {
+			if arg == "--" {
+				opts, cmdArgs = args[:i], args[i+1:]
+				break
+			}
+		}
+		separated := opts != nil
+		for !separated && len(cmdArgs) > 0 && isRunOption(cmdArgs[0]) {
+			opts = append(opts, cmdArgs[0])
+			cmdArgs = cmdArgs[1:]
+		}
+		promptMissing := slices.Contains(opts, "--prompt-missing")
+		list := slices.Contains(opts, "--list")
+		var outputRef string
+		for _, opt := range opts {
+			if opt == "--output-ref" {
+				outputRef = defaultEnvFileVar
+			} else if name, ok := strings.CutPrefix(opt, "--output-ref="); ok {
+				outputRef = name
+			}
+		}
+		if outputRef != "" && !shell.IsValidKey(outputRef) {
+			return invalidf("invalid variable name for --output-ref: %q", outputRef)
+		}
+
+		if len(cmdArgs) == 0 && !list {
+			return invalidf("no command specified")
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		ctx, err := resolver.Resolve(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to resolve environment: %w", err)
+		}
+		usageCtx = ctx
+		if list {
+			if len(cmdArgs) > 0 {
+				return invalidf("--list takes no command")
+			}
+			printTasks(ctx)
+			return nil
+		}
+		ctx.StripNoExport()
+		warnBlocked(stripUntrusted(ctx))
+		warnEvalFailed(evalRunnerFor(ctx).Apply(ctx))
+
+		environ := effectiveEnviron(ctx)
+		var answers []string
+		if promptMissing {
+			answers, err = promptMissingKeys(resolver, ctx, environ)
+			if err != nil {
+				return err
+			}
+			environ = append(environ, answers...)
+		}
+
+		// A task runs its command line with sh; "$@" passes the rest on
+		if task, ok := ctx.Tasks[cmdArgs[0]]; ok && !separated {
+			line := task.Command
+			if len(cmdArgs) > 1 {
+				line += ` "$@"`
+			}
+			cmdArgs = append([]string{"sh", "-c", line, task.Name}, cmdArgs[1:]...)
+		}
+
+		// Find command path
+		cmdPath, err := exec.LookPath(cmdArgs[0])
+		if err != nil {
+			return notFoundf("command not found: %s", cmdArgs[0])
+		}
+
+		if outputRef != "" {
+			vars := ctx.ApplicableVars(os.LookupEnv, loadedKeys())
+			return runWithEnvFile(cmdPath, cmdArgs, environ, outputRef, vars, answers)
+		}
+
+		// Exec replaces this process, so PersistentPostRun never fires
+		logUsage(cmd)
+		return syscall.Exec(cmdPath, cmdArgs, environ)
+	},
+}
+
+// isRunOption reports whether arg is one of run's own options.
+func isRunOption(arg string) bool {
+	return arg == "--prompt-missing" || arg == "--list" || arg == "--output-ref" || strings.HasPrefix(arg, "--output-ref=")
+}
+
+// runWithEnvFile runs the command with vars and the prompted answers also
+// written to a private env file, whose path is set in refVar. The file is
+// removed once the command exits, and its exit status is passed through.
+func runWithEnvFile(cmdPath string, cmdArgs, environ []string, refVar string, vars []*env.ResolvedVar, answers []string) error {
+	dir, err := os.MkdirTemp("", "enva-run-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "env")
+	if err := writeEnvFile(path, vars, answers); err != nil {
+		return err
+	}
+
+	child := exec.Command(cmdPath, cmdArgs[1:]...)
+	child.Args[0] = cmdArgs[0]
+	child.Env = append(environ, refVar+"="+path)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", cmdArgs[0], err)
+	}
+
+	// Stay alive to remove the file: Ctrl+C reaches the command through
+	// the terminal, and termination is handed on to it
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			if sig != os.Interrupt {
+				_ = child.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err = child.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitStatus = max(exitErr.ExitCode(), exitFailure)
+		return nil
+	}
+	return err
+}
+
+var (
+	tuiPath       string
+	tuiRenderOnce bool
+	tuiKeys       bool
+	tuiWidth      int
+	tuiHeight     int
+)
+
+// tuiCmd launches the TUI
+var tuiCmd = &cobra.Command{
+	Use:   "tui [--path DIR] [--profile PROFILE]",
+	Short: "Launch interactive TUI",
+	Long: `Launch the interactive TUI for the current directory, or for --path.
+
+With --render-once, the TUI is drawn a single time at --width x --height
+and printed to stdout instead, so packagers and tests can check the
+layout without a terminal.
+
+Keybindings can be changed with "keys" in the config, mapping action
+names to the keys that trigger them, which replace the defaults:
+
+  "keys": {"delete": ["d"], "edit": ["enter", "l"]}
+
+--keys lists every action and its keys and reports unknown actions,
+invalid keys and keys bound twice, exiting 3 if there are any.`,
+	Example: `  enva tui
+  enva tui --path ~/work/api --profile production
+  enva tui --render-once --width 80 --height 24
+  enva tui --keys`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if tuiKeys {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			lines, problems := tui.CheckKeys(cfg.Keys)
+			for _, line := range lines {
+				fmt.Println(line)
+			}
+			if len(problems) > 0 {
+				for _, p := range problems {
+					fmt.Fprintf(os.Stderr, "enva: keys: %s\n", p)
+				}
+				return invalidf("%d problem(s) with \"keys\" in the config", len(problems))
+			}
+			return nil
+		}
+
+		cwd := tuiPath
+		if cwd != "" {
+			info, err := os.Stat(cwd)
+			if err != nil {
+				return fmt.Errorf("invalid path: %w", err)
+			}
+			if !info.IsDir() {
+				return invalidf("invalid path: %s is not a directory", cwd)
+			}
+		} else {
+			var err error
+			if cwd, err = os.Getwd(); err != nil {
+				return fmt.Errorf("failed to get cwd: %w", err)
+			}
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		if tuiRenderOnce {
+			frame, err := tui.RenderOnce(database, resolver, cwd, tuiWidth, tuiHeight)
+			if err != nil {
+				return err
+			}
+			fmt.Println(frame)
+			return nil
+		}
+
+		// Stderr warnings would corrupt the TUI screen
+		envpath.OnMissing = nil
+
+		return tui.Run(database, resolver, cwd, tuiOptions())
+	},
+}
+
+var (
+	applyFile   string
+	applyPatch  string
+	applyDryRun bool
+	applyForce  bool
+)
+
+// applyCmd applies a declarative JSON change document
+var applyCmd = &cobra.Command{
+	Use:   "apply -f FILE | --patch FILE",
+	Short: "Apply a JSON document of changes transactionally",
+	Long: `Apply a declarative JSON document of per-scope sets and deletions in a
+single transaction. Either every change is applied or none is.
+
+  {
+    "scopes": [
+      {
+        "path": "~/projects/app",
+        "profile": "default",
+        "set": {"API_URL": "http://localhost", "TOKEN": {"value": "x", "description": "API token"}},
+        "unset": ["OLD_KEY"]
+      }
+    ]
+  }
+
+Scopes without a profile use the active profile (--profile or ENVA_PROFILE).
+
+--patch applies a patch instead, the add, change and remove ops enva diff
+--json prints or another tool computes. Each op names its scope, profile
+and key, and may give the old value and description it expects; if any
+op doesn't match the database, nothing is applied and enva exits 6 (use
+--force to apply anyway).
+
+Either way, keys are checked against the naming and secrets policies of
+the scope they're set at, as with set; if any is rejected, nothing is
+applied. The summary counts changes to each scope's own vars, so a key
+also set in a parent scope counts as added, and its unset as deleted,
+even though the parent's value still applies:
+
+  enva diff --json > changes.json
+  enva apply --patch changes.json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (applyFile == "") == (applyPatch == "") {
+			return invalidf("give a change document with -f or a patch with --patch")
+		}
+		if applyForce && applyPatch == "" {
+			return invalidf("--force only applies to --patch")
+		}
+		file := applyFile + applyPatch
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		var doc *apply.Document
+		var patch *apply.Patch
+		if applyPatch != "" {
+			if patch, err = apply.ParsePatch(data); err != nil {
+				return invalidf("%v", err)
+			}
+		} else if doc, err = apply.Parse(data); err != nil {
+			return err
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		// The same naming and secrets policies as set and import, per scope
+		check := func(path string, set map[string]string) error {
+			if err := resolver.CheckKeys(path, slices.Sorted(maps.Keys(set))...); err != nil {
+				return err
+			}
+			warnings, err := resolver.CheckSecrets(path, set)
+			if err != nil {
+				return err
+			}
+			printSecretWarnings(warnings)
+			return nil
+		}
+
+		var summary *apply.Summary
+		if patch != nil {
+			summary, err = apply.ApplyPatch(database, patch, resolver.GetProfile(), applyForce, applyDryRun, check)
+			var conflict *apply.ConflictError
+			if errors.As(err, &conflict) {
+				return &codedError{exitDrift, fmt.Errorf("nothing applied: %w", err)}
+			}
+		} else {
+			summary, err = apply.Apply(database, doc, resolver.GetProfile(), applyDryRun, check)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply changes: %w", err)
+		}
+		if !applyDryRun {
+			purgeExpiredTrash(database)
+		}
+
+		for _, c := range summary.Changes {
+			if c.Kind != apply.ChangeUnchanged {
+				fmt.Println(c)
+			}
+		}
+
+		verb := "Applied"
+		if applyDryRun {
+			verb = "Would apply"
+		}
+		fmt.Printf("%s: %d added, %d updated, %d deleted, %d unchanged (at the listed scopes, not counting inherited vars)\n",
+			verb, summary.Added, summary.Updated, summary.Deleted, summary.Unchanged)
+		return nil
+	},
+}
+
+var (
+	diffJSON    bool
+	diffReverse bool
+)
+
+// diffCmd compares the database with a sync remote
+var diffCmd = &cobra.Command{
+	Use:   "diff [REMOTE]",
+	Short: "List the differences between the database and a sync remote",
+	Long: `List the keys that differ between the database and what was last pushed
+to REMOTE (default: sync_remote in the config), as the changes that would
+turn the database into the remote's copy: + added, ~ changed, - removed.
+--reverse lists the changes that turn the remote's copy into the database.
+
+Unlike sync pull, nothing is merged and no base is consulted: it is a plain
+comparison of values and descriptions. Flags and tags aren't compared.
+
+--json prints the differences as a patch that enva apply --patch reads,
+so other tools can inspect or rewrite changes before applying them. Ops
+are sorted by scope, profile and key, so the same differences always give
+the same bytes:
+
+  {
+    "version": 1,
+    "ops": [
+      {"op": "add", "scope": "/home/me/app", "profile": "default",
+       "key": "PORT", "value": "8080"},
+      {"op": "change", "scope": "/home/me/app", "profile": "default",
+       "key": "DEBUG", "value": "0", "description": "",
+       "old_value": "1", "old_description": ""},
+      {"op": "remove", "scope": "@global", "profile": "default",
+       "key": "OLD", "old_value": "x", "old_description": ""}
+    ]
+  }
+
+enva exits 6 if there are differences.`,
+	Example: `  enva diff
+  enva diff ~/Dropbox/enva.json --json > changes.json
+  enva apply --patch changes.json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := openSync(args)
+		if err != nil {
+			return err
+		}
+		defer s.database.Close()
+
+		var remote []dbsync.Var
+		data, err := s.remote.Get()
+		switch {
+		case errors.Is(err, dbsync.ErrNoBundle):
+			// Nothing pushed yet: the remote is empty
+		case err != nil:
+			return fmt.Errorf("failed to read remote: %w", err)
+		default:
+			bundle, err := dbsync.DecodeBundle(data)
+			if err != nil {
+				return err
+			}
+			remote = bundle.Vars
+		}
+		local, err := dbsync.Snapshot(s.database)
+		if err != nil {
+			return fmt.Errorf("failed to read database: %w", err)
+		}
+
+		from, to := patchEntries(local), patchEntries(remote)
+		if diffReverse {
+			from, to = to, from
+		}
+		patch := apply.Diff(from, to)
+
+		if diffJSON {
+			out, err := patch.Encode()
+			if err != nil {
+				return err
+			}
+			os.Stdout.Write(out)
+		} else {
+			for _, op := range patch.Ops {
+				fmt.Println(op)
+			}
+		}
+		if len(patch.Ops) > 0 {
+			exitStatus = exitDrift
+		}
+		return nil
+	},
+}
+
+// patchEntries converts sync vars to the entries apply.Diff compares.
+func patchEntries(vars []dbsync.Var) []apply.Entry {
+	entries := make([]apply.Entry, len(vars))
+	for i, v := range vars {
+		entries[i] = apply.Entry{Scope: v.Path, Profile: v.Profile, Key: v.Key, Value: v.Value, Description: v.Description}
+	}
+	return entries
+}
+
+var (
+	policyPrefix  string
+	policySnake   bool
+	policyBanned  []string
+	policySecrets string
+)
+
+// policyCmd shows the effective naming policy
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Show the key naming policy for current directory",
+	Long: `Show the key naming policy that applies at the current directory.
+
+Policies are stored on a scope and apply to it and every directory below it
+within the project. Banned keys add up down the chain; the prefix and case
+rule come from the closest scope with either, and the secrets mode from the
+closest scope with one. Policies are
+enforced by set, edit and the TUI.
+
+Values that look like credentials (AWS keys, GitHub tokens, private keys)
+produce a warning by default; use --secrets block to refuse them.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		p, definedAt, err := resolver.EffectivePolicy(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to get policy: %w", err)
+		}
+
+		if definedAt == "" {
+			fmt.Println("No policy")
+			return nil
+		}
+		fmt.Printf("%s (set at %s)\n", p, definedAt)
+		return nil
+	},
+}
+
+// policySetCmd sets the naming policy at current directory scope
+var policySetCmd = &cobra.Command{
+	Use:   "set [--prefix P] [--screaming-snake] [--ban KEY...]",
+	Short: "Set the key naming policy at current directory",
+	Long: `Set rules of the naming policy at the current directory. Only the rules
+given change; the others this scope already has are kept. --ban replaces
+the scope's banned list.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if policySecrets != "" && !policy.IsValidSecretsMode(policySecrets) {
+			return invalidf("invalid --secrets mode %q (supported: warn, block, off)", policySecrets)
+		}
+
+		flags := cmd.Flags()
+		if !flags.Changed("prefix") && !flags.Changed("screaming-snake") && !flags.Changed("ban") && !flags.Changed("secrets") {
+			return invalidf("no rules given: use --prefix, --screaming-snake, --ban or --secrets")
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		// Only the rules given change; the rest of the scope's policy stays
+		p, err := resolver.ScopePolicy(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to get policy: %w", err)
+		}
+		if flags.Changed("prefix") {
+			p.Prefix = policyPrefix
+		}
+		if flags.Changed("screaming-snake") {
+			p.ScreamingSnake = policySnake
+		}
+		if flags.Changed("ban") {
+			p.Banned = policyBanned
+		}
+		if flags.Changed("secrets") {
+			p.Secrets = policySecrets
+		}
+
+		if err := resolver.SetPolicy(cwd, p); err != nil {
+			return fmt.Errorf("failed to set policy: %w", err)
+		}
+
+		fmt.Printf("Set policy at %s: %s\n", cwd, p)
+		return nil
+	},
+}
+
+// policyClearCmd removes the naming policy at current directory scope
+var policyClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove the key naming policy at current directory",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		if err := resolver.SetPolicy(cwd, policy.Policy{}); err != nil {
+			return fmt.Errorf("failed to clear policy: %w", err)
+		}
+
+		fmt.Printf("Cleared policy at %s\n", cwd)
+		return nil
+	},
+}
+
+// whichCmd reports the active root, profile, database and hook state
+var whichCmd = &cobra.Command{
+	Use:   "which",
+	Short: "Show active root, profile, database and hook state",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbPath, err := resolveDBPath()
+		if err != nil {
+			return fmt.Errorf("failed to get database path: %w", err)
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		cwdReal, err := envpath.Canonicalize(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to canonicalize cwd: %w", err)
+		}
+
+		root, marker := envpath.FindRootCanonical(cwdReal)
+		chain := envpath.BuildChainCanonical(root, cwdReal)
+
+		if envpath.CheckRoot(marker) != nil {
+			fmt.Printf("Root:     %s (%s, refused by refuse_fs_root; add a .enva file to mark a root)\n", root, marker)
+		} else {
+			fmt.Printf("Root:     %s (%s)\n", root, marker)
+		}
+		fmt.Printf("Cwd:      %s\n", cwdReal)
+		fmt.Printf("Depth:    %d\n", len(chain))
+		fmt.Printf("Profile:  %s\n", activeProfile())
+		fmt.Printf("Host:     %s\n", env.CurrentHost())
+		fmt.Printf("Database: %s\n", dbPath)
+
+		// Hook state from the tracking variables the hook exports
+		if state := shell.LoadState(os.LookupEnv); len(state.Sums) > 0 {
+			fmt.Printf("Hook:     active (%d var(s) loaded from %s", len(state.Sums), state.Path)
+			if state.Profile != "" {
+				fmt.Printf(", profile %s, generation %d", state.Profile, state.Gen)
+			}
+			fmt.Println(")")
+		} else {
+			fmt.Println("Hook:     no vars loaded in this shell (hook inactive or nothing to load)")
+		}
+
+		if shellName := shell.DetectShell(); shellName != "" {
+			home, err := os.UserHomeDir()
+			if err == nil && shell.IsHookInstalled(shellName, home) {
+				fmt.Printf("Config:   hook found in %s\n", shell.RCFile(shellName, home))
+			} else {
+				fmt.Printf("Config:   hook not found, add: %s\n", shell.HookLine(shellName))
+			}
+		}
+
+		return nil
+	},
+}
+
+var (
+	catNewline bool
+	catJQ      string
+)
+
+// catCmd writes a variable's raw value to stdout
+var catCmd = &cobra.Command{
+	Use:     "cat KEY",
+	Aliases: []string{"get"},
+	Short:   "Write a variable's raw value to stdout",
+	Long: `Write the effective value of KEY to stdout exactly as stored: no quoting,
+no escaping and no trailing newline, so certificates, keys and JSON can be
+piped straight into files and tools:
+
+  enva cat TLS_CERT > cert.pem
+  enva cat CONFIG_JSON | jq .
+
+Use --newline to append a newline, e.g. for interactive use.
+
+--jq reads one field out of a JSON value, with a jq-style path of .field,
+[N] and ."quoted key" steps. Strings come out raw, anything else as JSON:
+
+  enva get CONFIG --jq .db.host
+  enva get CONFIG --jq '.replicas[0]'`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		ctx, err := resolver.Resolve(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to resolve environment: %w", err)
+		}
+		usageCtx = ctx
+
+		v, ok := ctx.Resolved[args[0]]
+		if !ok {
+			return notFoundf("%s is not set here", args[0])
+		}
+
+		value := v.Value
+		if catJQ != "" {
+			if value, err = valuetype.Extract(value, catJQ); err != nil {
+				return invalidf("%s: %v", args[0], err)
+			}
+		}
+
+		if _, err := io.WriteString(os.Stdout, value); err != nil {
+			return err
+		}
+		if catNewline {
+			fmt.Println()
+		}
+		return nil
+	},
+}
+
+var reportTop int
+
+// reportCmd summarizes the local usage log
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarize the local usage log (slowest commands, biggest scopes)",
+	Long: `Summarize the local usage log for performance debugging.
+
+The log is opt-in and never leaves this machine. Enable it by setting
+"usage_log": true in the config file (see ENVA_CONFIG).`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := usageLogPath()
+		if err != nil {
+			return fmt.Errorf("failed to get usage log path: %w", err)
+		}
+
+		entries, err := usage.Read(path)
+		if err != nil {
+			return fmt.Errorf("failed to read usage log: %w", err)
+		}
+
+		if len(entries) == 0 {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			if !cfg.UsageLog {
+				cfgPath, _ := config.Path()
+				fmt.Printf("Usage log is disabled. Add \"usage_log\": true to %s to enable it.\n", cfgPath)
+			} else {
+				fmt.Println("Usage log is empty.")
+			}
+			return nil
+		}
+
+		r := usage.Summarize(entries, reportTop)
+		fmt.Printf("%d command(s) since %s (%s)\n", r.Entries, r.Since.Local().Format("2006-01-02 15:04"), path)
+
+		fmt.Println("\nBy command:")
+		for _, c := range r.Commands {
+			fmt.Printf("  %-16s %5d runs  avg %-8s max %s\n", c.Command, c.Count, formatDuration(c.Avg()), formatDuration(c.Max))
+		}
+
+		fmt.Println("\nSlowest:")
+		for _, e := range r.Slowest {
+			fmt.Printf("  %-8s %-16s %s\n", formatDuration(e.Duration), e.Command, e.Dir)
+		}
+
+		if len(r.Biggest) > 0 {
+			fmt.Println("\nBiggest scopes:")
+			for _, b := range r.Biggest {
+				fmt.Printf("  %5d vars  depth %-3d %s\n", b.Vars, b.Scopes, b.Dir)
+			}
+		}
+		return nil
+	},
+}
+
+// formatDuration rounds a duration for display.
+func formatDuration(d time.Duration) string {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond).String()
+	}
+	return d.Round(100 * time.Microsecond).String()
+}
+
+var (
+	mvToPath    string
+	mvToProfile string
+	mvCopy      bool
+	mvForce     bool
+)
+
+// mvCmd moves a variable between scopes and/or profiles
+var mvCmd = &cobra.Command{
+	Use:   "mv KEY [--to-path PATH] [--to-profile PROFILE]",
+	Short: "Move or copy a variable to another scope or profile",
+	Long: `Move KEY from the scope that defines it to another directory and/or
+profile in a single transaction. Its description, tags and --if-unset flag
+travel with it.
+
+  enva mv DATABASE_URL --to-path ~/projects/api
+  enva mv API_URL --to-profile production --copy
+
+Use --copy to duplicate instead of move, and --force to replace an
+existing KEY at the destination.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := args[0]
+		if mvToPath == "" && mvToProfile == "" {
+			return invalidf("nothing to do: give --to-path and/or --to-profile")
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		ctx, err := resolver.Resolve(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to resolve environment: %w", err)
+		}
+		v, ok := ctx.Resolved[key]
+		if !ok {
+			return notFoundf("%s is not set here", key)
+		}
+
+		dst := v.DefinedAtPath
+		if mvToPath != "" {
+			if _, err := os.Stat(mvToPath); err != nil {
+				return fmt.Errorf("invalid destination: %w", err)
+			}
+			dst = mvToPath
+		}
+		dstProfile := resolver.GetProfile()
+		if mvToProfile != "" {
+			dstProfile = mvToProfile
+		}
+
+		if err := resolver.CheckKeys(dst, key); err != nil {
+			return err
+		}
+
+		err = resolver.MoveVar(v.DefinedAtPath, key, dst, dstProfile, mvCopy, mvForce)
+		if errors.Is(err, db.ErrVarExists) {
+			return fmt.Errorf("%s already exists at the destination; use --force to replace it", key)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to move variable: %w", err)
+		}
+
+		dstCanon, err := envpath.Canonicalize(dst)
+		if err != nil {
+			return fmt.Errorf("failed to canonicalize destination: %w", err)
+		}
+		verb := "Moved"
+		if mvCopy {
+			verb = "Copied"
+		}
+		fmt.Printf("%s %s from %s to %s\n", verb, key,
+			atScope(v.DefinedAtPath, resolver.GetProfile()), atScope(dstCanon, dstProfile))
+		return nil
+	},
+}
+
+var tagRemove bool
+
+// tagCmd manages tags on variables
+var tagCmd = &cobra.Command{
+	Use:   "tag KEY [TAG...]",
+	Short: "Tag a variable to group it (e.g. db, aws, frontend)",
+	Long: `Attach tags to KEY so large scopes can be filtered and exported in groups:
+
+  enva tag AWS_REGION aws
+  enva ls --tag aws
+  enva export --tag aws
+
+Tags belong to the variable at the scope that defines it, so tagging an
+inherited variable tags it for every directory that inherits it. In the
+TUI, search for tag:aws. With no tags, lists KEY's tags.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := args[0]
+		tags, err := normalizeTags(args[1:])
+		if err != nil {
+			return err
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		ctx, err := resolver.Resolve(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to resolve environment: %w", err)
+		}
+		v, ok := ctx.Resolved[key]
+		if !ok {
+			return notFoundf("%s is not set here", key)
+		}
+
+		if len(tags) == 0 {
+			for _, tag := range v.Tags {
+				fmt.Println(tag)
+			}
+			return nil
+		}
+
+		for _, tag := range tags {
+			if tagRemove {
+				err = resolver.RemoveTag(v.DefinedAtPath, key, tag)
+			} else {
+				err = resolver.AddTag(v.DefinedAtPath, key, tag)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to update tag %s: %w", tag, err)
+			}
+		}
+
+		if tagRemove {
+			fmt.Printf("Untagged %s at %s: %s\n", key, atScope(v.DefinedAtPath, resolver.GetProfile()), strings.Join(tags, ", "))
+		} else {
+			fmt.Printf("Tagged %s at %s: %s\n", key, atScope(v.DefinedAtPath, resolver.GetProfile()), strings.Join(tags, ", "))
+		}
+		return nil
+	},
+}
+
+var clearRemove bool
+
+// clearCmd manages keys that are force-unset when entering a directory
+var clearCmd = &cobra.Command{
+	Use:   "clear [KEY...]",
+	Short: "Force-unset keys when entering current directory",
+	Long: `Declare keys that are unset when entering the current directory, even
+if they come from your shell rather than enva (e.g. clear AWS_PROFILE in a
+project that must not use it). Like variables, clears are inherited by
+subdirectories; a variable set in a subdirectory takes precedence.
+
+The hook emits unset lines for these keys and 'enva run' strips them.
+With no arguments, lists the keys cleared at the current directory.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, key := range args {
+			if !shell.IsValidKey(key) {
+				return invalidf("invalid key: %s must match [A-Za-z_][A-Za-z0-9_]*", key)
+			}
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		if len(args) == 0 {
+			ctx, err := resolver.Resolve(cwd)
+			if err != nil {
+				return fmt.Errorf("failed to resolve environment: %w", err)
+			}
+			for _, key := range ctx.Cleared {
+				fmt.Println(key)
+			}
+			return nil
+		}
+
+		for _, key := range args {
+			if clearRemove {
+				err = resolver.RemoveClear(cwd, key)
+			} else {
+				err = resolver.AddClear(cwd, key)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to update %s: %w", key, err)
+			}
+		}
+
+		if clearRemove {
+			fmt.Printf("No longer clearing %s at %s\n", strings.Join(args, ", "), atScope(cwd, resolver.GetProfile()))
+		} else {
+			fmt.Printf("Clearing %s at %s\n", strings.Join(args, ", "), atScope(cwd, resolver.GetProfile()))
+		}
+		return nil
+	},
+}
+
+var aliasRemove bool
+
+// aliasCmd defines shell aliases for the current directory scope
+var aliasCmd = &cobra.Command{
+	Use:   "alias [NAME=COMMAND | --remove NAME...]",
+	Short: "Define shell aliases for the current directory",
+	Long: `Define a shell alias at the current directory scope. Like variables,
+aliases are inherited by subdirectories, a subdirectory can redefine one,
+and the shell hook defines them on entering the scope and removes them
+on leaving it:
+
+  enva alias dc='docker compose -f docker-compose.dev.yml'
+
+With no arguments, lists the aliases in effect here and where each is
+defined. Aliases only reach interactive shells through the hook; 'enva
+run' and 'enva export' don't carry them.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		if len(args) == 0 {
+			if aliasRemove {
+				return invalidf("--remove needs the aliases to remove")
+			}
+			ctx, err := resolver.Resolve(cwd)
+			if err != nil {
+				return fmt.Errorf("failed to resolve environment: %w", err)
+			}
+			for _, a := range ctx.GetSortedAliases() {
+				fmt.Printf("%s  # %s\n", shell.FormatAlias(a.Name, a.Command), a.DefinedAtPath)
+			}
+			return nil
+		}
+
+		if aliasRemove {
+			for _, name := range args {
+				if err := resolver.DeleteAlias(cwd, name); err != nil {
+					return fmt.Errorf("failed to remove alias %s: %w", name, err)
+				}
+			}
+			fmt.Printf("Removed alias %s at %s\n", strings.Join(args, ", "), atScope(cwd, resolver.GetProfile()))
+			return nil
+		}
+
+		if len(args) != 1 {
+			return invalidf("expected one NAME=COMMAND (quote the command)")
+		}
+		name, command, ok := strings.Cut(args[0], "=")
+		if !ok || command == "" {
+			return invalidf("invalid format: expected NAME=COMMAND")
+		}
+		if !shell.IsValidAlias(name) {
+			return invalidf("invalid alias name: %s", name)
+		}
+		if err := resolver.SetAlias(cwd, name, command); err != nil {
+			return fmt.Errorf("failed to set alias: %w", err)
+		}
+		fmt.Printf("Set alias %s at %s\n", name, atScope(cwd, resolver.GetProfile()))
+		return nil
+	},
+}
+
+var taskRemove bool
+
+// taskCmd defines named commands for the current directory scope
+var taskCmd = &cobra.Command{
+	Use:   "task [NAME=COMMAND | --remove NAME...]",
+	Short: "Define named commands for the current directory, run with enva run NAME",
+	Long: `Define a task at the current directory scope: a named shell command,
+like an npm script, that 'enva run NAME' runs with the effective
+environment. Like variables, tasks are inherited by subdirectories and a
+subdirectory can redefine one:
+
+  enva task build='go build -o bin/ ./...'
+  enva task test:unit='go test -short ./...'
+  enva run build
+
+Arguments after the name are passed on to the command. With no
+arguments, lists the tasks in effect here and where each is defined.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		if len(args) == 0 {
+			if taskRemove {
+				return invalidf("--remove needs the tasks to remove")
+			}
+			ctx, err := resolver.Resolve(cwd)
+			if err != nil {
+				return fmt.Errorf("failed to resolve environment: %w", err)
+			}
+			printTasks(ctx)
+			return nil
+		}
+
+		if taskRemove {
+			for _, name := range args {
+				if err := resolver.DeleteTask(cwd, name); err != nil {
+					return fmt.Errorf("failed to remove task %s: %w", name, err)
+				}
+			}
+			fmt.Printf("Removed task %s at %s\n", strings.Join(args, ", "), atScope(cwd, resolver.GetProfile()))
+			return nil
+		}
+
+		if len(args) != 1 {
+			return invalidf("expected one NAME=COMMAND (quote the command)")
+		}
+		name, command, ok := strings.Cut(args[0], "=")
+		if !ok || command == "" {
+			return invalidf("invalid format: expected NAME=COMMAND")
+		}
+		if !shell.IsValidTask(name) {
+			return invalidf("invalid task name: %s", name)
+		}
+		if err := resolver.SetTask(cwd, name, command); err != nil {
+			return fmt.Errorf("failed to set task: %w", err)
+		}
+		fmt.Printf("Set task %s at %s\n", name, atScope(cwd, resolver.GetProfile()))
+		return nil
+	},
+}
+
+// printTasks lists the tasks in effect, aligned, with where each is defined.
+func printTasks(ctx *env.ResolveContext) {
+	tasks := ctx.GetSortedTasks()
+	width := 0
+	for _, t := range tasks {
+		width = max(width, len(t.Name))
+	}
+	for _, t := range tasks {
+		fmt.Printf("%-*s  %s  # %s\n", width, t.Name, t.Command, t.DefinedAtPath)
+	}
+}
+
+var (
+	syncForce  bool
+	syncPrefer string
+	syncDryRun bool
+)
+
+// syncCmd groups push and pull
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Sync the database with a remote (file, WebDAV, S3 or git)",
+	Long: `Push the whole database to a remote as one JSON bundle, or pull a bundle
+and merge it in, so your configuration follows you across machines.
+
+REMOTE is one of:
+  /path/enva-sync.json                      a file, e.g. in a synced folder
+  https://user@host/dav/enva-sync.json      WebDAV or any HTTP GET/PUT server
+  s3://bucket/enva-sync.json                S3 (AWS_* credentials; ENVA_S3_ENDPOINT for compatible stores)
+  git+git@host:me/env.git#enva-sync.json    a file in a git repository
+
+Without REMOTE, "sync_remote" from the config file is used. HTTP
+credentials can also come from ENVA_SYNC_USER and ENVA_SYNC_PASSWORD.
+
+Changes are tracked against the last sync using each variable's
+updated_at. A variable changed on both machines is a conflict: pull
+leaves it alone unless --prefer mine, theirs or newer decides it.
+Push refuses to overwrite a bundle someone else pushed since your last
+sync; pull first, or use --force.`,
+}
+
+// syncPushCmd uploads the database
+var syncPushCmd = &cobra.Command{
+	Use:   "push [REMOTE]",
+	Short: "Upload the database to the remote",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := openSync(args)
+		if err != nil {
+			return err
+		}
+		defer s.database.Close()
+
+		local, err := dbsync.Snapshot(s.database)
+		if err != nil {
+			return fmt.Errorf("failed to read database: %w", err)
+		}
+
+		data, err := s.remote.Get()
+		if err != nil && !errors.Is(err, dbsync.ErrNoBundle) {
+			return fmt.Errorf("failed to read remote: %w", err)
+		}
+		if err == nil && !syncForce {
+			bundle, err := dbsync.DecodeBundle(data)
+			if err != nil {
+				return err
+			}
+			if s.base == nil || bundle.Revision != s.base.Revision {
+				return fmt.Errorf("remote has changes from %s (pushed %s); run 'enva sync pull' first, or push --force to overwrite",
+					bundle.Machine, bundle.PushedAt.Local().Format(time.DateTime))
+			}
+		}
+
+		bundle := dbsync.NewBundle(local)
+		data, err = bundle.Encode()
+		if err != nil {
+			return err
+		}
+		if err := s.remote.Put(data); err != nil {
+			return fmt.Errorf("failed to write remote: %w", err)
+		}
+		if err := dbsync.NextBase(s.base, bundle.Revision, local, local).Save(s.basePath); err != nil {
+			return fmt.Errorf("failed to record sync state: %w", err)
+		}
+
+		fmt.Printf("Pushed %d var(s) to %s\n", len(local), s.location)
+		return nil
+	},
+}
+
+// syncPullCmd merges the remote bundle into the database
+var syncPullCmd = &cobra.Command{
+	Use:   "pull [REMOTE]",
+	Short: "Merge the remote's variables into the database",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !dbsync.IsValidPrefer(syncPrefer) {
+			return invalidf("invalid --prefer %q (supported: mine, theirs, newer)", syncPrefer)
+		}
+		s, err := openSync(args)
+		if err != nil {
+			return err
+		}
+		defer s.database.Close()
+
+		data, err := s.remote.Get()
+		if errors.Is(err, dbsync.ErrNoBundle) {
+			fmt.Printf("Nothing has been pushed to %s yet\n", s.location)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read remote: %w", err)
+		}
+		bundle, err := dbsync.DecodeBundle(data)
+		if err != nil {
+			return err
+		}
+
+		local, err := dbsync.Snapshot(s.database)
+		if err != nil {
+			return fmt.Errorf("failed to read database: %w", err)
+		}
+		plan := dbsync.PlanPull(s.base, local, bundle.Vars, syncPrefer)
+
+		for _, v := range plan.Set {
+			fmt.Printf("+ %s\n", v)
+		}
+		for _, v := range plan.Delete {
+			fmt.Printf("- %s\n", v)
+		}
+		for _, c := range plan.Kept {
+			fmt.Printf("= %s: kept mine\n", c.Var())
+		}
+		for _, c := range plan.Conflicts {
+			fmt.Printf("! %s: mine %s, theirs %s\n", c.Var(), syncSide(c.Mine), syncSide(c.Theirs))
+		}
+		if syncDryRun {
+			return nil
+		}
+
+		if err := dbsync.Apply(s.database, plan); err != nil {
+			return fmt.Errorf("failed to apply changes: %w", err)
+		}
+		if len(plan.Conflicts) > 0 {
+			return fmt.Errorf("%d conflict(s) left as they are; rerun with --prefer mine, theirs or newer", len(plan.Conflicts))
+		}
+
+		merged, err := dbsync.Snapshot(s.database)
+		if err != nil {
+			return fmt.Errorf("failed to read database: %w", err)
+		}
+		if err := dbsync.NextBase(s.base, bundle.Revision, merged, bundle.Vars).Save(s.basePath); err != nil {
+			return fmt.Errorf("failed to record sync state: %w", err)
+		}
+
+		if plan.Empty() {
+			fmt.Printf("Already up to date with %s\n", s.location)
+		} else {
+			fmt.Printf("Pulled %d change(s) from %s (pushed by %s)\n", len(plan.Set)+len(plan.Delete), s.location, bundle.Machine)
+		}
+		return nil
+	},
+}
+
+// syncSide describes one side of a sync conflict.
+func syncSide(v *dbsync.Var) string {
+	if v == nil {
+		return "deleted"
+	}
+	return "updated " + v.UpdatedAt.Local().Format(time.DateTime)
+}
+
+// syncSession is an open database, its remote and the sync state between them.
+type syncSession struct {
+	database db.Store
+	remote   dbsync.Remote
+	location string
+	base     *dbsync.Base
+	basePath string
+}
+
+// openSync opens the database and the remote named in args or the config.
+func openSync(args []string) (*syncSession, error) {
+	location := ""
+	if len(args) == 1 {
+		location = args[0]
+	} else {
+		cfg, err := config.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		location = cfg.SyncRemote
+	}
+	if location == "" {
+		return nil, invalidf("no remote given; pass one or set \"sync_remote\" in %s", configPathForHelp())
+	}
+
+	dataDir, err := db.DataDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data directory: %w", err)
+	}
+	remote, err := dbsync.OpenRemote(location, filepath.Join(dataDir, "sync"))
+	if err != nil {
+		return nil, err
+	}
+
+	dbPath, err := resolveDBPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database path: %w", err)
+	}
+	basePath := dbsync.BasePath(dataDir, dbPath, location)
+	base, err := dbsync.LoadBase(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := openStore(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return &syncSession{database: database, remote: remote, location: location, base: base, basePath: basePath}, nil
+}
+
+// configPathForHelp names the config file in messages.
+func configPathForHelp() string {
+	if p, err := config.Path(); err == nil {
+		return p
+	}
+	return "the config file"
+}
+
+var guiEnvDryRun bool
+
+// guiEnvCmd groups helpers for the environment of macOS GUI apps
+var guiEnvCmd = &cobra.Command{
+	Use:   "gui-env",
+	Short: "Make effective vars visible to macOS GUI apps",
+	Long: `GUI editors and IDEs started from the Dock or Finder don't run shell
+hooks, so they never see enva's vars. On macOS, launchd holds the
+environment those apps start with; gui-env apply copies the effective
+vars for the current directory into it with launchctl setenv.
+
+Apps pick the vars up when they are next launched. The values stay set
+until logout; run apply again after changing them.`,
+}
+
+// guiEnvApplyCmd sets the effective vars in launchd
+var guiEnvApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Set the effective vars for GUI apps with launchctl setenv",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if runtime.GOOS != "darwin" && !guiEnvDryRun {
+			return invalidf("gui-env apply needs launchctl, which is only available on macOS (use --dry-run to see the commands)")
+		}
+
+		cwd, err := getCwd()
+		if err != nil {
+			return err
+		}
+		ctx, err := resolveNow(cwd)
+		if err != nil {
+			return err
+		}
+		usageCtx = ctx
+		ctx.StripNoExport()
+		warnInvalidKeys(shell.StripInvalidKeys(ctx))
+		if blocked := stripUntrusted(ctx); len(blocked) > 0 {
+			warnBlocked(blocked)
+			exitStatus = exitUntrusted
+		}
+		warnEvalFailed(evalRunnerFor(ctx).Apply(ctx))
+
+		vars := ctx.GetSortedVars()
+		if guiEnvDryRun {
+			for _, key := range ctx.Cleared {
+				fmt.Println(shell.FormatLaunchctlUnset(key))
+			}
+			for _, v := range vars {
+				fmt.Println(shell.FormatLaunchctl(v.Key, v.Value))
+			}
+			return nil
+		}
+
+		for _, key := range ctx.Cleared {
+			if out, err := exec.Command("launchctl", "unsetenv", key).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to unset %s: %w: %s", key, err, bytes.TrimSpace(out))
+			}
+		}
+		for _, v := range vars {
+			if out, err := exec.Command("launchctl", "setenv", v.Key, v.Value).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to set %s: %w: %s", v.Key, err, bytes.TrimSpace(out))
+			}
+		}
+		fmt.Printf("Set %d var(s) for GUI apps; restart them to pick the changes up\n", len(vars))
+		return nil
+	},
+}
+
+// direnvCmd groups direnv integration helpers
+var direnvCmd = &cobra.Command{
+	Use:   "direnv",
+	Short: "Use enva from direnv instead of the prompt hook",
+}
+
+// direnvStdlibCmd prints the direnv library defining "use enva"
+var direnvStdlibCmd = &cobra.Command{
+	Use:   "stdlib",
+	Short: `Print a direnv library defining "use enva"`,
+	Long: `Print a direnv library that defines "use enva [PROFILE]". Teams that
+already use direnv can then load enva's vars from an .envrc without the
+prompt hook.
+
+Add to ~/.config/direnv/direnvrc:
+  eval "$(enva direnv stdlib)"
+
+and to an .envrc:
+  use enva
+
+direnv reloads when the database changes. The database location is the
+one in effect when the library is printed, so eval it as above rather than
+saving the output to a file.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbPath, err := resolveDBPath()
+		if err != nil {
+			return fmt.Errorf("failed to get database path: %w", err)
+		}
+
+		var watch []string
+		if file := db.FilePath(dbPath); file != "" {
+			watch = append(watch, file)
+			if !strings.HasPrefix(dbPath, "json:") {
+				// SQLite writes land in the WAL before the database file
+				watch = append(watch, file+"-wal")
+			}
+		}
+		fmt.Print(shell.DirenvStdlib(watch))
+		return nil
+	},
+}
+
+var (
+	trustRemove bool
+	trustList   bool
+)
+
+// trustCmd lets a scope export denylisted keys
+var trustCmd = &cobra.Command{
+	Use:   "trust [DIR]",
+	Short: "Allow a directory's scopes to export loader and PATH settings and run --eval commands",
+	Long: `Keys such as LD_PRELOAD, DYLD_INSERT_LIBRARIES, LD_AUDIT, PATH and IFS
+can hijack every program a shell starts. export, run and gui-env apply
+skip them, with a warning, unless the scope that sets them is trusted, so
+a synced or shared database can't inject them. Add more keys with
+"dangerous_keys" in the config file. enva has no append mode, so PATH is
+always a full replacement and always needs trust. Values set with --eval
+are commands, so they only run in trusted scopes too.
+
+Trusting DIR (default: the current directory) covers it and every
+directory below it. The list is kept next to the database, not in it, so
+it never syncs. --list shows it and --remove takes a directory off it.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := trustListPath()
+		if err != nil {
+			return fmt.Errorf("failed to get data directory: %w", err)
+		}
+		list, err := trust.Load(path)
+		if err != nil {
+			return fmt.Errorf("failed to read trust list: %w", err)
+		}
+
+		if trustList {
+			for _, dir := range list.Dirs {
+				fmt.Println(dir)
+			}
+			return nil
+		}
+
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		dir, err = envpath.Canonicalize(dir)
+		if err != nil {
+			return err
+		}
+
+		if trustRemove {
+			if !list.Remove(dir) {
+				return notFoundf("%s is not trusted", dir)
+			}
+		} else if !list.Add(dir) {
+			fmt.Printf("%s is already trusted\n", dir)
+			return nil
+		}
+		if err := list.Save(); err != nil {
+			return fmt.Errorf("failed to write trust list: %w", err)
+		}
+
+		if trustRemove {
+			fmt.Printf("No longer trusting %s\n", dir)
+		} else {
+			fmt.Printf("Trusted %s and the directories below it\n", dir)
+		}
+		return nil
+	},
+}
+
+var (
+	protectRemove bool
+	protectList   bool
+)
+
+// protectCmd puts a profile behind a passphrase
+var protectCmd = &cobra.Command{
+	Use:   "protect [PROFILE]",
+	Short: "Require a passphrase to open or export a profile",
+	Long: `Put PROFILE (default: the active profile) behind a passphrase, so its
+secrets aren't loaded into a shell or shown on screen by accident. Opening
+it in the TUI, exporting it or running anything else with it asks for the
+passphrase once; it then stays unlocked for "unlock_minutes" from the
+config file (default 15). Where there's nothing to ask on, as in the shell
+hook or a script, the command fails with exit status 8 until
+"enva unlock PROFILE" is run, and the hook unloads the profile's vars.
+
+This guards against accidents; it is not encryption. Values are stored as
+before, and anyone who can read the database can read them. The list of
+protected profiles is kept next to the database, not in it, so it never
+syncs. Run protect again to change the passphrase. --list shows the
+protected profiles and --remove unprotects one; both changes need the
+current passphrase.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		locker, err := loadLocker()
+		if err != nil {
+			return fmt.Errorf("failed to read protected profiles: %w", err)
+		}
+
+		if protectList {
+			for _, name := range locker.list.Names() {
+				fmt.Println(name)
+			}
+			return nil
+		}
+
+		profile := activeProfile()
+		if len(args) == 1 {
+			profile = args[0]
+		}
+		if protectRemove && !locker.list.Protected(profile) {
+			return notFoundf("profile %s is not protected", profile)
+		}
+
+		if locker.list.Protected(profile) {
+			current, err := readPassphrase(fmt.Sprintf("Current passphrase for profile %s: ", profile))
+			if err != nil {
+				return err
+			}
+			if err := locker.list.Check(profile, current); err != nil {
+				return &codedError{exitProtected, err}
+			}
+		}
+
+		if protectRemove {
+			locker.list.Remove(profile)
+		} else {
+			passphrase, err := readPassphrase(fmt.Sprintf("New passphrase for profile %s: ", profile))
+			if err != nil {
+				return err
+			}
+			again, err := readPassphrase("Repeat passphrase: ")
+			if err != nil {
+				return err
+			}
+			if passphrase != again {
+				return invalidf("passphrases don't match")
+			}
+			if err := locker.list.Protect(profile, passphrase); err != nil {
+				return invalidf("%v", err)
+			}
+		}
+		if err := locker.list.Save(); err != nil {
+			return fmt.Errorf("failed to write protected profiles: %w", err)
+		}
+		if err := locker.session.Lock(profile); err != nil {
+			return err
+		}
+
+		if protectRemove {
+			fmt.Printf("Profile %s is no longer protected\n", profile)
+		} else {
+			fmt.Printf("Protected profile %s; enva unlock %s opens it for %s\n", profile, profile, unlockTimeout())
+		}
+		return nil
+	},
+}
+
+// unlockCmd opens a protected profile for a while
+var unlockCmd = &cobra.Command{
+	Use:   "unlock [PROFILE]",
+	Short: "Enter a protected profile's passphrase so it can be used for a while",
+	Long: `Ask for the passphrase of PROFILE (default: the active profile) and
+unlock it for "unlock_minutes" from the config file (default 15). Unlocks
+last for the login session where $XDG_RUNTIME_DIR is set, and at most until
+they time out. Run it before cd-ing into a project whose shell hook should
+load a protected profile, or before scripts that have no terminal.
+
+Unlocking again restarts the timeout. See enva protect.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profile := activeProfile()
+		if len(args) == 1 {
+			profile = args[0]
+		}
+		locker, err := loadLocker()
+		if err != nil {
+			return fmt.Errorf("failed to read protected profiles: %w", err)
+		}
+		if !locker.list.Protected(profile) {
+			return notFoundf("profile %s is not protected", profile)
+		}
+		if err := promptUnlock(locker, profile); err != nil {
+			return err
+		}
+		fmt.Printf("Unlocked profile %s for %s\n", profile, unlockTimeout())
+		return nil
+	},
+}
+
+var lockAll bool
+
+// lockCmd locks a protected profile before its unlock times out
+var lockCmd = &cobra.Command{
+	Use:   "lock [PROFILE]",
+	Short: "Lock a protected profile again",
+	Long: `Lock PROFILE (default: the active profile) again before its unlock times
+out, so using it asks for the passphrase. --all locks every protected
+profile.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		locker, err := loadLocker()
+		if err != nil {
+			return fmt.Errorf("failed to read protected profiles: %w", err)
+		}
+		profiles := locker.list.Names()
+		if !lockAll {
+			profile := activeProfile()
+			if len(args) == 1 {
+				profile = args[0]
+			}
+			if !locker.list.Protected(profile) {
+				return notFoundf("profile %s is not protected", profile)
+			}
+			profiles = []string{profile}
+		}
+		for _, profile := range profiles {
+			if err := locker.session.Lock(profile); err != nil {
+				return err
+			}
+			fmt.Printf("Locked profile %s\n", profile)
+		}
+		return nil
+	},
+}
+
+var refreshWatch bool
+
+// refreshCmd reruns --eval commands ahead of the shell hook
+var refreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Rerun the --eval commands for the current directory and cache their output",
+	Long: `Rerun the command behind every --eval var in effect here and cache the
+output, so the next export picks up new values without waiting on them.
+
+With --watch, enva keeps running and renews each output shortly before it
+expires (see set --refresh), so short-lived credentials are replaced in
+the background. Run it from a login item, systemd user unit or tmux pane
+in the project directory. Database changes are picked up each minute.
+While vault:// vars are in effect it also renews the Vault token halfway
+through its lifetime, logging in again with AppRole if renewal fails.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := getCwd()
+		if err != nil {
+			return err
+		}
+		runner := evalRunner()
+
+		if !refreshWatch {
+			ctx, err := resolver.Resolve(cwd)
+			if err != nil {
+				return fmt.Errorf("failed to resolve environment: %w", err)
+			}
+			usageCtx = ctx
+			warnBlocked(stripUntrusted(ctx))
+
+			runner = evalRunnerFor(ctx)
+			refreshed, failed := runner.Refresh(ctx)
+			warnEvalFailed(failed)
+			for _, v := range refreshed {
+				fmt.Printf("Refreshed %s\n", v.Key)
+			}
+			if len(failed) > 0 {
+				return fmt.Errorf("%d of %d command(s) failed", len(failed), len(refreshed)+len(failed))
+			}
+			return nil
+		}
+
+		sig, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		var renewAt time.Time
+		var vaultTok string // The AppRole session's, once renewed or replaced
+		for {
+			// Resolve each round so new, changed and removed vars are seen
+			next := time.Minute
+			if ctx, err := resolver.Resolve(cwd); err != nil {
+				fmt.Fprintf(os.Stderr, "enva: failed to resolve environment: %v\n", err)
+			} else {
+				stripUntrusted(ctx)
+				// Keep the Vault session alive while vault:// vars are in use
+				if len(vaultKeys(ctx)) > 0 && !time.Now().Before(renewAt) {
+					if vaultTok == "" {
+						vaultTok = vaultToken(ctx)
+					}
+					var after time.Duration
+					vaultTok, after = renewVault(vaultTok)
+					renewAt = time.Now().Add(after)
+				}
+				runner.Env = nil
+				if vaultTok != "" {
+					runner.Env = []string{"VAULT_TOKEN=" + vaultTok}
+				}
+				if len(vaultKeys(ctx)) > 0 && time.Until(renewAt) < next {
+					next = max(time.Until(renewAt), time.Second)
+				}
+				failed, due := runner.RefreshDue(ctx)
+				warnEvalFailed(failed)
+				if due > 0 && due < next {
+					next = due
+				}
+			}
+
+			select {
+			case <-sig.Done():
+				return nil
+			case <-time.After(next):
+			}
+		}
+	},
+}
+
+var (
+	templatePlaceholders []string
+	templateValues       []string
+	templateForce        bool
+)
+
+// templateCmd groups the template subcommands
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Save a scope as a template and bootstrap new projects from it",
+	Long: `Templates capture a scope's vars (with their descriptions, tags and flags),
+force-unsets and aliases, so a standard service setup can be stamped into
+a new project:
+
+  cd ~/src/billing && enva template save service --placeholder SERVICE_NAME
+  cd ~/src/payments && enva template apply service
+
+Values can contain {{NAME}} placeholders. apply asks for each one, or takes
+them from --set NAME=VALUE. Values that look like credentials are saved as
+placeholders automatically, so templates never hold secrets.
+
+Templates are kept in the data directory, not the database.`,
+}
+
+// templatesDir returns the directory templates are kept in.
+func templatesDir() (string, error) {
+	dir, err := db.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "templates"), nil
+}
+
+// templateSaveCmd saves the current scope as a template
+var templateSaveCmd = &cobra.Command{
+	Use:   "save NAME",
+	Short: "Save the vars, clears and aliases defined here as a template",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if !templates.ValidName(name) {
+			return invalidf("invalid template name %q: use letters, digits, '.', '_' and '-'", name)
+		}
+		dir, err := templatesDir()
+		if err != nil {
+			return fmt.Errorf("failed to get data directory: %w", err)
+		}
+		if _, err := templates.Load(dir, name); err == nil && !templateForce {
+			return invalidf("template %s already exists (use --force to replace it)", name)
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+		canonical, err := envpath.Canonicalize(cwd)
+		if err != nil {
+			return err
+		}
+		profile := resolver.GetProfile()
+
+		vars, err := database.GetVarsForPath(canonical, profile)
+		if err != nil {
+			return fmt.Errorf("failed to read variables: %w", err)
+		}
+		clears, err := database.GetClearsForPaths([]string{canonical}, profile)
+		if err != nil {
+			return fmt.Errorf("failed to read clears: %w", err)
+		}
+		aliases, err := database.GetAliasesForPaths([]string{canonical}, profile)
+		if err != nil {
+			return fmt.Errorf("failed to read aliases: %w", err)
+		}
+		if len(vars) == 0 && len(clears) == 0 && len(aliases) == 0 {
+			return notFoundf("nothing is defined at %s", atScope(canonical, profile))
+		}
+		tags, err := database.GetTagsForPaths([]string{canonical}, profile)
+		if err != nil {
+			return fmt.Errorf("failed to read tags: %w", err)
+		}
+		tagsByKey := make(map[string][]string)
+		for _, t := range tags {
+			tagsByKey[t.Key] = append(tagsByKey[t.Key], t.Tag)
+		}
+
+		asPlaceholder := make(map[string]bool)
+		for _, key := range templatePlaceholders {
+			asPlaceholder[key] = true
+		}
+
+		t := &templates.Template{Name: name, CreatedAt: time.Now().UTC()}
+		for _, v := range vars {
+			value := v.Value
+			if asPlaceholder[v.Key] {
+				delete(asPlaceholder, v.Key)
+				value = templates.Placeholder(v.Key)
+			} else if !v.Eval && secrets.IsSensitive(v.Key, v.Value) {
+				fmt.Fprintf(os.Stderr, "enva: %s looks like a credential; saved as %s\n", v.Key, templates.Placeholder(v.Key))
+				value = templates.Placeholder(v.Key)
+			}
+			t.Vars = append(t.Vars, templates.Var{
+				Key:         v.Key,
+				Value:       value,
+				Description: v.Description,
+				IfUnset:     v.IfUnset,
+				Eval:        v.Eval,
+				Refresh:     v.Refresh,
+				NoExport:    v.NoExport,
+				Fallback:    v.Fallback,
+				Type:        v.Type,
+				Tags:        tagsByKey[v.Key],
+			})
+		}
+		if len(asPlaceholder) > 0 {
+			return notFoundf("--placeholder: %s not set at %s", strings.Join(slices.Sorted(maps.Keys(asPlaceholder)), ", "), atScope(canonical, profile))
+		}
+		for _, c := range clears {
+			t.Clears = append(t.Clears, c.Key)
+		}
+		for _, a := range aliases {
+			if t.Aliases == nil {
+				t.Aliases = make(map[string]string)
+			}
+			t.Aliases[a.Name] = a.Command
+		}
+
+		if err := templates.Save(dir, t); err != nil {
+			return fmt.Errorf("failed to save template: %w", err)
+		}
+		fmt.Printf("Saved template %s: %d var(s)", name, len(t.Vars))
+		if p := t.Placeholders(); len(p) > 0 {
+			fmt.Printf(", placeholders %s", strings.Join(p, ", "))
+		}
+		fmt.Println()
+		return nil
+	},
+}
+
+// templateApplyCmd writes a template into the current scope
+var templateApplyCmd = &cobra.Command{
+	Use:   "apply NAME",
+	Short: "Set a template's vars, clears and aliases here, filling in placeholders",
+	Long: `Set the template's vars, force-unsets and aliases at the current directory
+scope. Each {{NAME}} placeholder is asked for, unless given with --set
+NAME=VALUE; without a terminal every placeholder must be given.
+
+Vars and aliases that already exist here are left alone and the template
+isn't applied; use --force to replace them.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := templatesDir()
+		if err != nil {
+			return fmt.Errorf("failed to get data directory: %w", err)
+		}
+		if !templates.ValidName(args[0]) {
+			return invalidf("invalid template name %q", args[0])
+		}
+		t, err := templates.Load(dir, args[0])
+		if errors.Is(err, fs.ErrNotExist) {
+			return notFoundf("no template named %s (see enva template list)", args[0])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read template: %w", err)
+		}
+
+		values := make(map[string]string)
+		for _, kv := range templateValues {
+			name, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				return invalidf("invalid --set %q: expected NAME=VALUE", kv)
+			}
+			values[name] = value
+		}
+		if err := promptPlaceholders(t, values); err != nil {
+			return err
+		}
+		t, err = t.Fill(values)
+		if err != nil {
+			return invalidf("%v (pass them with --set NAME=VALUE)", err)
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get cwd: %w", err)
+		}
+
+		keys := make([]string, 0, len(t.Vars))
+		set := make(map[string]db.VarData, len(t.Vars))
+		plain := make(map[string]string)
+		for _, v := range t.Vars {
+			keys = append(keys, v.Key)
+			set[v.Key] = db.VarData{Value: v.Value, Description: v.Description}
+			if !v.Eval {
+				plain[v.Key] = v.Value
+			}
+		}
+		if err := resolver.CheckKeys(cwd, keys...); err != nil {
+			return err
+		}
+
+		if !templateForce {
+			var taken []string
+			local, err := resolver.GetLocalVarsFromDB(cwd)
+			if err != nil {
+				return fmt.Errorf("failed to read variables: %w", err)
+			}
+			for _, v := range local {
+				if _, ok := set[v.Key]; ok {
+					taken = append(taken, v.Key)
+				}
+			}
+			ctx, err := resolver.Resolve(cwd)
+			if err != nil {
+				return fmt.Errorf("failed to resolve environment: %w", err)
+			}
+			for name, a := range ctx.Aliases {
+				if _, ok := t.Aliases[name]; ok && a.DefinedAtPath == ctx.CwdReal {
+					taken = append(taken, "alias "+name)
+				}
+			}
+			if len(taken) > 0 {
+				sort.Strings(taken)
+				return invalidf("already set here: %s (use --force to replace)", strings.Join(taken, ", "))
+			}
+		}
+
+		warnings, err := resolver.CheckSecrets(cwd, plain)
+		if err != nil {
+			return err
+		}
+		printSecretWarnings(warnings)
+
+		if err := resolver.SetVarsBatch(cwd, set); err != nil {
+			return fmt.Errorf("failed to set variables: %w", err)
+		}
+		evals := false
+		for _, v := range t.Vars {
+			if err := resolver.SetIfUnset(cwd, v.Key, v.IfUnset); err != nil {
+				return fmt.Errorf("failed to set %s: %w", v.Key, err)
+			}
+			if err := resolver.SetEval(cwd, v.Key, v.Eval); err != nil {
+				return fmt.Errorf("failed to set %s: %w", v.Key, err)
+			}
+			if err := resolver.SetRefresh(cwd, v.Key, v.Refresh); err != nil {
+				return fmt.Errorf("failed to set %s: %w", v.Key, err)
+			}
+			if err := resolver.SetNoExport(cwd, v.Key, v.NoExport); err != nil {
+				return fmt.Errorf("failed to set %s: %w", v.Key, err)
+			}
+			if err := resolver.SetFallback(cwd, v.Key, v.Fallback); err != nil {
+				return fmt.Errorf("failed to set %s: %w", v.Key, err)
+			}
+			if err := resolver.SetType(cwd, v.Key, v.Type); err != nil {
+				return fmt.Errorf("failed to set %s: %w", v.Key, err)
+			}
+			for _, tag := range v.Tags {
+				if err := resolver.AddTag(cwd, v.Key, tag); err != nil {
+					return fmt.Errorf("failed to tag %s: %w", v.Key, err)
+				}
+			}
+			evals = evals || v.Eval
+		}
+		for _, key := range t.Clears {
+			if err := resolver.AddClear(cwd, key); err != nil {
+				return fmt.Errorf("failed to clear %s: %w", key, err)
+			}
+		}
+		for name, command := range t.Aliases {
+			if err := resolver.SetAlias(cwd, name, command); err != nil {
+				return fmt.Errorf("failed to set alias %s: %w", name, err)
+			}
+		}
+
+		fmt.Printf("Applied template %s at %s: %d var(s)\n", t.Name, atScope(cwd, resolver.GetProfile()), len(t.Vars))
+		if evals && !trustedDir(cwd) {
+			fmt.Fprintln(os.Stderr, "enva: the template's --eval vars won't be evaluated until this directory is trusted (enva trust)")
+		}
+		return nil
+	},
+}
+
+// promptPlaceholders asks on the terminal for each placeholder in t that
+// values lacks. Without a terminal it leaves them for Fill to report.
+func promptPlaceholders(t *templates.Template, values map[string]string) error {
+	var missing []string
+	for _, name := range t.Placeholders() {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	for _, name := range missing {
+		fmt.Printf("%s: ", name)
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("aborted: no changes saved")
+		}
+		values[name] = strings.TrimRight(line, "\r\n")
+	}
+	return nil
+}
+
+// templateListCmd lists saved templates
+var templateListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved templates",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := templatesDir()
+		if err != nil {
+			return fmt.Errorf("failed to get data directory: %w", err)
+		}
+		list, err := templates.List(dir)
+		if err != nil {
+			return fmt.Errorf("failed to list templates: %w", err)
+		}
+		for _, t := range list {
+			line := fmt.Sprintf("%s  # %d var(s)", t.Name, len(t.Vars))
+			if p := t.Placeholders(); len(p) > 0 {
+				line += ", placeholders " + strings.Join(p, ", ")
+			}
+			fmt.Println(line)
+		}
+		return nil
+	},
+}
+
+// templateRmCmd deletes a saved template
+var templateRmCmd = &cobra.Command{
+	Use:   "rm NAME",
+	Short: "Delete a saved template",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := templatesDir()
+		if err != nil {
+			return fmt.Errorf("failed to get data directory: %w", err)
+		}
+		if !templates.ValidName(args[0]) {
+			return invalidf("invalid template name %q", args[0])
+		}
+		err = templates.Remove(dir, args[0])
+		if errors.Is(err, fs.ErrNotExist) {
+			return notFoundf("no template named %s", args[0])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete template: %w", err)
+		}
+		fmt.Printf("Deleted template %s\n", args[0])
+		return nil
+	},
+}
+
+// shellCmd starts a subshell with the effective environment loaded
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Start a shell with the effective environment loaded",
+	Long: `Start your $SHELL with the current directory's effective environment
+applied, for a fully loaded shell without installing the hook. The prompt
+starts with (enva:PROJECT), or (enva:PROJECT@PROFILE) outside the default
+profile; exit the shell to leave.
+
+The vars are fixed when the shell starts: cd-ing elsewhere doesn't change
+them. ENVA_SHELL is set to the project root inside, for prompt themes that
+redraw the prompt themselves.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if root := os.Getenv(shell.SubshellVar); root != "" {
+			return invalidf("already in an enva shell for %s; exit it first", root)
+		}
+		shellPath := os.Getenv("SHELL")
+		if shellPath == "" {
+			shellPath = "/bin/sh"
+		}
+		if _, err := exec.LookPath(shellPath); err != nil {
+			return notFoundf("can't start $SHELL: %v", err)
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := getCwd()
+		if err != nil {
+			return err
+		}
+		ctx, err := resolver.Resolve(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to resolve environment: %w", err)
+		}
+		usageCtx = ctx
+		ctx.StripNoExport()
+		warnBlocked(stripUntrusted(ctx))
+		warnEvalFailed(evalRunnerFor(ctx).Apply(ctx))
+
+		root := ctx.RootDir
+		if root == string(filepath.Separator) {
+			root = ctx.CwdReal
+		}
+		label := "enva:" + filepath.Base(root)
+		if ctx.Profile != env.DefaultProfile {
+			label += "@" + ctx.Profile
+		}
+
+		rcDir, err := os.MkdirTemp("", "enva-shell-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(rcDir)
+		shellArgs, extra, err := shell.Subshell(shellPath, label, rcDir)
+		if err != nil {
+			return fmt.Errorf("failed to prepare shell: %w", err)
+		}
+
+		sub := exec.Command(shellPath, shellArgs...)
+		sub.Env = append(effectiveEnviron(ctx), shell.SubshellVar+"="+root)
+		sub.Env = append(sub.Env, extra...)
+		sub.Stdin, sub.Stdout, sub.Stderr = os.Stdin, os.Stdout, os.Stderr
+		fmt.Fprintf(os.Stderr, "enva: starting %s with %d var(s) from %s; exit to leave\n", filepath.Base(shellPath), len(ctx.Resolved), root)
+
+		// The shell's own exit status is passed through, like run
+		err = sub.Run()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitStatus = max(exitErr.ExitCode(), exitFailure)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to start %s: %w", shellPath, err)
+		}
+		return nil
+	},
+}
+
+var watchInterval time.Duration
+
+// watchCmd is the long-running process behind change notifications
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watch the effective environment and notify when watched keys change",
+	Long: `Keep running, resolve the current directory's environment every
+--interval and report vars that were added, changed or removed, e.g. by
+sync pull bringing in a teammate's update to a shared bundle.
+
+Each change is printed, and the "notify" rules in the config file decide
+what else happens:
+
+  "notify": [
+    {"keys": ["API_URL", "DB_*"], "desktop": true},
+    {"keys": ["*"], "webhook": "https://hooks.example.com/enva"},
+    {"keys": ["STRIPE_*"], "command": "say \"$ENVA_SUMMARY\""}
+  ]
+
+Commands run with sh in this directory, with ENVA_CHANGED_KEYS, ENVA_DIR
+and ENVA_SUMMARY set and the JSON payload on stdin; webhooks receive the
+same payload in a POST. Values are left out of the payload unless the rule
+sets "include_values": true. Run it from a login item, systemd user unit
+or tmux pane in the project directory.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if watchInterval < time.Second {
+			return invalidf("--interval must be at least 1s")
+		}
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := getCwd()
+		if err != nil {
+			return err
+		}
+		ctx, err := resolver.Resolve(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to resolve environment: %w", err)
+		}
+		usageCtx = ctx
+
+		dispatcher := &notify.Dispatcher{Dir: ctx.CwdReal}
+		for _, r := range cfg.Notify {
+			dispatcher.Rules = append(dispatcher.Rules, notify.Rule(r))
+		}
+		if len(dispatcher.Rules) == 0 {
+			fmt.Fprintln(os.Stderr, `enva: no "notify" rules in the config file; changes will only be printed`)
+		}
+		fmt.Fprintf(os.Stderr, "enva: watching %d var(s) in %s\n", len(ctx.Resolved), ctx.CwdReal)
+
+		sig, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		last := ctx.Resolved
+		for {
+			select {
+			case <-sig.Done():
+				return nil
+			case <-time.After(watchInterval):
+			}
+
+			ctx, err := resolver.Resolve(cwd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "enva: failed to resolve environment: %v\n", err)
+				continue
+			}
+			changes := notify.Diff(last, ctx.Resolved)
+			last = ctx.Resolved
+			if len(changes) == 0 {
+				continue
+			}
+			for _, c := range changes {
+				fmt.Printf("%s %s %s (%s)\n", time.Now().Format(time.TimeOnly), c.Key, c.Kind, c.Path)
+			}
+			for _, f := range dispatcher.Dispatch(changes) {
+				fmt.Fprintf(os.Stderr, "enva: notify %s failed: %v\n", strings.Join(f.Rule.Keys, ","), f.Err)
+			}
+		}
+	},
+}
+
+// defaultTrashRetention is how long deleted vars stay restorable unless the
+// config sets trash_retention_days.
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// purgeExpiredTrash permanently deletes vars trashed longer ago than the
+// retention window.
+func purgeExpiredTrash(database db.Store) {
+	retention := defaultTrashRetention
+	if cfg, err := config.Load(); err == nil && cfg.TrashRetentionDays != 0 {
+		if cfg.TrashRetentionDays < 0 {
+			return
+		}
+		retention = time.Duration(cfg.TrashRetentionDays) * 24 * time.Hour
+	}
+	database.PurgeTrash(time.Now().Add(-retention))
+}
+
+var (
+	trashForce     bool
+	trashOlderThan time.Duration
+)
+
+// trashCmd groups the trash subcommands
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "List, restore or purge deleted variables",
+	Long: `Deleting a variable, with unset, edit, the TUI, apply or sync pull,
+moves it to the trash with its description, flags and tags. It can be
+restored for 30 days (trash_retention_days in the config file) and is then
+deleted for good.`,
+}
+
+// trashListCmd lists trashed vars in the current profile
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List deleted variables, most recent first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		purgeExpiredTrash(database)
+		list, err := database.ListTrash(resolver.GetProfile())
+		if err != nil {
+			return fmt.Errorf("failed to list trash: %w", err)
+		}
+		for _, t := range list {
+			line := fmt.Sprintf("%-4d %s  # %s, deleted %s", t.ID, t.Key, t.Path, t.DeletedAt.Local().Format("2006-01-02 15:04"))
+			if t.DeletedBy != "" {
+				line += " by " + t.DeletedBy
+			}
+			fmt.Println(line)
+		}
+		return nil
+	},
+}
+
+// trashRestoreCmd puts a trashed var back
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore ID|KEY",
+	Short: "Restore a deleted variable",
+	Long: `Restore a deleted variable where it was deleted from, by its ID from
+trash list, or by KEY for the latest KEY deleted from the current
+directory. A var set again since then is only replaced with --force.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		purgeExpiredTrash(database)
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			key := args[0]
+			if !shell.IsValidKey(key) {
+				return invalidf("invalid key: must match [A-Za-z_][A-Za-z0-9_]*")
+			}
+			cwd, err := getCwd()
+			if err != nil {
+				return err
+			}
+			canonical, err := envpath.Canonicalize(cwd)
+			if err != nil {
+				return err
+			}
+			list, err := database.ListTrash(resolver.GetProfile())
+			if err != nil {
+				return fmt.Errorf("failed to list trash: %w", err)
+			}
+			i := slices.IndexFunc(list, func(t db.TrashedVar) bool { return t.Key == key && t.Path == canonical })
+			if i < 0 {
+				return notFoundf("%s isn't in the trash for %s", key, atScope(canonical, resolver.GetProfile()))
+			}
+			id = list[i].ID
+		}
+
+		t, err := database.RestoreTrash(id, trashForce)
+		switch {
+		case errors.Is(err, db.ErrVarNotFound):
+			return notFoundf("nothing in the trash with ID %d", id)
+		case errors.Is(err, db.ErrVarExists):
+			return invalidf("%s has been set again since it was deleted; use --force to replace it", args[0])
+		case err != nil:
+			return fmt.Errorf("failed to restore variable: %w", err)
+		}
+		fmt.Printf("Restored %s at %s\n", t.Key, atScope(t.Path, t.Profile))
+		return nil
+	},
+}
+
+// trashPurgeCmd empties the trash
+var trashPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently delete trashed variables",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if trashOlderThan < 0 {
+			return invalidf("--older-than can't be negative")
+		}
+		database, _, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		n, err := database.PurgeTrash(time.Now().Add(-trashOlderThan))
+		if err != nil {
+			return fmt.Errorf("failed to purge trash: %w", err)
+		}
+		fmt.Printf("Purged %d variable(s)\n", n)
+		return nil
+	},
+}
+
+var (
+	archiveOutput  string
+	archiveDryRun  bool
+	unarchiveForce bool
+)
+
+// archivesDir returns the directory archives are written to by default.
+func archivesDir() (string, error) {
+	dir, err := db.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "archives"), nil
+}
+
+// archiveCmd moves a retired project's scopes out of the database
+var archiveCmd = &cobra.Command{
+	Use:   "archive DIR",
+	Short: "Save everything stored at DIR and below to a file, then remove it",
+	Long: `Retire a project: the vars, clears, aliases, tasks and policies stored at
+DIR and every directory below it, in every profile, are written to an
+archive file and then removed from the database, so they no longer show up
+in ls --all-scopes, lint, syncs or the TUI. DIR may already be deleted.
+
+Vars keep their descriptions, flags, tags and authors in the archive.
+Removed vars don't go to the trash; the archive is the copy. Archives are
+written to the archives folder in the data directory unless -o says
+otherwise. enva unarchive FILE puts everything back.`,
+	Example: `  enva archive ~/src/old-service
+  enva archive ~/src/old-service -o old-service.json
+  enva unarchive old-service.json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if args[0] == env.GlobalScope {
+			return invalidf("archive takes a directory; %s can't be archived", env.GlobalScope)
+		}
+		root, err := envpath.Canonicalize(args[0])
+		if err != nil {
+			return err
+		}
+
+		database, _, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		a, err := archive.Collect(database, root)
+		if err != nil {
+			return fmt.Errorf("failed to read scopes: %w", err)
+		}
+		if a.Empty() {
+			return notFoundf("nothing is stored at or below %s", root)
+		}
+		summary := fmt.Sprintf("%d var(s), %d clear(s), %d alias(es), %d task(s) from %d scope(s) under %s",
+			len(a.Vars), len(a.Clears), len(a.Aliases), len(a.Tasks), len(a.Paths()), root)
+		if archiveDryRun {
+			for _, p := range a.Paths() {
+				fmt.Println(p)
+			}
+			fmt.Printf("Would archive %s\n", summary)
+			return nil
+		}
+
+		out := archiveOutput
+		if out == "" {
+			dir, err := archivesDir()
+			if err != nil {
+				return fmt.Errorf("failed to get data directory: %w", err)
+			}
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return err
+			}
+			out = filepath.Join(dir, fmt.Sprintf("%s-%s.json", filepath.Base(root), a.ArchivedAt.Local().Format("20060102-150405")))
+		}
+		data, err := a.Encode()
+		if err != nil {
+			return err
+		}
+		// Vars may hold secrets, so the archive is private like the database
+		if err := os.WriteFile(out, data, 0600); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		if err := archive.Remove(database, a); err != nil {
+			return fmt.Errorf("failed to remove archived scopes (the archive at %s is complete): %w", out, err)
+		}
+		fmt.Printf("Archived %s to %s\n", summary, out)
+		return nil
+	},
+}
+
+// unarchiveCmd restores an archive written by enva archive
+var unarchiveCmd = &cobra.Command{
+	Use:   "unarchive FILE",
+	Short: "Restore the scopes saved by enva archive",
+	Long: `Put back everything enva archive saved to FILE, at the paths it was
+archived from. Vars set again there since then are only replaced with
+--force; nothing is restored until they are. FILE is left in place.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		a, err := archive.Decode(data)
+		if err != nil {
+			return invalidf("%v", err)
+		}
+
+		database, _, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		if !unarchiveForce {
+			conflicts, err := archive.Conflicts(database, a)
+			if err != nil {
+				return fmt.Errorf("failed to read database: %w", err)
+			}
+			for _, v := range conflicts {
+				fmt.Fprintf(os.Stderr, "  %s\n", v)
+			}
+			if len(conflicts) > 0 {
+				return invalidf("%d var(s) have been set again since they were archived; use --force to replace them", len(conflicts))
+			}
+		}
+		if err := archive.Restore(database, a); err != nil {
+			return fmt.Errorf("failed to restore archive: %w", err)
+		}
+		fmt.Printf("Restored %d var(s), %d clear(s), %d alias(es), %d task(s) under %s\n",
+			len(a.Vars), len(a.Clears), len(a.Aliases), len(a.Tasks), a.Root)
+		return nil
+	},
+}
+
+var (
+	captureFilters []string
+	captureDryRun  bool
+)
+
+// captureSkipped are per-session shell variables a --filter never captures.
+var captureSkipped = map[string]bool{"PWD": true, "OLDPWD": true, "SHLVL": true, "_": true, shell.SubshellVar: true}
+
+// captureCmd persists values from the calling shell's environment
+var captureCmd = &cobra.Command{
+	Use:   "capture [KEY...]",
+	Short: "Save variables from your current environment at current directory",
+	Long: `Copy variables from the environment enva runs in, i.e. your shell's
+exported variables, into the current directory scope. After getting a
+configuration working by hand with export, persist it in one go:
+
+  enva capture AWS_PROFILE AWS_REGION
+  enva capture --filter 'AWS_*' --filter 'KUBECONFIG'
+
+Variables whose value is what enva already exports here are skipped, as
+are enva's own and per-session shell variables (PWD, SHLVL, ...) matched
+by a --filter. Use --dry-run to see what would be saved.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && len(captureFilters) == 0 {
+			return invalidf("name the keys to capture, or pass --filter GLOB")
+		}
+		for _, key := range args {
+			if !shell.IsValidKey(key) {
+				return invalidf("invalid key %q: must match [A-Za-z_][A-Za-z0-9_]*", key)
+			}
+		}
+		for _, pattern := range captureFilters {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return invalidf("invalid --filter %q: %v", pattern, err)
+			}
+		}
+
+		captured := make(map[string]string)
+		for _, key := range args {
+			value, ok := os.LookupEnv(key)
+			if !ok {
+				return notFoundf("%s isn't set in your environment", key)
+			}
+			captured[key] = value
+		}
+		for _, kv := range os.Environ() {
+			key, value, _ := strings.Cut(kv, "=")
+			if !shell.IsValidKey(key) || captureSkipped[key] || strings.HasPrefix(key, "__ENVA_") {
+				continue
+			}
+			for _, pattern := range captureFilters {
+				if ok, _ := filepath.Match(pattern, key); ok {
+					captured[key] = value
+					break
+				}
+			}
+		}
+
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		cwd, err := getCwd()
+		if err != nil {
+			return err
+		}
+		ctx, err := resolver.Resolve(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to resolve environment: %w", err)
+		}
+		usageCtx = ctx
+
+		keys := slices.Sorted(maps.Keys(captured))
+		vars := make(map[string]db.VarData)
+		for _, key := range keys {
+			if v := ctx.Resolved[key]; v != nil && !v.Eval && v.Value == captured[key] {
+				fmt.Printf("Skipped %s (already set by enva)\n", key)
+				continue
+			}
+			vars[key] = db.VarData{Value: captured[key]}
+		}
+		if len(vars) == 0 {
+			if len(keys) == 0 {
+				fmt.Println("No variables in your environment match")
+			}
+			return nil
+		}
+
+		toSet := slices.Sorted(maps.Keys(vars))
+		if err := resolver.CheckKeys(cwd, toSet...); err != nil {
+			return err
+		}
+		values := make(map[string]string, len(vars))
+		for key, data := range vars {
+			values[key] = data.Value
+		}
+		warnings, err := resolver.CheckSecrets(cwd, values)
+		if err != nil {
+			return err
+		}
+		printSecretWarnings(warnings)
+		warnLint(resolver, cwd, values)
+
+		verb := "Captured"
+		if captureDryRun {
+			verb = "Would capture"
+		} else if err := resolver.SetVarsBatch(cwd, vars); err != nil {
+			return fmt.Errorf("failed to set variables: %w", err)
+		}
+		for _, key := range toSet {
+			fmt.Printf("%s %s at %s\n", verb, key, atScope(ctx.CwdReal, resolver.GetProfile()))
+		}
+		return nil
+	},
+}
+
+var lintAllScopes bool
+
+// lintCmd checks connection settings for mistakes
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check that URL, host and port values are well formed and agree",
+	Long: `Check the vars of every scope in effect here for connection settings that
+can't work:
+
+  *_URL   must parse as a URL with a scheme and host
+  *_PORT  must be a number from 1 to 65535
+  *_HOST  must be a host name or IP address, without a scheme or port
+
+Related keys in the same scope must also agree: with DB_URL, DB_HOST and
+DB_PORT all set, the URL's host must be DB_HOST and its port (or the
+scheme's default, such as 5432 for postgres://) must be DB_PORT.
+
+Exits 7 if there are problems. Set "lint_on_set" in the config to get
+the same warnings from set and capture.`,
+	Example: `  enva lint
+  enva lint --all-scopes`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, resolver, err := getDBAndResolver()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		var vars []db.EnvVar
+		if lintAllScopes {
+			if vars, err = database.GetAllVars(resolver.GetProfile()); err != nil {
+				return fmt.Errorf("failed to list variables: %w", err)
+			}
+		} else {
+			cwd, err := getCwd()
+			if err != nil {
+				return err
+			}
+			ctx, err := resolver.Resolve(cwd)
+			if err != nil {
+				return fmt.Errorf("failed to resolve environment: %w", err)
+			}
+			usageCtx = ctx
+			if vars, err = database.GetVarsForPaths(ctx.Chain, resolver.GetProfile()); err != nil {
+				return fmt.Errorf("failed to list variables: %w", err)
+			}
+		}
+
+		byPath := make(map[string]map[string]string)
+		for _, v := range vars {
+			if v.Eval {
+				continue
+			}
+			if byPath[v.Path] == nil {
+				byPath[v.Path] = make(map[string]string)
+			}
+			byPath[v.Path][v.Key] = v.Value
+		}
+
+		problems := 0
+		for _, path := range slices.Sorted(maps.Keys(byPath)) {
+			warnings := lint.Check(byPath[path])
+			if len(warnings) == 0 {
+				continue
+			}
+			if problems > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("[%s]\n", path)
+			for _, w := range warnings {
+				fmt.Println(w.Message)
+			}
+			problems += len(warnings)
+		}
+		if problems == 0 {
+			fmt.Println("No problems found")
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "enva: %d problem(s) found\n", problems)
+		exitStatus = exitLint
+		return nil
+	},
+}
+
+// scopeProblems returns the keys required by the project's .enva that
+// ctx's environment lacks, and how the directory's .env, if any, differs
+// from enva. Only the .env's own keys count: vars enva adds on top of it,
+// like global ones, aren't drift.
+func scopeProblems(ctx *env.ResolveContext) ([]manifest.Key, []drift, error) {
+	keys, err := manifest.Load(ctx.RootDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read required keys: %w", err)
+	}
+	missing := manifest.Missing(keys, effectiveEnviron(ctx))
+
+	path := filepath.Join(ctx.CwdReal, ".env")
+	if _, err := os.Stat(path); err != nil {
+		return missing, nil, nil
+	}
+	diffs, err := dotenvDrift(ctx.GetSortedVars(), path)
+	if err != nil {
+		return missing, nil, err
+	}
+	diffs = slices.DeleteFunc(diffs, func(d drift) bool { return d.mark == "+" })
+	return missing, diffs, nil
+}
+
+// driftAlertsPath returns the file recording when the hook last warned
+// about each directory.
+func driftAlertsPath() (string, error) {
+	dir, err := db.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "drift-alerts.json"), nil
+}
+
+// warnScopeDrift prints the hook's one-line nudge towards enva check when
+// drift_alerts is on and ctx's directory has problems, at most once a day
+// per directory.
+func warnScopeDrift(ctx *env.ResolveContext) {
+	if cfg, err := config.Load(); err != nil || !cfg.DriftAlerts {
+		return
+	}
+	path, err := driftAlertsPath()
+	if err != nil {
+		return
+	}
+	today := time.Now().Format(time.DateOnly)
+	alerted := map[string]string{}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &alerted)
+	}
+	if alerted[ctx.CwdReal] == today {
+		return
+	}
+
+	missing, diffs, err := scopeProblems(ctx)
+	if err != nil || len(missing)+len(diffs) == 0 {
+		return
+	}
+	var parts []string
+	if len(missing) > 0 {
+		parts = append(parts, fmt.Sprintf("%d required key(s) missing", len(missing)))
+	}
+	if len(diffs) > 0 {
+		parts = append(parts, fmt.Sprintf(".env differs in %d key(s)", len(diffs)))
+	}
+	msg := "enva: " + strings.Join(parts, ", ") + "; run enva check"
+	if os.Getenv("NO_COLOR") == "" {
+		msg = "\033[33m" + msg + "\033[0m"
+	}
+	fmt.Fprintln(os.Stderr, msg)
+
+	// Only today's entries matter, so older ones are dropped
+	next := map[string]string{ctx.CwdReal: today}
+	for dir, day := range alerted {
+		if day == today {
+			next[dir] = day
+		}
+	}
+	if data, err := json.Marshal(next); err == nil && os.MkdirAll(filepath.Dir(path), 0700) == nil {
+		os.WriteFile(path, data, 0600)
+	}
+}
+
+// checkCmd reports what the current directory's environment is missing
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Report missing required keys and .env drift for the current directory",
+	Long: `Check the environment this directory gets for:
+
+  - keys listed in the project's .enva file that aren't set, by enva or
+    the shell (enva run --prompt-missing asks for them)
+  - keys in this directory's .env file that enva doesn't set, or sets to a
+    different value (vars enva sets beyond the file are fine)
+  - an expired or invalid Vault session, when vault:// vars are in effect
+
+Values are never printed. Exits 6 if there are problems. With
+"drift_alerts": true in the config file, the shell hook runs the same
+checks when you enter a directory and prints a one-line warning, at most
+once a day per directory.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := getCwd()
+		if err != nil {
+			return err
 		}
+		ctx, err := resolveNow(cwd)
+		if err != nil {
+			return err
+		}
+		usageCtx = ctx
+		ctx.StripNoExport()
+		stripUntrusted(ctx)
+		vault, token := vaultKeys(ctx), vaultToken(ctx)
+		warnEvalFailed(evalRunnerFor(ctx).Apply(ctx))
 
-		// Track current path (only with --internal flag for shell hooks)
-		cwdReal := ctx.CwdReal
-		if exportInternal {
-			if len(keysList) > 0 {
-				fmt.Printf("export __ENVA_LOADED_KEYS='%s'\n", strings.Join(keysList, ":"))
-				fmt.Printf("export __ENVA_LOADED_PATH='%s'\n", cwdReal)
-			} else if prevKeysStr != "" {
-				fmt.Println("unset __ENVA_LOADED_KEYS")
-				fmt.Println("unset __ENVA_LOADED_PATH")
+		missing, diffs, err := scopeProblems(ctx)
+		if err != nil {
+			return err
+		}
+		problems := len(missing) + len(diffs)
+		if len(vault) > 0 {
+			if _, err := provider.VaultLookup(token); err != nil {
+				fmt.Printf("Vault session expired or invalid, needed by %s:\n  %v\n", strings.Join(vault, ", "), err)
+				fmt.Println("  Run vault login, or set VAULT_ROLE_ID and VAULT_SECRET_ID for AppRole")
+				problems++
+				if len(missing)+len(diffs) > 0 {
+					fmt.Println()
+				}
 			}
-
-			// Print status message to stderr (only for shell hooks)
-			if unsetCount > 0 && len(newVars) == 0 {
-				fmt.Fprintf(os.Stderr, "enva: unloaded %d var(s)\n", unsetCount)
-			} else if loadCount > 0 && prevPath != cwdReal {
-				fmt.Fprintf(os.Stderr, "enva: loaded %d var(s)\n", len(newVars))
-			} else if unsetCount > 0 || loadCount > 0 {
-				if prevPath != cwdReal {
-					fmt.Fprintf(os.Stderr, "enva: loaded %d var(s)\n", len(newVars))
+		}
+		if len(missing) > 0 {
+			fmt.Printf("Required by %s but not set:\n", filepath.Join(ctx.RootDir, manifest.FileName))
+			for _, k := range missing {
+				if k.Description != "" {
+					fmt.Printf("  %s  # %s\n", k.Name, k.Description)
+				} else {
+					fmt.Printf("  %s\n", k.Name)
 				}
 			}
 		}
-
+		if len(diffs) > 0 {
+			if len(missing) > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s differs from enva:\n", filepath.Join(ctx.CwdReal, ".env"))
+			printDriftLines(diffs)
+		}
+		if problems == 0 {
+			fmt.Println("No missing keys or .env drift")
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "enva: %d problem(s)\n", problems)
+		exitStatus = exitDrift
 		return nil
 	},
 }
 
-// setCmd sets a variable at current directory scope
-var setCmd = &cobra.Command{
-	Use:   "set KEY=VALUE",
-	Short: "Set an environment variable at current directory",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		key, value, ok := shell.ParseKeyValue(args[0])
-		if !ok {
-			return fmt.Errorf("invalid format: expected KEY=VALUE")
-		}
+var checkProfilesBase string
 
-		if !shell.IsValidKey(key) {
-			return fmt.Errorf("invalid key: must match [A-Za-z_][A-Za-z0-9_]*")
-		}
+// checkProfilesCmd compares the environment here across profiles
+var checkProfilesCmd = &cobra.Command{
+	Use:   "check-profiles [PROFILE...]",
+	Short: "Report keys set in the default profile but missing from others",
+	Long: `Compare the environment this directory gets in each PROFILE (default:
+every profile in the database) with what it gets in the --base profile,
+and report:
 
+  - keys set in the base profile but missing from the other
+  - keys whose values are of different kinds, such as PORT being a number
+    in default but text in production (kinds: empty, boolean, integer,
+    number, URL, JSON and text)
+
+Values are never printed. Keys only set in the other profile are fine,
+and --eval vars are only checked for presence. Exits 6 if there are
+differences, so CI can stop a deploy.`,
+	Example: `  enva check-profiles
+  enva check-profiles production staging
+  enva check-profiles --base staging production`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		database, resolver, err := getDBAndResolver()
 		if err != nil {
 			return err
 		}
 		defer database.Close()
 
-		cwd, err := os.Getwd()
+		cwd, err := getCwd()
 		if err != nil {
-			return fmt.Errorf("failed to get cwd: %w", err)
+			return err
 		}
 
-		if err := resolver.SetVar(cwd, key, value, ""); err != nil {
-			return fmt.Errorf("failed to set variable: %w", err)
+		profiles := args
+		if len(profiles) == 0 {
+			if profiles, err = database.ListProfiles(); err != nil {
+				return fmt.Errorf("failed to list profiles: %w", err)
+			}
+			if checkProfilesBase != env.DefaultProfile {
+				profiles = append([]string{env.DefaultProfile}, profiles...)
+			}
 		}
-
-		fmt.Printf("Set %s at %s\n", key, cwd)
-		return nil
-	},
-}
-
-// unsetCmd deletes a variable from current directory scope
-var unsetCmd = &cobra.Command{
-	Use:   "unset KEY",
-	Short: "Remove an environment variable from current directory",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		key := args[0]
-
-		if !shell.IsValidKey(key) {
-			return fmt.Errorf("invalid key: must match [A-Za-z_][A-Za-z0-9_]*")
+		seen := map[string]bool{checkProfilesBase: true}
+		profiles = slices.DeleteFunc(profiles, func(p string) bool {
+			dup := seen[p]
+			seen[p] = true
+			return dup
+		})
+		if len(profiles) == 0 {
+			fmt.Printf("No profiles to compare with %s\n", checkProfilesBase)
+			return nil
 		}
 
-		database, resolver, err := getDBAndResolver()
+		resolve := func(profile string) ([]*env.ResolvedVar, error) {
+			r := env.NewResolver(database, profile)
+			r.SetChainOptions(resolver.ChainOptions())
+			r.SetEnvFiles(resolver.EnvFiles())
+			ctx, err := r.Resolve(cwd)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve profile %s: %w", profile, err)
+			}
+			return ctx.GetSortedVars(), nil
+		}
+		base, err := resolve(checkProfilesBase)
 		if err != nil {
 			return err
 		}
-		defer database.Close()
 
-		cwd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("failed to get cwd: %w", err)
+		differences := 0
+		for _, profile := range profiles {
+			vars, err := resolve(profile)
+			if err != nil {
+				return err
+			}
+			problems := profilecheck.Compare(base, vars)
+			if len(problems) == 0 {
+				continue
+			}
+			if differences > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("[%s]\n", profile)
+			for _, p := range problems {
+				if p.Missing {
+					fmt.Printf("%s is missing (set in %s)\n", p.Key, checkProfilesBase)
+				} else {
+					fmt.Printf("%s is %s here but %s in %s\n", p.Key, p.Kind, p.BaseKind, checkProfilesBase)
+				}
+			}
+			differences += len(problems)
 		}
-
-		if err := resolver.DeleteVar(cwd, key); err != nil {
-			return fmt.Errorf("failed to unset variable: %w", err)
+		if differences == 0 {
+			fmt.Printf("%s matches %s\n", strings.Join(profiles, ", "), checkProfilesBase)
+			return nil
 		}
-
-		fmt.Printf("Unset %s at %s\n", key, cwd)
+		fmt.Fprintf(os.Stderr, "enva: %d difference(s) from %s\n", differences, checkProfilesBase)
+		exitStatus = exitDrift
 		return nil
 	},
 }
 
-// lsCmd lists effective variables
-var lsCmd = &cobra.Command{
-	Use:   "ls",
-	Short: "List effective environment variables",
+var graphFormat string
+
+// graphCmd draws the scopes under the current root
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Draw the project's scopes and overrides as DOT or Mermaid",
+	Long: `Print a graph of the scopes under the current project root: one node per
+scope listing the keys it defines, an edge from each scope to the scopes
+nested below it, and a dashed edge for the keys a scope overrides from a
+scope above. Values are never printed, so the output is safe to commit
+alongside your docs.
+
+Render DOT with Graphviz, or paste Mermaid into Markdown.`,
+	Example: `  enva graph | dot -Tsvg > env.svg
+  enva graph --format mermaid`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if graphFormat != "dot" && graphFormat != "mermaid" {
+			return invalidf("unknown format %q (use dot or mermaid)", graphFormat)
+		}
+
 		database, resolver, err := getDBAndResolver()
 		if err != nil {
 			return err
 		}
 		defer database.Close()
 
-		cwd, err := os.Getwd()
+		cwd, err := getCwd()
 		if err != nil {
-			return fmt.Errorf("failed to get cwd: %w", err)
+			return err
 		}
-
 		ctx, err := resolver.Resolve(cwd)
 		if err != nil {
 			return fmt.Errorf("failed to resolve environment: %w", err)
 		}
+		usageCtx = ctx
 
-		vars := ctx.GetSortedVars()
-		for _, v := range vars {
-			fmt.Printf("%s=%s\n", v.Key, v.Value)
+		vars, err := database.GetAllVars(resolver.GetProfile())
+		if err != nil {
+			return fmt.Errorf("failed to list variables: %w", err)
+		}
+
+		g := graph.Build(ctx.RootDir, vars)
+		if graphFormat == "mermaid" {
+			fmt.Print(g.Mermaid())
+		} else {
+			fmt.Print(g.DOT())
 		}
 		return nil
 	},
 }
 
-// editCmd opens $EDITOR for editing local vars
-var editCmd = &cobra.Command{
-	Use:   "edit",
-	Short: "Edit local environment variables in $EDITOR",
-	Long: `Opens $EDITOR with KEY=VALUE lines for local variables at the current
-directory. After saving, parses the file and applies changes (upserts/deletes).`,
-	RunE: func(cmd *cobra.Command, args []string) error {
-		editor := os.Getenv("EDITOR")
-		if editor == "" {
-			editor = "vi"
+// warnLint prints the lint warnings that setting values at path would
+// raise, if lint_on_set is enabled.
+func warnLint(resolver *env.Resolver, path string, values map[string]string) {
+	cfg, err := config.Load()
+	if err != nil || !cfg.LintOnSet {
+		return
+	}
+	existing, err := resolver.GetLocalVarsFromDB(path)
+	if err != nil {
+		return
+	}
+	scope := make(map[string]string, len(existing)+len(values))
+	for _, v := range existing {
+		if !v.Eval {
+			scope[v.Key] = v.Value
+		}
+	}
+	maps.Copy(scope, values)
+	for _, w := range lint.Check(scope) {
+		if slices.ContainsFunc(w.Keys, func(k string) bool { _, ok := values[k]; return ok }) {
+			fmt.Fprintf(os.Stderr, "enva: warning: %s\n", w.Message)
 		}
+	}
+}
+
+var (
+	envFileInterval time.Duration
+	envFilePrefer   string
+	envFileOnce     bool
+)
+
+// envFileCmd groups the .env file integrations
+var envFileCmd = &cobra.Command{
+	Use:   "env-file",
+	Short: "Keep a .env file in sync with the current directory's scope",
+}
+
+// envFileWatchCmd syncs a .env file and the local scope in both directions
+var envFileWatchCmd = &cobra.Command{
+	Use:   "watch [FILE]",
+	Short: "Keep a .env file and the current directory's vars in sync both ways",
+	Long: `For tools that only read .env, keep running and keep FILE (.env by
+default) and the vars set at the current directory in step: edits to the
+file are saved to enva, and changes made with enva (set, the TUI, sync
+pull) are written to the file. Both sides are checked every --interval.
 
+Changes are merged key by key against the last synced state, so only what
+changed on each side is copied and the sync never echoes its own writes.
+When both sides changed the same key, --prefer picks the winner (enva by
+default) and the conflict is logged to stderr without values. The first
+pass only adds keys missing on either side; nothing is deleted until
+both sides have been seen in sync. If the file disappears, as during a
+checkout or an editor's save, syncing pauses until it's back; while it
+has lines that don't parse, keys missing from it aren't deleted.
+
+Comments and unchanged lines in the file are kept. Eval vars aren't
+written to the file, and the file can't replace them. A new file is
+created readable only by you.`,
+	Example: `  enva env-file watch
+  enva env-file watch .env.local --prefer file
+  enva env-file watch --once`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if envFilePrefer != "enva" && envFilePrefer != "file" {
+			return invalidf("--prefer must be enva or file, not %q", envFilePrefer)
+		}
+		if envFileInterval < 100*time.Millisecond {
+			return invalidf("--interval must be at least 100ms")
+		}
 		database, resolver, err := getDBAndResolver()
 		if err != nil {
 			return err
 		}
 		defer database.Close()
 
-		cwd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("failed to get cwd: %w", err)
-		}
-
-		cwdCanon, err := envpath.Canonicalize(cwd)
+		cwd, err := getCwd()
 		if err != nil {
-			return fmt.Errorf("failed to canonicalize cwd: %w", err)
+			return err
 		}
-
-		// Get current local vars
-		localVars, err := resolver.GetLocalVarsFromDB(cwd)
+		dir, err := envpath.Canonicalize(cwd)
 		if err != nil {
-			return fmt.Errorf("failed to get local vars: %w", err)
+			return err
 		}
-
-		// Build content
-		var lines []string
-		sort.Slice(localVars, func(i, j int) bool {
-			return localVars[i].Key < localVars[j].Key
-		})
-		for _, v := range localVars {
-			lines = append(lines, fmt.Sprintf("%s=%s", v.Key, v.Value))
+		file := ".env"
+		if len(args) == 1 {
+			file = args[0]
 		}
-		content := strings.Join(lines, "\n")
-		if content != "" {
-			content += "\n"
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(cwd, file)
 		}
 
-		// Create temp file
-		tmpFile, err := os.CreateTemp("", "enva-edit-*.env")
-		if err != nil {
-			return fmt.Errorf("failed to create temp file: %w", err)
+		syncer := &envFileSync{resolver: resolver, dir: dir, file: file, preferFile: envFilePrefer == "file"}
+		if err := syncer.run(); err != nil {
+			return err
 		}
-		tmpPath := tmpFile.Name()
-		defer os.Remove(tmpPath)
-
-		if _, err := tmpFile.WriteString(content); err != nil {
-			tmpFile.Close()
-			return fmt.Errorf("failed to write temp file: %w", err)
+		if envFileOnce {
+			return nil
 		}
-		tmpFile.Close()
-
-		// Open editor
-		editorCmd := exec.Command(editor, tmpPath)
-		editorCmd.Stdin = os.Stdin
-		editorCmd.Stdout = os.Stdout
-		editorCmd.Stderr = os.Stderr
+		fmt.Fprintf(os.Stderr, "enva: syncing %s with %s\n", file, atScope(dir, resolver.GetProfile()))
 
-		if err := editorCmd.Run(); err != nil {
-			return fmt.Errorf("editor failed: %w", err)
+		sig, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		for {
+			select {
+			case <-sig.Done():
+				return nil
+			case <-time.After(envFileInterval):
+			}
+			if err := syncer.run(); err != nil {
+				fmt.Fprintf(os.Stderr, "enva: %v\n", err)
+			}
 		}
+	},
+}
 
-		// Read updated content
-		newContent, err := os.ReadFile(tmpPath)
-		if err != nil {
-			return fmt.Errorf("failed to read temp file: %w", err)
-		}
+// envFileSync is the state of a two-way sync between a .env file and a
+// scope.
+type envFileSync struct {
+	resolver   *env.Resolver
+	dir        string
+	file       string
+	preferFile bool
+	base       map[string]db.VarData // Values at the last sync; nil before the first
+	content    string                // File content at the last sync
+	missing    bool                  // The file vanished after a sync; waiting for it
+}
 
-		// Parse new content with descriptions
-		parsed, invalid := shell.ParseEnvFileWithDesc(string(newContent))
-		if len(invalid) > 0 {
-			return fmt.Errorf("invalid lines in file: %v", invalid)
+// run copies the changes made to either side since the last sync to the
+// other one.
+func (s *envFileSync) run() error {
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, fs.ErrNotExist) && s.base != nil {
+		// Checkouts, editors and generators often delete and recreate the
+		// file; that mustn't read as every key being removed
+		if !s.missing {
+			fmt.Fprintf(os.Stderr, "enva: %s is missing; pausing until it's back\n", filepath.Base(s.file))
+			s.missing = true
 		}
-
-		// Convert to db.VarData
-		newVars := make(map[string]db.VarData)
-		for k, v := range parsed {
-			newVars[k] = db.VarData{Value: v.Value, Description: v.Description}
+		return nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if s.missing {
+		fmt.Fprintf(os.Stderr, "enva: %s is back; syncing\n", filepath.Base(s.file))
+		s.missing = false
+	}
+	if again, err := os.ReadFile(s.file); err == nil && !bytes.Equal(again, data) {
+		// Caught mid-write: try again next round rather than act on half a file
+		return nil
+	}
+	content := string(data)
+	values, invalid := shell.ParseDotenv(content)
+	if content != s.content {
+		for _, line := range invalid {
+			fmt.Fprintf(os.Stderr, "enva: %s: skipping invalid line: %s\n", filepath.Base(s.file), redact.Line(line))
 		}
+	}
 
-		// Sync vars
-		if err := resolver.SyncLocalVars(cwdCanon, newVars); err != nil {
-			return fmt.Errorf("failed to sync vars: %w", err)
+	vars, err := s.resolver.GetLocalVarsFromDB(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read variables: %w", err)
+	}
+	existing := make(map[string]db.EnvVar, len(vars))
+	mine := make(map[string]db.VarData, len(vars))
+	for _, v := range vars {
+		existing[v.Key] = v
+		if !v.Eval {
+			mine[v.Key] = db.VarData{Value: v.Value}
 		}
+	}
+	theirs := make(map[string]db.VarData, len(values))
+	for key, value := range values {
+		if v, ok := existing[key]; ok && v.Eval {
+			continue
+		}
+		theirs[key] = db.VarData{Value: value}
+	}
 
-		fmt.Printf("Updated local vars at %s\n", cwdCanon)
-		return nil
-	},
-}
-
-// runCmd executes a command with the effective environment
-var runCmd = &cobra.Command{
-	Use:   "run -- COMMAND [ARGS...]",
-	Short: "Run a command with effective environment",
-	Long: `Executes the given command with the effective environment variables
-merged into the current process environment.`,
-	DisableFlagParsing: true,
-	RunE: func(cmd *cobra.Command, args []string) error {
-		// Find -- separator
-		cmdArgs := args
-		for i, arg := range args {
-			if arg == "--" {
-				cmdArgs = args[i+1:]
-				break
+	base := s.base
+	if base == nil {
+		// Without a common state, keys only on one side are added to the
+		// other rather than deleted
+		base = make(map[string]db.VarData)
+	}
+	kept := make(map[string]bool)
+	if len(invalid) > 0 {
+		// A key on a line that no longer parses wasn't necessarily removed,
+		// so only deletions made in enva go through until it's fixed
+		for key, d := range base {
+			if _, ok := theirs[key]; !ok {
+				theirs[key] = d
+				kept[key] = true
 			}
 		}
-
-		if len(cmdArgs) == 0 {
-			return fmt.Errorf("no command specified")
+	}
+	res := merge.ThreeWay(base, mine, theirs)
+	now := time.Now().Format(time.TimeOnly)
+	for _, c := range res.Conflicts {
+		merge.Resolve(res.Merged, c, !s.preferFile)
+		winner := "enva"
+		if s.preferFile {
+			winner = filepath.Base(s.file)
 		}
+		fmt.Fprintf(os.Stderr, "%s conflict: %s was changed differently in %s and enva; kept %s's value\n", now, c.Key, filepath.Base(s.file), winner)
+	}
 
-		database, resolver, err := getDBAndResolver()
-		if err != nil {
+	// Save the file's changes, keeping descriptions and flags
+	toSet := make(map[string]db.VarData)
+	var toDelete []string
+	for key, d := range res.Merged {
+		if m, ok := mine[key]; ok && m == d {
+			continue
+		}
+		v := existing[key]
+		toSet[key] = db.VarData{Value: d.Value, Description: v.Description}
+	}
+	for key := range mine {
+		if _, ok := res.Merged[key]; !ok {
+			toDelete = append(toDelete, key)
+		}
+	}
+	if len(toSet) > 0 {
+		if err := s.resolver.CheckKeys(s.dir, slices.Sorted(maps.Keys(toSet))...); err != nil {
 			return err
 		}
-		defer database.Close()
-
-		cwd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("failed to get cwd: %w", err)
+		if err := s.resolver.SetVarsBatch(s.dir, toSet); err != nil {
+			return fmt.Errorf("failed to set variables: %w", err)
 		}
-
-		ctx, err := resolver.Resolve(cwd)
-		if err != nil {
-			return fmt.Errorf("failed to resolve environment: %w", err)
+	}
+	if len(toDelete) > 0 {
+		if err := s.resolver.DeleteVarsBatch(s.dir, toDelete); err != nil {
+			return fmt.Errorf("failed to delete variables: %w", err)
 		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(toSet)) {
+		fmt.Printf("%s %s: %s -> enva\n", now, key, filepath.Base(s.file))
+	}
+	slices.Sort(toDelete)
+	for _, key := range toDelete {
+		fmt.Printf("%s %s: removed from %s -> enva\n", now, key, filepath.Base(s.file))
+	}
 
-		// Build environment: current env + enva vars
-		envMap := make(map[string]string)
-		for _, e := range os.Environ() {
-			parts := strings.SplitN(e, "=", 2)
-			if len(parts) == 2 {
-				envMap[parts[0]] = parts[1]
-			}
+	// Write enva's changes to the file
+	merged := make(map[string]string, len(res.Merged))
+	for key, d := range res.Merged {
+		if kept[key] && d == base[key] {
+			continue // Still on its unparsable line; don't add it twice
 		}
-
-		// Override with enva vars
-		for _, v := range ctx.GetSortedVars() {
-			envMap[v.Key] = v.Value
+		merged[key] = d.Value
+	}
+	if updated := shell.RewriteDotenv(content, merged); updated != content {
+		if err := writeFileAtomic(s.file, []byte(updated)); err != nil {
+			return err
 		}
-
-		// Convert back to slice
-		var environ []string
-		for k, v := range envMap {
-			environ = append(environ, fmt.Sprintf("%s=%s", k, v))
+		for _, key := range slices.Sorted(maps.Keys(merged)) {
+			if t, ok := theirs[key]; !ok || t.Value != merged[key] {
+				fmt.Printf("%s %s: enva -> %s\n", now, key, filepath.Base(s.file))
+			}
 		}
-		sort.Strings(environ)
-
-		// Find command path
-		cmdPath, err := exec.LookPath(cmdArgs[0])
-		if err != nil {
-			return fmt.Errorf("command not found: %s", cmdArgs[0])
+		for _, key := range slices.Sorted(maps.Keys(theirs)) {
+			if _, ok := merged[key]; !ok && !kept[key] {
+				fmt.Printf("%s %s: removed in enva -> %s\n", now, key, filepath.Base(s.file))
+			}
 		}
+		content = updated
+	}
 
-		// Exec (replaces current process)
-		return syscall.Exec(cmdPath, cmdArgs, environ)
-	},
+	s.base = res.Merged
+	s.content = content
+	return nil
 }
 
-// tuiCmd launches the TUI
-var tuiCmd = &cobra.Command{
-	Use:   "tui",
-	Short: "Launch interactive TUI",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		database, resolver, err := getDBAndResolver()
-		if err != nil {
-			return err
-		}
-		defer database.Close()
-
-		cwd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("failed to get cwd: %w", err)
-		}
-
-		return tui.Run(database, resolver, cwd)
-	},
+// writeFileAtomic replaces path with data through a temporary file, so a
+// reader never sees it half written. An existing file keeps its mode; a new
+// one is private.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
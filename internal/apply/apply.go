@@ -0,0 +1,269 @@
+// Package apply applies declarative JSON change documents to the database.
+package apply
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nick-skriabin/enva/internal/db"
+	envpath "github.com/nick-skriabin/enva/internal/path"
+	"github.com/nick-skriabin/enva/internal/shell"
+)
+
+// Document is a set of changes grouped by scope.
+//
+//	{
+//	  "scopes": [
+//	    {
+//	      "path": "~/projects/app",
+//	      "profile": "default",
+//	      "set": {"API_URL": "http://localhost", "TOKEN": {"value": "x", "description": "API token"}},
+//	      "unset": ["OLD_KEY"]
+//	    }
+//	  ]
+//	}
+type Document struct {
+	Scopes []Scope `json:"scopes"`
+}
+
+// Scope holds the changes for one path/profile. Empty profile means the default.
+type Scope struct {
+	Path    string           `json:"path"`
+	Profile string           `json:"profile,omitempty"`
+	Set     map[string]Value `json:"set,omitempty"`
+	Unset   []string         `json:"unset,omitempty"`
+}
+
+// Value is a variable value, given either as a string or as
+// {"value": ..., "description": ...}.
+type Value struct {
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+}
+
+// UnmarshalJSON accepts both the string and object forms.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = Value{Value: s}
+		return nil
+	}
+	type plain Value
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("value must be a string or {\"value\", \"description\"} object")
+	}
+	*v = Value(p)
+	return nil
+}
+
+// ChangeKind classifies a single planned change.
+type ChangeKind int
+
+const (
+	ChangeAdd ChangeKind = iota
+	ChangeUpdate
+	ChangeUnchanged
+	ChangeDelete
+)
+
+// Change is a single planned key change.
+type Change struct {
+	Kind    ChangeKind
+	Path    string
+	Profile string
+	Key     string
+}
+
+// String formats the change as a summary line.
+func (c Change) String() string {
+	var sym string
+	switch c.Kind {
+	case ChangeAdd:
+		sym = "+"
+	case ChangeUpdate:
+		sym = "~"
+	case ChangeUnchanged:
+		sym = "="
+	case ChangeDelete:
+		sym = "-"
+	}
+	return fmt.Sprintf("%s %s (%s @ %s)", sym, c.Key, c.Profile, c.Path)
+}
+
+// Summary reports the outcome of an apply. Changes are counted against
+// each scope's own vars, not what the scope inherits: setting a key a
+// parent scope also has is an add, and unsetting it a delete, though the
+// parent's value still applies there.
+type Summary struct {
+	Changes   []Change
+	Added     int
+	Updated   int
+	Unchanged int
+	Deleted   int
+}
+
+// Checker vets the values about to be set at path, by key, returning an
+// error to stop the whole apply. The naming and secrets policies live with
+// the resolver, so the caller supplies it.
+type Checker func(path string, set map[string]string) error
+
+// run calls check, if there is one, on the sets in changes, in order.
+func (check Checker) run(changes []db.ScopeChange) error {
+	if check == nil {
+		return nil
+	}
+	for _, c := range changes {
+		if len(c.Set) == 0 {
+			continue
+		}
+		set := make(map[string]string, len(c.Set))
+		for k, v := range c.Set {
+			set[k] = v.Value
+		}
+		if err := check(c.Path, set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse decodes and validates a change document.
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid document: %w", err)
+	}
+
+	for i, s := range doc.Scopes {
+		if s.Path == "" {
+			return nil, fmt.Errorf("scope %d: missing path", i)
+		}
+		for k := range s.Set {
+			if !shell.IsValidKey(k) {
+				return nil, fmt.Errorf("scope %d: invalid key %q", i, k)
+			}
+		}
+		for _, k := range s.Unset {
+			if !shell.IsValidKey(k) {
+				return nil, fmt.Errorf("scope %d: invalid key %q", i, k)
+			}
+			if _, ok := s.Set[k]; ok {
+				return nil, fmt.Errorf("scope %d: key %q is both set and unset", i, k)
+			}
+		}
+	}
+
+	return &doc, nil
+}
+
+// Apply plans the document against the database, runs check on every
+// scope's sets and, unless dryRun is set, applies every change in one
+// transaction. If check rejects any scope, nothing is applied. Scopes
+// without a profile use defaultProfile.
+func Apply(database db.Store, doc *Document, defaultProfile string, dryRun bool, check Checker) (*Summary, error) {
+	summary := &Summary{}
+	var changes []db.ScopeChange
+
+	for _, s := range doc.Scopes {
+		path, err := resolvePath(s.Path)
+		if err != nil {
+			return nil, fmt.Errorf("scope %s: %w", s.Path, err)
+		}
+		profile := s.Profile
+		if profile == "" {
+			profile = defaultProfile
+		}
+
+		existing, err := database.GetVarsForPath(path, profile)
+		if err != nil {
+			return nil, err
+		}
+		current := make(map[string]db.EnvVar)
+		for _, v := range existing {
+			current[v.Key] = v
+		}
+
+		sc := db.ScopeChange{Path: path, Profile: profile, Set: make(map[string]db.VarData)}
+
+		keys := make([]string, 0, len(s.Set))
+		for k := range s.Set {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			val := s.Set[k]
+			c := Change{Path: path, Profile: profile, Key: k, Kind: ChangeAdd}
+			if old, ok := current[k]; ok {
+				if old.Value == val.Value && old.Description == val.Description {
+					c.Kind = ChangeUnchanged
+				} else {
+					c.Kind = ChangeUpdate
+				}
+			}
+			if c.Kind != ChangeUnchanged {
+				sc.Set[k] = db.VarData{Value: val.Value, Description: val.Description}
+			}
+			summary.record(c)
+		}
+
+		for _, k := range s.Unset {
+			if _, ok := current[k]; !ok {
+				continue
+			}
+			sc.Delete = append(sc.Delete, k)
+			summary.record(Change{Path: path, Profile: profile, Key: k, Kind: ChangeDelete})
+		}
+
+		changes = append(changes, sc)
+	}
+
+	if err := check.run(changes); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return summary, nil
+	}
+
+	if err := database.ApplyChanges(changes); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+func (s *Summary) record(c Change) {
+	s.Changes = append(s.Changes, c)
+	switch c.Kind {
+	case ChangeAdd:
+		s.Added++
+	case ChangeUpdate:
+		s.Updated++
+	case ChangeUnchanged:
+		s.Unchanged++
+	case ChangeDelete:
+		s.Deleted++
+	}
+}
+
+// resolvePath expands a leading ~ and canonicalizes the path. Unlike the
+// interactive commands, a document may only target directories that exist.
+func resolvePath(p string) (string, error) {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = filepath.Join(home, p[1:])
+	}
+	if _, err := os.Stat(p); err != nil {
+		return "", err
+	}
+	return envpath.Canonicalize(p)
+}
@@ -0,0 +1,148 @@
+package apply
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/nick-skriabin/enva/internal/db"
+)
+
+func setupTestDB(t *testing.T) (db.Store, string) {
+	t.Helper()
+
+	tmpDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+
+	database, err := db.Open("memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	return database, tmpDir
+}
+
+func TestParse(t *testing.T) {
+	doc, err := Parse([]byte(`{"scopes":[{"path":"/x","set":{"A":"1","B":{"value":"2","description":"bee"}},"unset":["C"]}]}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	s := doc.Scopes[0]
+	if s.Set["A"].Value != "1" {
+		t.Errorf("A = %+v", s.Set["A"])
+	}
+	if s.Set["B"].Value != "2" || s.Set["B"].Description != "bee" {
+		t.Errorf("B = %+v", s.Set["B"])
+	}
+	if len(s.Unset) != 1 || s.Unset[0] != "C" {
+		t.Errorf("Unset = %v", s.Unset)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"bad json", `{`},
+		{"missing path", `{"scopes":[{"set":{"A":"1"}}]}`},
+		{"invalid key", `{"scopes":[{"path":"/x","set":{"1A":"1"}}]}`},
+		{"set and unset", `{"scopes":[{"path":"/x","set":{"A":"1"},"unset":["A"]}]}`},
+		{"unknown field", `{"scopes":[{"path":"/x","sett":{}}]}`},
+		{"bad value", `{"scopes":[{"path":"/x","set":{"A":1}}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.doc)); err == nil {
+				t.Error("Parse should fail")
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	database, dir := setupTestDB(t)
+
+	database.SetVar(dir, "default", "SAME", "s", "")
+	database.SetVar(dir, "default", "CHANGE", "old", "")
+	database.SetVar(dir, "default", "GONE", "g", "")
+
+	doc := &Document{Scopes: []Scope{{
+		Path: dir,
+		Set: map[string]Value{
+			"SAME":   {Value: "s"},
+			"CHANGE": {Value: "new"},
+			"ADDED":  {Value: "a"},
+		},
+		Unset: []string{"GONE", "MISSING"},
+	}}}
+
+	// Dry run leaves the database untouched
+	summary, err := Apply(database, doc, "default", true, nil)
+	if err != nil {
+		t.Fatalf("Apply dry run failed: %v", err)
+	}
+	if summary.Added != 1 || summary.Updated != 1 || summary.Unchanged != 1 || summary.Deleted != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if v, _ := database.GetVar(dir, "default", "ADDED"); v != nil {
+		t.Error("dry run should not write")
+	}
+
+	if _, err := Apply(database, doc, "default", false, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	vars, _ := database.GetVarsForPath(dir, "default")
+	got := make(map[string]string)
+	for _, v := range vars {
+		got[v.Key] = v.Value
+	}
+	want := map[string]string{"SAME": "s", "CHANGE": "new", "ADDED": "a"}
+	if len(got) != len(want) {
+		t.Fatalf("vars = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestApplyChecker(t *testing.T) {
+	database, dir := setupTestDB(t)
+
+	doc := &Document{Scopes: []Scope{{Path: dir, Set: map[string]Value{"OK": {Value: "1"}, "LD_PRELOAD": {Value: "x.so"}}}}}
+	var checked []string
+	check := func(path string, set map[string]string) error {
+		checked = append(checked, path)
+		if _, ok := set["LD_PRELOAD"]; ok {
+			return errors.New("LD_PRELOAD is banned")
+		}
+		return nil
+	}
+	for _, dryRun := range []bool{true, false} {
+		if _, err := Apply(database, doc, "default", dryRun, check); err == nil {
+			t.Errorf("Apply (dry run %v) should fail the check", dryRun)
+		}
+	}
+	if len(checked) != 2 || checked[0] != dir {
+		t.Errorf("checked %v", checked)
+	}
+	if v, _ := database.GetVar(dir, "default", "OK"); v != nil {
+		t.Error("nothing should be applied when a key is rejected")
+	}
+}
+
+func TestApplyMissingPath(t *testing.T) {
+	database, dir := setupTestDB(t)
+
+	doc := &Document{Scopes: []Scope{{Path: filepath.Join(dir, "nope"), Set: map[string]Value{"A": {Value: "1"}}}}}
+	if _, err := Apply(database, doc, "default", false, nil); err == nil {
+		t.Error("Apply should fail for nonexistent path")
+	}
+}
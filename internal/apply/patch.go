@@ -0,0 +1,323 @@
+package apply
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nick-skriabin/enva/internal/db"
+	"github.com/nick-skriabin/enva/internal/env"
+	"github.com/nick-skriabin/enva/internal/shell"
+)
+
+// PatchVersion is the patch format enva diff --json writes and enva apply
+// --patch reads. It changes only if the format does, incompatibly.
+const PatchVersion = 1
+
+// Patch operations.
+const (
+	OpAdd    = "add"
+	OpChange = "change"
+	OpRemove = "remove"
+)
+
+// Patch is a list of key changes, written in a stable order (scope,
+// profile, key) with fields in a fixed order, so equal diffs are equal
+// byte for byte.
+//
+//	{
+//	  "version": 1,
+//	  "ops": [
+//	    {"op": "add", "scope": "/home/me/app", "profile": "default", "key": "PORT", "value": "8080"},
+//	    {"op": "change", "scope": "/home/me/app", "profile": "default", "key": "DEBUG",
+//	     "value": "0", "old_value": "1"},
+//	    {"op": "remove", "scope": "@global", "profile": "default", "key": "OLD", "old_value": "x"}
+//	  ]
+//	}
+//
+// old_value and old_description are preconditions: the op only applies if
+// the key still has them. A change that leaves out value or description
+// keeps the current one. Patches carry values and descriptions only;
+// flags and tags are left as they are.
+type Patch struct {
+	Version int       `json:"version"`
+	Ops     []PatchOp `json:"ops"`
+}
+
+// PatchOp adds, changes or removes one key.
+type PatchOp struct {
+	Op             string  `json:"op"`
+	Scope          string  `json:"scope"`
+	Profile        string  `json:"profile"`
+	Key            string  `json:"key"`
+	Value          *string `json:"value,omitempty"`
+	Description    *string `json:"description,omitempty"`
+	OldValue       *string `json:"old_value,omitempty"`
+	OldDescription *string `json:"old_description,omitempty"`
+}
+
+// String formats the op as a summary line, like Change.
+func (o PatchOp) String() string {
+	sym := map[string]string{OpAdd: "+", OpChange: "~", OpRemove: "-"}[o.Op]
+	return fmt.Sprintf("%s %s (%s @ %s)", sym, o.Key, o.Profile, o.Scope)
+}
+
+// Entry is one var of a state Diff compares.
+type Entry struct {
+	Scope       string
+	Profile     string
+	Key         string
+	Value       string
+	Description string
+}
+
+// Diff returns the patch that turns from into to.
+func Diff(from, to []Entry) *Patch {
+	type id struct{ scope, profile, key string }
+	index := func(entries []Entry) map[id]Entry {
+		m := make(map[id]Entry, len(entries))
+		for _, e := range entries {
+			m[id{e.Scope, e.Profile, e.Key}] = e
+		}
+		return m
+	}
+	before, after := index(from), index(to)
+
+	p := &Patch{Version: PatchVersion, Ops: []PatchOp{}}
+	for k, a := range after {
+		b, ok := before[k]
+		switch {
+		case !ok:
+			op := PatchOp{Op: OpAdd, Scope: k.scope, Profile: k.profile, Key: k.key, Value: ptr(a.Value)}
+			if a.Description != "" {
+				op.Description = ptr(a.Description)
+			}
+			p.Ops = append(p.Ops, op)
+		case a.Value != b.Value || a.Description != b.Description:
+			p.Ops = append(p.Ops, PatchOp{
+				Op: OpChange, Scope: k.scope, Profile: k.profile, Key: k.key,
+				Value: ptr(a.Value), Description: ptr(a.Description),
+				OldValue: ptr(b.Value), OldDescription: ptr(b.Description),
+			})
+		}
+	}
+	for k, b := range before {
+		if _, ok := after[k]; !ok {
+			p.Ops = append(p.Ops, PatchOp{
+				Op: OpRemove, Scope: k.scope, Profile: k.profile, Key: k.key,
+				OldValue: ptr(b.Value), OldDescription: ptr(b.Description),
+			})
+		}
+	}
+
+	sort.Slice(p.Ops, func(i, j int) bool {
+		a, b := p.Ops[i], p.Ops[j]
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+		return a.Key < b.Key
+	})
+	return p
+}
+
+// Encode writes the patch as indented JSON.
+func (p *Patch) Encode() ([]byte, error) {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ParsePatch decodes and validates a patch.
+func ParsePatch(data []byte) (*Patch, error) {
+	var p Patch
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", err)
+	}
+	if p.Version != PatchVersion {
+		return nil, fmt.Errorf("invalid patch: version %d (this enva reads version %d)", p.Version, PatchVersion)
+	}
+
+	seen := make(map[string]int)
+	for i, op := range p.Ops {
+		if op.Scope == "" {
+			return nil, fmt.Errorf("op %d: missing scope", i)
+		}
+		if !shell.IsValidKey(op.Key) {
+			return nil, fmt.Errorf("op %d: invalid key %q", i, op.Key)
+		}
+		switch op.Op {
+		case OpAdd:
+			if op.Value == nil {
+				return nil, fmt.Errorf("op %d: add %s has no value", i, op.Key)
+			}
+		case OpChange:
+			if op.Value == nil && op.Description == nil {
+				return nil, fmt.Errorf("op %d: change %s has no value or description", i, op.Key)
+			}
+		case OpRemove:
+			if op.Value != nil || op.Description != nil {
+				return nil, fmt.Errorf("op %d: remove %s can't have a value or description", i, op.Key)
+			}
+		default:
+			return nil, fmt.Errorf("op %d: unknown op %q (use add, change or remove)", i, op.Op)
+		}
+		id := op.Scope + "\x00" + op.Profile + "\x00" + op.Key
+		if prev, ok := seen[id]; ok {
+			return nil, fmt.Errorf("op %d: %s is also changed by op %d", i, op.Key, prev)
+		}
+		seen[id] = i
+	}
+	return &p, nil
+}
+
+// ConflictError lists the ops whose preconditions don't hold.
+type ConflictError struct {
+	Conflicts []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%d op(s) don't match the database:\n  %s", len(e.Conflicts), strings.Join(e.Conflicts, "\n  "))
+}
+
+// ApplyPatch checks every op's preconditions and, unless dryRun is set,
+// applies the patch in one transaction. If any op doesn't match the
+// database nothing is applied and a *ConflictError is returned; force
+// skips the checks, so adds and changes upsert and removes of missing keys
+// do nothing; it doesn't skip check, which vets each scope's sets as in
+// Apply. Ops without a profile use defaultProfile.
+func ApplyPatch(database db.Store, p *Patch, defaultProfile string, force, dryRun bool, check Checker) (*Summary, error) {
+	summary := &Summary{}
+	changes := make(map[[2]string]*db.ScopeChange)
+	var order [][2]string
+	var conflicts []string
+	current := make(map[[2]string]map[string]db.EnvVar)
+
+	for _, op := range p.Ops {
+		scope, err := resolveScope(op.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("scope %s: %w", op.Scope, err)
+		}
+		profile := op.Profile
+		if profile == "" {
+			profile = defaultProfile
+		}
+		sp := [2]string{scope, profile}
+		if current[sp] == nil {
+			existing, err := database.GetVarsForPath(scope, profile)
+			if err != nil {
+				return nil, err
+			}
+			current[sp] = make(map[string]db.EnvVar, len(existing))
+			for _, v := range existing {
+				current[sp][v.Key] = v
+			}
+			changes[sp] = &db.ScopeChange{Path: scope, Profile: profile, Set: make(map[string]db.VarData)}
+			order = append(order, sp)
+		}
+		old, exists := current[sp][op.Key]
+		where := fmt.Sprintf("%s %s (%s @ %s)", op.Op, op.Key, profile, scope)
+
+		if !force {
+			switch {
+			case op.Op == OpAdd && exists && (old.Value != *op.Value || old.Description != deref(op.Description)):
+				conflicts = append(conflicts, where+": already set")
+				continue
+			case op.Op != OpAdd && !exists:
+				conflicts = append(conflicts, where+": not set")
+				continue
+			case op.OldValue != nil && exists && old.Value != *op.OldValue:
+				conflicts = append(conflicts, where+": value has changed")
+				continue
+			case op.OldDescription != nil && exists && old.Description != *op.OldDescription:
+				conflicts = append(conflicts, where+": description has changed")
+				continue
+			}
+		}
+
+		c := Change{Path: scope, Profile: profile, Key: op.Key}
+		if op.Op == OpRemove {
+			if !exists {
+				continue
+			}
+			c.Kind = ChangeDelete
+			changes[sp].Delete = append(changes[sp].Delete, op.Key)
+			summary.record(c)
+			continue
+		}
+
+		next := db.VarData{Value: old.Value, Description: old.Description}
+		if !exists {
+			next = db.VarData{}
+		}
+		if op.Value != nil {
+			next.Value = *op.Value
+		}
+		if op.Description != nil {
+			next.Description = *op.Description
+		}
+		switch {
+		case !exists:
+			c.Kind = ChangeAdd
+		case next.Value == old.Value && next.Description == old.Description:
+			c.Kind = ChangeUnchanged
+		default:
+			c.Kind = ChangeUpdate
+		}
+		if c.Kind != ChangeUnchanged {
+			changes[sp].Set[op.Key] = next
+		}
+		summary.record(c)
+	}
+
+	if len(conflicts) > 0 {
+		return nil, &ConflictError{Conflicts: conflicts}
+	}
+
+	list := make([]db.ScopeChange, 0, len(order))
+	for _, sp := range order {
+		list = append(list, *changes[sp])
+	}
+	if err := check.run(list); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return summary, nil
+	}
+	if err := database.ApplyChanges(list); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// resolveScope resolves a patch scope: @global, or a directory as
+// resolvePath takes it, either limited to a host with //host:NAME.
+func resolveScope(scope string) (string, error) {
+	dir, host := env.SplitHost(scope)
+	if dir != env.GlobalScope {
+		var err error
+		if dir, err = resolvePath(dir); err != nil {
+			return "", err
+		}
+	}
+	if host != "" {
+		return env.HostScope(dir, host), nil
+	}
+	return dir, nil
+}
+
+func ptr(s string) *string { return &s }
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
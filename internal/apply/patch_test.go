@@ -0,0 +1,131 @@
+package apply
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	from := []Entry{
+		{Scope: "/b", Profile: "default", Key: "SAME", Value: "s"},
+		{Scope: "/b", Profile: "default", Key: "CHANGED", Value: "1", Description: "old"},
+		{Scope: "/a", Profile: "default", Key: "GONE", Value: "g"},
+	}
+	to := []Entry{
+		{Scope: "/b", Profile: "default", Key: "SAME", Value: "s"},
+		{Scope: "/b", Profile: "default", Key: "CHANGED", Value: "2", Description: "old"},
+		{Scope: "/b", Profile: "ci", Key: "NEW", Value: "", Description: "Empty on purpose"},
+	}
+	data, err := Diff(from, to).Encode()
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	want := `{
+  "version": 1,
+  "ops": [
+    {
+      "op": "remove",
+      "scope": "/a",
+      "profile": "default",
+      "key": "GONE",
+      "old_value": "g",
+      "old_description": ""
+    },
+    {
+      "op": "add",
+      "scope": "/b",
+      "profile": "ci",
+      "key": "NEW",
+      "value": "",
+      "description": "Empty on purpose"
+    },
+    {
+      "op": "change",
+      "scope": "/b",
+      "profile": "default",
+      "key": "CHANGED",
+      "value": "2",
+      "description": "old",
+      "old_value": "1",
+      "old_description": "old"
+    }
+  ]
+}
+`
+	if string(data) != want {
+		t.Errorf("Diff =\n%s\nwant\n%s", data, want)
+	}
+
+	if data, _ := Diff(from, from).Encode(); string(data) != "{\n  \"version\": 1,\n  \"ops\": []\n}\n" {
+		t.Errorf("empty diff = %s", data)
+	}
+}
+
+func TestParsePatchInvalid(t *testing.T) {
+	tests := map[string]string{
+		"version":     `{"version":2,"ops":[]}`,
+		"unknown op":  `{"version":1,"ops":[{"op":"move","scope":"/a","key":"A"}]}`,
+		"no value":    `{"version":1,"ops":[{"op":"add","scope":"/a","key":"A"}]}`,
+		"bad key":     `{"version":1,"ops":[{"op":"add","scope":"/a","key":"1A","value":"x"}]}`,
+		"no scope":    `{"version":1,"ops":[{"op":"remove","key":"A"}]}`,
+		"remove set":  `{"version":1,"ops":[{"op":"remove","scope":"/a","key":"A","value":"x"}]}`,
+		"twice":       `{"version":1,"ops":[{"op":"remove","scope":"/a","key":"A"},{"op":"add","scope":"/a","key":"A","value":"x"}]}`,
+		"extra field": `{"version":1,"ops":[],"extra":true}`,
+	}
+	for name, doc := range tests {
+		if _, err := ParsePatch([]byte(doc)); err == nil {
+			t.Errorf("%s: ParsePatch should fail", name)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	database, dir := setupTestDB(t)
+	database.SetVar(dir, "default", "CHANGED", "1", "")
+	database.SetVar(dir, "default", "GONE", "g", "")
+
+	from := []Entry{
+		{Scope: dir, Profile: "default", Key: "CHANGED", Value: "1"},
+		{Scope: dir, Profile: "default", Key: "GONE", Value: "g"},
+	}
+	to := []Entry{
+		{Scope: dir, Profile: "default", Key: "CHANGED", Value: "2", Description: "Two"},
+		{Scope: "@global", Profile: "default", Key: "ADDED", Value: "a"},
+	}
+	data, _ := Diff(from, to).Encode()
+	p, err := ParsePatch(data)
+	if err != nil {
+		t.Fatalf("ParsePatch failed: %v", err)
+	}
+
+	// Someone changed a key the patch expects to find as it was
+	database.SetVar(dir, "default", "GONE", "moved on", "")
+	_, err = ApplyPatch(database, p, "default", false, false, nil)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || len(conflict.Conflicts) != 1 || !strings.Contains(conflict.Conflicts[0], "GONE") {
+		t.Fatalf("ApplyPatch = %v, want a conflict on GONE", err)
+	}
+	if v, _ := database.GetVar("@global", "default", "ADDED"); v != nil {
+		t.Error("a conflicting patch should apply nothing")
+	}
+
+	summary, err := ApplyPatch(database, p, "default", true, false, nil)
+	if err != nil {
+		t.Fatalf("ApplyPatch --force failed: %v", err)
+	}
+	if summary.Added != 1 || summary.Updated != 1 || summary.Deleted != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if v, _ := database.GetVar(dir, "default", "CHANGED"); v == nil || v.Value != "2" || v.Description != "Two" {
+		t.Errorf("CHANGED = %+v", v)
+	}
+	if v, _ := database.GetVar(dir, "default", "GONE"); v != nil {
+		t.Errorf("GONE = %+v", v)
+	}
+
+	// Applied again, the adds already match and the rest is gone
+	if _, err := ApplyPatch(database, p, "default", false, true, nil); !errors.As(err, &conflict) {
+		t.Errorf("reapplying = %v, want conflicts", err)
+	}
+}
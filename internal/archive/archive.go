@@ -0,0 +1,239 @@
+// Package archive retires a project: everything stored at a directory and
+// below is written to a file and removed from the store, so it no longer
+// shows up in resolution, listings or syncs, and can be restored later.
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nick-skriabin/enva/internal/db"
+	"github.com/nick-skriabin/enva/internal/dbsync"
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+// Version is bumped when the archive layout changes incompatibly.
+const Version = 1
+
+// Archive is the file enva archive writes. Vars use the sync bundle's
+// format, so they keep their flags, tags, author and update time.
+type Archive struct {
+	Version    int               `json:"version"`
+	Root       string            `json:"root"`
+	Machine    string            `json:"machine"`
+	ArchivedAt time.Time         `json:"archived_at"`
+	Vars       []dbsync.Var      `json:"vars"`
+	Clears     []Clear           `json:"clears,omitempty"`
+	Aliases    []Command         `json:"aliases,omitempty"`
+	Tasks      []Command         `json:"tasks,omitempty"`
+	Policies   map[string]string `json:"policies,omitempty"`
+}
+
+// Clear is a key force-unset at a scope.
+type Clear struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+	Key     string `json:"key"`
+}
+
+// Command is an alias or task defined at a scope.
+type Command struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+	Name    string `json:"name"`
+	Command string `json:"command"`
+}
+
+// Under reports whether scope, a directory or a directory limited to a
+// host, is root or below it.
+func Under(root, scope string) bool {
+	dir, _ := env.SplitHost(scope)
+	if dir == env.GlobalScope {
+		return false
+	}
+	sep := string(filepath.Separator)
+	return dir == root || strings.HasPrefix(dir, strings.TrimSuffix(root, sep)+sep)
+}
+
+// Collect reads everything stored at root and below, in every profile.
+func Collect(store db.Store, root string) (*Archive, error) {
+	machine, _ := os.Hostname()
+	a := &Archive{Version: Version, Root: root, Machine: machine, ArchivedAt: time.Now().UTC()}
+
+	all, err := dbsync.Snapshot(store)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range all {
+		if Under(root, v.Path) {
+			a.Vars = append(a.Vars, v)
+		}
+	}
+
+	scopes, err := store.ListScopes()
+	if err != nil {
+		return nil, err
+	}
+	pathsByProfile := make(map[string][]string)
+	var profiles []string
+	paths := []string{root}
+	seen := map[string]bool{root: true}
+	for _, sc := range scopes {
+		if !Under(root, sc.Path) {
+			continue
+		}
+		if pathsByProfile[sc.Profile] == nil {
+			profiles = append(profiles, sc.Profile)
+		}
+		pathsByProfile[sc.Profile] = append(pathsByProfile[sc.Profile], sc.Path)
+		if !seen[sc.Path] {
+			seen[sc.Path] = true
+			paths = append(paths, sc.Path)
+		}
+	}
+	sort.Strings(profiles)
+
+	for _, profile := range profiles {
+		clears, err := store.GetClearsForPaths(pathsByProfile[profile], profile)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range clears {
+			a.Clears = append(a.Clears, Clear{c.Path, c.Profile, c.Key})
+		}
+		aliases, err := store.GetAliasesForPaths(pathsByProfile[profile], profile)
+		if err != nil {
+			return nil, err
+		}
+		for _, al := range aliases {
+			a.Aliases = append(a.Aliases, Command{al.Path, al.Profile, al.Name, al.Command})
+		}
+		tasks, err := store.GetTasksForPaths(pathsByProfile[profile], profile)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tasks {
+			a.Tasks = append(a.Tasks, Command{t.Path, t.Profile, t.Name, t.Command})
+		}
+	}
+
+	if a.Policies, err = store.GetScopePolicies(paths); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Empty reports whether nothing was collected.
+func (a *Archive) Empty() bool {
+	return len(a.Vars) == 0 && len(a.Clears) == 0 && len(a.Aliases) == 0 && len(a.Tasks) == 0 && len(a.Policies) == 0
+}
+
+// Paths returns every scope path the archive holds something for, sorted.
+func (a *Archive) Paths() []string {
+	seen := make(map[string]bool)
+	for _, v := range a.Vars {
+		seen[v.Path] = true
+	}
+	for _, c := range a.Clears {
+		seen[c.Path] = true
+	}
+	for _, al := range a.Aliases {
+		seen[al.Path] = true
+	}
+	for _, t := range a.Tasks {
+		seen[t.Path] = true
+	}
+	for p := range a.Policies {
+		seen[p] = true
+	}
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Encode serializes the archive.
+func (a *Archive) Encode() ([]byte, error) {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Decode parses an archive.
+func Decode(data []byte) (*Archive, error) {
+	var a Archive
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	if a.Version > Version {
+		return nil, fmt.Errorf("archive was written by a newer enva (version %d)", a.Version)
+	}
+	if a.Root == "" {
+		return nil, fmt.Errorf("invalid archive: no root")
+	}
+	for _, p := range a.Paths() {
+		if !Under(a.Root, p) {
+			return nil, fmt.Errorf("invalid archive: %s is outside its root %s", p, a.Root)
+		}
+	}
+	return &a, nil
+}
+
+// Remove deletes the archived scopes from the store.
+func Remove(store db.Store, a *Archive) error {
+	return store.DropScopes(a.Paths())
+}
+
+// Conflicts lists the archived vars that are set again in the store with a
+// different value or description.
+func Conflicts(store db.Store, a *Archive) ([]dbsync.Var, error) {
+	var conflicts []dbsync.Var
+	for _, v := range a.Vars {
+		cur, err := store.GetVar(v.Path, v.Profile, v.Key)
+		if err != nil {
+			return nil, err
+		}
+		if cur != nil && (cur.Value != v.Value || cur.Description != v.Description) {
+			conflicts = append(conflicts, v)
+		}
+	}
+	return conflicts, nil
+}
+
+// Restore writes the archive back to the store, replacing vars set since
+// it was made. Callers check Conflicts first.
+func Restore(store db.Store, a *Archive) error {
+	if err := dbsync.Apply(store, dbsync.Plan{Set: a.Vars}); err != nil {
+		return err
+	}
+	for _, c := range a.Clears {
+		if err := store.AddClear(c.Path, c.Profile, c.Key); err != nil {
+			return err
+		}
+	}
+	for _, al := range a.Aliases {
+		if err := store.SetAlias(al.Path, al.Profile, al.Name, al.Command); err != nil {
+			return err
+		}
+	}
+	for _, t := range a.Tasks {
+		if err := store.SetTask(t.Path, t.Profile, t.Name, t.Command); err != nil {
+			return err
+		}
+	}
+	for path, policy := range a.Policies {
+		if err := store.SetScopePolicy(path, policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package archive
+
+import (
+	"testing"
+
+	"github.com/nick-skriabin/enva/internal/db"
+)
+
+func TestUnder(t *testing.T) {
+	tests := []struct {
+		root, scope string
+		want        bool
+	}{
+		{"/p/app", "/p/app", true},
+		{"/p/app", "/p/app/sub", true},
+		{"/p/app", "/p/app//host:box", true},
+		{"/p/app", "/p/application", false},
+		{"/p/app", "/p", false},
+		{"/", "/p", true},
+		{"/", "@global", false},
+	}
+	for _, tt := range tests {
+		if got := Under(tt.root, tt.scope); got != tt.want {
+			t.Errorf("Under(%q, %q) = %v, want %v", tt.root, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	store, err := db.Open("memory:")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+
+	store.SetVar("/p/app", "default", "PORT", "8080", "HTTP port")
+	store.SetEval("/p/app", "default", "PORT", true)
+	store.AddTag("/p/app", "default", "PORT", "net")
+	store.SetVar("/p/app/web//host:box", "staging", "DEBUG", "1", "")
+	store.AddClear("/p/app/web", "default", "HOME")
+	store.SetAlias("/p/app", "default", "t", "go test ./...")
+	store.SetTask("/p/app/web", "staging", "serve", "npm start")
+	store.SetScopePolicy("/p/app", `{"prefix":"APP_"}`)
+	store.SetVar("/p/application", "default", "KEEP", "1", "")
+	store.SetVar("@global", "default", "EDITOR", "vi", "")
+
+	a, err := Collect(store, "/p/app")
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if len(a.Vars) != 2 || len(a.Clears) != 1 || len(a.Aliases) != 1 || len(a.Tasks) != 1 || len(a.Policies) != 1 {
+		t.Fatalf("Collect = %+v", a)
+	}
+	data, err := a.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	if err := Remove(store, a); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if left, _ := Collect(store, "/p/app"); !left.Empty() {
+		t.Fatalf("Remove left %+v", left)
+	}
+	if v, _ := store.GetVar("/p/application", "default", "KEEP"); v == nil {
+		t.Error("Remove deleted a sibling project")
+	}
+
+	b, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	store.SetVar("/p/app", "default", "PORT", "9090", "")
+	if conflicts, _ := Conflicts(store, b); len(conflicts) != 1 || conflicts[0].Key != "PORT" {
+		t.Fatalf("Conflicts = %v, want PORT", conflicts)
+	}
+	if err := Restore(store, b); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	v, _ := store.GetVar("/p/app", "default", "PORT")
+	if v == nil || v.Value != "8080" || !v.Eval {
+		t.Errorf("restored PORT = %+v", v)
+	}
+	if tags, _ := store.GetTagsForPaths([]string{"/p/app"}, "default"); len(tags) != 1 || tags[0].Tag != "net" {
+		t.Errorf("restored tags = %v", tags)
+	}
+	if tasks, _ := store.GetTasksForPaths([]string{"/p/app/web"}, "staging"); len(tasks) != 1 {
+		t.Errorf("restored tasks = %v", tasks)
+	}
+	if policies, _ := store.GetScopePolicies([]string{"/p/app"}); policies["/p/app"] == "" {
+		t.Error("policy not restored")
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for name, doc := range map[string]string{
+		"newer":   `{"version":2,"root":"/p"}`,
+		"no root": `{"version":1}`,
+		"outside": `{"version":1,"root":"/p","vars":[{"path":"/q","profile":"default","key":"A","value":"1"}]}`,
+	} {
+		if _, err := Decode([]byte(doc)); err == nil {
+			t.Errorf("%s: Decode should fail", name)
+		}
+	}
+}
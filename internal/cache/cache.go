@@ -0,0 +1,132 @@
+// Package cache stores resolved environments on disk so the shell hook can
+// answer instantly on slow filesystems while a background refresh runs.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+// Path returns the cache file for a database, profile and directory.
+// Cache files live in a cache/ directory next to the database.
+func Path(dbPath, profile, dir string) string {
+	sum := sha256.Sum256([]byte(dbPath + "\x00" + profile + "\x00" + dir))
+	return filepath.Join(filepath.Dir(dbPath), "cache", hex.EncodeToString(sum[:16])+".json")
+}
+
+// Load reads a cached resolve context. A missing file returns os.ErrNotExist.
+func Load(path string) (*env.ResolveContext, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ctx env.ResolveContext
+	if err := json.Unmarshal(data, &ctx); err != nil {
+		return nil, err
+	}
+	return &ctx, nil
+}
+
+// lockTimeout is how long a refresh lock holds before it's taken for the
+// leftover of a refresh that died.
+const lockTimeout = time.Minute
+
+// SourceTime returns when the database at dbPath last changed: the later
+// modification time of the file and its SQLite write-ahead log.
+func SourceTime(dbPath string) time.Time {
+	var latest time.Time
+	for _, name := range []string{dbPath, dbPath + "-wal"} {
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// Stale reports whether the database at dbPath changed after the entry at
+// path was resolved from it. Missing entries are stale.
+func Stale(path, dbPath string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	return SourceTime(dbPath).After(info.ModTime())
+}
+
+// Claim takes the refresh lock for the entry at path, so prompts arriving
+// while a refresh runs don't start another. It reports false while a
+// refresh younger than lockTimeout holds the lock.
+func Claim(path string) bool {
+	lock := path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lock), 0700); err != nil {
+		return false
+	}
+	for range 2 {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return true
+		}
+		info, serr := os.Stat(lock)
+		if !errors.Is(err, os.ErrExist) || serr != nil || time.Since(info.ModTime()) < lockTimeout {
+			return false
+		}
+		// Whoever removes it first retries; the other one loses the create
+		os.Remove(lock)
+	}
+	return false
+}
+
+// Release drops the refresh lock taken by Claim.
+func Release(path string) {
+	os.Remove(path + ".lock")
+}
+
+// Store writes a resolve context atomically, so concurrent readers never
+// see a partial file. A non-zero source, the SourceTime read before
+// resolving, becomes the entry's modification time, so a database change
+// made while resolving still leaves the entry Stale.
+func Store(path string, ctx *env.ResolveContext, source time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(ctx)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if !source.IsZero() {
+		if err := os.Chtimes(tmpPath, source, source); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+func TestPath(t *testing.T) {
+	a := Path("/data/enva.db", "default", "/project")
+	b := Path("/data/enva.db", "default", "/project")
+	if a != b {
+		t.Errorf("Path should be deterministic: %q != %q", a, b)
+	}
+	if filepath.Dir(a) != "/data/cache" {
+		t.Errorf("Path dir = %q, want /data/cache", filepath.Dir(a))
+	}
+
+	if Path("/data/enva.db", "production", "/project") == a {
+		t.Error("Path should differ by profile")
+	}
+	if Path("/data/enva.db", "default", "/other") == a {
+		t.Error("Path should differ by dir")
+	}
+}
+
+func TestStoreAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "x.json")
+
+	if _, err := Load(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load on missing file = %v, want ErrNotExist", err)
+	}
+
+	ctx := &env.ResolveContext{
+		CwdReal: "/project/child",
+		RootDir: "/project",
+		Chain:   []string{"/project", "/project/child"},
+		Profile: "default",
+		Resolved: map[string]*env.ResolvedVar{
+			"A": {Key: "A", Value: "1", DefinedAtPath: "/project", IfUnset: true},
+		},
+		Cleared: []string{"AWS_PROFILE"},
+	}
+
+	if err := Store(path, ctx, time.Time{}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got.CwdReal != ctx.CwdReal || len(got.Chain) != 2 || len(got.Cleared) != 1 {
+		t.Errorf("Load = %+v", got)
+	}
+	if a := got.Resolved["A"]; a == nil || a.Value != "1" || !a.IfUnset {
+		t.Errorf("Resolved[A] = %+v", a)
+	}
+}
+
+func TestStale(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "enva.db")
+	path := filepath.Join(dir, "cache", "x.json")
+	if err := os.WriteFile(dbPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !Stale(path, dbPath) {
+		t.Error("a missing entry should be stale")
+	}
+
+	if err := Store(path, &env.ResolveContext{}, SourceTime(dbPath)); err != nil {
+		t.Fatal(err)
+	}
+	if Stale(path, dbPath) {
+		t.Error("an entry resolved from the current database should not be stale")
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.WriteFile(dbPath+"-wal", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dbPath+"-wal", later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !Stale(path, dbPath) {
+		t.Error("a write to the WAL should make the entry stale")
+	}
+}
+
+func TestClaim(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "x.json")
+	if !Claim(path) {
+		t.Fatal("Claim should take a free lock")
+	}
+	if Claim(path) {
+		t.Error("Claim should fail while a refresh holds the lock")
+	}
+	Release(path)
+	if !Claim(path) {
+		t.Error("Claim should succeed after Release")
+	}
+
+	old := time.Now().Add(-2 * lockTimeout)
+	if err := os.Chtimes(path+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !Claim(path) {
+		t.Error("Claim should take over a lock left by a dead refresh")
+	}
+}
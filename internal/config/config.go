@@ -0,0 +1,158 @@
+// Package config loads enva's optional user configuration file.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Config holds user settings. The zero value is the default configuration.
+type Config struct {
+	// UsageLog enables the local usage log read by `enva report`.
+	UsageLog bool `json:"usage_log,omitempty"`
+
+	// DB is the database location used when neither --db nor ENVA_DB is
+	// set: a SQLite file path, json:PATH or memory:.
+	DB string `json:"db,omitempty"`
+
+	// SyncRemote is the default remote for `enva sync push/pull`.
+	SyncRemote string `json:"sync_remote,omitempty"`
+
+	// Author is recorded as who set each var (default user@host). "none"
+	// records nothing.
+	Author string `json:"author,omitempty"`
+
+	// MaxChainDepth skips directories more than this many levels below the
+	// project root when resolving; the current directory always applies.
+	// Zero means no limit.
+	MaxChainDepth int `json:"max_chain_depth,omitempty"`
+
+	// ScopedChain resolves only through directories that have vars, found
+	// with one lookup, instead of querying every level of the path.
+	ScopedChain bool `json:"scoped_chain,omitempty"`
+
+	// ReadEnvFiles also reads .env and .env.local in each directory of the
+	// chain, below the vars stored for that directory.
+	ReadEnvFiles bool `json:"read_env_files,omitempty"`
+
+	// RootStopAtHome keeps project root discovery inside the home
+	// directory: below it, with no .enva or .git found, the home directory
+	// is the root rather than the filesystem root.
+	RootStopAtHome bool `json:"root_stop_at_home,omitempty"`
+
+	// RefuseFSRoot makes commands fail instead of using the filesystem
+	// root as the project root, where vars would apply everywhere.
+	RefuseFSRoot bool `json:"refuse_fs_root,omitempty"`
+
+	// DriftAlerts makes the shell hook warn, once a day per directory, when
+	// entering one whose required keys are missing or whose .env differs
+	// from enva.
+	DriftAlerts bool `json:"drift_alerts,omitempty"`
+
+	// DangerousKeys adds to the keys (LD_PRELOAD, PATH, ...) that only
+	// trusted scopes may export.
+	DangerousKeys []string `json:"dangerous_keys,omitempty"`
+
+	// EvalTimeout bounds each --eval command, in seconds (default 5).
+	EvalTimeout int `json:"eval_timeout,omitempty"`
+
+	// EvalCacheTTL is how long --eval outputs are reused, in seconds
+	// (default 30). Negative disables the cache.
+	EvalCacheTTL int `json:"eval_cache_ttl,omitempty"`
+
+	// PassCommand is the password store enva set --pass and enva import
+	// --pass use: "pass" (default) or "gopass".
+	PassCommand string `json:"pass_command,omitempty"`
+
+	// AWSRegion and GCPProject are passed to the aws and gcloud CLIs for
+	// enva set --ref references that don't name their own.
+	AWSRegion  string `json:"aws_region,omitempty"`
+	GCPProject string `json:"gcp_project,omitempty"`
+
+	// TrashRetentionDays is how long deleted vars can be restored with
+	// `enva trash restore` (default 30). Negative keeps them until purged.
+	TrashRetentionDays int `json:"trash_retention_days,omitempty"`
+
+	// UnlockMinutes is how long `enva unlock`, or entering the passphrase
+	// when asked, opens a protected profile (default 15).
+	UnlockMinutes int `json:"unlock_minutes,omitempty"`
+
+	// LintOnSet makes set and capture warn about malformed or mismatched
+	// *_URL, *_HOST and *_PORT values, as enva lint reports them.
+	LintOnSet bool `json:"lint_on_set,omitempty"`
+
+	// SearchExact makes search match the query as a substring instead of
+	// fuzzily, in the TUI and `enva search`.
+	SearchExact bool `json:"search_exact,omitempty"`
+
+	// SearchCaseSensitive makes search match letters in the query's case.
+	SearchCaseSensitive bool `json:"search_case_sensitive,omitempty"`
+
+	// SearchKeysOnly makes search ignore values.
+	SearchKeysOnly bool `json:"search_keys_only,omitempty"`
+
+	// SearchMinScore drops fuzzy matches scoring below it. Zero keeps all.
+	SearchMinScore int `json:"search_min_score,omitempty"`
+
+	// Keys rebinds TUI actions: each action name maps to the keys that
+	// trigger it, replacing its defaults. See `enva tui --keys`.
+	Keys map[string][]string `json:"keys,omitempty"`
+
+	// Notify lists what `enva watch` does when watched keys change.
+	Notify []NotifyRule `json:"notify,omitempty"`
+}
+
+// NotifyRule reports changes to Keys (names or globs such as DB_*) by
+// running Command, showing a desktop notification and/or POSTing the
+// changes to Webhook. Values are left out unless IncludeValues is set.
+type NotifyRule struct {
+	Keys          []string `json:"keys"`
+	Command       string   `json:"command,omitempty"`
+	Desktop       bool     `json:"desktop,omitempty"`
+	Webhook       string   `json:"webhook,omitempty"`
+	IncludeValues bool     `json:"include_values,omitempty"`
+}
+
+// Path returns the config file location: $ENVA_CONFIG if set, else
+// enva/config.json in the user config directory.
+func Path() (string, error) {
+	if p := os.Getenv("ENVA_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "enva", "config.json"), nil
+}
+
+// Load reads the config file. A missing file yields the default config.
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	return LoadFile(path)
+}
+
+// LoadFile reads the config at path. A missing file yields the default config.
+func LoadFile(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
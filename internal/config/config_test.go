@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("missing file is default", func(t *testing.T) {
+		cfg, err := LoadFile(filepath.Join(dir, "nope.json"))
+		if err != nil {
+			t.Fatalf("LoadFile failed: %v", err)
+		}
+		if cfg.UsageLog {
+			t.Error("UsageLog should default to false")
+		}
+	})
+
+	t.Run("reads settings", func(t *testing.T) {
+		path := filepath.Join(dir, "config.json")
+		os.WriteFile(path, []byte(`{"usage_log": true, "db": "json:/x/enva.json"}`), 0644)
+
+		cfg, err := LoadFile(path)
+		if err != nil {
+			t.Fatalf("LoadFile failed: %v", err)
+		}
+		if !cfg.UsageLog {
+			t.Error("UsageLog should be true")
+		}
+		if cfg.DB != "json:/x/enva.json" {
+			t.Errorf("DB = %q", cfg.DB)
+		}
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		path := filepath.Join(dir, "bad.json")
+		os.WriteFile(path, []byte(`{`), 0644)
+
+		if _, err := LoadFile(path); err == nil {
+			t.Error("LoadFile should fail on invalid JSON")
+		}
+	})
+}
+
+func TestPathEnvOverride(t *testing.T) {
+	t.Setenv("ENVA_CONFIG", "/custom/config.json")
+	if got, _ := Path(); got != "/custom/config.json" {
+		t.Errorf("Path() = %q", got)
+	}
+}
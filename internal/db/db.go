@@ -1,18 +1,38 @@
-// Package db provides SQLite database operations for enva.
+// Package db provides storage for enva: a Store interface with SQLite,
+// JSON file and in-memory backends.
 package db
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"os/user"
 	"path/filepath"
+	"runtime"
 	"time"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/nick-skriabin/enva/internal/redact"
 )
 
-// DB wraps the SQLite database connection.
+// DB is the SQLite Store.
 type DB struct {
-	conn *sql.DB
+	conn   *sql.DB
+	path   string
+	author string // Recorded on writes; see SetAuthor
+
+	// Prepared once: chain lookups run on every prompt
+	varsForPaths    *sql.Stmt
+	clearsForPaths  *sql.Stmt
+	tagsForPaths    *sql.Stmt
+	aliasesForPaths *sql.Stmt
+	resolved        *sql.Stmt
 }
 
 // EnvVar represents a single environment variable record.
@@ -23,6 +43,28 @@ type EnvVar struct {
 	Value       string
 	Description string
 	UpdatedAt   time.Time
+	CreatedAt   time.Time // When the var was first set
+	IfUnset     bool      // Only applies when the key is absent from the ambient environment
+	Eval        bool      // Value is a shell command whose output is exported instead
+	Refresh     int       // Seconds an eval command's output is reused; 0 uses the default
+	NoExport    bool      // Resolvable, but never exported to shells or commands
+	Fallback    string    // What an eval var exports when its command fails; "" fails
+	Type        string    // Declared value type (json, int, bool); "" is a plain string
+	Author      string    // Who last set the value (user@host), if recorded
+}
+
+// String names the variable for messages with its value redacted, so
+// printing a var, on its own or in a struct, can't show a secret.
+func (v EnvVar) String() string {
+	return fmt.Sprintf("%s=%v (%s @ %s)", v.Key, redact.Ref(v.Value), v.Profile, v.Path)
+}
+
+// ScopeSummary describes a scope that has variables in a profile.
+type ScopeSummary struct {
+	Path      string
+	Vars      int       // Number of variables set at the scope
+	UpdatedAt time.Time // Latest update among them
+	Owner     string    // Who created the scope, if recorded
 }
 
 // EnvScope represents a scope record.
@@ -32,14 +74,115 @@ type EnvScope struct {
 	CreatedAt time.Time
 }
 
+// EnvClear represents a key that is force-unset when entering a scope.
+type EnvClear struct {
+	Path    string
+	Profile string
+	Key     string
+}
+
+// EnvAlias represents a shell alias defined for a scope.
+type EnvAlias struct {
+	Path    string
+	Profile string
+	Name    string
+	Command string
+}
+
+// EnvTask represents a named command defined for a scope, run with
+// `enva run NAME`.
+type EnvTask struct {
+	Path    string
+	Profile string
+	Name    string
+	Command string
+}
+
+// EnvTag represents a tag attached to a variable.
+type EnvTag struct {
+	Path    string
+	Profile string
+	Key     string
+	Tag     string
+}
+
 // VarData holds value and description for batch operations.
 type VarData struct {
 	Value       string
 	Description string
+	Author      string    // Overrides the store's author, e.g. for synced values
+	Flags       *VarFlags // If set, replaces the var's flags and tags in the same write
+}
+
+// VarFlags are a variable's settings besides its value, for writes that
+// replace them along with it, such as a sync pull.
+type VarFlags struct {
+	IfUnset  bool
+	Eval     bool
+	Refresh  int
+	NoExport bool
+	Fallback string
+	Type     string
+	Tags     []string
+}
+
+// ScopeChange describes upserts, deletions and moves for one path/profile.
+// Move maps keys to the path they move to in the same profile, taking their
+// flags, tags and creation time with them.
+type ScopeChange struct {
+	Path    string
+	Profile string
+	Set     map[string]VarData
+	Delete  []string
+	Move    map[string]string
+}
+
+// ScopeRef is a path and profile that has something stored at it.
+type ScopeRef struct {
+	Path    string
+	Profile string
+}
+
+// DataDir returns the directory enva keeps its data in. Precedence:
+// $ENVA_DATA_DIR, then $XDG_DATA_HOME/enva, then the platform default
+// (~/.local/share/enva, ~/Library/Application Support/enva on macOS,
+// %LocalAppData%\enva on Windows).
+func DataDir() (string, error) {
+	if dir := os.Getenv("ENVA_DATA_DIR"); dir != "" {
+		return dir, nil
+	}
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		return filepath.Join(xdg, "enva"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "enva"), nil
+	case "windows":
+		if local := os.Getenv("LocalAppData"); local != "" {
+			return filepath.Join(local, "enva"), nil
+		}
+		return filepath.Join(home, "AppData", "Local", "enva"), nil
+	}
+	return filepath.Join(home, ".local", "share", "enva"), nil
 }
 
-// DefaultDBPath returns the default database path (~/.local/share/enva/enva.db).
+// DefaultDBPath returns the default database path (enva.db in DataDir).
 func DefaultDBPath() (string, error) {
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "enva.db"), nil
+}
+
+// legacyDBPath is where enva kept its database before DataDir existed.
+func legacyDBPath() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
@@ -47,35 +190,307 @@ func DefaultDBPath() (string, error) {
 	return filepath.Join(home, ".local", "share", "enva", "enva.db"), nil
 }
 
-// Open opens or creates the database at the given path.
-func Open(dbPath string) (*DB, error) {
+// ResolveDBPath returns the database path to use: override if non-empty,
+// else $ENVA_DB if set, else the default path. A database at the legacy
+// location is moved to the default path the first time it's resolved; if
+// that fails, so does ResolveDBPath, rather than start an empty database.
+func ResolveDBPath(override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	if p := os.Getenv("ENVA_DB"); p != "" {
+		return p, nil
+	}
+
+	def, err := DefaultDBPath()
+	if err != nil {
+		return "", err
+	}
+	legacy, err := legacyDBPath()
+	if err != nil || legacy == def {
+		return def, nil
+	}
+	if err := migrateDB(legacy, def); err != nil {
+		return "", fmt.Errorf("failed to move the database from %s to %s: %w", legacy, def, err)
+	}
+	return def, nil
+}
+
+// migrateDB moves the database at src to dst, unless src doesn't exist or
+// dst already does. Any WAL is checkpointed into the main file first, so
+// that file is all that moves and nothing committed is left behind. A lock
+// file keeps shells starting at once from moving it together.
+func migrateDB(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	unlock, err := lockFile(dst + ".migrating")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// Another process may have moved it while this one waited
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	if err := checkpoint(src); err != nil {
+		return fmt.Errorf("failed to checkpoint: %w", err)
+	}
+	if err := moveFile(src, dst); err != nil {
+		return err
+	}
+	// The checkpoint left the WAL empty; a stale one must not pair with a
+	// new database created at src
+	if info, err := os.Stat(src + "-wal"); err == nil && info.Size() == 0 {
+		os.Remove(src + "-wal")
+		os.Remove(src + "-shm")
+	}
+	return nil
+}
+
+// checkpoint writes everything in the WAL of the database at path into
+// the main file and truncates the WAL. Databases without one are left as
+// they are, and a hot rollback journal is rolled back on opening.
+func checkpoint(path string) error {
+	conn, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var busy, frames, done int
+	if err := conn.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &frames, &done); err != nil {
+		return err
+	}
+	if busy != 0 {
+		return errors.New("database is busy")
+	}
+	return conn.Close()
+}
+
+// lockFile creates the lock file at path, waiting up to 10 seconds for
+// another process to remove it, and returns a func that removes it. A lock
+// older than a minute was left by a crash and is taken over.
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > time.Minute {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is held by another enva; remove it if none is running", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// moveFile renames src to dst, copying when they're on different devices.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// OpenSQLite opens or creates the SQLite database at the given path.
+func OpenSQLite(dbPath string) (*DB, error) {
 	// Ensure directory exists
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
 
-	conn, err := sql.Open("sqlite", dbPath)
+	// Wait on locks held by concurrent enva processes instead of failing
+	conn, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, err
 	}
 
-	db := &DB{conn: conn}
+	db := &DB{conn: conn, path: dbPath}
 	if err := db.migrate(); err != nil {
 		conn.Close()
 		return nil, err
 	}
+	if err := db.prepare(); err != nil {
+		conn.Close()
+		return nil, err
+	}
 
 	return db, nil
 }
 
+// Path returns the file path the database was opened from.
+func (db *DB) Path() string {
+	return db.path
+}
+
+// SetAuthor sets who is recorded as the author of subsequent writes and
+// the owner of scopes they create. Empty records nothing.
+func (db *DB) SetAuthor(author string) {
+	db.author = author
+}
+
+// DefaultAuthor returns user@host for the current user, or "" when
+// neither can be determined.
+func DefaultAuthor() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	switch {
+	case name == "":
+		return ""
+	case host == "":
+		return name
+	}
+	return name + "@" + host
+}
+
+// authorOf returns the author to record for data.
+func (db *DB) authorOf(data VarData) string {
+	if data.Author != "" {
+		return data.Author
+	}
+	return db.author
+}
+
 // Close closes the database connection.
 func (db *DB) Close() error {
+	db.varsForPaths.Close()
+	db.clearsForPaths.Close()
+	db.tagsForPaths.Close()
+	db.aliasesForPaths.Close()
+	db.resolved.Close()
 	return db.conn.Close()
 }
 
-// migrate runs database migrations.
+// prepare compiles the statements used on the resolve hot path. Paths are
+// passed as a single JSON array so one statement serves any chain depth.
+func (db *DB) prepare() error {
+	var err error
+	db.varsForPaths, err = db.conn.Prepare(`SELECT ` + varColumns + ` FROM env_vars
+	          WHERE profile = ? AND path IN (SELECT value FROM json_each(?)) ORDER BY path, key`)
+	if err != nil {
+		return err
+	}
+	db.clearsForPaths, err = db.conn.Prepare(`SELECT path, profile, key FROM env_clears
+	          WHERE profile = ? AND path IN (SELECT value FROM json_each(?)) ORDER BY path, key`)
+	if err != nil {
+		db.varsForPaths.Close()
+		return err
+	}
+	db.tagsForPaths, err = db.conn.Prepare(`SELECT path, profile, key, tag FROM env_tags
+	          WHERE profile = ? AND path IN (SELECT value FROM json_each(?)) ORDER BY path, key, tag`)
+	if err != nil {
+		db.varsForPaths.Close()
+		db.clearsForPaths.Close()
+		return err
+	}
+	db.aliasesForPaths, err = db.conn.Prepare(`SELECT path, profile, name, command FROM env_aliases
+	          WHERE profile = ? AND path IN (SELECT value FROM json_each(?)) ORDER BY path, name`)
+	if err != nil {
+		db.varsForPaths.Close()
+		db.clearsForPaths.Close()
+		db.tagsForPaths.Close()
+		return err
+	}
+	db.resolved, err = db.conn.Prepare(`SELECT m.value, r.root, r.generation, r.data FROM env_meta m
+	          LEFT JOIN env_resolved r ON r.path = ? AND r.profile = ? WHERE m.key = 'generation'`)
+	if err != nil {
+		db.varsForPaths.Close()
+		db.clearsForPaths.Close()
+		db.tagsForPaths.Close()
+		db.aliasesForPaths.Close()
+		return err
+	}
+	return nil
+}
+
+// schemaVersion is stored in PRAGMA user_version once migrate has run.
+// Bump it whenever a migration is added below.
+const schemaVersion = 13
+
+// migrate runs database migrations. It holds the database write lock while
+// migrating, so concurrent first runs (e.g. several shells starting their
+// prompt hooks at once) serialize instead of racing on the schema.
 func (db *DB) migrate() error {
+	ctx := context.Background()
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Fast path: already up to date, no write lock needed
+	var version int
+	if err := conn.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version >= schemaVersion {
+		return nil
+	}
+
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(ctx, `ROLLBACK`)
+		}
+	}()
+
+	// Another process may have migrated while we waited for the lock
+	if err := conn.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version >= schemaVersion {
+		return nil
+	}
+
 	schema := `
 	CREATE TABLE IF NOT EXISTS env_scopes (
 		path TEXT PRIMARY KEY,
@@ -90,40 +505,183 @@ func (db *DB) migrate() error {
 		value TEXT NOT NULL,
 		description TEXT NOT NULL DEFAULT '',
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (path, profile, key)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_env_vars_profile_path_key ON env_vars(profile, path, key);
+
+	CREATE TABLE IF NOT EXISTS env_clears (
+		path TEXT NOT NULL,
+		profile TEXT NOT NULL,
+		key TEXT NOT NULL,
 		PRIMARY KEY (path, profile, key)
 	);
 
-	CREATE INDEX IF NOT EXISTS idx_env_vars_path_profile ON env_vars(path, profile);
+	CREATE TABLE IF NOT EXISTS env_tags (
+		path TEXT NOT NULL,
+		profile TEXT NOT NULL,
+		key TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (path, profile, key, tag)
+	);
+
+	CREATE TABLE IF NOT EXISTS env_aliases (
+		path TEXT NOT NULL,
+		profile TEXT NOT NULL,
+		name TEXT NOT NULL,
+		command TEXT NOT NULL,
+		PRIMARY KEY (path, profile, name)
+	);
+
+	CREATE TABLE IF NOT EXISTS env_tasks (
+		path TEXT NOT NULL,
+		profile TEXT NOT NULL,
+		name TEXT NOT NULL,
+		command TEXT NOT NULL,
+		PRIMARY KEY (path, profile, name)
+	);
+
+	-- Merged resolve results, valid while their generation is current
+	CREATE TABLE IF NOT EXISTS env_meta (
+		key TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	);
+	INSERT OR IGNORE INTO env_meta (key, value) VALUES ('generation', 0);
+
+	CREATE TABLE IF NOT EXISTS env_resolved (
+		path TEXT NOT NULL,
+		profile TEXT NOT NULL,
+		root TEXT NOT NULL,
+		generation INTEGER NOT NULL,
+		data BLOB NOT NULL,
+		PRIMARY KEY (path, profile)
+	);
+
+	-- Deleted vars, kept until purged so they can be restored
+	CREATE TABLE IF NOT EXISTS env_trash (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		path TEXT NOT NULL,
+		profile TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		if_unset INTEGER NOT NULL DEFAULT 0,
+		eval INTEGER NOT NULL DEFAULT 0,
+		refresh INTEGER NOT NULL DEFAULT 0,
+		no_export INTEGER NOT NULL DEFAULT 0,
+		fallback TEXT NOT NULL DEFAULT '',
+		value_type TEXT NOT NULL DEFAULT '',
+		author TEXT NOT NULL DEFAULT '',
+		tags TEXT NOT NULL DEFAULT '[]',
+		updated_at DATETIME,
+		created_at DATETIME,
+		deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		deleted_by TEXT NOT NULL DEFAULT ''
+	);
+
+	-- Tags belong to a variable and go away with it
+	CREATE TRIGGER IF NOT EXISTS env_vars_delete_tags AFTER DELETE ON env_vars
+	BEGIN
+		DELETE FROM env_tags WHERE path = OLD.path AND profile = OLD.profile AND key = OLD.key;
+	END;
 	`
-	if _, err := db.conn.Exec(schema); err != nil {
+	if _, err := conn.ExecContext(ctx, schema+generationTriggers()); err != nil {
 		return err
 	}
 
+	// Migration: superseded by idx_env_vars_profile_path_key
+	conn.ExecContext(ctx, `DROP INDEX IF EXISTS idx_env_vars_path_profile`)
+
 	// Migration: add description column to existing tables
-	db.conn.Exec(`ALTER TABLE env_vars ADD COLUMN description TEXT NOT NULL DEFAULT ''`)
+	conn.ExecContext(ctx, `ALTER TABLE env_vars ADD COLUMN description TEXT NOT NULL DEFAULT ''`)
+
+	// Migration: add if_unset (default instead of override) column
+	conn.ExecContext(ctx, `ALTER TABLE env_vars ADD COLUMN if_unset INTEGER NOT NULL DEFAULT 0`)
+
+	// Migration: add eval (command substitution value) column
+	conn.ExecContext(ctx, `ALTER TABLE env_vars ADD COLUMN eval INTEGER NOT NULL DEFAULT 0`)
+
+	// Migration: add per-var refresh interval (seconds) for eval values
+	conn.ExecContext(ctx, `ALTER TABLE env_vars ADD COLUMN refresh INTEGER NOT NULL DEFAULT 0`)
+
+	// Migration: add naming policy column to scopes
+	conn.ExecContext(ctx, `ALTER TABLE env_scopes ADD COLUMN policy TEXT NOT NULL DEFAULT ''`)
 
+	// Migration: record who set each var and who created each scope
+	conn.ExecContext(ctx, `ALTER TABLE env_vars ADD COLUMN author TEXT NOT NULL DEFAULT ''`)
+	conn.ExecContext(ctx, `ALTER TABLE env_scopes ADD COLUMN owner TEXT NOT NULL DEFAULT ''`)
+
+	// Migration: record when each var was first set; for existing vars the
+	// best guess is their last update
+	conn.ExecContext(ctx, `ALTER TABLE env_vars ADD COLUMN created_at DATETIME`)
+	conn.ExecContext(ctx, `UPDATE env_vars SET created_at = updated_at WHERE created_at IS NULL`)
+	conn.ExecContext(ctx, `ALTER TABLE env_trash ADD COLUMN created_at DATETIME`)
+
+	// Migration: add no_export (resolvable but never exported) column
+	conn.ExecContext(ctx, `ALTER TABLE env_vars ADD COLUMN no_export INTEGER NOT NULL DEFAULT 0`)
+	conn.ExecContext(ctx, `ALTER TABLE env_trash ADD COLUMN no_export INTEGER NOT NULL DEFAULT 0`)
+
+	// Migration: add fallback (what failed eval vars export) column
+	conn.ExecContext(ctx, `ALTER TABLE env_vars ADD COLUMN fallback TEXT NOT NULL DEFAULT ''`)
+	conn.ExecContext(ctx, `ALTER TABLE env_trash ADD COLUMN fallback TEXT NOT NULL DEFAULT ''`)
+
+	// Migration: add value_type (declared json/int/bool type) column
+	conn.ExecContext(ctx, `ALTER TABLE env_vars ADD COLUMN value_type TEXT NOT NULL DEFAULT ''`)
+	conn.ExecContext(ctx, `ALTER TABLE env_trash ADD COLUMN value_type TEXT NOT NULL DEFAULT ''`)
+
+	// Migration: resolve results cached before tasks existed, or creation
+	// times, no_export, fallback or value types were recorded, lack them
+	conn.ExecContext(ctx, `DELETE FROM env_resolved`)
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion)); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+		return err
+	}
+	committed = true
 	return nil
 }
 
+// varColumns are the env_vars columns scanVar reads, in order.
+const varColumns = `path, profile, key, value, description, updated_at, created_at, if_unset, eval, refresh, no_export, fallback, value_type, author`
+
+// scanVar reads a var selected with varColumns. Vars from before creation
+// times were recorded count as created when last updated.
+func scanVar(row interface{ Scan(...any) error }) (EnvVar, error) {
+	var (
+		v         EnvVar
+		createdAt sql.NullTime
+	)
+	err := row.Scan(&v.Path, &v.Profile, &v.Key, &v.Value, &v.Description, &v.UpdatedAt, &createdAt, &v.IfUnset, &v.Eval, &v.Refresh, &v.NoExport, &v.Fallback, &v.Type, &v.Author)
+	v.CreatedAt = createdAt.Time
+	if !createdAt.Valid {
+		v.CreatedAt = v.UpdatedAt
+	}
+	return v, err
+}
+
+// unixOrNil binds t for datetime(?, 'unixepoch'), or NULL for the zero time.
+func unixOrNil(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
 // GetVarsForPaths retrieves all variables for the given paths and profile.
 func (db *DB) GetVarsForPaths(paths []string, profile string) ([]EnvVar, error) {
 	if len(paths) == 0 {
 		return nil, nil
 	}
 
-	// Build query with placeholders
-	query := `SELECT path, profile, key, value, description, updated_at FROM env_vars WHERE profile = ? AND path IN (`
-	args := []interface{}{profile}
-	for i, p := range paths {
-		if i > 0 {
-			query += ","
-		}
-		query += "?"
-		args = append(args, p)
+	pathsJSON, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
 	}
-	query += `) ORDER BY path, key`
 
-	rows, err := db.conn.Query(query, args...)
+	rows, err := db.varsForPaths.Query(profile, string(pathsJSON))
 	if err != nil {
 		return nil, err
 	}
@@ -131,8 +689,8 @@ func (db *DB) GetVarsForPaths(paths []string, profile string) ([]EnvVar, error)
 
 	var vars []EnvVar
 	for rows.Next() {
-		var v EnvVar
-		if err := rows.Scan(&v.Path, &v.Profile, &v.Key, &v.Value, &v.Description, &v.UpdatedAt); err != nil {
+		v, err := scanVar(rows)
+		if err != nil {
 			return nil, err
 		}
 		vars = append(vars, v)
@@ -142,7 +700,7 @@ func (db *DB) GetVarsForPaths(paths []string, profile string) ([]EnvVar, error)
 
 // GetVarsForPath retrieves all variables for a specific path and profile.
 func (db *DB) GetVarsForPath(path, profile string) ([]EnvVar, error) {
-	query := `SELECT path, profile, key, value, description, updated_at FROM env_vars
+	query := `SELECT ` + varColumns + ` FROM env_vars
 	          WHERE path = ? AND profile = ? ORDER BY key`
 	rows, err := db.conn.Query(query, path, profile)
 	if err != nil {
@@ -152,8 +710,8 @@ func (db *DB) GetVarsForPath(path, profile string) ([]EnvVar, error) {
 
 	var vars []EnvVar
 	for rows.Next() {
-		var v EnvVar
-		if err := rows.Scan(&v.Path, &v.Profile, &v.Key, &v.Value, &v.Description, &v.UpdatedAt); err != nil {
+		v, err := scanVar(rows)
+		if err != nil {
 			return nil, err
 		}
 		vars = append(vars, v)
@@ -168,83 +726,611 @@ func (db *DB) SetVar(path, profile, key, value, description string) error {
 		return err
 	}
 
-	query := `INSERT INTO env_vars (path, profile, key, value, description, updated_at)
-	          VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	query := `INSERT INTO env_vars (path, profile, key, value, description, author, updated_at, created_at)
+	          VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	          ON CONFLICT(path, profile, key)
-	          DO UPDATE SET value = excluded.value, description = excluded.description, updated_at = CURRENT_TIMESTAMP`
-	_, err := db.conn.Exec(query, path, profile, key, value, description)
+	          DO UPDATE SET value = excluded.value, description = excluded.description, author = excluded.author, updated_at = CURRENT_TIMESTAMP`
+	_, err := db.conn.Exec(query, path, profile, key, value, description, db.author)
+	return redact.WrapValue(err, key, value)
+}
+
+// SetIfUnset marks an existing variable as a default (applied only when the
+// key is absent from the ambient environment) or as a regular override.
+func (db *DB) SetIfUnset(path, profile, key string, ifUnset bool) error {
+	_, err := db.conn.Exec(`UPDATE env_vars SET if_unset = ? WHERE path = ? AND profile = ? AND key = ?`, ifUnset, path, profile, key)
 	return err
 }
 
-// DeleteVar deletes a variable at the given path/profile/key.
-func (db *DB) DeleteVar(path, profile, key string) error {
-	query := `DELETE FROM env_vars WHERE path = ? AND profile = ? AND key = ?`
-	_, err := db.conn.Exec(query, path, profile, key)
+// SetEval marks an existing variable's value as a shell command to run at
+// export time, or back to a literal value.
+func (db *DB) SetEval(path, profile, key string, eval bool) error {
+	_, err := db.conn.Exec(`UPDATE env_vars SET eval = ? WHERE path = ? AND profile = ? AND key = ?`, eval, path, profile, key)
 	return err
 }
 
-// DeleteVarsForPath deletes all variables for a path and profile.
-func (db *DB) DeleteVarsForPath(path, profile string) error {
-	query := `DELETE FROM env_vars WHERE path = ? AND profile = ?`
-	_, err := db.conn.Exec(query, path, profile)
+// SetNoExport marks an existing variable as kept out of exports, or as
+// exported again.
+func (db *DB) SetNoExport(path, profile, key string, noExport bool) error {
+	_, err := db.conn.Exec(`UPDATE env_vars SET no_export = ? WHERE path = ? AND profile = ? AND key = ?`, noExport, path, profile, key)
 	return err
 }
 
-// GetVar retrieves a specific variable.
-func (db *DB) GetVar(path, profile, key string) (*EnvVar, error) {
-	query := `SELECT path, profile, key, value, description, updated_at FROM env_vars
-	          WHERE path = ? AND profile = ? AND key = ?`
-	var v EnvVar
-	err := db.conn.QueryRow(query, path, profile, key).Scan(&v.Path, &v.Profile, &v.Key, &v.Value, &v.Description, &v.UpdatedAt)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	return &v, nil
+// SetFallback sets what an eval variable exports when its command fails.
+// Empty restores the default of not exporting it.
+func (db *DB) SetFallback(path, profile, key, fallback string) error {
+	_, err := db.conn.Exec(`UPDATE env_vars SET fallback = ? WHERE path = ? AND profile = ? AND key = ?`, fallback, path, profile, key)
+	return redact.WrapValue(err, key, fallback)
 }
 
-// ensureScope creates a scope record if it doesn't exist.
-func (db *DB) ensureScope(path string) error {
-	query := `INSERT OR IGNORE INTO env_scopes (path, created_at) VALUES (?, CURRENT_TIMESTAMP)`
-	_, err := db.conn.Exec(query, path)
+// SetType declares an existing variable's value type. Empty makes it a
+// plain string again.
+func (db *DB) SetType(path, profile, key, valueType string) error {
+	_, err := db.conn.Exec(`UPDATE env_vars SET value_type = ? WHERE path = ? AND profile = ? AND key = ?`, valueType, path, profile, key)
 	return err
 }
 
-// SetVarsBatch sets multiple variables in a transaction.
-func (db *DB) SetVarsBatch(path, profile string, vars map[string]VarData) error {
+// SetRefresh sets how many seconds an eval variable's output is reused
+// before its command runs again. Zero restores the default.
+func (db *DB) SetRefresh(path, profile, key string, seconds int) error {
+	_, err := db.conn.Exec(`UPDATE env_vars SET refresh = ? WHERE path = ? AND profile = ? AND key = ?`, seconds, path, profile, key)
+	return err
+}
+
+// DeleteVar moves a variable at the given path/profile/key to the trash.
+func (db *DB) DeleteVar(path, profile, key string) error {
+	return db.DeleteVarsBatch(path, profile, []string{key})
+}
+
+// DeleteVarsForPath moves all variables for a path and profile to the trash.
+func (db *DB) DeleteVarsForPath(path, profile string) error {
 	tx, err := db.conn.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Ensure scope exists
-	_, err = tx.Exec(`INSERT OR IGNORE INTO env_scopes (path, created_at) VALUES (?, CURRENT_TIMESTAMP)`, path)
-	if err != nil {
+	if _, err := tx.Exec(trashInsert+`v.path = ? AND v.profile = ?`, db.author, path, profile); err != nil {
 		return err
 	}
-
-	stmt, err := tx.Prepare(`INSERT INTO env_vars (path, profile, key, value, description, updated_at)
-	                         VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
-	                         ON CONFLICT(path, profile, key)
-	                         DO UPDATE SET value = excluded.value, description = excluded.description, updated_at = CURRENT_TIMESTAMP`)
-	if err != nil {
+	if _, err := tx.Exec(`DELETE FROM env_vars WHERE path = ? AND profile = ?`, path, profile); err != nil {
 		return err
 	}
-	defer stmt.Close()
-
-	for key, data := range vars {
-		if _, err := stmt.Exec(path, profile, key, data.Value, data.Description); err != nil {
-			return err
-		}
-	}
-
 	return tx.Commit()
 }
 
-// DeleteVarsBatch deletes multiple variables in a transaction.
+// GetVar retrieves a specific variable.
+func (db *DB) GetVar(path, profile, key string) (*EnvVar, error) {
+	query := `SELECT ` + varColumns + ` FROM env_vars
+	          WHERE path = ? AND profile = ? AND key = ?`
+	v, err := scanVar(db.conn.QueryRow(query, path, profile, key))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// GetAllVars retrieves every variable for a profile, ordered by path and key.
+func (db *DB) GetAllVars(profile string) ([]EnvVar, error) {
+	query := `SELECT ` + varColumns + ` FROM env_vars
+	          WHERE profile = ? ORDER BY path, key`
+	rows, err := db.conn.Query(query, profile)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vars []EnvVar
+	for rows.Next() {
+		v, err := scanVar(rows)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, v)
+	}
+	return vars, rows.Err()
+}
+
+// GetScopesWithVars returns every scope with variables in profile, sorted by
+// path, with their counts, latest update and owner in a single query.
+func (db *DB) GetScopesWithVars(profile string) ([]ScopeSummary, error) {
+	rows, err := db.conn.Query(`SELECT v.path, COUNT(*), MAX(v.updated_at), COALESCE(s.owner, '') FROM env_vars v
+	          LEFT JOIN env_scopes s ON s.path = v.path
+	          WHERE v.profile = ? GROUP BY v.path ORDER BY v.path`, profile)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scopes []ScopeSummary
+	for rows.Next() {
+		var sc ScopeSummary
+		var updated string
+		if err := rows.Scan(&sc.Path, &sc.Vars, &updated, &sc.Owner); err != nil {
+			return nil, err
+		}
+		// MAX drops the column's DATETIME type, so parse CURRENT_TIMESTAMP's format
+		sc.UpdatedAt, _ = time.Parse(time.DateTime, updated)
+		scopes = append(scopes, sc)
+	}
+	return scopes, rows.Err()
+}
+
+// CountVars returns the total number of variables across all paths and profiles.
+func (db *DB) CountVars() (int, error) {
+	var n int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM env_vars`).Scan(&n)
+	return n, err
+}
+
+// ListProfiles returns the profiles that have at least one variable, sorted.
+func (db *DB) ListProfiles() ([]string, error) {
+	rows, err := db.conn.Query(`SELECT DISTINCT profile FROM env_vars ORDER BY profile`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// ensureScope creates a scope record if it doesn't exist.
+func (db *DB) ensureScope(path string) error {
+	query := `INSERT OR IGNORE INTO env_scopes (path, owner, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)`
+	_, err := db.conn.Exec(query, path, db.author)
+	return err
+}
+
+// AddClear records key as force-unset at the given path/profile.
+func (db *DB) AddClear(path, profile, key string) error {
+	if err := db.ensureScope(path); err != nil {
+		return err
+	}
+	_, err := db.conn.Exec(`INSERT OR IGNORE INTO env_clears (path, profile, key) VALUES (?, ?, ?)`, path, profile, key)
+	return err
+}
+
+// RemoveClear removes a force-unset key at the given path/profile.
+func (db *DB) RemoveClear(path, profile, key string) error {
+	_, err := db.conn.Exec(`DELETE FROM env_clears WHERE path = ? AND profile = ? AND key = ?`, path, profile, key)
+	return err
+}
+
+// GetClearsForPaths retrieves force-unset keys for the given paths and profile.
+func (db *DB) GetClearsForPaths(paths []string, profile string) ([]EnvClear, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	pathsJSON, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.clearsForPaths.Query(profile, string(pathsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clears []EnvClear
+	for rows.Next() {
+		var c EnvClear
+		if err := rows.Scan(&c.Path, &c.Profile, &c.Key); err != nil {
+			return nil, err
+		}
+		clears = append(clears, c)
+	}
+	return clears, rows.Err()
+}
+
+// SetAlias defines a shell alias at the given path/profile, replacing any
+// alias of the same name there.
+func (db *DB) SetAlias(path, profile, name, command string) error {
+	_, err := db.conn.Exec(`INSERT INTO env_aliases (path, profile, name, command) VALUES (?, ?, ?, ?)
+	          ON CONFLICT(path, profile, name) DO UPDATE SET command = excluded.command`, path, profile, name, command)
+	return err
+}
+
+// DeleteAlias removes a shell alias at the given path/profile.
+func (db *DB) DeleteAlias(path, profile, name string) error {
+	_, err := db.conn.Exec(`DELETE FROM env_aliases WHERE path = ? AND profile = ? AND name = ?`, path, profile, name)
+	return err
+}
+
+// PathsWithScopes returns the paths, in order, that have vars, clears,
+// aliases or tasks for profile, checked in a single query.
+func (db *DB) PathsWithScopes(paths []string, profile string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	pathsJSON, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT p.value FROM json_each(?) p
+	          WHERE EXISTS (SELECT 1 FROM env_vars WHERE path = p.value AND profile = ?)
+	             OR EXISTS (SELECT 1 FROM env_clears WHERE path = p.value AND profile = ?)
+	             OR EXISTS (SELECT 1 FROM env_aliases WHERE path = p.value AND profile = ?)
+	             OR EXISTS (SELECT 1 FROM env_tasks WHERE path = p.value AND profile = ?)
+	          ORDER BY p.key`, string(pathsJSON), profile, profile, profile, profile)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scoped []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		scoped = append(scoped, p)
+	}
+	return scoped, rows.Err()
+}
+
+// GetAliasesForPaths retrieves shell aliases for the given paths and profile.
+func (db *DB) GetAliasesForPaths(paths []string, profile string) ([]EnvAlias, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	pathsJSON, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.aliasesForPaths.Query(profile, string(pathsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []EnvAlias
+	for rows.Next() {
+		var a EnvAlias
+		if err := rows.Scan(&a.Path, &a.Profile, &a.Name, &a.Command); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
+
+// SetTask defines a task at the given path/profile, replacing any task of
+// the same name there.
+func (db *DB) SetTask(path, profile, name, command string) error {
+	_, err := db.conn.Exec(`INSERT INTO env_tasks (path, profile, name, command) VALUES (?, ?, ?, ?)
+	          ON CONFLICT(path, profile, name) DO UPDATE SET command = excluded.command`, path, profile, name, command)
+	return err
+}
+
+// DeleteTask removes a task at the given path/profile.
+func (db *DB) DeleteTask(path, profile, name string) error {
+	_, err := db.conn.Exec(`DELETE FROM env_tasks WHERE path = ? AND profile = ? AND name = ?`, path, profile, name)
+	return err
+}
+
+// GetTasksForPaths retrieves tasks for the given paths and profile.
+func (db *DB) GetTasksForPaths(paths []string, profile string) ([]EnvTask, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	pathsJSON, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT path, profile, name, command FROM env_tasks
+	          WHERE profile = ? AND path IN (SELECT value FROM json_each(?)) ORDER BY path, name`, profile, string(pathsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []EnvTask
+	for rows.Next() {
+		var t EnvTask
+		if err := rows.Scan(&t.Path, &t.Profile, &t.Name, &t.Command); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// ErrVarExists is returned by MoveVar when the destination already has the key.
+var ErrVarExists = errors.New("variable already exists at destination")
+
+// ErrVarNotFound is returned when a variable doesn't exist.
+var ErrVarNotFound = errors.New("variable not found")
+
+// IsLocked reports whether err means another connection holds the SQLite
+// database lock for longer than the busy timeout.
+func IsLocked(err error) bool {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return false
+	}
+	code := se.Code() & 0xff // Drop the extended result code
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// MoveVar moves (or with copy, duplicates) a variable with its description,
+// if-unset flag and tags to another scope and/or profile in one transaction.
+// Unless overwrite is set, an existing destination key fails with ErrVarExists.
+func (db *DB) MoveVar(srcPath, srcProfile, dstPath, dstProfile, key string, copy, overwrite bool) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.moveVar(tx, srcPath, srcProfile, dstPath, dstProfile, key, copy, overwrite); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// moveVar is MoveVar within tx.
+func (db *DB) moveVar(tx *sql.Tx, srcPath, srcProfile, dstPath, dstProfile, key string, copy, overwrite bool) error {
+	v, err := scanVar(tx.QueryRow(`SELECT `+varColumns+` FROM env_vars WHERE path = ? AND profile = ? AND key = ?`,
+		srcPath, srcProfile, key))
+	if err == sql.ErrNoRows {
+		return ErrVarNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if !overwrite {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM env_vars WHERE path = ? AND profile = ? AND key = ?`,
+			dstPath, dstProfile, key).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrVarExists
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO env_scopes (path, owner, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)`, dstPath, db.author); err != nil {
+		return err
+	}
+	// Replace rather than upsert so the trigger drops any tags on an overwritten var
+	if _, err := tx.Exec(`DELETE FROM env_vars WHERE path = ? AND profile = ? AND key = ?`, dstPath, dstProfile, key); err != nil {
+		return err
+	}
+	// A moved var keeps its creation time
+	if _, err := tx.Exec(`INSERT INTO env_vars (path, profile, key, value, description, if_unset, eval, refresh, no_export, fallback, value_type, author, updated_at, created_at)
+	                      VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, COALESCE(datetime(?, 'unixepoch'), CURRENT_TIMESTAMP))`,
+		dstPath, dstProfile, key, v.Value, v.Description, v.IfUnset, v.Eval, v.Refresh, v.NoExport, v.Fallback, v.Type, v.Author, unixOrNil(v.CreatedAt)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO env_tags (path, profile, key, tag)
+	                      SELECT ?, ?, key, tag FROM env_tags WHERE path = ? AND profile = ? AND key = ?`,
+		dstPath, dstProfile, srcPath, srcProfile, key); err != nil {
+		return err
+	}
+
+	if !copy {
+		if _, err := tx.Exec(`DELETE FROM env_vars WHERE path = ? AND profile = ? AND key = ?`, srcPath, srcProfile, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddTag attaches a tag to a variable.
+func (db *DB) AddTag(path, profile, key, tag string) error {
+	_, err := db.conn.Exec(`INSERT OR IGNORE INTO env_tags (path, profile, key, tag) VALUES (?, ?, ?, ?)`,
+		path, profile, key, tag)
+	return err
+}
+
+// RemoveTag detaches a tag from a variable.
+func (db *DB) RemoveTag(path, profile, key, tag string) error {
+	_, err := db.conn.Exec(`DELETE FROM env_tags WHERE path = ? AND profile = ? AND key = ? AND tag = ?`,
+		path, profile, key, tag)
+	return err
+}
+
+// GetTagsForPaths retrieves tags on variables at the given paths and profile.
+func (db *DB) GetTagsForPaths(paths []string, profile string) ([]EnvTag, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	pathsJSON, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.tagsForPaths.Query(profile, string(pathsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []EnvTag
+	for rows.Next() {
+		var t EnvTag
+		if err := rows.Scan(&t.Path, &t.Profile, &t.Key, &t.Tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// SetScopePolicy stores the encoded naming policy for a scope.
+func (db *DB) SetScopePolicy(path, policy string) error {
+	if err := db.ensureScope(path); err != nil {
+		return err
+	}
+	_, err := db.conn.Exec(`UPDATE env_scopes SET policy = ? WHERE path = ?`, policy, path)
+	return err
+}
+
+// GetScopePolicies returns the non-empty encoded policies for the given paths.
+func (db *DB) GetScopePolicies(paths []string) (map[string]string, error) {
+	policies := make(map[string]string)
+	if len(paths) == 0 {
+		return policies, nil
+	}
+
+	query := `SELECT path, policy FROM env_scopes WHERE policy != '' AND path IN (`
+	args := []interface{}{}
+	for i, p := range paths {
+		if i > 0 {
+			query += ","
+		}
+		query += "?"
+		args = append(args, p)
+	}
+	query += `)`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path, policy string
+		if err := rows.Scan(&path, &policy); err != nil {
+			return nil, err
+		}
+		policies[path] = policy
+	}
+	return policies, rows.Err()
+}
+
+// GetScopeOwners returns who created each of the given scopes, where known.
+func (db *DB) GetScopeOwners(paths []string) (map[string]string, error) {
+	owners := make(map[string]string)
+	if len(paths) == 0 {
+		return owners, nil
+	}
+
+	pathsJSON, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.conn.Query(`SELECT path, owner FROM env_scopes
+	          WHERE owner != '' AND path IN (SELECT value FROM json_each(?))`, string(pathsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path, owner string
+		if err := rows.Scan(&path, &owner); err != nil {
+			return nil, err
+		}
+		owners[path] = owner
+	}
+	return owners, rows.Err()
+}
+
+// ListScopes returns every path and profile with vars, clears, aliases or
+// tasks, sorted by path and profile.
+func (db *DB) ListScopes() ([]ScopeRef, error) {
+	rows, err := db.conn.Query(`SELECT path, profile FROM env_vars
+	          UNION SELECT path, profile FROM env_clears
+	          UNION SELECT path, profile FROM env_aliases
+	          UNION SELECT path, profile FROM env_tasks
+	          ORDER BY path, profile`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scopes []ScopeRef
+	for rows.Next() {
+		var sc ScopeRef
+		if err := rows.Scan(&sc.Path, &sc.Profile); err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, sc)
+	}
+	return scopes, rows.Err()
+}
+
+// DropScopes deletes everything stored at paths, in every profile, with
+// their scope records, in a transaction. Vars deleted this way skip the
+// trash.
+func (db *DB) DropScopes(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	pathsJSON, err := json.Marshal(paths)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"env_vars", "env_tags", "env_clears", "env_aliases", "env_tasks", "env_resolved", "env_scopes"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE path IN (SELECT value FROM json_each(?))`, string(pathsJSON)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetVarsBatch sets multiple variables in a transaction.
+func (db *DB) SetVarsBatch(path, profile string, vars map[string]VarData) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Ensure scope exists
+	_, err = tx.Exec(`INSERT OR IGNORE INTO env_scopes (path, owner, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)`, path, db.author)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO env_vars (path, profile, key, value, description, author, updated_at, created_at)
+	                         VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	                         ON CONFLICT(path, profile, key)
+	                         DO UPDATE SET value = excluded.value, description = excluded.description, author = excluded.author, updated_at = CURRENT_TIMESTAMP`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for key, data := range vars {
+		if _, err := stmt.Exec(path, profile, key, data.Value, data.Description, db.authorOf(data)); err != nil {
+			return redact.WrapValue(err, key, data.Value)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteVarsBatch moves multiple variables to the trash in a transaction.
 func (db *DB) DeleteVarsBatch(path, profile string, keys []string) error {
 	if len(keys) == 0 {
 		return nil
@@ -256,6 +1342,12 @@ func (db *DB) DeleteVarsBatch(path, profile string, keys []string) error {
 	}
 	defer tx.Rollback()
 
+	trashStmt, err := tx.Prepare(trashInsert + `v.path = ? AND v.profile = ? AND v.key = ?`)
+	if err != nil {
+		return err
+	}
+	defer trashStmt.Close()
+
 	stmt, err := tx.Prepare(`DELETE FROM env_vars WHERE path = ? AND profile = ? AND key = ?`)
 	if err != nil {
 		return err
@@ -263,6 +1355,9 @@ func (db *DB) DeleteVarsBatch(path, profile string, keys []string) error {
 	defer stmt.Close()
 
 	for _, key := range keys {
+		if _, err := trashStmt.Exec(db.author, path, profile, key); err != nil {
+			return err
+		}
 		if _, err := stmt.Exec(path, profile, key); err != nil {
 			return err
 		}
@@ -270,3 +1365,86 @@ func (db *DB) DeleteVarsBatch(path, profile string, keys []string) error {
 
 	return tx.Commit()
 }
+
+// ApplyChanges applies moves, then upserts and deletions, across scopes in
+// a single transaction. Deleted vars go to the trash.
+func (db *DB) ApplyChanges(changes []ScopeChange) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Moves go first so sets can update a var where it lands
+	for _, c := range changes {
+		for key, dst := range c.Move {
+			if err := db.moveVar(tx, c.Path, c.Profile, dst, c.Profile, key, false, true); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	setStmt, err := tx.Prepare(`INSERT INTO env_vars (path, profile, key, value, description, author, updated_at, created_at)
+	                            VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	                            ON CONFLICT(path, profile, key)
+	                            DO UPDATE SET value = excluded.value, description = excluded.description, author = excluded.author, updated_at = CURRENT_TIMESTAMP`)
+	if err != nil {
+		return err
+	}
+	defer setStmt.Close()
+
+	trashStmt, err := tx.Prepare(trashInsert + `v.path = ? AND v.profile = ? AND v.key = ?`)
+	if err != nil {
+		return err
+	}
+	defer trashStmt.Close()
+
+	delStmt, err := tx.Prepare(`DELETE FROM env_vars WHERE path = ? AND profile = ? AND key = ?`)
+	if err != nil {
+		return err
+	}
+	defer delStmt.Close()
+
+	flagsStmt, err := tx.Prepare(`UPDATE env_vars SET if_unset = ?, eval = ?, refresh = ?, no_export = ?, fallback = ?, value_type = ?
+	                              WHERE path = ? AND profile = ? AND key = ?`)
+	if err != nil {
+		return err
+	}
+	defer flagsStmt.Close()
+
+	for _, c := range changes {
+		if len(c.Set) > 0 {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO env_scopes (path, owner, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)`, c.Path, db.author); err != nil {
+				return err
+			}
+		}
+		for _, key := range c.Delete {
+			if _, err := trashStmt.Exec(db.author, c.Path, c.Profile, key); err != nil {
+				return err
+			}
+			if _, err := delStmt.Exec(c.Path, c.Profile, key); err != nil {
+				return err
+			}
+		}
+		for key, data := range c.Set {
+			if _, err := setStmt.Exec(c.Path, c.Profile, key, data.Value, data.Description, db.authorOf(data)); err != nil {
+				return redact.WrapValue(err, key, data.Value)
+			}
+			if f := data.Flags; f != nil {
+				if _, err := flagsStmt.Exec(f.IfUnset, f.Eval, f.Refresh, f.NoExport, f.Fallback, f.Type, c.Path, c.Profile, key); err != nil {
+					return redact.WrapValue(err, key, f.Fallback)
+				}
+				if _, err := tx.Exec(`DELETE FROM env_tags WHERE path = ? AND profile = ? AND key = ?`, c.Path, c.Profile, key); err != nil {
+					return err
+				}
+				for _, tag := range f.Tags {
+					if _, err := tx.Exec(`INSERT OR IGNORE INTO env_tags (path, profile, key, tag) VALUES (?, ?, ?, ?)`, c.Path, c.Profile, key, tag); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	return tx.Commit()
+}
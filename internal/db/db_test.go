@@ -1,9 +1,16 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/nick-skriabin/enva/internal/redact"
 )
 
 func setupTestDB(t *testing.T) (*DB, func()) {
@@ -15,7 +22,7 @@ func setupTestDB(t *testing.T) (*DB, func()) {
 	}
 
 	dbPath := filepath.Join(tmpDir, "test.db")
-	db, err := Open(dbPath)
+	db, err := OpenSQLite(dbPath)
 	if err != nil {
 		os.RemoveAll(tmpDir)
 		t.Fatalf("Failed to open database: %v", err)
@@ -76,6 +83,28 @@ func TestSetAndGetVar(t *testing.T) {
 	}
 }
 
+func TestSetVarErrorCarriesValue(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := db.conn.Exec(`CREATE TRIGGER reject BEFORE INSERT ON env_vars
+	                           BEGIN SELECT RAISE(ABORT, 'rejected'); END`); err != nil {
+		t.Fatalf("CREATE TRIGGER failed: %v", err)
+	}
+
+	err := db.SetVar("/test/path", "default", "API_KEY", "secret123", "")
+	if err == nil {
+		t.Fatal("SetVar should fail")
+	}
+	if strings.Contains(err.Error(), "secret123") || !strings.Contains(err.Error(), "API_KEY") {
+		t.Errorf("SetVar error = %q", err)
+	}
+	var ve *redact.ValueError
+	if !errors.As(err, &ve) || ve.Value.Value() != "secret123" {
+		t.Errorf("SetVar error should carry the value, got %T", err)
+	}
+}
+
 func TestSetVarUpsert(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -313,3 +342,555 @@ func TestDeleteVarsForPath(t *testing.T) {
 		t.Errorf("Other path should still have 1 var, got %d", len(otherVars))
 	}
 }
+
+func TestCountVars(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	n, err := db.CountVars()
+	if err != nil {
+		t.Fatalf("CountVars failed: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("CountVars on empty db = %d, want 0", n)
+	}
+
+	db.SetVar("/a", "default", "K1", "v1", "")
+	db.SetVar("/b", "default", "K2", "v2", "")
+	db.SetVar("/a", "production", "K1", "v3", "")
+
+	n, err = db.CountVars()
+	if err != nil {
+		t.Fatalf("CountVars failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("CountVars = %d, want 3", n)
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	db.SetVar("/a", "default", "OLD", "x", "")
+
+	err := db.ApplyChanges([]ScopeChange{
+		{Path: "/a", Profile: "default", Set: map[string]VarData{"NEW": {Value: "1", Description: "d"}}, Delete: []string{"OLD"}},
+		{Path: "/b", Profile: "production", Set: map[string]VarData{"K": {Value: "2"}}},
+	})
+	if err != nil {
+		t.Fatalf("ApplyChanges failed: %v", err)
+	}
+
+	if v, _ := db.GetVar("/a", "default", "OLD"); v != nil {
+		t.Error("OLD should be deleted")
+	}
+	if v, _ := db.GetVar("/a", "default", "NEW"); v == nil || v.Value != "1" || v.Description != "d" {
+		t.Errorf("NEW = %+v", v)
+	}
+	if v, _ := db.GetVar("/b", "production", "K"); v == nil || v.Value != "2" {
+		t.Errorf("K = %+v", v)
+	}
+}
+
+func TestScopePolicies(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.SetScopePolicy("/a", `{"prefix":"APP_"}`); err != nil {
+		t.Fatalf("SetScopePolicy failed: %v", err)
+	}
+	db.SetScopePolicy("/b", "")
+
+	policies, err := db.GetScopePolicies([]string{"/a", "/b", "/c"})
+	if err != nil {
+		t.Fatalf("GetScopePolicies failed: %v", err)
+	}
+	if len(policies) != 1 || policies["/a"] != `{"prefix":"APP_"}` {
+		t.Errorf("GetScopePolicies = %v", policies)
+	}
+
+	// Clearing removes it
+	db.SetScopePolicy("/a", "")
+	policies, _ = db.GetScopePolicies([]string{"/a"})
+	if len(policies) != 0 {
+		t.Errorf("policy should be cleared, got %v", policies)
+	}
+}
+
+func TestResolveDBPath(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("ENVA_DB", "")
+	def, err := DefaultDBPath()
+	if err != nil {
+		t.Fatalf("DefaultDBPath failed: %v", err)
+	}
+
+	if got, _ := ResolveDBPath(""); got != def {
+		t.Errorf("ResolveDBPath(\"\") = %q, want default %q", got, def)
+	}
+
+	t.Setenv("ENVA_DB", "/env/enva.db")
+	if got, _ := ResolveDBPath(""); got != "/env/enva.db" {
+		t.Errorf("ResolveDBPath with ENVA_DB = %q", got)
+	}
+
+	if got, _ := ResolveDBPath("/flag/enva.db"); got != "/flag/enva.db" {
+		t.Errorf("ResolveDBPath with override = %q", got)
+	}
+}
+
+func TestGetAllVars(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	db.SetVar("/b", "default", "K", "1", "")
+	db.SetVar("/a", "default", "K", "2", "")
+	db.SetVar("/a", "production", "K", "3", "")
+
+	vars, err := db.GetAllVars("default")
+	if err != nil {
+		t.Fatalf("GetAllVars failed: %v", err)
+	}
+	if len(vars) != 2 || vars[0].Path != "/a" || vars[1].Path != "/b" {
+		t.Errorf("GetAllVars = %+v", vars)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	db.SetVar("/a", "default", "KEY", "value", "")
+	res, err := Query(db.Path(), "SELECT key, value, 1 AS n, NULL AS none FROM env_vars")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(res.Columns) != 4 || res.Columns[0] != "key" || res.Columns[3] != "none" {
+		t.Errorf("Columns = %v", res.Columns)
+	}
+	if len(res.Rows) != 1 || res.Rows[0][0] != "KEY" || res.Rows[0][1] != "value" || res.Rows[0][2] != int64(1) || res.Rows[0][3] != nil {
+		t.Errorf("Rows = %v", res.Rows)
+	}
+
+	// The connection is read-only
+	if _, err := Query(db.Path(), "DELETE FROM env_vars"); err == nil {
+		t.Error("Query should refuse to write")
+	}
+	if n, _ := db.CountVars(); n != 1 {
+		t.Errorf("CountVars after refused delete = %d, want 1", n)
+	}
+
+	if SQLitePath("json:/x.json") != "" || SQLitePath("memory:") != "" || SQLitePath("sqlite:/x.db") != "/x.db" {
+		t.Error("SQLitePath should only return SQLite files")
+	}
+}
+
+func TestPath(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if filepath.Base(db.Path()) != "test.db" {
+		t.Errorf("Path() = %q", db.Path())
+	}
+}
+
+func TestClears(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.AddClear("/a", "default", "AWS_PROFILE"); err != nil {
+		t.Fatalf("AddClear failed: %v", err)
+	}
+	// Adding twice is a no-op
+	db.AddClear("/a", "default", "AWS_PROFILE")
+	db.AddClear("/b", "default", "OTHER")
+	db.AddClear("/a", "production", "PROD_ONLY")
+
+	clears, err := db.GetClearsForPaths([]string{"/a", "/b"}, "default")
+	if err != nil {
+		t.Fatalf("GetClearsForPaths failed: %v", err)
+	}
+	if len(clears) != 2 || clears[0].Key != "AWS_PROFILE" || clears[1].Key != "OTHER" {
+		t.Errorf("GetClearsForPaths = %+v", clears)
+	}
+
+	if err := db.RemoveClear("/a", "default", "AWS_PROFILE"); err != nil {
+		t.Fatalf("RemoveClear failed: %v", err)
+	}
+	clears, _ = db.GetClearsForPaths([]string{"/a"}, "default")
+	if len(clears) != 0 {
+		t.Errorf("clear should be removed, got %+v", clears)
+	}
+}
+
+func TestSetIfUnset(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	db.SetVar("/a", "default", "EDITOR", "vim", "")
+	if err := db.SetIfUnset("/a", "default", "EDITOR", true); err != nil {
+		t.Fatalf("SetIfUnset failed: %v", err)
+	}
+
+	v, _ := db.GetVar("/a", "default", "EDITOR")
+	if v == nil || !v.IfUnset {
+		t.Fatalf("EDITOR should be if-unset, got %+v", v)
+	}
+
+	// Updating the value keeps the attribute
+	db.SetVar("/a", "default", "EDITOR", "nvim", "")
+	v, _ = db.GetVar("/a", "default", "EDITOR")
+	if !v.IfUnset || v.Value != "nvim" {
+		t.Errorf("after update = %+v", v)
+	}
+
+	db.SetIfUnset("/a", "default", "EDITOR", false)
+	vars, _ := db.GetVarsForPaths([]string{"/a"}, "default")
+	if len(vars) != 1 || vars[0].IfUnset {
+		t.Errorf("EDITOR should be a regular override, got %+v", vars)
+	}
+}
+
+func TestDataDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("ENVA_DATA_DIR", "")
+	t.Setenv("XDG_DATA_HOME", "/xdg")
+
+	if got, _ := DataDir(); got != filepath.Join("/xdg", "enva") {
+		t.Errorf("DataDir with XDG_DATA_HOME = %q", got)
+	}
+
+	t.Setenv("ENVA_DATA_DIR", "/custom")
+	if got, _ := DataDir(); got != "/custom" {
+		t.Errorf("DataDir with ENVA_DATA_DIR = %q", got)
+	}
+	if got, _ := DefaultDBPath(); got != filepath.Join("/custom", "enva.db") {
+		t.Errorf("DefaultDBPath = %q", got)
+	}
+}
+
+func TestResolveDBPathMigratesLegacy(t *testing.T) {
+	home := t.TempDir()
+	dataDir := filepath.Join(t.TempDir(), "data")
+	t.Setenv("HOME", home)
+	t.Setenv("ENVA_DB", "")
+	t.Setenv("ENVA_DATA_DIR", dataDir)
+
+	legacy := filepath.Join(home, ".local", "share", "enva", "enva.db")
+	database, err := OpenSQLite(legacy)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	database.SetVar("/p", "default", "KEY", "value", "")
+	database.Close()
+
+	got, err := ResolveDBPath("")
+	if err != nil {
+		t.Fatalf("ResolveDBPath failed: %v", err)
+	}
+	want := filepath.Join(dataDir, "enva.db")
+	if got != want {
+		t.Fatalf("ResolveDBPath = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Error("legacy database should have been moved")
+	}
+
+	database, err = OpenSQLite(got)
+	if err != nil {
+		t.Fatalf("Open migrated failed: %v", err)
+	}
+	defer database.Close()
+	if v, _ := database.GetVar("/p", "default", "KEY"); v == nil || v.Value != "value" {
+		t.Errorf("migrated database lost data: %+v", v)
+	}
+
+	// A second resolve is a no-op
+	if again, _ := ResolveDBPath(""); again != want {
+		t.Errorf("second ResolveDBPath = %q, want %q", again, want)
+	}
+}
+
+func TestMigrateDBCheckpointsWAL(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live.db")
+	conn, err := sql.Open("sqlite", live)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	for _, q := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA wal_autocheckpoint=0",
+		"CREATE TABLE t (v TEXT)",
+		"INSERT INTO t VALUES ('in the wal')",
+	} {
+		if _, err := conn.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+
+	// A copy taken now has the row only in its -wal, as after a crash
+	src := filepath.Join(dir, "legacy", "enva.db")
+	os.MkdirAll(filepath.Dir(src), 0755)
+	for _, suffix := range []string{"", "-wal"} {
+		data, err := os.ReadFile(live + suffix)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		os.WriteFile(src+suffix, data, 0644)
+	}
+
+	dst := filepath.Join(dir, "data", "enva.db")
+	const n = 4
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() { errs <- migrateDB(src, dst) }()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("migrateDB failed: %v", err)
+		}
+	}
+	for _, leftover := range []string{src, src + "-wal", src + "-shm", dst + "-wal", dst + ".migrating"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind", filepath.Base(leftover))
+		}
+	}
+
+	moved, err := sql.Open("sqlite", dst)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer moved.Close()
+	var v string
+	if err := moved.QueryRow("SELECT v FROM t").Scan(&v); err != nil || v != "in the wal" {
+		t.Errorf("moved database = %q, %v; want the WAL's row", v, err)
+	}
+}
+
+func TestOpenConcurrentFirstRun(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "fresh", "enva.db")
+
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			database, err := OpenSQLite(dbPath)
+			if err == nil {
+				_, err = database.GetVarsForPath("/p", "default")
+				database.Close()
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("concurrent Open failed: %v", err)
+		}
+	}
+
+	database, err := OpenSQLite(dbPath)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer database.Close()
+
+	var version int
+	if err := database.conn.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatalf("user_version failed: %v", err)
+	}
+	if version != schemaVersion {
+		t.Errorf("user_version = %d, want %d", version, schemaVersion)
+	}
+}
+
+func TestTags(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	database.SetVar("/p", "default", "DB_URL", "postgres://", "")
+	database.SetVar("/p", "default", "AWS_REGION", "us-east-1", "")
+
+	if err := database.AddTag("/p", "default", "DB_URL", "db"); err != nil {
+		t.Fatalf("AddTag failed: %v", err)
+	}
+	database.AddTag("/p", "default", "DB_URL", "db") // duplicate is a no-op
+	database.AddTag("/p", "default", "AWS_REGION", "aws")
+	database.AddTag("/p", "production", "AWS_REGION", "prod-only")
+
+	tags, err := database.GetTagsForPaths([]string{"/p"}, "default")
+	if err != nil {
+		t.Fatalf("GetTagsForPaths failed: %v", err)
+	}
+	if len(tags) != 2 || tags[0].Key != "AWS_REGION" || tags[1].Tag != "db" {
+		t.Errorf("GetTagsForPaths = %+v", tags)
+	}
+
+	database.RemoveTag("/p", "default", "AWS_REGION", "aws")
+	tags, _ = database.GetTagsForPaths([]string{"/p"}, "default")
+	if len(tags) != 1 {
+		t.Errorf("after RemoveTag = %+v", tags)
+	}
+
+	t.Run("deleting var drops its tags", func(t *testing.T) {
+		database.DeleteVar("/p", "default", "DB_URL")
+		database.SetVar("/p", "default", "DB_URL", "again", "")
+		tags, _ := database.GetTagsForPaths([]string{"/p"}, "default")
+		if len(tags) != 0 {
+			t.Errorf("tags survived delete: %+v", tags)
+		}
+	})
+}
+
+func TestMoveVar(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	database.SetVar("/a", "default", "KEY", "value", "desc")
+	database.SetIfUnset("/a", "default", "KEY", true)
+	database.SetEval("/a", "default", "KEY", true)
+	database.AddTag("/a", "default", "KEY", "grp")
+
+	t.Run("move", func(t *testing.T) {
+		if err := database.MoveVar("/a", "default", "/b", "prod", "KEY", false, false); err != nil {
+			t.Fatalf("MoveVar failed: %v", err)
+		}
+		if v, _ := database.GetVar("/a", "default", "KEY"); v != nil {
+			t.Error("source should be gone after move")
+		}
+		v, _ := database.GetVar("/b", "prod", "KEY")
+		if v == nil || v.Value != "value" || v.Description != "desc" || !v.IfUnset || !v.Eval {
+			t.Errorf("destination = %+v", v)
+		}
+		tags, _ := database.GetTagsForPaths([]string{"/b"}, "prod")
+		if len(tags) != 1 || tags[0].Tag != "grp" {
+			t.Errorf("tags not moved: %+v", tags)
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		if err := database.MoveVar("/b", "prod", "/c", "prod", "KEY", true, false); err != nil {
+			t.Fatalf("MoveVar copy failed: %v", err)
+		}
+		if v, _ := database.GetVar("/b", "prod", "KEY"); v == nil {
+			t.Error("source should remain after copy")
+		}
+		if v, _ := database.GetVar("/c", "prod", "KEY"); v == nil {
+			t.Error("copy missing at destination")
+		}
+	})
+
+	t.Run("refuses overwrite", func(t *testing.T) {
+		database.SetVar("/d", "prod", "KEY", "other", "")
+		if err := database.MoveVar("/b", "prod", "/d", "prod", "KEY", false, false); !errors.Is(err, ErrVarExists) {
+			t.Errorf("MoveVar onto existing = %v, want ErrVarExists", err)
+		}
+		if v, _ := database.GetVar("/b", "prod", "KEY"); v == nil {
+			t.Error("failed move must not delete the source")
+		}
+
+		if err := database.MoveVar("/b", "prod", "/d", "prod", "KEY", false, true); err != nil {
+			t.Fatalf("MoveVar overwrite failed: %v", err)
+		}
+		if v, _ := database.GetVar("/d", "prod", "KEY"); v == nil || v.Value != "value" {
+			t.Errorf("overwritten destination = %+v", v)
+		}
+	})
+
+	t.Run("missing source", func(t *testing.T) {
+		if err := database.MoveVar("/x", "prod", "/y", "prod", "KEY", false, false); !errors.Is(err, ErrVarNotFound) {
+			t.Errorf("MoveVar missing = %v, want ErrVarNotFound", err)
+		}
+	})
+}
+
+func TestResolvedCache(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	data, gen, err := database.GetResolved("/p", "default", "/")
+	if err != nil || data != nil {
+		t.Fatalf("GetResolved on empty cache = %q, %v", data, err)
+	}
+	if err := database.PutResolved("/p", "default", "/", gen, []byte("merged")); err != nil {
+		t.Fatalf("PutResolved failed: %v", err)
+	}
+	if data, _, _ := database.GetResolved("/p", "default", "/"); string(data) != "merged" {
+		t.Errorf("GetResolved = %q, want merged", data)
+	}
+	if data, _, _ := database.GetResolved("/p", "default", "/other-root"); data != nil {
+		t.Errorf("GetResolved with another root = %q, want a miss", data)
+	}
+
+	// Every kind of write invalidates the entry
+	writes := []struct {
+		name  string
+		write func()
+	}{
+		{"var", func() { database.SetVar("/q", "default", "K", "v", "") }},
+		{"tag", func() { database.AddTag("/q", "default", "K", "aws") }},
+		{"clear", func() { database.AddClear("/q", "default", "X") }},
+		{"alias", func() { database.SetAlias("/q", "default", "dc", "docker compose") }},
+		{"unset", func() { database.DeleteVar("/q", "default", "K") }},
+	}
+	for _, w := range writes {
+		name := w.name
+		_, gen, _ := database.GetResolved("/p", "default", "/")
+		database.PutResolved("/p", "default", "/", gen, []byte("merged"))
+		w.write()
+		data, next, _ := database.GetResolved("/p", "default", "/")
+		if data != nil || next == gen {
+			t.Errorf("after %s write: data = %q, generation %d -> %d", name, data, gen, next)
+		}
+
+		// A result computed before the write is stale and not stored
+		database.PutResolved("/p", "default", "/", gen, []byte("stale"))
+		if data, _, _ := database.GetResolved("/p", "default", "/"); data != nil {
+			t.Errorf("after %s write: stale result stored", name)
+		}
+	}
+}
+
+func TestIsLocked(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Hold the write lock on one connection...
+	conn, err := database.conn.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Conn failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "BEGIN IMMEDIATE"); err != nil {
+		t.Fatalf("BEGIN IMMEDIATE failed: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	// ...and ask for it on another without waiting
+	other, err := sql.Open("sqlite", database.Path())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer other.Close()
+	_, err = other.Exec("BEGIN IMMEDIATE")
+	if !IsLocked(err) {
+		t.Errorf("IsLocked(%v) = false, want true", err)
+	}
+
+	if IsLocked(ErrVarNotFound) || IsLocked(nil) {
+		t.Error("IsLocked should be false for other errors")
+	}
+}
+
+func TestEnvVarStringRedactsValue(t *testing.T) {
+	v := EnvVar{Path: "/a", Profile: "default", Key: "TOKEN", Value: "hunter22"}
+	for _, s := range []string{fmt.Sprint(v), fmt.Sprintf("%+v", &v), fmt.Sprintf("%v", []EnvVar{v})} {
+		if strings.Contains(s, "hunter22") || !strings.Contains(s, "TOKEN") {
+			t.Errorf("formatted as %q", s)
+		}
+	}
+}
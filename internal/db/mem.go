@@ -0,0 +1,1117 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// varID identifies a variable within a store.
+type varID struct {
+	Path    string
+	Profile string
+	Key     string
+}
+
+// memData is the full contents of a memStore.
+type memData struct {
+	vars     map[varID]EnvVar
+	scopes   map[string]memScope
+	clears   map[varID]bool
+	tags     map[varID]map[string]bool
+	policies map[string]string
+	aliases  map[varID]string // Keyed by name
+	tasks    map[varID]string // Keyed by name
+	trash    []TrashedVar     // In deletion order
+	trashSeq int64            // Last trash ID handed out
+}
+
+type memScope struct {
+	CreatedAt time.Time
+	Owner     string
+}
+
+func newMemData() *memData {
+	return &memData{
+		vars:     make(map[varID]EnvVar),
+		scopes:   make(map[string]memScope),
+		clears:   make(map[varID]bool),
+		tags:     make(map[varID]map[string]bool),
+		policies: make(map[string]string),
+		aliases:  make(map[varID]string),
+		tasks:    make(map[varID]string),
+	}
+}
+
+func (d *memData) clone() *memData {
+	c := newMemData()
+	for k, v := range d.vars {
+		c.vars[k] = v
+	}
+	for k, v := range d.scopes {
+		c.scopes[k] = v
+	}
+	for k := range d.clears {
+		c.clears[k] = true
+	}
+	for k, tags := range d.tags {
+		c.tags[k] = make(map[string]bool, len(tags))
+		for t := range tags {
+			c.tags[k][t] = true
+		}
+	}
+	for k, v := range d.policies {
+		c.policies[k] = v
+	}
+	for k, v := range d.aliases {
+		c.aliases[k] = v
+	}
+	for k, v := range d.tasks {
+		c.tasks[k] = v
+	}
+	c.trash = append([]TrashedVar(nil), d.trash...)
+	c.trashSeq = d.trashSeq
+	return c
+}
+
+func (d *memData) ensureScope(path, owner string) {
+	if _, ok := d.scopes[path]; !ok {
+		d.scopes[path] = memScope{CreatedAt: time.Now().UTC(), Owner: owner}
+	}
+}
+
+// setVar upserts a variable, keeping its if-unset flag like the SQLite upsert.
+func (d *memData) setVar(path, profile, key string, data VarData, author string) {
+	if data.Author != "" {
+		author = data.Author
+	}
+	d.ensureScope(path, author)
+	id := varID{path, profile, key}
+	v, exists := d.vars[id]
+	v.Path, v.Profile, v.Key = path, profile, key
+	v.Value, v.Description, v.Author = data.Value, data.Description, author
+	v.UpdatedAt = time.Now().UTC()
+	if !exists {
+		v.CreatedAt = v.UpdatedAt
+	}
+	if f := data.Flags; f != nil {
+		v.IfUnset, v.Eval, v.Refresh, v.NoExport = f.IfUnset, f.Eval, f.Refresh, f.NoExport
+		v.Fallback, v.Type = f.Fallback, f.Type
+		delete(d.tags, id)
+		for _, tag := range f.Tags {
+			if d.tags[id] == nil {
+				d.tags[id] = make(map[string]bool)
+			}
+			d.tags[id][tag] = true
+		}
+	}
+	d.vars[id] = v
+}
+
+// deleteVar removes a variable and, like the SQLite trigger, its tags.
+func (d *memData) deleteVar(id varID) {
+	delete(d.vars, id)
+	delete(d.tags, id)
+}
+
+// trashVar moves a variable, with its tags, to the trash.
+func (d *memData) trashVar(id varID, by string) {
+	v, ok := d.vars[id]
+	if !ok {
+		return
+	}
+	d.trashSeq++
+	t := TrashedVar{EnvVar: v, ID: d.trashSeq, DeletedAt: time.Now().UTC(), DeletedBy: by}
+	for tag := range d.tags[id] {
+		t.Tags = append(t.Tags, tag)
+	}
+	sort.Strings(t.Tags)
+	d.trash = append(d.trash, t)
+	d.deleteVar(id)
+}
+
+// memStore keeps everything in memory. With a file it's the JSON backend:
+// the file is read on open and rewritten after every change. It suits
+// single-user setups; writes from concurrent processes aren't merged, the
+// last one wins.
+type memStore struct {
+	mu       sync.Mutex
+	location string
+	file     string
+	author   string
+	data     *memData
+}
+
+func newMemStore(location string) *memStore {
+	return &memStore{location: location, data: newMemData()}
+}
+
+// openJSON opens or creates the JSON file store at path.
+func openJSON(path string) (*memStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	s := newMemStore(schemeJSON + path)
+	s.file = path
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.data.decode(raw); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the location the store was opened from.
+func (s *memStore) Path() string {
+	return s.location
+}
+
+// SetAuthor sets who is recorded on subsequent writes.
+func (s *memStore) SetAuthor(author string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.author = author
+}
+
+// Close releases the store. Every change is already on disk.
+func (s *memStore) Close() error {
+	return nil
+}
+
+// read runs fn with the store locked for reading.
+func (s *memStore) read(fn func(d *memData)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.data)
+}
+
+// update runs fn on a copy of the data and keeps the copy only if fn and
+// saving both succeed, so every change is all-or-nothing.
+func (s *memStore) update(fn func(d *memData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := s.data.clone()
+	if err := fn(next); err != nil {
+		return err
+	}
+	if s.file != "" {
+		if err := next.save(s.file); err != nil {
+			return err
+		}
+	}
+	s.data = next
+	return nil
+}
+
+// GetVarsForPaths retrieves all variables for the given paths and profile.
+func (s *memStore) GetVarsForPaths(paths []string, profile string) ([]EnvVar, error) {
+	in := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		in[p] = true
+	}
+	var vars []EnvVar
+	s.read(func(d *memData) {
+		for id, v := range d.vars {
+			if id.Profile == profile && in[id.Path] {
+				vars = append(vars, v)
+			}
+		}
+	})
+	sortVars(vars)
+	return vars, nil
+}
+
+// GetVarsForPath retrieves all variables for a specific path and profile.
+func (s *memStore) GetVarsForPath(path, profile string) ([]EnvVar, error) {
+	return s.GetVarsForPaths([]string{path}, profile)
+}
+
+// GetVar retrieves a specific variable.
+func (s *memStore) GetVar(path, profile, key string) (*EnvVar, error) {
+	var found *EnvVar
+	s.read(func(d *memData) {
+		if v, ok := d.vars[varID{path, profile, key}]; ok {
+			found = &v
+		}
+	})
+	return found, nil
+}
+
+// GetAllVars retrieves every variable for a profile, ordered by path and key.
+func (s *memStore) GetAllVars(profile string) ([]EnvVar, error) {
+	var vars []EnvVar
+	s.read(func(d *memData) {
+		for id, v := range d.vars {
+			if id.Profile == profile {
+				vars = append(vars, v)
+			}
+		}
+	})
+	sortVars(vars)
+	return vars, nil
+}
+
+// GetScopesWithVars returns every scope with variables in profile, sorted by
+// path, with their counts, latest update and owner.
+func (s *memStore) GetScopesWithVars(profile string) ([]ScopeSummary, error) {
+	byPath := make(map[string]*ScopeSummary)
+	s.read(func(d *memData) {
+		for id, v := range d.vars {
+			if id.Profile != profile {
+				continue
+			}
+			sc, ok := byPath[id.Path]
+			if !ok {
+				sc = &ScopeSummary{Path: id.Path, Owner: d.scopes[id.Path].Owner}
+				byPath[id.Path] = sc
+			}
+			sc.Vars++
+			if v.UpdatedAt.After(sc.UpdatedAt) {
+				sc.UpdatedAt = v.UpdatedAt
+			}
+		}
+	})
+	scopes := make([]ScopeSummary, 0, len(byPath))
+	for _, sc := range byPath {
+		scopes = append(scopes, *sc)
+	}
+	sort.Slice(scopes, func(i, j int) bool { return scopes[i].Path < scopes[j].Path })
+	return scopes, nil
+}
+
+// CountVars returns the total number of variables across all paths and profiles.
+func (s *memStore) CountVars() (int, error) {
+	var n int
+	s.read(func(d *memData) { n = len(d.vars) })
+	return n, nil
+}
+
+// ListProfiles returns the profiles that have at least one variable, sorted.
+func (s *memStore) ListProfiles() ([]string, error) {
+	seen := make(map[string]bool)
+	s.read(func(d *memData) {
+		for id := range d.vars {
+			seen[id.Profile] = true
+		}
+	})
+	var profiles []string
+	for p := range seen {
+		profiles = append(profiles, p)
+	}
+	sort.Strings(profiles)
+	return profiles, nil
+}
+
+// SetVar upserts a variable at the given path/profile/key.
+func (s *memStore) SetVar(path, profile, key, value, description string) error {
+	return s.update(func(d *memData) error {
+		d.setVar(path, profile, key, VarData{Value: value, Description: description}, s.author)
+		return nil
+	})
+}
+
+// SetIfUnset marks an existing variable as a default or a regular override.
+func (s *memStore) SetIfUnset(path, profile, key string, ifUnset bool) error {
+	return s.update(func(d *memData) error {
+		id := varID{path, profile, key}
+		if v, ok := d.vars[id]; ok {
+			v.IfUnset = ifUnset
+			d.vars[id] = v
+		}
+		return nil
+	})
+}
+
+// SetEval marks an existing variable's value as a command or a literal.
+func (s *memStore) SetEval(path, profile, key string, eval bool) error {
+	return s.update(func(d *memData) error {
+		id := varID{path, profile, key}
+		if v, ok := d.vars[id]; ok {
+			v.Eval = eval
+			d.vars[id] = v
+		}
+		return nil
+	})
+}
+
+// SetRefresh sets an eval variable's refresh interval in seconds.
+func (s *memStore) SetRefresh(path, profile, key string, seconds int) error {
+	return s.update(func(d *memData) error {
+		id := varID{path, profile, key}
+		if v, ok := d.vars[id]; ok {
+			v.Refresh = seconds
+			d.vars[id] = v
+		}
+		return nil
+	})
+}
+
+// SetNoExport marks an existing variable as kept out of exports, or not.
+func (s *memStore) SetNoExport(path, profile, key string, noExport bool) error {
+	return s.update(func(d *memData) error {
+		id := varID{path, profile, key}
+		if v, ok := d.vars[id]; ok {
+			v.NoExport = noExport
+			d.vars[id] = v
+		}
+		return nil
+	})
+}
+
+// SetFallback sets what an existing eval variable exports when its
+// command fails.
+func (s *memStore) SetFallback(path, profile, key, fallback string) error {
+	return s.update(func(d *memData) error {
+		id := varID{path, profile, key}
+		if v, ok := d.vars[id]; ok {
+			v.Fallback = fallback
+			d.vars[id] = v
+		}
+		return nil
+	})
+}
+
+// SetType declares an existing variable's value type.
+func (s *memStore) SetType(path, profile, key, valueType string) error {
+	return s.update(func(d *memData) error {
+		id := varID{path, profile, key}
+		if v, ok := d.vars[id]; ok {
+			v.Type = valueType
+			d.vars[id] = v
+		}
+		return nil
+	})
+}
+
+// DeleteVar moves a variable at the given path/profile/key to the trash.
+func (s *memStore) DeleteVar(path, profile, key string) error {
+	return s.update(func(d *memData) error {
+		d.trashVar(varID{path, profile, key}, s.author)
+		return nil
+	})
+}
+
+// DeleteVarsForPath moves all variables for a path and profile to the trash.
+func (s *memStore) DeleteVarsForPath(path, profile string) error {
+	return s.update(func(d *memData) error {
+		var ids []varID
+		for id := range d.vars {
+			if id.Path == path && id.Profile == profile {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i].Key < ids[j].Key })
+		for _, id := range ids {
+			d.trashVar(id, s.author)
+		}
+		return nil
+	})
+}
+
+// MoveVar moves (or with copy, duplicates) a variable with its description,
+// if-unset flag and tags, with the same semantics as the SQLite store.
+func (s *memStore) MoveVar(srcPath, srcProfile, dstPath, dstProfile, key string, copy, overwrite bool) error {
+	return s.update(func(d *memData) error {
+		return d.moveVar(varID{srcPath, srcProfile, key}, varID{dstPath, dstProfile, key}, copy, overwrite, s.author)
+	})
+}
+
+// moveVar moves or copies the var at src to dst with its tags.
+func (d *memData) moveVar(src, dst varID, copy, overwrite bool, author string) error {
+	v, ok := d.vars[src]
+	if !ok {
+		return ErrVarNotFound
+	}
+	if _, exists := d.vars[dst]; exists && !overwrite {
+		return ErrVarExists
+	}
+
+	srcTags := d.tags[src]
+	d.deleteVar(dst)
+	d.ensureScope(dst.Path, author)
+	v.Path, v.Profile = dst.Path, dst.Profile
+	v.UpdatedAt = time.Now().UTC()
+	d.vars[dst] = v
+	if len(srcTags) > 0 {
+		d.tags[dst] = make(map[string]bool, len(srcTags))
+		for t := range srcTags {
+			d.tags[dst][t] = true
+		}
+	}
+
+	if !copy {
+		d.deleteVar(src)
+	}
+	return nil
+}
+
+// SetVarsBatch sets multiple variables at once.
+func (s *memStore) SetVarsBatch(path, profile string, vars map[string]VarData) error {
+	return s.update(func(d *memData) error {
+		for key, data := range vars {
+			d.setVar(path, profile, key, data, s.author)
+		}
+		return nil
+	})
+}
+
+// DeleteVarsBatch moves multiple variables to the trash at once.
+func (s *memStore) DeleteVarsBatch(path, profile string, keys []string) error {
+	return s.update(func(d *memData) error {
+		for _, key := range keys {
+			d.trashVar(varID{path, profile, key}, s.author)
+		}
+		return nil
+	})
+}
+
+// ApplyChanges applies moves, then upserts and deletions, across scopes at
+// once. Deleted vars go to the trash.
+func (s *memStore) ApplyChanges(changes []ScopeChange) error {
+	return s.update(func(d *memData) error {
+		for _, c := range changes {
+			for key, dst := range c.Move {
+				if err := d.moveVar(varID{c.Path, c.Profile, key}, varID{dst, c.Profile, key}, false, true, s.author); err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+			}
+		}
+		for _, c := range changes {
+			for _, key := range c.Delete {
+				d.trashVar(varID{c.Path, c.Profile, key}, s.author)
+			}
+			for key, data := range c.Set {
+				d.setVar(c.Path, c.Profile, key, data, s.author)
+			}
+		}
+		return nil
+	})
+}
+
+// AddClear records key as force-unset at the given path/profile.
+func (s *memStore) AddClear(path, profile, key string) error {
+	return s.update(func(d *memData) error {
+		d.ensureScope(path, s.author)
+		d.clears[varID{path, profile, key}] = true
+		return nil
+	})
+}
+
+// RemoveClear removes a force-unset key at the given path/profile.
+func (s *memStore) RemoveClear(path, profile, key string) error {
+	return s.update(func(d *memData) error {
+		delete(d.clears, varID{path, profile, key})
+		return nil
+	})
+}
+
+// GetClearsForPaths retrieves force-unset keys for the given paths and profile.
+func (s *memStore) GetClearsForPaths(paths []string, profile string) ([]EnvClear, error) {
+	in := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		in[p] = true
+	}
+	var clears []EnvClear
+	s.read(func(d *memData) {
+		for id := range d.clears {
+			if id.Profile == profile && in[id.Path] {
+				clears = append(clears, EnvClear{Path: id.Path, Profile: id.Profile, Key: id.Key})
+			}
+		}
+	})
+	sort.Slice(clears, func(i, j int) bool {
+		if clears[i].Path != clears[j].Path {
+			return clears[i].Path < clears[j].Path
+		}
+		return clears[i].Key < clears[j].Key
+	})
+	return clears, nil
+}
+
+// PathsWithScopes returns the paths, in order, that have vars, clears,
+// aliases or tasks for profile.
+func (s *memStore) PathsWithScopes(paths []string, profile string) ([]string, error) {
+	used := make(map[string]bool)
+	s.read(func(d *memData) {
+		for id := range d.vars {
+			if id.Profile == profile {
+				used[id.Path] = true
+			}
+		}
+		for id := range d.clears {
+			if id.Profile == profile {
+				used[id.Path] = true
+			}
+		}
+		for id := range d.aliases {
+			if id.Profile == profile {
+				used[id.Path] = true
+			}
+		}
+		for id := range d.tasks {
+			if id.Profile == profile {
+				used[id.Path] = true
+			}
+		}
+	})
+	var scoped []string
+	for _, p := range paths {
+		if used[p] {
+			scoped = append(scoped, p)
+		}
+	}
+	return scoped, nil
+}
+
+// SetAlias defines a shell alias at the given path/profile.
+func (s *memStore) SetAlias(path, profile, name, command string) error {
+	return s.update(func(d *memData) error {
+		d.aliases[varID{path, profile, name}] = command
+		return nil
+	})
+}
+
+// DeleteAlias removes a shell alias at the given path/profile.
+func (s *memStore) DeleteAlias(path, profile, name string) error {
+	return s.update(func(d *memData) error {
+		delete(d.aliases, varID{path, profile, name})
+		return nil
+	})
+}
+
+// GetAliasesForPaths retrieves shell aliases for the given paths and profile.
+func (s *memStore) GetAliasesForPaths(paths []string, profile string) ([]EnvAlias, error) {
+	in := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		in[p] = true
+	}
+	var aliases []EnvAlias
+	s.read(func(d *memData) {
+		for id, command := range d.aliases {
+			if id.Profile == profile && in[id.Path] {
+				aliases = append(aliases, EnvAlias{Path: id.Path, Profile: id.Profile, Name: id.Key, Command: command})
+			}
+		}
+	})
+	sort.Slice(aliases, func(i, j int) bool {
+		if aliases[i].Path != aliases[j].Path {
+			return aliases[i].Path < aliases[j].Path
+		}
+		return aliases[i].Name < aliases[j].Name
+	})
+	return aliases, nil
+}
+
+// SetTask defines a task at the given path/profile.
+func (s *memStore) SetTask(path, profile, name, command string) error {
+	return s.update(func(d *memData) error {
+		d.tasks[varID{path, profile, name}] = command
+		return nil
+	})
+}
+
+// DeleteTask removes a task at the given path/profile.
+func (s *memStore) DeleteTask(path, profile, name string) error {
+	return s.update(func(d *memData) error {
+		delete(d.tasks, varID{path, profile, name})
+		return nil
+	})
+}
+
+// GetTasksForPaths retrieves tasks for the given paths and profile.
+func (s *memStore) GetTasksForPaths(paths []string, profile string) ([]EnvTask, error) {
+	in := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		in[p] = true
+	}
+	var tasks []EnvTask
+	s.read(func(d *memData) {
+		for id, command := range d.tasks {
+			if id.Profile == profile && in[id.Path] {
+				tasks = append(tasks, EnvTask{Path: id.Path, Profile: id.Profile, Name: id.Key, Command: command})
+			}
+		}
+	})
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Path != tasks[j].Path {
+			return tasks[i].Path < tasks[j].Path
+		}
+		return tasks[i].Name < tasks[j].Name
+	})
+	return tasks, nil
+}
+
+// AddTag attaches a tag to a variable. Tags on a missing variable are
+// ignored, since tags are stored with their variable.
+func (s *memStore) AddTag(path, profile, key, tag string) error {
+	return s.update(func(d *memData) error {
+		id := varID{path, profile, key}
+		if _, ok := d.vars[id]; !ok {
+			return nil
+		}
+		if d.tags[id] == nil {
+			d.tags[id] = make(map[string]bool)
+		}
+		d.tags[id][tag] = true
+		return nil
+	})
+}
+
+// RemoveTag detaches a tag from a variable.
+func (s *memStore) RemoveTag(path, profile, key, tag string) error {
+	return s.update(func(d *memData) error {
+		id := varID{path, profile, key}
+		delete(d.tags[id], tag)
+		if len(d.tags[id]) == 0 {
+			delete(d.tags, id)
+		}
+		return nil
+	})
+}
+
+// GetTagsForPaths retrieves tags on variables at the given paths and profile.
+func (s *memStore) GetTagsForPaths(paths []string, profile string) ([]EnvTag, error) {
+	in := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		in[p] = true
+	}
+	var tags []EnvTag
+	s.read(func(d *memData) {
+		for id, set := range d.tags {
+			if id.Profile != profile || !in[id.Path] {
+				continue
+			}
+			for t := range set {
+				tags = append(tags, EnvTag{Path: id.Path, Profile: id.Profile, Key: id.Key, Tag: t})
+			}
+		}
+	})
+	sort.Slice(tags, func(i, j int) bool {
+		a, b := tags[i], tags[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Tag < b.Tag
+	})
+	return tags, nil
+}
+
+// SetScopePolicy stores the encoded naming policy for a scope.
+func (s *memStore) SetScopePolicy(path, policy string) error {
+	return s.update(func(d *memData) error {
+		d.ensureScope(path, s.author)
+		if policy == "" {
+			delete(d.policies, path)
+		} else {
+			d.policies[path] = policy
+		}
+		return nil
+	})
+}
+
+// GetScopePolicies returns the non-empty encoded policies for the given paths.
+func (s *memStore) GetScopePolicies(paths []string) (map[string]string, error) {
+	policies := make(map[string]string)
+	s.read(func(d *memData) {
+		for _, p := range paths {
+			if policy, ok := d.policies[p]; ok {
+				policies[p] = policy
+			}
+		}
+	})
+	return policies, nil
+}
+
+// GetScopeOwners returns who created each of the given scopes, where known.
+func (s *memStore) GetScopeOwners(paths []string) (map[string]string, error) {
+	owners := make(map[string]string)
+	s.read(func(d *memData) {
+		for _, p := range paths {
+			if sc, ok := d.scopes[p]; ok && sc.Owner != "" {
+				owners[p] = sc.Owner
+			}
+		}
+	})
+	return owners, nil
+}
+
+// ListScopes returns every path and profile with vars, clears, aliases or
+// tasks, sorted by path and profile.
+func (s *memStore) ListScopes() ([]ScopeRef, error) {
+	seen := make(map[ScopeRef]bool)
+	s.read(func(d *memData) {
+		for id := range d.vars {
+			seen[ScopeRef{id.Path, id.Profile}] = true
+		}
+		for id := range d.clears {
+			seen[ScopeRef{id.Path, id.Profile}] = true
+		}
+		for _, m := range []map[varID]string{d.aliases, d.tasks} {
+			for id := range m {
+				seen[ScopeRef{id.Path, id.Profile}] = true
+			}
+		}
+	})
+	scopes := make([]ScopeRef, 0, len(seen))
+	for sc := range seen {
+		scopes = append(scopes, sc)
+	}
+	sort.Slice(scopes, func(i, j int) bool {
+		if scopes[i].Path != scopes[j].Path {
+			return scopes[i].Path < scopes[j].Path
+		}
+		return scopes[i].Profile < scopes[j].Profile
+	})
+	return scopes, nil
+}
+
+// DropScopes deletes everything stored at paths, in every profile, with
+// their scope records. Vars deleted this way skip the trash.
+func (s *memStore) DropScopes(paths []string) error {
+	in := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		in[p] = true
+	}
+	return s.update(func(d *memData) error {
+		for id := range d.vars {
+			if in[id.Path] {
+				d.deleteVar(id)
+			}
+		}
+		for _, m := range []map[varID]string{d.aliases, d.tasks} {
+			for id := range m {
+				if in[id.Path] {
+					delete(m, id)
+				}
+			}
+		}
+		for id := range d.clears {
+			if in[id.Path] {
+				delete(d.clears, id)
+			}
+		}
+		for p := range in {
+			delete(d.scopes, p)
+			delete(d.policies, p)
+		}
+		return nil
+	})
+}
+
+// ListTrash returns the trashed vars for profile, or for every profile if
+// it is empty, most recently deleted first.
+func (s *memStore) ListTrash(profile string) ([]TrashedVar, error) {
+	var list []TrashedVar
+	s.read(func(d *memData) {
+		for i := len(d.trash) - 1; i >= 0; i-- {
+			if profile == "" || d.trash[i].Profile == profile {
+				list = append(list, d.trash[i])
+			}
+		}
+	})
+	return list, nil
+}
+
+// RestoreTrash puts trashed var id back, with the same semantics as the
+// SQLite store.
+func (s *memStore) RestoreTrash(id int64, overwrite bool) (*TrashedVar, error) {
+	var restored *TrashedVar
+	err := s.update(func(d *memData) error {
+		i := slices.IndexFunc(d.trash, func(t TrashedVar) bool { return t.ID == id })
+		if i < 0 {
+			return ErrVarNotFound
+		}
+		t := d.trash[i]
+		vid := varID{t.Path, t.Profile, t.Key}
+		if _, exists := d.vars[vid]; exists {
+			if !overwrite {
+				return ErrVarExists
+			}
+			d.trashVar(vid, s.author)
+		}
+
+		d.ensureScope(t.Path, s.author)
+		v := t.EnvVar
+		v.UpdatedAt = time.Now().UTC()
+		if v.CreatedAt.IsZero() {
+			v.CreatedAt = v.UpdatedAt
+		}
+		d.vars[vid] = v
+		if len(t.Tags) > 0 {
+			d.tags[vid] = make(map[string]bool, len(t.Tags))
+			for _, tag := range t.Tags {
+				d.tags[vid][tag] = true
+			}
+		}
+		d.trash = slices.Delete(d.trash, i, i+1)
+		restored = &t
+		return nil
+	})
+	return restored, err
+}
+
+// PurgeTrash permanently deletes the vars trashed at or before cutoff and
+// returns how many there were.
+func (s *memStore) PurgeTrash(cutoff time.Time) (int, error) {
+	n := 0
+	err := s.update(func(d *memData) error {
+		kept := d.trash[:0]
+		for _, t := range d.trash {
+			if t.DeletedAt.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		n = len(d.trash) - len(kept)
+		d.trash = kept
+		return nil
+	})
+	return n, err
+}
+
+func sortVars(vars []EnvVar) {
+	sort.Slice(vars, func(i, j int) bool {
+		if vars[i].Path != vars[j].Path {
+			return vars[i].Path < vars[j].Path
+		}
+		return vars[i].Key < vars[j].Key
+	})
+}
+
+// jsonFile is the on-disk layout of the JSON backend.
+type jsonFile struct {
+	Version int         `json:"version"`
+	Scopes  []jsonScope `json:"scopes"`
+	Vars    []jsonVar   `json:"vars"`
+	Clears  []jsonClear `json:"clears,omitempty"`
+	Aliases []jsonAlias `json:"aliases,omitempty"`
+	Tasks   []jsonAlias `json:"tasks,omitempty"`
+	Trash   []jsonTrash `json:"trash,omitempty"`
+}
+
+type jsonScope struct {
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+	Owner     string    `json:"owner,omitempty"`
+	Policy    string    `json:"policy,omitempty"`
+}
+
+type jsonVar struct {
+	Path        string    `json:"path"`
+	Profile     string    `json:"profile"`
+	Key         string    `json:"key"`
+	Value       string    `json:"value"`
+	Description string    `json:"description,omitempty"`
+	IfUnset     bool      `json:"if_unset,omitempty"`
+	Eval        bool      `json:"eval,omitempty"`
+	Refresh     int       `json:"refresh,omitempty"`
+	NoExport    bool      `json:"no_export,omitempty"`
+	Fallback    string    `json:"fallback,omitempty"`
+	Type        string    `json:"type,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type jsonTrash struct {
+	ID int64 `json:"id"`
+	jsonVar
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+}
+
+type jsonClear struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+	Key     string `json:"key"`
+}
+
+type jsonAlias struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+	Name    string `json:"name"`
+	Command string `json:"command"`
+}
+
+// jsonFileVersion is bumped when the JSON layout changes incompatibly.
+const jsonFileVersion = 1
+
+func (d *memData) decode(raw []byte) error {
+	var f jsonFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return err
+	}
+	if f.Version > jsonFileVersion {
+		return errors.New("json store was written by a newer enva")
+	}
+
+	for _, sc := range f.Scopes {
+		d.scopes[sc.Path] = memScope{CreatedAt: sc.CreatedAt, Owner: sc.Owner}
+		if sc.Policy != "" {
+			d.policies[sc.Path] = sc.Policy
+		}
+	}
+	for _, v := range f.Vars {
+		id := varID{v.Path, v.Profile, v.Key}
+		if v.CreatedAt.IsZero() {
+			v.CreatedAt = v.UpdatedAt // Written before creation times were recorded
+		}
+		d.vars[id] = EnvVar{
+			Path: v.Path, Profile: v.Profile, Key: v.Key,
+			Value: v.Value, Description: v.Description,
+			UpdatedAt: v.UpdatedAt, CreatedAt: v.CreatedAt, IfUnset: v.IfUnset, Eval: v.Eval, Refresh: v.Refresh, NoExport: v.NoExport, Fallback: v.Fallback, Type: v.Type, Author: v.Author,
+		}
+		for _, t := range v.Tags {
+			if d.tags[id] == nil {
+				d.tags[id] = make(map[string]bool)
+			}
+			d.tags[id][t] = true
+		}
+	}
+	for _, c := range f.Clears {
+		d.clears[varID{c.Path, c.Profile, c.Key}] = true
+	}
+	for _, a := range f.Aliases {
+		d.aliases[varID{a.Path, a.Profile, a.Name}] = a.Command
+	}
+	for _, t := range f.Tasks {
+		d.tasks[varID{t.Path, t.Profile, t.Name}] = t.Command
+	}
+	for _, t := range f.Trash {
+		d.trash = append(d.trash, TrashedVar{
+			EnvVar: EnvVar{
+				Path: t.Path, Profile: t.Profile, Key: t.Key,
+				Value: t.Value, Description: t.Description,
+				UpdatedAt: t.UpdatedAt, CreatedAt: t.CreatedAt, IfUnset: t.IfUnset, Eval: t.Eval, Refresh: t.Refresh, NoExport: t.NoExport, Fallback: t.Fallback, Type: t.Type, Author: t.Author,
+			},
+			ID: t.ID, Tags: t.Tags, DeletedAt: t.DeletedAt, DeletedBy: t.DeletedBy,
+		})
+		d.trashSeq = max(d.trashSeq, t.ID)
+	}
+	return nil
+}
+
+// save writes the data to path atomically, sorted so diffs stay readable.
+// Tags live on their variable, so tags without one are dropped.
+func (d *memData) save(path string) error {
+	f := jsonFile{Version: jsonFileVersion, Scopes: []jsonScope{}, Vars: []jsonVar{}}
+
+	for p, sc := range d.scopes {
+		f.Scopes = append(f.Scopes, jsonScope{Path: p, CreatedAt: sc.CreatedAt, Owner: sc.Owner, Policy: d.policies[p]})
+	}
+	sort.Slice(f.Scopes, func(i, j int) bool { return f.Scopes[i].Path < f.Scopes[j].Path })
+
+	for id, v := range d.vars {
+		jv := jsonVar{
+			Path: v.Path, Profile: v.Profile, Key: v.Key,
+			Value: v.Value, Description: v.Description,
+			IfUnset: v.IfUnset, Eval: v.Eval, Refresh: v.Refresh, NoExport: v.NoExport, Fallback: v.Fallback, Type: v.Type, Author: v.Author, UpdatedAt: v.UpdatedAt, CreatedAt: v.CreatedAt,
+		}
+		for t := range d.tags[id] {
+			jv.Tags = append(jv.Tags, t)
+		}
+		sort.Strings(jv.Tags)
+		f.Vars = append(f.Vars, jv)
+	}
+	sort.Slice(f.Vars, func(i, j int) bool {
+		a, b := f.Vars[i], f.Vars[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+		return a.Key < b.Key
+	})
+
+	for id := range d.clears {
+		f.Clears = append(f.Clears, jsonClear{Path: id.Path, Profile: id.Profile, Key: id.Key})
+	}
+	sort.Slice(f.Clears, func(i, j int) bool {
+		a, b := f.Clears[i], f.Clears[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+		return a.Key < b.Key
+	})
+
+	for id, command := range d.aliases {
+		f.Aliases = append(f.Aliases, jsonAlias{Path: id.Path, Profile: id.Profile, Name: id.Key, Command: command})
+	}
+	sort.Slice(f.Aliases, func(i, j int) bool {
+		a, b := f.Aliases[i], f.Aliases[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+		return a.Name < b.Name
+	})
+
+	// Tasks have the same shape as aliases
+	for id, command := range d.tasks {
+		f.Tasks = append(f.Tasks, jsonAlias{Path: id.Path, Profile: id.Profile, Name: id.Key, Command: command})
+	}
+	sort.Slice(f.Tasks, func(i, j int) bool {
+		a, b := f.Tasks[i], f.Tasks[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+		return a.Name < b.Name
+	})
+
+	for _, t := range d.trash {
+		f.Trash = append(f.Trash, jsonTrash{
+			ID: t.ID,
+			jsonVar: jsonVar{
+				Path: t.Path, Profile: t.Profile, Key: t.Key,
+				Value: t.Value, Description: t.Description,
+				IfUnset: t.IfUnset, Eval: t.Eval, Refresh: t.Refresh, NoExport: t.NoExport, Fallback: t.Fallback, Type: t.Type, Tags: t.Tags, Author: t.Author, UpdatedAt: t.UpdatedAt, CreatedAt: t.CreatedAt,
+			},
+			DeletedAt: t.DeletedAt, DeletedBy: t.DeletedBy,
+		})
+	}
+
+	raw, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".enva-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
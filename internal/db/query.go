@@ -0,0 +1,71 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// QueryResult holds the rows returned by Query. Values are int64, float64,
+// string or nil.
+type QueryResult struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// SQLitePath returns the SQLite file at location, or "" when location is
+// another kind of store.
+func SQLitePath(location string) string {
+	if strings.HasPrefix(location, schemeJSON) || strings.HasPrefix(location, schemeMemory) {
+		return ""
+	}
+	return strings.TrimPrefix(location, schemeSQLite)
+}
+
+// Query runs one SQL statement against the SQLite database at dbPath over
+// a read-only connection, so statements that would write fail instead.
+func Query(dbPath, query string) (*QueryResult, error) {
+	dsn := (&url.URL{Scheme: "file", Path: dbPath, RawQuery: "mode=ro&_pragma=busy_timeout(5000)&_pragma=query_only(1)"}).String()
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	res := &QueryResult{Columns: cols}
+	for rows.Next() {
+		values := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			switch v := v.(type) {
+			case []byte:
+				values[i] = string(v)
+			case time.Time:
+				values[i] = v.UTC().Format(time.RFC3339)
+			case int64, float64, string, nil:
+			default:
+				values[i] = fmt.Sprint(v)
+			}
+		}
+		res.Rows = append(res.Rows, values)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ResolveCache is implemented by stores that keep merged resolve results,
+// so a deep directory can be answered without re-merging its whole chain.
+// Entries are tied to a generation that every write advances.
+type ResolveCache interface {
+	// GetResolved returns the entry for path and profile if it was stored
+	// for root at the current generation, and the current generation.
+	GetResolved(path, profile, root string) (data []byte, generation int64, err error)
+	// PutResolved stores an entry computed at generation. An entry from a
+	// generation that has since passed is discarded on the next lookup.
+	PutResolved(path, profile, root string, generation int64, data []byte) error
+}
+
+// generationTables are the tables whose contents feed a resolve.
+var generationTables = []string{"env_vars", "env_clears", "env_tags", "env_aliases", "env_tasks"}
+
+// generationTriggers returns the triggers that advance the generation on
+// any write to a table that affects resolution.
+func generationTriggers() string {
+	var b strings.Builder
+	for _, table := range generationTables {
+		for _, op := range []string{"INSERT", "UPDATE", "DELETE"} {
+			fmt.Fprintf(&b, `
+	CREATE TRIGGER IF NOT EXISTS %s_generation_%s AFTER %s ON %s
+	BEGIN
+		UPDATE env_meta SET value = value + 1 WHERE key = 'generation';
+	END;
+`, table, strings.ToLower(op), op, table)
+		}
+	}
+	return b.String()
+}
+
+// GetResolved returns the cached resolve result for path and profile.
+func (db *DB) GetResolved(path, profile, root string) ([]byte, int64, error) {
+	var (
+		current int64
+		gotRoot sql.NullString
+		gen     sql.NullInt64
+		data    []byte
+	)
+	if err := db.resolved.QueryRow(path, profile).Scan(&current, &gotRoot, &gen, &data); err != nil {
+		return nil, 0, err
+	}
+	if !gen.Valid || gen.Int64 != current || gotRoot.String != root {
+		return nil, current, nil
+	}
+	return data, current, nil
+}
+
+// PutResolved stores a resolve result, dropping entries left behind by
+// earlier generations. A result computed before a concurrent write is
+// already stale and isn't stored.
+func (db *DB) PutResolved(path, profile, root string, generation int64, data []byte) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current int64
+	if err := tx.QueryRow(`SELECT value FROM env_meta WHERE key = 'generation'`).Scan(&current); err != nil {
+		return err
+	}
+	if current != generation {
+		return nil
+	}
+	if _, err := tx.Exec(`DELETE FROM env_resolved WHERE generation <> ?`, generation); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO env_resolved (path, profile, root, generation, data) VALUES (?, ?, ?, ?, ?)`,
+		path, profile, root, generation, data); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// Store is the storage backend enva reads and writes variables through.
+// *DB (SQLite) is the default; Open picks a backend from the location.
+type Store interface {
+	// Path returns the location the store was opened from.
+	Path() string
+	Close() error
+	// SetAuthor sets who is recorded on subsequent writes (user@host).
+	SetAuthor(author string)
+
+	GetVarsForPaths(paths []string, profile string) ([]EnvVar, error)
+	// PathsWithScopes returns the paths, in order, that have vars, clears,
+	// aliases or tasks for profile.
+	PathsWithScopes(paths []string, profile string) ([]string, error)
+	GetVarsForPath(path, profile string) ([]EnvVar, error)
+	GetVar(path, profile, key string) (*EnvVar, error)
+	GetAllVars(profile string) ([]EnvVar, error)
+	GetScopesWithVars(profile string) ([]ScopeSummary, error)
+	CountVars() (int, error)
+	ListProfiles() ([]string, error)
+	SetVar(path, profile, key, value, description string) error
+	SetIfUnset(path, profile, key string, ifUnset bool) error
+	SetEval(path, profile, key string, eval bool) error
+	SetRefresh(path, profile, key string, seconds int) error
+	SetNoExport(path, profile, key string, noExport bool) error
+	SetFallback(path, profile, key, fallback string) error
+	SetType(path, profile, key, valueType string) error
+	DeleteVar(path, profile, key string) error
+	DeleteVarsForPath(path, profile string) error
+	MoveVar(srcPath, srcProfile, dstPath, dstProfile, key string, copy, overwrite bool) error
+
+	SetVarsBatch(path, profile string, vars map[string]VarData) error
+	DeleteVarsBatch(path, profile string, keys []string) error
+	ApplyChanges(changes []ScopeChange) error
+
+	AddClear(path, profile, key string) error
+	RemoveClear(path, profile, key string) error
+	GetClearsForPaths(paths []string, profile string) ([]EnvClear, error)
+
+	AddTag(path, profile, key, tag string) error
+	RemoveTag(path, profile, key, tag string) error
+	GetTagsForPaths(paths []string, profile string) ([]EnvTag, error)
+
+	SetAlias(path, profile, name, command string) error
+	DeleteAlias(path, profile, name string) error
+	GetAliasesForPaths(paths []string, profile string) ([]EnvAlias, error)
+
+	SetTask(path, profile, name, command string) error
+	DeleteTask(path, profile, name string) error
+	GetTasksForPaths(paths []string, profile string) ([]EnvTask, error)
+
+	// Deleting a var moves it to the trash, where it stays until purged.
+	ListTrash(profile string) ([]TrashedVar, error)
+	RestoreTrash(id int64, overwrite bool) (*TrashedVar, error)
+	PurgeTrash(cutoff time.Time) (int, error)
+
+	SetScopePolicy(path, policy string) error
+	GetScopePolicies(paths []string) (map[string]string, error)
+	GetScopeOwners(paths []string) (map[string]string, error)
+
+	// ListScopes returns every path and profile with vars, clears, aliases
+	// or tasks, across all profiles.
+	ListScopes() ([]ScopeRef, error)
+	// DropScopes deletes everything stored at paths, in every profile, with
+	// their scope records. Vars deleted this way skip the trash.
+	DropScopes(paths []string) error
+}
+
+// Location schemes understood by Open. A location without a scheme is a
+// SQLite database file.
+const (
+	schemeSQLite = "sqlite:"
+	schemeJSON   = "json:"
+	schemeMemory = "memory:"
+)
+
+// Open opens the store at location:
+//
+//	/path/enva.db or sqlite:/path/enva.db   SQLite database (default)
+//	json:/path/enva.json                    single JSON file
+//	memory:                                 in-memory, discarded on Close
+func Open(location string) (Store, error) {
+	switch {
+	case strings.HasPrefix(location, schemeJSON):
+		return openJSON(strings.TrimPrefix(location, schemeJSON))
+	case strings.HasPrefix(location, schemeMemory):
+		return newMemStore(location), nil
+	}
+	return OpenSQLite(strings.TrimPrefix(location, schemeSQLite))
+}
+
+// FilePath returns the file backing the store at location, or "" for an
+// in-memory store.
+func FilePath(location string) string {
+	switch {
+	case strings.HasPrefix(location, schemeJSON):
+		return strings.TrimPrefix(location, schemeJSON)
+	case strings.HasPrefix(location, schemeMemory):
+		return ""
+	}
+	return strings.TrimPrefix(location, schemeSQLite)
+}
@@ -0,0 +1,521 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openStores returns one store per backend, all empty.
+func openStores(t *testing.T) map[string]Store {
+	t.Helper()
+	dir := t.TempDir()
+
+	stores := map[string]Store{}
+	for name, location := range map[string]string{
+		"sqlite": filepath.Join(dir, "test.db"),
+		"json":   "json:" + filepath.Join(dir, "test.json"),
+		"memory": "memory:",
+	} {
+		s, err := Open(location)
+		if err != nil {
+			t.Fatalf("Open(%q) failed: %v", location, err)
+		}
+		t.Cleanup(func() { s.Close() })
+		stores[name] = s
+	}
+	return stores
+}
+
+func TestOpenSelectsBackend(t *testing.T) {
+	stores := openStores(t)
+	if _, ok := stores["sqlite"].(*DB); !ok {
+		t.Errorf("plain path opened %T, want *DB", stores["sqlite"])
+	}
+	if _, ok := stores["json"].(*memStore); !ok {
+		t.Errorf("json: opened %T, want *memStore", stores["json"])
+	}
+
+	if got := FilePath("json:/x/enva.json"); got != "/x/enva.json" {
+		t.Errorf("FilePath(json) = %q", got)
+	}
+	if got := FilePath("sqlite:/x/enva.db"); got != "/x/enva.db" {
+		t.Errorf("FilePath(sqlite) = %q", got)
+	}
+	if got := FilePath("memory:"); got != "" {
+		t.Errorf("FilePath(memory) = %q, want empty", got)
+	}
+}
+
+// TestStoreConformance runs the same operations against every backend.
+func TestStoreConformance(t *testing.T) {
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.SetVar("/a", "default", "B", "2", ""); err != nil {
+				t.Fatalf("SetVar failed: %v", err)
+			}
+			if err := s.SetVarsBatch("/a", "default", map[string]VarData{"A": {Value: "1", Description: "first"}}); err != nil {
+				t.Fatalf("SetVarsBatch failed: %v", err)
+			}
+			s.SetVar("/a/b", "default", "C", "3", "")
+			s.SetVar("/a", "other", "A", "x", "")
+
+			vars, _ := s.GetVarsForPaths([]string{"/a", "/a/b"}, "default")
+			if len(vars) != 3 || vars[0].Key != "A" || vars[1].Key != "B" || vars[2].Path != "/a/b" {
+				t.Errorf("GetVarsForPaths = %+v", vars)
+			}
+			if vars[0].Description != "first" {
+				t.Errorf("description = %q", vars[0].Description)
+			}
+
+			// Upserts keep the if-unset, eval and no-export flags and fallback
+			s.SetIfUnset("/a", "default", "B", true)
+			s.SetEval("/a", "default", "B", true)
+			s.SetNoExport("/a", "default", "B", true)
+			s.SetFallback("/a", "default", "B", "cache:1h")
+			s.SetType("/a", "default", "B", "int")
+			s.SetVar("/a", "default", "B", "22", "")
+			if v, _ := s.GetVar("/a", "default", "B"); v == nil || v.Value != "22" || !v.IfUnset || !v.Eval || !v.NoExport || v.Fallback != "cache:1h" || v.Type != "int" {
+				t.Errorf("GetVar B = %+v", v)
+			}
+			if v, _ := s.GetVar("/a", "default", "MISSING"); v != nil {
+				t.Errorf("GetVar missing = %+v, want nil", v)
+			}
+			if n, _ := s.CountVars(); n != 4 {
+				t.Errorf("CountVars = %d, want 4", n)
+			}
+			if p, _ := s.ListProfiles(); len(p) != 2 || p[0] != "default" || p[1] != "other" {
+				t.Errorf("ListProfiles = %v", p)
+			}
+
+			// Tags go with their var
+			s.AddTag("/a", "default", "A", "aws")
+			s.AddTag("/a", "default", "A", "ci")
+			s.RemoveTag("/a", "default", "A", "ci")
+			tags, _ := s.GetTagsForPaths([]string{"/a"}, "default")
+			if len(tags) != 1 || tags[0].Tag != "aws" {
+				t.Errorf("GetTagsForPaths = %+v", tags)
+			}
+
+			if err := s.MoveVar("/a", "default", "/a/b", "default", "A", false, false); err != nil {
+				t.Fatalf("MoveVar failed: %v", err)
+			}
+			if tags, _ := s.GetTagsForPaths([]string{"/a/b"}, "default"); len(tags) != 1 {
+				t.Errorf("tags after move = %+v", tags)
+			}
+			if err := s.MoveVar("/a", "default", "/a/b", "default", "A", false, false); !errors.Is(err, ErrVarNotFound) {
+				t.Errorf("MoveVar missing = %v, want ErrVarNotFound", err)
+			}
+			s.SetVar("/a", "default", "C", "dup", "")
+			if err := s.MoveVar("/a", "default", "/a/b", "default", "C", false, false); !errors.Is(err, ErrVarExists) {
+				t.Errorf("MoveVar onto existing = %v, want ErrVarExists", err)
+			}
+
+			s.DeleteVar("/a/b", "default", "A")
+			if tags, _ := s.GetTagsForPaths([]string{"/a/b"}, "default"); len(tags) != 0 {
+				t.Errorf("tags after delete = %+v", tags)
+			}
+
+			err := s.ApplyChanges([]ScopeChange{
+				{Path: "/c", Profile: "default", Set: map[string]VarData{"X": {Value: "1"}}},
+				{Path: "/a", Profile: "default", Delete: []string{"B", "C"}},
+			})
+			if err != nil {
+				t.Fatalf("ApplyChanges failed: %v", err)
+			}
+			if vars, _ := s.GetVarsForPath("/a", "default"); len(vars) != 0 {
+				t.Errorf("vars at /a after apply = %+v", vars)
+			}
+			if all, _ := s.GetAllVars("default"); len(all) != 2 {
+				t.Errorf("GetAllVars = %+v", all)
+			}
+
+			s.SetEval("/c", "default", "X", true)
+			err = s.ApplyChanges([]ScopeChange{
+				{Path: "/d", Profile: "default", Set: map[string]VarData{"X": {Value: "2"}}},
+				{Path: "/c", Profile: "default", Move: map[string]string{"X": "/d"}},
+			})
+			if err != nil {
+				t.Fatalf("ApplyChanges with a move failed: %v", err)
+			}
+			if v, _ := s.GetVar("/d", "default", "X"); v == nil || v.Value != "2" || !v.Eval {
+				t.Errorf("moved var = %+v", v)
+			}
+			err = s.ApplyChanges([]ScopeChange{
+				{Path: "/e", Profile: "default", Set: map[string]VarData{"Y": {Value: "1"}}},
+				{Path: "/c", Profile: "default", Move: map[string]string{"MISSING": "/d"}},
+			})
+			if !errors.Is(err, ErrVarNotFound) {
+				t.Errorf("ApplyChanges moving a missing var = %v, want ErrVarNotFound", err)
+			}
+			if v, _ := s.GetVar("/e", "default", "Y"); v != nil {
+				t.Errorf("failed ApplyChanges still set Y: %+v", v)
+			}
+
+			s.AddClear("/a", "default", "PATHX")
+			s.AddClear("/a", "default", "PATHX")
+			if clears, _ := s.GetClearsForPaths([]string{"/a"}, "default"); len(clears) != 1 {
+				t.Errorf("GetClearsForPaths = %+v", clears)
+			}
+			s.RemoveClear("/a", "default", "PATHX")
+			if clears, _ := s.GetClearsForPaths([]string{"/a"}, "default"); len(clears) != 0 {
+				t.Errorf("clears after remove = %+v", clears)
+			}
+
+			s.SetScopePolicy("/a", `{"prefix":"APP_"}`)
+			s.SetScopePolicy("/c", "")
+			if p, _ := s.GetScopePolicies([]string{"/a", "/c"}); len(p) != 1 || p["/a"] == "" {
+				t.Errorf("GetScopePolicies = %v", p)
+			}
+
+			s.DeleteVarsBatch("/d", "default", []string{"X"})
+			s.DeleteVarsForPath("/a/b", "default")
+			if all, _ := s.GetAllVars("default"); len(all) != 0 {
+				t.Errorf("GetAllVars after deletes = %+v", all)
+			}
+		})
+	}
+}
+
+func TestStoreAliases(t *testing.T) {
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s.SetAlias("/a", "default", "dc", "docker compose")
+			s.SetAlias("/a", "default", "dc", "docker compose -f dev.yml")
+			s.SetAlias("/a/b", "default", "g", "git")
+			s.SetAlias("/a", "staging", "dc", "other")
+
+			got, err := s.GetAliasesForPaths([]string{"/a", "/a/b"}, "default")
+			if err != nil {
+				t.Fatalf("GetAliasesForPaths failed: %v", err)
+			}
+			if len(got) != 2 || got[0].Command != "docker compose -f dev.yml" || got[1].Name != "g" || got[1].Path != "/a/b" {
+				t.Errorf("GetAliasesForPaths = %+v", got)
+			}
+
+			s.DeleteAlias("/a", "default", "dc")
+			if got, _ := s.GetAliasesForPaths([]string{"/a"}, "default"); len(got) != 0 {
+				t.Errorf("aliases after delete = %+v", got)
+			}
+		})
+	}
+}
+
+func TestStoreTasks(t *testing.T) {
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s.SetTask("/a", "default", "build", "go build ./...")
+			s.SetTask("/a", "default", "build", "make")
+			s.SetTask("/a/b", "default", "test:unit", "go test ./...")
+
+			got, err := s.GetTasksForPaths([]string{"/a", "/a/b"}, "default")
+			if err != nil {
+				t.Fatalf("GetTasksForPaths failed: %v", err)
+			}
+			if len(got) != 2 || got[0].Command != "make" || got[1].Name != "test:unit" || got[1].Path != "/a/b" {
+				t.Errorf("GetTasksForPaths = %+v", got)
+			}
+			if scoped, _ := s.PathsWithScopes([]string{"/", "/a", "/a/b"}, "default"); len(scoped) != 2 {
+				t.Errorf("PathsWithScopes = %v, want the scopes with tasks", scoped)
+			}
+
+			s.DeleteTask("/a", "default", "build")
+			if got, _ := s.GetTasksForPaths([]string{"/a"}, "default"); len(got) != 0 {
+				t.Errorf("tasks after delete = %+v", got)
+			}
+		})
+	}
+}
+
+func TestStoreScopesWithVars(t *testing.T) {
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s.SetAuthor("alice@laptop")
+			s.SetVar("/b", "default", "K1", "v", "")
+			s.SetVar("/b", "default", "K2", "v", "")
+			s.SetVar("/a", "default", "K", "v", "")
+			s.SetVar("/c", "staging", "K", "v", "")
+
+			got, err := s.GetScopesWithVars("default")
+			if err != nil {
+				t.Fatalf("GetScopesWithVars failed: %v", err)
+			}
+			if len(got) != 2 || got[0].Path != "/a" || got[0].Vars != 1 || got[1].Path != "/b" || got[1].Vars != 2 {
+				t.Fatalf("GetScopesWithVars = %+v", got)
+			}
+			if got[1].Owner != "alice@laptop" || got[1].UpdatedAt.IsZero() {
+				t.Errorf("summary for /b = %+v", got[1])
+			}
+		})
+	}
+}
+
+func TestStorePathsWithScopes(t *testing.T) {
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s.SetVar("/a", "default", "K", "v", "")
+			s.AddClear("/a/b/c", "default", "K")
+			s.SetAlias("/a/b/c/d", "default", "g", "git")
+			s.SetVar("/a/b", "staging", "K", "v", "")
+
+			got, err := s.PathsWithScopes([]string{"/", "/a", "/a/b", "/a/b/c", "/a/b/c/d", "/a/b/c/d/e"}, "default")
+			if err != nil {
+				t.Fatalf("PathsWithScopes failed: %v", err)
+			}
+			if len(got) != 3 || got[0] != "/a" || got[1] != "/a/b/c" || got[2] != "/a/b/c/d" {
+				t.Errorf("PathsWithScopes = %v, want [/a /a/b/c /a/b/c/d]", got)
+			}
+		})
+	}
+}
+
+func TestStoreListAndDropScopes(t *testing.T) {
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s.SetVar("/a", "default", "K", "v", "")
+			s.AddTag("/a", "default", "K", "x")
+			s.AddClear("/a/b", "staging", "K")
+			s.SetTask("/a/b", "default", "t", "make")
+			s.SetScopePolicy("/a", `{"prefix":"APP_"}`)
+			s.SetVar("/c", "default", "K", "v", "")
+
+			got, err := s.ListScopes()
+			if err != nil {
+				t.Fatalf("ListScopes failed: %v", err)
+			}
+			want := []ScopeRef{{"/a", "default"}, {"/a/b", "default"}, {"/a/b", "staging"}, {"/c", "default"}}
+			if len(got) != len(want) {
+				t.Fatalf("ListScopes = %v, want %v", got, want)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("ListScopes = %v, want %v", got, want)
+					break
+				}
+			}
+
+			if err := s.DropScopes([]string{"/a", "/a/b"}); err != nil {
+				t.Fatalf("DropScopes failed: %v", err)
+			}
+			if got, _ := s.ListScopes(); len(got) != 1 || got[0].Path != "/c" {
+				t.Errorf("after DropScopes, ListScopes = %v", got)
+			}
+			if tags, _ := s.GetTagsForPaths([]string{"/a"}, "default"); len(tags) != 0 {
+				t.Errorf("tags left behind: %v", tags)
+			}
+			if policies, _ := s.GetScopePolicies([]string{"/a"}); len(policies) != 0 {
+				t.Errorf("policy left behind: %v", policies)
+			}
+			if trash, _ := s.ListTrash(""); len(trash) != 0 {
+				t.Errorf("DropScopes trashed %d vars, want none", len(trash))
+			}
+		})
+	}
+}
+
+// TestStoreAuthorship checks who is recorded on writes, for every backend.
+func TestStoreAuthorship(t *testing.T) {
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s.SetAuthor("alice@laptop")
+			s.SetVar("/a", "default", "A", "1", "")
+			s.SetAuthor("bob@desk")
+			s.SetVarsBatch("/a", "default", map[string]VarData{
+				"B": {Value: "2"},
+				"C": {Value: "3", Author: "carol@ci"},
+			})
+			s.MoveVar("/a", "default", "/b", "default", "A", true, false)
+
+			want := map[string]string{"A": "alice@laptop", "B": "bob@desk", "C": "carol@ci"}
+			vars, _ := s.GetVarsForPath("/a", "default")
+			for _, v := range vars {
+				if v.Author != want[v.Key] {
+					t.Errorf("%s author = %q, want %q", v.Key, v.Author, want[v.Key])
+				}
+			}
+			if v, _ := s.GetVar("/b", "default", "A"); v == nil || v.Author != "alice@laptop" {
+				t.Errorf("moved var = %+v, want author kept", v)
+			}
+
+			owners, err := s.GetScopeOwners([]string{"/a", "/b", "/missing"})
+			if err != nil {
+				t.Fatalf("GetScopeOwners failed: %v", err)
+			}
+			if owners["/a"] != "alice@laptop" || owners["/b"] != "bob@desk" || len(owners) != 2 {
+				t.Errorf("GetScopeOwners = %v", owners)
+			}
+		})
+	}
+}
+
+// TestStoreCreatedAt checks a var keeps its creation time through updates,
+// moves and the trash, for every backend.
+func TestStoreCreatedAt(t *testing.T) {
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s.SetVar("/a", "default", "A", "1", "")
+			created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			if sq, ok := s.(*DB); ok {
+				// SQLite times have second precision; backdate so a reset shows
+				sq.conn.Exec(`UPDATE env_vars SET created_at = '2020-01-02 03:04:05'`)
+			} else {
+				v, _ := s.GetVar("/a", "default", "A")
+				created = v.CreatedAt
+			}
+
+			s.SetVar("/a", "default", "A", "2", "")
+			s.MoveVar("/a", "default", "/b", "default", "A", false, false)
+			s.DeleteVar("/b", "default", "A")
+			list, _ := s.ListTrash("default")
+			if len(list) != 1 {
+				t.Fatalf("trash = %+v, want the deleted var", list)
+			}
+			s.RestoreTrash(list[0].ID, false)
+
+			v, err := s.GetVar("/b", "default", "A")
+			if err != nil || v == nil {
+				t.Fatalf("GetVar = %+v, %v", v, err)
+			}
+			if !v.CreatedAt.Equal(created) {
+				t.Errorf("CreatedAt = %v, want %v", v.CreatedAt, created)
+			}
+			if v.UpdatedAt.Before(v.CreatedAt) {
+				t.Errorf("UpdatedAt %v is before CreatedAt %v", v.UpdatedAt, v.CreatedAt)
+			}
+		})
+	}
+}
+
+// TestStoreTrash checks deleted vars can be listed, restored and purged,
+// for every backend.
+func TestStoreTrash(t *testing.T) {
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s.SetAuthor("alice@laptop")
+			s.SetVar("/a", "default", "A", "1", "first")
+			s.SetEval("/a", "default", "A", true)
+			s.SetNoExport("/a", "default", "A", true)
+			s.SetFallback("/a", "default", "A", "default:x")
+			s.SetType("/a", "default", "A", "int")
+			s.AddTag("/a", "default", "A", "db")
+			s.SetVar("/a", "default", "B", "2", "")
+			s.SetVar("/a", "staging", "A", "3", "")
+			s.MoveVar("/a", "default", "/b", "default", "B", false, false)
+
+			s.SetAuthor("bob@desk")
+			s.DeleteVar("/a", "default", "A")
+			s.DeleteVarsForPath("/a", "staging")
+
+			trash, err := s.ListTrash("default")
+			if err != nil {
+				t.Fatalf("ListTrash failed: %v", err)
+			}
+			if len(trash) != 1 {
+				t.Fatalf("ListTrash(default) = %+v, want only A (moves aren't deletions)", trash)
+			}
+			got := trash[0]
+			if got.Key != "A" || got.Value != "1" || got.Description != "first" || !got.Eval ||
+				len(got.Tags) != 1 || got.Tags[0] != "db" || got.Author != "alice@laptop" || got.DeletedBy != "bob@desk" || got.DeletedAt.IsZero() {
+				t.Errorf("trashed var = %+v", got)
+			}
+			if all, _ := s.ListTrash(""); len(all) != 2 {
+				t.Errorf("ListTrash(all) = %+v", all)
+			}
+
+			s.SetVar("/a", "default", "A", "new", "")
+			if _, err := s.RestoreTrash(got.ID, false); !errors.Is(err, ErrVarExists) {
+				t.Errorf("RestoreTrash over a new value = %v, want ErrVarExists", err)
+			}
+			if _, err := s.RestoreTrash(got.ID, true); err != nil {
+				t.Fatalf("RestoreTrash failed: %v", err)
+			}
+			v, _ := s.GetVar("/a", "default", "A")
+			if v == nil || v.Value != "1" || !v.Eval || !v.NoExport || v.Fallback != "default:x" || v.Type != "int" || v.Author != "alice@laptop" {
+				t.Errorf("restored var = %+v", v)
+			}
+			if tags, _ := s.GetTagsForPaths([]string{"/a"}, "default"); len(tags) != 1 || tags[0].Tag != "db" {
+				t.Errorf("restored tags = %+v", tags)
+			}
+			trash, _ = s.ListTrash("default")
+			if len(trash) != 1 || trash[0].Value != "new" {
+				t.Errorf("trash after restore = %+v, want the overwritten value", trash)
+			}
+			if _, err := s.RestoreTrash(got.ID, false); !errors.Is(err, ErrVarNotFound) {
+				t.Errorf("RestoreTrash twice = %v, want ErrVarNotFound", err)
+			}
+
+			if n, err := s.PurgeTrash(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+				t.Errorf("PurgeTrash(an hour ago) = %d, %v", n, err)
+			}
+			if n, err := s.PurgeTrash(time.Now()); err != nil || n != 2 {
+				t.Errorf("PurgeTrash(now) = %d, %v, want 2", n, err)
+			}
+			if all, _ := s.ListTrash(""); len(all) != 0 {
+				t.Errorf("trash after purge = %+v", all)
+			}
+		})
+	}
+}
+
+func TestJSONStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "enva.json")
+
+	s, err := Open("json:" + path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	s.SetVar("/p", "default", "KEY", "multi\nline", "desc")
+	s.SetIfUnset("/p", "default", "KEY", true)
+	s.SetEval("/p", "default", "KEY", true)
+	s.AddTag("/p", "default", "KEY", "aws")
+	s.AddClear("/p", "default", "GONE")
+	s.SetScopePolicy("/p", "policy")
+	s.SetVar("/p", "default", "OLD", "x", "")
+	s.DeleteVar("/p", "default", "OLD")
+	s.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("store file missing: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("store file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	s, err = Open("json:" + path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer s.Close()
+
+	v, _ := s.GetVar("/p", "default", "KEY")
+	if v == nil || v.Value != "multi\nline" || v.Description != "desc" || !v.IfUnset || !v.Eval {
+		t.Errorf("reopened var = %+v", v)
+	}
+	if tags, _ := s.GetTagsForPaths([]string{"/p"}, "default"); len(tags) != 1 {
+		t.Errorf("reopened tags = %+v", tags)
+	}
+	if clears, _ := s.GetClearsForPaths([]string{"/p"}, "default"); len(clears) != 1 {
+		t.Errorf("reopened clears = %+v", clears)
+	}
+	if p, _ := s.GetScopePolicies([]string{"/p"}); p["/p"] != "policy" {
+		t.Errorf("reopened policy = %v", p)
+	}
+	if trash, _ := s.ListTrash("default"); len(trash) != 1 || trash[0].Key != "OLD" || trash[0].ID == 0 {
+		t.Errorf("reopened trash = %+v", trash)
+	}
+
+	// A write that can't be saved leaves the store unchanged
+	os.Chmod(filepath.Dir(path), 0500)
+	defer os.Chmod(filepath.Dir(path), 0755)
+	if os.Geteuid() != 0 {
+		if err := s.SetVar("/p", "default", "NEW", "1", ""); err == nil {
+			t.Error("SetVar should fail when the file can't be written")
+		}
+		if v, _ := s.GetVar("/p", "default", "NEW"); v != nil {
+			t.Errorf("failed write left %+v behind", v)
+		}
+	}
+}
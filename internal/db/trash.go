@@ -0,0 +1,136 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// TrashedVar is a deleted variable, kept with its tags until the trash is
+// purged so it can be restored.
+type TrashedVar struct {
+	EnvVar
+	ID        int64
+	Tags      []string
+	DeletedAt time.Time
+	DeletedBy string // Who deleted it (user@host), if recorded
+}
+
+// trashInsert copies the env_vars rows matching the WHERE clause appended to
+// it, tags included, into env_trash. Its first argument is the deleter.
+const trashInsert = `INSERT INTO env_trash (path, profile, key, value, description, if_unset, eval, refresh, no_export, fallback, value_type, author, tags, updated_at, created_at, deleted_by)
+	SELECT v.path, v.profile, v.key, v.value, v.description, v.if_unset, v.eval, v.refresh, v.no_export, v.fallback, v.value_type, v.author,
+	       (SELECT json_group_array(t.tag) FROM env_tags t WHERE t.path = v.path AND t.profile = v.profile AND t.key = v.key),
+	       v.updated_at, v.created_at, ?
+	FROM env_vars v WHERE `
+
+// ListTrash returns the trashed vars for profile, or for every profile if
+// it is empty, most recently deleted first.
+func (db *DB) ListTrash(profile string) ([]TrashedVar, error) {
+	rows, err := db.conn.Query(`SELECT id, path, profile, key, value, description, if_unset, eval, refresh, no_export, fallback, value_type, author, tags, updated_at, created_at, deleted_at, deleted_by
+	                            FROM env_trash WHERE ? = '' OR profile = ? ORDER BY deleted_at DESC, id DESC`, profile, profile)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []TrashedVar
+	for rows.Next() {
+		t, err := scanTrashed(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *t)
+	}
+	return list, rows.Err()
+}
+
+func scanTrashed(row interface{ Scan(...any) error }) (*TrashedVar, error) {
+	var (
+		t         TrashedVar
+		tags      string
+		updatedAt sql.NullTime
+		createdAt sql.NullTime
+	)
+	err := row.Scan(&t.ID, &t.Path, &t.Profile, &t.Key, &t.Value, &t.Description, &t.IfUnset, &t.Eval, &t.Refresh, &t.NoExport, &t.Fallback, &t.Type, &t.Author,
+		&tags, &updatedAt, &createdAt, &t.DeletedAt, &t.DeletedBy)
+	if err != nil {
+		return nil, err
+	}
+	t.UpdatedAt = updatedAt.Time
+	t.CreatedAt = createdAt.Time
+	if err := json.Unmarshal([]byte(tags), &t.Tags); err != nil {
+		return nil, err
+	}
+	sort.Strings(t.Tags)
+	return &t, nil
+}
+
+// RestoreTrash puts trashed var id back where it was deleted from, with its
+// tags, and removes it from the trash. A missing id fails with
+// ErrVarNotFound; unless overwrite is set, a var that has since been set
+// again fails with ErrVarExists. An overwritten var goes to the trash.
+func (db *DB) RestoreTrash(id int64, overwrite bool) (*TrashedVar, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, err := scanTrashed(tx.QueryRow(`SELECT id, path, profile, key, value, description, if_unset, eval, refresh, no_export, fallback, value_type, author, tags, updated_at, created_at, deleted_at, deleted_by
+	                                   FROM env_trash WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrVarNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM env_vars WHERE path = ? AND profile = ? AND key = ?`, t.Path, t.Profile, t.Key).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		if !overwrite {
+			return nil, ErrVarExists
+		}
+		if _, err := tx.Exec(trashInsert+`v.path = ? AND v.profile = ? AND v.key = ?`, db.author, t.Path, t.Profile, t.Key); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`DELETE FROM env_vars WHERE path = ? AND profile = ? AND key = ?`, t.Path, t.Profile, t.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO env_scopes (path, owner, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)`, t.Path, db.author); err != nil {
+		return nil, err
+	}
+	// Restoring is a change, so sync picks it up: updated_at is now. The
+	// var keeps its creation time, if the trash recorded one
+	if _, err := tx.Exec(`INSERT INTO env_vars (path, profile, key, value, description, if_unset, eval, refresh, no_export, fallback, value_type, author, updated_at, created_at)
+	                      VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, COALESCE(datetime(?, 'unixepoch'), CURRENT_TIMESTAMP))`,
+		t.Path, t.Profile, t.Key, t.Value, t.Description, t.IfUnset, t.Eval, t.Refresh, t.NoExport, t.Fallback, t.Type, t.Author, unixOrNil(t.CreatedAt)); err != nil {
+		return nil, err
+	}
+	for _, tag := range t.Tags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO env_tags (path, profile, key, tag) VALUES (?, ?, ?, ?)`, t.Path, t.Profile, t.Key, tag); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM env_trash WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+	return t, tx.Commit()
+}
+
+// PurgeTrash permanently deletes the vars trashed at or before cutoff and
+// returns how many there were.
+func (db *DB) PurgeTrash(cutoff time.Time) (int, error) {
+	res, err := db.conn.Exec(`DELETE FROM env_trash WHERE deleted_at <= datetime(?, 'unixepoch')`, cutoff.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
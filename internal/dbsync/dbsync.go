@@ -0,0 +1,477 @@
+// Package dbsync synchronizes an enva store with a remote bundle, so a
+// personal configuration can follow its owner across machines.
+//
+// A push uploads every variable as one JSON bundle; a pull merges a bundle
+// into the local store. Each side's changes are measured against a base
+// recorded at the last sync, using updated_at and a content checksum, so a
+// variable changed on both machines is reported as a conflict instead of
+// silently overwritten.
+package dbsync
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nick-skriabin/enva/internal/db"
+	"github.com/nick-skriabin/enva/internal/shell"
+)
+
+// bundleVersion is bumped when the bundle layout changes incompatibly.
+const bundleVersion = 1
+
+// Bundle is the document stored at the remote.
+type Bundle struct {
+	Version  int       `json:"version"`
+	Revision string    `json:"revision"`
+	Machine  string    `json:"machine"`
+	PushedAt time.Time `json:"pushed_at"`
+	Vars     []Var     `json:"vars"`
+}
+
+// Var is one variable in a bundle.
+type Var struct {
+	Path        string    `json:"path"`
+	Profile     string    `json:"profile"`
+	Key         string    `json:"key"`
+	Value       string    `json:"value"`
+	Description string    `json:"description,omitempty"`
+	IfUnset     bool      `json:"if_unset,omitempty"`
+	Eval        bool      `json:"eval,omitempty"`
+	Refresh     int       `json:"refresh,omitempty"`
+	NoExport    bool      `json:"no_export,omitempty"`
+	Fallback    string    `json:"fallback,omitempty"`
+	Type        string    `json:"type,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ID identifies the variable across machines.
+func (v Var) ID() string {
+	return v.Profile + "\x00" + v.Path + "\x00" + v.Key
+}
+
+// String names the variable for messages.
+func (v Var) String() string {
+	return fmt.Sprintf("%s at %s (profile %s)", v.Key, v.Path, v.Profile)
+}
+
+// sum is a checksum of everything a sync carries besides the timestamp.
+func (v Var) sum() string {
+	h := sha256.New()
+	for _, s := range []string{v.Value, v.Description, fmt.Sprint(v.IfUnset), strings.Join(v.Tags, ",")} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	// Appended only when set so literal vars keep the sums older bundles carry
+	if v.Eval {
+		h.Write([]byte("eval"))
+	}
+	if v.Refresh != 0 {
+		fmt.Fprintf(h, "refresh=%d", v.Refresh)
+	}
+	if v.NoExport {
+		h.Write([]byte("no_export"))
+	}
+	if v.Fallback != "" {
+		fmt.Fprintf(h, "fallback=%s", v.Fallback)
+	}
+	if v.Type != "" {
+		fmt.Fprintf(h, "type=%s", v.Type)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:12])
+}
+
+// Encode serializes the bundle.
+func (b *Bundle) Encode() ([]byte, error) {
+	return json.MarshalIndent(b, "", "  ")
+}
+
+// DecodeBundle parses a bundle. A bundle with a key that isn't a valid
+// variable name is rejected whole: anyone who can write to the remote
+// could otherwise get a shell command into every export.
+func DecodeBundle(data []byte) (*Bundle, error) {
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("invalid sync bundle: %w", err)
+	}
+	if b.Version > bundleVersion {
+		return nil, fmt.Errorf("sync bundle was written by a newer enva (version %d)", b.Version)
+	}
+	if err := checkKeys(b.Vars); err != nil {
+		return nil, fmt.Errorf("invalid sync bundle: %w", err)
+	}
+	return &b, nil
+}
+
+// checkKeys fails on the first var whose key isn't a valid variable name.
+func checkKeys(vars []Var) error {
+	for _, v := range vars {
+		if !shell.IsValidKey(v.Key) {
+			return fmt.Errorf("invalid key %q at %s (profile %s)", v.Key, v.Path, v.Profile)
+		}
+	}
+	return nil
+}
+
+// Snapshot reads every variable in the store, across all profiles, sorted.
+func Snapshot(store db.Store) ([]Var, error) {
+	profiles, err := store.ListProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var vars []Var
+	for _, profile := range profiles {
+		all, err := store.GetAllVars(profile)
+		if err != nil {
+			return nil, err
+		}
+
+		var paths []string
+		seen := make(map[string]bool)
+		for _, v := range all {
+			if !seen[v.Path] {
+				seen[v.Path] = true
+				paths = append(paths, v.Path)
+			}
+		}
+		tags, err := store.GetTagsForPaths(paths, profile)
+		if err != nil {
+			return nil, err
+		}
+		tagsByVar := make(map[string][]string)
+		for _, t := range tags {
+			id := t.Path + "\x00" + t.Key
+			tagsByVar[id] = append(tagsByVar[id], t.Tag)
+		}
+
+		for _, v := range all {
+			vars = append(vars, Var{
+				Path:        v.Path,
+				Profile:     v.Profile,
+				Key:         v.Key,
+				Value:       v.Value,
+				Description: v.Description,
+				IfUnset:     v.IfUnset,
+				Eval:        v.Eval,
+				Refresh:     v.Refresh,
+				NoExport:    v.NoExport,
+				Fallback:    v.Fallback,
+				Type:        v.Type,
+				Tags:        tagsByVar[v.Path+"\x00"+v.Key],
+				Author:      v.Author,
+				UpdatedAt:   v.UpdatedAt.UTC(),
+			})
+		}
+	}
+	sortVars(vars)
+	return vars, nil
+}
+
+// NewBundle wraps vars in a bundle with a fresh revision.
+func NewBundle(vars []Var) *Bundle {
+	machine, _ := os.Hostname()
+	return &Bundle{
+		Version:  bundleVersion,
+		Revision: newRevision(),
+		Machine:  machine,
+		PushedAt: time.Now().UTC(),
+		Vars:     vars,
+	}
+}
+
+func newRevision() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sortVars(vars []Var) {
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].ID() < vars[j].ID()
+	})
+}
+
+// Base is what both sides agreed on at the last sync.
+type Base struct {
+	Revision string             `json:"revision"`
+	Vars     map[string]BaseVar `json:"vars"`
+}
+
+// BaseVar records a synced variable's checksum and its updated_at on each
+// side, since applying a pulled value stamps a new local time.
+type BaseVar struct {
+	Sum      string    `json:"sum"`
+	LocalAt  time.Time `json:"local_at"`
+	RemoteAt time.Time `json:"remote_at"`
+}
+
+// NextBase records the state after a sync with revision. Variables the two
+// sides now agree on get fresh entries; ones that still differ keep their
+// entry from prev (which may be nil), so pending local edits stay pending.
+func NextBase(prev *Base, revision string, local, remote []Var) *Base {
+	base := &Base{Revision: revision, Vars: make(map[string]BaseVar)}
+	mine := indexVars(local)
+	theirs := indexVars(remote)
+
+	for id, m := range mine {
+		if t := theirs[id]; t != nil && t.sum() == m.sum() {
+			base.Vars[id] = BaseVar{Sum: m.sum(), LocalAt: m.UpdatedAt, RemoteAt: t.UpdatedAt}
+		}
+	}
+	if prev != nil {
+		for id, b := range prev.Vars {
+			_, agreed := base.Vars[id]
+			if !agreed && (mine[id] != nil || theirs[id] != nil) {
+				base.Vars[id] = b
+			}
+		}
+	}
+	return base
+}
+
+// BasePath returns where the base for syncing the store at dbPath with
+// remote is kept, under dataDir.
+func BasePath(dataDir, dbPath, remote string) string {
+	sum := sha256.Sum256([]byte(dbPath + "\x00" + remote))
+	return filepath.Join(dataDir, "sync", hex.EncodeToString(sum[:8])+".json")
+}
+
+// LoadBase reads a base. A missing file means the two have never synced.
+func LoadBase(path string) (*Base, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b Base
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("invalid sync state %s: %w", path, err)
+	}
+	return &b, nil
+}
+
+// Save writes the base atomically.
+func (b *Base) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// changed reports whether v (nil if absent) differs from the base entry on
+// one side, judged by updated_at and checksum.
+func changed(v *Var, b BaseVar, inBase bool, at func(BaseVar) time.Time) bool {
+	if v == nil || !inBase {
+		return (v == nil) != !inBase
+	}
+	return !v.UpdatedAt.Equal(at(b)) || v.sum() != b.Sum
+}
+
+func localAt(b BaseVar) time.Time  { return b.LocalAt }
+func remoteAt(b BaseVar) time.Time { return b.RemoteAt }
+
+// Conflict is a variable changed on both sides since the last sync. A nil
+// side means it was deleted there.
+type Conflict struct {
+	Mine   *Var
+	Theirs *Var
+}
+
+// Var returns whichever side exists, for naming the conflict.
+func (c Conflict) Var() Var {
+	if c.Mine != nil {
+		return *c.Mine
+	}
+	return *c.Theirs
+}
+
+// Resolution strategies for conflicts.
+const (
+	PreferNone   = ""
+	PreferMine   = "mine"
+	PreferTheirs = "theirs"
+	PreferNewer  = "newer"
+)
+
+// IsValidPrefer reports whether p names a resolution strategy.
+func IsValidPrefer(p string) bool {
+	switch p {
+	case PreferNone, PreferMine, PreferTheirs, PreferNewer:
+		return true
+	}
+	return false
+}
+
+// Plan is the set of local changes a pull makes.
+type Plan struct {
+	Set       []Var      // Take the remote version
+	Delete    []Var      // Deleted remotely
+	Conflicts []Conflict // Changed on both sides, left as they are
+	Kept      []Conflict // Conflicts the preference resolved in favor of mine
+}
+
+// Empty reports whether the plan changes nothing and has no conflicts.
+func (p Plan) Empty() bool {
+	return len(p.Set) == 0 && len(p.Delete) == 0 && len(p.Conflicts) == 0 && len(p.Kept) == 0
+}
+
+// PlanPull works out how to bring remote changes into local. base is nil
+// before the first sync, in which case any difference is a conflict.
+// Conflicts are resolved with prefer when it's set.
+func PlanPull(base *Base, local, remote []Var, prefer string) Plan {
+	if base == nil {
+		base = &Base{}
+	}
+	mine := indexVars(local)
+	theirs := indexVars(remote)
+
+	ids := make(map[string]bool)
+	for id := range mine {
+		ids[id] = true
+	}
+	for id := range theirs {
+		ids[id] = true
+	}
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+
+	var plan Plan
+	for _, id := range sorted {
+		m, t := mine[id], theirs[id]
+		if m != nil && t != nil && m.sum() == t.sum() {
+			continue
+		}
+		if m == nil && t == nil {
+			continue
+		}
+
+		b, inBase := base.Vars[id]
+		mineChanged := changed(m, b, inBase, localAt)
+		theirsChanged := changed(t, b, inBase, remoteAt)
+
+		take := false
+		switch {
+		case !theirsChanged:
+			continue
+		case !mineChanged:
+			take = true
+		default:
+			switch prefer {
+			case PreferTheirs:
+				take = true
+			case PreferNewer:
+				take = updatedAt(t).After(updatedAt(m))
+			case PreferNone:
+				plan.Conflicts = append(plan.Conflicts, Conflict{Mine: m, Theirs: t})
+				continue
+			}
+			if !take {
+				plan.Kept = append(plan.Kept, Conflict{Mine: m, Theirs: t})
+			}
+		}
+
+		if !take {
+			continue
+		}
+		if t == nil {
+			plan.Delete = append(plan.Delete, *m)
+		} else {
+			plan.Set = append(plan.Set, *t)
+		}
+	}
+	return plan
+}
+
+// updatedAt treats a deleted side as older than any edit.
+func updatedAt(v *Var) time.Time {
+	if v == nil {
+		return time.Time{}
+	}
+	return v.UpdatedAt
+}
+
+func indexVars(vars []Var) map[string]*Var {
+	m := make(map[string]*Var, len(vars))
+	for i := range vars {
+		m[vars[i].ID()] = &vars[i]
+	}
+	return m
+}
+
+// Apply writes a pull plan to the store in one transaction: values, flags
+// and tags together. A plan setting an invalid key is refused.
+func Apply(store db.Store, plan Plan) error {
+	if err := checkKeys(plan.Set); err != nil {
+		return err
+	}
+
+	type scope struct{ path, profile string }
+	changes := make(map[scope]*db.ScopeChange)
+	var order []scope
+	get := func(v Var) *db.ScopeChange {
+		s := scope{v.Path, v.Profile}
+		if changes[s] == nil {
+			changes[s] = &db.ScopeChange{Path: v.Path, Profile: v.Profile, Set: make(map[string]db.VarData)}
+			order = append(order, s)
+		}
+		return changes[s]
+	}
+	for _, v := range plan.Set {
+		get(v).Set[v.Key] = db.VarData{
+			Value:       v.Value,
+			Description: v.Description,
+			Author:      v.Author,
+			Flags: &db.VarFlags{
+				IfUnset:  v.IfUnset,
+				Eval:     v.Eval,
+				Refresh:  v.Refresh,
+				NoExport: v.NoExport,
+				Fallback: v.Fallback,
+				Type:     v.Type,
+				Tags:     v.Tags,
+			},
+		}
+	}
+	for _, v := range plan.Delete {
+		c := get(v)
+		c.Delete = append(c.Delete, v.Key)
+	}
+
+	var list []db.ScopeChange
+	for _, s := range order {
+		list = append(list, *changes[s])
+	}
+	return store.ApplyChanges(list)
+}
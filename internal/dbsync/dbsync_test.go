@@ -0,0 +1,217 @@
+package dbsync
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nick-skriabin/enva/internal/db"
+)
+
+var t0 = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func v(key, value string, at int) Var {
+	return Var{Path: "/p", Profile: "default", Key: key, Value: value, UpdatedAt: t0.Add(time.Duration(at) * time.Minute)}
+}
+
+func TestPlanPull(t *testing.T) {
+	synced := []Var{v("KEEP", "1", 0), v("EDIT_THEIRS", "1", 0), v("EDIT_MINE", "1", 0), v("BOTH", "1", 0), v("DEL_THEIRS", "1", 0)}
+	base := NextBase(nil, "rev1", synced, synced)
+
+	local := []Var{v("KEEP", "1", 0), v("EDIT_THEIRS", "1", 0), v("EDIT_MINE", "2", 5), v("BOTH", "mine", 5), v("DEL_THEIRS", "1", 0), v("NEW_MINE", "x", 5)}
+	remote := []Var{v("KEEP", "1", 0), v("EDIT_THEIRS", "2", 3), v("EDIT_MINE", "1", 0), v("BOTH", "theirs", 7), v("NEW_THEIRS", "y", 3)}
+
+	plan := PlanPull(base, local, remote, PreferNone)
+	if len(plan.Set) != 2 || plan.Set[0].Key != "EDIT_THEIRS" || plan.Set[1].Key != "NEW_THEIRS" {
+		t.Errorf("Set = %+v", plan.Set)
+	}
+	if len(plan.Delete) != 1 || plan.Delete[0].Key != "DEL_THEIRS" {
+		t.Errorf("Delete = %+v", plan.Delete)
+	}
+	if len(plan.Conflicts) != 1 || plan.Conflicts[0].Var().Key != "BOTH" {
+		t.Errorf("Conflicts = %+v", plan.Conflicts)
+	}
+
+	for prefer, want := range map[string]string{PreferTheirs: "theirs", PreferNewer: "theirs", PreferMine: ""} {
+		plan := PlanPull(base, local, remote, prefer)
+		if len(plan.Conflicts) != 0 {
+			t.Errorf("prefer %s left conflicts %+v", prefer, plan.Conflicts)
+		}
+		got := ""
+		for _, s := range plan.Set {
+			if s.Key == "BOTH" {
+				got = s.Value
+			}
+		}
+		if got != want {
+			t.Errorf("prefer %s: BOTH = %q, want %q", prefer, got, want)
+		}
+		if kept := len(plan.Kept) == 1; kept != (want == "") {
+			t.Errorf("prefer %s: Kept = %+v", prefer, plan.Kept)
+		}
+	}
+}
+
+func TestNextBase(t *testing.T) {
+	synced := []Var{v("EDITED", "1", 0), v("GONE", "1", 0)}
+	prev := NextBase(nil, "rev1", synced, synced)
+
+	// After a pull: EDITED has a pending local edit, GONE was deleted on
+	// both sides and NEW only exists locally
+	local := []Var{v("EDITED", "2", 5), v("NEW", "x", 5)}
+	remote := []Var{v("EDITED", "1", 0)}
+	base := NextBase(prev, "rev2", local, remote)
+
+	if base.Revision != "rev2" {
+		t.Errorf("Revision = %q", base.Revision)
+	}
+	if _, ok := base.Vars[v("GONE", "", 0).ID()]; ok {
+		t.Error("a var gone from both sides should leave the base")
+	}
+	if _, ok := base.Vars[v("NEW", "", 0).ID()]; ok {
+		t.Error("a local-only var should not enter the base")
+	}
+
+	// The pending edit is neither lost nor turned into a conflict
+	plan := PlanPull(base, local, remote, PreferNone)
+	if !plan.Empty() {
+		t.Errorf("second pull plan = %+v, want empty", plan)
+	}
+}
+
+func TestPlanPullFirstSync(t *testing.T) {
+	local := []Var{v("SAME", "1", 0), v("DIFF", "mine", 0), v("ONLY_MINE", "1", 0)}
+	remote := []Var{v("SAME", "1", 2), v("DIFF", "theirs", 0), v("ONLY_THEIRS", "1", 0)}
+
+	plan := PlanPull(nil, local, remote, PreferNone)
+	if len(plan.Set) != 1 || plan.Set[0].Key != "ONLY_THEIRS" {
+		t.Errorf("Set = %+v", plan.Set)
+	}
+	if len(plan.Delete) != 0 {
+		t.Errorf("Delete = %+v", plan.Delete)
+	}
+	if len(plan.Conflicts) != 1 || plan.Conflicts[0].Var().Key != "DIFF" {
+		t.Errorf("Conflicts = %+v", plan.Conflicts)
+	}
+}
+
+func TestPlanPullDetectsTouch(t *testing.T) {
+	// Same content but a newer updated_at on both sides still counts as a change
+	synced := []Var{v("A", "1", 0)}
+	base := NextBase(nil, "rev1", synced, synced)
+
+	plan := PlanPull(base, []Var{v("A", "1", 1)}, []Var{v("A", "2", 2)}, PreferNone)
+	if len(plan.Conflicts) != 1 {
+		t.Errorf("Conflicts = %+v, want one", plan.Conflicts)
+	}
+}
+
+func TestSnapshotApply(t *testing.T) {
+	store, err := db.Open("memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetAuthor("alice@laptop")
+	store.SetVar("/p", "default", "A", "1", "first")
+	store.SetVar("/p", "staging", "A", "s", "")
+	store.SetAuthor("bob@desk")
+	store.AddTag("/p", "default", "A", "aws")
+
+	vars, err := Snapshot(store)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(vars) != 2 || vars[0].Profile != "default" || len(vars[0].Tags) != 1 || vars[0].Description != "first" || vars[0].Author != "alice@laptop" {
+		t.Errorf("Snapshot = %+v", vars)
+	}
+
+	b := NewBundle(vars)
+	data, _ := b.Encode()
+	got, err := DecodeBundle(data)
+	if err != nil || got.Revision != b.Revision || len(got.Vars) != 2 {
+		t.Errorf("DecodeBundle = %+v, %v", got, err)
+	}
+
+	plan := Plan{
+		Set:    []Var{{Path: "/q", Profile: "default", Key: "B", Value: "2", IfUnset: true, Tags: []string{"ci"}, Author: "carol@ci"}},
+		Delete: []Var{{Path: "/p", Profile: "staging", Key: "A"}},
+	}
+	if err := Apply(store, plan); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	b2, _ := store.GetVar("/q", "default", "B")
+	// The remote author is kept rather than replaced by whoever pulled
+	if b2 == nil || b2.Value != "2" || !b2.IfUnset || b2.Author != "carol@ci" {
+		t.Errorf("applied B = %+v", b2)
+	}
+	if tags, _ := store.GetTagsForPaths([]string{"/q"}, "default"); len(tags) != 1 || tags[0].Tag != "ci" {
+		t.Errorf("applied tags = %+v", tags)
+	}
+	if a, _ := store.GetVar("/p", "staging", "A"); a != nil {
+		t.Errorf("staging A should be deleted, got %+v", a)
+	}
+}
+
+func TestApplyFlagsAndTags(t *testing.T) {
+	for _, dsn := range []string{"memory:", filepath.Join(t.TempDir(), "test.db")} {
+		store, err := db.Open(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		store.SetVar("/p", "default", "T", "old", "")
+		store.AddTag("/p", "default", "T", "stale")
+
+		set := Var{Path: "/p", Profile: "default", Key: "T", Value: "vault read x", Eval: true, Refresh: 60, Fallback: "none", Type: "json", Tags: []string{"ci"}}
+		if err := Apply(store, Plan{Set: []Var{set}}); err != nil {
+			t.Fatalf("%s: Apply failed: %v", dsn, err)
+		}
+		got, _ := store.GetVar("/p", "default", "T")
+		if got == nil || !got.Eval || got.Refresh != 60 || got.Fallback != "none" || got.Type != "json" || got.IfUnset || got.NoExport {
+			t.Errorf("%s: applied T = %+v", dsn, got)
+		}
+		if tags, _ := store.GetTagsForPaths([]string{"/p"}, "default"); len(tags) != 1 || tags[0].Tag != "ci" {
+			t.Errorf("%s: applied tags = %+v", dsn, tags)
+		}
+	}
+}
+
+func TestInvalidKeysRejected(t *testing.T) {
+	bad := Var{Path: "/p", Profile: "default", Key: "Z;echo PWNED;Q", Value: "v", UpdatedAt: t0}
+	data, _ := NewBundle([]Var{v("A", "1", 0), bad}).Encode()
+	if _, err := DecodeBundle(data); err == nil {
+		t.Error("DecodeBundle should reject a bundle with an invalid key")
+	}
+
+	store, err := db.Open("memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(store, Plan{Set: []Var{v("A", "1", 0), bad}}); err == nil {
+		t.Error("Apply should refuse a plan setting an invalid key")
+	}
+	if a, _ := store.GetVar("/p", "default", "A"); a != nil {
+		t.Errorf("nothing should be applied, got %+v", a)
+	}
+}
+
+func TestBaseSaveLoad(t *testing.T) {
+	path := BasePath(t.TempDir(), "/db", "s3://b/k")
+
+	if b, err := LoadBase(path); err != nil || b != nil {
+		t.Errorf("LoadBase missing = %+v, %v", b, err)
+	}
+
+	base := NextBase(nil, "rev", []Var{v("A", "1", 0)}, []Var{v("A", "1", 1)})
+	if err := base.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	got, err := LoadBase(path)
+	if err != nil || got.Revision != "rev" || !got.Vars[v("A", "", 0).ID()].RemoteAt.Equal(t0.Add(time.Minute)) {
+		t.Errorf("LoadBase = %+v, %v", got, err)
+	}
+
+	if filepath.Dir(path) == "" || BasePath("/d", "/db", "other") == path {
+		t.Error("BasePath should differ per remote")
+	}
+}
@@ -0,0 +1,164 @@
+package dbsync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNoBundle is returned by Remote.Get when nothing has been pushed yet.
+var ErrNoBundle = errors.New("no bundle at remote")
+
+// Remote is a place a bundle can be stored.
+type Remote interface {
+	Get() ([]byte, error)
+	Put(data []byte) error
+}
+
+// OpenRemote returns the remote for location:
+//
+//	/path/enva-sync.json, file:///path/...   a file (e.g. in a synced folder)
+//	https://host/dav/enva-sync.json          WebDAV or any HTTP GET/PUT server
+//	s3://bucket/key                          Amazon S3 or a compatible store
+//	git+https://host/repo.git#enva-sync.json a file in a git repository
+//
+// cacheDir holds working copies for git remotes.
+func OpenRemote(location, cacheDir string) (Remote, error) {
+	switch {
+	case strings.HasPrefix(location, "git+"):
+		return newGitRemote(strings.TrimPrefix(location, "git+"), cacheDir)
+	case strings.HasPrefix(location, "s3://"):
+		return newS3Remote(location)
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return newHTTPRemote(location)
+	case strings.HasPrefix(location, "file://"):
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		return fileRemote(u.Path), nil
+	case strings.Contains(location, "://"):
+		return nil, fmt.Errorf("unsupported sync remote: %s", location)
+	}
+	return fileRemote(location), nil
+}
+
+// fileRemote stores the bundle in a local file.
+type fileRemote string
+
+func (f fileRemote) Get() ([]byte, error) {
+	data, err := os.ReadFile(string(f))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoBundle
+	}
+	return data, err
+}
+
+func (f fileRemote) Put(data []byte) error {
+	path := string(f)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".enva-sync-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// httpTimeout bounds each request to an HTTP or S3 remote.
+const httpTimeout = 30 * time.Second
+
+// httpRemote stores the bundle with plain GET and PUT, which covers WebDAV
+// servers. Credentials come from the URL or ENVA_SYNC_USER and
+// ENVA_SYNC_PASSWORD.
+type httpRemote struct {
+	url    string
+	user   string
+	pass   string
+	client *http.Client
+}
+
+func newHTTPRemote(location string) (*httpRemote, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	r := &httpRemote{
+		user:   os.Getenv("ENVA_SYNC_USER"),
+		pass:   os.Getenv("ENVA_SYNC_PASSWORD"),
+		client: &http.Client{Timeout: httpTimeout},
+	}
+	if u.User != nil {
+		r.user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			r.pass = p
+		}
+		u.User = nil
+	}
+	r.url = u.String()
+	return r, nil
+}
+
+func (r *httpRemote) do(method string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, r.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if r.user != "" {
+		req.SetBasicAuth(r.user, r.pass)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return r.client.Do(req)
+}
+
+func (r *httpRemote) Get() ([]byte, error) {
+	resp, err := r.do(http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(resp)
+}
+
+func (r *httpRemote) Put(data []byte) error {
+	resp, err := r.do(http.MethodPut, data)
+	if err != nil {
+		return err
+	}
+	_, err = readResponse(resp)
+	return err
+}
+
+// readResponse returns the body of a successful response, ErrNoBundle for
+// a 404 and an error naming the status otherwise.
+func readResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNoBundle
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("remote returned %s", resp.Status)
+	}
+	return body, nil
+}
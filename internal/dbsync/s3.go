@@ -0,0 +1,157 @@
+package dbsync
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Remote stores the bundle as an S3 object, signing requests with AWS
+// Signature Version 4. Credentials come from the standard AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN variables and the region from
+// AWS_REGION (or AWS_DEFAULT_REGION). ENVA_S3_ENDPOINT points it at an
+// S3-compatible store such as MinIO, using path-style URLs.
+type s3Remote struct {
+	endpoint string // Object URL
+	region   string
+	keyID    string
+	secret   string
+	token    string
+	client   *http.Client
+	now      func() time.Time
+}
+
+func newS3Remote(location string) (*s3Remote, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("s3 remote needs a bucket and key: s3://bucket/key")
+	}
+
+	r := &s3Remote{
+		region: os.Getenv("AWS_REGION"),
+		keyID:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+		client: &http.Client{Timeout: httpTimeout},
+		now:    time.Now,
+	}
+	if r.region == "" {
+		r.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if r.region == "" {
+		r.region = "us-east-1"
+	}
+	if r.keyID == "" || r.secret == "" {
+		return nil, fmt.Errorf("s3 remote needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	if ep := os.Getenv("ENVA_S3_ENDPOINT"); ep != "" {
+		r.endpoint = strings.TrimSuffix(ep, "/") + "/" + bucket + "/" + key
+	} else {
+		r.endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, r.region, key)
+	}
+	return r, nil
+}
+
+func (r *s3Remote) do(method string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.token != "" {
+		req.Header.Set("X-Amz-Security-Token", r.token)
+	}
+	r.sign(req, body)
+	return r.client.Do(req)
+}
+
+func (r *s3Remote) Get() ([]byte, error) {
+	resp, err := r.do(http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(resp)
+}
+
+func (r *s3Remote) Put(data []byte) error {
+	resp, err := r.do(http.MethodPut, data)
+	if err != nil {
+		return err
+	}
+	_, err = readResponse(resp)
+	return err
+}
+
+// sign adds SigV4 headers to req. Every header already set is signed.
+func (r *s3Remote) sign(req *http.Request, body []byte) {
+	now := r.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	var names []string
+	headers := make(map[string]string)
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		names = append(names, lower)
+		headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, n := range names {
+		canonHeaders.WriteString(n + ":" + headers[n] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signed,
+		payload,
+	}, "\n")
+
+	scope := day + "/" + r.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+r.secret), day)
+	key = hmacSHA256(key, r.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Del("Host") // net/http sends req.Host itself
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		r.keyID, scope, signed, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,349 @@
+// Package dynamic runs the shell commands behind --eval values, such as
+// `git rev-parse --short HEAD` or a vault lookup, so the exported value is
+// the command's output. Outputs are cached on disk for a short while, or
+// for the var's own refresh interval, so the shell hook doesn't rerun every
+// command at each prompt. Refresh and RefreshDue renew the cache ahead of
+// time, so short-lived credentials are replaced before anyone waits on them.
+package dynamic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+// Defaults used when Runner leaves Timeout or TTL zero.
+const (
+	DefaultTimeout = 5 * time.Second
+	DefaultTTL     = 30 * time.Second
+)
+
+// Runner evaluates commands. A negative TTL turns caching off for vars
+// without their own refresh interval; an empty CacheDir turns it off for all.
+// Offline runs no commands: cached outputs still serve within their
+// lifetime, and every other var fails, or uses its fallback. Env is added
+// to the commands' environment only, such as a VAULT_TOKEN that shouldn't
+// reach anything else enva starts. Command, if set, turns an eval var's
+// stored value into the command to run, such as a secret manager reference
+// into the CLI call that reads it; without it the value is the command.
+type Runner struct {
+	Timeout  time.Duration
+	TTL      time.Duration
+	CacheDir string
+	Offline  bool
+	Env      []string
+	Command  func(value string) (string, error)
+}
+
+// ErrOffline is the error for commands an offline Runner didn't run.
+var ErrOffline = errors.New("offline")
+
+// Failure is an eval var whose command didn't produce a value. When
+// Fallback is set the var was exported anyway, with the value it names.
+type Failure struct {
+	Var      *env.ResolvedVar
+	Err      error
+	Fallback string
+}
+
+// Fallback is what an eval var exports when its command fails, parsed from
+// the var's fallback setting:
+//
+//	fail (or "")     not exported
+//	cache[:MAXAGE]   the last output, if no older than MAXAGE (default any)
+//	default:VALUE    VALUE
+type Fallback struct {
+	Cache      bool
+	MaxAge     time.Duration
+	UseDefault bool
+	Default    string
+}
+
+// ParseFallback parses a fallback setting.
+func ParseFallback(spec string) (Fallback, error) {
+	mode, arg, hasArg := strings.Cut(spec, ":")
+	switch {
+	case spec == "" || spec == "fail":
+		return Fallback{}, nil
+	case mode == "cache" && !hasArg:
+		return Fallback{Cache: true}, nil
+	case mode == "cache":
+		age, err := time.ParseDuration(arg)
+		if err != nil || age <= 0 {
+			return Fallback{}, fmt.Errorf("invalid fallback %q: cache:MAXAGE takes a duration such as 24h", spec)
+		}
+		return Fallback{Cache: true, MaxAge: age}, nil
+	case mode == "default" && hasArg:
+		return Fallback{UseDefault: true, Default: arg}, nil
+	}
+	// Only the mode: what follows it may be a value
+	return Fallback{}, fmt.Errorf("invalid fallback %q: use fail, cache[:MAXAGE] or default:VALUE", mode)
+}
+
+type entry struct {
+	Value string    `json:"value"`
+	At    time.Time `json:"at"`
+}
+
+// Value returns the output of command run with sh in dir, minus trailing
+// newlines, like $(...) in the shell.
+func (r *Runner) Value(dir, command string) (string, error) {
+	return r.value(dir, command, r.TTL, false)
+}
+
+// value runs command unless a cached output younger than ttl (or the
+// runner's default for zero) exists. force skips the cache lookup but still
+// stores the output.
+func (r *Runner) value(dir, command string, ttl time.Duration, force bool) (string, error) {
+	ttl = r.ttlOr(ttl)
+	path := r.cachePath(dir, command, ttl)
+	if path != "" && !force {
+		if e, ok := loadEntry(path); ok && time.Since(e.At) < ttl {
+			return e.Value, nil
+		}
+	}
+	if r.Offline {
+		return "", ErrOffline
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir, _ = env.SplitHost(dir) // Host-limited vars run in their directory
+	if len(r.Env) > 0 {
+		cmd.Env = append(os.Environ(), r.Env...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Don't let a child holding the pipes open outlive the timeout
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("timed out after %s", timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+
+	value := strings.TrimRight(stdout.String(), "\n")
+	if path != "" {
+		storeEntry(path, entry{Value: value, At: time.Now()})
+	}
+	return value, nil
+}
+
+// Apply replaces the value of every eval var in ctx with its command's
+// output. Vars whose command fails take their fallback value, or are
+// removed without one, so a command line is never exported as a value;
+// either way they are returned sorted by key.
+func (r *Runner) Apply(ctx *env.ResolveContext) []Failure {
+	var failed []Failure
+	for _, v := range ctx.GetSortedVars() {
+		if !v.Eval {
+			continue
+		}
+		value, err := r.eval(v)
+		if err != nil {
+			if value, used, ok := r.fallback(v); ok {
+				v.Value = value
+				failed = append(failed, Failure{Var: v, Err: err, Fallback: used})
+				continue
+			}
+			delete(ctx.Resolved, v.Key)
+			failed = append(failed, Failure{Var: v, Err: err})
+			continue
+		}
+		v.Value = value
+	}
+	return failed
+}
+
+// fallback returns the value v's fallback gives, described for messages,
+// or false when it has none or the cache has nothing recent enough.
+func (r *Runner) fallback(v *env.ResolvedVar) (value, used string, ok bool) {
+	fb, err := ParseFallback(v.Fallback)
+	if err != nil {
+		return "", "", false
+	}
+	switch {
+	case fb.Cache:
+		command, err := r.command(v)
+		if err != nil {
+			return "", "", false
+		}
+		path := r.cachePath(v.DefinedAtPath, command, r.ttlOr(refreshOf(v)))
+		e, ok := loadEntry(path)
+		if path == "" || !ok {
+			return "", "", false
+		}
+		age := time.Since(e.At)
+		if fb.MaxAge > 0 && age > fb.MaxAge {
+			return "", "", false
+		}
+		return e.Value, fmt.Sprintf("cached value from %s ago", age.Round(time.Second)), true
+	case fb.UseDefault:
+		return fb.Default, "fallback default", true
+	}
+	return "", "", false
+}
+
+// Refresh reruns the command behind every cached eval var in ctx and
+// caches the output, whether or not the cached one is stale, returning the
+// vars it renewed. ctx is left unchanged.
+func (r *Runner) Refresh(ctx *env.ResolveContext) (refreshed []*env.ResolvedVar, failed []Failure) {
+	refreshed, failed, _ = r.refresh(ctx, true)
+	return refreshed, failed
+}
+
+// RefreshDue reruns the commands whose cached output is in the last fifth
+// of its lifetime, and returns how long until the next one gets there.
+// Without cached eval vars next is zero.
+func (r *Runner) RefreshDue(ctx *env.ResolveContext) (failed []Failure, next time.Duration) {
+	_, failed, next = r.refresh(ctx, false)
+	return failed, next
+}
+
+func (r *Runner) refresh(ctx *env.ResolveContext, force bool) ([]*env.ResolvedVar, []Failure, time.Duration) {
+	var refreshed []*env.ResolvedVar
+	var failed []Failure
+	var next time.Duration
+	for _, v := range ctx.GetSortedVars() {
+		if !v.Eval {
+			continue
+		}
+		ttl := r.ttlOr(refreshOf(v))
+		command, err := r.command(v)
+		if err != nil {
+			failed = append(failed, Failure{Var: v, Err: err})
+			continue
+		}
+		path := r.cachePath(v.DefinedAtPath, command, ttl)
+		if path == "" {
+			continue
+		}
+
+		// Missing or unreadable output is due now
+		lead := ttl / 5
+		var left time.Duration
+		if e, ok := loadEntry(path); ok && !force {
+			left = ttl - time.Since(e.At) - lead
+		}
+		if left <= 0 {
+			if _, err := r.value(v.DefinedAtPath, command, ttl, true); err != nil {
+				failed = append(failed, Failure{Var: v, Err: err})
+			} else {
+				refreshed = append(refreshed, v)
+			}
+			left = ttl - lead
+		}
+		if left > 0 && (next == 0 || left < next) {
+			next = left
+		}
+	}
+	return refreshed, failed, next
+}
+
+// eval returns the output of v's command.
+func (r *Runner) eval(v *env.ResolvedVar) (string, error) {
+	command, err := r.command(v)
+	if err != nil {
+		return "", err
+	}
+	return r.value(v.DefinedAtPath, command, refreshOf(v), false)
+}
+
+// command returns the command behind v's stored value. Outputs are cached
+// by command, so a reference resolved differently, say after the default
+// region changes, doesn't reuse the old output.
+func (r *Runner) command(v *env.ResolvedVar) (string, error) {
+	if r.Command == nil {
+		return v.Value, nil
+	}
+	return r.Command(v.Value)
+}
+
+// Clear drops every cached output, so the next export reruns the commands.
+func (r *Runner) Clear() error {
+	if r.CacheDir == "" {
+		return nil
+	}
+	return os.RemoveAll(r.CacheDir)
+}
+
+// refreshOf returns v's own refresh interval, or zero for the default.
+func refreshOf(v *env.ResolvedVar) time.Duration {
+	return time.Duration(v.Refresh) * time.Second
+}
+
+// ttlOr returns ttl, falling back to the runner's TTL and then DefaultTTL.
+func (r *Runner) ttlOr(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		ttl = r.TTL
+	}
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	return ttl
+}
+
+// cachePath returns the cache file for command run in dir, or "" when
+// caching is off for ttl.
+func (r *Runner) cachePath(dir, command string, ttl time.Duration) string {
+	if r.CacheDir == "" || ttl < 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(dir + "\x00" + command))
+	return filepath.Join(r.CacheDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+func loadEntry(path string) (entry, bool) {
+	var e entry
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return e, false
+	}
+	return e, json.Unmarshal(data, &e) == nil
+}
+
+// storeEntry writes e atomically. Outputs may be secrets, so the files are
+// private to the user. Failures only cost a rerun next time.
+func storeEntry(path string, e entry) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, werr := tmp.Write(data)
+	if cerr := tmp.Close(); werr != nil || cerr != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,266 @@
+package dynamic
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+func TestValue(t *testing.T) {
+	dir := t.TempDir()
+	r := &Runner{TTL: -1}
+
+	got, err := r.Value(dir, "printf 'a\\nb\\n\\n'")
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+	if got != "a\nb" {
+		t.Errorf("Value = %q, want trailing newlines trimmed", got)
+	}
+
+	got, err = r.Value(dir, "pwd -P")
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+	real, _ := filepath.EvalSymlinks(dir)
+	if got != real {
+		t.Errorf("command ran in %q, want %q", got, real)
+	}
+
+	if _, err := r.Value(dir, "echo nope >&2; exit 3"); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("failing command error = %v, want stderr included", err)
+	}
+}
+
+func TestValueEnv(t *testing.T) {
+	r := &Runner{TTL: -1, Env: []string{"ENVA_TEST_TOKEN=s.abc"}}
+	if got, err := r.Value(t.TempDir(), "echo $ENVA_TEST_TOKEN"); err != nil || got != "s.abc" {
+		t.Errorf("Value = %q, %v; want the runner's Env", got, err)
+	}
+	if _, ok := os.LookupEnv("ENVA_TEST_TOKEN"); ok {
+		t.Error("Env leaked into enva's own environment")
+	}
+}
+
+func TestValueTimeout(t *testing.T) {
+	r := &Runner{Timeout: 100 * time.Millisecond, TTL: -1}
+	start := time.Now()
+	_, err := r.Value(t.TempDir(), "sleep 5")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want timeout", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("timeout took %s", time.Since(start))
+	}
+}
+
+func TestValueCache(t *testing.T) {
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "runs")
+	command := "echo x >> " + counter + "; wc -l < " + counter + " | tr -d ' '"
+	r := &Runner{TTL: time.Hour, CacheDir: filepath.Join(dir, "cache")}
+
+	first, err := r.Value(dir, command)
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+	second, _ := r.Value(dir, command)
+	if first != "1" || second != "1" {
+		t.Errorf("cached values = %q, %q, want the first run reused", first, second)
+	}
+
+	info, err := os.Stat(r.cachePath(dir, command, r.TTL))
+	if err != nil {
+		t.Fatalf("cache file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("cache file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	if err := r.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if third, _ := r.Value(dir, command); third != "2" {
+		t.Errorf("after Clear = %q, want a rerun", third)
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	ctx := &env.ResolveContext{Resolved: map[string]*env.ResolvedVar{
+		"REV":    {Key: "REV", Value: "echo abc123", Eval: true, DefinedAtPath: dir},
+		"BROKEN": {Key: "BROKEN", Value: "exit 1", Eval: true, DefinedAtPath: dir},
+		"PLAIN":  {Key: "PLAIN", Value: "echo hi", DefinedAtPath: dir},
+	}}
+
+	failed := (&Runner{TTL: -1}).Apply(ctx)
+	if len(failed) != 1 || failed[0].Var.Key != "BROKEN" {
+		t.Errorf("failed = %+v", failed)
+	}
+	if _, ok := ctx.Resolved["BROKEN"]; ok {
+		t.Error("a failed command should not be exported")
+	}
+	if v := ctx.Resolved["REV"]; v == nil || v.Value != "abc123" {
+		t.Errorf("REV = %+v", v)
+	}
+	if v := ctx.Resolved["PLAIN"]; v.Value != "echo hi" {
+		t.Errorf("literal value changed to %q", v.Value)
+	}
+}
+
+func TestApplyCommand(t *testing.T) {
+	dir := t.TempDir()
+	ctx := &env.ResolveContext{Resolved: map[string]*env.ResolvedVar{
+		"SECRET": {Key: "SECRET", Value: "ref://db", Eval: true, DefinedAtPath: dir},
+		"BAD":    {Key: "BAD", Value: "ref://", Eval: true, DefinedAtPath: dir},
+		"REV":    {Key: "REV", Value: "echo abc123", Eval: true, DefinedAtPath: dir},
+	}}
+	r := &Runner{TTL: -1, Command: func(value string) (string, error) {
+		name, ok := strings.CutPrefix(value, "ref://")
+		switch {
+		case !ok:
+			return value, nil
+		case name == "":
+			return "", errors.New("no name")
+		}
+		return "echo secret-" + name, nil
+	}}
+
+	failed := r.Apply(ctx)
+	if len(failed) != 1 || failed[0].Var.Key != "BAD" {
+		t.Errorf("failed = %+v", failed)
+	}
+	if v := ctx.Resolved["SECRET"]; v == nil || v.Value != "secret-db" {
+		t.Errorf("SECRET = %+v", v)
+	}
+	if v := ctx.Resolved["REV"]; v == nil || v.Value != "abc123" {
+		t.Errorf("REV = %+v", v)
+	}
+}
+
+func TestParseFallback(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Fallback
+	}{
+		{"", Fallback{}},
+		{"fail", Fallback{}},
+		{"cache", Fallback{Cache: true}},
+		{"cache:24h", Fallback{Cache: true, MaxAge: 24 * time.Hour}},
+		{"default:localhost:5432", Fallback{UseDefault: true, Default: "localhost:5432"}},
+		{"default:", Fallback{UseDefault: true}},
+	}
+	for _, tt := range tests {
+		if got, err := ParseFallback(tt.spec); err != nil || got != tt.want {
+			t.Errorf("ParseFallback(%q) = %+v, %v", tt.spec, got, err)
+		}
+	}
+	for _, bad := range []string{"cache:soon", "cache:-1h", "default", "retry"} {
+		if _, err := ParseFallback(bad); err == nil {
+			t.Errorf("ParseFallback(%q) should fail", bad)
+		}
+	}
+}
+
+func TestApplyFallback(t *testing.T) {
+	dir := t.TempDir()
+	down := filepath.Join(dir, "down")
+	command := "test ! -e " + down + " && echo live"
+	r := &Runner{TTL: time.Millisecond, CacheDir: filepath.Join(dir, "cache")}
+	resolve := func() *env.ResolveContext {
+		return &env.ResolveContext{Resolved: map[string]*env.ResolvedVar{
+			"CACHED":  {Key: "CACHED", Value: command, Eval: true, Fallback: "cache", DefinedAtPath: dir},
+			"STALE":   {Key: "STALE", Value: command + " ", Eval: true, Fallback: "cache:1ms", DefinedAtPath: dir},
+			"DEFAULT": {Key: "DEFAULT", Value: command, Eval: true, Fallback: "default:local", DefinedAtPath: t.TempDir()},
+			"FAIL":    {Key: "FAIL", Value: command, Eval: true, DefinedAtPath: t.TempDir()},
+		}}
+	}
+	if failed := r.Apply(resolve()); len(failed) != 0 {
+		t.Fatalf("failed while up = %+v", failed)
+	}
+
+	os.WriteFile(down, nil, 0644)
+	time.Sleep(10 * time.Millisecond)
+	for _, offline := range []bool{false, true} {
+		r.Offline = offline
+		ctx := resolve()
+		failed := r.Apply(ctx)
+		if len(failed) != 4 {
+			t.Fatalf("offline=%v: failed = %+v", offline, failed)
+		}
+		if v := ctx.Resolved["CACHED"]; v == nil || v.Value != "live" || failed[0].Fallback == "" {
+			t.Errorf("offline=%v: CACHED = %+v, %+v", offline, v, failed[0])
+		}
+		if v := ctx.Resolved["DEFAULT"]; v == nil || v.Value != "local" {
+			t.Errorf("offline=%v: DEFAULT = %+v", offline, v)
+		}
+		for _, key := range []string{"STALE", "FAIL"} {
+			if _, ok := ctx.Resolved[key]; ok {
+				t.Errorf("offline=%v: %s should not be exported", offline, key)
+			}
+		}
+		if offline && !errors.Is(failed[0].Err, ErrOffline) {
+			t.Errorf("offline error = %v, want ErrOffline", failed[0].Err)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "runs")
+	command := "echo x >> " + counter + "; wc -l < " + counter + " | tr -d ' '"
+	ctx := &env.ResolveContext{Resolved: map[string]*env.ResolvedVar{
+		"TOKEN":  {Key: "TOKEN", Value: command, Eval: true, Refresh: 3600, DefinedAtPath: dir},
+		"BROKEN": {Key: "BROKEN", Value: "exit 1", Eval: true, DefinedAtPath: dir},
+		"PLAIN":  {Key: "PLAIN", Value: "x", DefinedAtPath: dir},
+	}}
+	// The default TTL is off, but TOKEN's own interval still caches it
+	r := &Runner{TTL: -1, CacheDir: filepath.Join(dir, "cache")}
+
+	refreshed, failed := r.Refresh(ctx)
+	if len(refreshed) != 1 || refreshed[0].Key != "TOKEN" {
+		t.Errorf("refreshed = %+v", refreshed)
+	}
+	if len(failed) != 0 {
+		t.Errorf("uncached BROKEN should be skipped, failed = %+v", failed)
+	}
+	if ctx.Resolved["TOKEN"].Value != command {
+		t.Error("Refresh should leave ctx unchanged")
+	}
+
+	// Fresh output isn't due for most of the hour
+	failed, next := r.RefreshDue(ctx)
+	if len(failed) != 0 || next < 47*time.Minute || next > 48*time.Minute {
+		t.Errorf("RefreshDue = %+v, %s, want next in ~48m", failed, next)
+	}
+	if got, _ := r.value(dir, command, time.Hour, false); got != "1" {
+		t.Errorf("cached value = %q, want 1", got)
+	}
+
+	// Forced refresh reruns even fresh output
+	r.Refresh(ctx)
+	if got, _ := r.value(dir, command, time.Hour, false); got != "2" {
+		t.Errorf("after Refresh = %q, want 2", got)
+	}
+}
+
+func TestRefreshDueRunsMissing(t *testing.T) {
+	dir := t.TempDir()
+	ctx := &env.ResolveContext{Resolved: map[string]*env.ResolvedVar{
+		"REV": {Key: "REV", Value: "echo abc", Eval: true, Refresh: 10, DefinedAtPath: dir},
+	}}
+	r := &Runner{CacheDir: filepath.Join(dir, "cache")}
+
+	failed, next := r.RefreshDue(ctx)
+	if len(failed) != 0 || next != 8*time.Second {
+		t.Errorf("RefreshDue = %+v, %s, want 8s", failed, next)
+	}
+	if _, err := os.Stat(r.cachePath(dir, "echo abc", 10*time.Second)); err != nil {
+		t.Errorf("missing output should have been cached: %v", err)
+	}
+}
@@ -2,16 +2,89 @@
 package env
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"slices"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/nick-skriabin/enva/internal/db"
 	envpath "github.com/nick-skriabin/enva/internal/path"
+	"github.com/nick-skriabin/enva/internal/policy"
+	"github.com/nick-skriabin/enva/internal/redact"
+	"github.com/nick-skriabin/enva/internal/secrets"
 )
 
 // DefaultProfile is the default profile name.
 const DefaultProfile = "default"
 
+// GlobalScope is the path of the scope that applies everywhere, below every
+// project root, for machine-wide defaults like EDITOR. It can't clash with
+// a directory since real scope paths are absolute.
+const GlobalScope = "@global"
+
+// hostSep joins a scope path to the host its vars are limited to. Canonical
+// paths never contain it.
+const hostSep = "//host:"
+
+// HostScope returns the scope for vars at path that only apply on host.
+func HostScope(path, host string) string {
+	return path + hostSep + host
+}
+
+// SplitHost splits a scope into its path and the host it's limited to, ""
+// for a scope that applies on every host.
+func SplitHost(scope string) (path, host string) {
+	if i := strings.Index(scope, hostSep); i >= 0 {
+		return scope[:i], scope[i+len(hostSep):]
+	}
+	return scope, ""
+}
+
+// ValidHost reports whether host can name a machine: letters, digits, '-',
+// '_' and '.'.
+func ValidHost(host string) bool {
+	for _, c := range host {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return host != ""
+}
+
+// CurrentHost returns the host name host-limited vars are matched against:
+// ENVA_HOST if set, else the machine's host name up to the first dot.
+func CurrentHost() string {
+	if h := os.Getenv("ENVA_HOST"); h != "" {
+		return h
+	}
+	h, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	h, _, _ = strings.Cut(h, ".")
+	return h
+}
+
+// canonicalScope canonicalizes a scope path, leaving GlobalScope as is and
+// keeping any host it's limited to.
+func canonicalScope(path string) (string, error) {
+	dir, host := SplitHost(path)
+	if dir != GlobalScope {
+		var err error
+		if dir, err = envpath.Canonicalize(dir); err != nil {
+			return "", err
+		}
+	}
+	if host != "" {
+		return HostScope(dir, host), nil
+	}
+	return dir, nil
+}
+
 // ResolvedVar represents a resolved environment variable with provenance.
 type ResolvedVar struct {
 	Key           string
@@ -20,20 +93,111 @@ type ResolvedVar struct {
 	DefinedAtPath string
 	Overrode      bool
 	OverrodePath  string
+	IfUnset       bool      // Only applies when the key is absent from the ambient env
+	Eval          bool      // Value is a shell command; its output is what gets exported
+	Refresh       int       // Seconds the command's output is reused; 0 uses the default
+	NoExport      bool      // Resolvable, but never exported to shells or commands
+	Fallback      string    // What an eval var exports when its command fails; "" fails
+	Type          string    // Declared value type (json, int, bool); "" is a plain string
+	Tags          []string  // Tags on the var at DefinedAtPath (sorted)
+	Author        string    // Who last set the var (user@host), if recorded
+	UpdatedAt     time.Time // When the var was last set
+	CreatedAt     time.Time // When the var was first set
+	File          string    // .env file the value was read from; "" when stored
+}
+
+// String names the var for messages with its value redacted.
+func (v ResolvedVar) String() string {
+	return fmt.Sprintf("%s=%v (%s)", v.Key, redact.Ref(v.Value), v.DefinedAtPath)
+}
+
+// HasTag reports whether the var carries tag.
+func (v *ResolvedVar) HasTag(tag string) bool {
+	for _, t := range v.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeTag lowercases a tag and checks it only uses [a-z0-9_.-].
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", fmt.Errorf("tag must not be empty")
+	}
+	for _, c := range tag {
+		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '.' || c == '-') {
+			return "", fmt.Errorf("invalid tag %q: use letters, digits, _, . or -", tag)
+		}
+	}
+	return tag, nil
 }
 
 // Resolver handles environment variable resolution.
 type Resolver struct {
-	db      *db.DB
+	db      db.Store
 	profile string
+	host    string // Host whose host-limited vars apply; "" for none
+	chain   ChainOptions
+	files   EnvFileReader // Reads .env files below stored vars; nil for none
+}
+
+// EnvFileReader returns the vars in a directory's .env files, merged in
+// order, and the file each one was read from. Missing files aren't errors.
+type EnvFileReader func(dir string) (vars map[string]string, files map[string]string, err error)
+
+// ChainOptions limit which directories between root and cwd are looked up,
+// for very deep trees such as generated build output. The root and cwd are
+// always part of the chain; only levels in between are dropped.
+type ChainOptions struct {
+	// MaxDepth skips directories more than MaxDepth levels below the root.
+	// Zero means no limit.
+	MaxDepth int
+	// ScopedOnly keeps only directories that have vars, clears or aliases,
+	// found with one query before the chain is loaded.
+	ScopedOnly bool
 }
 
 // NewResolver creates a new resolver.
-func NewResolver(database *db.DB, profile string) *Resolver {
+func NewResolver(database db.Store, profile string) *Resolver {
 	if profile == "" {
 		profile = DefaultProfile
 	}
-	return &Resolver{db: database, profile: profile}
+	return &Resolver{db: database, profile: profile, host: CurrentHost()}
+}
+
+// SetHost sets the host whose host-limited vars apply; "" applies none.
+func (r *Resolver) SetHost(host string) {
+	r.host = host
+}
+
+// Host returns the host whose host-limited vars apply.
+func (r *Resolver) Host() string {
+	return r.host
+}
+
+// SetChainOptions sets the limits applied to resolution chains.
+func (r *Resolver) SetChainOptions(opts ChainOptions) {
+	r.chain = opts
+}
+
+// ChainOptions returns the limits applied to resolution chains.
+func (r *Resolver) ChainOptions() ChainOptions {
+	return r.chain
+}
+
+// SetEnvFiles makes each directory in the chain also read vars from its
+// .env files through read, below the vars stored at that directory. nil
+// turns this off.
+func (r *Resolver) SetEnvFiles(read EnvFileReader) {
+	r.files = read
+}
+
+// EnvFiles returns the reader set with SetEnvFiles, or nil.
+func (r *Resolver) EnvFiles() EnvFileReader {
+	return r.files
 }
 
 // GetProfile returns the active profile.
@@ -56,6 +220,28 @@ type ResolveContext struct {
 	Chain    []string
 	Resolved map[string]*ResolvedVar
 	Profile  string
+	// Cleared lists keys to force-unset here (sorted). A var set deeper in
+	// the chain than the clear takes precedence over it.
+	Cleared []string
+	// Aliases holds shell aliases by name; the closest scope wins.
+	Aliases map[string]*ResolvedAlias
+	// Tasks holds the commands enva run can run by name; the closest scope
+	// wins.
+	Tasks map[string]*ResolvedTask
+}
+
+// ResolvedAlias is a shell alias with the scope that defines it.
+type ResolvedAlias struct {
+	Name          string
+	Command       string
+	DefinedAtPath string
+}
+
+// ResolvedTask is a named command with the scope that defines it.
+type ResolvedTask struct {
+	Name          string
+	Command       string
+	DefinedAtPath string
 }
 
 // Resolve resolves environment variables for the given directory.
@@ -66,20 +252,73 @@ func (r *Resolver) Resolve(cwd string) (*ResolveContext, error) {
 		return nil, err
 	}
 
-	// Find root
-	rootDir, err := envpath.FindRoot(cwdReal)
+	// Find root and build chain (cwdReal is already canonical)
+	rootDir, marker := envpath.FindRootCanonical(cwdReal)
+	if err := envpath.CheckRoot(marker); err != nil {
+		return nil, err
+	}
+
+	// Stores that keep merged results answer without re-merging the chain.
+	// Files change without the database noticing, so merges that read
+	// them are never cached, nor served from the cache.
+	cache, _ := r.db.(db.ResolveCache)
+	if r.files != nil {
+		cache = nil
+	}
+	cacheRoot := rootDir
+	if r.chain != (ChainOptions{}) {
+		// Results merged under different limits mustn't be mixed up
+		cacheRoot = fmt.Sprintf("%s\x00depth=%d,scoped=%t", rootDir, r.chain.MaxDepth, r.chain.ScopedOnly)
+	}
+	if r.host != "" {
+		// Nor may results for different hosts sharing a database
+		cacheRoot += "\x00host=" + r.host
+	}
+	var generation int64
+	if cache != nil {
+		data, gen, err := cache.GetResolved(cwdReal, r.profile, cacheRoot)
+		if err == nil && data != nil {
+			var ctx ResolveContext
+			if json.Unmarshal(data, &ctx) == nil {
+				return &ctx, nil
+			}
+		}
+		if err != nil {
+			cache = nil
+		}
+		generation = gen
+	}
+
+	ctx, err := r.merge(cwdReal, rootDir)
 	if err != nil {
 		return nil, err
 	}
+	if cache != nil {
+		if data, err := json.Marshal(ctx); err == nil {
+			cache.PutResolved(cwdReal, r.profile, cacheRoot, generation, data) // Best effort
+		}
+	}
+	return ctx, nil
+}
 
-	// Build chain
-	chain, err := envpath.BuildChain(rootDir, cwdReal)
+// merge loads the chain from rootDir to cwdReal and merges it.
+func (r *Resolver) merge(cwdReal, rootDir string) (*ResolveContext, error) {
+	chain, err := r.buildChain(rootDir, cwdReal)
 	if err != nil {
 		return nil, err
 	}
 
+	// The global scope comes first, below the root, and vars limited to
+	// this host come last, above the whole chain
+	paths := append([]string{GlobalScope}, chain...)
+	if r.host != "" {
+		for _, p := range append([]string{GlobalScope}, chain...) {
+			paths = append(paths, HostScope(p, r.host))
+		}
+	}
+
 	// Load vars for all chain paths
-	allVars, err := r.db.GetVarsForPaths(chain, r.profile)
+	allVars, err := r.db.GetVarsForPaths(paths, r.profile)
 	if err != nil {
 		return nil, err
 	}
@@ -88,20 +327,79 @@ func (r *Resolver) Resolve(cwd string) (*ResolveContext, error) {
 	type varInfo struct {
 		Value       string
 		Description string
+		IfUnset     bool
+		Eval        bool
+		Refresh     int
+		NoExport    bool
+		Fallback    string
+		Type        string
+		Author      string
+		UpdatedAt   time.Time
+		CreatedAt   time.Time
+		File        string
 	}
 	varsByPath := make(map[string]map[string]varInfo)
 	for _, v := range allVars {
 		if varsByPath[v.Path] == nil {
 			varsByPath[v.Path] = make(map[string]varInfo)
 		}
-		varsByPath[v.Path][v.Key] = varInfo{Value: v.Value, Description: v.Description}
+		varsByPath[v.Path][v.Key] = varInfo{
+			Value:       v.Value,
+			Description: v.Description,
+			IfUnset:     v.IfUnset,
+			Eval:        v.Eval,
+			Refresh:     v.Refresh,
+			NoExport:    v.NoExport,
+			Fallback:    v.Fallback,
+			Type:        v.Type,
+			Author:      v.Author,
+			UpdatedAt:   v.UpdatedAt,
+			CreatedAt:   v.CreatedAt,
+		}
+	}
+
+	// Values in a directory's .env files sit just below those stored there
+	if r.files != nil {
+		for _, dir := range chain {
+			fileVars, files, err := r.files(dir)
+			if err != nil {
+				return nil, err
+			}
+			for key, value := range fileVars {
+				if _, ok := varsByPath[dir][key]; ok {
+					continue
+				}
+				if varsByPath[dir] == nil {
+					varsByPath[dir] = make(map[string]varInfo)
+				}
+				varsByPath[dir][key] = varInfo{Value: value, File: files[key]}
+			}
+		}
+	}
+
+	// Load force-unset keys for all chain paths
+	allClears, err := r.db.GetClearsForPaths(paths, r.profile)
+	if err != nil {
+		return nil, err
+	}
+	clearsByPath := make(map[string][]string)
+	for _, c := range allClears {
+		clearsByPath[c.Path] = append(clearsByPath[c.Path], c.Key)
 	}
 
 	// Merge in chain order (parent first, child overrides)
 	resolved := make(map[string]*ResolvedVar)
-	for _, path := range chain {
+	cleared := make(map[string]bool)
+	for _, path := range paths {
+		// Clears drop inherited values; vars at the same scope still apply
+		for _, key := range clearsByPath[path] {
+			delete(resolved, key)
+			cleared[key] = true
+		}
+
 		pathVars := varsByPath[path]
 		for key, info := range pathVars {
+			delete(cleared, key)
 			if existing, ok := resolved[key]; ok {
 				// Override
 				resolved[key] = &ResolvedVar{
@@ -111,6 +409,16 @@ func (r *Resolver) Resolve(cwd string) (*ResolveContext, error) {
 					DefinedAtPath: path,
 					Overrode:      true,
 					OverrodePath:  existing.DefinedAtPath,
+					IfUnset:       info.IfUnset,
+					Eval:          info.Eval,
+					Refresh:       info.Refresh,
+					NoExport:      info.NoExport,
+					Fallback:      info.Fallback,
+					Type:          info.Type,
+					Author:        info.Author,
+					UpdatedAt:     info.UpdatedAt,
+					CreatedAt:     info.CreatedAt,
+					File:          info.File,
 				}
 			} else {
 				resolved[key] = &ResolvedVar{
@@ -119,20 +427,135 @@ func (r *Resolver) Resolve(cwd string) (*ResolveContext, error) {
 					Description:   info.Description,
 					DefinedAtPath: path,
 					Overrode:      false,
+					IfUnset:       info.IfUnset,
+					Eval:          info.Eval,
+					Refresh:       info.Refresh,
+					NoExport:      info.NoExport,
+					Fallback:      info.Fallback,
+					Type:          info.Type,
+					Author:        info.Author,
+					UpdatedAt:     info.UpdatedAt,
+					CreatedAt:     info.CreatedAt,
+					File:          info.File,
 				}
 			}
 		}
 	}
 
+	// Attach tags from the scope each var is defined at
+	allTags, err := r.db.GetTagsForPaths(paths, r.profile)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range allTags {
+		if v, ok := resolved[t.Key]; ok && v.DefinedAtPath == t.Path {
+			v.Tags = append(v.Tags, t.Tag)
+		}
+	}
+
+	// Aliases merge like vars: parent first, child overrides
+	allAliases, err := r.db.GetAliasesForPaths(paths, r.profile)
+	if err != nil {
+		return nil, err
+	}
+	depth := make(map[string]int, len(paths))
+	for i, path := range paths {
+		depth[path] = i
+	}
+	var aliases map[string]*ResolvedAlias
+	if len(allAliases) > 0 {
+		aliases = make(map[string]*ResolvedAlias)
+		for _, a := range allAliases {
+			if existing, ok := aliases[a.Name]; ok && depth[existing.DefinedAtPath] > depth[a.Path] {
+				continue
+			}
+			aliases[a.Name] = &ResolvedAlias{Name: a.Name, Command: a.Command, DefinedAtPath: a.Path}
+		}
+	}
+
+	// So do tasks
+	allTasks, err := r.db.GetTasksForPaths(paths, r.profile)
+	if err != nil {
+		return nil, err
+	}
+	var tasks map[string]*ResolvedTask
+	if len(allTasks) > 0 {
+		tasks = make(map[string]*ResolvedTask)
+		for _, t := range allTasks {
+			if existing, ok := tasks[t.Name]; ok && depth[existing.DefinedAtPath] > depth[t.Path] {
+				continue
+			}
+			tasks[t.Name] = &ResolvedTask{Name: t.Name, Command: t.Command, DefinedAtPath: t.Path}
+		}
+	}
+
+	clearedKeys := make([]string, 0, len(cleared))
+	for key := range cleared {
+		clearedKeys = append(clearedKeys, key)
+	}
+	sort.Strings(clearedKeys)
+
 	return &ResolveContext{
 		CwdReal:  cwdReal,
 		RootDir:  rootDir,
 		Chain:    chain,
 		Resolved: resolved,
 		Profile:  r.profile,
+		Cleared:  clearedKeys,
+		Aliases:  aliases,
+		Tasks:    tasks,
 	}, nil
 }
 
+// buildChain builds the chain from rootDir to cwdReal with the resolver's
+// ChainOptions applied.
+func (r *Resolver) buildChain(rootDir, cwdReal string) ([]string, error) {
+	chain := envpath.BuildChainCanonical(rootDir, cwdReal)
+	last := len(chain) - 1
+
+	if depth := r.chain.MaxDepth; depth > 0 && last > depth+1 {
+		// Keep root plus depth levels below it, and cwd
+		chain = append(chain[:depth+1:depth+1], chain[last])
+		last = len(chain) - 1
+	}
+
+	if r.chain.ScopedOnly && last > 1 {
+		scoped, err := r.db.PathsWithScopes(chain[1:last], r.profile)
+		if err != nil {
+			return nil, err
+		}
+		kept := make([]string, 0, len(scoped)+2)
+		kept = append(kept, chain[0])
+		kept = append(kept, scoped...)
+		chain = append(kept, chain[last])
+	}
+	return chain, nil
+}
+
+// GetSortedAliases returns resolved aliases sorted by name.
+func (ctx *ResolveContext) GetSortedAliases() []*ResolvedAlias {
+	aliases := make([]*ResolvedAlias, 0, len(ctx.Aliases))
+	for _, a := range ctx.Aliases {
+		aliases = append(aliases, a)
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Name < aliases[j].Name
+	})
+	return aliases
+}
+
+// GetSortedTasks returns resolved tasks sorted by name.
+func (ctx *ResolveContext) GetSortedTasks() []*ResolvedTask {
+	tasks := make([]*ResolvedTask, 0, len(ctx.Tasks))
+	for _, t := range ctx.Tasks {
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Name < tasks[j].Name
+	})
+	return tasks
+}
+
 // GetSortedVars returns resolved vars sorted by key.
 func (ctx *ResolveContext) GetSortedVars() []*ResolvedVar {
 	vars := make([]*ResolvedVar, 0, len(ctx.Resolved))
@@ -145,6 +568,32 @@ func (ctx *ResolveContext) GetSortedVars() []*ResolvedVar {
 	return vars
 }
 
+// ApplicableVars returns the sorted vars to apply on top of an ambient
+// environment. Vars marked IfUnset are skipped when lookup finds the key,
+// unless owned reports that enva itself set it (e.g. on a previous prompt).
+func (ctx *ResolveContext) ApplicableVars(lookup func(string) (string, bool), owned map[string]bool) []*ResolvedVar {
+	var vars []*ResolvedVar
+	for _, v := range ctx.GetSortedVars() {
+		if v.IfUnset && !owned[v.Key] {
+			if _, present := lookup(v.Key); present {
+				continue
+			}
+		}
+		vars = append(vars, v)
+	}
+	return vars
+}
+
+// StripNoExport removes the vars marked NoExport from ctx, before it is
+// exported to a shell or command.
+func (ctx *ResolveContext) StripNoExport() {
+	for key, v := range ctx.Resolved {
+		if v.NoExport {
+			delete(ctx.Resolved, key)
+		}
+	}
+}
+
 // GetLocalVars returns only vars defined at cwdReal.
 func (ctx *ResolveContext) GetLocalVars() []*ResolvedVar {
 	var vars []*ResolvedVar
@@ -164,27 +613,115 @@ func (ctx *ResolveContext) IsLocal(v *ResolvedVar) bool {
 	return v.DefinedAtPath == ctx.CwdReal
 }
 
+// VarSource classifies where a resolved var comes from relative to cwd.
+type VarSource int
+
+const (
+	SourceLocal     VarSource = iota // Defined at cwd, not shadowing a parent
+	SourceInherited                  // Defined at an ancestor
+	SourceOverride                   // Defined at cwd, shadowing a parent
+)
+
+// SourceOf returns the source classification of a resolved var.
+func (ctx *ResolveContext) SourceOf(v *ResolvedVar) VarSource {
+	if v.DefinedAtPath != ctx.CwdReal {
+		return SourceInherited
+	}
+	if v.Overrode {
+		return SourceOverride
+	}
+	return SourceLocal
+}
+
 // GetLocalVarsFromDB retrieves local vars directly from the database.
 func (r *Resolver) GetLocalVarsFromDB(path string) ([]db.EnvVar, error) {
-	canonical, err := envpath.Canonicalize(path)
+	canonical, err := canonicalScope(path)
 	if err != nil {
 		return nil, err
 	}
 	return r.db.GetVarsForPath(canonical, r.profile)
 }
 
+// GetVar returns the variable stored for key at path, or nil if none is.
+func (r *Resolver) GetVar(path, key string) (*db.EnvVar, error) {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.db.GetVar(canonical, r.profile, key)
+}
+
 // SetVar sets a variable at the given path.
 func (r *Resolver) SetVar(path, key, value, description string) error {
-	canonical, err := envpath.Canonicalize(path)
+	canonical, err := canonicalScope(path)
 	if err != nil {
 		return err
 	}
 	return r.db.SetVar(canonical, r.profile, key, value, description)
 }
 
+// SetIfUnset marks a variable at path as a default or a regular override.
+func (r *Resolver) SetIfUnset(path, key string, ifUnset bool) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.SetIfUnset(canonical, r.profile, key, ifUnset)
+}
+
+// SetEval marks a variable at path as a command to run at export time or as
+// a literal value.
+func (r *Resolver) SetEval(path, key string, eval bool) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.SetEval(canonical, r.profile, key, eval)
+}
+
+// SetRefresh sets how many seconds the output of an eval variable at path
+// is reused.
+func (r *Resolver) SetRefresh(path, key string, seconds int) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.SetRefresh(canonical, r.profile, key, seconds)
+}
+
+// SetNoExport marks a variable at path as kept out of exports, or as
+// exported again.
+func (r *Resolver) SetNoExport(path, key string, noExport bool) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.SetNoExport(canonical, r.profile, key, noExport)
+}
+
+// SetType declares the value type of a variable at path; "" makes it a
+// plain string.
+func (r *Resolver) SetType(path, key, valueType string) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.SetType(canonical, r.profile, key, valueType)
+}
+
+// SetFallback sets what an eval variable at path exports when its command
+// fails; "" restores not exporting it.
+func (r *Resolver) SetFallback(path, key, fallback string) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.SetFallback(canonical, r.profile, key, fallback)
+}
+
 // DeleteVar deletes a variable at the given path.
 func (r *Resolver) DeleteVar(path, key string) error {
-	canonical, err := envpath.Canonicalize(path)
+	canonical, err := canonicalScope(path)
 	if err != nil {
 		return err
 	}
@@ -193,7 +730,7 @@ func (r *Resolver) DeleteVar(path, key string) error {
 
 // SetVarsBatch sets multiple variables at the given path.
 func (r *Resolver) SetVarsBatch(path string, vars map[string]db.VarData) error {
-	canonical, err := envpath.Canonicalize(path)
+	canonical, err := canonicalScope(path)
 	if err != nil {
 		return err
 	}
@@ -202,16 +739,41 @@ func (r *Resolver) SetVarsBatch(path string, vars map[string]db.VarData) error {
 
 // DeleteVarsBatch deletes multiple variables at the given path.
 func (r *Resolver) DeleteVarsBatch(path string, keys []string) error {
-	canonical, err := envpath.Canonicalize(path)
+	canonical, err := canonicalScope(path)
 	if err != nil {
 		return err
 	}
 	return r.db.DeleteVarsBatch(canonical, r.profile, keys)
 }
 
+// ApplyChanges applies moves, sets and deletions across scopes in the
+// resolver's profile as a single transaction. Each change's Path and move
+// destinations are canonicalized and its Profile ignored.
+func (r *Resolver) ApplyChanges(changes []db.ScopeChange) error {
+	canon := make([]db.ScopeChange, len(changes))
+	for i, c := range changes {
+		path, err := canonicalScope(c.Path)
+		if err != nil {
+			return err
+		}
+		c.Path, c.Profile = path, r.profile
+		if len(c.Move) > 0 {
+			moves := make(map[string]string, len(c.Move))
+			for key, dst := range c.Move {
+				if moves[key], err = canonicalScope(dst); err != nil {
+					return err
+				}
+			}
+			c.Move = moves
+		}
+		canon[i] = c
+	}
+	return r.db.ApplyChanges(canon)
+}
+
 // SyncLocalVars synchronizes local vars: adds/updates from newVars, deletes keys not in newVars.
 func (r *Resolver) SyncLocalVars(path string, newVars map[string]db.VarData) error {
-	canonical, err := envpath.Canonicalize(path)
+	canonical, err := canonicalScope(path)
 	if err != nil {
 		return err
 	}
@@ -246,3 +808,224 @@ func (r *Resolver) SyncLocalVars(path string, newVars map[string]db.VarData) err
 
 	return nil
 }
+
+// MoveVar moves key from srcPath in the active profile to dstPath in
+// dstProfile (the active profile if empty). With copy the source is kept.
+func (r *Resolver) MoveVar(srcPath, key, dstPath, dstProfile string, copy, overwrite bool) error {
+	src, err := canonicalScope(srcPath)
+	if err != nil {
+		return err
+	}
+	dst, err := canonicalScope(dstPath)
+	if err != nil {
+		return err
+	}
+	if dstProfile == "" {
+		dstProfile = r.profile
+	}
+	if src == dst && dstProfile == r.profile {
+		return fmt.Errorf("source and destination are the same")
+	}
+	return r.db.MoveVar(src, r.profile, dst, dstProfile, key, copy, overwrite)
+}
+
+// AddTag attaches tag to the var key defined at path.
+func (r *Resolver) AddTag(path, key, tag string) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.AddTag(canonical, r.profile, key, tag)
+}
+
+// RemoveTag detaches tag from the var key defined at path.
+func (r *Resolver) RemoveTag(path, key, tag string) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.RemoveTag(canonical, r.profile, key, tag)
+}
+
+// AddClear marks key as force-unset when entering path.
+func (r *Resolver) AddClear(path, key string) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.AddClear(canonical, r.profile, key)
+}
+
+// SetAlias defines a shell alias at path.
+func (r *Resolver) SetAlias(path, name, command string) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.SetAlias(canonical, r.profile, name, command)
+}
+
+// DeleteAlias removes a shell alias at path.
+func (r *Resolver) DeleteAlias(path, name string) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.DeleteAlias(canonical, r.profile, name)
+}
+
+// SetTask defines a task at path.
+func (r *Resolver) SetTask(path, name, command string) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.SetTask(canonical, r.profile, name, command)
+}
+
+// DeleteTask removes a task at path.
+func (r *Resolver) DeleteTask(path, name string) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.DeleteTask(canonical, r.profile, name)
+}
+
+// RemoveClear removes a force-unset key at path.
+func (r *Resolver) RemoveClear(path, key string) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	return r.db.RemoveClear(canonical, r.profile, key)
+}
+
+// ScopePolicy returns the policy stored at the scope at path itself,
+// ignoring its ancestors; the zero policy if none is.
+func (r *Resolver) ScopePolicy(path string) (policy.Policy, error) {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return policy.Policy{}, err
+	}
+	stored, err := r.db.GetScopePolicies([]string{canonical})
+	if err != nil {
+		return policy.Policy{}, err
+	}
+	return policy.Decode(stored[canonical])
+}
+
+// SetPolicy stores the naming policy for the scope at path.
+func (r *Resolver) SetPolicy(path string, p policy.Policy) error {
+	canonical, err := canonicalScope(path)
+	if err != nil {
+		return err
+	}
+	encoded, err := p.Encode()
+	if err != nil {
+		return err
+	}
+	return r.db.SetScopePolicy(canonical, encoded)
+}
+
+// EffectivePolicy returns the policy that applies at path, merged along the
+// chain: banned keys from every scope, the prefix and case rule from the
+// closest scope with either, and the secrets mode from the closest scope
+// with one. Returns the closest scope with a policy, or "" if none applies.
+func (r *Resolver) EffectivePolicy(path string) (policy.Policy, string, error) {
+	path, _ = SplitHost(path) // Host-limited vars follow their directory's policy
+	chain := []string{GlobalScope}
+	if path != GlobalScope {
+		canonical, err := envpath.Canonicalize(path)
+		if err != nil {
+			return policy.Policy{}, "", err
+		}
+		rootDir, marker := envpath.FindRootCanonical(canonical)
+		if err := envpath.CheckRoot(marker); err != nil {
+			return policy.Policy{}, "", err
+		}
+		chain = envpath.BuildChainCanonical(rootDir, canonical)
+	}
+
+	stored, err := r.db.GetScopePolicies(chain)
+	if err != nil {
+		return policy.Policy{}, "", err
+	}
+
+	// Banned keys add up; the closest scope with naming rules sets the
+	// prefix and case, and the closest with a secrets mode sets that
+	var eff policy.Policy
+	var at string
+	var naming, secrets bool
+	for i := len(chain) - 1; i >= 0; i-- {
+		s, ok := stored[chain[i]]
+		if !ok {
+			continue
+		}
+		p, err := policy.Decode(s)
+		if err != nil {
+			return policy.Policy{}, "", err
+		}
+		if at == "" && !p.IsZero() {
+			at = chain[i]
+		}
+		if !naming && (p.Prefix != "" || p.ScreamingSnake) {
+			eff.Prefix, eff.ScreamingSnake = p.Prefix, p.ScreamingSnake
+			naming = true
+		}
+		if !secrets && p.Secrets != "" {
+			eff.Secrets = p.Secrets
+			secrets = true
+		}
+		for _, b := range p.Banned {
+			if !slices.Contains(eff.Banned, b) {
+				eff.Banned = append(eff.Banned, b)
+			}
+		}
+	}
+	return eff, at, nil
+}
+
+// CheckKeys validates keys against the effective policy at path.
+func (r *Resolver) CheckKeys(path string, keys ...string) error {
+	p, _, err := r.EffectivePolicy(path)
+	if err != nil {
+		return err
+	}
+	if errs := p.CheckAll(keys); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// CheckSecrets scans values for credential-like patterns according to the
+// effective policy at path. In warn mode it returns one warning per affected
+// key; in block mode it returns an error instead.
+func (r *Resolver) CheckSecrets(path string, values map[string]string) ([]string, error) {
+	p, _, err := r.EffectivePolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	mode := p.SecretsMode()
+	if mode == policy.SecretsOff {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var warnings []string
+	for _, k := range keys {
+		for _, f := range secrets.Scan(values[k]) {
+			warnings = append(warnings, fmt.Sprintf("%s looks like a credential (%s) and will be stored unencrypted", k, f.Kind))
+		}
+	}
+
+	if mode == policy.SecretsBlock && len(warnings) > 0 {
+		return nil, &policy.Violation{Reason: "refusing to store credential-like values (secrets policy is block): " + strings.Join(warnings, "; ")}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,60 @@
+package generate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValue(t *testing.T) {
+	Rand = bytes.NewReader(bytes.Repeat([]byte{0xff}, 4096))
+	Now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 6e6, time.FixedZone("X", 3600)) }
+	t.Cleanup(func() {
+		Rand = rand.Reader
+		Now = time.Now
+	})
+
+	tests := []struct{ spec, want string }{
+		{"hex:4", "ffffffff"},
+		{"base64:3", "////"},
+		{"secret:3", "____"},
+		{"uuid", "ffffffff-ffff-4fff-bfff-ffffffffffff"},
+		{"timestamp", "2026-01-02T02:04:05Z"},
+		{"timestamp:unix", "1767319445"},
+		{"timestamp:unixms", "1767319445006"},
+	}
+	for _, tt := range tests {
+		got, err := Value(tt.spec)
+		if err != nil || got != tt.want {
+			t.Errorf("Value(%q) = %q, %v; want %q", tt.spec, got, err, tt.want)
+		}
+	}
+
+	if got, _ := Value("secret"); len(got) != base64.RawURLEncoding.EncodedLen(DefaultBytes) {
+		t.Errorf("Value(secret) has length %d", len(got))
+	}
+
+	for _, spec := range []string{"secret:0", "hex:x", "base64:5000", "uuid:v7", "timestamp:iso", "password", ""} {
+		if _, err := Value(spec); err == nil {
+			t.Errorf("Value(%q) should fail", spec)
+		}
+	}
+}
+
+func TestValueRandom(t *testing.T) {
+	a, _ := Value("uuid")
+	b, _ := Value("uuid")
+	if a == b {
+		t.Errorf("two UUIDs are equal: %s", a)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(a) {
+		t.Errorf("not a v4 UUID: %s", a)
+	}
+	if s, _ := Value("hex:8"); len(s) != 16 || strings.Trim(s, "0123456789abcdef") != "" {
+		t.Errorf("Value(hex:8) = %q", s)
+	}
+}
@@ -0,0 +1,196 @@
+// Package graph describes how the scopes under a project root layer their
+// variables, as Graphviz DOT or Mermaid for documentation. Each scope is a
+// node listing the keys it defines; scopes are linked to the nearest scope
+// above them, and a dashed edge marks the keys a scope overrides there.
+// Values are never included.
+package graph
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nick-skriabin/enva/internal/db"
+)
+
+// Scope is a directory that defines variables.
+type Scope struct {
+	Path   string
+	Parent string   // Nearest scope above, "" for the top one
+	Keys   []string // Sorted
+}
+
+// Override is a set of keys a scope redefines from an ancestor scope.
+type Override struct {
+	From string // The overriding scope
+	To   string // The scope whose keys it overrides
+	Keys []string
+}
+
+// Graph is the scopes under Root and their overrides.
+type Graph struct {
+	Root      string
+	Scopes    []Scope // Sorted by path, so parents come first
+	Overrides []Override
+}
+
+// Build makes the graph of vars' scopes under root; vars elsewhere are
+// ignored. Root is always included, even if it defines nothing.
+func Build(root string, vars []db.EnvVar) *Graph {
+	keys := map[string][]string{root: nil}
+	for _, v := range vars {
+		if within(root, v.Path) {
+			keys[v.Path] = append(keys[v.Path], v.Key)
+		}
+	}
+	paths := make([]string, 0, len(keys))
+	for p := range keys {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	g := &Graph{Root: root}
+	defined := make(map[string]map[string]bool, len(paths))
+	for _, p := range paths {
+		sort.Strings(keys[p])
+		defined[p] = make(map[string]bool, len(keys[p]))
+		for _, k := range keys[p] {
+			defined[p][k] = true
+		}
+
+		sc := Scope{Path: p, Keys: keys[p]}
+		if p != root {
+			sc.Parent = nearest(paths, p)
+		}
+		g.Scopes = append(g.Scopes, sc)
+	}
+
+	// Each key overrides the closest ancestor scope that defines it
+	parents := make(map[string]string, len(g.Scopes))
+	for _, sc := range g.Scopes {
+		parents[sc.Path] = sc.Parent
+	}
+	for _, sc := range g.Scopes {
+		byTarget := make(map[string][]string)
+		for _, k := range sc.Keys {
+			for a := sc.Parent; a != ""; a = parents[a] {
+				if defined[a][k] {
+					byTarget[a] = append(byTarget[a], k)
+					break
+				}
+			}
+		}
+		targets := make([]string, 0, len(byTarget))
+		for t := range byTarget {
+			targets = append(targets, t)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(targets)))
+		for _, t := range targets {
+			g.Overrides = append(g.Overrides, Override{From: sc.Path, To: t, Keys: byTarget[t]})
+		}
+	}
+	return g
+}
+
+// within reports whether p is dir or below it.
+func within(dir, p string) bool {
+	if p == dir {
+		return true
+	}
+	prefix := dir
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return strings.HasPrefix(p, prefix)
+}
+
+// nearest returns the closest proper ancestor of p in sorted paths.
+func nearest(paths []string, p string) string {
+	best := ""
+	for _, a := range paths {
+		if a != p && within(a, p) && len(a) > len(best) {
+			best = a
+		}
+	}
+	return best
+}
+
+// label is a scope's name relative to the root, such as "services/api".
+func (g *Graph) label(p string) string {
+	if p == g.Root {
+		return filepath.Base(g.Root) + " (root)"
+	}
+	rel, err := filepath.Rel(g.Root, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+// DOT renders the graph for Graphviz, e.g. `enva graph | dot -Tsvg`.
+func (g *Graph) DOT() string {
+	ids := g.ids()
+	var b strings.Builder
+	b.WriteString("digraph enva {\n")
+	b.WriteString("  rankdir=TB;\n")
+	b.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	for _, sc := range g.Scopes {
+		lines := append([]string{g.label(sc.Path)}, sc.Keys...)
+		for i := range lines {
+			lines[i] = dotEscape(lines[i])
+		}
+		fmt.Fprintf(&b, "  %s [label=\"%s\\l\"];\n", ids[sc.Path], strings.Join(lines, "\\l"))
+	}
+	for _, sc := range g.Scopes {
+		if sc.Parent != "" {
+			fmt.Fprintf(&b, "  %s -> %s;\n", ids[sc.Parent], ids[sc.Path])
+		}
+	}
+	for _, o := range g.Overrides {
+		fmt.Fprintf(&b, "  %s -> %s [style=dashed, color=red, label=\"%s\"];\n",
+			ids[o.From], ids[o.To], dotEscape(strings.Join(o.Keys, "\n")))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart, e.g. for a README.
+func (g *Graph) Mermaid() string {
+	ids := g.ids()
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	for _, sc := range g.Scopes {
+		lines := append([]string{"<b>" + mermaidEscape(g.label(sc.Path)) + "</b>"}, sc.Keys...)
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", ids[sc.Path], strings.Join(lines, "<br/>"))
+	}
+	for _, sc := range g.Scopes {
+		if sc.Parent != "" {
+			fmt.Fprintf(&b, "  %s --> %s\n", ids[sc.Parent], ids[sc.Path])
+		}
+	}
+	for _, o := range g.Overrides {
+		fmt.Fprintf(&b, "  %s -. \"overrides %s\" .-> %s\n", ids[o.From], strings.Join(o.Keys, ", "), ids[o.To])
+	}
+	return b.String()
+}
+
+// ids names the scopes s0, s1, ... in path order.
+func (g *Graph) ids() map[string]string {
+	ids := make(map[string]string, len(g.Scopes))
+	for i, sc := range g.Scopes {
+		ids[sc.Path] = fmt.Sprintf("s%d", i)
+	}
+	return ids
+}
+
+// dotEscape escapes s for a double-quoted DOT string; newlines become
+// centered line breaks.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// mermaidEscape escapes the characters Mermaid would read as markup.
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(s)
+}
@@ -0,0 +1,74 @@
+package graph
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nick-skriabin/enva/internal/db"
+)
+
+func testVars() []db.EnvVar {
+	return []db.EnvVar{
+		{Path: "/repo", Key: "DB_URL", Value: "postgres://secret@db"},
+		{Path: "/repo", Key: "LOG_LEVEL", Value: "info"},
+		{Path: "/repo/services/api", Key: "LOG_LEVEL", Value: "debug"},
+		{Path: "/repo/services/api/v2", Key: "DB_URL", Value: "x"},
+		{Path: "/repo/services/api/v2", Key: "LOG_LEVEL", Value: "warn"},
+		{Path: "/repo-other", Key: "OTHER", Value: "1"},
+		{Path: "/elsewhere", Key: "OTHER", Value: "1"},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	g := Build("/repo", testVars())
+
+	want := []Scope{
+		{Path: "/repo", Keys: []string{"DB_URL", "LOG_LEVEL"}},
+		{Path: "/repo/services/api", Parent: "/repo", Keys: []string{"LOG_LEVEL"}},
+		{Path: "/repo/services/api/v2", Parent: "/repo/services/api", Keys: []string{"DB_URL", "LOG_LEVEL"}},
+	}
+	if !reflect.DeepEqual(g.Scopes, want) {
+		t.Errorf("Scopes = %+v, want %+v", g.Scopes, want)
+	}
+
+	wantOverrides := []Override{
+		{From: "/repo/services/api", To: "/repo", Keys: []string{"LOG_LEVEL"}},
+		{From: "/repo/services/api/v2", To: "/repo/services/api", Keys: []string{"LOG_LEVEL"}},
+		{From: "/repo/services/api/v2", To: "/repo", Keys: []string{"DB_URL"}},
+	}
+	if !reflect.DeepEqual(g.Overrides, wantOverrides) {
+		t.Errorf("Overrides = %+v, want %+v", g.Overrides, wantOverrides)
+	}
+}
+
+func TestBuildEmptyRoot(t *testing.T) {
+	g := Build("/repo", []db.EnvVar{{Path: "/repo/a", Key: "K"}})
+	if len(g.Scopes) != 2 || g.Scopes[0].Path != "/repo" || g.Scopes[1].Parent != "/repo" {
+		t.Errorf("Scopes = %+v", g.Scopes)
+	}
+}
+
+func TestRender(t *testing.T) {
+	g := Build("/repo", testVars())
+
+	dot := g.DOT()
+	for _, s := range []string{"digraph enva {", `label="repo (root)\lDB_URL\lLOG_LEVEL\l"`, "s0 -> s1;", "s2 -> s0 [style=dashed"} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("DOT missing %q:\n%s", s, dot)
+		}
+	}
+
+	mermaid := g.Mermaid()
+	for _, s := range []string{"flowchart TD", `s1["<b>services/api</b><br/>LOG_LEVEL"]`, "s0 --> s1", `s2 -. "overrides DB_URL" .-> s0`} {
+		if !strings.Contains(mermaid, s) {
+			t.Errorf("Mermaid missing %q:\n%s", s, mermaid)
+		}
+	}
+
+	for _, out := range []string{dot, mermaid} {
+		if strings.Contains(out, "secret") || strings.Contains(out, "debug") || strings.Contains(out, "OTHER") {
+			t.Errorf("output leaks values or other scopes:\n%s", out)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Package lint checks that connection settings make sense: that *_URL
+// values parse as URLs, *_PORT values are ports and *_HOST values are host
+// names, and that related keys in a scope, such as DB_URL, DB_HOST and
+// DB_PORT, agree with each other.
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Warning is a problem with one or more keys.
+type Warning struct {
+	Keys    []string // The keys involved, the first being the one at fault
+	Message string
+}
+
+// Kinds of key, by suffix.
+const (
+	kindURL  = "URL"
+	kindHost = "HOST"
+	kindPort = "PORT"
+)
+
+// defaultPorts are the ports implied by URL schemes that commonly appear in
+// connection strings.
+var defaultPorts = map[string]int{
+	"http":       80,
+	"https":      443,
+	"ws":         80,
+	"wss":        443,
+	"ftp":        21,
+	"postgres":   5432,
+	"postgresql": 5432,
+	"mysql":      3306,
+	"redis":      6379,
+	"rediss":     6379,
+	"amqp":       5672,
+	"amqps":      5671,
+	"mongodb":    27017,
+	"nats":       4222,
+}
+
+// hostlessSchemes may name a file or socket rather than a host.
+var hostlessSchemes = map[string]bool{"file": true, "unix": true, "sqlite": true, "sqlite3": true}
+
+// split returns the prefix and kind of a key such as DB_HOST ("DB", "HOST").
+// HOST, PORT and URL on their own have an empty prefix.
+func split(key string) (prefix, kind string, ok bool) {
+	for _, k := range []string{kindURL, kindHost, kindPort} {
+		if key == k {
+			return "", k, true
+		}
+		if p, found := strings.CutSuffix(key, "_"+k); found && p != "" {
+			return p, k, true
+		}
+	}
+	return "", "", false
+}
+
+// Check returns the problems with the vars of one scope, sorted by the key at
+// fault. Keys without a URL, HOST or PORT suffix are ignored.
+func Check(vars map[string]string) []Warning {
+	type group struct {
+		url, host, port string // Keys, if set and valid
+		u               *url.URL
+		hostName        string
+		portNum         int
+	}
+	groups := make(map[string]*group)
+	var warnings []Warning
+
+	for key, value := range vars {
+		prefix, kind, ok := split(key)
+		if !ok {
+			continue
+		}
+		g := groups[prefix]
+		if g == nil {
+			g = &group{}
+			groups[prefix] = g
+		}
+		switch kind {
+		case kindURL:
+			u, msg := checkURL(value)
+			if msg != "" {
+				warnings = append(warnings, Warning{Keys: []string{key}, Message: key + " " + msg})
+				continue
+			}
+			g.url, g.u = key, u
+		case kindHost:
+			if msg := checkHost(value); msg != "" {
+				warnings = append(warnings, Warning{Keys: []string{key}, Message: key + " " + msg})
+				continue
+			}
+			g.host, g.hostName = key, value
+		case kindPort:
+			n, msg := checkPort(value)
+			if msg != "" {
+				warnings = append(warnings, Warning{Keys: []string{key}, Message: key + " " + msg})
+				continue
+			}
+			g.port, g.portNum = key, n
+		}
+	}
+
+	for _, g := range groups {
+		if g.url == "" || g.u.Host == "" {
+			continue
+		}
+		if g.host != "" && !strings.EqualFold(g.u.Hostname(), g.hostName) {
+			warnings = append(warnings, Warning{
+				Keys:    []string{g.url, g.host},
+				Message: fmt.Sprintf("%s points at %s but %s is %s", g.url, g.u.Hostname(), g.host, g.hostName),
+			})
+		}
+		if g.port != "" {
+			if port, ok := urlPort(g.u); ok && port != g.portNum {
+				warnings = append(warnings, Warning{
+					Keys:    []string{g.url, g.port},
+					Message: fmt.Sprintf("%s uses port %d but %s is %d", g.url, port, g.port, g.portNum),
+				})
+			}
+		}
+	}
+
+	sort.Slice(warnings, func(i, j int) bool {
+		if warnings[i].Keys[0] != warnings[j].Keys[0] {
+			return warnings[i].Keys[0] < warnings[j].Keys[0]
+		}
+		return warnings[i].Message < warnings[j].Message
+	})
+	return warnings
+}
+
+// checkURL parses value as a URL with a scheme and, unless the scheme names
+// a file or socket, a host.
+func checkURL(value string) (*url.URL, string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, "isn't a valid URL: " + err.Error()
+	}
+	if u.Scheme == "" {
+		return nil, "has no scheme, such as https://"
+	}
+	if u.Opaque != "" {
+		// localhost:5432 parses as scheme "localhost"
+		return nil, "has no // after the scheme; host:port needs a scheme, such as https://"
+	}
+	if u.Host == "" && !hostlessSchemes[u.Scheme] {
+		return nil, "has no host"
+	}
+	if p := u.Port(); p != "" {
+		if _, msg := checkPort(p); msg != "" {
+			return nil, "port " + msg
+		}
+	}
+	return u, ""
+}
+
+// urlPort returns u's port, explicit or implied by its scheme.
+func urlPort(u *url.URL) (int, bool) {
+	if p := u.Port(); p != "" {
+		n, err := strconv.Atoi(p)
+		return n, err == nil
+	}
+	n, ok := defaultPorts[u.Scheme]
+	return n, ok
+}
+
+// checkHost accepts a host name or IP address without a scheme, port or
+// path.
+func checkHost(value string) string {
+	switch {
+	case strings.Contains(value, "://"):
+		return "looks like a URL, not a host name"
+	case strings.Contains(value, "/"):
+		return "has a path; a host name can't contain /"
+	case net.ParseIP(strings.Trim(value, "[]")) != nil:
+		return ""
+	case strings.Contains(value, ":"):
+		return "has a port; put it in the matching _PORT key"
+	case !isHostName(value):
+		return "isn't a valid host name"
+	}
+	return ""
+}
+
+// isHostName reports whether s is made of dot-separated labels of letters,
+// digits, hyphens and underscores that don't start or end with a hyphen.
+func isHostName(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkPort parses value as a TCP or UDP port.
+func checkPort(value string) (int, string) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, "isn't a port number"
+	}
+	if n < 1 || n > 65535 {
+		return 0, "is out of range (1-65535)"
+	}
+	return n, ""
+}
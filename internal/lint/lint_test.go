@@ -0,0 +1,56 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want []string // Substrings of the expected messages, in order
+	}{
+		{"coherent", map[string]string{
+			"DB_URL":  "postgres://db.internal:5432/app",
+			"DB_HOST": "DB.internal",
+			"DB_PORT": "5432",
+			"NAME":    "not checked",
+		}, nil},
+		{"default port", map[string]string{"API_URL": "https://api.example.com", "API_PORT": "8443"},
+			[]string{"API_URL uses port 443 but API_PORT is 8443"}},
+		{"host mismatch", map[string]string{"REDIS_URL": "redis://cache:6379", "REDIS_HOST": "localhost"},
+			[]string{"REDIS_URL points at cache but REDIS_HOST is localhost"}},
+		{"bare keys", map[string]string{"URL": "http://localhost:3000", "PORT": "3001"},
+			[]string{"URL uses port 3000 but PORT is 3001"}},
+		{"other prefixes aren't related", map[string]string{"DB_URL": "postgres://db/app", "API_HOST": "api"}, nil},
+		{"unknown scheme", map[string]string{"S3_URL": "s3://bucket/key", "S3_PORT": "9000"}, nil},
+		{"file url", map[string]string{"CACHE_URL": "sqlite:///tmp/cache.db"}, nil},
+		{"no scheme", map[string]string{"API_URL": "api.example.com/v1"}, []string{"API_URL has no scheme"}},
+		{"host:port", map[string]string{"DB_URL": "localhost:5432"}, []string{"DB_URL has no // after the scheme"}},
+		{"no host", map[string]string{"API_URL": "https:/v1"}, []string{"API_URL has no host"}},
+		{"bad url", map[string]string{"API_URL": "http://[::1"}, []string{"API_URL isn't a valid URL"}},
+		{"url port range", map[string]string{"API_URL": "http://localhost:70000"}, []string{"API_URL port is out of range"}},
+		{"bad port", map[string]string{"DB_PORT": "postgres"}, []string{"DB_PORT isn't a port number"}},
+		{"port range", map[string]string{"DB_PORT": "0"}, []string{"DB_PORT is out of range (1-65535)"}},
+		{"host with scheme", map[string]string{"DB_HOST": "postgres://db"}, []string{"DB_HOST looks like a URL"}},
+		{"host with port", map[string]string{"DB_HOST": "db:5432"}, []string{"DB_HOST has a port"}},
+		{"host with space", map[string]string{"DB_HOST": "my db"}, []string{"DB_HOST isn't a valid host name"}},
+		{"ip hosts", map[string]string{"A_HOST": "10.0.0.1", "B_HOST": "::1", "C_HOST": "[fe80::1]"}, nil},
+		{"sorted", map[string]string{"B_PORT": "x", "A_PORT": "y"}, []string{"A_PORT", "B_PORT"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Check(tt.vars)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Check = %+v, want %d warning(s)", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i].Message, want) {
+					t.Errorf("warning %d = %q, want it to contain %q", i, got[i].Message, want)
+				}
+			}
+		})
+	}
+}
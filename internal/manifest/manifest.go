@@ -0,0 +1,95 @@
+// Package manifest reads the keys a project requires. They are listed in
+// the project's .enva marker file, one per line with an optional
+// description, so a checkout tells newcomers what to set:
+//
+//	# Keys this project needs
+//	DATABASE_URL   # postgres://... for your local database
+//	STRIPE_API_KEY # test-mode key from the dashboard
+package manifest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nick-skriabin/enva/internal/shell"
+)
+
+// FileName is the marker file, at a project root, that lists required keys.
+const FileName = ".enva"
+
+// Key is a required key.
+type Key struct {
+	Name        string
+	Description string
+}
+
+// Parse reads required keys from content. Blank lines and lines starting
+// with # are skipped; invalid names are returned as errors with their line
+// number.
+func Parse(content string) ([]Key, error) {
+	var (
+		keys []Key
+		errs []error
+		seen = make(map[string]bool)
+	)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, desc, _ := strings.Cut(line, "#")
+		name = strings.TrimSpace(name)
+		if !shell.IsValidKey(name) {
+			errs = append(errs, fmt.Errorf("line %d: invalid key %q", n, name))
+			continue
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		keys = append(keys, Key{Name: name, Description: strings.TrimSpace(desc)})
+	}
+	return keys, errors.Join(errs...)
+}
+
+// Load returns the keys required by the project at root. A project without
+// a marker file, or with an empty one, requires nothing.
+func Load(root string) ([]Key, error) {
+	path := filepath.Join(root, FileName)
+	// A .enva directory isn't a marker (see path.FindRoot)
+	if info, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := Parse(string(data))
+	if err != nil {
+		return keys, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// Missing returns the keys that aren't set in environ, a list of KEY=value
+// entries like os.Environ.
+func Missing(keys []Key, environ []string) []Key {
+	set := make(map[string]bool, len(environ))
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		set[name] = true
+	}
+	var missing []Key
+	for _, k := range keys {
+		if !set[k.Name] {
+			missing = append(missing, k)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,44 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	keys, err := Parse("# Keys this project needs\n\nDATABASE_URL   # local postgres\nSTRIPE_API_KEY\nDATABASE_URL\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(keys) != 2 || keys[0] != (Key{"DATABASE_URL", "local postgres"}) || keys[1] != (Key{"STRIPE_API_KEY", ""}) {
+		t.Errorf("Parse = %+v", keys)
+	}
+
+	keys, err = Parse("GOOD\nnot-a-key\n")
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Parse error = %v, want the bad line", err)
+	}
+	if len(keys) != 1 || keys[0].Name != "GOOD" {
+		t.Errorf("Parse should keep the valid keys, got %+v", keys)
+	}
+}
+
+func TestLoadAndMissing(t *testing.T) {
+	dir := t.TempDir()
+	if keys, err := Load(dir); err != nil || keys != nil {
+		t.Errorf("Load without a marker = %v, %v", keys, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, FileName), []byte("A\nB # second\nC\n"), 0644)
+	keys, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	missing := Missing(keys, []string{"A=1", "C=", "PATH=/bin"})
+	if len(missing) != 1 || missing[0].Name != "B" || missing[0].Description != "second" {
+		t.Errorf("Missing = %+v, want only B", missing)
+	}
+}
@@ -0,0 +1,91 @@
+// Package merge reconciles concurrent edits to a scope's variables.
+package merge
+
+import (
+	"sort"
+
+	"github.com/nick-skriabin/enva/internal/db"
+)
+
+// Conflict is a key both sides changed differently since the base snapshot.
+// A nil side means the key was deleted (or never existed) on that side.
+type Conflict struct {
+	Key    string
+	Base   *db.VarData
+	Mine   *db.VarData
+	Theirs *db.VarData
+}
+
+// Result is the outcome of a three-way merge.
+type Result struct {
+	Merged    map[string]db.VarData // Non-conflicting keys, resolved
+	Conflicts []Conflict            // Sorted by key; need a decision
+	Theirs    []string              // Keys taken from theirs without conflict
+}
+
+// ThreeWay merges mine and theirs, both derived from base. A key changed
+// on only one side takes that side's version; keys changed identically on
+// both sides merge cleanly; anything else is a conflict.
+func ThreeWay(base, mine, theirs map[string]db.VarData) Result {
+	res := Result{Merged: make(map[string]db.VarData)}
+
+	keys := make(map[string]bool)
+	for _, m := range []map[string]db.VarData{base, mine, theirs} {
+		for k := range m {
+			keys[k] = true
+		}
+	}
+
+	for key := range keys {
+		b, m, t := lookup(base, key), lookup(mine, key), lookup(theirs, key)
+		switch {
+		case equal(m, t):
+			set(res.Merged, key, m)
+		case equal(m, b):
+			set(res.Merged, key, t)
+			res.Theirs = append(res.Theirs, key)
+		case equal(t, b):
+			set(res.Merged, key, m)
+		default:
+			res.Conflicts = append(res.Conflicts, Conflict{Key: key, Base: b, Mine: m, Theirs: t})
+		}
+	}
+
+	sort.Strings(res.Theirs)
+	sort.Slice(res.Conflicts, func(i, j int) bool {
+		return res.Conflicts[i].Key < res.Conflicts[j].Key
+	})
+	return res
+}
+
+// Resolve applies a choice for a conflict to merged: the chosen side's
+// version is kept, and a nil side deletes the key.
+func Resolve(merged map[string]db.VarData, c Conflict, useMine bool) {
+	side := c.Theirs
+	if useMine {
+		side = c.Mine
+	}
+	set(merged, c.Key, side)
+}
+
+func lookup(m map[string]db.VarData, key string) *db.VarData {
+	if v, ok := m[key]; ok {
+		return &v
+	}
+	return nil
+}
+
+func equal(a, b *db.VarData) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func set(m map[string]db.VarData, key string, v *db.VarData) {
+	if v == nil {
+		delete(m, key)
+		return
+	}
+	m[key] = *v
+}
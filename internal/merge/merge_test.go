@@ -0,0 +1,108 @@
+package merge
+
+import (
+	"testing"
+
+	"github.com/nick-skriabin/enva/internal/db"
+)
+
+func v(s string) db.VarData { return db.VarData{Value: s} }
+
+func TestThreeWay(t *testing.T) {
+	base := map[string]db.VarData{
+		"SAME":         v("1"),
+		"MINE_CHANGED": v("1"),
+		"THEIR_CHANGE": v("1"),
+		"BOTH_SAME":    v("1"),
+		"CONFLICT":     v("1"),
+		"I_DELETED":    v("1"),
+		"THEY_DELETED": v("1"),
+		"DEL_VS_EDIT":  v("1"),
+	}
+	mine := map[string]db.VarData{
+		"SAME":         v("1"),
+		"MINE_CHANGED": v("2"),
+		"THEIR_CHANGE": v("1"),
+		"BOTH_SAME":    v("2"),
+		"CONFLICT":     v("mine"),
+		"THEY_DELETED": v("1"),
+		"MY_NEW":       v("new"),
+	}
+	theirs := map[string]db.VarData{
+		"SAME":         v("1"),
+		"MINE_CHANGED": v("1"),
+		"THEIR_CHANGE": v("2"),
+		"BOTH_SAME":    v("2"),
+		"CONFLICT":     v("theirs"),
+		"I_DELETED":    v("1"),
+		"DEL_VS_EDIT":  v("edited"),
+		"THEIR_NEW":    v("new"),
+	}
+
+	res := ThreeWay(base, mine, theirs)
+
+	want := map[string]string{
+		"SAME":         "1",
+		"MINE_CHANGED": "2",
+		"THEIR_CHANGE": "2",
+		"BOTH_SAME":    "2",
+		"MY_NEW":       "new",
+		"THEIR_NEW":    "new",
+	}
+	if len(res.Merged) != len(want) {
+		t.Errorf("Merged = %v, want %v", res.Merged, want)
+	}
+	for k, val := range want {
+		if res.Merged[k].Value != val {
+			t.Errorf("Merged[%s] = %q, want %q", k, res.Merged[k].Value, val)
+		}
+	}
+
+	if len(res.Conflicts) != 2 || res.Conflicts[0].Key != "CONFLICT" || res.Conflicts[1].Key != "DEL_VS_EDIT" {
+		t.Fatalf("Conflicts = %+v", res.Conflicts)
+	}
+	if c := res.Conflicts[1]; c.Mine != nil || c.Theirs == nil || c.Theirs.Value != "edited" {
+		t.Errorf("DEL_VS_EDIT conflict = %+v", c)
+	}
+
+	wantTheirs := []string{"THEIR_CHANGE", "THEIR_NEW", "THEY_DELETED"}
+	if len(res.Theirs) != len(wantTheirs) {
+		t.Fatalf("Theirs = %v, want %v", res.Theirs, wantTheirs)
+	}
+	for i, k := range wantTheirs {
+		if res.Theirs[i] != k {
+			t.Errorf("Theirs[%d] = %q, want %q", i, res.Theirs[i], k)
+		}
+	}
+}
+
+func TestThreeWayDescriptionChange(t *testing.T) {
+	base := map[string]db.VarData{"K": {Value: "1", Description: "old"}}
+	mine := map[string]db.VarData{"K": {Value: "1", Description: "old"}}
+	theirs := map[string]db.VarData{"K": {Value: "1", Description: "new"}}
+
+	res := ThreeWay(base, mine, theirs)
+	if len(res.Conflicts) != 0 || res.Merged["K"].Description != "new" {
+		t.Errorf("ThreeWay = %+v", res)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	mine, theirs := v("mine"), v("theirs")
+	merged := map[string]db.VarData{}
+
+	Resolve(merged, Conflict{Key: "K", Mine: &mine, Theirs: &theirs}, true)
+	if merged["K"].Value != "mine" {
+		t.Errorf("Resolve(mine) = %v", merged)
+	}
+
+	Resolve(merged, Conflict{Key: "K", Mine: nil, Theirs: &theirs}, true)
+	if _, ok := merged["K"]; ok {
+		t.Error("Resolve with deleted mine should remove the key")
+	}
+
+	Resolve(merged, Conflict{Key: "K", Mine: nil, Theirs: &theirs}, false)
+	if merged["K"].Value != "theirs" {
+		t.Errorf("Resolve(theirs) = %v", merged)
+	}
+}
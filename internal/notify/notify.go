@@ -0,0 +1,239 @@
+// Package notify tells the user when vars they depend on change, such as a
+// shared bundle a teammate pushed. enva watch compares snapshots of the
+// effective environment and hands the changes to a Dispatcher, which runs a
+// command, shows a desktop notification or POSTs a webhook for each rule
+// whose keys changed.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+// DefaultTimeout bounds each command and webhook when Dispatcher leaves
+// Timeout zero.
+const DefaultTimeout = 10 * time.Second
+
+// Kinds of change.
+const (
+	Added   = "added"
+	Changed = "changed"
+	Removed = "removed"
+)
+
+// Change is a var that was added, changed or removed between snapshots.
+// Old and New are only filled in for rules with IncludeValues.
+type Change struct {
+	Key    string `json:"key"`
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Author string `json:"author,omitempty"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// Rule says which keys to watch and how to report their changes. Keys are
+// names or path.Match globs such as DB_*. Any of Command, Desktop and
+// Webhook may be set.
+type Rule struct {
+	Keys          []string
+	Command       string
+	Desktop       bool
+	Webhook       string
+	IncludeValues bool
+}
+
+// Payload is the JSON body sent to webhooks and given to commands on stdin.
+type Payload struct {
+	Dir     string   `json:"dir"`
+	Changes []Change `json:"changes"`
+}
+
+// Failure is a rule whose notification couldn't be delivered.
+type Failure struct {
+	Rule *Rule
+	Err  error
+}
+
+// Diff returns the changes between two resolutions' vars (see
+// env.ResolveContext.Resolved), sorted by key. A var counts as changed when
+// its value (the command, for eval vars) or the scope it comes from differs.
+func Diff(old, new map[string]*env.ResolvedVar) []Change {
+	var changes []Change
+	for key, n := range new {
+		o, ok := old[key]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Key: key, Kind: Added, Path: n.DefinedAtPath, Author: n.Author, New: n.Value})
+		case o.Value != n.Value || o.DefinedAtPath != n.DefinedAtPath:
+			changes = append(changes, Change{Key: key, Kind: Changed, Path: n.DefinedAtPath, Author: n.Author, Old: o.Value, New: n.Value})
+		}
+	}
+	for key, o := range old {
+		if _, ok := new[key]; !ok {
+			changes = append(changes, Change{Key: key, Kind: Removed, Path: o.DefinedAtPath, Old: o.Value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// Matches reports whether the rule watches key.
+func (r *Rule) Matches(key string) bool {
+	for _, pattern := range r.Keys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher delivers notifications for changes in Dir.
+type Dispatcher struct {
+	Dir     string
+	Rules   []Rule
+	Timeout time.Duration
+}
+
+// Dispatch notifies every rule with at least one matching change, and
+// returns the ones that failed.
+func (d *Dispatcher) Dispatch(changes []Change) []Failure {
+	var failed []Failure
+	for i := range d.Rules {
+		rule := &d.Rules[i]
+		var matched []Change
+		for _, c := range changes {
+			if !rule.Matches(c.Key) {
+				continue
+			}
+			if !rule.IncludeValues {
+				c.Old, c.New = "", ""
+			}
+			matched = append(matched, c)
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		for _, err := range d.deliver(rule, Payload{Dir: d.Dir, Changes: matched}) {
+			failed = append(failed, Failure{Rule: rule, Err: err})
+		}
+	}
+	return failed
+}
+
+func (d *Dispatcher) deliver(rule *Rule, p Payload) []error {
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	if rule.Command != "" {
+		if err := runCommand(timeout, rule.Command, p, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if rule.Desktop {
+		if err := desktop(timeout, p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if rule.Webhook != "" {
+		if err := post(timeout, rule.Webhook, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Summary describes the changes in one line, such as
+// "API_URL changed, DB_HOST added".
+func (p Payload) Summary() string {
+	parts := make([]string, len(p.Changes))
+	for i, c := range p.Changes {
+		parts[i] = c.Key + " " + c.Kind
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runCommand runs command with sh, with the payload on stdin and
+// ENVA_CHANGED_KEYS, ENVA_DIR and ENVA_SUMMARY set.
+func runCommand(timeout time.Duration, command string, p Payload, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	keys := make([]string, len(p.Changes))
+	for i, c := range p.Changes {
+		keys[i] = c.Key
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = p.Dir
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"ENVA_CHANGED_KEYS="+strings.Join(keys, " "),
+		"ENVA_DIR="+p.Dir,
+		"ENVA_SUMMARY="+p.Summary(),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %w: %s", command, err, msg)
+		}
+		return fmt.Errorf("%s: %w", command, err)
+	}
+	return nil
+}
+
+// desktop shows p's summary with osascript on macOS and notify-send
+// elsewhere.
+func desktop(timeout time.Duration, p Payload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	title := "enva: " + p.Dir
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		script := fmt.Sprintf("display notification %s with title %s", appleString(p.Summary()), appleString(title))
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	} else {
+		cmd = exec.CommandContext(ctx, "notify-send", title, p.Summary())
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("desktop notification: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// appleString quotes s as an AppleScript string literal.
+func appleString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// post sends body to url as JSON.
+func post(timeout time.Duration, url string, body []byte) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
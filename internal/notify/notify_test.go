@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+func TestDiff(t *testing.T) {
+	old := map[string]*env.ResolvedVar{
+		"API_URL": {Key: "API_URL", Value: "https://a", DefinedAtPath: "/p"},
+		"GONE":    {Key: "GONE", Value: "x", DefinedAtPath: "/p"},
+		"MOVED":   {Key: "MOVED", Value: "1", DefinedAtPath: "/p"},
+		"SAME":    {Key: "SAME", Value: "1", DefinedAtPath: "/p"},
+	}
+	new := map[string]*env.ResolvedVar{
+		"API_URL": {Key: "API_URL", Value: "https://b", DefinedAtPath: "/p", Author: "sam@laptop"},
+		"MOVED":   {Key: "MOVED", Value: "1", DefinedAtPath: "/p/app"},
+		"NEW":     {Key: "NEW", Value: "y", DefinedAtPath: "/p"},
+		"SAME":    {Key: "SAME", Value: "1", DefinedAtPath: "/p"},
+	}
+
+	got := Diff(old, new)
+	want := []Change{
+		{Key: "API_URL", Kind: Changed, Path: "/p", Author: "sam@laptop", Old: "https://a", New: "https://b"},
+		{Key: "GONE", Kind: Removed, Path: "/p", Old: "x"},
+		{Key: "MOVED", Kind: Changed, Path: "/p/app", Old: "1", New: "1"},
+		{Key: "NEW", Kind: Added, Path: "/p", New: "y"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Diff = %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("change %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if len(Diff(new, new)) != 0 {
+		t.Error("Diff of identical snapshots should be empty")
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	r := &Rule{Keys: []string{"API_URL", "DB_*"}}
+	for key, want := range map[string]bool{"API_URL": true, "DB_HOST": true, "API_KEY": false, "XDB_HOST": false} {
+		if got := r.Matches(key); got != want {
+			t.Errorf("Matches(%s) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	var posted Payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &posted); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+	}))
+	defer srv.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	d := &Dispatcher{Dir: dir, Rules: []Rule{
+		{Keys: []string{"API_*"}, Webhook: srv.URL},
+		{Keys: []string{"DB_*"}, Command: `{ echo "$ENVA_CHANGED_KEYS|$ENVA_SUMMARY"; cat; } > out`, IncludeValues: true},
+		{Keys: []string{"NOTHING"}, Command: "touch never"},
+		{Keys: []string{"*"}, Webhook: failing.URL},
+	}}
+
+	failed := d.Dispatch([]Change{
+		{Key: "API_URL", Kind: Changed, Path: dir, Old: "https://a", New: "https://b"},
+		{Key: "DB_HOST", Kind: Added, Path: dir, New: "db"},
+	})
+
+	if len(failed) != 1 || failed[0].Rule != &d.Rules[3] || !strings.Contains(failed[0].Err.Error(), "500") {
+		t.Errorf("failed = %+v, want only the failing webhook", failed)
+	}
+
+	if len(posted.Changes) != 1 || posted.Changes[0].Key != "API_URL" || posted.Dir != dir {
+		t.Errorf("webhook payload = %+v", posted)
+	}
+	if posted.Changes[0].Old != "" || posted.Changes[0].New != "" {
+		t.Error("values should be left out without IncludeValues")
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("command didn't run: %v", err)
+	}
+	line, body, _ := strings.Cut(string(data), "\n")
+	if line != "DB_HOST|DB_HOST added" {
+		t.Errorf("command env = %q", line)
+	}
+	var p Payload
+	if err := json.Unmarshal([]byte(body), &p); err != nil || len(p.Changes) != 1 || p.Changes[0].New != "db" {
+		t.Errorf("command stdin = %q (%v)", body, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "never")); err == nil {
+		t.Error("a rule without matching changes should not run")
+	}
+}
@@ -0,0 +1,141 @@
+// Package pass reads a pass or gopass password store, so vars can resolve
+// from store entries at export time or be imported from them in bulk.
+package pass
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Tools enva knows how to drive.
+const (
+	Pass   = "pass"
+	Gopass = "gopass"
+)
+
+// ValidTool reports whether tool is Pass or Gopass.
+func ValidTool(tool string) bool {
+	return tool == Pass || tool == Gopass
+}
+
+// Command returns a shell command that prints the password (first line)
+// of entry, for an --eval var. It fails when tool prints nothing.
+func Command(tool, entry string) string {
+	quoted := "'" + strings.ReplaceAll(entry, "'", `'\''`) + "'"
+	if tool == Gopass {
+		return "gopass show --password " + quoted
+	}
+	return "pass show " + quoted + ` | { IFS= read -r p && printf '%s\n' "$p"; }`
+}
+
+// Show returns the password (first line) of entry.
+func Show(tool, entry string) (string, error) {
+	args := []string{"show", entry}
+	if tool == Gopass {
+		args = []string{"show", "--password", entry}
+	}
+	out, err := run(tool, args...)
+	if err != nil {
+		return "", fmt.Errorf("%s show %s: %w", tool, entry, err)
+	}
+	first, _, _ := strings.Cut(out, "\n")
+	return first, nil
+}
+
+// List returns the entries below prefix (all entries for ""), sorted.
+// pass entries are read from $PASSWORD_STORE_DIR (default
+// ~/.password-store) without decrypting anything.
+func List(tool, prefix string) ([]string, error) {
+	prefix = strings.Trim(prefix, "/")
+	var entries []string
+	if tool == Gopass {
+		out, err := run(tool, "ls", "--flat")
+		if err != nil {
+			return nil, fmt.Errorf("gopass ls: %w", err)
+		}
+		for _, line := range strings.Split(out, "\n") {
+			if line = strings.TrimSpace(line); line != "" && under(line, prefix) {
+				entries = append(entries, line)
+			}
+		}
+		sort.Strings(entries)
+		return entries, nil
+	}
+
+	store := os.Getenv("PASSWORD_STORE_DIR")
+	if store == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		store = filepath.Join(home, ".password-store")
+	}
+	root := filepath.Join(store, filepath.FromSlash(prefix))
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") && path != root {
+			return filepath.SkipDir // .git and the like
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".gpg") {
+			rel, err := filepath.Rel(store, strings.TrimSuffix(path, ".gpg"))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
+
+// Key returns the variable name for entry below prefix: the rest of its
+// path uppercased, with / and other invalid characters as _, so
+// "project/db/password" below "project" is DB_PASSWORD.
+func Key(entry, prefix string) string {
+	name := entry
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		name = strings.TrimPrefix(entry, prefix+"/")
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// under reports whether entry is prefix or below it.
+func under(entry, prefix string) bool {
+	return prefix == "" || entry == prefix || strings.HasPrefix(entry, prefix+"/")
+}
+
+// run runs tool with args and returns its output, or its stderr as the
+// error.
+func run(tool string, args ...string) (string, error) {
+	cmd := exec.Command(tool, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,72 @@
+package pass
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	store := t.TempDir()
+	t.Setenv("PASSWORD_STORE_DIR", store)
+	for _, name := range []string{"project/API_KEY.gpg", "project/db/password.gpg", "other/x.gpg", ".git/objects/y.gpg", "project/notes.txt"} {
+		path := filepath.Join(store, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, nil, 0600)
+	}
+
+	entries, err := List(Pass, "project/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if strings.Join(entries, ",") != "project/API_KEY,project/db/password" {
+		t.Errorf("List(project) = %v", entries)
+	}
+	if all, _ := List(Pass, ""); len(all) != 3 {
+		t.Errorf("List() = %v, want 3 entries without .git", all)
+	}
+	if _, err := List(Pass, "missing"); err == nil {
+		t.Error("List of a missing folder should fail")
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct{ entry, prefix, want string }{
+		{"project/API_KEY", "project", "API_KEY"},
+		{"project/db/password", "project/", "DB_PASSWORD"},
+		{"project/db-url", "", "PROJECT_DB_URL"},
+	}
+	for _, tt := range tests {
+		if got := Key(tt.entry, tt.prefix); got != tt.want {
+			t.Errorf("Key(%q, %q) = %q, want %q", tt.entry, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestCommandAndShow(t *testing.T) {
+	// A stand-in pass that prints a multi-line entry, or fails
+	bin := t.TempDir()
+	script := "#!/bin/sh\n[ \"$2\" = missing ] && { echo 'not in store' >&2; exit 1; }\nprintf 's3cret\\nuser: me\\n'\n"
+	os.WriteFile(filepath.Join(bin, "pass"), []byte(script), 0755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if got, err := Show(Pass, "project/it's"); err != nil || got != "s3cret" {
+		t.Errorf("Show = %q, %v", got, err)
+	}
+	if _, err := Show(Pass, "missing"); err == nil || !strings.Contains(err.Error(), "not in store") {
+		t.Errorf("Show(missing) error = %v", err)
+	}
+
+	out, err := exec.Command("sh", "-c", Command(Pass, "project/it's")).Output()
+	if err != nil || string(out) != "s3cret\n" {
+		t.Errorf("Command output = %q, %v", out, err)
+	}
+	if err := exec.Command("sh", "-c", Command(Pass, "missing")).Run(); err == nil {
+		t.Error("Command should fail when pass does")
+	}
+	if got := Command(Gopass, "a/b"); got != "gopass show --password 'a/b'" {
+		t.Errorf("Command(gopass) = %q", got)
+	}
+}
@@ -3,46 +3,160 @@
 package path
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
-// Canonicalize returns the absolute, symlink-resolved path.
+// canonEntry is a cached Canonicalize result. Missing marks a path that
+// didn't exist, so the failed EvalSymlinks isn't retried.
+type canonEntry struct {
+	path    string
+	missing bool
+}
+
+var canonCache = struct {
+	sync.Mutex
+	m map[string]canonEntry
+}{m: make(map[string]canonEntry)}
+
+// OnMissing, if set, is called the first time Canonicalize falls back to the
+// plain absolute path for a path that no longer exists.
+var OnMissing func(abs string)
+
+// Canonicalize returns the absolute, symlink-resolved path. Results are
+// cached for the life of the process. A path that doesn't exist (e.g. a
+// deleted or renamed cwd) falls back to its absolute form.
 func Canonicalize(p string) (string, error) {
 	abs, err := filepath.Abs(p)
 	if err != nil {
 		return "", err
 	}
-	return filepath.EvalSymlinks(abs)
+
+	canonCache.Lock()
+	entry, ok := canonCache.m[abs]
+	canonCache.Unlock()
+	if ok {
+		return entry.path, nil
+	}
+
+	resolved, err := evalSymlinks(abs)
+	switch {
+	case err == nil:
+		entry = canonEntry{path: resolved}
+	case errors.Is(err, fs.ErrNotExist):
+		entry = canonEntry{path: abs, missing: true}
+		if OnMissing != nil {
+			OnMissing(abs)
+		}
+	default:
+		return "", err
+	}
+
+	canonCache.Lock()
+	canonCache.m[abs] = entry
+	canonCache.Unlock()
+	return entry.path, nil
+}
+
+// ResetCache drops all cached Canonicalize results. Long-running callers use
+// it to pick up symlinks that changed since they were first resolved.
+func ResetCache() {
+	canonCache.Lock()
+	canonCache.m = make(map[string]canonEntry)
+	canonCache.Unlock()
+}
+
+// RootMarker identifies what established a root boundary.
+type RootMarker int
+
+const (
+	MarkerFSRoot RootMarker = iota // No marker found; filesystem root
+	MarkerEnva                     // .enva marker file
+	MarkerGit                      // .git directory
+	MarkerHome                     // No marker found up to Home
+)
+
+// Home, if set, is a canonical directory root discovery doesn't walk
+// above, normally the user's home directory. A path inside it with no
+// marker up to and including it takes it as the root.
+var Home string
+
+// RefuseFSRoot makes CheckRoot reject the filesystem root as a root, so
+// vars set there can't apply to every directory on the machine.
+var RefuseFSRoot bool
+
+// ErrFSRoot is returned by CheckRoot for a refused filesystem root.
+var ErrFSRoot = errors.New("no .enva or .git found above this directory, and refuse_fs_root is set")
+
+// CheckRoot returns ErrFSRoot if marker says no root was found below the
+// filesystem root and RefuseFSRoot is set.
+func CheckRoot(marker RootMarker) error {
+	if RefuseFSRoot && marker == MarkerFSRoot {
+		return ErrFSRoot
+	}
+	return nil
+}
+
+// String returns a display name for the marker.
+func (m RootMarker) String() string {
+	switch m {
+	case MarkerEnva:
+		return ".enva"
+	case MarkerGit:
+		return ".git"
+	case MarkerHome:
+		return "home directory"
+	}
+	return "filesystem root"
 }
 
 // FindRoot walks up from the given path to find the root boundary.
-// Priority: .enva file (closest) > .git directory (closest) > filesystem root
+// Priority: .enva file (closest) > .git directory (closest) > Home, for
+// paths inside it > filesystem root
 func FindRoot(from string) (string, error) {
+	root, _, err := FindRootWithMarker(from)
+	return root, err
+}
+
+// FindRootWithMarker is like FindRoot but also reports which marker was found.
+func FindRootWithMarker(from string) (string, RootMarker, error) {
 	canonical, err := Canonicalize(from)
 	if err != nil {
-		return "", err
+		return "", MarkerFSRoot, err
 	}
+	root, marker := FindRootCanonical(canonical)
+	return root, marker, nil
+}
 
+// FindRootCanonical is like FindRootWithMarker for a path that is already
+// canonical, skipping the symlink resolution.
+func FindRootCanonical(canonical string) (string, RootMarker) {
 	current := canonical
 	for {
 		// Check for .enva marker file
 		envaMarker := filepath.Join(current, ".enva")
 		if info, err := os.Stat(envaMarker); err == nil && !info.IsDir() {
-			return current, nil
+			return current, MarkerEnva
 		}
 
 		// Check for .git directory
 		gitDir := filepath.Join(current, ".git")
 		if info, err := os.Stat(gitDir); err == nil && info.IsDir() {
-			return current, nil
+			return current, MarkerGit
+		}
+
+		if Home != "" && samePath(current, Home) {
+			return current, MarkerHome
 		}
 
 		// Move to parent
 		parent := filepath.Dir(current)
 		if parent == current {
-			// Reached filesystem root
-			return current, nil
+			// Reached filesystem root (/, or a drive or UNC share root on Windows)
+			return current, MarkerFSRoot
 		}
 		current = parent
 	}
@@ -59,13 +173,18 @@ func BuildChain(rootDir, targetDir string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	return BuildChainCanonical(rootCanon, targetCanon), nil
+}
 
-	// Build chain by walking up from target to root
+// BuildChainCanonical is like BuildChain for paths that are already
+// canonical. Only the two endpoints are ever resolved, not every level.
+func BuildChainCanonical(rootCanon, targetCanon string) []string {
+	// Walk up from target to root, then reverse
 	var chain []string
 	current := targetCanon
 	for {
-		chain = append([]string{current}, chain...)
-		if current == rootCanon {
+		chain = append(chain, current)
+		if samePath(current, rootCanon) {
 			break
 		}
 		parent := filepath.Dir(current)
@@ -76,7 +195,10 @@ func BuildChain(rootDir, targetDir string) ([]string, error) {
 		current = parent
 	}
 
-	return chain, nil
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
 }
 
 // IsAncestor checks if ancestor is an ancestor of (or equal to) path.
@@ -65,6 +65,55 @@ func TestCanonicalize(t *testing.T) {
 	})
 }
 
+func TestCanonicalizeCache(t *testing.T) {
+	tmpDir, _ := filepath.EvalSymlinks(t.TempDir())
+	defer ResetCache()
+
+	t.Run("missing path falls back to abs", func(t *testing.T) {
+		var warned []string
+		OnMissing = func(abs string) { warned = append(warned, abs) }
+		defer func() { OnMissing = nil }()
+
+		gone := filepath.Join(tmpDir, "gone")
+		for i := 0; i < 2; i++ {
+			got, err := Canonicalize(gone)
+			if err != nil {
+				t.Fatalf("Canonicalize failed: %v", err)
+			}
+			if got != gone {
+				t.Errorf("Canonicalize(%q) = %q, want %q", gone, got, gone)
+			}
+		}
+		if len(warned) != 1 {
+			t.Errorf("OnMissing called %d times, want 1 (negative result cached)", len(warned))
+		}
+	})
+
+	t.Run("results cached until reset", func(t *testing.T) {
+		a := filepath.Join(tmpDir, "a")
+		b := filepath.Join(tmpDir, "b")
+		link := filepath.Join(tmpDir, "link")
+		os.Mkdir(a, 0755)
+		os.Mkdir(b, 0755)
+		os.Symlink(a, link)
+
+		if got, _ := Canonicalize(link); got != a {
+			t.Fatalf("Canonicalize(link) = %q, want %q", got, a)
+		}
+
+		os.Remove(link)
+		os.Symlink(b, link)
+		if got, _ := Canonicalize(link); got != a {
+			t.Errorf("Canonicalize(link) = %q, want cached %q", got, a)
+		}
+
+		ResetCache()
+		if got, _ := Canonicalize(link); got != b {
+			t.Errorf("Canonicalize(link) after reset = %q, want %q", got, b)
+		}
+	})
+}
+
 func TestFindRoot(t *testing.T) {
 	// Create a temp directory structure for testing
 	tmpDir, err := os.MkdirTemp("", "enva-test-*")
@@ -145,6 +194,44 @@ func TestFindRoot(t *testing.T) {
 	})
 }
 
+func TestFindRootWithMarker(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "enva-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpDirCanon, _ := filepath.EvalSymlinks(tmpDir)
+
+	envaRoot := filepath.Join(tmpDirCanon, "enva-root")
+	os.MkdirAll(envaRoot, 0755)
+	os.WriteFile(filepath.Join(envaRoot, ".enva"), []byte{}, 0644)
+
+	gitRoot := filepath.Join(tmpDirCanon, "git-root")
+	os.MkdirAll(filepath.Join(gitRoot, ".git"), 0755)
+
+	tests := []struct {
+		from   string
+		root   string
+		marker RootMarker
+	}{
+		{envaRoot, envaRoot, MarkerEnva},
+		{gitRoot, gitRoot, MarkerGit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.marker.String(), func(t *testing.T) {
+			root, marker, err := FindRootWithMarker(tt.from)
+			if err != nil {
+				t.Fatalf("FindRootWithMarker failed: %v", err)
+			}
+			if root != tt.root || marker != tt.marker {
+				t.Errorf("FindRootWithMarker(%q) = %q, %v, want %q, %v", tt.from, root, marker, tt.root, tt.marker)
+			}
+		})
+	}
+}
+
 func TestBuildChain(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "enva-test-*")
 	if err != nil {
@@ -192,6 +279,44 @@ func TestBuildChain(t *testing.T) {
 	})
 }
 
+func TestBuildChainCanonical(t *testing.T) {
+	chain := BuildChainCanonical("/root", "/root/a/b")
+	expected := []string{"/root", "/root/a", "/root/a/b"}
+	if len(chain) != len(expected) {
+		t.Fatalf("BuildChainCanonical returned %v, want %v", chain, expected)
+	}
+	for i, want := range expected {
+		if chain[i] != want {
+			t.Errorf("BuildChainCanonical[%d] = %q, want %q", i, chain[i], want)
+		}
+	}
+
+	// Paths are used as given; nothing needs to exist on disk
+	if got := BuildChainCanonical("/nope", "/nope"); len(got) != 1 || got[0] != "/nope" {
+		t.Errorf("BuildChainCanonical(same) = %v", got)
+	}
+}
+
+func BenchmarkBuildChain(b *testing.B) {
+	tmpDir, _ := filepath.EvalSymlinks(b.TempDir())
+	deep := tmpDir
+	for i := 0; i < 40; i++ {
+		deep = filepath.Join(deep, "d")
+	}
+	os.MkdirAll(deep, 0755)
+
+	b.Run("canonicalizing", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			BuildChain(tmpDir, deep)
+		}
+	})
+	b.Run("canonical", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			BuildChainCanonical(tmpDir, deep)
+		}
+	})
+}
+
 func TestIsAncestor(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "enva-test-*")
 	if err != nil {
@@ -235,3 +360,48 @@ func TestIsAncestor(t *testing.T) {
 		})
 	}
 }
+
+func TestFindRootStopsAtHome(t *testing.T) {
+	tmpDir, _ := filepath.EvalSymlinks(t.TempDir())
+	home := filepath.Join(tmpDir, "home")
+	project := filepath.Join(home, "code", "project")
+	os.MkdirAll(project, 0755)
+	outside := filepath.Join(tmpDir, "elsewhere")
+	os.MkdirAll(outside, 0755)
+
+	Home = home
+	defer func() { Home = "" }()
+
+	root, marker := FindRootCanonical(project)
+	if root != home || marker != MarkerHome {
+		t.Errorf("FindRootCanonical(%q) = %q, %v, want %q, %v", project, root, marker, home, MarkerHome)
+	}
+
+	// A marker below home still wins
+	os.WriteFile(filepath.Join(project, ".enva"), []byte{}, 0644)
+	if root, marker := FindRootCanonical(project); root != project || marker != MarkerEnva {
+		t.Errorf("FindRootCanonical(%q) = %q, %v, want %q, %v", project, root, marker, project, MarkerEnva)
+	}
+
+	// Outside home the walk goes on as before
+	if _, marker := FindRootCanonical(outside); marker == MarkerHome {
+		t.Errorf("FindRootCanonical(%q) stopped at home", outside)
+	}
+}
+
+func TestCheckRoot(t *testing.T) {
+	if err := CheckRoot(MarkerFSRoot); err != nil {
+		t.Errorf("CheckRoot(MarkerFSRoot) = %v without RefuseFSRoot", err)
+	}
+
+	RefuseFSRoot = true
+	defer func() { RefuseFSRoot = false }()
+	if err := CheckRoot(MarkerFSRoot); err != ErrFSRoot {
+		t.Errorf("CheckRoot(MarkerFSRoot) = %v, want ErrFSRoot", err)
+	}
+	for _, m := range []RootMarker{MarkerEnva, MarkerGit, MarkerHome} {
+		if err := CheckRoot(m); err != nil {
+			t.Errorf("CheckRoot(%v) = %v, want nil", m, err)
+		}
+	}
+}
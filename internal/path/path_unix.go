@@ -0,0 +1,16 @@
+//go:build !windows
+
+package path
+
+import "path/filepath"
+
+// evalSymlinks resolves symlinks in an absolute path.
+func evalSymlinks(abs string) (string, error) {
+	return filepath.EvalSymlinks(abs)
+}
+
+// samePath reports whether two canonical paths name the same directory.
+// POSIX filesystems are compared byte for byte.
+func samePath(a, b string) bool {
+	return a == b
+}
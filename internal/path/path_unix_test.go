@@ -0,0 +1,14 @@
+//go:build !windows
+
+package path
+
+import "testing"
+
+func TestSamePathCaseSensitive(t *testing.T) {
+	if samePath("/work/App", "/work/app") {
+		t.Error("samePath should be case-sensitive on POSIX")
+	}
+	if !samePath("/work/app", "/work/app") {
+		t.Error("samePath should match identical paths")
+	}
+}
@@ -0,0 +1,67 @@
+//go:build windows
+
+package path
+
+import (
+	"io/fs"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// volumeNameDOS asks GetFinalPathNameByHandle for a drive-letter path
+// (VOLUME_NAME_DOS, not exported by x/sys/windows).
+const volumeNameDOS = 0x0
+
+// evalSymlinks resolves an absolute path to its final on-disk form. Asking
+// the filesystem for the final path name follows symlinks and junctions
+// alike and yields the stored letter case, so paths typed in any case map
+// to the same canonical form.
+func evalSymlinks(abs string) (string, error) {
+	p, err := windows.UTF16PtrFromString(abs)
+	if err != nil {
+		return "", err
+	}
+
+	// FILE_FLAG_BACKUP_SEMANTICS is required to open directories
+	h, err := windows.CreateFile(p, 0,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return "", &fs.PathError{Op: "open", Path: abs, Err: err}
+	}
+	defer windows.CloseHandle(h)
+
+	buf := make([]uint16, windows.MAX_PATH)
+	for {
+		n, err := windows.GetFinalPathNameByHandle(h, &buf[0], uint32(len(buf)), volumeNameDOS)
+		if err != nil {
+			return "", &fs.PathError{Op: "GetFinalPathNameByHandle", Path: abs, Err: err}
+		}
+		if int(n) < len(buf) {
+			return cleanFinalPath(windows.UTF16ToString(buf[:n])), nil
+		}
+		buf = make([]uint16, n)
+	}
+}
+
+// cleanFinalPath strips the \\?\ prefix from a final path name and
+// upper-cases the drive letter.
+func cleanFinalPath(p string) string {
+	switch {
+	case strings.HasPrefix(p, `\\?\UNC\`):
+		return `\\` + p[len(`\\?\UNC\`):]
+	case strings.HasPrefix(p, `\\?\`):
+		p = p[len(`\\?\`):]
+	}
+	if len(p) >= 2 && p[1] == ':' {
+		p = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return p
+}
+
+// samePath reports whether two canonical paths name the same directory.
+// Windows filesystems are case-insensitive.
+func samePath(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
@@ -0,0 +1,107 @@
+//go:build windows
+
+package path
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanFinalPath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`\\?\C:\Users\dev`, `C:\Users\dev`},
+		{`\\?\c:\Users\dev`, `C:\Users\dev`},
+		{`\\?\UNC\server\share\dir`, `\\server\share\dir`},
+		{`D:\work`, `D:\work`},
+	}
+	for _, tt := range tests {
+		if got := cleanFinalPath(tt.in); got != tt.want {
+			t.Errorf("cleanFinalPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSamePathCaseInsensitive(t *testing.T) {
+	if !samePath(`C:\Work\App`, `c:\work\app`) {
+		t.Error("samePath should ignore case on Windows")
+	}
+	if samePath(`C:\work\app`, `C:\work\api`) {
+		t.Error("samePath should distinguish different paths")
+	}
+}
+
+func TestCanonicalizeCaseAndJunction(t *testing.T) {
+	defer ResetCache()
+
+	tmpDir, err := Canonicalize(t.TempDir())
+	if err != nil {
+		t.Fatalf("Canonicalize failed: %v", err)
+	}
+
+	real := filepath.Join(tmpDir, "RealDir")
+	if err := os.Mkdir(real, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	t.Run("case folds to on-disk form", func(t *testing.T) {
+		got, err := Canonicalize(filepath.Join(tmpDir, "realdir"))
+		if err != nil {
+			t.Fatalf("Canonicalize failed: %v", err)
+		}
+		if got != real {
+			t.Errorf("Canonicalize(realdir) = %q, want %q", got, real)
+		}
+	})
+
+	t.Run("junction resolves to target", func(t *testing.T) {
+		junction := filepath.Join(tmpDir, "junction")
+		if out, err := exec.Command("cmd", "/c", "mklink", "/J", junction, real).CombinedOutput(); err != nil {
+			t.Skipf("mklink /J unavailable: %v: %s", err, out)
+		}
+
+		got, err := Canonicalize(junction)
+		if err != nil {
+			t.Fatalf("Canonicalize failed: %v", err)
+		}
+		if got != real {
+			t.Errorf("Canonicalize(junction) = %q, want %q", got, real)
+		}
+	})
+}
+
+func TestBuildChainDriveRoot(t *testing.T) {
+	chain := BuildChainCanonical(`C:\`, `C:\work\app`)
+	expected := []string{`C:\`, `C:\work`, `C:\work\app`}
+	if len(chain) != len(expected) {
+		t.Fatalf("BuildChainCanonical = %v, want %v", chain, expected)
+	}
+	for i, want := range expected {
+		if chain[i] != want {
+			t.Errorf("BuildChainCanonical[%d] = %q, want %q", i, chain[i], want)
+		}
+	}
+
+	// Root given in a different case still terminates the walk
+	chain = BuildChainCanonical(`c:\WORK`, `C:\work\app`)
+	if len(chain) != 2 {
+		t.Errorf("BuildChainCanonical with differently cased root = %v", chain)
+	}
+}
+
+func TestFindRootDriveRoot(t *testing.T) {
+	// With no markers the walk must stop at the volume root, not loop
+	dir := t.TempDir()
+	canon, err := Canonicalize(dir)
+	if err != nil {
+		t.Fatalf("Canonicalize failed: %v", err)
+	}
+	root, marker := FindRootCanonical(canon)
+	if marker == MarkerFSRoot && !strings.HasSuffix(root, `:\`) && !strings.HasPrefix(root, `\\`) {
+		t.Errorf("FindRootCanonical = %q, want a drive or UNC root", root)
+	}
+}
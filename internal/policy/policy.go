@@ -0,0 +1,148 @@
+// Package policy provides per-project naming rules for environment variable keys.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Secret scanning modes.
+const (
+	SecretsWarn  = "warn"  // Warn when a value looks like a credential (default)
+	SecretsBlock = "block" // Refuse to store values that look like credentials
+	SecretsOff   = "off"   // Don't scan values
+)
+
+// Policy holds naming rules for keys set within a scope and its descendants.
+type Policy struct {
+	// Prefix, if set, is required at the start of every key.
+	Prefix string `json:"prefix,omitempty"`
+	// ScreamingSnake requires keys to match [A-Z][A-Z0-9_]*.
+	ScreamingSnake bool `json:"screaming_snake,omitempty"`
+	// Banned lists keys that may never be set.
+	Banned []string `json:"banned,omitempty"`
+	// Secrets is the secret scanning mode. Empty means SecretsWarn.
+	Secrets string `json:"secrets,omitempty"`
+}
+
+// SecretsMode returns the effective secret scanning mode.
+func (p Policy) SecretsMode() string {
+	if p.Secrets == "" {
+		return SecretsWarn
+	}
+	return p.Secrets
+}
+
+// IsValidSecretsMode reports whether mode is a known secret scanning mode.
+func IsValidSecretsMode(mode string) bool {
+	return mode == SecretsWarn || mode == SecretsBlock || mode == SecretsOff
+}
+
+// IsZero reports whether the policy has no rules.
+func (p Policy) IsZero() bool {
+	return p.Prefix == "" && !p.ScreamingSnake && len(p.Banned) == 0 && p.Secrets == ""
+}
+
+// Violation is the error returned for a key or value a policy rejects.
+type Violation struct {
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return v.Reason
+}
+
+// violationf formats a Violation.
+func violationf(format string, args ...any) error {
+	return &Violation{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Check returns an error describing the first rule key violates, or nil.
+func (p Policy) Check(key string) error {
+	for _, b := range p.Banned {
+		if key == b {
+			return violationf("key %s is banned by policy", key)
+		}
+	}
+
+	if p.Prefix != "" && !strings.HasPrefix(key, p.Prefix) {
+		return violationf("key %s must start with %q", key, p.Prefix)
+	}
+
+	if p.ScreamingSnake && !isScreamingSnake(key) {
+		return violationf("key %s must be SCREAMING_SNAKE_CASE", key)
+	}
+
+	return nil
+}
+
+// CheckAll checks every key and returns all violations.
+func (p Policy) CheckAll(keys []string) []error {
+	var errs []error
+	for _, k := range keys {
+		if err := p.Check(k); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// String returns a short human-readable description of the rules.
+func (p Policy) String() string {
+	if p.IsZero() {
+		return "no rules"
+	}
+	var parts []string
+	if p.Prefix != "" {
+		parts = append(parts, fmt.Sprintf("prefix %q", p.Prefix))
+	}
+	if p.ScreamingSnake {
+		parts = append(parts, "SCREAMING_SNAKE_CASE")
+	}
+	if len(p.Banned) > 0 {
+		parts = append(parts, "banned: "+strings.Join(p.Banned, ", "))
+	}
+	if p.Secrets != "" {
+		parts = append(parts, "secrets: "+p.Secrets)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Encode serializes the policy for storage. A zero policy encodes as "".
+func (p Policy) Encode() (string, error) {
+	if p.IsZero() {
+		return "", nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Decode parses a stored policy. An empty string decodes as a zero policy.
+func Decode(s string) (Policy, error) {
+	var p Policy
+	if s == "" {
+		return p, nil
+	}
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		return Policy{}, fmt.Errorf("invalid policy: %w", err)
+	}
+	return p, nil
+}
+
+// isScreamingSnake checks if a key matches [A-Z][A-Z0-9_]*
+func isScreamingSnake(key string) bool {
+	if len(key) == 0 || key[0] < 'A' || key[0] > 'Z' {
+		return false
+	}
+	for i := 1; i < len(key); i++ {
+		c := key[i]
+		if !((c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	p := Policy{Prefix: "APP_", ScreamingSnake: true, Banned: []string{"APP_LD_PRELOAD"}}
+
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"APP_URL", true},
+		{"APP_PORT_2", true},
+		{"URL", false},
+		{"APP_url", false},
+		{"app_URL", false},
+		{"APP_LD_PRELOAD", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := p.Check(tt.key)
+			if (err == nil) != tt.valid {
+				t.Errorf("Check(%q) = %v, want valid=%v", tt.key, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCheckZeroPolicy(t *testing.T) {
+	var p Policy
+	if !p.IsZero() {
+		t.Error("zero policy should report IsZero")
+	}
+	if err := p.Check("anything_goes"); err != nil {
+		t.Errorf("zero policy should accept all keys, got %v", err)
+	}
+}
+
+func TestCheckAll(t *testing.T) {
+	p := Policy{Banned: []string{"LD_PRELOAD", "DYLD_INSERT_LIBRARIES"}}
+	errs := p.CheckAll([]string{"OK", "LD_PRELOAD", "DYLD_INSERT_LIBRARIES"})
+	if len(errs) != 2 {
+		t.Errorf("CheckAll returned %d errors, want 2", len(errs))
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	p := Policy{Prefix: "APP_", ScreamingSnake: true, Banned: []string{"LD_PRELOAD"}}
+
+	s, err := p.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	got, err := Decode(s)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if got.Prefix != p.Prefix || got.ScreamingSnake != p.ScreamingSnake || len(got.Banned) != 1 {
+		t.Errorf("Decode(Encode(p)) = %+v, want %+v", got, p)
+	}
+
+	empty, _ := Policy{}.Encode()
+	if empty != "" {
+		t.Errorf("zero policy should encode as empty string, got %q", empty)
+	}
+	if z, err := Decode(""); err != nil || !z.IsZero() {
+		t.Errorf("Decode(\"\") = %+v, %v", z, err)
+	}
+	if _, err := Decode("{"); err == nil {
+		t.Error("Decode should fail on invalid JSON")
+	}
+}
+
+func TestSecretsMode(t *testing.T) {
+	if got := (Policy{}).SecretsMode(); got != SecretsWarn {
+		t.Errorf("default SecretsMode = %q, want %q", got, SecretsWarn)
+	}
+	if got := (Policy{Secrets: SecretsBlock}).SecretsMode(); got != SecretsBlock {
+		t.Errorf("SecretsMode = %q, want %q", got, SecretsBlock)
+	}
+	if (Policy{Secrets: SecretsOff}).IsZero() {
+		t.Error("policy with secrets mode should not be zero")
+	}
+	if IsValidSecretsMode("sometimes") {
+		t.Error("IsValidSecretsMode should reject unknown modes")
+	}
+}
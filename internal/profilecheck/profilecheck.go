@@ -0,0 +1,88 @@
+// Package profilecheck compares the environment a directory gets in one
+// profile with what it gets in another, so a key set in default but never
+// in production, or a port that is a number in one and text in the other,
+// is caught before a deploy.
+package profilecheck
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+// Kind is the sort of value a var holds, inferred from the value.
+type Kind string
+
+const (
+	KindEmpty  Kind = "empty"
+	KindBool   Kind = "boolean"
+	KindInt    Kind = "integer"
+	KindNumber Kind = "number"
+	KindURL    Kind = "URL"
+	KindJSON   Kind = "JSON"
+	KindString Kind = "text"
+)
+
+// KindOf infers the kind of value.
+func KindOf(value string) Kind {
+	v := strings.TrimSpace(value)
+	switch strings.ToLower(v) {
+	case "":
+		return KindEmpty
+	case "true", "false", "yes", "no", "on", "off":
+		return KindBool
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return KindInt
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return KindNumber
+	}
+	if u, err := url.Parse(v); err == nil && u.Scheme != "" && u.Host != "" {
+		return KindURL
+	}
+	if (strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[")) && json.Valid([]byte(v)) {
+		return KindJSON
+	}
+	return KindString
+}
+
+// Problem is a difference between the base profile and another one.
+type Problem struct {
+	Key      string
+	Missing  bool // Set in the base profile but not in the other
+	BaseKind Kind // Otherwise, the kinds that differ
+	Kind     Kind
+}
+
+// Compare reports the keys of base missing from other and the keys whose
+// values are of different kinds, sorted by key. Keys only in other are
+// fine, and --eval vars are only checked for presence, since their values
+// are commands.
+func Compare(base, other []*env.ResolvedVar) []Problem {
+	byKey := make(map[string]*env.ResolvedVar, len(other))
+	for _, v := range other {
+		byKey[v.Key] = v
+	}
+
+	var problems []Problem
+	for _, b := range base {
+		o, ok := byKey[b.Key]
+		if !ok {
+			problems = append(problems, Problem{Key: b.Key, Missing: true})
+			continue
+		}
+		if b.Eval || o.Eval {
+			continue
+		}
+		if bk, ok := KindOf(b.Value), KindOf(o.Value); bk != ok {
+			problems = append(problems, Problem{Key: b.Key, BaseKind: bk, Kind: ok})
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Key < problems[j].Key })
+	return problems
+}
@@ -0,0 +1,59 @@
+package profilecheck
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := map[string]Kind{
+		"":                     KindEmpty,
+		"  ":                   KindEmpty,
+		"TRUE":                 KindBool,
+		"off":                  KindBool,
+		"8080":                 KindInt,
+		"-3":                   KindInt,
+		"0.5":                  KindNumber,
+		"postgres://db:5432/x": KindURL,
+		`{"a": 1}`:             KindJSON,
+		"[1, 2]":               KindJSON,
+		"{not json":            KindString,
+		"localhost":            KindString,
+	}
+	for value, want := range tests {
+		if got := KindOf(value); got != want {
+			t.Errorf("KindOf(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := []*env.ResolvedVar{
+		{Key: "DB_URL", Value: "postgres://localhost/dev"},
+		{Key: "DEBUG", Value: "true"},
+		{Key: "GIT_SHA", Value: "git rev-parse HEAD", Eval: true},
+		{Key: "PORT", Value: "8080"},
+		{Key: "STRIPE_KEY", Value: "sk_test"},
+	}
+	prod := []*env.ResolvedVar{
+		{Key: "DB_URL", Value: "postgres://db.internal/prod"},
+		{Key: "DEBUG", Value: "1"},
+		{Key: "GIT_SHA", Value: "abc123"},
+		{Key: "PORT", Value: "eighty"},
+		{Key: "SENTRY_DSN", Value: "https://sentry"},
+	}
+
+	want := []Problem{
+		{Key: "DEBUG", BaseKind: KindBool, Kind: KindInt},
+		{Key: "PORT", BaseKind: KindInt, Kind: KindString},
+		{Key: "STRIPE_KEY", Missing: true},
+	}
+	if got := Compare(base, prod); !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare = %+v, want %+v", got, want)
+	}
+	if got := Compare(base, base); len(got) != 0 {
+		t.Errorf("Compare(base, base) = %+v", got)
+	}
+}
@@ -0,0 +1,116 @@
+// Package progress reports how far a bulk operation has got: a count, a
+// percentage and an estimate of the time left.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// redrawEvery limits how often a Bar rewrites its line.
+const redrawEvery = 100 * time.Millisecond
+
+// Bar draws progress on one terminal line, rewriting it in place.
+type Bar struct {
+	w     io.Writer
+	label string
+	total int
+	done  int
+	start time.Time
+	drawn time.Time
+	now   func() time.Time
+}
+
+// New returns a bar counting to total. With a nil w it only counts.
+func New(w io.Writer, label string, total int) *Bar {
+	b := &Bar{w: w, label: label, total: total, now: time.Now}
+	b.start = b.now()
+	return b
+}
+
+// Terminal returns f if it is a terminal and nil otherwise, so output
+// redirected to a file or pipe isn't filled with progress lines.
+func Terminal(f *os.File) io.Writer {
+	if info, err := f.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return f
+}
+
+// Add records n more items done and redraws if it's been a while.
+func (b *Bar) Add(n int) {
+	b.done += n
+	if b.w == nil {
+		return
+	}
+	now := b.now()
+	if now.Sub(b.drawn) < redrawEvery && b.done < b.total {
+		return
+	}
+	b.drawn = now
+	fmt.Fprintf(b.w, "\r\033[K%s", Line(b.label, b.done, b.total, now.Sub(b.start)))
+}
+
+// Done returns how many items have been recorded.
+func (b *Bar) Done() int { return b.done }
+
+// Finish clears the bar's line.
+func (b *Bar) Finish() {
+	if b.w != nil && !b.drawn.IsZero() {
+		fmt.Fprint(b.w, "\r\033[K")
+	}
+}
+
+// Line formats progress as "label  1500/5000  30%  ETA 4s". The estimate
+// is left out until there's enough done to go on.
+func Line(label string, done, total int, elapsed time.Duration) string {
+	line := fmt.Sprintf("%s  %d/%d  %3d%%", label, done, total, Percent(done, total))
+	if eta, ok := ETA(done, total, elapsed); ok {
+		line += "  ETA " + formatDuration(eta)
+	}
+	return line
+}
+
+// Percent returns done as a whole percentage of total.
+func Percent(done, total int) int {
+	if total <= 0 {
+		return 100
+	}
+	return done * 100 / total
+}
+
+// ETA estimates the time left from the rate so far. It reports false
+// before anything is done, or after everything is.
+func ETA(done, total int, elapsed time.Duration) (time.Duration, bool) {
+	if done <= 0 || done >= total || elapsed <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(elapsed) / float64(done) * float64(total-done)), true
+}
+
+// BarString draws a width-cell bar filled to done/total.
+func BarString(done, total, width int) string {
+	filled := width * Percent(done, total) / 100
+	if filled > width {
+		filled = width
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// formatDuration rounds d for display: 4s, 2m10s, 1h5m.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return "<1s"
+	case d < time.Minute:
+		return d.Round(time.Second).String()
+	case d < time.Hour:
+		d = d.Round(time.Second)
+		return fmt.Sprintf("%dm%ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+}
@@ -0,0 +1,65 @@
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLine(t *testing.T) {
+	tests := []struct {
+		done, total int
+		elapsed     time.Duration
+		want        string
+	}{
+		{0, 5000, 0, "Importing  0/5000    0%"},
+		{1500, 5000, 3 * time.Second, "Importing  1500/5000   30%  ETA 7s"},
+		{10, 1000, 10 * time.Second, "Importing  10/1000    1%  ETA 16m30s"},
+		{5000, 5000, time.Minute, "Importing  5000/5000  100%"},
+	}
+	for _, tt := range tests {
+		if got := Line("Importing", tt.done, tt.total, tt.elapsed); got != tt.want {
+			t.Errorf("Line(%d, %d, %v) = %q, want %q", tt.done, tt.total, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestBar(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Unix(0, 0)
+	b := New(&out, "Importing", 3)
+	b.now = func() time.Time { return now }
+	b.start = now
+
+	now = now.Add(time.Second)
+	b.Add(1)
+	b.Add(1) // too soon to redraw
+	if got := strings.Count(out.String(), "\r"); got != 1 {
+		t.Errorf("drew %d times, want 1: %q", got, out.String())
+	}
+	b.Add(1) // the last one always draws
+	if !strings.HasSuffix(out.String(), "Importing  3/3  100%") || b.Done() != 3 {
+		t.Errorf("output = %q, done = %d", out.String(), b.Done())
+	}
+	b.Finish()
+	if !strings.HasSuffix(out.String(), "\r\033[K") {
+		t.Errorf("Finish didn't clear the line: %q", out.String())
+	}
+
+	quiet := New(nil, "x", 2)
+	quiet.Add(2)
+	quiet.Finish()
+	if quiet.Done() != 2 {
+		t.Errorf("Done = %d", quiet.Done())
+	}
+}
+
+func TestBarString(t *testing.T) {
+	if got := BarString(1, 4, 8); got != "██░░░░░░" {
+		t.Errorf("BarString = %q", got)
+	}
+	if got := BarString(9, 4, 4); got != "████" {
+		t.Errorf("BarString over = %q", got)
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+
+package protect
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+)
+
+// IsTerminal reports whether f is a terminal a passphrase can be read from.
+// /dev/null is a character device too, so ask stty rather than checking
+// the file mode.
+func IsTerminal(f *os.File) bool {
+	cmd := exec.Command("stty", "-g")
+	cmd.Stdin = f
+	return cmd.Run() == nil
+}
+
+// withoutEcho runs fn with the terminal f's echo turned off, turning it
+// back on afterwards, even if fn is interrupted.
+func withoutEcho(f *os.File, fn func() error) error {
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = f
+		return cmd.Run()
+	}
+	if err := stty("-echo"); err != nil {
+		return fmt.Errorf("failed to turn off echo: %w", err)
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-interrupted:
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+	defer func() {
+		signal.Stop(interrupted)
+		close(done)
+		stty("echo")
+	}()
+	return fn()
+}
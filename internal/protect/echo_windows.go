@@ -0,0 +1,31 @@
+//go:build windows
+
+package protect
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// IsTerminal reports whether f is a console a passphrase can be read from.
+func IsTerminal(f *os.File) bool {
+	var mode uint32
+	return windows.GetConsoleMode(windows.Handle(f.Fd()), &mode) == nil
+}
+
+// withoutEcho runs fn with the console f's echo turned off, turning it
+// back on afterwards.
+func withoutEcho(f *os.File, fn func() error) error {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return fmt.Errorf("failed to turn off echo: %w", err)
+	}
+	if err := windows.SetConsoleMode(h, mode&^windows.ENABLE_ECHO_INPUT); err != nil {
+		return fmt.Errorf("failed to turn off echo: %w", err)
+	}
+	defer windows.SetConsoleMode(h, mode)
+	return fn()
+}
@@ -0,0 +1,253 @@
+// Package protect keeps chosen profiles behind a passphrase. Using a
+// protected profile, to open it in the TUI or export its vars, needs the
+// passphrase, and entering it unlocks the profile for a while, the way
+// sudo remembers a password.
+//
+// It guards against exposing a profile by accident, such as loading
+// production secrets into every shell or showing them on a shared screen.
+// It is not encryption: values stay readable in the database file. Like
+// the trust list, the protected profiles are kept in a local file, never in
+// the database, so they don't sync.
+package protect
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is how long an unlock lasts unless configured otherwise.
+const DefaultTimeout = 15 * time.Minute
+
+// iterations is the PBKDF2 work factor for new passphrases. Entries keep
+// their own count, so it can be raised without breaking existing ones.
+const iterations = 200_000
+
+// ErrWrongPassphrase is returned for a passphrase that doesn't match.
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// List is the set of protected profiles and their passphrases.
+type List struct {
+	path     string
+	Profiles map[string]Entry `json:"profiles"`
+}
+
+// Entry is a protected profile's passphrase, as a salted PBKDF2-SHA256
+// hash.
+type Entry struct {
+	Salt       string `json:"salt"`
+	Hash       string `json:"hash"`
+	Iterations int    `json:"iterations"`
+}
+
+// Load reads the list at path. A missing file yields an empty list.
+func Load(path string) (*List, error) {
+	l := &List{path: path, Profiles: make(map[string]Entry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, err
+	}
+	if l.Profiles == nil {
+		l.Profiles = make(map[string]Entry)
+	}
+	return l, nil
+}
+
+// Save writes the list back to the file it was loaded from.
+func (l *List) Save() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(l.path, append(data, '\n'), 0600)
+}
+
+// Protected reports whether profile needs a passphrase.
+func (l *List) Protected(profile string) bool {
+	_, ok := l.Profiles[profile]
+	return ok
+}
+
+// Names returns the protected profiles, sorted.
+func (l *List) Names() []string {
+	names := make([]string, 0, len(l.Profiles))
+	for name := range l.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Protect puts profile behind passphrase, replacing any it had.
+func (l *List) Protect(profile, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	l.Profiles[profile] = Entry{
+		Salt:       hex.EncodeToString(salt),
+		Hash:       hex.EncodeToString(pbkdf2Key([]byte(passphrase), salt, iterations)),
+		Iterations: iterations,
+	}
+	return nil
+}
+
+// Remove stops protecting profile, reporting whether it was protected.
+func (l *List) Remove(profile string) bool {
+	if !l.Protected(profile) {
+		return false
+	}
+	delete(l.Profiles, profile)
+	return true
+}
+
+// Check returns ErrWrongPassphrase unless passphrase is profile's. An
+// unprotected profile takes any passphrase.
+func (l *List) Check(profile, passphrase string) error {
+	e, ok := l.Profiles[profile]
+	if !ok {
+		return nil
+	}
+	salt, err := hex.DecodeString(e.Salt)
+	if err != nil {
+		return fmt.Errorf("invalid entry for %s: %w", profile, err)
+	}
+	want, err := hex.DecodeString(e.Hash)
+	if err != nil {
+		return fmt.Errorf("invalid entry for %s: %w", profile, err)
+	}
+	if subtle.ConstantTimeCompare(pbkdf2Key([]byte(passphrase), salt, e.Iterations), want) != 1 {
+		return ErrWrongPassphrase
+	}
+	return nil
+}
+
+// pbkdf2Key derives a 32-byte key with PBKDF2-HMAC-SHA256 (RFC 8018).
+// One block is all a 32-byte key needs.
+func pbkdf2Key(password, salt []byte, iter int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iter; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// Session records which profiles are unlocked, one file per profile in a
+// directory only the user can read.
+type Session struct {
+	dir string
+	now func() time.Time
+}
+
+// unlock is what a session file holds. Hash ties it to the passphrase it
+// was unlocked with, so changing the passphrase locks the profile.
+type unlock struct {
+	Expires time.Time `json:"expires"`
+	Hash    string    `json:"hash"`
+}
+
+// NewSession returns the session kept in dir.
+func NewSession(dir string) *Session {
+	return &Session{dir: dir, now: time.Now}
+}
+
+// Unlock checks passphrase and, if it's right, unlocks profile for ttl.
+func (s *Session) Unlock(l *List, profile, passphrase string, ttl time.Duration) error {
+	if err := l.Check(profile, passphrase); err != nil {
+		return err
+	}
+	if !l.Protected(profile) {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(unlock{Expires: s.now().Add(ttl).UTC(), Hash: l.Profiles[profile].Hash})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file(profile), data, 0600)
+}
+
+// Unlocked reports whether profile can be used without the passphrase:
+// it isn't protected, or it was unlocked and that hasn't expired.
+func (s *Session) Unlocked(l *List, profile string) bool {
+	e, ok := l.Profiles[profile]
+	if !ok {
+		return true
+	}
+	data, err := os.ReadFile(s.file(profile))
+	if err != nil {
+		return false
+	}
+	var u unlock
+	if err := json.Unmarshal(data, &u); err != nil {
+		return false
+	}
+	return u.Hash == e.Hash && s.now().Before(u.Expires)
+}
+
+// Lock forgets that profile was unlocked.
+func (s *Session) Lock(profile string) error {
+	err := os.Remove(s.file(profile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// file names profile's session file; profile names can hold anything.
+func (s *Session) file(profile string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(profile)))
+}
+
+// ReadPassphrase writes prompt to w and reads a line from the terminal f
+// without echoing it.
+func ReadPassphrase(f *os.File, w io.Writer, prompt string) (string, error) {
+	fmt.Fprint(w, prompt)
+	var line string
+	err := withoutEcho(f, func() error {
+		var err error
+		line, err = bufio.NewReader(f).ReadString('\n')
+		return err
+	})
+	fmt.Fprintln(w)
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
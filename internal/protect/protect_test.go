@@ -0,0 +1,105 @@
+package protect
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPBKDF2Vectors(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vectors, P="password", S="salt", dkLen=32
+	for iter, want := range map[int]string{
+		1: "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b",
+		2: "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43",
+	} {
+		if got := hex.EncodeToString(pbkdf2Key([]byte("password"), []byte("salt"), iter)); got != want {
+			t.Errorf("pbkdf2Key(c=%d) = %s, want %s", iter, got, want)
+		}
+	}
+}
+
+func TestListPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "enva", "protected.json")
+
+	l, err := Load(path)
+	if err != nil || len(l.Profiles) != 0 {
+		t.Fatalf("Load on missing file = %+v, %v", l, err)
+	}
+	if err := l.Protect("production", ""); err == nil {
+		t.Error("Protect should refuse an empty passphrase")
+	}
+	if err := l.Protect("production", "open sesame"); err != nil {
+		t.Fatalf("Protect failed: %v", err)
+	}
+	if err := l.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "open sesame") {
+		t.Errorf("passphrase stored in the clear: %s", data)
+	}
+
+	l, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !l.Protected("production") || l.Protected("default") {
+		t.Errorf("Names = %v", l.Names())
+	}
+	if err := l.Check("production", "open sesame"); err != nil {
+		t.Errorf("Check with the right passphrase = %v", err)
+	}
+	if err := l.Check("production", "guess"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Check with a wrong passphrase = %v", err)
+	}
+	if err := l.Check("default", "anything"); err != nil {
+		t.Errorf("Check on an unprotected profile = %v", err)
+	}
+	if !l.Remove("production") || l.Remove("production") {
+		t.Error("Remove should report only protected profiles")
+	}
+}
+
+func TestSessionUnlock(t *testing.T) {
+	l := &List{Profiles: make(map[string]Entry)}
+	l.Protect("prod/eu", "open sesame")
+	s := NewSession(filepath.Join(t.TempDir(), "unlocked"))
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	if s.Unlocked(l, "prod/eu") || !s.Unlocked(l, "default") {
+		t.Fatal("only the protected profile should start locked")
+	}
+	if err := s.Unlock(l, "prod/eu", "guess", time.Minute); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("Unlock with a wrong passphrase = %v", err)
+	}
+	if err := s.Unlock(l, "prod/eu", "open sesame", time.Minute); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if !s.Unlocked(l, "prod/eu") {
+		t.Error("profile should be unlocked")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if s.Unlocked(l, "prod/eu") {
+		t.Error("unlock should expire")
+	}
+
+	now = now.Add(-2 * time.Minute)
+	l.Protect("prod/eu", "new passphrase")
+	if s.Unlocked(l, "prod/eu") {
+		t.Error("changing the passphrase should lock the profile")
+	}
+
+	s.Unlock(l, "prod/eu", "new passphrase", time.Minute)
+	if err := s.Lock("prod/eu"); err != nil || s.Unlocked(l, "prod/eu") {
+		t.Errorf("Lock = %v; profile should be locked", err)
+	}
+	if err := s.Lock("prod/eu"); err != nil {
+		t.Errorf("Lock on a locked profile = %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Package provider turns secret manager references, such as
+// aws-sm://prod/db-password, into the shell commands that read them. A var
+// set from a reference is an --eval var that stores the reference itself;
+// it's turned into a command, with the region and project configured at
+// the time, whenever it's exported, and its output is cached like any
+// other eval value. Reading
+// goes through the aws, gcloud and vault CLIs, which already handle
+// credentials, profiles and SSO; for Vault, this package also logs in with
+// AppRole and renews tokens.
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/nick-skriabin/enva/internal/pass"
+)
+
+// Reference schemes.
+const (
+	AWSSecretsManager = "aws-sm"  // aws-sm://NAME[?region=R]
+	AWSParameterStore = "aws-ssm" // aws-ssm://NAME[?region=R]
+	GCPSecretManager  = "gcp-sm"  // gcp-sm://NAME[?project=P&version=V]
+	Pass              = "pass"    // pass://ENTRY
+)
+
+// Options fills in what a reference leaves out.
+type Options struct {
+	AWSRegion  string // --region for aws; "" uses the CLI's own default
+	GCPProject string // --project for gcloud; "" uses the CLI's own default
+	PassTool   string // pass.Pass or pass.Gopass; "" is pass.Pass
+}
+
+// IsRef reports whether value uses one of the reference schemes.
+func IsRef(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+	if !ok {
+		return false
+	}
+	switch scheme {
+	case AWSSecretsManager, AWSParameterStore, GCPSecretManager, Vault, Pass:
+		return true
+	}
+	return false
+}
+
+// Command returns the shell command that prints the secret ref points to.
+func Command(ref string, opts Options) (string, error) {
+	scheme, rest, ok := strings.Cut(ref, "://")
+	if !ok {
+		return "", fmt.Errorf("invalid reference %q: expected SCHEME://NAME", ref)
+	}
+	name, rawQuery, _ := strings.Cut(rest, "?")
+	if name == "" {
+		return "", fmt.Errorf("invalid reference %q: no secret name", ref)
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("invalid reference %q: %w", ref, err)
+	}
+	param := func(key, fallback string) string {
+		if v := query.Get(key); v != "" {
+			return v
+		}
+		return fallback
+	}
+
+	var allowed []string
+	var cmd string
+	switch scheme {
+	case AWSSecretsManager:
+		allowed = []string{"region"}
+		cmd = "aws secretsmanager get-secret-value --secret-id " + quote(name) + " --query SecretString --output text"
+		if region := param("region", opts.AWSRegion); region != "" {
+			cmd += " --region " + quote(region)
+		}
+	case AWSParameterStore:
+		allowed = []string{"region"}
+		cmd = "aws ssm get-parameter --name " + quote(name) + " --with-decryption --query Parameter.Value --output text"
+		if region := param("region", opts.AWSRegion); region != "" {
+			cmd += " --region " + quote(region)
+		}
+	case GCPSecretManager:
+		allowed = []string{"project", "version"}
+		cmd = "gcloud secrets versions access " + quote(param("version", "latest")) + " --secret " + quote(name)
+		if project := param("project", opts.GCPProject); project != "" {
+			cmd += " --project " + quote(project)
+		}
+	case Vault:
+		allowed = []string{"version"}
+		if cmd, err = vaultCommand(name, query.Get("version")); err != nil {
+			return "", fmt.Errorf("invalid reference %q: %w", ref, err)
+		}
+	case Pass:
+		tool := opts.PassTool
+		if tool == "" {
+			tool = pass.Pass
+		}
+		cmd = pass.Command(tool, name)
+	default:
+		return "", fmt.Errorf("unknown reference scheme %q: use %s, %s, %s, %s or %s", scheme, AWSSecretsManager, AWSParameterStore, GCPSecretManager, Vault, Pass)
+	}
+
+	for key := range query {
+		found := false
+		for _, a := range allowed {
+			found = found || key == a
+		}
+		if !found {
+			return "", fmt.Errorf("invalid reference %q: %s:// takes no %q parameter", ref, scheme, key)
+		}
+	}
+	return cmd, nil
+}
+
+// quote single-quotes s for sh.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
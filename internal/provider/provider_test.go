@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	opts := Options{AWSRegion: "eu-west-1", GCPProject: "acme"}
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"aws-sm://prod/db-password", "aws secretsmanager get-secret-value --secret-id 'prod/db-password' --query SecretString --output text --region 'eu-west-1'"},
+		{"aws-sm://prod/db?region=us-east-1", "aws secretsmanager get-secret-value --secret-id 'prod/db' --query SecretString --output text --region 'us-east-1'"},
+		{"aws-ssm:///prod/db", "aws ssm get-parameter --name '/prod/db' --with-decryption --query Parameter.Value --output text --region 'eu-west-1'"},
+		{"gcp-sm://db-password", "gcloud secrets versions access 'latest' --secret 'db-password' --project 'acme'"},
+		{"gcp-sm://db-password?version=3&project=other", "gcloud secrets versions access '3' --secret 'db-password' --project 'other'"},
+		{"vault://secret/prod/db#password", "vault kv get -mount='secret' -field='password' 'prod/db'"},
+		{"vault://kv/app?version=2", "vault kv get -mount='kv' -field='value' -version='2' 'app'"},
+		{"pass://it's", `pass show 'it'\''s' | { IFS= read -r p && printf '%s\n' "$p"; }`},
+	}
+	for _, tt := range tests {
+		got, err := Command(tt.ref, opts)
+		if err != nil || got != tt.want {
+			t.Errorf("Command(%q) = %q, %v\n want %q", tt.ref, got, err, tt.want)
+		}
+	}
+
+	if got, _ := Command("aws-sm://x", Options{}); strings.Contains(got, "--region") {
+		t.Errorf("Command without a region = %q", got)
+	}
+	for _, bad := range []string{"aws-sm://", "vault://x", "vault://secret#f", "azure://x", "plain", "aws-sm://x?project=p", "gcp-sm://x?region=r"} {
+		if _, err := Command(bad, opts); err == nil {
+			t.Errorf("Command(%q) should fail", bad)
+		}
+	}
+}
+
+func TestIsRef(t *testing.T) {
+	for ref, want := range map[string]bool{
+		"aws-sm://x":          true,
+		"gcp-sm://x":          true,
+		"pass://x":            true,
+		"vault://kv/x":        true,
+		"https://example.com": false,
+		"plain":               false,
+	} {
+		if got := IsRef(ref); got != want {
+			t.Errorf("IsRef(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
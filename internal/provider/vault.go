@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Vault is the reference scheme for HashiCorp Vault KV v2 secrets:
+// vault://MOUNT/PATH[#FIELD][?version=N]. FIELD defaults to "value".
+const Vault = "vault"
+
+// vaultCommand returns the vault kv get command for a reference's name
+// (MOUNT/PATH#FIELD) and query.
+func vaultCommand(name, version string) (string, error) {
+	name, field, _ := strings.Cut(name, "#")
+	if field == "" {
+		field = "value"
+	}
+	mount, path, ok := strings.Cut(name, "/")
+	if !ok || mount == "" || path == "" {
+		return "", fmt.Errorf("expected %s://MOUNT/PATH[#FIELD]", Vault)
+	}
+	cmd := "vault kv get -mount=" + quote(mount) + " -field=" + quote(field)
+	if version != "" {
+		cmd += " -version=" + quote(version)
+	}
+	return cmd + " " + quote(path), nil
+}
+
+// UsesVault reports whether an --eval value, a vault:// reference or a
+// vault command, reads from Vault, so it depends on a Vault session.
+func UsesVault(value string) bool {
+	return strings.HasPrefix(value, Vault+"://") || strings.HasPrefix(value, "vault ")
+}
+
+// VaultSession is a Vault token and when it expires. A zero Expires never
+// expires.
+type VaultSession struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// Valid reports whether the session can still be used at now, leaving a
+// minute's margin.
+func (s *VaultSession) Valid(now time.Time) bool {
+	return s.Token != "" && (s.Expires.IsZero() || now.Add(time.Minute).Before(s.Expires))
+}
+
+// vaultAuth is the part of vault's JSON output describing a token.
+type vaultAuth struct {
+	Auth *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+	Data *struct {
+		TTL int `json:"ttl"`
+	} `json:"data"`
+}
+
+// VaultLogin logs in with the AppRole credentials in VAULT_ROLE_ID and
+// VAULT_SECRET_ID. ok is false when they aren't set.
+func VaultLogin() (session *VaultSession, ok bool, err error) {
+	roleID, secretID := os.Getenv("VAULT_ROLE_ID"), os.Getenv("VAULT_SECRET_ID")
+	if roleID == "" || secretID == "" {
+		return nil, false, nil
+	}
+	// The secret goes in on stdin: anyone on the machine can read argv
+	var out vaultAuth
+	if err := runVault("", strings.NewReader(secretID), &out, "write", "-format=json", "auth/approle/login", "role_id="+roleID, "secret_id=-"); err != nil {
+		return nil, true, fmt.Errorf("vault approle login: %w", err)
+	}
+	if out.Auth == nil || out.Auth.ClientToken == "" {
+		return nil, true, fmt.Errorf("vault approle login returned no token")
+	}
+	return newSession(out.Auth.ClientToken, out.Auth.LeaseDuration), true, nil
+}
+
+// VaultRenew renews token ("" for the vault CLI's own: VAULT_TOKEN or
+// ~/.vault-token) and returns when it now expires.
+func VaultRenew(token string) (*VaultSession, error) {
+	var out vaultAuth
+	if err := runVault(token, nil, &out, "token", "renew", "-format=json"); err != nil {
+		return nil, fmt.Errorf("vault token renew: %w", err)
+	}
+	if out.Auth == nil {
+		return nil, fmt.Errorf("vault token renew returned no token")
+	}
+	return newSession(token, out.Auth.LeaseDuration), nil
+}
+
+// VaultLookup checks that token ("" for the vault CLI's own) still works
+// and returns how long it has left; zero means it doesn't expire.
+func VaultLookup(token string) (time.Duration, error) {
+	var out vaultAuth
+	if err := runVault(token, nil, &out, "token", "lookup", "-format=json"); err != nil {
+		return 0, err
+	}
+	if out.Data == nil {
+		return 0, fmt.Errorf("vault token lookup returned no token")
+	}
+	return time.Duration(out.Data.TTL) * time.Second, nil
+}
+
+// newSession returns a session for token lasting ttl seconds.
+func newSession(token string, ttl int) *VaultSession {
+	s := &VaultSession{Token: token}
+	if ttl > 0 {
+		s.Expires = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+	return s
+}
+
+// runVault runs the vault CLI with args, as token if set and with stdin if
+// not nil, and decodes its JSON output into out.
+func runVault(token string, stdin io.Reader, out any, args ...string) error {
+	cmd := exec.Command("vault", args...)
+	if token != "" {
+		cmd.Env = append(os.Environ(), "VAULT_TOKEN="+token)
+	}
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return json.Unmarshal(stdout.Bytes(), out)
+}
@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeVault puts a vault on PATH that answers login, renew and lookup,
+// failing lookup and renew for the token "expired". Login only succeeds
+// with the secret ID "secret" read from stdin.
+func fakeVault(t *testing.T) {
+	t.Helper()
+	bin := t.TempDir()
+	script := `#!/bin/sh
+case "$1 $2" in
+"write -format=json")
+	[ "$5" = secret_id=- ] && [ "$(cat)" = secret ] || exit 1
+	echo '{"auth":{"client_token":"s.approle","lease_duration":3600}}' ;;
+"token renew"|"token lookup")
+	[ "$VAULT_TOKEN" = expired ] && { echo 'permission denied' >&2; exit 2; }
+	echo '{"auth":{"client_token":"x","lease_duration":7200},"data":{"ttl":600}}' ;;
+*) exit 1 ;;
+esac
+`
+	os.WriteFile(filepath.Join(bin, "vault"), []byte(script), 0755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestVaultLogin(t *testing.T) {
+	fakeVault(t)
+	t.Setenv("VAULT_ROLE_ID", "")
+	if _, ok, err := VaultLogin(); ok || err != nil {
+		t.Errorf("VaultLogin without AppRole = %v, %v", ok, err)
+	}
+
+	t.Setenv("VAULT_ROLE_ID", "role")
+	t.Setenv("VAULT_SECRET_ID", "secret")
+	s, ok, err := VaultLogin()
+	if !ok || err != nil {
+		t.Fatalf("VaultLogin = %v, %v", ok, err)
+	}
+	if s.Token != "s.approle" || !s.Valid(time.Now()) || s.Valid(time.Now().Add(2*time.Hour)) {
+		t.Errorf("session = %+v", s)
+	}
+}
+
+func TestVaultRenewAndLookup(t *testing.T) {
+	fakeVault(t)
+	s, err := VaultRenew("s.token")
+	if err != nil || s.Token != "s.token" || time.Until(s.Expires) < time.Hour {
+		t.Errorf("VaultRenew = %+v, %v", s, err)
+	}
+	if ttl, err := VaultLookup("s.token"); err != nil || ttl != 10*time.Minute {
+		t.Errorf("VaultLookup = %v, %v", ttl, err)
+	}
+	if _, err := VaultLookup("expired"); err == nil {
+		t.Error("VaultLookup should fail for an expired token")
+	}
+	if _, err := VaultRenew("expired"); err == nil {
+		t.Error("VaultRenew should fail for an expired token")
+	}
+}
+
+func TestUsesVault(t *testing.T) {
+	cmd, _ := Command("vault://secret/db", Options{})
+	if !UsesVault(cmd) || !UsesVault("vault://secret/db") || UsesVault("aws secretsmanager get-secret-value") || UsesVault("aws-sm://db") {
+		t.Error("UsesVault should only match vault references and commands")
+	}
+	if (&VaultSession{}).Valid(time.Now()) || !(&VaultSession{Token: "t"}).Valid(time.Now()) {
+		t.Error("Valid should need a token and accept sessions without expiry")
+	}
+}
@@ -0,0 +1,123 @@
+// Package redact keeps variable values out of error messages, warnings and
+// anything else printed about a value rather than the value itself.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Placeholder stands in for a redacted value.
+const Placeholder = "[redacted]"
+
+// minScrubLen is the shortest value Error scrubs. Shorter values would
+// match ordinary words and numbers in messages, and make poor secrets.
+const minScrubLen = 4
+
+// ValueRef carries a value without showing it: formatted with any fmt
+// verb, or marshaled to JSON, it prints as Placeholder. Only Value reads
+// it, so passing a ValueRef to an error or a log line can't leak it.
+// ValueError uses it to carry the value of a failed write.
+type ValueRef struct {
+	value string
+}
+
+// Ref wraps value.
+func Ref(value string) ValueRef {
+	return ValueRef{value: value}
+}
+
+// Value returns the wrapped value.
+func (r ValueRef) Value() string { return r.value }
+
+// String returns Placeholder.
+func (r ValueRef) String() string { return Placeholder }
+
+// GoString returns Placeholder, for %#v.
+func (r ValueRef) GoString() string { return Placeholder }
+
+// Format writes Placeholder whatever the verb, so %q and %x don't bypass
+// String.
+func (r ValueRef) Format(f fmt.State, verb rune) {
+	io.WriteString(f, Placeholder)
+}
+
+// MarshalJSON encodes the ref as the Placeholder string.
+func (r ValueRef) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Placeholder)
+}
+
+// Line returns a KEY=VALUE line for reporting, with everything after the
+// first = replaced by Placeholder. A line without = is returned as it is:
+// there's no value in it to hide.
+func Line(line string) string {
+	if key, _, ok := strings.Cut(line, "="); ok {
+		return key + "=" + Placeholder
+	}
+	return line
+}
+
+// Lines applies Line to each of lines.
+func Lines(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = Line(l)
+	}
+	return out
+}
+
+// Error returns err with every occurrence of values in its message replaced
+// by Placeholder, for errors from code that may quote what it was given,
+// such as a database driver. errors.Is and errors.As still see err. Values
+// shorter than four bytes are left alone.
+func Error(err error, values ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	scrubbed := msg
+	for _, v := range values {
+		if len(v) >= minScrubLen {
+			scrubbed = strings.ReplaceAll(scrubbed, v, Placeholder)
+		}
+	}
+	if scrubbed == msg {
+		return err
+	}
+	return &scrubbedError{err: err, msg: scrubbed}
+}
+
+// ValueError is a failure to write a variable. It carries the value as a
+// ValueRef, so callers that need it back, to retry or restore an edit, can
+// get it with errors.As without it ever being printed.
+type ValueError struct {
+	Key   string
+	Value ValueRef
+	Err   error
+}
+
+// WrapValue returns err as a *ValueError for key and value, or nil if err
+// is nil.
+func WrapValue(err error, key, value string) error {
+	if err == nil {
+		return nil
+	}
+	return &ValueError{Key: key, Value: Ref(value), Err: err}
+}
+
+// Error names the key and gives Err's message with the value scrubbed.
+func (e *ValueError) Error() string {
+	return e.Key + ": " + Error(e.Err, e.Value.Value()).Error()
+}
+
+func (e *ValueError) Unwrap() error { return e.Err }
+
+type scrubbedError struct {
+	err error
+	msg string
+}
+
+func (e *scrubbedError) Error() string { return e.msg }
+func (e *scrubbedError) Unwrap() error { return e.err }
@@ -0,0 +1,82 @@
+package redact
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestValueRef(t *testing.T) {
+	r := Ref("hunter22")
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%10s"} {
+		if got := fmt.Sprintf(verb, r); strings.Contains(got, "hunter22") || !strings.Contains(got, Placeholder) {
+			t.Errorf("Sprintf(%s) = %q", verb, got)
+		}
+	}
+	inStruct := struct {
+		Key   string
+		Value ValueRef
+	}{"PASSWORD", r}
+	if got := fmt.Sprintf("%+v", inStruct); strings.Contains(got, "hunter22") {
+		t.Errorf("struct formatted as %q", got)
+	}
+	if data, _ := json.Marshal(inStruct); strings.Contains(string(data), "hunter22") {
+		t.Errorf("struct marshaled as %s", data)
+	}
+	if r.Value() != "hunter22" {
+		t.Errorf("Value = %q", r.Value())
+	}
+}
+
+func TestLine(t *testing.T) {
+	tests := map[string]string{
+		"1KEY=secret value": "1KEY=" + Placeholder,
+		"KEY=a=b":           "KEY=" + Placeholder,
+		"just words":        "just words",
+	}
+	for line, want := range tests {
+		if got := Line(line); got != want {
+			t.Errorf("Line(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	base := fmt.Errorf("insert failed near 'hunter22': %w", fs.ErrPermission)
+	err := Error(base, "hunter22", "abc")
+	if strings.Contains(err.Error(), "hunter22") {
+		t.Errorf("Error = %q", err)
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Error("scrubbed error no longer wraps the original")
+	}
+	if got := Error(base, "x"); got != base {
+		t.Error("Error should return err unchanged when nothing matches")
+	}
+	if Error(nil, "hunter22") != nil {
+		t.Error("Error(nil) should be nil")
+	}
+}
+
+func TestValueError(t *testing.T) {
+	base := fmt.Errorf("insert failed near 'hunter22': %w", fs.ErrPermission)
+	err := WrapValue(base, "PASSWORD", "hunter22")
+	for _, verb := range []string{"%v", "%+v", "%s", "%q"} {
+		if got := fmt.Sprintf(verb, err); strings.Contains(got, "hunter22") || !strings.Contains(got, "PASSWORD") {
+			t.Errorf("Sprintf(%s) = %q", verb, got)
+		}
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Error("ValueError no longer wraps the original")
+	}
+	var ve *ValueError
+	if !errors.As(err, &ve) || ve.Value.Value() != "hunter22" {
+		t.Errorf("errors.As found %+v", ve)
+	}
+	if WrapValue(nil, "PASSWORD", "hunter22") != nil {
+		t.Error("WrapValue(nil) should be nil")
+	}
+}
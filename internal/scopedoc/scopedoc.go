@@ -0,0 +1,419 @@
+// Package scopedoc formats and parses the TOML document enva edit
+// --scope-root opens: one table per directory, named by its path, holding
+// that directory's vars as strings with their descriptions as trailing
+// comments.
+package scopedoc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nick-skriabin/enva/internal/redact"
+)
+
+// Var is a variable in a section.
+type Var struct {
+	Key         string
+	Value       string
+	Description string
+}
+
+// Section holds the vars of one directory.
+type Section struct {
+	Path string
+	Vars []Var
+}
+
+// Format renders sections as a TOML document below header, a comment
+// block written as is.
+func Format(header string, sections []Section) string {
+	var b strings.Builder
+	b.WriteString(header)
+	for i, s := range sections {
+		if i > 0 || header != "" {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s]\n", quote(s.Path))
+		for _, v := range s.Vars {
+			fmt.Fprintf(&b, "%s = %s", formatKey(v.Key), quote(v.Value))
+			if v.Description != "" {
+				fmt.Fprintf(&b, "  # %s", strings.ReplaceAll(v.Description, "\n", " "))
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// Parse reads a document written by Format, after editing. Besides basic
+// strings it accepts literal ('...') and multi-line strings, and bare
+// integers, floats and booleans, kept as written. A key defined twice in
+// one section, or before any section, is an error.
+func Parse(doc string) ([]Section, error) {
+	p := &parser{src: doc, line: 1}
+	var sections []Section
+	seen := make(map[string]int)
+	for {
+		p.skipBlank()
+		if p.done() {
+			break
+		}
+		if p.peek() == '[' {
+			p.pos++
+			p.skipSpace()
+			path, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if p.done() || p.peek() != ']' {
+				return nil, p.errorf("expected ] after section name")
+			}
+			p.pos++
+			if _, dup := seen[path]; dup {
+				return nil, p.errorf("section %q appears twice", path)
+			}
+			if _, err := p.endLine(); err != nil {
+				return nil, err
+			}
+			seen[path] = len(sections)
+			sections = append(sections, Section{Path: path})
+			continue
+		}
+
+		if len(sections) == 0 {
+			return nil, p.errorf("var outside a section: start with [\"/path\"]")
+		}
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.done() || p.peek() != '=' {
+			return nil, p.errorf("expected = after %s", key)
+		}
+		p.pos++
+		p.skipSpace()
+		value, err := p.value()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		s := &sections[len(sections)-1]
+		for _, v := range s.Vars {
+			if v.Key == key {
+				return nil, p.errorf("%s appears twice in [%q]", key, s.Path)
+			}
+		}
+		desc, err := p.endLine()
+		if err != nil {
+			return nil, err
+		}
+		s.Vars = append(s.Vars, Var{Key: key, Value: value, Description: desc})
+	}
+	return sections, nil
+}
+
+// quote returns s as a TOML basic string.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// formatKey returns key bare when TOML allows it, else quoted.
+func formatKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for _, r := range key {
+		if !isBare(r) {
+			return quote(key)
+		}
+	}
+	return key
+}
+
+func isBare(r rune) bool {
+	return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-'
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.src) }
+
+func (p *parser) peek() byte { return p.src[p.pos] }
+
+func (p *parser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, newlines and comment lines.
+func (p *parser) skipBlank() {
+	for !p.done() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for !p.done() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endLine consumes the rest of the line, returning its comment if any.
+func (p *parser) endLine() (string, error) {
+	p.skipSpace()
+	comment := ""
+	if !p.done() && p.peek() == '#' {
+		start := p.pos + 1
+		for !p.done() && p.peek() != '\n' {
+			p.pos++
+		}
+		comment = strings.TrimSpace(p.src[start:p.pos])
+	}
+	if !p.done() && p.peek() == '\r' {
+		p.pos++
+	}
+	if !p.done() {
+		if p.peek() != '\n' {
+			return "", p.errorf("unexpected text after value")
+		}
+		p.pos++
+		p.line++
+	}
+	return comment, nil
+}
+
+// rest returns what's left of the current line, for errors.
+func (p *parser) rest() string {
+	end := strings.IndexByte(p.src[p.pos:], '\n')
+	if end < 0 {
+		return p.src[p.pos:]
+	}
+	return strings.TrimRight(p.src[p.pos:p.pos+end], "\r")
+}
+
+// key reads a bare or quoted key.
+func (p *parser) key() (string, error) {
+	if p.done() {
+		return "", p.errorf("expected a key")
+	}
+	switch p.peek() {
+	case '"':
+		return p.basic()
+	case '\'':
+		return p.literal()
+	}
+	start := p.pos
+	for !p.done() && p.peek() < utf8.RuneSelf && isBare(rune(p.peek())) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a key, got %q", redact.Line(p.rest()))
+	}
+	return p.src[start:p.pos], nil
+}
+
+// value reads a string, or a bare number or boolean kept as written.
+func (p *parser) value() (string, error) {
+	if p.done() {
+		return "", p.errorf("missing value")
+	}
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], `"""`):
+		return p.multiline(`"""`)
+	case strings.HasPrefix(p.src[p.pos:], `'''`):
+		return p.multiline(`'''`)
+	case p.peek() == '"':
+		return p.basic()
+	case p.peek() == '\'':
+		return p.literal()
+	}
+
+	start := p.pos
+	for !p.done() && !strings.ContainsRune(" \t\r\n#", rune(p.peek())) {
+		p.pos++
+	}
+	word := p.src[start:p.pos]
+	if word == "true" || word == "false" {
+		return word, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(word, "_", ""), 64); err == nil && word != "" {
+		return word, nil
+	}
+	return "", p.errorf("values must be quoted strings, numbers or booleans")
+}
+
+// basic reads a "..." string with escapes.
+func (p *parser) basic() (string, error) {
+	p.pos++ // opening quote
+	var b strings.Builder
+	for {
+		if p.done() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		if c == '"' {
+			p.pos++
+			return b.String(), nil
+		}
+		if c == '\\' {
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte(c)
+		p.pos++
+	}
+}
+
+// literal reads a '...' string, taken as is.
+func (p *parser) literal() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.src[p.pos:], "'\n")
+	if end < 0 || p.src[p.pos+end] != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+// multiline reads a string between delim, three double or three single
+// quotes. A newline right after the opening quotes is dropped, and between
+// double quotes a backslash at the end of a line joins it to the next
+// non-blank text.
+func (p *parser) multiline(delim string) (string, error) {
+	p.pos += len(delim)
+	if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+		p.pos += 2
+		p.line++
+	} else if strings.HasPrefix(p.src[p.pos:], "\n") {
+		p.pos++
+		p.line++
+	}
+
+	var b strings.Builder
+	for {
+		if p.done() {
+			return "", p.errorf("unterminated %s string", delim)
+		}
+		if strings.HasPrefix(p.src[p.pos:], delim) {
+			// Up to two quotes may end the content, as in """a"""""
+			n := len(delim)
+			for n < 5 && p.pos+n < len(p.src) && p.src[p.pos+n] == delim[0] {
+				n++
+			}
+			b.WriteString(p.src[p.pos : p.pos+n-len(delim)])
+			p.pos += n
+			return b.String(), nil
+		}
+		c := p.peek()
+		if c == '\n' {
+			p.line++
+		}
+		if c == '\\' && delim == `"""` {
+			if rest := strings.TrimLeft(p.src[p.pos+1:], " \t\r"); strings.HasPrefix(rest, "\n") {
+				// Line-ending backslash: skip the break and leading whitespace
+				p.pos++
+				for !p.done() && strings.ContainsRune(" \t\r\n", rune(p.peek())) {
+					if p.peek() == '\n' {
+						p.line++
+					}
+					p.pos++
+				}
+				continue
+			}
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte(c)
+		p.pos++
+	}
+}
+
+// escape reads the escape sequence at the current backslash into b.
+func (p *parser) escape(b *strings.Builder) error {
+	p.pos++
+	if p.done() {
+		return p.errorf("unterminated escape")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'n':
+		b.WriteByte('\n')
+	case 't':
+		b.WriteByte('\t')
+	case 'r':
+		b.WriteByte('\r')
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'e':
+		b.WriteByte(0x1b)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.src) {
+			return p.errorf("short \\%c escape", c)
+		}
+		code, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return p.errorf("invalid \\%c escape", c)
+		}
+		b.WriteRune(rune(code))
+		p.pos += n
+	default:
+		return p.errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
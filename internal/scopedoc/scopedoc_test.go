@@ -0,0 +1,101 @@
+package scopedoc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	sections := []Section{
+		{Path: "/home/me/app", Vars: []Var{
+			{Key: "DB_URL", Value: `postgres://u:"p"@h/db`, Description: "Primary # not a comment"},
+			{Key: "CERT", Value: "line1\nline2\t\\end"},
+			{Key: "weird.key", Value: ""},
+		}},
+		{Path: "/home/me/app/api"},
+		{Path: "/home/me/app/api/v2", Vars: []Var{{Key: "X", Value: "\x01é"}}},
+	}
+	doc := Format("# header\n", sections)
+	if !strings.HasPrefix(doc, "# header\n\n[\"/home/me/app\"]\n") {
+		t.Errorf("Format =\n%s", doc)
+	}
+	got, err := Parse(doc)
+	if err != nil {
+		t.Fatalf("Parse failed: %v\n%s", err, doc)
+	}
+	if !reflect.DeepEqual(got, sections) {
+		t.Errorf("Parse(Format()) =\n%+v\nwant\n%+v", got, sections)
+	}
+}
+
+func TestParse(t *testing.T) {
+	doc := `# comment
+["/a"]
+PORT = 5432
+DEBUG = true  # Verbose logs
+LIT = 'C:\path'
+'quoted key' = "v"
+
+[ '/a/b' ]
+PEM = """
+-----BEGIN-----
+abc
+-----END-----"""
+JOINED = """one \
+         two"""
+RAW = '''
+no \escapes'''
+`
+	got, err := Parse(doc)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := []Section{
+		{Path: "/a", Vars: []Var{
+			{Key: "PORT", Value: "5432"},
+			{Key: "DEBUG", Value: "true", Description: "Verbose logs"},
+			{Key: "LIT", Value: `C:\path`},
+			{Key: "quoted key", Value: "v"},
+		}},
+		{Path: "/a/b", Vars: []Var{
+			{Key: "PEM", Value: "-----BEGIN-----\nabc\n-----END-----"},
+			{Key: "JOINED", Value: "one two"},
+			{Key: "RAW", Value: `no \escapes`},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct{ doc, want string }{
+		{"A = \"1\"\n", "line 1: var outside a section"},
+		{"[\"/a\"]\nA = unquoted\n", "line 2: values must be quoted"},
+		{"[\"/a\"]\nA = \"1\"\nA = \"2\"\n", "line 3: A appears twice"},
+		{"[\"/a\"]\n[\"/a\"]\n", "line 2: section \"/a\" appears twice"},
+		{"[\"/a\"]\nA = \"open\n", "line 2: unterminated string"},
+		{"[\"/a\"]\nA = \"1\" junk\n", "line 2: unexpected text after value"},
+		{"[\"/a\"]\nA = \"\\q\"\n", "line 2: invalid escape"},
+		{"[\"/a\"\n", "line 1: expected ]"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.doc)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.doc, err, tt.want)
+		}
+	}
+
+	// Errors point at the line but never quote a value
+	for _, doc := range []string{
+		"[\"/a\"]\nA = hunter22\n",
+		"[\"/a\"]\nA = \"1\" hunter22\n",
+		"[\"/a\"]\n$A = \"hunter22\"\n",
+		"[\"/a\"]\nA = \"\\uhunt\"\n",
+	} {
+		if _, err := Parse(doc); err == nil || strings.Contains(err.Error(), "hunt") {
+			t.Errorf("Parse(%q) error = %v", doc, err)
+		}
+	}
+}
@@ -4,10 +4,12 @@ package search
 import (
 	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/sahilm/fuzzy"
 
 	"github.com/nick-skriabin/enva/internal/env"
+	"github.com/nick-skriabin/enva/internal/secrets"
 )
 
 // SearchResult represents a search result with match information.
@@ -31,9 +33,71 @@ type searchSource []searchItem
 func (s searchSource) String(i int) string { return s[i].text }
 func (s searchSource) Len() int            { return len(s) }
 
+// Options tune how Search matches. The zero value is the default: fuzzy,
+// case-insensitive matching over keys and values.
+type Options struct {
+	Exact         bool // Match the query as a substring instead of fuzzily
+	CaseSensitive bool // Match letters in the query's case only
+	KeysOnly      bool // Ignore values
+	MinScore      int  // Drop fuzzy matches scoring below this; 0 keeps all
+
+	// Local is the directory being searched from. Vars defined there rank
+	// above inherited ones.
+	Local string
+}
+
+// Ranking bonuses added to match scores. A query that is a prefix of the
+// key or value beats one whose letters are scattered through it, and a var
+// defined in the current directory beats an inherited one.
+const (
+	prefixBonus = 20
+	localBonus  = 10
+)
+
+// splitTagFilters pulls tag:NAME terms out of a query, returning the
+// remaining fuzzy query and the lowercased tags.
+func splitTagFilters(query string) (string, []string) {
+	if !strings.Contains(query, "tag:") {
+		return query, nil
+	}
+
+	var rest, tags []string
+	for _, field := range strings.Fields(query) {
+		if tag, ok := strings.CutPrefix(field, "tag:"); ok && tag != "" {
+			tags = append(tags, strings.ToLower(tag))
+		} else {
+			rest = append(rest, field)
+		}
+	}
+	return strings.Join(rest, " "), tags
+}
+
 // Search performs fuzzy search over vars, matching against both key and value.
-// Returns results sorted by score desc, then key asc.
+// Sensitive vars (see secrets.IsSensitive) match on their key only, so a
+// query can't probe a secret or highlight it.
+// Terms of the form tag:NAME restrict results to vars carrying every such tag.
+// Returns results sorted by score desc, then key asc, then defining path asc.
 func Search(vars []*env.ResolvedVar, query string) []*SearchResult {
+	return SearchWith(vars, query, Options{})
+}
+
+// SearchWith is Search with matching tuned by opts.
+func SearchWith(vars []*env.ResolvedVar, query string, opts Options) []*SearchResult {
+	query, tags := splitTagFilters(query)
+	if len(tags) > 0 {
+		filtered := make([]*env.ResolvedVar, 0, len(vars))
+	next:
+		for _, v := range vars {
+			for _, tag := range tags {
+				if !v.HasTag(tag) {
+					continue next
+				}
+			}
+			filtered = append(filtered, v)
+		}
+		vars = filtered
+	}
+
 	if query == "" {
 		// No query: return all vars sorted by key
 		results := make([]*SearchResult, len(vars))
@@ -50,17 +114,34 @@ func Search(vars []*env.ResolvedVar, query string) []*SearchResult {
 	source := make(searchSource, 0, len(vars)*2)
 	for i, v := range vars {
 		source = append(source, searchItem{idx: i, text: v.Key, isKey: true, varPtr: v})
-		source = append(source, searchItem{idx: i, text: v.Value, isKey: false, varPtr: v})
+		if !opts.KeysOnly && !secrets.IsSensitive(v.Key, v.Value) {
+			source = append(source, searchItem{idx: i, text: v.Value, isKey: false, varPtr: v})
+		}
 	}
 
-	// Perform fuzzy match
-	matches := fuzzy.FindFrom(query, source)
+	var matches []fuzzy.Match
+	if opts.Exact {
+		matches = findExact(query, source, opts.CaseSensitive)
+	} else {
+		for _, m := range fuzzy.FindFrom(query, source) {
+			if opts.CaseSensitive && !sameCase(query, m) {
+				continue
+			}
+			if opts.MinScore != 0 && m.Score < opts.MinScore {
+				continue
+			}
+			matches = append(matches, m)
+		}
+	}
 
 	// Aggregate results by var index
 	resultMap := make(map[int]*SearchResult)
 	for _, m := range matches {
 		item := source[m.Index]
 		varIdx := item.idx
+		if hasPrefix(item.text, query, opts.CaseSensitive) {
+			m.Score += prefixBonus
+		}
 
 		if existing, ok := resultMap[varIdx]; ok {
 			// Take max score
@@ -90,20 +171,98 @@ func Search(vars []*env.ResolvedVar, query string) []*SearchResult {
 	// Convert to slice
 	results := make([]*SearchResult, 0, len(resultMap))
 	for _, r := range resultMap {
+		if opts.Local != "" && r.Var.DefinedAtPath == opts.Local {
+			r.Score += localBonus
+		}
 		results = append(results, r)
 	}
 
-	// Sort by score desc, then key asc
+	// Sort by score desc, then key asc, then path asc
 	sort.Slice(results, func(i, j int) bool {
 		if results[i].Score != results[j].Score {
 			return results[i].Score > results[j].Score
 		}
-		return results[i].Var.Key < results[j].Var.Key
+		if results[i].Var.Key != results[j].Var.Key {
+			return results[i].Var.Key < results[j].Var.Key
+		}
+		return results[i].Var.DefinedAtPath < results[j].Var.DefinedAtPath
 	})
 
 	return results
 }
 
+// hasPrefix reports whether text starts with query, ignoring case unless
+// caseSensitive.
+func hasPrefix(text, query string, caseSensitive bool) bool {
+	if len(text) < len(query) {
+		return false
+	}
+	if caseSensitive {
+		return strings.HasPrefix(text, query)
+	}
+	return strings.EqualFold(text[:len(query)], query)
+}
+
+// sameCase reports whether the characters a fuzzy match found are in the
+// query's case; the matcher itself ignores case.
+func sameCase(query string, m fuzzy.Match) bool {
+	i := 0
+	for _, r := range query {
+		if i >= len(m.MatchedIndexes) {
+			return false
+		}
+		got, _ := utf8.DecodeRuneInString(m.Str[m.MatchedIndexes[i]:])
+		if got != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// findExact matches query as a substring of each item, first occurrence
+// only. Keys matching at the start score highest, then other key matches,
+// then value matches.
+func findExact(query string, source searchSource, caseSensitive bool) []fuzzy.Match {
+	var matches []fuzzy.Match
+	for i, item := range source {
+		pos := indexOf(item.text, query, caseSensitive)
+		if pos < 0 {
+			continue
+		}
+		score := 1
+		if item.isKey {
+			score = 2
+			if pos == 0 {
+				score = 3
+			}
+		}
+		indexes := make([]int, 0, len(query))
+		for j := range item.text[pos : pos+len(query)] {
+			indexes = append(indexes, pos+j)
+		}
+		matches = append(matches, fuzzy.Match{Str: item.text, Index: i, MatchedIndexes: indexes, Score: score})
+	}
+	return matches
+}
+
+// indexOf returns the byte index of the first instance of substr in s, or
+// -1. Without caseSensitive, letters match regardless of case.
+func indexOf(s, substr string, caseSensitive bool) int {
+	if caseSensitive {
+		return strings.Index(s, substr)
+	}
+	for i := range s {
+		if i+len(substr) > len(s) {
+			break
+		}
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
 // mergeIndices merges two sorted index slices, removing duplicates.
 func mergeIndices(a, b []int) []int {
 	seen := make(map[int]bool)
@@ -0,0 +1,91 @@
+// Package secrets detects values that look like credentials.
+package secrets
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Finding describes a credential-like pattern found in a value.
+type Finding struct {
+	Kind string // Human-readable credential type, e.g. "AWS access key"
+}
+
+// pattern pairs a credential type with its detector.
+type pattern struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+var patterns = []pattern{
+	{"AWS access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"GitHub token", regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,}\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_\-]{20,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`)},
+	{"Stripe secret key", regexp.MustCompile(`\b[sr]k_live_[A-Za-z0-9]{16,}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN ([A-Z]+ )*PRIVATE KEY-----`)},
+}
+
+// Scan returns the credential types detected in value, without duplicates.
+func Scan(value string) []Finding {
+	var findings []Finding
+	seen := make(map[string]bool)
+	for _, p := range patterns {
+		if seen[p.kind] {
+			continue
+		}
+		if p.re.MatchString(value) {
+			findings = append(findings, Finding{Kind: p.kind})
+			seen[p.kind] = true
+		}
+	}
+	return findings
+}
+
+// sensitiveKeyParts are key name fragments that suggest a credential.
+var sensitiveKeyParts = []string{"SECRET", "TOKEN", "PASSWORD", "PASSWD", "PRIVATE", "API_KEY", "APIKEY", "CREDENTIAL", "AUTH"}
+
+// IsSensitiveKey reports whether a key name suggests it holds a credential.
+func IsSensitiveKey(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(upper, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSensitive reports whether a key/value pair likely holds a credential.
+func IsSensitive(key, value string) bool {
+	return IsSensitiveKey(key) || len(Scan(value)) > 0
+}
+
+// tokenPrefixes are the fixed prefixes of well-known token formats. They
+// say what kind of token a value is without giving any of it away.
+var tokenPrefixes = []string{
+	"github_pat_", "ghp_", "gho_", "ghu_", "ghs_", "ghr_", "glpat-",
+	"xoxa-", "xoxb-", "xoxp-", "xoxr-", "xoxs-", "sk_live_", "rk_live_",
+	"AKIA", "ASIA", "AIza",
+}
+
+// mask is what Mask shows in place of a value, the same width whatever
+// the value's length.
+const mask = "••••••••"
+
+// Mask returns a placeholder for value, safe to print to terminal
+// scrollback. It shows no characters of the value and not its length;
+// only a well-known token prefix such as ghp_ is kept.
+func Mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	for _, p := range tokenPrefixes {
+		if len(value) > len(p) && strings.HasPrefix(value, p) {
+			return p + mask
+		}
+	}
+	return mask
+}
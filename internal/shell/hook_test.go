@@ -0,0 +1,80 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestHookScripts drives a real interactive bash through the expect scripts
+// in testdata/hook, checking the tracking state across pushd/popd, cd -,
+// nested shells, user overrides and profile switches.
+func TestHookScripts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping shell-level tests in short mode")
+	}
+	expectBin, err := exec.LookPath("expect")
+	if err != nil {
+		t.Skip("expect not installed")
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+
+	bin := t.TempDir()
+	build := exec.Command("go", "build", "-o", filepath.Join(bin, "enva"), "../../cmd/enva")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build enva: %v\n%s", err, out)
+	}
+
+	work := t.TempDir()
+	for _, dir := range []string{"proj/sub", "other"} {
+		if err := os.MkdirAll(filepath.Join(work, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(work, "proj", ".enva"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	environ := []string{
+		"HOME=" + work,
+		"PATH=" + bin + string(os.PathListSeparator) + os.Getenv("PATH"),
+		"ENVA_DB=" + filepath.Join(work, "enva.db"),
+		"ENVA_CONFIG=" + filepath.Join(work, "config.json"),
+		"PS1=ENVA> ",
+		"TERM=dumb",
+		"WORK=" + work,
+	}
+
+	enva := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command(filepath.Join(bin, "enva"), args...)
+		cmd.Dir = filepath.Join(work, dir)
+		cmd.Env = environ
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("enva %s failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	enva("proj", "set", "FOO=proj")
+	enva("proj", "set", "BAR=bar")
+	enva("proj/sub", "set", "SUB=sub")
+	enva("proj", "--profile", "staging", "set", "FOO=staging")
+
+	scripts, err := filepath.Glob(filepath.Join("testdata", "hook", "*.exp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range scripts {
+		name := strings.TrimSuffix(filepath.Base(script), ".exp")
+		t.Run(name, func(t *testing.T) {
+			cmd := exec.Command(expectBin, script)
+			cmd.Env = environ
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("%s failed: %v\n%s", name, err, out)
+			}
+		})
+	}
+}
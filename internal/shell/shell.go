@@ -2,8 +2,17 @@
 package shell
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/nick-skriabin/enva/internal/env"
 )
@@ -31,16 +40,257 @@ func FormatExportWithDesc(key, value, description string) string {
 	return fmt.Sprintf("export %s='%s'", key, escaped)
 }
 
+// FormatAlias formats an alias definition. The name=value form is
+// understood by bash, zsh and fish alike.
+func FormatAlias(name, command string) string {
+	return fmt.Sprintf("alias %s='%s'", name, escapeSingleQuote(command))
+}
+
+// FormatUnalias formats the removal of an alias for shellName. Fish aliases
+// are functions, so they are erased as such.
+func FormatUnalias(shellName, name string) string {
+	if shellName == "fish" {
+		return fmt.Sprintf("functions -e %s", name)
+	}
+	return fmt.Sprintf("unalias %s 2>/dev/null", name)
+}
+
+// FormatLaunchctl formats a variable as a launchctl setenv line, which makes
+// it visible to GUI applications started by launchd on macOS.
+func FormatLaunchctl(key, value string) string {
+	return fmt.Sprintf("launchctl setenv %s '%s'", key, escapeSingleQuote(value))
+}
+
+// FormatLaunchctlUnset formats the removal of a variable from launchd.
+func FormatLaunchctlUnset(key string) string {
+	return "launchctl unsetenv " + key
+}
+
+// CommentStyle controls where descriptions are written in generated output.
+type CommentStyle int
+
+const (
+	CommentsNone      CommentStyle = iota // Descriptions are omitted
+	CommentsTrailing                      // KEY=value # description
+	CommentsPreceding                     // # description line above KEY=value
+)
+
+// ParseCommentStyle parses a --comments value: none, trailing or preceding.
+func ParseCommentStyle(s string) (CommentStyle, error) {
+	switch s {
+	case "none":
+		return CommentsNone, nil
+	case "trailing":
+		return CommentsTrailing, nil
+	case "preceding":
+		return CommentsPreceding, nil
+	}
+	return CommentsNone, fmt.Errorf("invalid comment style %q (use none, trailing or preceding)", s)
+}
+
+// FormatWithComment attaches a description to a formatted line in the
+// given style. Multi-line descriptions become several preceding comment
+// lines, or are joined onto one line when trailing.
+func FormatWithComment(line, description string, style CommentStyle) string {
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return line
+	}
+
+	switch style {
+	case CommentsTrailing:
+		return line + " # " + strings.Join(strings.Fields(description), " ")
+	case CommentsPreceding:
+		var b strings.Builder
+		for _, l := range strings.Split(description, "\n") {
+			b.WriteString(strings.TrimRight("# "+strings.TrimSpace(l), " "))
+			b.WriteString("\n")
+		}
+		b.WriteString(line)
+		return b.String()
+	}
+	return line
+}
+
+// FormatDotenv formats a variable as a .env line. Plain values are written
+// bare; values with spaces, quotes, # or $ are single-quoted, and values that
+// contain a single quote or newline are double-quoted with escapes.
+func FormatDotenv(key, value string) string {
+	if value == "" || !strings.ContainsAny(value, " \t\n\r#'\"\\$`") {
+		return key + "=" + value
+	}
+	if !strings.ContainsAny(value, "'\n\r") {
+		return key + "='" + value + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`)
+	return key + `="` + r.Replace(value) + `"`
+}
+
+// ParseDotenv parses .env content as FormatDotenv writes it: bare,
+// single-quoted and double-quoted values (with \\, \", \n, \r and \$
+// escapes), an optional "export " prefix, and # comments. It returns the
+// values by key, last one winning, and the lines it couldn't parse.
+func ParseDotenv(content string) (map[string]string, []string) {
+	result := make(map[string]string)
+	var invalid []string
+	unescape := strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n", `\r`, "\r", `\$`, "$")
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || !IsValidKey(key) {
+			invalid = append(invalid, line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := closingQuote(value)
+			if end < 0 {
+				invalid = append(invalid, line)
+				continue
+			}
+			value = unescape.Replace(value[1:end])
+		case strings.HasPrefix(value, "'"):
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				invalid = append(invalid, line)
+				continue
+			}
+			value = value[1 : end+1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			}
+			value = strings.TrimSpace(value)
+		}
+		result[key] = value
+	}
+	return result, invalid
+}
+
+// EnvFileNames are the files ReadEnvFiles reads, later ones overriding
+// earlier ones.
+var EnvFileNames = []string{".env", ".env.local"}
+
+// ReadEnvFiles reads the EnvFileNames files in dir with ParseDotenv. It
+// returns the merged values and the file each came from; missing files are
+// skipped and unparseable lines ignored. It is an env.EnvFileReader.
+func ReadEnvFiles(dir string) (map[string]string, map[string]string, error) {
+	vars := make(map[string]string)
+	files := make(map[string]string)
+	for _, name := range EnvFileNames {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		parsed, _ := ParseDotenv(string(data))
+		for key, value := range parsed {
+			vars[key] = value
+			files[key] = path
+		}
+	}
+	return vars, files, nil
+}
+
+// RewriteDotenv returns .env content with its variables set to values.
+// Lines for changed keys are rewritten with FormatDotenv, lines for keys not
+// in values are dropped and new keys are appended, sorted. Comments, blank
+// lines and lines already holding the right value are kept as they are.
+func RewriteDotenv(content string, values map[string]string) string {
+	var lines []string
+	if content != "" {
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+	written := make(map[string]bool)
+	out := lines[:0:0]
+	for _, line := range lines {
+		parsed, _ := ParseDotenv(line)
+		if len(parsed) == 0 {
+			out = append(out, line)
+			continue
+		}
+		for key, old := range parsed {
+			value, ok := values[key]
+			switch {
+			case !ok || written[key]:
+			case value == old:
+				out = append(out, line)
+			default:
+				out = append(out, FormatDotenv(key, value))
+			}
+			written[key] = true
+		}
+	}
+
+	var added []string
+	for key := range values {
+		if !written[key] {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+	for _, key := range added {
+		out = append(out, FormatDotenv(key, values[key]))
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// closingQuote returns the index of the double quote that closes s, which
+// starts with one, skipping backslash escapes, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
 // FormatKeyValue formats a variable as KEY=value (for display).
 func FormatKeyValue(key, value string) string {
 	return fmt.Sprintf("%s=%s", key, value)
 }
 
-// FormatExportLines formats all resolved vars as export lines.
+// StripInvalidKeys removes vars whose key isn't a valid variable name from
+// ctx, returning their keys, sorted. An export line for such a key would be
+// run as shell code by eval. Keys are checked when they are stored; this
+// catches any that got past.
+func StripInvalidKeys(ctx *env.ResolveContext) []string {
+	var stripped []string
+	for key := range ctx.Resolved {
+		if !IsValidKey(key) {
+			delete(ctx.Resolved, key)
+			stripped = append(stripped, key)
+		}
+	}
+	sort.Strings(stripped)
+	return stripped
+}
+
+// FormatExportLines formats all resolved vars as export lines, leaving out
+// invalid keys.
 func FormatExportLines(ctx *env.ResolveContext) string {
 	vars := ctx.GetSortedVars()
 	var lines []string
 	for _, v := range vars {
+		if !IsValidKey(v.Key) {
+			continue
+		}
 		lines = append(lines, FormatExport(v.Key, v.Value))
 	}
 	return strings.Join(lines, "\n")
@@ -183,6 +433,36 @@ func IsValidKey(key string) bool {
 	return true
 }
 
+// IsValidAlias checks if an alias name is safe to emit unquoted in any
+// supported shell: letters, digits, '_', '-' and '.', not starting with '-'.
+func IsValidAlias(name string) bool {
+	if name == "" || name[0] == '-' {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !((c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidTask checks if a task name can be given to enva run: letters,
+// digits, '_', '-', '.' and ':' (as in test:unit), not starting with '-'.
+func IsValidTask(name string) bool {
+	if name == "" || name[0] == '-' {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !((c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
 // ParseEnvFile parses multiple KEY=value lines (without descriptions).
 // Returns a map of key->value and a list of invalid lines.
 // Last value wins for duplicate keys.
@@ -232,3 +512,202 @@ func ParseEnvFileWithDesc(content string) (map[string]ParsedVar, []string) {
 
 	return result, invalid
 }
+
+// KeyMap renames keys as they're imported or exported, to fit a vendor's
+// .env or a tool's conventions (Terraform's TF_VAR_, Vite's VITE_). An
+// exact rename wins; other keys lose StripPrefix, if they have it, are
+// lowercased if Lower is set, then gain Prefix.
+type KeyMap struct {
+	Rename      map[string]string
+	StripPrefix string
+	Lower       bool
+	Prefix      string
+}
+
+// IsZero reports whether km leaves every key as it is.
+func (km KeyMap) IsZero() bool {
+	return len(km.Rename) == 0 && km.StripPrefix == "" && !km.Lower && km.Prefix == ""
+}
+
+// Key returns the name key is renamed to.
+func (km KeyMap) Key(key string) string {
+	if to, ok := km.Rename[key]; ok {
+		return to
+	}
+	key = strings.TrimPrefix(key, km.StripPrefix)
+	if km.Lower {
+		key = strings.ToLower(key)
+	}
+	return km.Prefix + key
+}
+
+// Check fails if one of keys would be renamed to an invalid key or two of
+// them to the same name.
+func (km KeyMap) Check(keys []string) error {
+	keys = slices.Sorted(slices.Values(keys))
+	from := make(map[string]string, len(keys))
+	for _, k := range keys {
+		to := km.Key(k)
+		if !IsValidKey(to) {
+			return fmt.Errorf("%s would be renamed to invalid key %q", k, to)
+		}
+		if prev, ok := from[to]; ok {
+			return fmt.Errorf("%s and %s would both be renamed to %s", prev, k, to)
+		}
+		from[to] = k
+	}
+	return nil
+}
+
+// Apply renames the keys of vars, failing as Check does.
+func (km KeyMap) Apply(vars map[string]ParsedVar) (map[string]ParsedVar, error) {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	if err := km.Check(keys); err != nil {
+		return nil, err
+	}
+	result := make(map[string]ParsedVar, len(vars))
+	for k, v := range vars {
+		result[km.Key(k)] = v
+	}
+	return result, nil
+}
+
+// SupportedShells lists the shells that have hook integration.
+var SupportedShells = []string{"bash", "zsh", "fish"}
+
+// DetectShell returns the supported shell named by $SHELL, or "" if unknown.
+func DetectShell() string {
+	name := filepath.Base(os.Getenv("SHELL"))
+	for _, s := range SupportedShells {
+		if name == s {
+			return s
+		}
+	}
+	return ""
+}
+
+// HookLine returns the config line that activates the enva hook for a shell.
+func HookLine(shellName string) string {
+	if shellName == "fish" {
+		return "enva hook fish | source"
+	}
+	return fmt.Sprintf(`eval "$(enva hook %s)"`, shellName)
+}
+
+// DirenvStdlib returns a direnv library defining "use enva [PROFILE]", which
+// exports the effective vars for the .envrc's directory. direnv reloads when
+// any of the watch files (the database) change. The hook's tracking state is
+// hidden from export, so vars a prompt hook loaded are never unset.
+func DirenvStdlib(watch []string) string {
+	var b strings.Builder
+	b.WriteString("# enva direnv library: use \"use enva\" or \"use enva PROFILE\" in an .envrc\n")
+	b.WriteString("use_enva() {\n")
+	b.WriteString("  local profile=${1:-${ENVA_PROFILE:-}}\n")
+	if len(watch) > 0 {
+		b.WriteString("  watch_file")
+		for _, f := range watch {
+			b.WriteString(" '" + escapeSingleQuote(f) + "'")
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "  eval \"$(env -u %s -u %s -u %s enva export --comments=none ${profile:+--profile \"$profile\"})\"\n",
+		StateVar, LegacyKeysVar, LegacyPathVar)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// RCFile returns the config file the hook line belongs in for a shell.
+func RCFile(shellName, home string) string {
+	switch shellName {
+	case "bash":
+		return filepath.Join(home, ".bashrc")
+	case "zsh":
+		return filepath.Join(home, ".zshrc")
+	case "fish":
+		return filepath.Join(home, ".config", "fish", "config.fish")
+	}
+	return ""
+}
+
+// IsHookInstalled reports whether the shell's config file already references the enva hook.
+func IsHookInstalled(shellName, home string) bool {
+	content, err := os.ReadFile(RCFile(shellName, home))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(content), "enva hook "+shellName)
+}
+
+// InstallHook appends the hook line to the shell's config file unless it is
+// already present. Returns the config file path and whether it was modified.
+func InstallHook(shellName, home string) (string, bool, error) {
+	rc := RCFile(shellName, home)
+	if rc == "" {
+		return "", false, fmt.Errorf("unsupported shell: %s", shellName)
+	}
+	if IsHookInstalled(shellName, home) {
+		return rc, false, nil
+	}
+	if err := AppendHook(shellName, home); err != nil {
+		return rc, false, err
+	}
+	return rc, true, nil
+}
+
+// AppendHook appends the hook line to the end of the shell's config file,
+// even if an earlier one exists. Re-adding it last repairs a hook that
+// something later in the file overwrote.
+func AppendHook(shellName, home string) error {
+	rc := RCFile(shellName, home)
+	if rc == "" {
+		return fmt.Errorf("unsupported shell: %s", shellName)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(rc), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(rc, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "\n# enva\n%s\n", HookLine(shellName))
+	return err
+}
+
+// hookProbeTimeout bounds how long ProbeHook waits for a shell to start.
+const hookProbeTimeout = 5 * time.Second
+
+// hookProbes prints, per shell, the registered prompt and directory hooks.
+var hookProbes = map[string]string{
+	"bash": `printf '%s\n' "${PROMPT_COMMAND[*]:-}"`,
+	"zsh":  `print -r -- "${precmd_functions[*]} ${chpwd_functions[*]}"`,
+	"fish": `functions -q _enva_hook; and echo _enva_hook`,
+}
+
+// ProbeHook starts an interactive shell so it loads its config, then reports
+// whether the enva hook is still registered once startup is done. This
+// catches frameworks that overwrite PROMPT_COMMAND or precmd after the enva
+// line ran.
+func ProbeHook(shellName string) (bool, error) {
+	probe, ok := hookProbes[shellName]
+	if !ok {
+		return false, fmt.Errorf("unsupported shell: %s", shellName)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookProbeTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, shellName, "-i", "-c", probe).Output()
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("%s took longer than %s to start", shellName, hookProbeTimeout)
+	}
+	if err != nil && len(out) == 0 {
+		return false, fmt.Errorf("failed to start %s: %w", shellName, err)
+	}
+	return strings.Contains(string(out), "_enva_hook"), nil
+}
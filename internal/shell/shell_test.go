@@ -1,7 +1,13 @@
 package shell
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/nick-skriabin/enva/internal/env"
 )
 
 func TestIsValidKey(t *testing.T) {
@@ -116,6 +122,178 @@ func TestFormatExport(t *testing.T) {
 	}
 }
 
+func TestStripInvalidKeys(t *testing.T) {
+	ctx := &env.ResolveContext{Resolved: map[string]*env.ResolvedVar{
+		"GOOD":           {Key: "GOOD", Value: "1"},
+		"Z;echo PWNED;Q": {Key: "Z;echo PWNED;Q", Value: "v"},
+	}}
+	if stripped := StripInvalidKeys(ctx); len(stripped) != 1 || stripped[0] != "Z;echo PWNED;Q" {
+		t.Errorf("StripInvalidKeys = %q", stripped)
+	}
+	if got := FormatExportLines(ctx); got != "export GOOD='1'" {
+		t.Errorf("FormatExportLines = %q", got)
+	}
+}
+
+func TestFormatAlias(t *testing.T) {
+	if got := FormatAlias("dc", "docker compose -f 'dev.yml'"); got != `alias dc='docker compose -f '\''dev.yml'\'''` {
+		t.Errorf("FormatAlias = %q", got)
+	}
+	if got := FormatUnalias("bash", "dc"); got != "unalias dc 2>/dev/null" {
+		t.Errorf("FormatUnalias(bash) = %q", got)
+	}
+	if got := FormatUnalias("fish", "dc"); got != "functions -e dc" {
+		t.Errorf("FormatUnalias(fish) = %q", got)
+	}
+
+	for name, want := range map[string]bool{"dc": true, "k8s.logs": true, "run-tests": true, "": false, "-x": false, "a b": false, "x;rm": false} {
+		if got := IsValidAlias(name); got != want {
+			t.Errorf("IsValidAlias(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestIsValidTask(t *testing.T) {
+	for name, want := range map[string]bool{"build": true, "test:unit": true, "db.migrate": true, "": false, "--list": false, "a b": false, "x;rm": false} {
+		if got := IsValidTask(name); got != want {
+			t.Errorf("IsValidTask(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestFormatLaunchctl(t *testing.T) {
+	if got := FormatLaunchctl("GREETING", "it's here"); got != `launchctl setenv GREETING 'it'\''s here'` {
+		t.Errorf("FormatLaunchctl = %q", got)
+	}
+	if got := FormatLaunchctlUnset("GREETING"); got != "launchctl unsetenv GREETING" {
+		t.Errorf("FormatLaunchctlUnset = %q", got)
+	}
+}
+
+func TestDirenvStdlib(t *testing.T) {
+	lib := DirenvStdlib([]string{"/data/it's.db"})
+	for _, want := range []string{
+		"use_enva() {",
+		`watch_file '/data/it'\''s.db'`,
+		"env -u " + StateVar,
+		`${profile:+--profile "$profile"}`,
+	} {
+		if !strings.Contains(lib, want) {
+			t.Errorf("DirenvStdlib missing %q:\n%s", want, lib)
+		}
+	}
+	if strings.Contains(DirenvStdlib(nil), "watch_file") {
+		t.Error("DirenvStdlib without files should not watch any")
+	}
+}
+
+func TestFormatDotenv(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"value", "KEY=value"},
+		{"", "KEY="},
+		{"hello world", "KEY='hello world'"},
+		{"a#b", "KEY='a#b'"},
+		{"$HOME", "KEY='$HOME'"},
+		{"it's", `KEY="it's"`},
+		{"line1\nline2", `KEY="line1\nline2"`},
+		{`say "hi" it's`, `KEY="say \"hi\" it's"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := FormatDotenv("KEY", tt.value); got != tt.expected {
+				t.Errorf("FormatDotenv(%q) = %q, want %q", tt.value, got, tt.expected)
+			}
+		})
+	}
+
+	// Single-quoted output parses back to the same value
+	_, parsed, ok := ParseKeyValueWithDesc(FormatWithComment(FormatDotenv("KEY", "a # b"), "note", CommentsTrailing))
+	if !ok || parsed.Value != "a # b" || parsed.Description != "note" {
+		t.Errorf("round trip = %+v, %v", parsed, ok)
+	}
+}
+
+func TestParseDotenv(t *testing.T) {
+	values := []string{"value", "", "hello world", "a#b", "$HOME", "it's", "line1\nline2", `say "hi" it's`, `back\slash$`}
+	var lines []string
+	for i, v := range values {
+		lines = append(lines, FormatWithComment(FormatDotenv(fmt.Sprintf("K%d", i), v), "note", CommentsTrailing))
+	}
+	content := "# header\n\nexport PLAIN=x # trailing\r\n" + strings.Join(lines, "\n") + "\nnot a line\nBAD=\"open\n"
+
+	got, invalid := ParseDotenv(content)
+	for i, v := range values {
+		if key := fmt.Sprintf("K%d", i); got[key] != v {
+			t.Errorf("%s = %q, want %q", key, got[key], v)
+		}
+	}
+	if got["PLAIN"] != "x" {
+		t.Errorf("PLAIN = %q", got["PLAIN"])
+	}
+	if len(invalid) != 2 || invalid[0] != "not a line" {
+		t.Errorf("invalid = %q", invalid)
+	}
+}
+
+func TestReadEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	if vars, _, err := ReadEnvFiles(dir); err != nil || len(vars) != 0 {
+		t.Fatalf("ReadEnvFiles on empty dir = %v, %v", vars, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, ".env"), []byte("A=1\nB=2\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".env.local"), []byte("B=local\n"), 0644)
+	vars, files, err := ReadEnvFiles(dir)
+	if err != nil {
+		t.Fatalf("ReadEnvFiles failed: %v", err)
+	}
+	if vars["A"] != "1" || vars["B"] != "local" {
+		t.Errorf("vars = %v", vars)
+	}
+	if files["A"] != filepath.Join(dir, ".env") || files["B"] != filepath.Join(dir, ".env.local") {
+		t.Errorf("files = %v", files)
+	}
+}
+
+func TestFormatWithComment(t *testing.T) {
+	tests := []struct {
+		name     string
+		desc     string
+		style    CommentStyle
+		expected string
+	}{
+		{"none", "desc", CommentsNone, "K=v"},
+		{"empty desc", "", CommentsTrailing, "K=v"},
+		{"trailing", "API endpoint", CommentsTrailing, "K=v # API endpoint"},
+		{"trailing multiline", "first\nsecond", CommentsTrailing, "K=v # first second"},
+		{"preceding", "API endpoint", CommentsPreceding, "# API endpoint\nK=v"},
+		{"preceding multiline", "first\n\nsecond", CommentsPreceding, "# first\n#\n# second\nK=v"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatWithComment("K=v", tt.desc, tt.style); got != tt.expected {
+				t.Errorf("FormatWithComment = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseCommentStyle(t *testing.T) {
+	for s, want := range map[string]CommentStyle{"none": CommentsNone, "trailing": CommentsTrailing, "preceding": CommentsPreceding} {
+		if got, err := ParseCommentStyle(s); err != nil || got != want {
+			t.Errorf("ParseCommentStyle(%q) = %v, %v", s, got, err)
+		}
+	}
+	if _, err := ParseCommentStyle("above"); err == nil {
+		t.Error("ParseCommentStyle should reject unknown styles")
+	}
+}
+
 func TestFormatKeyValue(t *testing.T) {
 	got := FormatKeyValue("API_KEY", "secret")
 	want := "API_KEY=secret"
@@ -196,3 +374,188 @@ KEY=third
 		t.Errorf("ParseEnvFile duplicate handling: got %q, want 'third'", vars["KEY"])
 	}
 }
+
+func TestKeyMap(t *testing.T) {
+	km := KeyMap{
+		Rename:      map[string]string{"REACT_APP_SENTRY": "SENTRY_DSN"},
+		StripPrefix: "REACT_APP_",
+		Prefix:      "WEB_",
+	}
+	vars := map[string]ParsedVar{
+		"REACT_APP_API_URL": {Value: "https://api"},
+		"REACT_APP_SENTRY":  {Value: "dsn", Description: "Error reporting"},
+		"NODE_ENV":          {Value: "development"},
+	}
+	got, err := km.Apply(vars)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	want := map[string]string{"WEB_API_URL": "https://api", "SENTRY_DSN": "dsn", "WEB_NODE_ENV": "development"}
+	if len(got) != len(want) {
+		t.Errorf("Apply = %v, want keys %v", got, want)
+	}
+	for k, v := range want {
+		if got[k].Value != v {
+			t.Errorf("%s = %q, want %q", k, got[k].Value, v)
+		}
+	}
+	if got["SENTRY_DSN"].Description != "Error reporting" {
+		t.Errorf("description lost: %+v", got["SENTRY_DSN"])
+	}
+
+	tf := KeyMap{Lower: true, Prefix: "TF_VAR_"}
+	if got := tf.Key("AWS_REGION"); got != "TF_VAR_aws_region" {
+		t.Errorf("Key(AWS_REGION) = %q, want TF_VAR_aws_region", got)
+	}
+	if !(KeyMap{Rename: map[string]string{}}).IsZero() || tf.IsZero() {
+		t.Error("IsZero is wrong")
+	}
+
+	if _, err := (KeyMap{StripPrefix: "APP_"}).Apply(map[string]ParsedVar{"APP_PORT": {}, "PORT": {}}); err == nil {
+		t.Error("Apply allowed two keys to collide")
+	}
+	if _, err := (KeyMap{StripPrefix: "APP_"}).Apply(map[string]ParsedVar{"APP_1X": {}}); err == nil {
+		t.Error("Apply allowed an invalid key")
+	}
+}
+
+func TestHookLine(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{"bash", `eval "$(enva hook bash)"`},
+		{"zsh", `eval "$(enva hook zsh)"`},
+		{"fish", "enva hook fish | source"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			if got := HookLine(tt.shell); got != tt.want {
+				t.Errorf("HookLine(%q) = %q, want %q", tt.shell, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectShell(t *testing.T) {
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{"/bin/zsh", "zsh"},
+		{"/usr/local/bin/fish", "fish"},
+		{"/bin/bash", "bash"},
+		{"/bin/tcsh", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv("SHELL", tt.env)
+			if got := DetectShell(); got != tt.want {
+				t.Errorf("DetectShell() with SHELL=%q = %q, want %q", tt.env, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstallHook(t *testing.T) {
+	home := t.TempDir()
+
+	if IsHookInstalled("fish", home) {
+		t.Fatal("IsHookInstalled should be false before install")
+	}
+
+	rc, added, err := InstallHook("fish", home)
+	if err != nil {
+		t.Fatalf("InstallHook failed: %v", err)
+	}
+	if !added {
+		t.Error("InstallHook should report the line was added")
+	}
+	if rc != filepath.Join(home, ".config", "fish", "config.fish") {
+		t.Errorf("InstallHook rc = %q", rc)
+	}
+	if !IsHookInstalled("fish", home) {
+		t.Error("IsHookInstalled should be true after install")
+	}
+
+	// Second install is a no-op
+	_, added, err = InstallHook("fish", home)
+	if err != nil {
+		t.Fatalf("InstallHook failed: %v", err)
+	}
+	if added {
+		t.Error("InstallHook should not add the line twice")
+	}
+
+	content, _ := os.ReadFile(rc)
+	if strings.Count(string(content), HookLine("fish")) != 1 {
+		t.Errorf("hook line should appear once, got:\n%s", content)
+	}
+
+	if _, _, err := InstallHook("tcsh", home); err == nil {
+		t.Error("InstallHook should fail for unsupported shell")
+	}
+}
+
+func TestAppendHook(t *testing.T) {
+	home := t.TempDir()
+
+	if _, _, err := InstallHook("zsh", home); err != nil {
+		t.Fatalf("InstallHook failed: %v", err)
+	}
+
+	rc := RCFile("zsh", home)
+	f, err := os.OpenFile(rc, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("precmd_functions=()\n")
+	f.Close()
+
+	if err := AppendHook("zsh", home); err != nil {
+		t.Fatalf("AppendHook failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(rc)
+	if strings.Count(string(content), HookLine("zsh")) != 2 {
+		t.Errorf("AppendHook should add the line again, got:\n%s", content)
+	}
+	if !strings.HasSuffix(string(content), HookLine("zsh")+"\n") {
+		t.Errorf("hook line should be last, got:\n%s", content)
+	}
+
+	if err := AppendHook("tcsh", home); err == nil {
+		t.Error("AppendHook should fail for unsupported shell")
+	}
+}
+
+func TestProbeHookUnsupported(t *testing.T) {
+	if _, err := ProbeHook("tcsh"); err == nil {
+		t.Error("ProbeHook should fail for unsupported shell")
+	}
+}
+
+func TestRewriteDotenv(t *testing.T) {
+	content := "# Local settings\nKEEP=1\nCHANGE=old # note\n\nexport DROP=x\nDUP=a\nDUP=b\nnot a line\n"
+	got := RewriteDotenv(content, map[string]string{
+		"KEEP":   "1",
+		"CHANGE": "new value",
+		"DUP":    "b",
+		"NEW":    "n",
+		"ADDED":  "a",
+	})
+	want := "# Local settings\nKEEP=1\nCHANGE='new value'\n\nDUP=b\nnot a line\nADDED=a\nNEW=n\n"
+	if got != want {
+		t.Errorf("RewriteDotenv =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := RewriteDotenv("", map[string]string{"A": "1"}); got != "A=1\n" {
+		t.Errorf("RewriteDotenv of empty content = %q", got)
+	}
+	if got := RewriteDotenv("A=1\n", nil); got != "" {
+		t.Errorf("RewriteDotenv without values = %q", got)
+	}
+}
@@ -0,0 +1,186 @@
+package shell
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// StateVar holds the hook's tracking state between prompts.
+const StateVar = "__ENVA_STATE"
+
+// Tracking variables used before StateVar. They are still read so a shell
+// started with an older enva unloads cleanly after an upgrade.
+const (
+	LegacyKeysVar = "__ENVA_LOADED_KEYS"
+	LegacyPathVar = "__ENVA_LOADED_PATH"
+)
+
+var errInvalidState = errors.New("invalid tracking state")
+
+// stateVersion prefixes the encoded state so the format can change later.
+const stateVersion = "1:"
+
+// State records what the hook last loaded into a shell: the directory and
+// profile, a fingerprint of each value it exported and of each alias it
+// defined. Gen increases each
+// time the loaded set changes, and PID names the shell that wrote the state,
+// so nested shells and copied environments can be told apart from the shell
+// that produced them.
+type State struct {
+	Gen     uint64            `json:"gen"`
+	PID     int               `json:"pid,omitempty"`
+	Profile string            `json:"profile"`
+	Path    string            `json:"path"`
+	Sums    map[string]string `json:"sums"`
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// Fingerprint returns a short hash of a value, so the state can tell whether
+// a variable still holds what enva exported without storing the value.
+func Fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadState reads the tracking state from the environment. Legacy state has
+// no fingerprints, so every key it lists is treated as still owned. A missing
+// or unreadable state yields an empty one.
+func LoadState(lookup func(string) (string, bool)) State {
+	if raw, ok := lookup(StateVar); ok {
+		if s, err := DecodeState(raw); err == nil {
+			return s
+		}
+		return State{}
+	}
+
+	s := State{Sums: make(map[string]string)}
+	keys, _ := lookup(LegacyKeysVar)
+	for _, k := range strings.Split(keys, ":") {
+		if k != "" {
+			s.Sums[k] = ""
+		}
+	}
+	s.Path, _ = lookup(LegacyPathVar)
+	return s
+}
+
+// DecodeState parses an encoded state.
+func DecodeState(raw string) (State, error) {
+	var s State
+	if !strings.HasPrefix(raw, stateVersion) {
+		return s, errInvalidState
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(raw, stateVersion))
+	if err != nil {
+		return s, errInvalidState
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, errInvalidState
+	}
+	return s, nil
+}
+
+// Encode returns the state in a form safe to single-quote in any shell.
+func (s State) Encode() string {
+	data, _ := json.Marshal(s)
+	return stateVersion + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Empty reports whether the state tracks nothing.
+func (s State) Empty() bool {
+	return len(s.Sums) == 0 && len(s.Aliases) == 0
+}
+
+// AliasNames returns the tracked alias names, sorted.
+func (s State) AliasNames() []string {
+	names := make([]string, 0, len(s.Aliases))
+	for n := range s.Aliases {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Keys returns the tracked keys, sorted.
+func (s State) Keys() []string {
+	keys := make([]string, 0, len(s.Sums))
+	for k := range s.Sums {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Owns reports whether key was loaded by enva and still holds the value it
+// exported. A key changed or unset since then belongs to the user.
+func (s State) Owns(key string, lookup func(string) (string, bool)) bool {
+	sum, tracked := s.Sums[key]
+	if !tracked {
+		return false
+	}
+	value, present := lookup(key)
+	if !present {
+		return false
+	}
+	return sum == "" || sum == Fingerprint(value)
+}
+
+// Owned returns the set of tracked keys that enva still owns.
+func (s State) Owned(lookup func(string) (string, bool)) map[string]bool {
+	owned := make(map[string]bool)
+	for k := range s.Sums {
+		if s.Owns(k, lookup) {
+			owned[k] = true
+		}
+	}
+	return owned
+}
+
+// Inherited reports whether the state was written by a shell other than pid,
+// i.e. it came in through the environment of a parent shell, a tmux server or
+// similar. A pid of 0 means the caller doesn't know its shell and skips the
+// check.
+func (s State) Inherited(pid int) bool {
+	return pid != 0 && s.PID != pid
+}
+
+// Rederive rebuilds an inherited state from what the environment actually
+// holds: keys that no longer carry the value enva exported are dropped, and
+// the rest are fingerprinted afresh. Aliases aren't inherited by child
+// shells, so none are kept. The result has no owner until the next call to
+// Next.
+func (s State) Rederive(lookup func(string) (string, bool)) State {
+	next := State{Gen: s.Gen, Profile: s.Profile, Path: s.Path, Sums: make(map[string]string)}
+	for k := range s.Owned(lookup) {
+		value, _ := lookup(k)
+		next.Sums[k] = Fingerprint(value)
+	}
+	return next
+}
+
+// Next returns the state after the shell pid loaded sums and aliases at path
+// under profile. Gen only advances when something actually changed.
+func (s State) Next(path, profile string, pid int, sums, aliases map[string]string) State {
+	next := State{Gen: s.Gen, PID: pid, Profile: profile, Path: path, Sums: sums, Aliases: aliases}
+	if s.PID != pid || s.Path != path || s.Profile != profile || !sameSums(s.Sums, sums) || !sameSums(s.Aliases, aliases) {
+		next.Gen++
+	}
+	return next
+}
+
+func sameSums(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
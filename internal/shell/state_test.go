@@ -0,0 +1,154 @@
+package shell
+
+import (
+	"testing"
+)
+
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	s := State{Gen: 3, Profile: "staging", Path: "/tmp/it's here", Sums: map[string]string{"FOO": Fingerprint("bar")}}
+
+	encoded := s.Encode()
+	if !isShellSafe(encoded) {
+		t.Errorf("Encode() = %q, want shell-safe characters", encoded)
+	}
+
+	got, err := DecodeState(encoded)
+	if err != nil {
+		t.Fatalf("DecodeState failed: %v", err)
+	}
+	if got.Gen != 3 || got.Profile != "staging" || got.Path != s.Path || got.Sums["FOO"] != s.Sums["FOO"] {
+		t.Errorf("DecodeState = %+v, want %+v", got, s)
+	}
+
+	for _, bad := range []string{"", "garbage", "1:!!!", "2:" + encoded[2:]} {
+		if _, err := DecodeState(bad); err == nil {
+			t.Errorf("DecodeState(%q) should fail", bad)
+		}
+	}
+}
+
+func isShellSafe(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadState(t *testing.T) {
+	s := State{Gen: 1, Profile: "default", Path: "/p", Sums: map[string]string{"FOO": Fingerprint("bar")}}
+
+	got := LoadState(lookupIn(map[string]string{StateVar: s.Encode()}))
+	if got.Gen != 1 || got.Path != "/p" {
+		t.Errorf("LoadState = %+v", got)
+	}
+
+	// Corrupt state is dropped rather than guessed at
+	got = LoadState(lookupIn(map[string]string{StateVar: "junk", LegacyKeysVar: "FOO"}))
+	if len(got.Sums) != 0 {
+		t.Errorf("LoadState with corrupt state = %+v, want empty", got)
+	}
+
+	// Legacy variables are still understood
+	got = LoadState(lookupIn(map[string]string{LegacyKeysVar: "FOO:BAR", LegacyPathVar: "/old"}))
+	if got.Path != "/old" || len(got.Keys()) != 2 || got.Keys()[0] != "BAR" {
+		t.Errorf("LoadState legacy = %+v", got)
+	}
+}
+
+func TestStateOwns(t *testing.T) {
+	s := State{Sums: map[string]string{
+		"SAME":    Fingerprint("enva"),
+		"CHANGED": Fingerprint("enva"),
+		"GONE":    Fingerprint("enva"),
+		"LEGACY":  "",
+	}}
+	lookup := lookupIn(map[string]string{
+		"SAME":    "enva",
+		"CHANGED": "mine",
+		"LEGACY":  "anything",
+		"OTHER":   "x",
+	})
+
+	owned := s.Owned(lookup)
+	want := map[string]bool{"SAME": true, "LEGACY": true}
+	if len(owned) != len(want) {
+		t.Errorf("Owned = %v, want %v", owned, want)
+	}
+	for k := range want {
+		if !owned[k] {
+			t.Errorf("Owned missing %s", k)
+		}
+	}
+	if s.Owns("OTHER", lookup) {
+		t.Error("Owns should be false for untracked keys")
+	}
+}
+
+func TestStateNext(t *testing.T) {
+	sums := map[string]string{"FOO": Fingerprint("bar")}
+	s := State{Gen: 4, Profile: "default", Path: "/p", Sums: sums}
+
+	if got := s.Next("/p", "default", 0, map[string]string{"FOO": Fingerprint("bar")}, nil); got.Gen != 4 {
+		t.Errorf("Next unchanged Gen = %d, want 4", got.Gen)
+	}
+	if got := s.Next("/q", "default", 0, sums, nil); got.Gen != 5 {
+		t.Errorf("Next new path Gen = %d, want 5", got.Gen)
+	}
+	if got := s.Next("/p", "staging", 0, sums, nil); got.Gen != 5 {
+		t.Errorf("Next new profile Gen = %d, want 5", got.Gen)
+	}
+	if got := s.Next("/p", "default", 0, map[string]string{"FOO": Fingerprint("baz")}, nil); got.Gen != 5 {
+		t.Errorf("Next new value Gen = %d, want 5", got.Gen)
+	}
+	if got := s.Next("/p", "default", 42, sums, nil); got.Gen != 5 || got.PID != 42 {
+		t.Errorf("Next new shell = %+v, want Gen 5 PID 42", got)
+	}
+	aliases := map[string]string{"dc": Fingerprint("docker compose")}
+	got := s.Next("/p", "default", 0, sums, aliases)
+	if got.Gen != 5 || got.Empty() || len(got.AliasNames()) != 1 {
+		t.Errorf("Next new alias = %+v, want Gen 5 with dc", got)
+	}
+	if again := got.Next("/p", "default", 0, sums, aliases); again.Gen != 5 {
+		t.Errorf("Next same alias Gen = %d, want 5", again.Gen)
+	}
+}
+
+func TestStateInherited(t *testing.T) {
+	s := State{Gen: 2, PID: 100, Path: "/p", Sums: map[string]string{
+		"FOO":  Fingerprint("foo"),
+		"BAR":  Fingerprint("bar"),
+		"LEFT": "",
+	}, Aliases: map[string]string{"dc": Fingerprint("docker compose")}}
+
+	if s.Inherited(100) {
+		t.Error("state should not be inherited in the shell that wrote it")
+	}
+	if !s.Inherited(200) {
+		t.Error("state should be inherited in another shell")
+	}
+	if s.Inherited(0) {
+		t.Error("an unknown shell should skip the check")
+	}
+
+	// The child dropped BAR before starting; FOO and the legacy LEFT remain
+	got := s.Rederive(lookupIn(map[string]string{"FOO": "foo", "BAR": "changed", "LEFT": "x"}))
+	if got.PID != 0 || got.Gen != 2 || got.Path != "/p" {
+		t.Errorf("Rederive = %+v", got)
+	}
+	if len(got.Sums) != 2 || got.Sums["FOO"] != Fingerprint("foo") || got.Sums["LEFT"] != Fingerprint("x") {
+		t.Errorf("Rederive sums = %v", got.Sums)
+	}
+	// Aliases live in the shell, not the environment, so the child has none
+	if len(got.Aliases) != 0 {
+		t.Errorf("Rederive aliases = %v, want none", got.Aliases)
+	}
+}
@@ -0,0 +1,70 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SubshellVar is set in shells started by enva shell, to the project root,
+// so nesting can be refused and prompt themes can show it.
+const SubshellVar = "ENVA_SHELL"
+
+// origZdotdirVar carries the user's ZDOTDIR past the one enva substitutes.
+const origZdotdirVar = "__ENVA_ZDOTDIR"
+
+// Subshell prepares an interactive shell whose prompt starts with
+// "(label) ". The user's own startup files still run first. bash and zsh
+// need startup files of their own, written to rcDir, which must outlive the
+// shell. It returns the arguments to pass after the shell's path and the
+// environment entries to add.
+func Subshell(shellPath, label, rcDir string) (args, environ []string, err error) {
+	prefix := "'(" + escapeSingleQuote(label) + ") '"
+
+	switch filepath.Base(shellPath) {
+	case "bash":
+		rc := filepath.Join(rcDir, "bashrc")
+		script := "[ -f ~/.bashrc ] && . ~/.bashrc\n" +
+			"PS1=" + prefix + "\"${PS1-}\"\n"
+		if err := os.WriteFile(rc, []byte(script), 0600); err != nil {
+			return nil, nil, err
+		}
+		return []string{"--rcfile", rc, "-i"}, nil, nil
+
+	case "zsh":
+		// zsh reads its startup files from ZDOTDIR: point it at rcDir, run
+		// the user's files from their real ZDOTDIR, then add the prefix
+		orig := os.Getenv("ZDOTDIR")
+		if orig == "" {
+			orig, _ = os.UserHomeDir()
+		}
+		zshenv := fmt.Sprintf("ZDOTDIR=\"$%[1]s\"\n"+
+			"[ -f \"$ZDOTDIR/.zshenv\" ] && . \"$ZDOTDIR/.zshenv\"\n"+
+			"%[1]s=\"$ZDOTDIR\"\n"+
+			"ZDOTDIR='%[2]s'\n", origZdotdirVar, escapeSingleQuote(rcDir))
+		zshrc := fmt.Sprintf("ZDOTDIR=\"$%[1]s\"\n"+
+			"unset %[1]s\n"+
+			"[ -f \"$ZDOTDIR/.zshrc\" ] && . \"$ZDOTDIR/.zshrc\"\n"+
+			"PROMPT=%[2]s\"$PROMPT\"\n", origZdotdirVar, prefix)
+		if err := os.WriteFile(filepath.Join(rcDir, ".zshenv"), []byte(zshenv), 0600); err != nil {
+			return nil, nil, err
+		}
+		if err := os.WriteFile(filepath.Join(rcDir, ".zshrc"), []byte(zshrc), 0600); err != nil {
+			return nil, nil, err
+		}
+		return []string{"-i"}, []string{"ZDOTDIR=" + rcDir, origZdotdirVar + "=" + orig}, nil
+
+	case "fish":
+		// --init-command runs after config.fish, so wrap whatever prompt it set
+		init := "functions -c fish_prompt __enva_fish_prompt; " +
+			"function fish_prompt; echo -n " + prefix + "; __enva_fish_prompt; end"
+		return []string{"-i", "--init-command", init}, nil, nil
+	}
+
+	// Other shells read PS1 from the environment
+	ps1 := os.Getenv("PS1")
+	if ps1 == "" {
+		ps1 = "$ "
+	}
+	return []string{"-i"}, []string{"PS1=(" + label + ") " + ps1}, nil
+}
@@ -0,0 +1,64 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runSubshell starts shellName as Subshell sets it up, with HOME holding
+// the given startup file, and returns what probe prints.
+func runSubshell(t *testing.T, shellName, rcName, rc, probe string) string {
+	t.Helper()
+	shellPath, err := exec.LookPath(shellName)
+	if err != nil {
+		t.Skipf("%s not installed", shellName)
+	}
+
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, rcName), []byte(rc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("ZDOTDIR", "")
+
+	args, environ, err := Subshell(shellPath, "enva:it's", t.TempDir())
+	if err != nil {
+		t.Fatalf("Subshell failed: %v", err)
+	}
+	cmd := exec.Command(shellPath, append(args, "-c", probe)...)
+	cmd.Env = append(os.Environ(), environ...)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s failed: %v\n%s", shellName, err, out)
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	return lines[len(lines)-1]
+}
+
+func TestSubshellBash(t *testing.T) {
+	got := runSubshell(t, "bash", ".bashrc", "PS1='base$ '\nUSER_RC=1\n", `echo "$USER_RC|$PS1"`)
+	if got != "1|(enva:it's) base$ " {
+		t.Errorf("got %q, want the user's rc run and the prompt prefixed", got)
+	}
+}
+
+func TestSubshellZsh(t *testing.T) {
+	got := runSubshell(t, "zsh", ".zshrc", "PROMPT='base%# '\nUSER_RC=1\n", `print -r -- "$USER_RC|$PROMPT|${__ENVA_ZDOTDIR-unset}"`)
+	if got != "1|(enva:it's) base%# |unset" {
+		t.Errorf("got %q, want the user's rc run and the prompt prefixed", got)
+	}
+}
+
+func TestSubshellOther(t *testing.T) {
+	t.Setenv("PS1", "# ")
+	args, environ, err := Subshell("/bin/dash", "enva:app", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || args[0] != "-i" || len(environ) != 1 || environ[0] != "PS1=(enva:app) # " {
+		t.Errorf("Subshell = %v, %v", args, environ)
+	}
+}
@@ -0,0 +1,52 @@
+# Helpers shared by the hook scripts. The test runner sets WORK to a tree
+# with proj/ (FOO, BAR), proj/sub/ (SUB) and other/ (nothing), PS1 to
+# "ENVA> " and PATH to a freshly built enva.
+
+set timeout 10
+log_user 0
+
+proc fail {msg} {
+    puts stderr "FAIL: $msg"
+    exit 1
+}
+
+proc expect_prompt {} {
+    expect {
+        -ex "ENVA> " {}
+        timeout { fail "timed out waiting for prompt" }
+        eof { fail "shell exited" }
+    }
+}
+
+# start_shell spawns an interactive bash with the hook loaded.
+proc start_shell {} {
+    global spawn_id
+    spawn bash --norc --noprofile -i
+    expect_prompt
+    run "eval \"\$(enva hook bash)\""
+}
+
+# run sends a command line and waits for the next prompt, after which the
+# hook has run.
+proc run {cmd} {
+    send "$cmd\r"
+    expect_prompt
+}
+
+# check asserts the shell variable name holds want, or is unset if want is
+# UNSET.
+proc check {name want} {
+    send "printf '<%s>\\n' \"\${$name-UNSET}\"\r"
+    expect {
+        -ex "<$want>" {}
+        -re "<(\[^>\]*)>\r" { fail "$name = $expect_out(1,string), want $want" }
+        timeout { fail "timed out checking $name" }
+    }
+    expect_prompt
+}
+
+proc done {} {
+    send "exit\r"
+    expect eof
+    exit 0
+}
@@ -0,0 +1,178 @@
+// Package templates stores a scope's vars, clears and aliases as a named
+// template for bootstrapping new projects. Values may contain {{NAME}}
+// placeholders, filled in when the template is applied, so secrets and
+// per-project settings are never saved with it.
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Var is a variable in a template.
+type Var struct {
+	Key         string   `json:"key"`
+	Value       string   `json:"value"`
+	Description string   `json:"description,omitempty"`
+	IfUnset     bool     `json:"if_unset,omitempty"`
+	Eval        bool     `json:"eval,omitempty"`
+	Refresh     int      `json:"refresh,omitempty"`
+	NoExport    bool     `json:"no_export,omitempty"`
+	Fallback    string   `json:"fallback,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// Template is a saved scope.
+type Template struct {
+	Name      string            `json:"name"`
+	CreatedAt time.Time         `json:"created_at"`
+	Vars      []Var             `json:"vars"`
+	Clears    []string          `json:"clears,omitempty"`
+	Aliases   map[string]string `json:"aliases,omitempty"`
+}
+
+var (
+	nameRe        = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	placeholderRe = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// ValidName reports whether name can be used for a template (and its file).
+func ValidName(name string) bool {
+	return nameRe.MatchString(name)
+}
+
+// Placeholder returns the placeholder text for name.
+func Placeholder(name string) string {
+	return "{{" + name + "}}"
+}
+
+// Path returns the file for template name in dir.
+func Path(dir, name string) string {
+	return filepath.Join(dir, name+".json")
+}
+
+// Load reads template name from dir. A missing template returns an error
+// matching fs.ErrNotExist.
+func Load(dir, name string) (*Template, error) {
+	data, err := os.ReadFile(Path(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var t Template
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	t.Name = name
+	return &t, nil
+}
+
+// Save writes t to dir, replacing any template with the same name.
+func Save(dir string, t *Template) error {
+	if !ValidName(t.Name) {
+		return fmt.Errorf("invalid template name %q", t.Name)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(Path(dir, t.Name), append(data, '\n'), 0600)
+}
+
+// Remove deletes template name from dir. A missing template returns an
+// error matching fs.ErrNotExist.
+func Remove(dir, name string) error {
+	return os.Remove(Path(dir, name))
+}
+
+// List returns the templates in dir, sorted by name.
+func List(dir string) ([]*Template, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Template
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() || !ValidName(name) {
+			continue
+		}
+		t, err := Load(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Placeholders returns the distinct placeholder names in t's values and
+// aliases, sorted.
+func (t *Template) Placeholders() []string {
+	seen := make(map[string]bool)
+	add := func(s string) {
+		for _, m := range placeholderRe.FindAllStringSubmatch(s, -1) {
+			seen[m[1]] = true
+		}
+	}
+	for _, v := range t.Vars {
+		add(v.Value)
+	}
+	for _, cmd := range t.Aliases {
+		add(cmd)
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Fill returns a copy of t with every placeholder replaced from values. It
+// fails, naming them, if any placeholder has no value.
+func (t *Template) Fill(values map[string]string) (*Template, error) {
+	var missing []string
+	for _, name := range t.Placeholders() {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no value for placeholder(s): %s", strings.Join(missing, ", "))
+	}
+
+	replace := func(s string) string {
+		return placeholderRe.ReplaceAllStringFunc(s, func(m string) string {
+			return values[placeholderRe.FindStringSubmatch(m)[1]]
+		})
+	}
+	out := *t
+	out.Vars = make([]Var, len(t.Vars))
+	for i, v := range t.Vars {
+		v.Value = replace(v.Value)
+		out.Vars[i] = v
+	}
+	if t.Aliases != nil {
+		out.Aliases = make(map[string]string, len(t.Aliases))
+		for name, cmd := range t.Aliases {
+			out.Aliases[name] = replace(cmd)
+		}
+	}
+	return &out, nil
+}
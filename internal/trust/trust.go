@@ -0,0 +1,125 @@
+// Package trust keeps keys that can hijack every program a shell starts,
+// such as LD_PRELOAD, and --eval commands from being exported out of scopes
+// the user hasn't vouched for. Trusted directories are recorded in a local file, never in
+// the database, so a synced or shared database can't trust itself.
+package trust
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+// DefaultDenylist lists the keys only trusted scopes may export: loader
+// injection, and PATH and IFS, which enva can only replace outright.
+var DefaultDenylist = []string{
+	"DYLD_INSERT_LIBRARIES",
+	"IFS",
+	"LD_AUDIT",
+	"LD_PRELOAD",
+	"PATH",
+}
+
+// List is the set of directories whose scopes, and those below them, may
+// export denylisted keys.
+type List struct {
+	path string
+	Dirs []string `json:"dirs"`
+}
+
+// Load reads the trust list at path. A missing file yields an empty list.
+func Load(path string) (*List, error) {
+	l := &List{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Save writes the list back to the file it was loaded from.
+func (l *List) Save() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(l.path, append(data, '\n'), 0600)
+}
+
+// Add trusts dir, reporting whether it wasn't trusted already.
+func (l *List) Add(dir string) bool {
+	for _, d := range l.Dirs {
+		if d == dir {
+			return false
+		}
+	}
+	l.Dirs = append(l.Dirs, dir)
+	sort.Strings(l.Dirs)
+	return true
+}
+
+// Remove stops trusting dir, reporting whether it was trusted.
+func (l *List) Remove(dir string) bool {
+	for i, d := range l.Dirs {
+		if d == dir {
+			l.Dirs = append(l.Dirs[:i], l.Dirs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Trusts reports whether dir or one of its ancestors is trusted. Both are
+// compared as given, so pass canonical paths.
+func (l *List) Trusts(dir string) bool {
+	for _, d := range l.Dirs {
+		rel, err := filepath.Rel(d, dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Denied reports whether key is on the default denylist or in extra.
+func Denied(key string, extra []string) bool {
+	for _, k := range DefaultDenylist {
+		if k == key {
+			return true
+		}
+	}
+	for _, k := range extra {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Strip removes denylisted and eval vars defined at untrusted scopes from
+// ctx and returns them, sorted by key, so callers can say what was held back.
+func (l *List) Strip(ctx *env.ResolveContext, extra []string) []*env.ResolvedVar {
+	var blocked []*env.ResolvedVar
+	for _, v := range ctx.GetSortedVars() {
+		if (v.Eval || Denied(v.Key, extra)) && !l.Trusts(v.DefinedAtPath) {
+			delete(ctx.Resolved, v.Key)
+			blocked = append(blocked, v)
+		}
+	}
+	return blocked
+}
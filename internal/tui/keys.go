@@ -0,0 +1,320 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// action is a normal-mode command that keys can be bound to. Its value is
+// the name used in the "keys" config setting.
+type action string
+
+const (
+	actQuit            action = "quit"
+	actPalette         action = "palette"
+	actSearch          action = "search"
+	actClearSearch     action = "clear_search"
+	actSearchExact     action = "search_exact"
+	actSearchCase      action = "search_case_sensitive"
+	actSearchKeysOnly  action = "search_keys_only"
+	actDown            action = "down"
+	actUp              action = "up"
+	actTop             action = "top"
+	actBottom          action = "bottom"
+	actHalfPageDown    action = "half_page_down"
+	actHalfPageUp      action = "half_page_up"
+	actNextSection     action = "next_section"
+	actPrevSection     action = "prev_section"
+	actSetMark         action = "set_mark"
+	actJumpMark        action = "jump_to_mark"
+	actToggleView      action = "toggle_view"
+	actToggleChain     action = "toggle_chain"
+	actCycleFilter     action = "cycle_filter"
+	actFilterLocal     action = "filter_local"
+	actFilterInherited action = "filter_inherited"
+	actFilterOverride  action = "filter_override"
+	actFilterAll       action = "filter_all"
+	actCycleSort       action = "cycle_sort"
+	actEdit            action = "edit"
+	actAdd             action = "add"
+	actBulkImport      action = "bulk_import"
+	actImportEnv       action = "import_env"
+	actView            action = "view"
+	actPreview         action = "toggle_preview"
+	actSecrets         action = "toggle_secrets"
+	actGrowPreview     action = "grow_preview"
+	actShrinkPreview   action = "shrink_preview"
+	actDelete          action = "delete"
+	actCopyProfile     action = "copy_to_profile"
+	actUndo            action = "undo"
+	actTrash           action = "trash"
+	actTasks           action = "tasks"
+	actCopy            action = "copy"
+	actCopyExport      action = "copy_export"
+	actHook            action = "install_hook"
+	actHelp            action = "help"
+)
+
+// binding is an action, the keys that trigger it and how it's described.
+type binding struct {
+	action action
+	keys   []string
+	help   string // Description in the help modal
+	bar    string // Label in the help bar; empty leaves it out
+}
+
+// defaultBindings is every bindable action with its default keys, in the
+// order the help modal lists them.
+var defaultBindings = []binding{
+	{actDown, []string{"j", "down"}, "Move down (5j moves 5 rows)", ""},
+	{actUp, []string{"k", "up"}, "Move up", ""},
+	{actTop, []string{"g"}, "Go to top (with a count, to that row)", ""},
+	{actBottom, []string{"G"}, "Go to bottom (with a count, to that row)", ""},
+	{actHalfPageDown, []string{"ctrl+d"}, "Half page down", ""},
+	{actHalfPageUp, []string{"ctrl+u"}, "Half page up", ""},
+	{actNextSection, []string{"}"}, "Next group of vars from the same scope", ""},
+	{actPrevSection, []string{"{"}, "Previous group of vars from the same scope", ""},
+	{actSetMark, []string{"m"}, "Mark the row: m then a letter", ""},
+	{actJumpMark, []string{"'"}, "Jump to a mark: ' then its letter", ""},
+	{actSearch, []string{"/"}, "Enter search mode (tag:NAME filters by tag)", ""},
+	{actPalette, []string{"ctrl+p"}, "Command palette", ""},
+	{actClearSearch, []string{"esc"}, "Clear search", ""},
+	{actSearchExact, []string{"alt+e"}, "Search: toggle exact substring / fuzzy", ""},
+	{actSearchCase, []string{"alt+c"}, "Search: toggle case-sensitive", ""},
+	{actSearchKeysOnly, []string{"alt+k"}, "Search: toggle keys only / keys and values", ""},
+	{actToggleView, []string{"t"}, "Toggle view: Effective / Local", ""},
+	{actToggleChain, []string{"c"}, "Expand/collapse resolution chain", ""},
+	{actCycleFilter, []string{"f"}, "Cycle source filter", ""},
+	{actFilterLocal, []string{"1"}, "Only local vars", ""},
+	{actFilterInherited, []string{"2"}, "Only inherited vars", ""},
+	{actFilterOverride, []string{"3"}, "Only overrides", ""},
+	{actFilterAll, []string{"0"}, "All vars", ""},
+	{actCycleSort, []string{"o"}, "Cycle sort: key / last change / creation", ""},
+	{actEdit, []string{"enter", "e"}, "Edit selected variable", "Edit"},
+	{actAdd, []string{"a"}, "Add new variable (at any scope in the chain)", "Add"},
+	{actBulkImport, []string{"A"}, "Bulk import variables", ""},
+	{actImportEnv, []string{"i"}, "Import .env from current directory", ""},
+	{actView, []string{"v"}, "View full value", ""},
+	{actPreview, []string{"p"}, "Toggle value preview pane", ""},
+	{actSecrets, []string{"s"}, "Show/mask secret values", ""},
+	{actGrowPreview, []string{"+", "="}, "Grow preview pane", ""},
+	{actShrinkPreview, []string{"-", "_"}, "Shrink preview pane", ""},
+	{actDelete, []string{"x"}, "Delete variable (at its source)", "Delete"},
+	{actCopyProfile, []string{"P"}, "Copy/move variable to another profile", ""},
+	{actUndo, []string{"u"}, "Undo last action", ""},
+	{actTrash, []string{"T"}, "Trash: restore deleted variables", ""},
+	{actTasks, []string{"R"}, "Tasks: add, edit or delete enva run commands", ""},
+	{actCopy, []string{"y"}, "Copy KEY=value", ""},
+	{actCopyExport, []string{"Y"}, "Copy export line", ""},
+	{actHook, []string{"H"}, "Install shell hook", ""},
+	{actHelp, []string{"?"}, "Show this help", "Help"},
+	{actQuit, []string{"q"}, "Quit", "Quit"},
+}
+
+// keymap resolves normal-mode keys to actions.
+type keymap struct {
+	actions  map[string]action
+	bindings []binding // defaultBindings with their effective keys
+}
+
+// newKeymap applies custom bindings, action name to keys, over the
+// defaults; an action given in custom loses its default keys. It returns
+// the problems found: unknown actions and invalid keys are skipped, and a
+// key bound to two actions goes to the custom one, or to the action whose
+// name sorts first.
+func newKeymap(custom map[string][]string) (keymap, []string) {
+	var problems []string
+
+	known := make(map[action]bool, len(defaultBindings))
+	for _, b := range defaultBindings {
+		known[b.action] = true
+	}
+
+	overrides := make(map[action][]string)
+	for _, name := range sortedNames(custom) {
+		a := action(name)
+		if !known[a] {
+			problems = append(problems, fmt.Sprintf("unknown action %q", name))
+			continue
+		}
+		keys := []string{}
+		for _, k := range custom[name] {
+			norm, ok := normalizeKey(k)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: invalid key %q", name, k))
+				continue
+			}
+			keys = append(keys, norm)
+		}
+		overrides[a] = keys
+	}
+
+	// Custom bindings claim their keys first
+	km := keymap{actions: make(map[string]action)}
+	claim := func(a action, keys []string) []string {
+		var kept []string
+		for _, k := range keys {
+			if k == "ctrl+c" {
+				problems = append(problems, fmt.Sprintf("%s: ctrl+c always quits", a))
+				continue
+			}
+			if owner, taken := km.actions[k]; taken && owner != a {
+				problems = append(problems, fmt.Sprintf("%s is bound to both %s and %s; %s keeps it", displayKey(k), owner, a, owner))
+				continue
+			}
+			km.actions[k] = a
+			kept = append(kept, k)
+		}
+		return kept
+	}
+	effective := make(map[action][]string, len(defaultBindings))
+	for _, name := range sortedNames(custom) {
+		if keys, ok := overrides[action(name)]; ok {
+			effective[action(name)] = claim(action(name), keys)
+		}
+	}
+	for _, b := range defaultBindings {
+		if _, ok := overrides[b.action]; !ok {
+			effective[b.action] = claim(b.action, b.keys)
+		}
+	}
+
+	for _, b := range defaultBindings {
+		b.keys = effective[b.action]
+		km.bindings = append(km.bindings, b)
+	}
+	return km, problems
+}
+
+// defaultKeymap is the keymap without customizations.
+func defaultKeymap() keymap {
+	km, _ := newKeymap(nil)
+	return km
+}
+
+// keysFor returns the keys bound to a.
+func (km keymap) keysFor(a action) []string {
+	for _, b := range km.bindings {
+		if b.action == a {
+			return b.keys
+		}
+	}
+	return nil
+}
+
+func sortedNames(m map[string][]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namedKeys are the keys, besides single characters, that bubbletea
+// reports by name.
+var namedKeys = map[string]bool{
+	"enter": true, "esc": true, "tab": true, "backspace": true, "delete": true, "insert": true,
+	"up": true, "down": true, "left": true, "right": true,
+	"home": true, "end": true, "pgup": true, "pgdown": true,
+}
+
+// modifiers in the order bubbletea writes them, as in "alt+ctrl+x".
+var modifiers = []string{"alt+", "ctrl+", "shift+"}
+
+// normalizeKey checks a configured key and returns it as bubbletea names
+// it: "ctrl+d", "alt+e", "enter", "G". Modifiers may come in any order and
+// case, and "space" is accepted for " ".
+func normalizeKey(k string) (string, bool) {
+	has := make(map[string]bool)
+	rest := k
+	for {
+		found := false
+		for _, mod := range modifiers {
+			if len(rest) > len(mod) && strings.EqualFold(rest[:len(mod)], mod) {
+				has[mod] = true
+				rest = rest[len(mod):]
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+
+	base := rest
+	if utf8.RuneCountInString(base) != 1 {
+		base = strings.ToLower(base)
+	}
+	if base == "space" {
+		base = " "
+	}
+	switch {
+	case base == " " || namedKeys[base]:
+	case utf8.RuneCountInString(base) == 1:
+		if has["shift+"] {
+			return "", false // Shifted characters arrive as themselves, e.g. "A"
+		}
+		if has["ctrl+"] {
+			base = strings.ToLower(base)
+		}
+	case len(base) >= 2 && base[0] == 'f' && isDigits(base[1:]):
+	default:
+		return "", false
+	}
+
+	var b strings.Builder
+	for _, mod := range modifiers {
+		if has[mod] {
+			b.WriteString(mod)
+		}
+	}
+	b.WriteString(base)
+	return b.String(), true
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// keyNames are how named keys are shown in help.
+var keyNames = map[string]string{
+	"up": "↑", "down": "↓", "left": "←", "right": "→", " ": "Space",
+	"pgup": "PgUp", "pgdown": "PgDn",
+}
+
+// displayKey formats a key for help: "Ctrl+d", "Enter", "↓".
+func displayKey(k string) string {
+	var b strings.Builder
+	for _, mod := range modifiers {
+		if strings.HasPrefix(k, mod) && len(k) > len(mod) {
+			b.WriteString(strings.ToUpper(mod[:1]) + mod[1:])
+			k = k[len(mod):]
+		}
+	}
+	switch {
+	case keyNames[k] != "":
+		b.WriteString(keyNames[k])
+	case utf8.RuneCountInString(k) > 1:
+		b.WriteString(strings.ToUpper(k[:1]) + k[1:])
+	default:
+		b.WriteString(k)
+	}
+	return b.String()
+}
+
+// displayKeys formats keys for help, e.g. "j, ↓".
+func displayKeys(keys []string) string {
+	shown := make([]string, len(keys))
+	for i, k := range keys {
+		shown[i] = displayKey(k)
+	}
+	return strings.Join(shown, ", ")
+}
@@ -0,0 +1,161 @@
+package tui
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestNormalizeKey(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"e", "e", true},
+		{"G", "G", true},
+		{"Ctrl+D", "ctrl+d", true},
+		{"ctrl+alt+x", "alt+ctrl+x", true},
+		{"Enter", "enter", true},
+		{"space", " ", true},
+		{"shift+tab", "shift+tab", true},
+		{"f5", "f5", true},
+		{"shift+a", "", false},
+		{"", "", false},
+		{"ctrl+", "", false},
+		{"hyper+x", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeKey(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalizeKey(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNewKeymap(t *testing.T) {
+	km, problems := newKeymap(map[string][]string{
+		"delete": {"d"},
+		"edit":   {"enter", "x"}, // x was delete's default, which it no longer has
+		"bogus":  {"z"},
+		"add":    {"hyper+a", "j"}, // j is down's default; the custom binding wins
+		"quit":   {"ctrl+c", "Q"},
+	})
+	if km.actions["d"] != actDelete || km.actions["x"] != actEdit || km.actions["e"] != "" {
+		t.Errorf("custom bindings not applied: d=%q x=%q e=%q", km.actions["d"], km.actions["x"], km.actions["e"])
+	}
+	if km.actions["j"] != actAdd || km.actions["down"] != actDown {
+		t.Errorf("j = %q, down = %q", km.actions["j"], km.actions["down"])
+	}
+	if km.actions["Q"] != actQuit || km.actions["q"] != "" {
+		t.Errorf("quit keys = %v", km.keysFor(actQuit))
+	}
+
+	want := []string{
+		`add: invalid key "hyper+a"`,
+		`unknown action "bogus"`,
+		"quit: ctrl+c always quits",
+		"j is bound to both add and down; add keeps it",
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems =\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, problems := newKeymap(nil); len(problems) != 0 {
+		t.Errorf("default keymap has problems: %v", problems)
+	}
+}
+
+func TestCustomKeysInTUI(t *testing.T) {
+	store, r, child := setupTUI(t)
+	ctx, _ := r.Resolve(child)
+
+	m := NewModel(store, r, ctx)
+	m.keys, _ = newKeymap(map[string][]string{"help": {"h"}})
+	var tm tea.Model = m
+	tm, _ = tm.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+
+	if next, _ := tm.(Model).handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("?")}); next.(Model).modal != ModalNone {
+		t.Error("? should no longer open help")
+	}
+	next, _ := tm.(Model).handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("h")})
+	if next.(Model).modal != ModalHelp {
+		t.Fatal("h should open help")
+	}
+	if frame := tm.View(); !strings.Contains(frame, "h Help") {
+		t.Errorf("help bar should show the custom key:\n%s", frame)
+	}
+}
+
+func TestCountsSectionsAndMarks(t *testing.T) {
+	store, r, child := setupTUI(t)
+	root := filepath.Dir(child)
+	for _, k := range []string{"A1", "A2", "A3"} {
+		r.SetVar(root, k, "x", "")
+	}
+	for _, k := range []string{"B1", "B2"} {
+		r.SetVar(child, k, "x", "")
+	}
+	ctx, _ := r.Resolve(child)
+
+	var m tea.Model = NewModel(store, r, ctx)
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	press := func(keys ...string) {
+		t.Helper()
+		for _, k := range keys {
+			m, _ = m.(Model).handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)})
+		}
+	}
+	selected := func() string { mm := m.(Model); return mm.selectedVar().Key }
+
+	// Rows: A1 A2 A3 (root), API_URL B1 B2 DEBUG (child)
+	press("4", "j")
+	if selected() != "B1" {
+		t.Errorf("4j selected %s, want B1", selected())
+	}
+	press("g", "1", "2", "j") // 1 is bound to a filter, but followed by a digit it starts a count
+	if selected() != "DEBUG" || m.(Model).sourceFilter != FilterAll {
+		t.Errorf("12j selected %s with filter %v, want DEBUG unfiltered", selected(), m.(Model).sourceFilter)
+	}
+	press("1") // On its own, once the wait is over, it filters
+	m, _ = m.Update(countTimeoutMsg{seq: m.(Model).countSeq})
+	if m.(Model).sourceFilter != FilterLocal || m.(Model).count != 0 {
+		t.Fatalf("1 should still filter local vars")
+	}
+	press("0", "2", "p") // Followed by something other than a motion, it's its action
+	if m.(Model).sourceFilter != FilterInherited || !m.(Model).previewOpen || m.(Model).count != 0 {
+		t.Errorf("2p should filter inherited vars and open the preview")
+	}
+	press("p", "0")
+	press("5", "0") // Once a count has started, every digit extends it
+	if m.(Model).count != 50 {
+		t.Errorf("count = %d, want 50", m.(Model).count)
+	}
+	press("esc")
+	press("g", "}")
+	if selected() != "API_URL" {
+		t.Errorf("} selected %s, want API_URL", selected())
+	}
+	press("{")
+	if selected() != "A1" {
+		t.Errorf("{ selected %s, want A1", selected())
+	}
+	press("6", "G")
+	if selected() != "B2" {
+		t.Errorf("6G selected %s, want B2", selected())
+	}
+
+	press("m", "a", "g")
+	if frame := m.View(); !strings.Contains(frame, "Mark a: B2") {
+		t.Errorf("setting a mark should say so:\n%s", frame)
+	}
+	press("'", "a")
+	if selected() != "B2" {
+		t.Errorf("'a selected %s, want B2", selected())
+	}
+	press("'", "z")
+	if !m.(Model).toastIsErr {
+		t.Error("jumping to an unset mark should fail")
+	}
+}
@@ -0,0 +1,62 @@
+package tui
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/nick-skriabin/enva/internal/search"
+)
+
+// Searches over at least asyncSearchMin vars run off the UI goroutine once
+// typing pauses for searchDebounce, so keystrokes aren't held up by
+// matching thousands of keys and values. Smaller sets are searched at once.
+var asyncSearchMin = 2000
+
+const searchDebounce = 120 * time.Millisecond
+
+// searchDebounceMsg fires when typing paused after search seq was queued.
+type searchDebounceMsg struct{ seq int }
+
+// searchResultsMsg carries the results of background search seq.
+type searchResultsMsg struct {
+	seq     int
+	results []*search.SearchResult
+}
+
+// queueSearch updates the results for the query being typed: right away
+// for small sets, else after a pause in typing and in the background.
+func (m *Model) queueSearch() tea.Cmd {
+	if len(m.searchVars()) < asyncSearchMin {
+		m.refreshResults()
+		return nil
+	}
+	m.searchSeq++
+	m.searching = true
+	seq := m.searchSeq
+	return tea.Tick(searchDebounce, func(time.Time) tea.Msg {
+		return searchDebounceMsg{seq: seq}
+	})
+}
+
+// startSearch runs search seq in the background, unless a newer search or
+// a refresh has superseded it.
+func (m *Model) startSearch(seq int) tea.Cmd {
+	if seq != m.searchSeq {
+		return nil
+	}
+	vars, query, opts := m.searchVars(), m.searchQuery, m.effectiveSearchOpts()
+	return func() tea.Msg {
+		return searchResultsMsg{seq: seq, results: search.SearchWith(vars, query, opts)}
+	}
+}
+
+// applySearchResults shows the results of a background search if they're
+// still current; superseded ones are dropped.
+func (m *Model) applySearchResults(msg searchResultsMsg) {
+	if msg.seq != m.searchSeq {
+		return
+	}
+	m.searching = false
+	m.setResults(msg.results)
+}
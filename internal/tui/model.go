@@ -1,6 +1,11 @@
 package tui
 
 import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/textarea"
@@ -9,6 +14,7 @@ import (
 	"github.com/nick-skriabin/enva/internal/db"
 	"github.com/nick-skriabin/enva/internal/env"
 	"github.com/nick-skriabin/enva/internal/search"
+	"github.com/nick-skriabin/enva/internal/shell"
 )
 
 // ViewMode represents the current list view mode.
@@ -19,6 +25,57 @@ const (
 	ViewLocal                     // Show only local vars
 )
 
+// SourceFilter restricts the list to vars from a particular source.
+type SourceFilter int
+
+const (
+	FilterAll       SourceFilter = iota // No source filtering
+	FilterLocal                         // Only local (non-override) vars
+	FilterInherited                     // Only inherited vars
+	FilterOverride                      // Only local overrides of inherited vars
+)
+
+// String returns the display name of the filter.
+func (f SourceFilter) String() string {
+	switch f {
+	case FilterLocal:
+		return "Local"
+	case FilterInherited:
+		return "Inherited"
+	case FilterOverride:
+		return "Override"
+	}
+	return "All"
+}
+
+// SortOrder is the order the list shows vars in.
+type SortOrder int
+
+const (
+	SortKey     SortOrder = iota // By key, or by relevance when searching
+	SortUpdated                  // Least recently changed first
+	SortCreated                  // Oldest first
+)
+
+// String returns the display name of the order.
+func (o SortOrder) String() string {
+	switch o {
+	case SortUpdated:
+		return "Updated"
+	case SortCreated:
+		return "Created"
+	}
+	return "Key"
+}
+
+// time returns the time v is sorted by under o.
+func (o SortOrder) time(v *env.ResolvedVar) time.Time {
+	if o == SortCreated && !v.CreatedAt.IsZero() {
+		return v.CreatedAt
+	}
+	return v.UpdatedAt
+}
+
 // ModalType represents the type of modal currently displayed.
 type ModalType int
 
@@ -29,6 +86,14 @@ const (
 	ModalView                    // Read-only value view
 	ModalHelp                    // Help/keybindings
 	ModalConfirmDelete           // Delete confirmation
+	ModalHookSetup               // Shell hook installation
+	ModalImportPreview           // Bulk import diff preview
+	ModalCopyProfile             // Copy/move a var to another profile
+	ModalPalette                 // Command palette
+	ModalTrash                   // Deleted vars that can be restored
+	ModalTasks                   // Named commands for enva run
+	ModalProgress                // A bulk import being written
+	ModalUnlock                  // Passphrase for a protected profile
 )
 
 // FocusField represents which field is focused in edit modal.
@@ -38,23 +103,45 @@ const (
 	FocusKey FocusField = iota
 	FocusValue
 	FocusDescription
+	FocusScope // Only offered when adding a var
+)
+
+// ImportStatus describes what a bulk import line will do to a key.
+type ImportStatus int
+
+const (
+	ImportAdd       ImportStatus = iota // Key does not exist locally
+	ImportUpdate                        // Key exists with a different value/description
+	ImportUnchanged                     // Key exists with identical value/description
 )
 
+// ImportLine is a single key in the bulk import preview.
+type ImportLine struct {
+	Key     string
+	OldVal  string
+	NewVal  string
+	NewDesc string
+	Status  ImportStatus
+	Enabled bool
+}
+
 // UndoAction represents an action that can be undone.
 type UndoAction struct {
 	Type    string // "set", "delete", "import"
 	Key     string
-	OldVal  string            // Previous value (for set/delete)
-	NewVal  string            // New value (for set)
-	HadVal  bool              // Whether there was a previous value
-	Batch   map[string]string // For import undo
-	Deleted []string          // Keys that were deleted in the batch
+	Path    string                // Scope the action applied to (empty means cwd)
+	OldVal  string                // Previous value (for set/delete)
+	OldDesc string                // Previous description (for set/delete)
+	NewVal  string                // New value (for set)
+	HadVal  bool                  // Whether there was a previous value
+	Batch   map[string]db.VarData // Previous vars an import overwrote
+	Added   []string              // Keys an import added
 }
 
 // Model is the main TUI model.
 type Model struct {
 	// Data
-	db       *db.DB
+	db       db.Store
 	resolver *env.Resolver
 	ctx      *env.ResolveContext
 
@@ -64,11 +151,32 @@ type Model struct {
 	cursor        int // Selected row index
 	offset        int // Scroll offset
 	viewMode      ViewMode
+	sourceFilter  SourceFilter
+	sortOrder     SortOrder
+	chainExpanded bool // Show every chain directory under the top bar
+	previewOpen   bool // Show the selected value in a bottom pane
+	previewHeight int  // Number of value lines in the preview pane
+	revealSecrets bool // Show sensitive values in the table and preview
 	searchFocused bool
 	searchQuery   string
 
-	// Search input
+	// Search input, and how the query matches (toggled with Alt+e/c/k)
 	searchInput textinput.Model
+	searchOpts  search.Options
+
+	// Background search: the latest queued one, and whether it's pending
+	searchSeq int
+	searching bool
+
+	// Normal-mode keybindings, a count typed before a motion (5j), the
+	// action of a bound digit that may yet start one, an m or ' waiting
+	// for its letter, and the rows marked with m
+	keys        keymap
+	count       int
+	countAction action
+	countSeq    int
+	markPending action
+	marks       map[byte]mark
 
 	// Filtered/searched results
 	results []*search.SearchResult
@@ -80,12 +188,20 @@ type Model struct {
 	editValInput  textarea.Model
 	editDescInput textinput.Model
 	editFocus     FocusField
+	editScope     int // Index into ctx.Chain a new var is added at
 	editError     string
 
 	// Bulk import
 	bulkInput textarea.Model
 	bulkError string
 
+	// Bulk import preview
+	importLines    []ImportLine
+	importCursor   int
+	importWarnings []string // Secret scanning warnings for the pending import
+	importJob      *importJob
+	importStatus   string // Why the preview reopened after a stopped import
+
 	// View modal
 	viewScrollOffset int
 
@@ -93,7 +209,51 @@ type Model struct {
 	helpScrollOffset int
 
 	// Delete confirmation
-	deleteKey string
+	deleteKey  string
+	deletePath string // Scope the var is defined at (may be an ancestor)
+
+	// Copy to profile: existing profiles, then a row for a new name
+	profileKey       string
+	profilePath      string // Scope the var is defined at
+	profileChoices   []string
+	profileCursor    int // len(profileChoices) selects the new-name input
+	profileInput     textinput.Model
+	profileMove      bool
+	profileOverwrite bool // Armed after the destination turned out to exist
+	profileError     string
+
+	// Command palette
+	paletteInput   textinput.Model
+	paletteAll     []paletteCommand
+	paletteMatches []paletteCommand
+	paletteCursor  int
+
+	// Trash: deleted vars in the current profile, most recent first
+	trashItems     []db.TrashedVar
+	trashCursor    int
+	trashOverwrite bool // Armed after the var turned out to be set again
+	trashError     string
+
+	// Tasks in effect, and the add/edit form when taskEditing
+	taskItems     []*env.ResolvedTask
+	taskCursor    int
+	taskEditing   bool
+	taskOrig      string // Name of the task being edited, "" when adding
+	taskNameInput textinput.Model
+	taskCmdInput  textinput.Model
+	taskError     string
+
+	// Passphrase prompt for switching to a locked profile
+	locker        Locker
+	unlockProfile string
+	unlockInput   textinput.Model
+	unlockError   string
+
+	// Onboarding / hook setup
+	dbEmpty       bool   // true if no vars exist in any scope or profile
+	hookInstalled bool   // true if the user's shell config already loads the hook
+	hookShell     string // shell selected in the hook setup modal
+	hookError     string
 
 	// Toast/status message
 	toast       string
@@ -103,15 +263,39 @@ type Model struct {
 	// Undo
 	undoStack []UndoAction
 
-	// For clipboard (optional feature)
-	clipboard string
+	// Last copied content, and how long sensitive copies stay on the system clipboard
+	clipboard      string
+	clipboardClear time.Duration
+}
+
+// defaultClipboardClear is how long sensitive values stay on the clipboard
+// unless overridden by ENVA_CLIPBOARD_CLEAR (seconds, 0 disables).
+const defaultClipboardClear = 30 * time.Second
+
+// clipboardClearFromEnv returns the clipboard auto-clear delay.
+func clipboardClearFromEnv() time.Duration {
+	s := os.Getenv("ENVA_CLIPBOARD_CLEAR")
+	if s == "" {
+		return defaultClipboardClear
+	}
+	secs, err := strconv.Atoi(s)
+	if err != nil || secs < 0 {
+		return defaultClipboardClear
+	}
+	return time.Duration(secs) * time.Second
 }
 
+// Preview pane height bounds.
+const (
+	defaultPreviewHeight = 6
+	minPreviewHeight     = 2
+)
+
 // NewModel creates a new TUI model.
-func NewModel(database *db.DB, resolver *env.Resolver, ctx *env.ResolveContext) Model {
+func NewModel(database db.Store, resolver *env.Resolver, ctx *env.ResolveContext) Model {
 	// Search input
 	si := textinput.New()
-	si.Placeholder = "Type to search..."
+	si.Placeholder = "Type to search... (tag:NAME to filter)"
 	si.CharLimit = 100
 
 	// Edit key input
@@ -136,25 +320,83 @@ func NewModel(database *db.DB, resolver *env.Resolver, ctx *env.ResolveContext)
 	bi.CharLimit = 1000000
 	bi.SetHeight(15)
 
+	// New profile name in the copy-to-profile modal
+	pi := textinput.New()
+	pi.Prompt = ""
+	pi.Placeholder = "new profile"
+	pi.CharLimit = 64
+	pi.Width = 40
+
+	// Task form
+	tn := textinput.New()
+	tn.Placeholder = "build"
+	tn.CharLimit = 64
+	tn.Width = 30
+	tc := textinput.New()
+	tc.Placeholder = "go build ./..."
+	tc.CharLimit = 4096
+	tc.Width = 50
+
+	// Passphrase for a protected profile
+	ui := textinput.New()
+	ui.Prompt = ""
+	ui.EchoMode = textinput.EchoPassword
+	ui.CharLimit = 1024
+	ui.Width = 40
+
+	// Command palette query
+	ci := textinput.New()
+	ci.Placeholder = "Type a command..."
+	ci.CharLimit = 100
+	ci.Width = 50
+
+	hookShell := shell.DetectShell()
+	if hookShell == "" {
+		hookShell = shell.SupportedShells[0]
+	}
+
 	m := Model{
-		db:            database,
-		resolver:      resolver,
-		ctx:           ctx,
-		viewMode:      ViewEffective,
-		searchInput:   si,
-		editKeyInput:  ki,
-		editValInput:  vi,
-		editDescInput: di,
-		bulkInput:     bi,
-		undoStack:     make([]UndoAction, 0),
+		db:             database,
+		resolver:       resolver,
+		ctx:            ctx,
+		viewMode:       ViewEffective,
+		searchInput:    si,
+		editKeyInput:   ki,
+		editValInput:   vi,
+		editDescInput:  di,
+		bulkInput:      bi,
+		profileInput:   pi,
+		paletteInput:   ci,
+		taskNameInput:  tn,
+		taskCmdInput:   tc,
+		unlockInput:    ui,
+		keys:           defaultKeymap(),
+		undoStack:      make([]UndoAction, 0),
+		hookShell:      hookShell,
+		previewHeight:  defaultPreviewHeight,
+		clipboardClear: clipboardClearFromEnv(),
 	}
 
+	if home, err := os.UserHomeDir(); err == nil {
+		m.hookInstalled = shell.IsHookInstalled(hookShell, home)
+	}
+
+	m.refreshDBEmpty()
 	m.refreshResults()
 	return m
 }
 
-// refreshResults updates the search results based on current view and query.
+// refreshResults updates the search results based on current view and
+// query, superseding any search running in the background.
 func (m *Model) refreshResults() {
+	m.searchSeq++
+	m.searching = false
+	m.setResults(search.SearchWith(m.searchVars(), m.searchQuery, m.effectiveSearchOpts()))
+}
+
+// searchVars returns the vars the current view and source filter show,
+// before searching.
+func (m *Model) searchVars() []*env.ResolvedVar {
 	var vars []*env.ResolvedVar
 
 	switch m.viewMode {
@@ -164,7 +406,35 @@ func (m *Model) refreshResults() {
 		vars = m.ctx.GetLocalVars()
 	}
 
-	m.results = search.Search(vars, m.searchQuery)
+	if m.sourceFilter != FilterAll {
+		filtered := make([]*env.ResolvedVar, 0, len(vars))
+		for _, v := range vars {
+			if m.matchesSourceFilter(v) {
+				filtered = append(filtered, v)
+			}
+		}
+		vars = filtered
+	}
+	return vars
+}
+
+// effectiveSearchOpts returns the search options, ranking vars defined
+// here first.
+func (m *Model) effectiveSearchOpts() search.Options {
+	opts := m.searchOpts
+	opts.Local = m.ctx.CwdReal
+	return opts
+}
+
+// setResults shows results, keeping the cursor on a row.
+func (m *Model) setResults(results []*search.SearchResult) {
+	if m.sortOrder != SortKey {
+		// Stable, so equal times keep key or relevance order
+		sort.SliceStable(results, func(i, j int) bool {
+			return m.sortOrder.time(results[i].Var).Before(m.sortOrder.time(results[j].Var))
+		})
+	}
+	m.results = results
 
 	// Ensure cursor is within bounds
 	if m.cursor >= len(m.results) {
@@ -175,6 +445,79 @@ func (m *Model) refreshResults() {
 	}
 }
 
+// toggleSearchOption flips the search option a controls and reports
+// whether a is a search toggle.
+func (m *Model) toggleSearchOption(a action) bool {
+	var msg string
+	switch a {
+	case actSearchExact:
+		m.searchOpts.Exact = !m.searchOpts.Exact
+		msg = "Search: fuzzy"
+		if m.searchOpts.Exact {
+			msg = "Search: exact substring"
+		}
+	case actSearchCase:
+		m.searchOpts.CaseSensitive = !m.searchOpts.CaseSensitive
+		msg = "Search: ignoring case"
+		if m.searchOpts.CaseSensitive {
+			msg = "Search: case-sensitive"
+		}
+	case actSearchKeysOnly:
+		m.searchOpts.KeysOnly = !m.searchOpts.KeysOnly
+		msg = "Search: keys and values"
+		if m.searchOpts.KeysOnly {
+			msg = "Search: keys only"
+		}
+	default:
+		return false
+	}
+	m.setToast(msg, false)
+	m.refreshResults()
+	return true
+}
+
+// matchesSourceFilter reports whether v passes the active source filter.
+func (m *Model) matchesSourceFilter(v *env.ResolvedVar) bool {
+	switch m.sourceFilter {
+	case FilterLocal:
+		return m.ctx.SourceOf(v) == env.SourceLocal
+	case FilterInherited:
+		return m.ctx.SourceOf(v) == env.SourceInherited
+	case FilterOverride:
+		return m.ctx.SourceOf(v) == env.SourceOverride
+	}
+	return true
+}
+
+// setSourceFilter applies a source filter and refreshes the list.
+func (m *Model) setSourceFilter(f SourceFilter) {
+	m.sourceFilter = f
+	m.cursor = 0
+	m.offset = 0
+	m.refreshResults()
+	if f == FilterAll {
+		m.setToast("Showing all sources", false)
+	} else {
+		m.setToast(fmt.Sprintf("Showing %s vars only", strings.ToLower(f.String())), false)
+	}
+}
+
+// cycleSortOrder switches to the next sort order and refreshes the list.
+func (m *Model) cycleSortOrder() {
+	m.sortOrder = (m.sortOrder + 1) % 3
+	m.cursor = 0
+	m.offset = 0
+	m.refreshResults()
+	switch m.sortOrder {
+	case SortUpdated:
+		m.setToast("Sorted by last change, oldest first", false)
+	case SortCreated:
+		m.setToast("Sorted by creation, oldest first", false)
+	default:
+		m.setToast("Sorted by key", false)
+	}
+}
+
 // reloadContext reloads the environment context from the database.
 func (m *Model) reloadContext() error {
 	newCtx, err := m.resolver.Resolve(m.ctx.CwdReal)
@@ -182,10 +525,17 @@ func (m *Model) reloadContext() error {
 		return err
 	}
 	m.ctx = newCtx
+	m.refreshDBEmpty()
 	m.refreshResults()
 	return nil
 }
 
+// refreshDBEmpty records whether the database holds no variables at all.
+func (m *Model) refreshDBEmpty() {
+	n, err := m.db.CountVars()
+	m.dbEmpty = err == nil && n == 0
+}
+
 // selectedVar returns the currently selected variable, or nil if none.
 func (m *Model) selectedVar() *env.ResolvedVar {
 	if m.cursor >= 0 && m.cursor < len(m.results) {
@@ -237,10 +587,54 @@ func (m *Model) popUndo() *UndoAction {
 	return &action
 }
 
+// chainHeaderHeight returns the number of lines used by the chain header.
+func (m *Model) chainHeaderHeight() int {
+	if m.chainExpanded {
+		return 1 + m.chainLinesShown()
+	}
+	return 1
+}
+
+// chainLinesShown returns how many chain dirs fit in the expanded header
+// (at most a third of the screen).
+func (m *Model) chainLinesShown() int {
+	limit := m.height / 3
+	if limit < 1 {
+		limit = 1
+	}
+	if len(m.ctx.Chain) < limit {
+		return len(m.ctx.Chain)
+	}
+	return limit
+}
+
+// previewPaneHeight returns the number of lines used by the preview pane
+// (title line plus value lines), or 0 when the pane is closed.
+func (m *Model) previewPaneHeight() int {
+	if !m.previewOpen {
+		return 0
+	}
+	return 1 + m.previewHeight
+}
+
+// resizePreview grows or shrinks the preview pane, keeping at least a few table rows.
+func (m *Model) resizePreview(delta int) {
+	h := m.previewHeight + delta
+	maxHeight := m.height / 2
+	if h > maxHeight {
+		h = maxHeight
+	}
+	if h < minPreviewHeight {
+		h = minPreviewHeight
+	}
+	m.previewHeight = h
+	m.ensureCursorVisible()
+}
+
 // visibleRows returns the number of visible table rows.
 func (m *Model) visibleRows() int {
-	// Height minus: top bar (1), border (2), header+separator (2), help bar (1)
-	rows := m.height - 6
+	// Height minus: top bar (1), chain header, border (2), header+separator (2), help bar (1), preview pane
+	rows := m.height - 6 - m.chainHeaderHeight() - m.previewPaneHeight()
 	if rows < 1 {
 		rows = 1
 	}
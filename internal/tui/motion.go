@@ -0,0 +1,166 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxCount caps a count prefix so a held key can't overflow it.
+const maxCount = 9999
+
+// countTimeout is how long a digit bound to an action waits for a motion or
+// another digit before it runs its action, like vim's timeoutlen.
+const countTimeout = 600 * time.Millisecond
+
+// countTimeoutMsg fires when the digit held as count seq got no follow-up.
+type countTimeoutMsg struct{ seq int }
+
+// counted are the actions a count applies to.
+var counted = map[action]bool{
+	actDown: true, actUp: true, actTop: true, actBottom: true,
+	actHalfPageDown: true, actHalfPageUp: true,
+	actNextSection: true, actPrevSection: true,
+}
+
+// mark is a row remembered with m{letter}. It's kept by var rather than
+// by row number, so it survives searching, filtering and edits.
+type mark struct {
+	path string
+	key  string
+}
+
+// countDigit adds key to the pending count if it's a digit that can be read
+// as one: any digit once a count has started, and otherwise 1 to 9. A
+// digit bound to an action, such as the source filters, is held in
+// countAction until the next key says which it was: another digit or a
+// motion makes it a count, anything else runs its action first. It
+// reports whether key was used.
+func (m *Model) countDigit(key string) bool {
+	if len(key) != 1 || key[0] < '0' || key[0] > '9' {
+		return false
+	}
+	if m.count == 0 {
+		if key == "0" {
+			return false
+		}
+		m.countAction = m.keys.actions[key]
+		m.countSeq++
+	} else {
+		m.countAction = ""
+	}
+	m.count = m.count*10 + int(key[0]-'0')
+	if m.count > maxCount {
+		m.count = maxCount
+	}
+	return true
+}
+
+// waitForCount runs the held digit's action if no key follows it in time.
+func (m *Model) waitForCount() tea.Cmd {
+	if m.countAction == "" {
+		return nil
+	}
+	seq := m.countSeq
+	return tea.Tick(countTimeout, func(time.Time) tea.Msg {
+		return countTimeoutMsg{seq: seq}
+	})
+}
+
+// flushCount runs the held digit's action, if any, and drops the count.
+func (m Model) flushCount() (Model, tea.Cmd) {
+	a := m.countAction
+	m.count, m.countAction = 0, ""
+	if a == "" {
+		return m, nil
+	}
+	next, cmd := m.runAction(a, 0)
+	return next.(Model), cmd
+}
+
+// gotoRow moves the cursor to row n, counting from 1.
+func (m *Model) gotoRow(n int) {
+	m.cursor = 0
+	m.moveDown(n - 1)
+}
+
+// nextSection moves the cursor to the first row of the next run of rows
+// defined at the same scope, n times.
+func (m *Model) nextSection(n int) {
+	for ; n > 0; n-- {
+		i := m.cursor
+		for i < len(m.results)-1 && m.rowSource(i+1) == m.rowSource(m.cursor) {
+			i++
+		}
+		if i >= len(m.results)-1 {
+			m.cursor = max(len(m.results)-1, 0)
+			break
+		}
+		m.cursor = i + 1
+	}
+	m.ensureCursorVisible()
+}
+
+// prevSection moves the cursor to the first row of the current run of rows
+// defined at the same scope or, if already there, of the run before, n
+// times.
+func (m *Model) prevSection(n int) {
+	for ; n > 0 && m.cursor > 0; n-- {
+		i := m.cursor
+		if m.rowSource(i-1) != m.rowSource(i) {
+			i--
+		}
+		for i > 0 && m.rowSource(i-1) == m.rowSource(i) {
+			i--
+		}
+		m.cursor = i
+	}
+	m.ensureCursorVisible()
+}
+
+// rowSource is the scope that defines row i.
+func (m *Model) rowSource(i int) string {
+	return m.results[i].Var.DefinedAtPath
+}
+
+// handleMarkKey finishes m{letter} or '{letter}, started by pending.
+func (m Model) handleMarkKey(pending action, key string) (tea.Model, tea.Cmd) {
+	if len(key) != 1 || !(key[0] >= 'a' && key[0] <= 'z' || key[0] >= 'A' && key[0] <= 'Z') {
+		return m, nil // Esc or anything else cancels
+	}
+	letter := key[0]
+
+	if pending == actSetMark {
+		v := m.selectedVar()
+		if v == nil {
+			return m, nil
+		}
+		if m.marks == nil {
+			m.marks = make(map[byte]mark)
+		}
+		m.marks[letter] = mark{path: v.DefinedAtPath, key: v.Key}
+		m.setToast(fmt.Sprintf("Mark %c: %s", letter, v.Key), false)
+		return m, nil
+	}
+
+	mk, ok := m.marks[letter]
+	if !ok {
+		m.setToast(fmt.Sprintf("Mark %c isn't set", letter), true)
+		return m, nil
+	}
+	// The same key defined elsewhere will do, e.g. after switching view
+	found := -1
+	for i, r := range m.results {
+		if r.Var.Key == mk.key && (found < 0 || r.Var.DefinedAtPath == mk.path) {
+			found = i
+		}
+	}
+	if found < 0 {
+		m.setToast(fmt.Sprintf("Mark %c: %s isn't shown", letter, mk.key), true)
+		return m, nil
+	}
+	m.cursor = found
+	m.ensureCursorVisible()
+	return m, nil
+}
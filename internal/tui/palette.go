@@ -0,0 +1,177 @@
+package tui
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/sahilm/fuzzy"
+
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+// paletteCommand is an action offered by the command palette.
+type paletteCommand struct {
+	title string
+	key   string // Equivalent keybinding, shown alongside the title
+	run   func(m Model) (tea.Model, tea.Cmd)
+}
+
+// paletteCommands lists every palette action, including one per profile
+// the view can switch to.
+func (m Model) paletteCommands() []paletteCommand {
+	actions := []struct {
+		title  string
+		action action
+	}{
+		{"Add variable", actAdd},
+		{"Edit selected variable", actEdit},
+		{"Delete selected variable", actDelete},
+		{"View full value", actView},
+		{"Copy KEY=value", actCopy},
+		{"Copy export line", actCopyExport},
+		{"Copy or move to another profile", actCopyProfile},
+		{"Bulk import variables", actBulkImport},
+		{"Import .env from current directory", actImportEnv},
+		{"Toggle view: effective / local", actToggleView},
+		{"Show local vars only", actFilterLocal},
+		{"Show inherited vars only", actFilterInherited},
+		{"Show overrides only", actFilterOverride},
+		{"Show all vars", actFilterAll},
+		{"Sort by key / last change / creation", actCycleSort},
+		{"Toggle value preview pane", actPreview},
+		{"Show/mask secret values", actSecrets},
+		{"Search: toggle exact / fuzzy matching", actSearchExact},
+		{"Search: toggle case-sensitive", actSearchCase},
+		{"Search: toggle keys only", actSearchKeysOnly},
+		{"Expand/collapse resolution chain", actToggleChain},
+		{"Undo last action", actUndo},
+		{"Restore deleted variables (trash)", actTrash},
+		{"Edit tasks (enva run NAME)", actTasks},
+		{"Install shell hook", actHook},
+		{"Show keybindings", actHelp},
+		{"Quit", actQuit},
+	}
+
+	cmds := make([]paletteCommand, 0, len(actions))
+	for _, a := range actions {
+		var key string
+		if keys := m.keys.keysFor(a.action); len(keys) > 0 {
+			key = displayKey(keys[0])
+		}
+		act := a.action
+		cmds = append(cmds, paletteCommand{
+			title: a.title,
+			key:   key,
+			run:   func(m Model) (tea.Model, tea.Cmd) { return m.runAction(act, 0) },
+		})
+	}
+
+	current := m.resolver.GetProfile()
+	profiles, _ := m.db.ListProfiles()
+	if current != env.DefaultProfile {
+		profiles = append([]string{env.DefaultProfile}, profiles...)
+	}
+	seen := map[string]bool{current: true}
+	for _, p := range profiles {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		profile := p
+		title := "Switch profile: " + profile
+		if m.locked(profile) {
+			title += " (locked)"
+		}
+		cmds = append(cmds, paletteCommand{
+			title: title,
+			run: func(m Model) (tea.Model, tea.Cmd) {
+				if m.locked(profile) {
+					return m, m.openUnlock(profile)
+				}
+				m.switchProfile(profile)
+				return m, nil
+			},
+		})
+	}
+	return cmds
+}
+
+// openPalette shows the command palette with every command listed.
+func (m *Model) openPalette() tea.Cmd {
+	m.modal = ModalPalette
+	m.paletteInput.SetValue("")
+	m.paletteCursor = 0
+	m.paletteAll = m.paletteCommands()
+	m.paletteMatches = m.paletteAll
+	m.paletteInput.Focus()
+	return textinput.Blink
+}
+
+// filterPalette fuzzy-matches the palette query against command titles.
+func (m *Model) filterPalette() {
+	query := m.paletteInput.Value()
+	m.paletteCursor = 0
+	if query == "" {
+		m.paletteMatches = m.paletteAll
+		return
+	}
+	titles := make([]string, len(m.paletteAll))
+	for i, c := range m.paletteAll {
+		titles[i] = c.title
+	}
+	m.paletteMatches = nil
+	for _, match := range fuzzy.Find(query, titles) {
+		m.paletteMatches = append(m.paletteMatches, m.paletteAll[match.Index])
+	}
+}
+
+func (m Model) handlePaletteKey(msg tea.KeyMsg, key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "esc", "ctrl+p":
+		m.modal = ModalNone
+		m.paletteInput.Blur()
+		return m, nil
+	case "up", "ctrl+k":
+		if m.paletteCursor > 0 {
+			m.paletteCursor--
+		}
+		return m, nil
+	case "down", "ctrl+j", "ctrl+n":
+		if m.paletteCursor < len(m.paletteMatches)-1 {
+			m.paletteCursor++
+		}
+		return m, nil
+	case "enter":
+		if m.paletteCursor >= len(m.paletteMatches) {
+			return m, nil
+		}
+		cmd := m.paletteMatches[m.paletteCursor]
+		m.modal = ModalNone
+		m.paletteInput.Blur()
+		return cmd.run(m)
+	}
+
+	var cmd tea.Cmd
+	m.paletteInput, cmd = m.paletteInput.Update(msg)
+	m.filterPalette()
+	return m, cmd
+}
+
+// switchProfile re-resolves the view under profile. The undo stack is
+// dropped since its entries belong to the previous profile.
+func (m *Model) switchProfile(profile string) {
+	resolver := env.NewResolver(m.db, profile)
+	resolver.SetChainOptions(m.resolver.ChainOptions())
+	resolver.SetEnvFiles(m.resolver.EnvFiles())
+	ctx, err := resolver.Resolve(m.ctx.CwdReal)
+	if err != nil {
+		m.setToast(fmt.Sprintf("Switch error: %v", err), true)
+		return
+	}
+	m.resolver = resolver
+	m.ctx = ctx
+	m.undoStack = m.undoStack[:0]
+	m.refreshResults()
+	m.setToast("Switched to profile "+profile, false)
+}
@@ -0,0 +1,200 @@
+package tui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/nick-skriabin/enva/internal/db"
+	"github.com/nick-skriabin/enva/internal/env"
+)
+
+// setupTUI returns a resolver over an in-memory store with a var at a
+// project root and an override below it, and the child directory.
+func setupTUI(t *testing.T) (db.Store, *env.Resolver, string) {
+	t.Helper()
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	child := filepath.Join(root, "service")
+	os.MkdirAll(child, 0755)
+	os.WriteFile(filepath.Join(root, ".enva"), nil, 0644)
+
+	store, err := db.Open("memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	r := env.NewResolver(store, env.DefaultProfile)
+	r.SetVar(root, "API_URL", "https://api.example.com", "Shared API endpoint")
+	r.SetVar(child, "API_URL", "http://localhost:8080", "")
+	r.SetVar(child, "DEBUG", "1", "")
+	return store, r, child
+}
+
+// checkFrame fails if the frame doesn't fit width x height.
+func checkFrame(t *testing.T, name, frame string, width, height int) {
+	t.Helper()
+	lines := strings.Split(frame, "\n")
+	if len(lines) > height {
+		t.Errorf("%s: %d lines, want at most %d", name, len(lines), height)
+	}
+	for i, line := range lines {
+		if w := lipgloss.Width(line); w > width {
+			t.Errorf("%s: line %d is %d wide, want at most %d: %q", name, i, w, width, line)
+		}
+	}
+}
+
+func TestRenderOnce(t *testing.T) {
+	store, r, child := setupTUI(t)
+
+	for _, size := range [][2]int{{80, 24}, {120, 40}} {
+		frame, err := RenderOnce(store, r, child, size[0], size[1])
+		if err != nil {
+			t.Fatalf("RenderOnce failed: %v", err)
+		}
+		checkFrame(t, "main view", frame, size[0], size[1])
+		for _, want := range []string{"API_URL", "DEBUG", "Override"} {
+			if !strings.Contains(frame, want) {
+				t.Errorf("%dx%d frame is missing %q:\n%s", size[0], size[1], want, frame)
+			}
+		}
+	}
+}
+
+// TestModalLayout opens each modal with its key and checks it fits the screen.
+func TestModalLayout(t *testing.T) {
+	store, r, child := setupTUI(t)
+	ctx, err := r.Resolve(child)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	keys := map[string]tea.KeyMsg{
+		"help":    {Type: tea.KeyRunes, Runes: []rune("?")},
+		"edit":    {Type: tea.KeyRunes, Runes: []rune("e")},
+		"add":     {Type: tea.KeyRunes, Runes: []rune("a")},
+		"view":    {Type: tea.KeyRunes, Runes: []rune("v")},
+		"palette": {Type: tea.KeyCtrlP},
+		"bulk":    {Type: tea.KeyRunes, Runes: []rune("A")},
+		"profile": {Type: tea.KeyRunes, Runes: []rune("P")},
+		"tasks":   {Type: tea.KeyRunes, Runes: []rune("R")},
+	}
+	for name, key := range keys {
+		var m tea.Model = NewModel(store, r, ctx)
+		m, _ = m.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+		m, _ = m.Update(key)
+		if m.(Model).modal == ModalNone {
+			t.Errorf("%s: no modal opened", name)
+		}
+		checkFrame(t, name, m.View(), 80, 24)
+	}
+}
+
+func TestSecretsMasked(t *testing.T) {
+	store, r, child := setupTUI(t)
+	r.SetVar(child, "DB_PASSWORD", "correct-horse-battery", "")
+	ctx, _ := r.Resolve(child)
+
+	var m tea.Model = NewModel(store, r, ctx)
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m, _ = m.(Model).handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("p")})
+	if frame := m.View(); strings.Contains(frame, "corr") || !strings.Contains(frame, "••••••••") {
+		t.Errorf("secret should be masked in the table and preview:\n%s", frame)
+	}
+
+	// Searching for the value finds nothing rather than revealing it
+	mm := m.(Model)
+	mm.searchQuery = "horse"
+	mm.refreshResults()
+	if len(mm.results) != 0 {
+		t.Errorf("search matched a secret value: %+v", mm.results[0].Var.Key)
+	}
+	mm.searchQuery = ""
+	mm.refreshResults()
+
+	m, _ = mm.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	if frame := m.View(); !strings.Contains(frame, "correct-horse-battery") {
+		t.Errorf("s should reveal secrets:\n%s", frame)
+	}
+}
+
+func TestSearchOptionToggles(t *testing.T) {
+	store, r, child := setupTUI(t)
+	ctx, _ := r.Resolve(child)
+
+	var m tea.Model = NewModel(store, r, ctx)
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	mm := m.(Model)
+	mm.searchQuery = "local"
+	mm.refreshResults()
+	if len(mm.results) != 1 {
+		t.Fatalf("fuzzy search for a value: %d results, want 1", len(mm.results))
+	}
+
+	m, _ = mm.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("k"), Alt: true})
+	mm = m.(Model)
+	if !mm.searchOpts.KeysOnly || len(mm.results) != 0 {
+		t.Errorf("Alt+k should match keys only: %+v, %d results", mm.searchOpts, len(mm.results))
+	}
+	if frame := m.View(); !strings.Contains(frame, "[keys]") {
+		t.Errorf("top bar should show the search mode:\n%s", frame)
+	}
+
+	m, _ = mm.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("k"), Alt: true})
+	m, _ = m.(Model).handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e"), Alt: true})
+	mm = m.(Model)
+	mm.searchQuery = "dbg"
+	mm.refreshResults()
+	if !mm.searchOpts.Exact || len(mm.results) != 0 {
+		t.Errorf("Alt+e should match exact substrings: %+v, %d results", mm.searchOpts, len(mm.results))
+	}
+}
+
+func TestAsyncSearch(t *testing.T) {
+	old := asyncSearchMin
+	asyncSearchMin = 1
+	t.Cleanup(func() { asyncSearchMin = old })
+
+	store, r, child := setupTUI(t)
+	ctx, _ := r.Resolve(child)
+	var m tea.Model = NewModel(store, r, ctx)
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m, _ = m.(Model).handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("/")})
+
+	m, _ = m.(Model).handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	stale := m.(Model).searchSeq
+	m, _ = m.(Model).handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	mm := m.(Model)
+	if !mm.searching || len(mm.results) != 2 {
+		t.Fatalf("typing should queue a search and keep the old results: searching=%v, %d results", mm.searching, len(mm.results))
+	}
+	if frame := m.View(); !strings.Contains(frame, "searching…") {
+		t.Errorf("top bar should show the search is pending:\n%s", frame)
+	}
+
+	if _, cmd := m.Update(searchDebounceMsg{seq: stale}); cmd != nil {
+		t.Error("a superseded search should not start")
+	}
+	m, cmd := m.Update(searchDebounceMsg{seq: mm.searchSeq})
+	if cmd == nil {
+		t.Fatal("the latest search should start after the pause")
+	}
+	msg := cmd()
+
+	// Results for an older query are dropped
+	m, _ = m.Update(searchResultsMsg{seq: stale})
+	if len(m.(Model).results) != 2 {
+		t.Errorf("stale results were applied")
+	}
+
+	m, _ = m.Update(msg)
+	mm = m.(Model)
+	if mm.searching || len(mm.results) != 1 || mm.results[0].Var.Key != "DEBUG" {
+		t.Errorf("searching=%v, results=%d, want DEBUG only", mm.searching, len(mm.results))
+	}
+}
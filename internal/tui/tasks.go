@@ -0,0 +1,154 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/nick-skriabin/enva/internal/shell"
+)
+
+// openTasks lists the tasks in effect here.
+func (m *Model) openTasks() {
+	m.modal = ModalTasks
+	m.taskItems = m.ctx.GetSortedTasks()
+	m.taskCursor = min(m.taskCursor, max(len(m.taskItems)-1, 0))
+	m.taskEditing = false
+	m.taskError = ""
+}
+
+func (m Model) handleTasksKey(msg tea.KeyMsg, key string) (tea.Model, tea.Cmd) {
+	if m.taskEditing {
+		return m.handleTaskFormKey(msg, key)
+	}
+	switch key {
+	case "esc", "q", "R":
+		m.modal = ModalNone
+		m.taskError = ""
+	case "up", "k":
+		if m.taskCursor > 0 {
+			m.taskCursor--
+			m.taskError = ""
+		}
+	case "down", "j":
+		if m.taskCursor < len(m.taskItems)-1 {
+			m.taskCursor++
+			m.taskError = ""
+		}
+	case "a":
+		return m, m.openTaskForm("", "")
+	case "enter", "e":
+		if m.taskCursor < len(m.taskItems) {
+			t := m.taskItems[m.taskCursor]
+			return m, m.openTaskForm(t.Name, t.Command)
+		}
+	case "x":
+		return m.deleteSelectedTask()
+	}
+	return m, nil
+}
+
+// openTaskForm shows the add/edit form, for a new task if name is empty.
+func (m *Model) openTaskForm(name, command string) tea.Cmd {
+	m.taskEditing = true
+	m.taskOrig = name
+	m.taskError = ""
+	m.taskNameInput.SetValue(name)
+	m.taskCmdInput.SetValue(command)
+	if name == "" {
+		m.taskCmdInput.Blur()
+		return m.taskNameInput.Focus()
+	}
+	m.taskNameInput.Blur()
+	return m.taskCmdInput.Focus()
+}
+
+func (m Model) handleTaskFormKey(msg tea.KeyMsg, key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "esc":
+		m.taskEditing = false
+		m.taskError = ""
+		return m, nil
+	case "tab", "shift+tab":
+		if m.taskNameInput.Focused() {
+			m.taskNameInput.Blur()
+			return m, m.taskCmdInput.Focus()
+		}
+		m.taskCmdInput.Blur()
+		return m, m.taskNameInput.Focus()
+	case "enter", "ctrl+s":
+		return m.saveTask()
+	}
+
+	var cmd tea.Cmd
+	if m.taskNameInput.Focused() {
+		m.taskNameInput, cmd = m.taskNameInput.Update(msg)
+	} else {
+		m.taskCmdInput, cmd = m.taskCmdInput.Update(msg)
+	}
+	return m, cmd
+}
+
+// saveTask saves the form at the current directory, where it overrides a
+// task of the same name defined further up. Renaming a task defined here
+// removes the old name.
+func (m Model) saveTask() (tea.Model, tea.Cmd) {
+	name := strings.TrimSpace(m.taskNameInput.Value())
+	command := strings.TrimSpace(m.taskCmdInput.Value())
+	if !shell.IsValidTask(name) {
+		m.taskError = "Name may only use letters, digits, _ - . and :"
+		return m, nil
+	}
+	if command == "" {
+		m.taskError = "Enter a command"
+		return m, nil
+	}
+
+	cwd := m.ctx.CwdReal
+	if err := m.resolver.SetTask(cwd, name, command); err != nil {
+		m.taskError = fmt.Sprintf("Error: %v", err)
+		return m, nil
+	}
+	if old := m.ctx.Tasks[m.taskOrig]; old != nil && old.Name != name && old.DefinedAtPath == cwd {
+		if err := m.resolver.DeleteTask(cwd, old.Name); err != nil {
+			m.taskError = fmt.Sprintf("Error: %v", err)
+			return m, nil
+		}
+	}
+
+	if err := m.reloadContext(); err != nil {
+		m.setToast(fmt.Sprintf("Reload error: %v", err), true)
+	} else {
+		m.setToast(fmt.Sprintf("Saved task %s", name), false)
+	}
+	m.openTasks()
+	for i, t := range m.taskItems {
+		if t.Name == name {
+			m.taskCursor = i
+		}
+	}
+	return m, nil
+}
+
+// deleteSelectedTask deletes the selected task at the scope that defines
+// it, which may bring back one of the same name from further up.
+func (m Model) deleteSelectedTask() (tea.Model, tea.Cmd) {
+	if m.taskCursor >= len(m.taskItems) {
+		return m, nil
+	}
+	t := m.taskItems[m.taskCursor]
+	if err := m.resolver.DeleteTask(t.DefinedAtPath, t.Name); err != nil {
+		m.taskError = fmt.Sprintf("Error: %v", err)
+		return m, nil
+	}
+	if err := m.reloadContext(); err != nil {
+		m.setToast(fmt.Sprintf("Reload error: %v", err), true)
+	} else if t.DefinedAtPath != m.ctx.CwdReal {
+		m.setToast(fmt.Sprintf("Deleted task %s at %s", t.Name, displayPath(t.DefinedAtPath)), false)
+	} else {
+		m.setToast(fmt.Sprintf("Deleted task %s", t.Name), false)
+	}
+	m.openTasks()
+	return m, nil
+}
@@ -0,0 +1,93 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/nick-skriabin/enva/internal/db"
+)
+
+// openTrash lists the vars deleted in the current profile.
+func (m *Model) openTrash() {
+	m.modal = ModalTrash
+	m.trashCursor = 0
+	m.trashOverwrite = false
+	m.trashError = ""
+	items, err := m.db.ListTrash(m.resolver.GetProfile())
+	if err != nil {
+		m.trashError = fmt.Sprintf("Error: %v", err)
+	}
+	m.trashItems = items
+}
+
+func (m Model) handleTrashKey(key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "esc", "q", "T":
+		m.modal = ModalNone
+		m.trashError = ""
+	case "up", "k":
+		if m.trashCursor > 0 {
+			m.trashCursor--
+			m.trashOverwrite = false
+			m.trashError = ""
+		}
+	case "down", "j":
+		if m.trashCursor < len(m.trashItems)-1 {
+			m.trashCursor++
+			m.trashOverwrite = false
+			m.trashError = ""
+		}
+	case "enter", "r":
+		return m.restoreSelectedTrash()
+	}
+	return m, nil
+}
+
+// restoreSelectedTrash puts the selected var back where it was deleted
+// from. A var set there since is only replaced on a second Enter.
+func (m Model) restoreSelectedTrash() (tea.Model, tea.Cmd) {
+	if m.trashCursor >= len(m.trashItems) {
+		return m, nil
+	}
+	item := m.trashItems[m.trashCursor]
+
+	_, err := m.db.RestoreTrash(item.ID, m.trashOverwrite)
+	if errors.Is(err, db.ErrVarExists) {
+		m.trashOverwrite = true
+		m.trashError = fmt.Sprintf("%s has been set again since; Enter again to replace it", item.Key)
+		return m, nil
+	}
+	if err != nil {
+		m.trashError = fmt.Sprintf("Error: %v", err)
+		return m, nil
+	}
+
+	m.modal = ModalNone
+	m.trashError = ""
+	if err := m.reloadContext(); err != nil {
+		m.setToast(fmt.Sprintf("Reload error: %v", err), true)
+	} else if item.Path != m.ctx.CwdReal {
+		m.setToast(fmt.Sprintf("Restored %s at %s", item.Key, displayPath(item.Path)), false)
+	} else {
+		m.setToast(fmt.Sprintf("Restored %s", item.Key), false)
+	}
+	return m, nil
+}
+
+// restoreDeleted restores the latest trashed key deleted from path, with its
+// flags and tags, and reports whether there was one.
+func (m *Model) restoreDeleted(path, key string) (bool, error) {
+	items, err := m.db.ListTrash(m.resolver.GetProfile())
+	if err != nil {
+		return false, err
+	}
+	for _, t := range items {
+		if t.Path == path && t.Key == key {
+			_, err := m.db.RestoreTrash(t.ID, true)
+			return true, err
+		}
+	}
+	return false, nil
+}
@@ -7,18 +7,76 @@ import (
 
 	"github.com/nick-skriabin/enva/internal/db"
 	"github.com/nick-skriabin/enva/internal/env"
+	"github.com/nick-skriabin/enva/internal/search"
 )
 
-// Run starts the TUI application.
-func Run(database *db.DB, resolver *env.Resolver, cwd string) error {
+// Options are user settings for the TUI.
+type Options struct {
+	Search search.Options      // Default search matching
+	Keys   map[string][]string // Action name to keys, replacing its defaults
+	Locker Locker              // Guards protected profiles; nil if none are
+}
+
+// Locker keeps protected profiles behind a passphrase. Switching to a
+// locked profile asks for it first.
+type Locker interface {
+	Locked(profile string) bool
+	Unlock(profile, passphrase string) error
+}
+
+// Run starts the TUI application. Problems with opts.Keys are shown when it
+// starts; see CheckKeys.
+func Run(database db.Store, resolver *env.Resolver, cwd string, opts Options) error {
 	ctx, err := resolver.Resolve(cwd)
 	if err != nil {
 		return fmt.Errorf("failed to resolve environment: %w", err)
 	}
 
 	m := NewModel(database, resolver, ctx)
+	m.searchOpts = opts.Search
+	m.locker = opts.Locker
+	m.refreshResults()
+	keys, problems := newKeymap(opts.Keys)
+	m.keys = keys
+	if len(problems) > 0 {
+		msg := "Keybindings: " + problems[0]
+		if len(problems) > 1 {
+			msg += fmt.Sprintf(" (and %d more; see enva tui --keys)", len(problems)-1)
+		}
+		m.setToast(msg, true)
+	}
 	p := tea.NewProgram(m, tea.WithAltScreen())
 
 	_, err = p.Run()
 	return err
 }
+
+// RenderOnce draws the TUI for cwd a single time at the given size and
+// returns the frame, without a terminal. It's meant for snapshots and
+// layout checks.
+func RenderOnce(database db.Store, resolver *env.Resolver, cwd string, width, height int) (string, error) {
+	ctx, err := resolver.Resolve(cwd)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve environment: %w", err)
+	}
+
+	var m tea.Model = NewModel(database, resolver, ctx)
+	m, _ = m.Update(tea.WindowSizeMsg{Width: width, Height: height})
+	return m.View(), nil
+}
+
+// CheckKeys returns the keybindings that custom, as in Options.Keys, gives:
+// one line per action with its name, keys and description. It also returns
+// the problems with custom, as Run would report them.
+func CheckKeys(custom map[string][]string) ([]string, []string) {
+	km, problems := newKeymap(custom)
+	lines := make([]string, 0, len(km.bindings))
+	for _, b := range km.bindings {
+		keys := displayKeys(b.keys)
+		if keys == "" {
+			keys = "(none)"
+		}
+		lines = append(lines, fmt.Sprintf("%-22s %-12s %s", b.action, keys, b.help))
+	}
+	return lines, problems
+}
@@ -0,0 +1,53 @@
+package tui
+
+import (
+	"errors"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/nick-skriabin/enva/internal/protect"
+)
+
+// locked reports whether profile needs its passphrase before switching.
+func (m Model) locked(profile string) bool {
+	return m.locker != nil && m.locker.Locked(profile)
+}
+
+// openUnlock asks for profile's passphrase, switching to it once given.
+func (m *Model) openUnlock(profile string) tea.Cmd {
+	m.modal = ModalUnlock
+	m.unlockProfile = profile
+	m.unlockError = ""
+	m.unlockInput.SetValue("")
+	return m.unlockInput.Focus()
+}
+
+func (m Model) handleUnlockKey(msg tea.KeyMsg, key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "esc":
+		m.modal = ModalNone
+		m.unlockInput.SetValue("")
+		m.unlockInput.Blur()
+		return m, nil
+	case "enter":
+		err := m.locker.Unlock(m.unlockProfile, m.unlockInput.Value())
+		m.unlockInput.SetValue("")
+		if errors.Is(err, protect.ErrWrongPassphrase) {
+			m.unlockError = "Wrong passphrase"
+			return m, nil
+		}
+		if err != nil {
+			m.unlockError = "Error: " + err.Error()
+			return m, nil
+		}
+		m.modal = ModalNone
+		m.unlockInput.Blur()
+		m.switchProfile(m.unlockProfile)
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.unlockInput, cmd = m.unlockInput.Update(msg)
+	m.unlockError = ""
+	return m, cmd
+}
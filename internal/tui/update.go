@@ -1,13 +1,24 @@
 package tui
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/atotto/clipboard"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 
 	"github.com/nick-skriabin/enva/internal/db"
+	"github.com/nick-skriabin/enva/internal/env"
+	"github.com/nick-skriabin/enva/internal/redact"
+	"github.com/nick-skriabin/enva/internal/secrets"
 	"github.com/nick-skriabin/enva/internal/shell"
 )
 
@@ -39,18 +50,47 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.editKeyInput.Width = inputWidth
 		m.editValInput.SetWidth(inputWidth)
 		m.bulkInput.SetWidth(inputWidth)
+		// Shrink the value box on short screens so the add modal, with its
+		// scope row and an error line, still fits
+		m.editValInput.SetHeight(max(1, min(5, msg.Height-21)))
 		return m, nil
 
 	case tea.KeyMsg:
 		return m.handleKey(msg)
+
+	case searchDebounceMsg:
+		return m, m.startSearch(msg.seq)
+
+	case searchResultsMsg:
+		m.applySearchResults(msg)
+		return m, nil
+
+	case importChunkMsg:
+		return m.handleImportChunk(msg)
+
+	case countTimeoutMsg:
+		if msg.seq == m.countSeq && m.countAction != "" {
+			return m.flushCount()
+		}
+		return m, nil
+
+	case clipboardClearMsg:
+		// Only clear if the clipboard still holds what we copied
+		if current, err := clipboard.ReadAll(); err == nil && current == msg.content {
+			clipboard.WriteAll("")
+			m.setToast("Clipboard cleared", false)
+		}
+		return m, nil
 	}
 
 	// Handle text input updates
 	var cmd tea.Cmd
 	if m.searchFocused && m.modal == ModalNone {
 		m.searchInput, cmd = m.searchInput.Update(msg)
-		m.searchQuery = m.searchInput.Value()
-		m.refreshResults()
+		if v := m.searchInput.Value(); v != m.searchQuery {
+			m.searchQuery = v
+			return m, tea.Batch(cmd, m.queueSearch())
+		}
 		return m, cmd
 	}
 
@@ -70,35 +110,82 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m.handleSearchKey(msg)
 	}
 
-	// Normal mode
-	switch key {
-	case "q", "ctrl+c":
+	// Normal mode; ctrl+c quits whatever the keymap says
+	if key == "ctrl+c" {
+		return m, tea.Quit
+	}
+	if pending := m.markPending; pending != "" {
+		m.markPending = ""
+		m.count = 0
+		return m.handleMarkKey(pending, key)
+	}
+	if m.countDigit(key) {
+		return m, m.waitForCount()
+	}
+	a := m.keys.actions[key]
+	if m.countAction != "" && !counted[a] {
+		// The held digit wasn't a count after all
+		m, cmd := m.flushCount()
+		next, cmd2 := m.runAction(a, 0)
+		return next, tea.Batch(cmd, cmd2)
+	}
+	count := m.count
+	m.count, m.countAction = 0, ""
+	return m.runAction(a, count)
+}
+
+// runAction performs a normal-mode action. Motions repeat count times, or
+// once if count is 0.
+func (m Model) runAction(a action, count int) (tea.Model, tea.Cmd) {
+	n := max(count, 1)
+	switch a {
+	case actQuit:
 		return m, tea.Quit
 
-	case "/":
+	case actPalette:
+		return m, m.openPalette()
+
+	case actSearch:
 		m.searchFocused = true
 		m.searchInput.Focus()
 		return m, textinput.Blink
 
-	case "j", "down":
-		m.moveDown(1)
+	case actDown:
+		m.moveDown(n)
 
-	case "k", "up":
-		m.moveUp(1)
+	case actUp:
+		m.moveUp(n)
 
-	case "g":
-		m.moveToTop()
+	case actTop:
+		if count > 0 {
+			m.gotoRow(count)
+		} else {
+			m.moveToTop()
+		}
 
-	case "G":
-		m.moveToBottom()
+	case actBottom:
+		if count > 0 {
+			m.gotoRow(count)
+		} else {
+			m.moveToBottom()
+		}
+
+	case actHalfPageDown:
+		m.moveDown(n * m.halfPage())
+
+	case actHalfPageUp:
+		m.moveUp(n * m.halfPage())
+
+	case actNextSection:
+		m.nextSection(n)
 
-	case "ctrl+d":
-		m.moveDown(m.halfPage())
+	case actPrevSection:
+		m.prevSection(n)
 
-	case "ctrl+u":
-		m.moveUp(m.halfPage())
+	case actSetMark, actJumpMark:
+		m.markPending = a
 
-	case "t":
+	case actToggleView:
 		// Toggle view mode
 		if m.viewMode == ViewEffective {
 			m.viewMode = ViewLocal
@@ -109,59 +196,132 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 		m.refreshResults()
 
-	case "enter", "e":
+	case actCycleFilter:
+		// Cycle source filter: All -> Local -> Inherited -> Override -> All
+		m.setSourceFilter((m.sourceFilter + 1) % 4)
+
+	case actCycleSort:
+		m.cycleSortOrder()
+
+	case actFilterLocal:
+		m.setSourceFilter(FilterLocal)
+
+	case actFilterInherited:
+		m.setSourceFilter(FilterInherited)
+
+	case actFilterOverride:
+		m.setSourceFilter(FilterOverride)
+
+	case actFilterAll:
+		m.setSourceFilter(FilterAll)
+
+	case actPreview:
+		// Toggle value preview pane
+		m.previewOpen = !m.previewOpen
+		m.ensureCursorVisible()
+
+	case actGrowPreview:
+		if m.previewOpen {
+			m.resizePreview(1)
+		}
+
+	case actShrinkPreview:
+		if m.previewOpen {
+			m.resizePreview(-1)
+		}
+
+	case actSecrets:
+		// Show/hide sensitive values
+		m.revealSecrets = !m.revealSecrets
+		if m.revealSecrets {
+			m.setToast("Showing secret values", false)
+		} else {
+			m.setToast("Masking secret values", false)
+		}
+
+	case actSearchExact, actSearchCase, actSearchKeysOnly:
+		m.toggleSearchOption(a)
+
+	case actToggleChain:
+		// Expand/collapse chain header
+		m.chainExpanded = !m.chainExpanded
+		m.ensureCursorVisible()
+
+	case actEdit:
 		// Edit selected
 		if v := m.selectedVar(); v != nil {
 			m.openEditModal(v.Key, v.Value, v.Description, false)
 		}
 
-	case "a":
+	case actAdd:
 		// Add new
 		m.openEditModal("", "", "", true)
 
-	case "A":
+	case actBulkImport:
 		// Bulk import
 		m.openBulkImportModal()
 
-	case "v":
+	case actView:
 		// View value
 		if m.selectedVar() != nil {
 			m.modal = ModalView
 			m.viewScrollOffset = 0
 		}
 
-	case "?":
+	case actImportEnv:
+		// Import .env from current directory
+		m.openEnvFileImport()
+
+	case actHook:
+		// Shell hook setup
+		m.modal = ModalHookSetup
+		m.hookError = ""
+
+	case actHelp:
 		// Help
 		m.modal = ModalHelp
 
-	case "x":
-		// Delete
-		if v := m.selectedVar(); v != nil && v.DefinedAtPath == m.ctx.CwdReal {
+	case actDelete:
+		// Delete (inherited vars are deleted at their source after confirmation)
+		if v := m.selectedVar(); v != nil {
 			m.deleteKey = v.Key
+			m.deletePath = v.DefinedAtPath
 			m.modal = ModalConfirmDelete
-		} else if v != nil {
-			m.setToast("Can only delete local vars", true)
 		}
 
-	case "u":
+	case actCopyProfile:
+		// Copy or move to another profile
+		if v := m.selectedVar(); v != nil {
+			m.openCopyProfileModal(v)
+		}
+
+	case actUndo:
 		// Undo
 		return m.handleUndo()
 
-	case "y":
+	case actTrash:
+		// Trash
+		m.openTrash()
+
+	case actTasks:
+		// Tasks
+		m.openTasks()
+
+	case actCopy:
 		// Copy KEY=value
 		if v := m.selectedVar(); v != nil {
-			m.clipboard = fmt.Sprintf("%s=%s", v.Key, v.Value)
-			m.setToast("Copied: "+v.Key+"=...", false)
+			cmd := m.copyToClipboard(fmt.Sprintf("%s=%s", v.Key, v.Value), v.Key, v.Value)
+			return m, cmd
 		}
 
-	case "Y":
+	case actCopyExport:
 		// Copy export line
 		if v := m.selectedVar(); v != nil {
-			m.clipboard = shell.FormatExport(v.Key, v.Value)
-			m.setToast("Copied export line", false)
+			cmd := m.copyToClipboard(shell.FormatExport(v.Key, v.Value), v.Key, v.Value)
+			return m, cmd
 		}
 
-	case "esc":
+	case actClearSearch:
 		if m.searchQuery != "" {
 			m.searchQuery = ""
 			m.searchInput.SetValue("")
@@ -172,6 +332,33 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// clipboardClearMsg asks to clear the system clipboard if it still holds content.
+type clipboardClearMsg struct {
+	content string
+}
+
+// copyToClipboard copies content to the system clipboard. The toast only ever
+// shows a masked value. Sensitive values are cleared after m.clipboardClear.
+func (m *Model) copyToClipboard(content, key, value string) tea.Cmd {
+	m.clipboard = content
+	masked := key + "=" + secrets.Mask(value)
+
+	if err := clipboard.WriteAll(content); err != nil {
+		m.setToast(fmt.Sprintf("Clipboard error: %v", err), true)
+		return nil
+	}
+
+	if m.clipboardClear <= 0 || !secrets.IsSensitive(key, value) {
+		m.setToast("Copied "+masked, false)
+		return nil
+	}
+
+	m.setToast(fmt.Sprintf("Copied %s (clears in %s)", masked, m.clipboardClear), false)
+	return tea.Tick(m.clipboardClear, func(time.Time) tea.Msg {
+		return clipboardClearMsg{content: content}
+	})
+}
+
 func (m Model) handleSearchKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	key := msg.String()
 
@@ -207,11 +394,22 @@ func (m Model) handleSearchKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
+	// Search toggles work while typing, unless bound to a plain character
+	switch a := m.keys.actions[key]; a {
+	case actSearchExact, actSearchCase, actSearchKeysOnly:
+		if utf8.RuneCountInString(key) > 1 {
+			m.toggleSearchOption(a)
+			return m, nil
+		}
+	}
+
 	// Forward to text input
 	var cmd tea.Cmd
 	m.searchInput, cmd = m.searchInput.Update(msg)
-	m.searchQuery = m.searchInput.Value()
-	m.refreshResults()
+	if v := m.searchInput.Value(); v != m.searchQuery {
+		m.searchQuery = v
+		return m, tea.Batch(cmd, m.queueSearch())
+	}
 	return m, cmd
 }
 
@@ -229,6 +427,22 @@ func (m Model) handleModalKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m.handleHelpModalKey(key)
 	case ModalConfirmDelete:
 		return m.handleDeleteConfirmKey(key)
+	case ModalHookSetup:
+		return m.handleHookSetupKey(key)
+	case ModalImportPreview:
+		return m.handleImportPreviewKey(key)
+	case ModalCopyProfile:
+		return m.handleCopyProfileKey(msg, key)
+	case ModalPalette:
+		return m.handlePaletteKey(msg, key)
+	case ModalTrash:
+		return m.handleTrashKey(key)
+	case ModalTasks:
+		return m.handleTasksKey(msg, key)
+	case ModalProgress:
+		return m.handleProgressKey(key)
+	case ModalUnlock:
+		return m.handleUnlockKey(msg, key)
 	}
 
 	return m, nil
@@ -257,12 +471,33 @@ func (m Model) handleEditModalKey(msg tea.KeyMsg, key string) (tea.Model, tea.Cm
 			m.editFocus = FocusDescription
 			m.editDescInput.Focus()
 		case FocusDescription:
+			if m.editIsNew {
+				m.editFocus = FocusScope
+			} else {
+				m.editFocus = FocusKey
+				m.editKeyInput.Focus()
+			}
+		case FocusScope:
 			m.editFocus = FocusKey
 			m.editKeyInput.Focus()
 		}
 		return m, nil
 	}
 
+	if m.editFocus == FocusScope {
+		switch key {
+		case "left", "h", "up", "k":
+			if m.editScope > 0 {
+				m.editScope--
+			}
+		case "right", "l", "down", "j":
+			if m.editScope < len(m.ctx.Chain)-1 {
+				m.editScope++
+			}
+		}
+		return m, nil
+	}
+
 	// Forward to focused input
 	var cmd tea.Cmd
 	switch m.editFocus {
@@ -284,7 +519,7 @@ func (m Model) handleBulkImportKey(msg tea.KeyMsg, key string) (tea.Model, tea.C
 		return m, nil
 
 	case "ctrl+s":
-		return m.saveBulkImport()
+		return m.previewBulkImport()
 	}
 
 	// Forward to textarea
@@ -293,6 +528,44 @@ func (m Model) handleBulkImportKey(msg tea.KeyMsg, key string) (tea.Model, tea.C
 	return m, cmd
 }
 
+func (m Model) handleImportPreviewKey(key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "esc":
+		// Back to the import text
+		m.modal = ModalBulkImport
+		m.bulkInput.Focus()
+		m.importLines = nil
+		m.importStatus = ""
+	case "j", "down":
+		if m.importCursor < len(m.importLines)-1 {
+			m.importCursor++
+		}
+	case "k", "up":
+		if m.importCursor > 0 {
+			m.importCursor--
+		}
+	case " ", "x":
+		if m.importCursor < len(m.importLines) {
+			m.importLines[m.importCursor].Enabled = !m.importLines[m.importCursor].Enabled
+		}
+	case "a":
+		// Toggle all: enable everything unless everything is already enabled
+		allOn := true
+		for _, l := range m.importLines {
+			if !l.Enabled {
+				allOn = false
+				break
+			}
+		}
+		for i := range m.importLines {
+			m.importLines[i].Enabled = !allOn
+		}
+	case "enter", "ctrl+s", "y":
+		return m.saveBulkImport()
+	}
+	return m, nil
+}
+
 func (m Model) handleViewModalKey(key string) (tea.Model, tea.Cmd) {
 	switch key {
 	case "esc", "q", "v", "enter":
@@ -312,7 +585,7 @@ func (m Model) handleHelpModalKey(key string) (tea.Model, tea.Cmd) {
 	if maxLines < 5 {
 		maxLines = 5
 	}
-	totalBindings := m.getHelpBindingsCount()
+	totalBindings := len(m.keys.bindings)
 	maxOffset := totalBindings - maxLines
 	if maxOffset < 0 {
 		maxOffset = 0
@@ -342,19 +615,198 @@ func (m Model) handleDeleteConfirmKey(key string) (tea.Model, tea.Cmd) {
 	switch key {
 	case "y", "Y":
 		return m.confirmDelete()
+	case "o", "O":
+		if m.deletePath != m.ctx.CwdReal {
+			return m.createEmptyOverride()
+		}
 	case "n", "N", "esc":
 		m.modal = ModalNone
 		m.deleteKey = ""
+		m.deletePath = ""
+	}
+	return m, nil
+}
+
+func (m Model) handleHookSetupKey(key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "esc", "n", "q":
+		m.modal = ModalNone
+		m.hookError = ""
+	case "tab":
+		// Cycle through supported shells
+		for i, s := range shell.SupportedShells {
+			if s == m.hookShell {
+				m.hookShell = shell.SupportedShells[(i+1)%len(shell.SupportedShells)]
+				break
+			}
+		}
+		m.hookError = ""
+	case "enter", "y":
+		return m.installHook()
+	}
+	return m, nil
+}
+
+// openCopyProfileModal offers the profiles v can be copied or moved to.
+func (m *Model) openCopyProfileModal(v *env.ResolvedVar) {
+	m.modal = ModalCopyProfile
+	m.profileKey = v.Key
+	m.profilePath = v.DefinedAtPath
+	m.profileMove = false
+	m.profileOverwrite = false
+	m.profileError = ""
+	m.profileInput.SetValue("")
+	m.profileInput.Blur()
+
+	current := m.resolver.GetProfile()
+	m.profileChoices = nil
+	profiles, err := m.db.ListProfiles()
+	if err != nil {
+		m.profileError = fmt.Sprintf("Error: %v", err)
+	}
+	if current != env.DefaultProfile {
+		profiles = append(profiles, env.DefaultProfile)
+	}
+	seen := make(map[string]bool)
+	for _, p := range profiles {
+		if p != current && !seen[p] {
+			seen[p] = true
+			m.profileChoices = append(m.profileChoices, p)
+		}
+	}
+	sort.Strings(m.profileChoices)
+
+	m.profileCursor = 0
+	if len(m.profileChoices) == 0 {
+		m.profileInput.Focus()
+	}
+}
+
+func (m Model) handleCopyProfileKey(msg tea.KeyMsg, key string) (tea.Model, tea.Cmd) {
+	onInput := m.profileCursor == len(m.profileChoices)
+
+	switch key {
+	case "esc":
+		m.modal = ModalNone
+		m.profileError = ""
+		return m, nil
+	case "tab":
+		m.profileMove = !m.profileMove
+		return m, nil
+	case "enter":
+		return m.copyToProfile()
+	case "up", "down":
+		if key == "up" && m.profileCursor > 0 {
+			m.profileCursor--
+		} else if key == "down" && !onInput {
+			m.profileCursor++
+		}
+		m.profileOverwrite = false
+		m.profileError = ""
+		if m.profileCursor == len(m.profileChoices) {
+			m.profileInput.Focus()
+		} else {
+			m.profileInput.Blur()
+		}
+		return m, nil
+	}
+
+	if onInput {
+		var cmd tea.Cmd
+		m.profileInput, cmd = m.profileInput.Update(msg)
+		m.profileOverwrite = false
+		return m, cmd
+	}
+
+	switch key {
+	case "k":
+		return m.handleCopyProfileKey(msg, "up")
+	case "j":
+		return m.handleCopyProfileKey(msg, "down")
+	case "q":
+		m.modal = ModalNone
 	}
 	return m, nil
 }
 
+// copyToProfile copies or moves the var to the chosen profile at the scope
+// it is defined at. An existing var there is only replaced on a second Enter.
+func (m Model) copyToProfile() (tea.Model, tea.Cmd) {
+	target := strings.TrimSpace(m.profileInput.Value())
+	if m.profileCursor < len(m.profileChoices) {
+		target = m.profileChoices[m.profileCursor]
+	}
+	if target == "" {
+		m.profileError = "Enter a profile name"
+		return m, nil
+	}
+
+	err := m.resolver.MoveVar(m.profilePath, m.profileKey, m.profilePath, target, !m.profileMove, m.profileOverwrite)
+	if errors.Is(err, db.ErrVarExists) {
+		m.profileOverwrite = true
+		m.profileError = fmt.Sprintf("%s already exists in %s; Enter again to replace it", m.profileKey, target)
+		return m, nil
+	}
+	if err != nil {
+		m.profileError = fmt.Sprintf("Error: %v", err)
+		return m, nil
+	}
+
+	m.modal = ModalNone
+	m.profileError = ""
+	if err := m.reloadContext(); err != nil {
+		m.setToast(fmt.Sprintf("Reload error: %v", err), true)
+	} else if m.profileMove {
+		m.setToast(fmt.Sprintf("Moved %s to %s", m.profileKey, target), false)
+	} else {
+		m.setToast(fmt.Sprintf("Copied %s to %s", m.profileKey, target), false)
+	}
+	return m, nil
+}
+
+func (m Model) installHook() (tea.Model, tea.Cmd) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		m.hookError = fmt.Sprintf("Error: %v", err)
+		return m, nil
+	}
+
+	rc, added, err := shell.InstallHook(m.hookShell, home)
+	if err != nil {
+		m.hookError = fmt.Sprintf("Error: %v", err)
+		return m, nil
+	}
+
+	m.hookInstalled = true
+	if added {
+		m.setToast(fmt.Sprintf("Hook added to %s, restart your shell", rc), false)
+	} else {
+		m.setToast(fmt.Sprintf("Hook already in %s", rc), false)
+	}
+
+	m.modal = ModalNone
+	m.hookError = ""
+	return m, nil
+}
+
+// openEnvFileImport opens the bulk import modal prefilled with ./.env.
+func (m *Model) openEnvFileImport() {
+	content, err := os.ReadFile(filepath.Join(m.ctx.CwdReal, ".env"))
+	if err != nil {
+		m.setToast("No .env file in current directory", true)
+		return
+	}
+	m.openBulkImportModal()
+	m.bulkInput.SetValue(string(content))
+}
+
 func (m *Model) openEditModal(key, value, description string, isNew bool) {
 	m.modal = ModalEdit
 	m.editIsNew = isNew
 	m.editKeyInput.SetValue(key)
 	m.editValInput.SetValue(value)
 	m.editDescInput.SetValue(description)
+	m.editScope = len(m.ctx.Chain) - 1
 	m.editError = ""
 
 	if isNew {
@@ -379,10 +831,20 @@ func (m *Model) openBulkImportModal() {
 
 var keyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 
+// editPath returns the scope the edit modal saves to: the chosen chain
+// directory for a new var, else the current directory.
+func (m Model) editPath() string {
+	if m.editIsNew && m.editScope >= 0 && m.editScope < len(m.ctx.Chain) {
+		return m.ctx.Chain[m.editScope]
+	}
+	return m.ctx.CwdReal
+}
+
 func (m Model) saveEdit() (tea.Model, tea.Cmd) {
 	key := m.editKeyInput.Value()
 	value := m.editValInput.Value()
 	description := m.editDescInput.Value()
+	path := m.editPath()
 
 	// Validate key
 	if !keyRegex.MatchString(key) {
@@ -390,38 +852,55 @@ func (m Model) saveEdit() (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
+	if err := m.resolver.CheckKeys(path, key); err != nil {
+		m.editError = err.Error()
+		return m, nil
+	}
+
+	warnings, err := m.resolver.CheckSecrets(path, map[string]string{key: value})
+	if err != nil {
+		m.editError = err.Error()
+		return m, nil
+	}
+
 	// Save undo info
-	oldVar, _ := m.resolver.GetLocalVarsFromDB(m.ctx.CwdReal)
+	oldVar, _ := m.resolver.GetLocalVarsFromDB(path)
 	var hadVal bool
-	var oldVal string
+	var oldVal, oldDesc string
 	for _, v := range oldVar {
 		if v.Key == key {
 			hadVal = true
-			oldVal = v.Value
+			oldVal, oldDesc = v.Value, v.Description
 			break
 		}
 	}
 
 	// Set the variable
-	if err := m.resolver.SetVar(m.ctx.CwdReal, key, value, description); err != nil {
+	if err := m.resolver.SetVar(path, key, value, description); err != nil {
 		m.editError = fmt.Sprintf("Error: %v", err)
 		return m, nil
 	}
 
 	// Push undo
 	m.pushUndo(UndoAction{
-		Type:   "set",
-		Key:    key,
-		OldVal: oldVal,
-		NewVal: value,
-		HadVal: hadVal,
+		Type:    "set",
+		Key:     key,
+		Path:    path,
+		OldVal:  oldVal,
+		OldDesc: oldDesc,
+		NewVal:  value,
+		HadVal:  hadVal,
 	})
 
 	// Reload and close
 	if err := m.reloadContext(); err != nil {
 		m.setToast(fmt.Sprintf("Reload error: %v", err), true)
+	} else if len(warnings) > 0 {
+		m.setToast("Saved, but "+warnings[0], true)
 	} else {
-		if m.editIsNew {
+		if m.editIsNew && path != m.ctx.CwdReal {
+			m.setToast(fmt.Sprintf("Added %s at %s", key, displayPath(path)), false)
+		} else if m.editIsNew {
 			m.setToast(fmt.Sprintf("Added %s", key), false)
 		} else {
 			m.setToast(fmt.Sprintf("Updated %s", key), false)
@@ -433,12 +912,13 @@ func (m Model) saveEdit() (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-func (m Model) saveBulkImport() (tea.Model, tea.Cmd) {
+// previewBulkImport parses the bulk import text and opens the diff preview.
+func (m Model) previewBulkImport() (tea.Model, tea.Cmd) {
 	content := m.bulkInput.Value()
 	parsed, invalid := shell.ParseEnvFileWithDesc(content)
 
 	if len(invalid) > 0 {
-		m.bulkError = fmt.Sprintf("Invalid lines: %v", invalid)
+		m.bulkError = fmt.Sprintf("Invalid lines: %v", redact.Lines(invalid))
 		return m, nil
 	}
 
@@ -447,91 +927,217 @@ func (m Model) saveBulkImport() (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
-	// Convert to db.VarData
-	varData := make(map[string]db.VarData)
+	keys := make([]string, 0, len(parsed))
+	for k := range parsed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if err := m.resolver.CheckKeys(m.ctx.CwdReal, keys...); err != nil {
+		m.bulkError = strings.ReplaceAll(err.Error(), "\n", "; ")
+		return m, nil
+	}
+
+	values := make(map[string]string)
 	for k, v := range parsed {
-		varData[k] = db.VarData{Value: v.Value, Description: v.Description}
+		values[k] = v.Value
 	}
+	warnings, err := m.resolver.CheckSecrets(m.ctx.CwdReal, values)
+	if err != nil {
+		m.bulkError = err.Error()
+		return m, nil
+	}
+	m.importWarnings = warnings
+
+	oldVars, err := m.resolver.GetLocalVarsFromDB(m.ctx.CwdReal)
+	if err != nil {
+		m.bulkError = fmt.Sprintf("Error: %v", err)
+		return m, nil
+	}
+
+	m.importLines = buildImportLines(parsed, oldVars)
+	m.importCursor = 0
+	m.bulkInput.Blur()
+	m.bulkError = ""
+	m.modal = ModalImportPreview
+	return m, nil
+}
 
-	// Get existing for undo
+// buildImportLines diffs parsed import vars against existing local vars.
+// Lines are sorted by key; unchanged keys start disabled.
+func buildImportLines(parsed map[string]shell.ParsedVar, existing []db.EnvVar) []ImportLine {
+	oldByKey := make(map[string]db.EnvVar)
+	for _, v := range existing {
+		oldByKey[v.Key] = v
+	}
+
+	lines := make([]ImportLine, 0, len(parsed))
+	for k, p := range parsed {
+		line := ImportLine{Key: k, NewVal: p.Value, NewDesc: p.Description, Status: ImportAdd, Enabled: true}
+		if old, ok := oldByKey[k]; ok {
+			line.OldVal = old.Value
+			if old.Value == p.Value && old.Description == p.Description {
+				line.Status = ImportUnchanged
+				line.Enabled = false
+			} else {
+				line.Status = ImportUpdate
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].Key < lines[j].Key
+	})
+	return lines
+}
+
+func (m Model) saveBulkImport() (tea.Model, tea.Cmd) {
+	// Convert enabled lines to db.VarData
+	varData := make(map[string]db.VarData)
+	added := 0
+	updated := 0
+	for _, l := range m.importLines {
+		if !l.Enabled {
+			continue
+		}
+		varData[l.Key] = db.VarData{Value: l.NewVal, Description: l.NewDesc}
+		if l.Status == ImportAdd {
+			added++
+		} else {
+			updated++
+		}
+	}
+
+	if len(varData) == 0 {
+		m.setToast("Nothing selected to import", true)
+		return m, nil
+	}
+
+	// Remember what the import overwrites or adds, for undo
 	oldVars, _ := m.resolver.GetLocalVarsFromDB(m.ctx.CwdReal)
-	oldMap := make(map[string]string)
+	oldMap := make(map[string]db.VarData)
 	for _, v := range oldVars {
-		oldMap[v.Key] = v.Value
+		if _, ok := varData[v.Key]; ok {
+			oldMap[v.Key] = db.VarData{Value: v.Value, Description: v.Description}
+		}
+	}
+	var addedKeys []string
+	for k := range varData {
+		if _, ok := oldMap[k]; !ok {
+			addedKeys = append(addedKeys, k)
+		}
+	}
+
+	if len(varData) >= asyncImportMin {
+		return m.startImport(varData, oldMap)
 	}
 
 	// Set all vars
 	if err := m.resolver.SetVarsBatch(m.ctx.CwdReal, varData); err != nil {
-		m.bulkError = fmt.Sprintf("Error: %v", err)
+		m.setToast(fmt.Sprintf("Import error: %v", err), true)
 		return m, nil
 	}
 
 	// Push undo
 	m.pushUndo(UndoAction{
 		Type:  "import",
+		Path:  m.ctx.CwdReal,
 		Batch: oldMap,
+		Added: addedKeys,
 	})
 
 	// Reload and close
-	added := 0
-	updated := 0
-	for k := range parsed {
-		if _, existed := oldMap[k]; existed {
-			updated++
-		} else {
-			added++
-		}
-	}
-
 	if err := m.reloadContext(); err != nil {
 		m.setToast(fmt.Sprintf("Reload error: %v", err), true)
 	} else {
-		m.setToast(fmt.Sprintf("Imported %d (added %d, updated %d)", len(parsed), added, updated), false)
+		m.setToast(fmt.Sprintf("Imported %d (added %d, updated %d)", len(varData), added, updated), false)
 	}
 
 	m.modal = ModalNone
 	m.bulkError = ""
+	m.importLines = nil
+	m.importStatus = ""
 	return m, nil
 }
 
 func (m Model) confirmDelete() (tea.Model, tea.Cmd) {
 	key := m.deleteKey
+	path := m.deletePath
+	if path == "" {
+		path = m.ctx.CwdReal
+	}
 
 	// Get old value for undo
-	var oldVal string
-	vars, _ := m.resolver.GetLocalVarsFromDB(m.ctx.CwdReal)
+	var oldVal, oldDesc string
+	vars, _ := m.resolver.GetLocalVarsFromDB(path)
 	for _, v := range vars {
 		if v.Key == key {
-			oldVal = v.Value
+			oldVal, oldDesc = v.Value, v.Description
 			break
 		}
 	}
 
 	// Delete
-	if err := m.resolver.DeleteVar(m.ctx.CwdReal, key); err != nil {
+	if err := m.resolver.DeleteVar(path, key); err != nil {
 		m.setToast(fmt.Sprintf("Delete error: %v", err), true)
 		m.modal = ModalNone
 		m.deleteKey = ""
+		m.deletePath = ""
 		return m, nil
 	}
 
 	// Push undo
 	m.pushUndo(UndoAction{
-		Type:   "delete",
-		Key:    key,
-		OldVal: oldVal,
-		HadVal: true,
+		Type:    "delete",
+		Key:     key,
+		Path:    path,
+		OldVal:  oldVal,
+		OldDesc: oldDesc,
+		HadVal:  true,
 	})
 
 	// Reload
 	if err := m.reloadContext(); err != nil {
 		m.setToast(fmt.Sprintf("Reload error: %v", err), true)
+	} else if path != m.ctx.CwdReal {
+		m.setToast(fmt.Sprintf("Deleted %s at %s", key, displayPath(path)), false)
 	} else {
 		m.setToast(fmt.Sprintf("Deleted %s", key), false)
 	}
 
 	m.modal = ModalNone
 	m.deleteKey = ""
+	m.deletePath = ""
+	return m, nil
+}
+
+// createEmptyOverride shadows an inherited var with an empty local value.
+func (m Model) createEmptyOverride() (tea.Model, tea.Cmd) {
+	key := m.deleteKey
+
+	if err := m.resolver.SetVar(m.ctx.CwdReal, key, "", ""); err != nil {
+		m.setToast(fmt.Sprintf("Override error: %v", err), true)
+		m.modal = ModalNone
+		m.deleteKey = ""
+		m.deletePath = ""
+		return m, nil
+	}
+
+	m.pushUndo(UndoAction{
+		Type:   "set",
+		Key:    key,
+		HadVal: false,
+	})
+
+	if err := m.reloadContext(); err != nil {
+		m.setToast(fmt.Sprintf("Reload error: %v", err), true)
+	} else {
+		m.setToast(fmt.Sprintf("Overrode %s with empty value", key), false)
+	}
+
+	m.modal = ModalNone
+	m.deleteKey = ""
+	m.deletePath = ""
 	return m, nil
 }
 
@@ -545,23 +1151,38 @@ func (m Model) handleUndo() (tea.Model, tea.Cmd) {
 	var err error
 	switch action.Type {
 	case "set":
+		path := action.Path
+		if path == "" {
+			path = m.ctx.CwdReal
+		}
 		if action.HadVal {
-			// Restore old value (description is lost on undo)
-			err = m.resolver.SetVar(m.ctx.CwdReal, action.Key, action.OldVal, "")
+			err = m.resolver.SetVar(path, action.Key, action.OldVal, action.OldDesc)
 		} else {
 			// Delete the new key
-			err = m.resolver.DeleteVar(m.ctx.CwdReal, action.Key)
+			err = m.resolver.DeleteVar(path, action.Key)
 		}
 
 	case "delete":
-		// Restore deleted key (description is lost on undo)
-		err = m.resolver.SetVar(m.ctx.CwdReal, action.Key, action.OldVal, "")
+		// Restore deleted key at its original scope, from the trash if it's
+		// still there so its flags and tags come back too
+		path := action.Path
+		if path == "" {
+			path = m.ctx.CwdReal
+		}
+		var restored bool
+		restored, err = m.restoreDeleted(path, action.Key)
+		if err == nil && !restored {
+			err = m.resolver.SetVar(path, action.Key, action.OldVal, action.OldDesc)
+		}
 
 	case "import":
-		// This is complex - we'd need to restore old state
-		// For simplicity, just notify user
-		m.setToast("Import undo not fully supported", true)
-		return m, nil
+		// Put back what the import overwrote and drop what it added
+		if len(action.Batch) > 0 {
+			err = m.resolver.SetVarsBatch(action.Path, action.Batch)
+		}
+		if err == nil && len(action.Added) > 0 {
+			err = m.resolver.DeleteVarsBatch(action.Path, action.Added)
+		}
 	}
 
 	if err != nil {
@@ -0,0 +1,344 @@
+package tui
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/nick-skriabin/enva/internal/db"
+	"github.com/nick-skriabin/enva/internal/env"
+	"github.com/nick-skriabin/enva/internal/protect"
+	"github.com/nick-skriabin/enva/internal/shell"
+)
+
+func TestImportKeepsDescriptionsAndUndoes(t *testing.T) {
+	store, r, child := setupTUI(t)
+	r.SetVar(child, "DEBUG", "1", "Verbose logging")
+	ctx, err := r.Resolve(child)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	m := NewModel(store, r, ctx)
+
+	parsed, invalid := shell.ParseEnvFileWithDesc("DEBUG=0 # Quiet by default\nPORT=8080 # Listen port\n")
+	if len(invalid) > 0 {
+		t.Fatalf("invalid lines: %v", invalid)
+	}
+	existing, _ := r.GetLocalVarsFromDB(child)
+	m.importLines = buildImportLines(parsed, existing)
+
+	next, _ := m.saveBulkImport()
+	m = next.(Model)
+	vars, _ := r.GetLocalVarsFromDB(child)
+	got := map[string]string{}
+	for _, v := range vars {
+		got[v.Key] = v.Value + "|" + v.Description
+	}
+	if got["DEBUG"] != "0|Quiet by default" || got["PORT"] != "8080|Listen port" {
+		t.Errorf("after import = %v", got)
+	}
+
+	next, _ = m.handleUndo()
+	m = next.(Model)
+	vars, _ = r.GetLocalVarsFromDB(child)
+	got = map[string]string{}
+	for _, v := range vars {
+		got[v.Key] = v.Value + "|" + v.Description
+	}
+	if _, ok := got["PORT"]; ok || got["DEBUG"] != "1|Verbose logging" {
+		t.Errorf("after undo = %v", got)
+	}
+}
+
+func TestBuildImportLines(t *testing.T) {
+	existing := []db.EnvVar{
+		{Key: "DEBUG", Value: "1", Description: "Verbose logging"},
+		{Key: "PORT", Value: "8080"},
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  []ImportLine
+	}{
+		{"new key", "NAME=app", []ImportLine{
+			{Key: "NAME", NewVal: "app", Status: ImportAdd, Enabled: true},
+		}},
+		{"double quotes", `NAME="hello world"`, []ImportLine{
+			{Key: "NAME", NewVal: "hello world", Status: ImportAdd, Enabled: true},
+		}},
+		{"single quotes keep #", `URL='http://host/#top'`, []ImportLine{
+			{Key: "URL", NewVal: "http://host/#top", Status: ImportAdd, Enabled: true},
+		}},
+		{"comment lines skipped", "# settings\n\nPORT=8080", []ImportLine{
+			{Key: "PORT", OldVal: "8080", NewVal: "8080", Status: ImportUnchanged},
+		}},
+		{"trailing comment is the description", "DEBUG=1 # Verbose logging", []ImportLine{
+			{Key: "DEBUG", OldVal: "1", NewVal: "1", NewDesc: "Verbose logging", Status: ImportUnchanged},
+		}},
+		{"new description is an update", "DEBUG=1 # Noisy", []ImportLine{
+			{Key: "DEBUG", OldVal: "1", NewVal: "1", NewDesc: "Noisy", Status: ImportUpdate, Enabled: true},
+		}},
+		{"export prefix", "export PORT=9090\nexport NAME=\"a b\"", []ImportLine{
+			{Key: "NAME", NewVal: "a b", Status: ImportAdd, Enabled: true},
+			{Key: "PORT", OldVal: "8080", NewVal: "9090", Status: ImportUpdate, Enabled: true},
+		}},
+	}
+	for _, tt := range tests {
+		parsed, invalid := shell.ParseEnvFileWithDesc(tt.input)
+		if len(invalid) > 0 {
+			t.Errorf("%s: invalid lines %v", tt.name, invalid)
+			continue
+		}
+		if got := buildImportLines(parsed, existing); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: buildImportLines = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImportProgressCancelAndResume(t *testing.T) {
+	defer func(minVars, chunk int) { asyncImportMin, importChunk = minVars, chunk }(asyncImportMin, importChunk)
+	asyncImportMin, importChunk = 2, 1
+
+	store, r, child := setupTUI(t)
+	ctx, _ := r.Resolve(child)
+	m := NewModel(store, r, ctx)
+	m.width, m.height = 100, 30
+
+	parsed, _ := shell.ParseEnvFileWithDesc("A=1\nB=2\nC=3\nDEBUG=0\n")
+	existing, _ := r.GetLocalVarsFromDB(child)
+	m.importLines = buildImportLines(parsed, existing)
+
+	next, cmd := m.saveBulkImport()
+	m = next.(Model)
+	if m.modal != ModalProgress || cmd == nil {
+		t.Fatalf("modal = %v, cmd = %v; want the progress modal", m.modal, cmd)
+	}
+	next, cmd = m.Update(cmd())
+	m = next.(Model)
+	if !strings.Contains(m.View(), "1/4") {
+		t.Errorf("progress modal = %q", m.View())
+	}
+
+	// Cancel lands after the chunk in flight
+	next, _ = m.handleKey(tea.KeyMsg{Type: tea.KeyEsc})
+	next, _ = next.(Model).Update(cmd())
+	m = next.(Model)
+	if m.modal != ModalImportPreview || !strings.Contains(m.importStatus, "2 of 4") {
+		t.Fatalf("after cancel: modal = %v, status = %q", m.modal, m.importStatus)
+	}
+	enabled := 0
+	for _, l := range m.importLines {
+		if l.Enabled {
+			enabled++
+		}
+	}
+	if enabled != 2 {
+		t.Errorf("%d lines left to import, want 2: %+v", enabled, m.importLines)
+	}
+
+	// Undo takes back only what was written
+	next, _ = m.handleUndo()
+	m = next.(Model)
+	if v, _ := r.GetVar(child, "A"); v != nil {
+		t.Errorf("A after undo = %+v", v)
+	}
+
+	// Enter imports the rest
+	next, cmd = m.handleImportPreviewKey("enter")
+	m = next.(Model)
+	for cmd != nil {
+		next, cmd = m.Update(cmd())
+		m = next.(Model)
+	}
+	if m.modal != ModalNone {
+		t.Errorf("modal after resuming = %v", m.modal)
+	}
+	for _, key := range []string{"C", "DEBUG"} {
+		if v, _ := r.GetVar(child, key); v == nil {
+			t.Errorf("%s wasn't imported", key)
+		}
+	}
+}
+
+func TestUndoDeleteRestoresDescription(t *testing.T) {
+	store, r, child := setupTUI(t)
+	r.SetVar(child, "DEBUG", "1", "Verbose logging")
+	ctx, _ := r.Resolve(child)
+	m := NewModel(store, r, ctx)
+
+	m.deleteKey, m.deletePath = "DEBUG", child
+	next, _ := m.confirmDelete()
+	next, _ = next.(Model).handleUndo()
+
+	ctx = next.(Model).ctx
+	if v := ctx.Resolved["DEBUG"]; v == nil || v.Value != "1" || v.Description != "Verbose logging" {
+		t.Errorf("DEBUG after undo = %+v", v)
+	}
+}
+
+func TestTrashRestore(t *testing.T) {
+	store, r, child := setupTUI(t)
+	r.SetVar(child, "DEBUG", "1", "Verbose logging")
+	r.AddTag(child, "DEBUG", "dev")
+	ctx, _ := r.Resolve(child)
+	m := NewModel(store, r, ctx)
+
+	m.deleteKey, m.deletePath = "DEBUG", child
+	next, _ := m.confirmDelete()
+	r.SetVar(child, "DEBUG", "2", "")
+
+	next, _ = next.(Model).handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("T")})
+	m = next.(Model)
+	if m.modal != ModalTrash || len(m.trashItems) != 1 || m.trashItems[0].Key != "DEBUG" {
+		t.Fatalf("trash = %+v", m.trashItems)
+	}
+
+	// The key was set again, so the first Enter only asks to confirm
+	next, _ = m.handleTrashKey("enter")
+	m = next.(Model)
+	if m.modal != ModalTrash || !m.trashOverwrite {
+		t.Fatalf("first Enter should ask before replacing, error %q", m.trashError)
+	}
+	next, _ = m.handleTrashKey("enter")
+	m = next.(Model)
+
+	v := m.ctx.Resolved["DEBUG"]
+	if m.modal != ModalNone || v == nil || v.Value != "1" || !v.HasTag("dev") {
+		t.Errorf("DEBUG after restore = %+v", v)
+	}
+}
+
+func TestTasksModal(t *testing.T) {
+	store, r, child := setupTUI(t)
+	r.SetTask(filepath.Dir(child), "build", "make")
+	ctx, _ := r.Resolve(child)
+	var m tea.Model = NewModel(store, r, ctx)
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+
+	press := func(keys ...string) {
+		for _, k := range keys {
+			msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+			switch k {
+			case "enter":
+				msg = tea.KeyMsg{Type: tea.KeyEnter}
+			case "tab":
+				msg = tea.KeyMsg{Type: tea.KeyTab}
+			}
+			m, _ = m.Update(msg)
+		}
+	}
+
+	// Editing an inherited task overrides it here
+	press("R", "e")
+	mm := m.(Model)
+	mm.taskCmdInput.SetValue("make -C service")
+	m = mm
+	press("enter")
+	checkFrame(t, "tasks", m.View(), 80, 24)
+	if task := m.(Model).ctx.Tasks["build"]; task == nil || task.Command != "make -C service" || task.DefinedAtPath != child {
+		t.Fatalf("build after edit = %+v", task)
+	}
+
+	press("a", "t", "e", "s", "t", "tab", "g", "o", " ", "t", "e", "s", "t", "enter")
+	if task := m.(Model).ctx.Tasks["test"]; task == nil || task.Command != "go test" {
+		t.Fatalf("test after add = %+v", task)
+	}
+
+	// Deleting the override brings back the root's task
+	press("k", "x")
+	if task := m.(Model).ctx.Tasks["build"]; task == nil || task.Command != "make" {
+		t.Errorf("build after delete = %+v, want the root's", task)
+	}
+}
+
+func TestCycleSortOrder(t *testing.T) {
+	store, r, child := setupTUI(t)
+	r.SetVar(child, "A_NEW", "1", "")
+	r.SetVar(child, "B_OLD", "2", "")
+	ctx, _ := r.Resolve(child)
+	now := time.Now()
+	for _, v := range ctx.GetSortedVars() {
+		if v.Key == "B_OLD" {
+			v.UpdatedAt = now.AddDate(-2, 0, 0)
+			v.CreatedAt = now.AddDate(-3, 0, 0)
+		} else {
+			v.UpdatedAt = now
+			v.CreatedAt = now.AddDate(-4, 0, 0)
+		}
+	}
+	m := NewModel(store, r, ctx)
+
+	order := func() string {
+		var keys []string
+		for _, res := range m.results {
+			if strings.HasSuffix(res.Var.Key, "_NEW") || strings.HasSuffix(res.Var.Key, "_OLD") {
+				keys = append(keys, res.Var.Key)
+			}
+		}
+		return strings.Join(keys, ",")
+	}
+	for _, want := range []string{"B_OLD,A_NEW", "A_NEW,B_OLD", "A_NEW,B_OLD"} {
+		m.cycleSortOrder()
+		if got := order(); got != want {
+			t.Errorf("sort %s: order = %s, want %s", m.sortOrder, got, want)
+		}
+	}
+}
+
+// fakeLocker protects "production" with passphrase "open sesame".
+type fakeLocker struct{ unlocked bool }
+
+func (l *fakeLocker) Locked(profile string) bool { return profile == "production" && !l.unlocked }
+
+func (l *fakeLocker) Unlock(profile, passphrase string) error {
+	if passphrase != "open sesame" {
+		return protect.ErrWrongPassphrase
+	}
+	l.unlocked = true
+	return nil
+}
+
+func TestSwitchToLockedProfile(t *testing.T) {
+	store, r, child := setupTUI(t)
+	prod := env.NewResolver(store, "production")
+	prod.SetVar(child, "API_URL", "https://prod.example.com", "")
+	ctx, _ := r.Resolve(child)
+	m := NewModel(store, r, ctx)
+	m.locker = &fakeLocker{}
+	m.width, m.height = 80, 24
+
+	var switchCmd paletteCommand
+	for _, c := range m.paletteCommands() {
+		if strings.HasPrefix(c.title, "Switch profile: production") {
+			switchCmd = c
+		}
+	}
+	if switchCmd.title != "Switch profile: production (locked)" {
+		t.Fatalf("palette entry = %q", switchCmd.title)
+	}
+
+	next, _ := switchCmd.run(m)
+	m = next.(Model)
+	if m.modal != ModalUnlock || m.ctx.Profile != env.DefaultProfile {
+		t.Fatalf("modal = %v, profile = %s; want the unlock prompt", m.modal, m.ctx.Profile)
+	}
+	checkFrame(t, "unlock", m.View(), 80, 24)
+
+	m.unlockInput.SetValue("guess")
+	next, _ = m.handleModalKey(tea.KeyMsg{Type: tea.KeyEnter})
+	m = next.(Model)
+	if m.modal != ModalUnlock || m.unlockError == "" || m.ctx.Profile != env.DefaultProfile {
+		t.Fatalf("after a wrong passphrase: modal = %v, error = %q", m.modal, m.unlockError)
+	}
+
+	m.unlockInput.SetValue("open sesame")
+	next, _ = m.handleModalKey(tea.KeyMsg{Type: tea.KeyEnter})
+	m = next.(Model)
+	if m.modal != ModalNone || m.ctx.Profile != "production" || m.ctx.Resolved["API_URL"].Value != "https://prod.example.com" {
+		t.Errorf("after unlocking: modal = %v, profile = %s", m.modal, m.ctx.Profile)
+	}
+}
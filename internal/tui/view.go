@@ -2,12 +2,19 @@ package tui
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/lipgloss"
 
+	"github.com/nick-skriabin/enva/internal/db"
 	"github.com/nick-skriabin/enva/internal/env"
 	"github.com/nick-skriabin/enva/internal/search"
+	"github.com/nick-skriabin/enva/internal/secrets"
+	"github.com/nick-skriabin/enva/internal/shell"
 )
 
 // ensure import is used
@@ -31,6 +38,22 @@ func (m Model) View() string {
 		return m.renderHelpModal()
 	case ModalConfirmDelete:
 		return m.renderDeleteConfirmModal()
+	case ModalHookSetup:
+		return m.renderHookSetupModal()
+	case ModalImportPreview:
+		return m.renderImportPreviewModal()
+	case ModalCopyProfile:
+		return m.renderCopyProfileModal()
+	case ModalPalette:
+		return m.renderPaletteModal()
+	case ModalTrash:
+		return m.renderTrashModal()
+	case ModalTasks:
+		return m.renderTasksModal()
+	case ModalProgress:
+		return m.renderProgressModal()
+	case ModalUnlock:
+		return m.renderUnlockModal()
 	}
 
 	var b strings.Builder
@@ -39,6 +62,10 @@ func (m Model) View() string {
 	b.WriteString(m.renderTopBar())
 	b.WriteString("\n")
 
+	// Resolution chain: root → cwd
+	b.WriteString(m.renderChainHeader())
+	b.WriteString("\n")
+
 	// Main content with border
 	b.WriteString(m.renderMainContent())
 
@@ -61,11 +88,20 @@ func (m Model) renderTopBar() string {
 	} else {
 		searchPart = styleDim.Render("Search: ") + styleDim.Render("...")
 	}
+	if m.searching {
+		searchPart += styleDim.Render(" searching…")
+	}
+	if mode := m.searchModeLabel(); mode != "" {
+		searchPart += styleDim.Render(" [" + mode + "]")
+	}
 
 	left := appName + sep + searchPart
 
-	// Right side: profile
+	// Right side: profile, plus database when not the default one
 	right := styleDim.Render(m.ctx.Profile)
+	if def, err := db.DefaultDBPath(); err == nil && m.db.Path() != def {
+		right = styleDim.Render(displayPath(m.db.Path())+" · ") + right
+	}
 
 	padding := m.width - lipgloss.Width(left) - lipgloss.Width(right)
 	if padding < 1 {
@@ -75,9 +111,46 @@ func (m Model) renderTopBar() string {
 	return left + strings.Repeat(" ", padding) + right
 }
 
+func (m Model) renderChainHeader() string {
+	depth := len(m.ctx.Chain)
+	summary := styleDim.Render("Root: ") + displayPath(m.ctx.RootDir) +
+		styleDim.Render("  Cwd: ") + displayPath(m.ctx.CwdReal) +
+		styleDim.Render(fmt.Sprintf("  Depth: %d", depth))
+
+	if !m.chainExpanded {
+		return truncateStyled(summary+styleDim.Render("  (c: expand)"), m.width)
+	}
+
+	// Count contributing vars per chain dir
+	counts := make(map[string]int)
+	for _, v := range m.ctx.Resolved {
+		counts[v.DefinedAtPath]++
+	}
+
+	// Show the dirs closest to cwd when the chain doesn't fit
+	shown := m.chainLinesShown()
+	start := depth - shown
+
+	lines := []string{truncateStyled(summary+styleDim.Render("  (c: collapse)"), m.width)}
+	for i := start; i < depth; i++ {
+		p := m.ctx.Chain[i]
+		prefix := "├─ "
+		if i == depth-1 {
+			prefix = "└─ "
+		}
+		if i == start && start > 0 {
+			lines = append(lines, styleDim.Render(fmt.Sprintf("  ├─ … %d more", start+1)))
+			continue
+		}
+		line := styleDim.Render("  "+prefix) + displayPath(p) + styleDim.Render(fmt.Sprintf("  %d var(s)", counts[p]))
+		lines = append(lines, truncateStyled(line, m.width))
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (m Model) renderMainContent() string {
 	// Calculate available height for table (total - top bar - help bar - horizontal lines)
-	contentHeight := m.height - 4
+	contentHeight := m.height - 4 - m.chainHeaderHeight() - m.previewPaneHeight()
 	if contentHeight < 3 {
 		contentHeight = 3
 	}
@@ -88,6 +161,12 @@ func (m Model) renderMainContent() string {
 		viewMode = "Local"
 	}
 	title := fmt.Sprintf("%s Variables (%d/%d)", viewMode, m.cursor+1, len(m.results))
+	if m.sourceFilter != FilterAll {
+		title += fmt.Sprintf(" [%s]", m.sourceFilter)
+	}
+	if m.sortOrder != SortKey {
+		title += fmt.Sprintf(" [by %s]", strings.ToLower(m.sortOrder.String()))
+	}
 
 	var b strings.Builder
 
@@ -105,6 +184,12 @@ func (m Model) renderMainContent() string {
 	// Table content
 	b.WriteString(m.renderTableContent(contentHeight))
 
+	// Value preview pane
+	if m.previewOpen {
+		b.WriteString("\n")
+		b.WriteString(m.renderPreviewPane())
+	}
+
 	// Bottom horizontal line
 	b.WriteString("\n")
 	b.WriteString(styleDim.Render(strings.Repeat("─", m.width)))
@@ -113,15 +198,61 @@ func (m Model) renderMainContent() string {
 	return b.String()
 }
 
+func (m Model) renderPreviewPane() string {
+	v := m.selectedVar()
+
+	title := "Preview"
+	if v != nil {
+		title = "Preview: " + v.Key
+		if len(v.Tags) > 0 {
+			title += " [" + strings.Join(v.Tags, ", ") + "]"
+		}
+		if v.Author != "" {
+			title += " · set by " + v.Author
+		}
+	}
+	titleStyled := styleBorderTitle.Render(title)
+	lineWidth := m.width - lipgloss.Width(titleStyled) - 3
+	if lineWidth < 0 {
+		lineWidth = 0
+	}
+
+	var lines []string
+	lines = append(lines, styleDim.Render("─ ")+titleStyled+styleDim.Render(" "+strings.Repeat("─", lineWidth)))
+
+	var valueLines []string
+	if v != nil {
+		wrapped := lipgloss.NewStyle().Width(m.width - 2).Render(m.shownValue(v))
+		valueLines = strings.Split(wrapped, "\n")
+	}
+	if len(valueLines) > m.previewHeight {
+		hidden := len(valueLines) - m.previewHeight + 1
+		valueLines = append(valueLines[:m.previewHeight-1], styleDim.Render(fmt.Sprintf("… %d more line(s), v to view all", hidden)))
+	}
+	for _, l := range valueLines {
+		lines = append(lines, " "+l)
+	}
+	for len(lines) < 1+m.previewHeight {
+		lines = append(lines, "")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 func (m Model) renderTableContent(height int) string {
 	// Column widths - border takes 1 char each side
 	innerWidth := m.width - 4
 	keyColWidth := 24
 	sourceColWidth := 10
 	descColWidth := 20
-	// Row format: " key  value  desc  source"
-	// Widths: 1 + key + 2 + value + 2 + desc + 2 + source
-	valueColWidth := innerWidth - keyColWidth - descColWidth - sourceColWidth - 7
+	// Sorting by time adds a date column after the source
+	timeColWidth := 0
+	if m.sortOrder != SortKey {
+		timeColWidth = 12
+	}
+	// Row format: " key  value  desc  source[  date]"
+	// Widths: 1 + key + 2 + value + 2 + desc + 2 + source (+ 2 + date)
+	valueColWidth := innerWidth - keyColWidth - descColWidth - sourceColWidth - timeColWidth - 7
 	if valueColWidth < 15 {
 		valueColWidth = 15
 	}
@@ -134,6 +265,9 @@ func (m Model) renderTableContent(height int) string {
 		valueColWidth, "Value",
 		descColWidth, "Description",
 		sourceColWidth, "Source")
+	if timeColWidth > 0 {
+		header += fmt.Sprintf("  %-*s", timeColWidth-2, m.sortOrder)
+	}
 	lines = append(lines, styleTableHeader.Render(header))
 
 	// Separator - horizontal line
@@ -146,6 +280,10 @@ func (m Model) renderTableContent(height int) string {
 		visibleRows = 1
 	}
 
+	if len(m.results) == 0 {
+		lines = append(lines, m.renderEmptyState()...)
+	}
+
 	endIdx := m.offset + visibleRows
 	if endIdx > len(m.results) {
 		endIdx = len(m.results)
@@ -160,7 +298,7 @@ func (m Model) renderTableContent(height int) string {
 		keyStr := fmt.Sprintf("%-*s", keyColWidth, truncate(v.Key, keyColWidth))
 
 		// Value
-		valueStr := fmt.Sprintf("%-*s", valueColWidth, truncate(singleLine(v.Value), valueColWidth))
+		valueStr := fmt.Sprintf("%-*s", valueColWidth, truncate(singleLine(m.shownValue(v)), valueColWidth))
 
 		// Description
 		descStr := fmt.Sprintf("%-*s", descColWidth, truncate(v.Description, descColWidth))
@@ -168,9 +306,15 @@ func (m Model) renderTableContent(height int) string {
 		// Source
 		sourceStr := fmt.Sprintf("%-*s", sourceColWidth, m.getSourceText(v))
 
+		// Date the list is sorted by
+		var timeStr string
+		if timeColWidth > 0 {
+			timeStr = "  " + formatDate(m.sortOrder.time(v))
+		}
+
 		if isSelected {
 			// Build plain row and apply selection style
-			row := fmt.Sprintf(" %s  %s  %s  %s", keyStr, valueStr, descStr, sourceStr)
+			row := fmt.Sprintf(" %s  %s  %s  %s%s", keyStr, valueStr, descStr, sourceStr, timeStr)
 			row = padToWidth(row, innerWidth)
 			lines = append(lines, styleTableRowSelected.Render(row))
 		} else {
@@ -185,7 +329,7 @@ func (m Model) renderTableContent(height int) string {
 			descStyled := styleDim.Render(descStr)
 			sourceStyled := m.getSourceBadge(v)
 
-			row := " " + keyStr + "  " + valueStr + "  " + descStyled + "  " + sourceStyled
+			row := " " + keyStr + "  " + valueStr + "  " + descStyled + "  " + sourceStyled + styleDim.Render(timeStr)
 			lines = append(lines, row)
 		}
 	}
@@ -198,6 +342,41 @@ func (m Model) renderTableContent(height int) string {
 	return strings.Join(lines, "\n")
 }
 
+// renderEmptyState returns the lines shown in place of an empty table.
+func (m Model) renderEmptyState() []string {
+	if m.searchQuery != "" {
+		return []string{"", styleDim.Render(fmt.Sprintf("  No matches for %q", m.searchQuery))}
+	}
+	if m.sourceFilter != FilterAll {
+		return []string{"", styleDim.Render(fmt.Sprintf("  No %s vars (0: show all)", strings.ToLower(m.sourceFilter.String())))}
+	}
+
+	var title string
+	switch {
+	case m.dbEmpty:
+		title = "Welcome to enva! Vars set here load automatically when you cd into this directory."
+	case m.viewMode == ViewLocal:
+		title = "No local variables in this directory."
+	default:
+		title = "No variables in this scope."
+	}
+
+	actions := []struct{ key, desc string }{
+		{"a", "Add a variable"},
+		{"i", "Import .env from this directory"},
+		{"A", "Paste KEY=value lines"},
+	}
+	if !m.hookInstalled {
+		actions = append(actions, struct{ key, desc string }{"H", "Install shell hook"})
+	}
+
+	lines := []string{"", "  " + styleModalTitle.Render(title), ""}
+	for _, a := range actions {
+		lines = append(lines, "    "+styleHelpKey.Render(fmt.Sprintf("%-3s", a.key))+styleHelpDesc.Render(a.desc))
+	}
+	return lines
+}
+
 func (m Model) getSourceText(v *env.ResolvedVar) string {
 	if v.DefinedAtPath == m.ctx.CwdReal {
 		if v.Overrode {
@@ -220,18 +399,13 @@ func (m Model) getSourceBadge(v *env.ResolvedVar) string {
 }
 
 func (m Model) renderHelpBar() string {
-	// Keybindings help
-	help := []struct{ key, desc string }{
-		{"Esc", "Quit"},
-		{"e", "Edit"},
-		{"a", "Add"},
-		{"x", "Delete"},
-		{"?", "Help"},
-	}
-
+	// Keybindings help, with each action's first key
 	var parts []string
-	for _, h := range help {
-		parts = append(parts, styleHelpKey.Render(h.key)+" "+styleDim.Render(h.desc))
+	for _, b := range m.keys.bindings {
+		if b.bar == "" || len(b.keys) == 0 {
+			continue
+		}
+		parts = append(parts, styleHelpKey.Render(displayKey(b.keys[0]))+" "+styleDim.Render(b.bar))
 	}
 	left := strings.Join(parts, "  ")
 
@@ -246,6 +420,17 @@ func (m Model) renderHelpBar() string {
 	} else {
 		right = styleDim.Render(fmt.Sprintf("Item %d of %d", m.cursor+1, len(m.results)))
 	}
+	// Keys typed so far, like vim's showcmd
+	var pending string
+	if m.count > 0 {
+		pending = fmt.Sprint(m.count)
+	}
+	if keys := m.keys.keysFor(m.markPending); len(keys) > 0 {
+		pending += displayKey(keys[0])
+	}
+	if pending != "" {
+		right = styleHelpKey.Render(pending) + "  " + right
+	}
 
 	padding := m.width - lipgloss.Width(left) - lipgloss.Width(right)
 	if padding < 1 {
@@ -322,6 +507,19 @@ func (m Model) renderEditModal() string {
 		content.WriteString(styleModalInput.Width(inputWidth).Render(descInput))
 	}
 
+	// Scope selector, new vars only
+	if m.editIsNew {
+		content.WriteString("\n")
+		content.WriteString(styleModalLabel.Render("Scope:"))
+		content.WriteString("\n")
+		scope := "‹ " + m.scopeLabel(m.editScope) + " ›"
+		if m.editFocus == FocusScope {
+			content.WriteString(styleModalInputFocused.Width(inputWidth).Render(scope))
+		} else {
+			content.WriteString(styleModalInput.Width(inputWidth).Render(scope))
+		}
+	}
+
 	// Error
 	if m.editError != "" {
 		content.WriteString("\n")
@@ -330,12 +528,31 @@ func (m Model) renderEditModal() string {
 
 	// Help
 	content.WriteString("\n")
-	content.WriteString(styleHelpDesc.Render("Tab: switch field  Ctrl+S: save  Esc: cancel"))
+	help := "Tab: switch field  Ctrl+S: save  Esc: cancel"
+	if m.editFocus == FocusScope {
+		help = "←/→: change scope  " + help
+	}
+	content.WriteString(styleHelpDesc.Render(help))
 
 	modal := styleModalBox.Width(modalWidth).Render(content.String())
 	return centerModal(modal, m.width, m.height)
 }
 
+// scopeLabel describes chain directory i for the scope selector.
+func (m Model) scopeLabel(i int) string {
+	if i < 0 || i >= len(m.ctx.Chain) {
+		return displayPath(m.ctx.CwdReal)
+	}
+	label := displayPath(m.ctx.Chain[i])
+	switch {
+	case i == len(m.ctx.Chain)-1:
+		label += " (here)"
+	case i == 0:
+		label += " (root)"
+	}
+	return label
+}
+
 func (m Model) renderBulkImportModal() string {
 	// Modal width - use most of screen, max 80
 	modalWidth := m.width - 20
@@ -365,7 +582,109 @@ func (m Model) renderBulkImportModal() string {
 	content.WriteString("\n")
 	content.WriteString(styleHelpDesc.Render("Formats: KEY=value, export KEY=value, # comments"))
 	content.WriteString("\n")
-	content.WriteString(styleHelpDesc.Render("Ctrl+S: import  Esc: cancel"))
+	content.WriteString(styleHelpDesc.Render("Ctrl+S: review  Esc: cancel"))
+
+	modal := styleModalBox.Width(modalWidth).Render(content.String())
+	return centerModal(modal, m.width, m.height)
+}
+
+func (m Model) renderImportPreviewModal() string {
+	modalWidth := m.width - 20
+	if modalWidth > 100 {
+		modalWidth = 100
+	}
+	if modalWidth < 50 {
+		modalWidth = 50
+	}
+
+	var added, updated, unchanged, selected int
+	for _, l := range m.importLines {
+		switch l.Status {
+		case ImportAdd:
+			added++
+		case ImportUpdate:
+			updated++
+		case ImportUnchanged:
+			unchanged++
+		}
+		if l.Enabled {
+			selected++
+		}
+	}
+
+	var content strings.Builder
+	content.WriteString(styleModalTitle.Render("Review Import"))
+	content.WriteString("\n")
+	content.WriteString(styleModalLabel.Render(fmt.Sprintf("%d to add, %d to update, %d unchanged (%d selected)", added, updated, unchanged, selected)))
+	content.WriteString("\n\n")
+	if m.importStatus != "" {
+		content.WriteString(styleError.Render(m.importStatus))
+		content.WriteString("\n\n")
+	}
+
+	for _, w := range m.importWarnings {
+		content.WriteString(styleConfirm.Render("⚠ " + w))
+		content.WriteString("\n")
+	}
+	if len(m.importWarnings) > 0 {
+		content.WriteString("\n")
+	}
+
+	maxLines := m.height - 12 - len(m.importWarnings)
+	if maxLines < 3 {
+		maxLines = 3
+	}
+	start := 0
+	if m.importCursor >= maxLines {
+		start = m.importCursor - maxLines + 1
+	}
+	end := start + maxLines
+	if end > len(m.importLines) {
+		end = len(m.importLines)
+	}
+
+	valWidth := (modalWidth - 40) / 2
+	if valWidth < 8 {
+		valWidth = 8
+	}
+
+	for i := start; i < end; i++ {
+		l := m.importLines[i]
+		check := "[ ]"
+		if l.Enabled {
+			check = "[x]"
+		}
+
+		var status, change string
+		switch l.Status {
+		case ImportAdd:
+			status = styleBadgeLocal.Render(fmt.Sprintf("%-9s", "add"))
+			change = truncate(singleLine(l.NewVal), valWidth)
+		case ImportUpdate:
+			status = styleBadgeOverride.Render(fmt.Sprintf("%-9s", "update"))
+			change = truncate(singleLine(l.OldVal), valWidth) + " → " + truncate(singleLine(l.NewVal), valWidth)
+		case ImportUnchanged:
+			status = styleDim.Render(fmt.Sprintf("%-9s", "unchanged"))
+			change = styleDim.Render(truncate(singleLine(l.NewVal), valWidth))
+		}
+
+		cursor := "  "
+		if i == m.importCursor {
+			cursor = styleCursor.Render("> ")
+		}
+		content.WriteString(fmt.Sprintf("%s%s %s %-20s %s", cursor, check, status, truncate(l.Key, 20), change))
+		if i < end-1 {
+			content.WriteString("\n")
+		}
+	}
+
+	if len(m.importLines) > maxLines {
+		content.WriteString("\n")
+		content.WriteString(styleHelpDesc.Render(fmt.Sprintf("(%d-%d of %d)", start+1, end, len(m.importLines))))
+	}
+
+	content.WriteString("\n\n")
+	content.WriteString(styleHelpDesc.Render("Space: toggle  a: toggle all  Enter: apply  Esc: back"))
 
 	modal := styleModalBox.Width(modalWidth).Render(content.String())
 	return centerModal(modal, m.width, m.height)
@@ -420,25 +739,24 @@ func (m Model) renderViewModal() string {
 	return centerModal(modal, m.width, m.height)
 }
 
-func (m Model) renderHelpModal() string {
-	bindings := []struct{ key, desc string }{
-		{"j/k, ↑/↓", "Navigate up/down"},
-		{"g/G", "Go to top/bottom"},
-		{"Ctrl+d/u", "Half page down/up"},
-		{"/", "Enter search mode"},
-		{"Esc", "Clear search / exit search"},
-		{"t", "Toggle view: Effective / Local"},
-		{"Enter, e", "Edit selected variable"},
-		{"a", "Add new variable"},
-		{"A", "Bulk import variables"},
-		{"v", "View full value"},
-		{"x", "Delete local variable"},
-		{"u", "Undo last action"},
-		{"y", "Copy KEY=value"},
-		{"Y", "Copy export line"},
-		{"?", "Show this help"},
-		{"q", "Quit"},
+// searchModeLabel names the search options that differ from the default,
+// such as "exact, keys".
+func (m Model) searchModeLabel() string {
+	var modes []string
+	if m.searchOpts.Exact {
+		modes = append(modes, "exact")
+	}
+	if m.searchOpts.CaseSensitive {
+		modes = append(modes, "Aa")
 	}
+	if m.searchOpts.KeysOnly {
+		modes = append(modes, "keys")
+	}
+	return strings.Join(modes, ", ")
+}
+
+func (m Model) renderHelpModal() string {
+	bindings := m.keys.bindings
 
 	// Calculate available lines for content
 	maxLines := m.height - 10 // Account for modal padding, title, footer
@@ -465,8 +783,8 @@ func (m Model) renderHelpModal() string {
 
 	for i := startIdx; i < endIdx; i++ {
 		b := bindings[i]
-		content.WriteString(styleHelpKey.Render(fmt.Sprintf("%-12s", b.key)))
-		content.WriteString(styleHelpDesc.Render(b.desc))
+		content.WriteString(styleHelpKey.Render(fmt.Sprintf("%-12s", displayKeys(b.keys))))
+		content.WriteString(styleHelpDesc.Render(b.help))
 		if i < endIdx-1 {
 			content.WriteString("\n")
 		}
@@ -485,16 +803,275 @@ func (m Model) renderHelpModal() string {
 	return centerModal(modal, m.width, m.height)
 }
 
-// getHelpBindingsCount returns the number of help bindings for scroll bounds
-func (m Model) getHelpBindingsCount() int {
-	return 16 // Number of bindings in renderHelpModal
+func (m Model) renderHookSetupModal() string {
+	rc := shell.RCFile(m.hookShell, "~")
+
+	var content strings.Builder
+	content.WriteString(styleModalTitle.Render("Install Shell Hook"))
+	content.WriteString("\n\n")
+	content.WriteString(styleModalLabel.Render("Shell: "))
+	content.WriteString(styleHelpKey.Render(m.hookShell))
+	content.WriteString("\n\n")
+	content.WriteString(styleModalLabel.Render("The hook loads vars automatically as you cd."))
+	content.WriteString("\n")
+	content.WriteString(styleModalLabel.Render(fmt.Sprintf("This line will be added to %s:", rc)))
+	content.WriteString("\n\n")
+	content.WriteString("  " + shell.HookLine(m.hookShell))
+
+	if m.hookError != "" {
+		content.WriteString("\n\n")
+		content.WriteString(styleError.Render(m.hookError))
+	}
+
+	content.WriteString("\n\n")
+	content.WriteString(styleHelpDesc.Render("Enter/y: install  Tab: switch shell  Esc: cancel"))
+
+	modal := styleModalBox.Render(content.String())
+	return centerModal(modal, m.width, m.height)
+}
+
+func (m Model) renderPaletteModal() string {
+	var content strings.Builder
+	content.WriteString(styleModalTitle.Render("Commands"))
+	content.WriteString("\n\n")
+	content.WriteString(styleModalInputFocused.Width(54).Render(m.paletteInput.View()))
+	content.WriteString("\n\n")
+
+	// Keep the cursor in a window that fits the screen
+	maxRows := m.height - 12
+	if maxRows < 3 {
+		maxRows = 3
+	}
+	start := 0
+	if m.paletteCursor >= maxRows {
+		start = m.paletteCursor - maxRows + 1
+	}
+	end := start + maxRows
+	if end > len(m.paletteMatches) {
+		end = len(m.paletteMatches)
+	}
+
+	if len(m.paletteMatches) == 0 {
+		content.WriteString(styleDim.Render("  No matching commands"))
+	}
+	for i := start; i < end; i++ {
+		c := m.paletteMatches[i]
+		line := fmt.Sprintf("%-46s", c.title)
+		if i == m.paletteCursor {
+			content.WriteString(styleHelpKey.Render("> "+line) + styleDim.Render(c.key))
+		} else {
+			content.WriteString("  " + line + styleDim.Render(c.key))
+		}
+		if i < end-1 {
+			content.WriteString("\n")
+		}
+	}
+
+	content.WriteString("\n\n")
+	content.WriteString(styleHelpDesc.Render("↑/↓: choose  Enter: run  Esc: close"))
+
+	modal := styleModalBox.Render(content.String())
+	return centerModal(modal, m.width, m.height)
 }
 
-func (m Model) renderDeleteConfirmModal() string {
+func (m Model) renderCopyProfileModal() string {
+	verb := "Copy"
+	if m.profileMove {
+		verb = "Move"
+	}
+
 	var content strings.Builder
-	content.WriteString(styleConfirm.Render(fmt.Sprintf("Delete %s?", m.deleteKey)))
+	content.WriteString(styleModalTitle.Render(fmt.Sprintf("%s %s to profile", verb, m.profileKey)))
+	content.WriteString("\n\n")
+	content.WriteString(styleModalLabel.Render("From: "))
+	content.WriteString(m.resolver.GetProfile() + styleDim.Render(" at "+displayPath(m.profilePath)))
 	content.WriteString("\n\n")
-	content.WriteString(styleHelpDesc.Render("y: confirm  n/Esc: cancel"))
+
+	for i, p := range m.profileChoices {
+		if i == m.profileCursor {
+			content.WriteString(styleHelpKey.Render("> " + p))
+		} else {
+			content.WriteString("  " + p)
+		}
+		content.WriteString("\n")
+	}
+	prefix := "  "
+	if m.profileCursor == len(m.profileChoices) {
+		prefix = styleHelpKey.Render("> ")
+	}
+	content.WriteString(prefix + m.profileInput.View())
+
+	if m.profileError != "" {
+		content.WriteString("\n\n")
+		content.WriteString(styleError.Render(m.profileError))
+	}
+
+	content.WriteString("\n\n")
+	content.WriteString(styleHelpDesc.Render("↑/↓: choose  Tab: copy/move  Enter: " + strings.ToLower(verb) + "  Esc: cancel"))
+
+	modal := styleModalBox.Render(content.String())
+	return centerModal(modal, m.width, m.height)
+}
+
+func (m Model) renderUnlockModal() string {
+	var content strings.Builder
+	content.WriteString(styleModalTitle.Render("Unlock " + m.unlockProfile))
+	content.WriteString("\n\n")
+	content.WriteString(styleDim.Render("This profile is protected. Enter its passphrase to switch to it."))
+	content.WriteString("\n\n")
+	content.WriteString(styleModalLabel.Render("Passphrase: "))
+	content.WriteString(m.unlockInput.View())
+
+	if m.unlockError != "" {
+		content.WriteString("\n\n")
+		content.WriteString(styleError.Render(m.unlockError))
+	}
+
+	content.WriteString("\n\n")
+	content.WriteString(styleHelpDesc.Render("Enter: unlock  Esc: cancel"))
+
+	modal := styleModalBox.Render(content.String())
+	return centerModal(modal, m.width, m.height)
+}
+
+func (m Model) renderTrashModal() string {
+	var content strings.Builder
+	content.WriteString(styleModalTitle.Render("Trash"))
+	content.WriteString("\n\n")
+
+	// Keep the cursor in a window that fits the screen
+	maxRows := m.height - 12
+	if maxRows < 3 {
+		maxRows = 3
+	}
+	start := 0
+	if m.trashCursor >= maxRows {
+		start = m.trashCursor - maxRows + 1
+	}
+	end := min(start+maxRows, len(m.trashItems))
+
+	if len(m.trashItems) == 0 {
+		content.WriteString(styleDim.Render("  Nothing deleted in this profile"))
+	}
+	for i := start; i < end; i++ {
+		t := m.trashItems[i]
+		line := fmt.Sprintf("%-28s", t.Key)
+		detail := displayPath(t.Path) + ", " + t.DeletedAt.Local().Format("2006-01-02 15:04")
+		if i == m.trashCursor {
+			content.WriteString(styleHelpKey.Render("> "+line) + styleDim.Render(detail))
+		} else {
+			content.WriteString("  " + line + styleDim.Render(detail))
+		}
+		if i < end-1 {
+			content.WriteString("\n")
+		}
+	}
+
+	if m.trashError != "" {
+		content.WriteString("\n\n")
+		content.WriteString(styleError.Render(m.trashError))
+	}
+
+	content.WriteString("\n\n")
+	content.WriteString(styleHelpDesc.Render("↑/↓: choose  Enter: restore  Esc: close"))
+
+	modal := styleModalBox.Render(content.String())
+	return centerModal(modal, m.width, m.height)
+}
+
+func (m Model) renderTasksModal() string {
+	var content strings.Builder
+	content.WriteString(styleModalTitle.Render("Tasks"))
+	content.WriteString("\n\n")
+
+	if m.taskEditing {
+		title := "New task at " + displayPath(m.ctx.CwdReal)
+		if m.taskOrig != "" {
+			title = "Edit " + m.taskOrig + " (saved at " + displayPath(m.ctx.CwdReal) + ")"
+		}
+		content.WriteString(styleDim.Render(title))
+		content.WriteString("\n\n")
+		content.WriteString(taskInput("Name:   ", m.taskNameInput))
+		content.WriteString("\n")
+		content.WriteString(taskInput("Command:", m.taskCmdInput))
+		if m.taskError != "" {
+			content.WriteString("\n\n")
+			content.WriteString(styleError.Render(m.taskError))
+		}
+		content.WriteString("\n\n")
+		content.WriteString(styleHelpDesc.Render("Tab: switch field  Enter: save  Esc: back"))
+		return centerModal(styleModalBox.Render(content.String()), m.width, m.height)
+	}
+
+	// Keep the cursor in a window that fits the screen
+	maxRows := m.height - 12
+	if maxRows < 3 {
+		maxRows = 3
+	}
+	start := 0
+	if m.taskCursor >= maxRows {
+		start = m.taskCursor - maxRows + 1
+	}
+	end := min(start+maxRows, len(m.taskItems))
+
+	if len(m.taskItems) == 0 {
+		content.WriteString(styleDim.Render("  No tasks here; press a to add one"))
+	}
+	// Name, command and scope share the modal's inner width
+	inner := max(m.width-12, 40)
+	pathWidth := inner / 4
+	cmdWidth := inner - 17 - pathWidth - 1
+	for i := start; i < end; i++ {
+		t := m.taskItems[i]
+		line := fmt.Sprintf("%-16s %-*s ", truncate(t.Name, 16), cmdWidth, truncate(singleLine(t.Command), cmdWidth))
+		detail := truncate(displayPath(t.DefinedAtPath), pathWidth)
+		if i == m.taskCursor {
+			content.WriteString(styleHelpKey.Render("> "+line) + styleDim.Render(detail))
+		} else {
+			content.WriteString("  " + line + styleDim.Render(detail))
+		}
+		if i < end-1 {
+			content.WriteString("\n")
+		}
+	}
+
+	if m.taskError != "" {
+		content.WriteString("\n\n")
+		content.WriteString(styleError.Render(m.taskError))
+	}
+
+	content.WriteString("\n\n")
+	content.WriteString(styleHelpDesc.Render("a: add  Enter/e: edit  x: delete  Esc: close  ·  run with: enva run NAME"))
+
+	modal := styleModalBox.Render(content.String())
+	return centerModal(modal, m.width, m.height)
+}
+
+// taskInput renders a task form field, marking the focused one.
+func taskInput(label string, input textinput.Model) string {
+	prefix := "  "
+	if input.Focused() {
+		prefix = styleHelpKey.Render("> ")
+	}
+	return prefix + styleModalLabel.Render(label) + " " + input.View()
+}
+
+func (m Model) renderDeleteConfirmModal() string {
+	var content strings.Builder
+	if m.deletePath != "" && m.deletePath != m.ctx.CwdReal {
+		content.WriteString(styleConfirm.Render(fmt.Sprintf("%s is inherited", m.deleteKey)))
+		content.WriteString("\n\n")
+		content.WriteString(styleModalLabel.Render("Defined at: "))
+		content.WriteString(displayPath(m.deletePath))
+		content.WriteString("\n")
+		content.WriteString(styleModalLabel.Render("Deleting it there affects every directory below that path."))
+		content.WriteString("\n\n")
+		content.WriteString(styleHelpDesc.Render("y: delete at source  o: empty local override  n/Esc: cancel"))
+	} else {
+		content.WriteString(styleConfirm.Render(fmt.Sprintf("Delete %s?", m.deleteKey)))
+		content.WriteString("\n\n")
+		content.WriteString(styleHelpDesc.Render("y: confirm  n/Esc: cancel"))
+	}
 
 	modal := styleModalBox.Render(content.String())
 	return centerModal(modal, m.width, m.height)
@@ -532,6 +1109,37 @@ func centerModal(modal string, width, height int) string {
 	return result.String()
 }
 
+// displayPath abbreviates the home directory as ~.
+func displayPath(p string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return p
+	}
+	if p == home {
+		return "~"
+	}
+	if strings.HasPrefix(p, home+string(filepath.Separator)) {
+		return "~" + p[len(home):]
+	}
+	return p
+}
+
+// formatDate returns t's local date, or "-" for an unknown time.
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02")
+}
+
+// truncateStyled cuts a styled string to the given display width.
+func truncateStyled(s string, width int) string {
+	if width <= 0 || lipgloss.Width(s) <= width {
+		return s
+	}
+	return lipgloss.NewStyle().MaxWidth(width).Render(s)
+}
+
 func truncate(s string, maxLen int) string {
 	runes := []rune(s)
 	if len(runes) <= maxLen {
@@ -543,6 +1151,15 @@ func truncate(s string, maxLen int) string {
 	return string(runes[:maxLen-3]) + "..."
 }
 
+// shownValue is v's value as the table and preview show it: masked if it
+// looks sensitive, until the user reveals secrets with s.
+func (m Model) shownValue(v *env.ResolvedVar) string {
+	if !m.revealSecrets && secrets.IsSensitive(v.Key, v.Value) {
+		return secrets.Mask(v.Value)
+	}
+	return v.Value
+}
+
 func singleLine(s string) string {
 	s = strings.ReplaceAll(s, "\n", "\\n")
 	s = strings.ReplaceAll(s, "\r", "\\r")
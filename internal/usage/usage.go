@@ -0,0 +1,180 @@
+// Package usage keeps an opt-in, purely local log of enva invocations and
+// summarizes it for performance debugging. Nothing is ever sent anywhere.
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxLogSize is the size at which the log is rotated to a single .1 backup.
+const maxLogSize = 1 << 20
+
+// Entry is one logged command invocation.
+type Entry struct {
+	Time     time.Time     `json:"time"`
+	Command  string        `json:"command"`
+	Dir      string        `json:"dir,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Scopes   int           `json:"scopes,omitempty"` // Directories in the resolved chain
+	Vars     int           `json:"vars,omitempty"`   // Effective vars after resolution
+}
+
+// Append adds an entry to the log at path, rotating it when it grows large.
+func Append(path string, e Entry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > maxLogSize {
+		os.Rename(path, path+".1")
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns all entries in the log at path. A missing log is empty.
+// Lines that fail to parse are skipped.
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// CommandStats aggregates durations for one command.
+type CommandStats struct {
+	Command string
+	Count   int
+	Total   time.Duration
+	Max     time.Duration
+}
+
+// Avg returns the mean duration.
+func (s CommandStats) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// ScopeStats describes the largest resolution seen for a directory.
+type ScopeStats struct {
+	Dir    string
+	Scopes int
+	Vars   int
+}
+
+// Report summarizes a usage log.
+type Report struct {
+	Entries  int
+	Since    time.Time
+	Commands []CommandStats // Sorted by total time, descending
+	Slowest  []Entry        // Slowest single invocations
+	Biggest  []ScopeStats   // Directories with the most effective vars
+}
+
+// Summarize builds a report, keeping at most top slowest entries and
+// biggest scopes.
+func Summarize(entries []Entry, top int) Report {
+	r := Report{Entries: len(entries)}
+	if len(entries) == 0 {
+		return r
+	}
+
+	byCommand := make(map[string]*CommandStats)
+	byDir := make(map[string]*ScopeStats)
+	r.Since = entries[0].Time
+	for _, e := range entries {
+		if e.Time.Before(r.Since) {
+			r.Since = e.Time
+		}
+
+		cs := byCommand[e.Command]
+		if cs == nil {
+			cs = &CommandStats{Command: e.Command}
+			byCommand[e.Command] = cs
+		}
+		cs.Count++
+		cs.Total += e.Duration
+		if e.Duration > cs.Max {
+			cs.Max = e.Duration
+		}
+
+		if e.Dir != "" && (e.Vars > 0 || e.Scopes > 0) {
+			ss := byDir[e.Dir]
+			if ss == nil {
+				ss = &ScopeStats{Dir: e.Dir}
+				byDir[e.Dir] = ss
+			}
+			ss.Scopes = max(ss.Scopes, e.Scopes)
+			ss.Vars = max(ss.Vars, e.Vars)
+		}
+	}
+
+	for _, cs := range byCommand {
+		r.Commands = append(r.Commands, *cs)
+	}
+	sort.Slice(r.Commands, func(i, j int) bool {
+		if r.Commands[i].Total != r.Commands[j].Total {
+			return r.Commands[i].Total > r.Commands[j].Total
+		}
+		return r.Commands[i].Command < r.Commands[j].Command
+	})
+
+	r.Slowest = append([]Entry(nil), entries...)
+	sort.SliceStable(r.Slowest, func(i, j int) bool {
+		return r.Slowest[i].Duration > r.Slowest[j].Duration
+	})
+	if len(r.Slowest) > top {
+		r.Slowest = r.Slowest[:top]
+	}
+
+	for _, ss := range byDir {
+		r.Biggest = append(r.Biggest, *ss)
+	}
+	sort.Slice(r.Biggest, func(i, j int) bool {
+		if r.Biggest[i].Vars != r.Biggest[j].Vars {
+			return r.Biggest[i].Vars > r.Biggest[j].Vars
+		}
+		return r.Biggest[i].Dir < r.Biggest[j].Dir
+	})
+	if len(r.Biggest) > top {
+		r.Biggest = r.Biggest[:top]
+	}
+
+	return r
+}
@@ -0,0 +1,76 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppendAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "usage.log")
+
+	entries, err := Read(path)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("Read on missing log = %v, %v", entries, err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	Append(path, Entry{Time: now, Command: "export", Dir: "/p", Duration: 5 * time.Millisecond, Scopes: 3, Vars: 10})
+	Append(path, Entry{Time: now, Command: "ls", Duration: time.Millisecond})
+
+	// Garbage lines are skipped
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString("not json\n")
+	f.Close()
+
+	entries, err = Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Read returned %d entries, want 2", len(entries))
+	}
+	if e := entries[0]; e.Command != "export" || e.Vars != 10 || e.Duration != 5*time.Millisecond || !e.Time.Equal(now) {
+		t.Errorf("entries[0] = %+v", e)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Time: base.Add(time.Hour), Command: "export", Dir: "/a", Duration: 10 * time.Millisecond, Scopes: 2, Vars: 5},
+		{Time: base, Command: "export", Dir: "/b", Duration: 30 * time.Millisecond, Scopes: 4, Vars: 50},
+		{Time: base, Command: "ls", Dir: "/a", Duration: 5 * time.Millisecond, Scopes: 2, Vars: 7},
+		{Time: base, Command: "set", Duration: 100 * time.Millisecond},
+	}
+
+	r := Summarize(entries, 2)
+
+	if r.Entries != 4 || !r.Since.Equal(base) {
+		t.Errorf("Entries/Since = %d/%v", r.Entries, r.Since)
+	}
+
+	if len(r.Commands) != 3 || r.Commands[0].Command != "set" {
+		t.Fatalf("Commands = %+v", r.Commands)
+	}
+	exp := r.Commands[1]
+	if exp.Command != "export" || exp.Count != 2 || exp.Max != 30*time.Millisecond || exp.Avg() != 20*time.Millisecond {
+		t.Errorf("export stats = %+v", exp)
+	}
+
+	if len(r.Slowest) != 2 || r.Slowest[0].Command != "set" || r.Slowest[1].Dir != "/b" {
+		t.Errorf("Slowest = %+v", r.Slowest)
+	}
+
+	if len(r.Biggest) != 2 || r.Biggest[0].Dir != "/b" || r.Biggest[1].Vars != 7 {
+		t.Errorf("Biggest = %+v", r.Biggest)
+	}
+}
+
+func TestSummarizeEmpty(t *testing.T) {
+	r := Summarize(nil, 5)
+	if r.Entries != 0 || len(r.Commands) != 0 {
+		t.Errorf("Summarize(nil) = %+v", r)
+	}
+}
@@ -0,0 +1,211 @@
+// Package valuetype validates and normalizes values declared as json, int
+// or bool, and extracts fields from JSON values with a jq-style path.
+package valuetype
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Value types. String, the zero value, accepts anything.
+const (
+	String = ""
+	JSON   = "json"
+	Int    = "int"
+	Bool   = "bool"
+)
+
+// Parse returns the type named name; "string" is String.
+func Parse(name string) (string, error) {
+	switch name {
+	case "", "string":
+		return String, nil
+	case JSON, Int, Bool:
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown type %q: use string, json, int or bool", name)
+}
+
+// Normalize checks value is a valid t and returns it in canonical form:
+// JSON indented by two spaces, integers without padding or a + sign,
+// and booleans as true or false.
+func Normalize(t, value string) (string, error) {
+	switch t {
+	case JSON:
+		var out bytes.Buffer
+		if err := json.Indent(&out, []byte(strings.TrimSpace(value)), "", "  "); err != nil {
+			return "", jsonError(err)
+		}
+		return out.String(), nil
+	case Int:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("not an integer")
+		}
+		return strconv.FormatInt(n, 10), nil
+	case Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("not a boolean (true or false)")
+		}
+		return strconv.FormatBool(b), nil
+	}
+	return value, nil
+}
+
+// Inline returns value on one line when t is JSON, for formats such as
+// .env files where a line is a var. Other values are returned as they are.
+func Inline(t, value string) string {
+	if t != JSON {
+		return value
+	}
+	var out bytes.Buffer
+	if err := json.Compact(&out, []byte(value)); err != nil {
+		return value
+	}
+	return out.String()
+}
+
+// Extract returns the part of the JSON value at path, a jq-style path such
+// as .db.hosts[0] or ."key with spaces" (. is the whole value). Strings
+// are returned raw, anything else as compact JSON. Numbers keep their
+// digits, so large integers aren't rounded through float64.
+func Extract(value, path string) (string, error) {
+	var doc any
+	dec := json.NewDecoder(strings.NewReader(value))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return "", jsonError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return "", fmt.Errorf("not valid JSON (at byte %d)", dec.InputOffset())
+	}
+	steps, err := parsePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	cur := doc
+	for _, s := range steps {
+		switch node := cur.(type) {
+		case map[string]any:
+			if s.field == nil {
+				return "", fmt.Errorf("%s: can't index an object with a number", path)
+			}
+			cur = node[*s.field]
+		case []any:
+			if s.field != nil {
+				return "", fmt.Errorf("%s: can't index an array with %q", path, *s.field)
+			}
+			i := s.index
+			if i < 0 {
+				i += len(node)
+			}
+			if i < 0 || i >= len(node) {
+				cur = nil
+			} else {
+				cur = node[i]
+			}
+		case nil:
+			// Like jq, indexing null yields null
+		case json.Number:
+			return "", fmt.Errorf("%s: can't index a number", path)
+		default:
+			return "", fmt.Errorf("%s: can't index a %T", path, node)
+		}
+	}
+
+	if s, ok := cur.(string); ok {
+		return s, nil
+	}
+	out, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// jsonError describes why a value isn't JSON by position only, since the
+// decoder's own message quotes part of the value.
+func jsonError(err error) error {
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		return fmt.Errorf("not valid JSON (at byte %d)", syntax.Offset)
+	}
+	return errors.New("not valid JSON")
+}
+
+// step is one path element: an object field or an array index.
+type step struct {
+	field *string
+	index int
+}
+
+// parsePath splits a jq-style path into steps.
+func parsePath(path string) ([]step, error) {
+	if !strings.HasPrefix(path, ".") {
+		return nil, fmt.Errorf("invalid path %q: must start with .", path)
+	}
+	var steps []step
+	rest := path
+	for rest != "" && rest != "." {
+		switch {
+		case strings.HasPrefix(rest, "[") || strings.HasPrefix(rest, ".["):
+			rest = strings.TrimPrefix(strings.TrimPrefix(rest, "."), "[")
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unclosed [", path)
+			}
+			inner := rest[:end]
+			rest = rest[end+1:]
+			if strings.HasPrefix(inner, `"`) {
+				name, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q: bad field %s", path, inner)
+				}
+				steps = append(steps, step{field: &name})
+				continue
+			}
+			i, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: bad index [%s]", path, inner)
+			}
+			steps = append(steps, step{index: i})
+		case strings.HasPrefix(rest, `."`):
+			rest = rest[1:]
+			end := 1
+			for end < len(rest) && (rest[end] != '"' || rest[end-1] == '\\') {
+				end++
+			}
+			if end == len(rest) {
+				return nil, fmt.Errorf("invalid path %q: unclosed quote", path)
+			}
+			name, err := strconv.Unquote(rest[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: bad field %s", path, rest[:end+1])
+			}
+			rest = rest[end+1:]
+			steps = append(steps, step{field: &name})
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, fmt.Errorf("invalid path %q: empty field name", path)
+			}
+			rest = rest[end:]
+			steps = append(steps, step{field: &name})
+		default:
+			return nil, fmt.Errorf("invalid path %q: unexpected %q", path, rest)
+		}
+	}
+	return steps, nil
+}
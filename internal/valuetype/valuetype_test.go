@@ -0,0 +1,100 @@
+package valuetype
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		typ, in, want string
+		ok            bool
+	}{
+		{JSON, ` {"a":1,"b":[true]} `, "{\n  \"a\": 1,\n  \"b\": [\n    true\n  ]\n}", true},
+		{JSON, `{"a":`, "", false},
+		{Int, " 042 ", "42", true},
+		{Int, "-7", "-7", true},
+		{Int, "4.2", "", false},
+		{Bool, "1", "true", true},
+		{Bool, "FALSE", "false", true},
+		{Bool, "yes", "", false},
+		{String, " anything ", " anything ", true},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.typ, tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("Normalize(%q, %q) = %q, %v", tt.typ, tt.in, got, err)
+		}
+	}
+}
+
+func TestErrorsHideValue(t *testing.T) {
+	for _, tt := range []struct{ typ, in string }{{Int, "hunter22"}, {Bool, "hunter22"}, {JSON, "hunter22"}} {
+		if _, err := Normalize(tt.typ, tt.in); err == nil || strings.Contains(err.Error(), "hunter") || strings.Contains(err.Error(), "'h'") {
+			t.Errorf("Normalize(%q) error = %v", tt.typ, err)
+		}
+	}
+	if _, err := Extract("hunter22", "."); err == nil || strings.Contains(err.Error(), "'h'") {
+		t.Errorf("Extract error = %v", err)
+	}
+}
+
+func TestInline(t *testing.T) {
+	pretty, _ := Normalize(JSON, `{"a":[1,2]}`)
+	if got := Inline(JSON, pretty); got != `{"a":[1,2]}` {
+		t.Errorf("Inline(JSON) = %q", got)
+	}
+	if got := Inline(String, "a\nb"); got != "a\nb" {
+		t.Errorf("Inline(String) = %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if ty, err := Parse("string"); err != nil || ty != String {
+		t.Errorf("Parse(string) = %q, %v", ty, err)
+	}
+	if ty, err := Parse("json"); err != nil || ty != JSON {
+		t.Errorf("Parse(json) = %q, %v", ty, err)
+	}
+	if _, err := Parse("yaml"); err == nil {
+		t.Error("Parse(yaml) should fail")
+	}
+}
+
+func TestExtract(t *testing.T) {
+	doc := `{"db":{"host":"localhost","ports":[5432,5433]},"with space":{"x":true},"n":null}`
+	tests := []struct{ path, want string }{
+		{".", `{"db":{"host":"localhost","ports":[5432,5433]},"n":null,"with space":{"x":true}}`},
+		{".db.host", "localhost"},
+		{".db.ports[0]", "5432"},
+		{".db.ports.[1]", "5433"},
+		{".db.ports[-1]", "5433"},
+		{".db.ports[9]", "null"},
+		{`."with space".x`, "true"},
+		{`.["with space"]`, `{"x":true}`},
+		{".missing.deeper", "null"},
+		{".db", `{"host":"localhost","ports":[5432,5433]}`},
+	}
+	for _, tt := range tests {
+		if got, err := Extract(doc, tt.path); err != nil || got != tt.want {
+			t.Errorf("Extract(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"db", ".db[", ".db.host.x", ".db.ports.x", ".db[0]", `."open`, ".."} {
+		if _, err := Extract(doc, bad); err == nil {
+			t.Errorf("Extract(%q) should fail", bad)
+		}
+	}
+	for _, bad := range []string{"not json", `{"a":1} x`, `{"a":1}}`, ""} {
+		if _, err := Extract(bad, "."); err == nil {
+			t.Errorf("Extract of %q should fail", bad)
+		}
+	}
+
+	big := `{"id":9007199254740993,"ids":[12345678901234567890],"f":1.50}`
+	for path, want := range map[string]string{".id": "9007199254740993", ".ids[0]": "12345678901234567890", ".f": "1.50"} {
+		if got, err := Extract(big, path); err != nil || got != want {
+			t.Errorf("Extract(%q) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Package enva is the public Go API for embedding enva resolution in other tools.
+//
+// It exposes the same per-directory, inherited environment that the enva CLI
+// loads into the shell, without shelling out and parsing export output:
+//
+//	client, err := enva.Open(enva.Options{})
+//	if err != nil {
+//		return err
+//	}
+//	defer client.Close()
+//
+//	e, err := client.Resolve("/path/to/project")
+//	if err != nil {
+//		return err
+//	}
+//	cmd.Env = append(os.Environ(), e.Environ()...)
+package enva
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nick-skriabin/enva/internal/db"
+	"github.com/nick-skriabin/enva/internal/env"
+	envpath "github.com/nick-skriabin/enva/internal/path"
+	"github.com/nick-skriabin/enva/internal/redact"
+	"github.com/nick-skriabin/enva/internal/shell"
+)
+
+// DefaultProfile is the profile used when none is configured.
+const DefaultProfile = env.DefaultProfile
+
+// Options configures Open.
+type Options struct {
+	// DBPath is the SQLite database path. Empty means $ENVA_DB or the CLI default.
+	DBPath string
+	// Profile is the active profile. Empty means $ENVA_PROFILE or "default".
+	Profile string
+	// Author is recorded as who set each var written through the client.
+	// Empty means user@host.
+	Author string
+	// MaxChainDepth skips directories more than this many levels below the
+	// project root. Zero means no limit.
+	MaxChainDepth int
+	// ScopedChain resolves only through directories that have vars.
+	ScopedChain bool
+	// ReadEnvFiles also reads .env and .env.local in each directory,
+	// below the vars stored there.
+	ReadEnvFiles bool
+}
+
+// Var is a resolved environment variable with provenance.
+type Var struct {
+	Key         string
+	Value       string
+	Description string
+	// DefinedAt is the directory that defines the winning value.
+	DefinedAt string
+	// Overrides is the ancestor directory whose value this one shadows, if any.
+	Overrides string
+	// IfUnset marks a default that should not replace a value already
+	// present in the ambient environment.
+	IfUnset bool
+	// Eval marks a value that is a shell command rather than a literal.
+	// Value holds the command; running it is left to the caller, so Map
+	// and Environ leave it out.
+	Eval bool
+	// Refresh is how many seconds an Eval command's output may be reused;
+	// zero means enva's default.
+	Refresh int
+	// NoExport marks a var meant to be read, not passed to processes;
+	// Environ leaves it out.
+	NoExport bool
+	// Fallback is what an Eval var exports when its command fails, as
+	// set with enva set --fallback; empty means it isn't exported.
+	Fallback string
+	// Type is the value's declared type: "json", "int", "bool", or
+	// empty for a plain string.
+	Type string
+	// Author is who last set the var (user@host), if recorded.
+	Author string
+	// File is the .env file the value was read from, with ReadEnvFiles;
+	// empty for stored vars.
+	File string
+}
+
+// String names the var with its value redacted, so logging a Var
+// doesn't print a secret. Read Value for the value.
+func (v Var) String() string {
+	return fmt.Sprintf("%s=%v (%s)", v.Key, redact.Ref(v.Value), v.DefinedAt)
+}
+
+// Env is the effective environment for a directory.
+type Env struct {
+	Dir     string   // Canonical directory that was resolved
+	Root    string   // Project root boundary
+	Chain   []string // Directories from Root to Dir, in merge order
+	Profile string
+	Vars    []Var // Sorted by key
+	// Clear lists keys that must be removed from the ambient environment.
+	Clear []string
+}
+
+// Map returns the environment as a key/value map. Eval vars are left out,
+// since their Value is a command, not the value.
+func (e *Env) Map() map[string]string {
+	m := make(map[string]string, len(e.Vars))
+	for _, v := range e.Vars {
+		if v.Eval {
+			continue
+		}
+		m[v.Key] = v.Value
+	}
+	return m
+}
+
+// Environ returns the environment as KEY=value strings, suitable for exec.Cmd.Env.
+// Vars marked NoExport are left out, as are Eval vars, whose Value is a
+// command. Callers merging it with os.Environ should drop the keys in Clear.
+func (e *Env) Environ() []string {
+	out := make([]string, 0, len(e.Vars))
+	for _, v := range e.Vars {
+		if v.NoExport || v.Eval {
+			continue
+		}
+		out = append(out, v.Key+"="+v.Value)
+	}
+	return out
+}
+
+// Get returns the value of key and whether it is set.
+func (e *Env) Get(key string) (string, bool) {
+	for _, v := range e.Vars {
+		if v.Key == key {
+			return v.Value, true
+		}
+	}
+	return "", false
+}
+
+// Client is a handle to an enva database.
+type Client struct {
+	db       db.Store
+	resolver *env.Resolver
+}
+
+// Open opens the enva database described by opts.
+func Open(opts Options) (*Client, error) {
+	dbPath, err := db.ResolveDBPath(opts.DBPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database path: %w", err)
+	}
+
+	profile := opts.Profile
+	if profile == "" {
+		profile = env.GetProfileFromEnv()
+	}
+
+	database, err := db.Open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	author := opts.Author
+	if author == "" {
+		author = db.DefaultAuthor()
+	}
+	database.SetAuthor(author)
+
+	resolver := env.NewResolver(database, profile)
+	resolver.SetChainOptions(env.ChainOptions{MaxDepth: opts.MaxChainDepth, ScopedOnly: opts.ScopedChain})
+	if opts.ReadEnvFiles {
+		resolver.SetEnvFiles(shell.ReadEnvFiles)
+	}
+
+	return &Client{db: database, resolver: resolver}, nil
+}
+
+// Close closes the database connection.
+func (c *Client) Close() error {
+	return c.db.Close()
+}
+
+// Profile returns the active profile.
+func (c *Client) Profile() string {
+	return c.resolver.GetProfile()
+}
+
+// Resolve returns the effective environment for dir.
+func (c *Client) Resolve(dir string) (*Env, error) {
+	ctx, err := c.resolver.Resolve(dir)
+	if err != nil {
+		return nil, err
+	}
+	return newEnv(ctx), nil
+}
+
+// Set sets key at dir's scope.
+func (c *Client) Set(dir, key, value, description string) error {
+	if !shell.IsValidKey(key) {
+		return fmt.Errorf("invalid key %q: must match [A-Za-z_][A-Za-z0-9_]*", key)
+	}
+	return c.resolver.SetVar(dir, key, value, description)
+}
+
+// Delete removes key from dir's scope. Inherited values are not affected.
+func (c *Client) Delete(dir, key string) error {
+	return c.resolver.DeleteVar(dir, key)
+}
+
+// Watch polls dir's effective environment every interval and sends it on the
+// returned channel whenever it changes. The current environment is sent first.
+// The channel is closed when ctx is done. Resolution errors after the initial
+// resolve are retried on the next tick.
+func (c *Client) Watch(ctx context.Context, dir string, interval time.Duration) (<-chan *Env, error) {
+	current, err := c.Resolve(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *Env, 1)
+	ch <- current
+
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Pick up symlinks retargeted since the last tick
+				envpath.ResetCache()
+				next, err := c.Resolve(dir)
+				if err != nil || (equalVars(current.Vars, next.Vars) && equalStrings(current.Clear, next.Clear)) {
+					continue
+				}
+				current = next
+				select {
+				case ch <- next:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// newEnv converts an internal resolve context to the public type.
+func newEnv(ctx *env.ResolveContext) *Env {
+	e := &Env{
+		Dir:     ctx.CwdReal,
+		Root:    ctx.RootDir,
+		Chain:   append([]string(nil), ctx.Chain...),
+		Profile: ctx.Profile,
+		Clear:   append([]string(nil), ctx.Cleared...),
+	}
+	for _, v := range ctx.GetSortedVars() {
+		e.Vars = append(e.Vars, Var{
+			Key:         v.Key,
+			Value:       v.Value,
+			Description: v.Description,
+			DefinedAt:   v.DefinedAtPath,
+			Overrides:   v.OverrodePath,
+			IfUnset:     v.IfUnset,
+			Eval:        v.Eval,
+			Refresh:     v.Refresh,
+			NoExport:    v.NoExport,
+			Fallback:    v.Fallback,
+			Type:        v.Type,
+			File:        v.File,
+			Author:      v.Author,
+		})
+	}
+	return e
+}
+
+// equalVars reports whether two sorted var lists are identical.
+func equalVars(a, b []Var) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalStrings reports whether two string slices are identical.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
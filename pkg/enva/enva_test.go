@@ -0,0 +1,154 @@
+package enva
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setupTestClient(t *testing.T) (*Client, string) {
+	t.Helper()
+
+	tmpDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+
+	client, err := Open(Options{DBPath: filepath.Join(tmpDir, "test.db"), Profile: "default"})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	// Mark a project root so resolution stays inside the temp dir
+	if err := os.WriteFile(filepath.Join(tmpDir, ".enva"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create marker: %v", err)
+	}
+
+	return client, tmpDir
+}
+
+func TestResolveInheritance(t *testing.T) {
+	client, root := setupTestClient(t)
+
+	child := filepath.Join(root, "child")
+	os.MkdirAll(child, 0755)
+
+	if err := client.Set(root, "SHARED", "parent", ""); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := client.Set(child, "SHARED", "child", "overridden"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := client.Set(root, "ONLY_PARENT", "p", ""); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	e, err := client.Resolve(child)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	if e.Root != root {
+		t.Errorf("Root = %q, want %q", e.Root, root)
+	}
+	if len(e.Chain) != 2 {
+		t.Errorf("Chain length = %d, want 2", len(e.Chain))
+	}
+
+	m := e.Map()
+	if m["SHARED"] != "child" || m["ONLY_PARENT"] != "p" {
+		t.Errorf("Map() = %v", m)
+	}
+
+	for _, v := range e.Vars {
+		if v.Key == "SHARED" {
+			if v.DefinedAt != child || v.Overrides != root {
+				t.Errorf("SHARED provenance = %q overrides %q", v.DefinedAt, v.Overrides)
+			}
+			if v.Description != "overridden" {
+				t.Errorf("SHARED description = %q", v.Description)
+			}
+		}
+	}
+
+	environ := e.Environ()
+	if len(environ) != 2 || environ[0] != "ONLY_PARENT=p" || environ[1] != "SHARED=child" {
+		t.Errorf("Environ() = %v", environ)
+	}
+}
+
+func TestEnvironSkipsEval(t *testing.T) {
+	e := &Env{Vars: []Var{
+		{Key: "PLAIN", Value: "v"},
+		{Key: "TOKEN", Value: "vault read -field=token secret/x", Eval: true},
+		{Key: "HIDDEN", Value: "h", NoExport: true},
+	}}
+
+	if environ := e.Environ(); len(environ) != 1 || environ[0] != "PLAIN=v" {
+		t.Errorf("Environ() = %v", environ)
+	}
+	m := e.Map()
+	if _, ok := m["TOKEN"]; ok || m["PLAIN"] != "v" || m["HIDDEN"] != "h" {
+		t.Errorf("Map() = %v", m)
+	}
+}
+
+func TestSetInvalidKey(t *testing.T) {
+	client, root := setupTestClient(t)
+
+	if err := client.Set(root, "1BAD", "v", ""); err == nil {
+		t.Error("Set should reject invalid keys")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	client, root := setupTestClient(t)
+
+	client.Set(root, "KEY", "v", "")
+	if err := client.Delete(root, "KEY"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	e, err := client.Resolve(root)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if _, ok := e.Get("KEY"); ok {
+		t.Error("KEY should be deleted")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	client, root := setupTestClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := client.Watch(ctx, root, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	first := <-ch
+	if len(first.Vars) != 0 {
+		t.Fatalf("initial env should be empty, got %v", first.Vars)
+	}
+
+	client.Set(root, "KEY", "v", "")
+
+	select {
+	case e := <-ch:
+		if v, _ := e.Get("KEY"); v != "v" {
+			t.Errorf("watched KEY = %q, want 'v'", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+
+	cancel()
+	for range ch {
+	}
+}